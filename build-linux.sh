@@ -8,6 +8,9 @@ echo "========================================"
 BUILD_DIR="build"
 APP_NAME="tunnel-flow"
 AGENT_NAME="tunnel-flow-agent"
+VERSION=${VERSION:-1.0.0}
+GIT_COMMIT=$(git rev-parse --short HEAD 2>/dev/null || echo unknown)
+BUILD_DATE=$(date -u +%Y-%m-%dT%H:%M:%SZ)
 
 # 清理之前的构建
 echo "Cleaning previous build..."
@@ -82,7 +85,7 @@ cd tunnel-flow
 export CGO_ENABLED=0
 export GOOS=$GOOS
 export GOARCH=$GOARCH
-go build -ldflags "-s -w" -o ../$BUILD_DIR/$APP_NAME$EXT .
+go build -ldflags "-s -w -X tunnel-flow/internal/version.Version=$VERSION -X tunnel-flow/internal/version.GitCommit=$GIT_COMMIT -X tunnel-flow/internal/version.BuildDate=$BUILD_DATE" -o ../$BUILD_DIR/$APP_NAME$EXT .
 if [ $? -ne 0 ]; then
     echo "Backend server build failed!"
     exit 1
@@ -92,7 +95,7 @@ cd ..
 # 构建代理客户端
 echo "Building agent client..."
 cd tunnel-flow-agent
-go build -ldflags "-s -w" -o ../$BUILD_DIR/$AGENT_NAME$EXT .
+go build -ldflags "-s -w -X tunnel-flow-agent/internal/agent.Version=$VERSION -X tunnel-flow-agent/internal/agent.GitCommit=$GIT_COMMIT -X tunnel-flow-agent/internal/agent.BuildDate=$BUILD_DATE" -o ../$BUILD_DIR/$AGENT_NAME$EXT .
 if [ $? -ne 0 ]; then
     echo "Agent client build failed!"
     exit 1
@@ -7,6 +7,10 @@ echo ========================================
 set BUILD_DIR=build
 set APP_NAME=tunnel-flow
 set AGENT_NAME=tunnel-flow-agent
+if "%VERSION%"=="" set VERSION=1.0.0
+set GIT_COMMIT=unknown
+for /f %%i in ('git rev-parse --short HEAD 2^>nul') do set GIT_COMMIT=%%i
+for /f %%i in ('powershell -NoProfile -Command "(Get-Date).ToUniversalTime().ToString(\"yyyy-MM-ddTHH:mm:ssZ\")"') do set BUILD_DATE=%%i
 
 :: 清理之前的构建
 echo Cleaning previous build...
@@ -47,7 +51,7 @@ cd tunnel-flow
 set CGO_ENABLED=0
 set GOOS=windows
 set GOARCH=amd64
-go build -ldflags "-s -w" -o ..\%BUILD_DIR%\%APP_NAME%.exe .
+go build -ldflags "-s -w -X tunnel-flow/internal/version.Version=%VERSION% -X tunnel-flow/internal/version.GitCommit=%GIT_COMMIT% -X tunnel-flow/internal/version.BuildDate=%BUILD_DATE%" -o ..\%BUILD_DIR%\%APP_NAME%.exe .
 if %errorlevel% neq 0 (
     echo Backend server build failed!
     exit /b 1
@@ -57,7 +61,7 @@ cd ..
 :: 构建代理客户端
 echo Building agent client...
 cd tunnel-flow-agent
-go build -ldflags "-s -w" -o ..\%BUILD_DIR%\%AGENT_NAME%.exe .
+go build -ldflags "-s -w -X tunnel-flow-agent/internal/agent.Version=%VERSION% -X tunnel-flow-agent/internal/agent.GitCommit=%GIT_COMMIT% -X tunnel-flow-agent/internal/agent.BuildDate=%BUILD_DATE%" -o ..\%BUILD_DIR%\%AGENT_NAME%.exe .
 if %errorlevel% neq 0 (
     echo Agent client build failed!
     exit /b 1
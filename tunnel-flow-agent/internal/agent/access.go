@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"log"
+	"time"
+
+	"tunnel-flow-agent/internal/logging"
+)
+
+// accessEntry 一次请求的访问日志，请求处理结束时写入
+type accessEntry struct {
+	start   time.Time
+	msgID   string
+	method  string
+	path    string
+	target  string
+	status  int           // 返回给服务端的状态码，没有发送响应时为0
+	latency time.Duration // 本地目标返回响应头的耗时
+	bytes   int64         // 响应体字节数
+	err     string
+	spooled bool   // 连接断开，响应已暂存到重连后补发
+	cache   string // 可缓存路由的缓存状态：HIT、REVALIDATED 或 MISS（响应已存入缓存）
+}
+
+// newAccessLog 创建访问日志，未开启或无法创建文件时返回nil
+func newAccessLog(path string, maxSizeMB, maxBackups int) *logging.Logger {
+	if path == "" {
+		return nil
+	}
+
+	cfg := logging.DefaultConfig()
+	cfg.Output = "file"
+	cfg.FilePath = path
+	cfg.MaxSize = int64(maxSizeMB)
+	cfg.MaxBackups = maxBackups
+	cfg.EnableCaller = false
+
+	logger, err := logging.NewLogger(cfg)
+	if err != nil {
+		log.Printf("创建访问日志失败，不记录访问日志: %v", err)
+		return nil
+	}
+	return logger.WithComponent("access")
+}
+
+// writeAccess 写入一行访问日志，msg_id 与服务端日志中的请求ID相同
+func (a *Agent) writeAccess(entry *accessEntry) {
+	if a.accessLog == nil {
+		return
+	}
+
+	fields := map[string]interface{}{
+		"msg_id":      entry.msgID,
+		"method":      entry.method,
+		"path":        entry.path,
+		"target":      entry.target,
+		"status":      entry.status,
+		"latency_ms":  entry.latency.Milliseconds(),
+		"duration_ms": time.Since(entry.start).Milliseconds(),
+		"bytes":       entry.bytes,
+	}
+	if entry.err != "" {
+		fields["error"] = entry.err
+	}
+	if entry.spooled {
+		fields["spooled"] = true
+	}
+	if entry.cache != "" {
+		fields["cache"] = entry.cache
+	}
+	a.accessLog.InfoWithFields("request", fields)
+}
@@ -2,13 +2,18 @@ package agent
 
 import (
 	"context"
+	"crypto/cipher"
+	"crypto/ecdh"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
+	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -16,11 +21,53 @@ import (
 
 	"github.com/gorilla/websocket"
 	"tunnel-flow-agent/internal/config"
+	"tunnel-flow-agent/internal/logging"
+	"tunnel-flow-agent/internal/monitoring"
 	"tunnel-flow-agent/internal/protocol"
 	"tunnel-flow-agent/internal/retry"
 	wsconnector "tunnel-flow-agent/internal/websocket"
 )
 
+// 构建信息，可在构建时通过 -ldflags "-X tunnel-flow-agent/internal/agent.Version=x.y.z" 覆盖，
+// GitCommit 和 BuildDate 同理
+var (
+	Version   = "1.0.0"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// BuildInfo 代理的构建信息
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// GetBuildInfo 返回代理的构建信息
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// 组件日志，级别可以通过监控接口单独调整
+var (
+	wsLog        = logging.NewComponent(logging.ComponentWebsocket)
+	httpLog      = logging.NewComponent(logging.ComponentAgentHTTP)
+	heartbeatLog = logging.NewComponent(logging.ComponentHeartbeat)
+)
+
+// statsReportCheckInterval 检查是否到了运行状态上报时间的间隔
+const statsReportCheckInterval = time.Second
+
 // Agent 客户端代理
 type Agent struct {
 	config      *config.Config
@@ -45,6 +92,29 @@ type Agent struct {
 	messagePool   sync.Pool
 	bufferPool    sync.Pool
 	workerPool    chan struct{}
+
+	// 处理中的请求，按消息ID记录取消函数
+	inflightMu sync.Mutex
+	inflight   map[string]context.CancelFunc
+
+	// 停止时等待处理中的请求完成，draining 后不再接受新请求，pending 归零时关闭 drained
+	drainMu  sync.Mutex
+	draining bool
+	pending  int
+	drained  chan struct{}
+
+	// 分片到达的请求体，按消息ID记录
+	uploadsMu sync.Mutex
+	uploads   map[string]*upload
+
+	// 分片发送中的可续传响应体，按传输ID记录
+	transfersMu sync.Mutex
+	transfers   map[string]*transfer
+
+	// 连接断开时没有发出的响应，重连注册成功后补发
+	spoolMu    sync.Mutex
+	spool      []*spooledResponse
+	spoolBytes int
 	
 	// 统计信息
 	stats struct {
@@ -52,6 +122,9 @@ type Agent struct {
 		messagesReceived int64
 		reconnectCount   int64
 		errorCount       int64
+		requests         int64 // 处理的请求数
+		failedRequests   int64 // 转发失败或后端返回5xx的请求数
+		responseBytes    int64 // 返回的响应体字节数
 		startTime        time.Time
 		mu               sync.RWMutex
 	}
@@ -60,6 +133,8 @@ type Agent struct {
 	reconnectCount   int64
 	connMu          sync.RWMutex
 	lastConnectTime time.Time
+	// 收到注册确认时的连接，连接替换后不再视为已注册，由 connMu 保护
+	registeredConn  *websocket.Conn
 	
 	// 网络质量相关字段
 	qualityMu       sync.RWMutex
@@ -69,19 +144,84 @@ type Agent struct {
 	pongsReceived   int64
 	pingsSent       int64
 	networkQuality  float64
+	
+	// 服务端为该客户端设置的心跳间隔和超时，为0时使用本地默认值，由 qualityMu 保护
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	heartbeatChanged  chan struct{}
+	
+	// 运行状态上报
+	systemSampler *monitoring.SystemSampler
+	targetTracker *monitoring.TargetTracker
+
+	// 最近的请求，用于本地状态页
+	recentRequests *monitoring.RequestLog
+
+	// 访问日志，未开启时为nil；多个代理共享时由 Group 关闭
+	accessLog     *logging.Logger
+	ownsAccessLog bool
+
+	// 转发普通请求使用的Transport，连接时应用主机名映射
+	targetTransport *http.Transport
+
+	// 可缓存路由的后端响应，未开启缓存时为nil
+	responseCache *responseCache
+
+	// 服务端注册确认时下发的目标白名单，为空时不限制
+	allowMu        sync.RWMutex
+	allowedTargets []string
+	allowEntries   []allowedTarget
+
+	// 载荷加密，每次注册时重新协商，sessionCipher 为nil时以明文传输
+	cryptoMu      sync.Mutex
+	encryptionKey *ecdh.PrivateKey
+	sessionCipher cipher.AEAD
+
+	// 消息签名，服务端的注册确认带签名后 signing 为true，由 cryptoMu 保护
+	signing bool
+	nonces  *protocol.NonceCache
+
+	// 服务端注册确认时下发的默认载荷压缩设置，为nil时不压缩，由 cryptoMu 保护
+	compression *protocol.CompressionSettings
+
+	// 服务端注册确认时下发的最大消息大小，超过时拆分为分段发送，0表示不拆分，由 cryptoMu 保护
+	maxMessageBytes int
+
+	// 依次尝试连接的服务器，serverIndex 为当前使用的服务器，由 connMu 保护
+	servers     []string
+	serverIndex int
+
+	// 服务端下发的恢复令牌，由 connMu 保护，见 resume.go
+	sessionToken string
 }
 
-// NewAgent 创建新的代理实例
+// NewAgent 创建新的代理实例，配置了多个服务器时按 active-standby 方式在服务器之间切换
 func NewAgent(cfg *config.Config) *Agent {
+	agent := newAgent(cfg, cfg.ServerURLs())
+	agent.accessLog = newAccessLog(cfg.AccessLogPath(), cfg.AccessLog.MaxSizeMB, cfg.AccessLog.MaxBackups)
+	agent.ownsAccessLog = true
+	return agent
+}
+
+// newAgent 创建连接给定服务器的代理实例，不创建访问日志
+func newAgent(cfg *config.Config, servers []string) *Agent {
 	ctx, cancel := context.WithCancel(context.Background())
 	
 	agent := &Agent{
 		config:    cfg,
+		servers:   servers,
 		sendQueue: make(chan []byte, cfg.SendQueueSize()),
 		ctx:       ctx,
 		cancel:    cancel,
 		stopCh:    make(chan struct{}),
+		drained:   make(chan struct{}),
 		workerPool: make(chan struct{}, cfg.WorkerPoolSize()),
+		inflight:   make(map[string]context.CancelFunc),
+		uploads:    make(map[string]*upload),
+		transfers:  make(map[string]*transfer),
+		nonces:     protocol.NewNonceCache(signatureWindow),
+
+		heartbeatChanged: make(chan struct{}, 1),
 	}
 	
 	// 初始化重试策略
@@ -117,6 +257,13 @@ func NewAgent(cfg *config.Config) *Agent {
 	
 	// 初始化统计信息
 	agent.stats.startTime = time.Now()
+	agent.systemSampler = monitoring.NewSystemSampler()
+	agent.targetTracker = monitoring.NewTargetTracker()
+	agent.recentRequests = monitoring.NewRequestLog()
+	agent.targetTransport = agent.newTargetTransport()
+	if cfg.CacheEnabled() {
+		agent.responseCache = newResponseCache(cfg.CacheSize(), cfg.CacheMaxBytes())
+	}
 	
 	return agent
 }
@@ -136,6 +283,9 @@ func (a *Agent) Start() error {
 func (a *Agent) Stop() {
 	log.Println("停止客户端代理...")
 	
+	// 等待处理中的请求完成并发送响应，超时后取消剩余请求
+	a.drain(a.config.DrainTimeout())
+	
 	// 取消context
 	a.cancel()
 	
@@ -147,9 +297,13 @@ func (a *Agent) Stop() {
 		close(a.stopCh)
 	}
 	
-	// 关闭WebSocket连接
+	// 关闭WebSocket连接，先发送关闭帧让服务端知道是代理主动停止
 	a.connMu.Lock()
 	if a.conn != nil {
+		a.writeMu.Lock()
+		a.conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "agent stopping"), time.Now().Add(time.Second))
+		a.writeMu.Unlock()
 		a.conn.Close()
 		a.conn = nil
 	}
@@ -157,12 +311,17 @@ func (a *Agent) Stop() {
 	
 	// 等待所有goroutine退出
 	a.wg.Wait()
+	if a.accessLog != nil && a.ownsAccessLog {
+		a.accessLog.Close()
+	}
 	log.Println("客户端代理已停止")
 }
 
-// IsRunning 检查代理是否正在运行
+// IsRunning 检查代理是否与服务器保持连接
 func (a *Agent) IsRunning() bool {
-	return atomic.LoadInt32(&a.running) == 1
+	a.connMu.RLock()
+	defer a.connMu.RUnlock()
+	return a.conn != nil
 }
 
 // connectionLoop 连接循环
@@ -179,7 +338,7 @@ func (a *Agent) connectionLoop() {
 		// 尝试连接
 		err := a.connectWithRetry()
 		if err != nil {
-			log.Printf("连接失败: %v", err)
+			wsLog.Errorf("连接失败: %v", err)
 			
 			// 等待重连间隔
 			select {
@@ -192,9 +351,46 @@ func (a *Agent) connectionLoop() {
 
 		// 运行连接
 		a.runConnection()
+
+		// 连接断开后优先重连主服务器
+		a.connMu.Lock()
+		if a.serverIndex != 0 {
+			a.serverIndex = 0
+			wsLog.Infof("连接已断开，优先重连主服务器: %s", a.servers[0])
+		}
+		connectedAt := a.lastConnectTime
+		a.connMu.Unlock()
+
+		// 连接很快被断开时（如注册被拒绝，或被同一客户端ID的另一个代理替换）等待重连间隔，避免频繁重连
+		if time.Since(connectedAt) < a.config.ReconnectInterval() {
+			select {
+			case <-a.stopCh:
+				return
+			case <-time.After(a.config.ReconnectInterval()):
+			}
+		}
 	}
 }
 
+// serverURL 当前使用的服务器地址
+func (a *Agent) serverURL() string {
+	a.connMu.RLock()
+	defer a.connMu.RUnlock()
+	return a.servers[a.serverIndex]
+}
+
+// nextServer 连接失败时切换到下一个服务器，只有一个服务器时不切换
+func (a *Agent) nextServer() {
+	if len(a.servers) < 2 {
+		return
+	}
+	a.connMu.Lock()
+	a.serverIndex = (a.serverIndex + 1) % len(a.servers)
+	serverURL := a.servers[a.serverIndex]
+	a.connMu.Unlock()
+	wsLog.Warnf("切换到服务器: %s", serverURL)
+}
+
 // connectWithRetry 带重试的连接
 func (a *Agent) connectWithRetry() error {
 	a.connectionMu.Lock()
@@ -210,45 +406,64 @@ func (a *Agent) connectWithRetry() error {
 
 // connect 连接到服务器
 func (a *Agent) connect() error {
-	log.Printf("尝试连接到服务器...")
-	log.Printf("配置信息 - ServerURL: %s, ClientID: %s, AuthToken: %s",
-		a.config.ServerURL(), a.config.ClientID(), a.config.AuthToken())
+	serverURL := a.serverURL()
+	wsLog.Infof("尝试连接到服务器...")
+	// 认证令牌不写入日志
+	wsLog.Infof("配置信息 - ServerURL: %s, ClientID: %s", serverURL, a.config.ClientID())
 	
-	u, err := url.Parse(a.config.ServerURL())
+	u, err := url.Parse(serverURL)
 	if err != nil {
-		log.Printf("解析服务器URL失败: %v", err)
+		wsLog.Errorf("解析服务器URL失败: %v", err)
 		return fmt.Errorf("解析服务器URL失败: %w", err)
 	}
 
-	// 添加认证参数
+	// 添加认证参数，启用加密时不发送认证Token，改为发送以Token计算的证明，终止 TLS 的中间设备拿不到Token
 	q := u.Query()
 	q.Set("client_id", a.config.ClientID())
-	q.Set("token", a.config.AuthToken())
+	if a.config.EncryptionEnabled() {
+		signedAt, nonce, proof, err := protocol.ConnectProof(a.config.AuthToken(), a.config.ClientID())
+		if err != nil {
+			return fmt.Errorf("生成连接证明失败: %w", err)
+		}
+		q.Set("signed_at", strconv.FormatInt(signedAt, 10))
+		q.Set("nonce", nonce)
+		q.Set("proof", proof)
+	} else {
+		q.Set("token", a.config.AuthToken())
+	}
 	u.RawQuery = q.Encode()
 
-	log.Printf("准备连接到WebSocket URL: %s", u.String())
+	// 查询参数中有认证Token或连接证明，日志中只输出不带查询参数的地址
+	logURL := *u
+	logURL.RawQuery = ""
+	wsLog.Infof("准备连接到WebSocket URL: %s", logURL.String())
 
 	// 创建WebSocket连接
 	dialer := websocket.Dialer{
 		HandshakeTimeout: 10 * time.Second,
 	}
+	if proxyURL := a.config.ProxyURL(); proxyURL != nil {
+		dialer.Proxy = a.outboundProxy
+		wsLog.Infof("通过出站代理连接服务器: %s", proxyURL.Redacted())
+	}
 	
 	// 检查是否为 WSS 连接，配置 TLS
 	if u.Scheme == "wss" {
-		log.Printf("检测到 WSS 协议，配置 TLS 安全连接")
+		wsLog.Infof("检测到 WSS 协议，配置 TLS 安全连接")
 		dialer.TLSClientConfig = &tls.Config{
 			MinVersion:         tls.VersionTLS12,                    // 强制使用 TLS 1.2 或更高版本
 			InsecureSkipVerify: a.config.SSLInsecureSkipVerify(),   // 根据配置决定是否跳过证书验证
 			ServerName:         u.Hostname(),                       // 设置服务器名称用于证书验证
 		}
-		log.Printf("TLS 配置完成，最小版本: TLS 1.2，服务器名称: %s，跳过证书验证: %v", 
+		wsLog.Infof("TLS 配置完成，最小版本: TLS 1.2，服务器名称: %s，跳过证书验证: %v", 
 			u.Hostname(), a.config.SSLInsecureSkipVerify())
 	}
 
 	conn, _, err := dialer.Dial(u.String(), nil)
 	if err != nil {
 		atomic.AddInt64(&a.reconnectCount, 1)
-		log.Printf("WebSocket连接失败: %v", err)
+		wsLog.Errorf("WebSocket连接失败: %s: %v", serverURL, err)
+		a.nextServer()
 		return fmt.Errorf("连接WebSocket失败: %w", err)
 	}
 
@@ -263,12 +478,12 @@ func (a *Agent) connect() error {
 	a.lastPingTime = time.Time{} // 重置ping时间
 	a.qualityMu.Unlock()
 
-	log.Printf("已连接到服务器: %s", a.config.ServerURL())
+	wsLog.Infof("已连接到服务器: %s", serverURL)
 	
 	// 发送注册消息
 	err = a.sendRegisterMessage()
 	if err != nil {
-		log.Printf("发送注册消息失败: %v", err)
+		wsLog.Errorf("发送注册消息失败: %v", err)
 		conn.Close()
 		return fmt.Errorf("发送注册消息失败: %w", err)
 	}
@@ -282,6 +497,15 @@ func (a *Agent) runConnection() {
 	a.wg.Add(1)
 	go a.heartbeatLoop()
 
+	// 启动运行状态上报，连接结束时退出
+	connDone := make(chan struct{})
+	defer close(connDone)
+	a.wg.Add(1)
+	go a.statsReportLoop(connDone)
+
+	// 拼接服务端发来的分段消息
+	fragments := protocol.NewReassembler(protocol.ReassemblyLimit, fragmentTimeout)
+
 	// 处理消息
 	for {
 		select {
@@ -298,23 +522,83 @@ func (a *Agent) runConnection() {
 			return
 		}
 
-		var msg protocol.Message
-		err := conn.ReadJSON(&msg)
+		messageType, data, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("读取消息失败: %v", err)
+			wsLog.Errorf("读取消息失败: %v", err)
+			// 关闭已断开的连接，状态页和发送响应时能立即看到连接不可用
+			a.connMu.Lock()
+			if a.conn == conn {
+				conn.Close()
+				a.conn = nil
+			}
+			a.connMu.Unlock()
 			return
 		}
+		a.stats.mu.Lock()
+		a.stats.messagesReceived++
+		a.stats.mu.Unlock()
+
+		// 分段收齐后作为一条消息处理
+		if messageType == websocket.BinaryMessage {
+			message, err := fragments.Add(data, time.Now())
+			if err != nil {
+				wsLog.Warnf("丢弃消息分段: %v", err)
+				continue
+			}
+			if message == nil {
+				continue
+			}
+			data = message
+		}
 
+		var msg protocol.Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			wsLog.Errorf("解析消息失败: %v", err)
+			continue
+		}
+		// 校验签名并拒绝重放的消息，可能来自被劫持的连接
+		if err := a.verifyMessage(&msg, data); err != nil {
+			wsLog.Warnf("拒绝 %s 消息: %v", msg.Op, err)
+			continue
+		}
+		if err := msg.Open(a.getSessionCipher()); err != nil {
+			wsLog.Warnf("丢弃无法解密的 %s 消息: %v", msg.Op, err)
+			continue
+		}
+		if err := msg.Decompress(); err != nil {
+			wsLog.Warnf("丢弃无法解压的 %s 消息: %v", msg.Op, err)
+			continue
+		}
 		a.handleMessage(&msg)
 	}
 }
 
 // sendMessageWithRetry 带重试的消息发送
 func (a *Agent) sendMessageWithRetry(msg *protocol.Message) error {
+	msg, err := a.compressMessage(msg)
+	if err != nil {
+		return fmt.Errorf("压缩消息失败: %w", err)
+	}
+	if msg, err = a.sealMessage(msg); err != nil {
+		return fmt.Errorf("加密消息失败: %w", err)
+	}
+	if msg, err = a.signMessage(msg); err != nil {
+		return fmt.Errorf("消息签名失败: %w", err)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("序列化消息失败: %w", err)
+	}
+	// 超过服务端下发的最大消息大小时拆分为分段发送
+	frames, err := a.splitMessage(data)
+	if err != nil {
+		return fmt.Errorf("拆分消息失败: %w", err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	return a.retryStrategy.ExecuteWithRetry(ctx, func() error {
+	err = a.retryStrategy.ExecuteWithRetry(ctx, func() error {
 		a.connMu.RLock()
 		conn := a.conn
 		a.connMu.RUnlock()
@@ -323,29 +607,54 @@ func (a *Agent) sendMessageWithRetry(msg *protocol.Message) error {
 			return fmt.Errorf("连接不可用")
 		}
 
+		if frames != nil {
+			return a.writeFrames(conn, frames)
+		}
+
 		// 使用写入锁防止并发写入
 		a.writeMu.Lock()
 		defer a.writeMu.Unlock()
 		
-		return conn.WriteJSON(msg)
+		return conn.WriteMessage(websocket.TextMessage, data)
 	})
+
+	a.stats.mu.Lock()
+	if err != nil {
+		a.stats.errorCount++
+	} else {
+		a.stats.messagesSent++
+	}
+	a.stats.mu.Unlock()
+	return err
 }
 
 // handleMessage 处理消息
 func (a *Agent) handleMessage(msg *protocol.Message) {
 	switch msg.Op {
+	case protocol.OpRegisterAck:
+		a.handleRegisterAck(msg)
 	case protocol.OpPing:
 		a.handlePing(msg)
 	case protocol.OpPong:
 		a.handlePong(msg)
-	case protocol.OpRouteSync:
-		a.handleRouteSync(msg)
+	case protocol.OpRouteSyncAck:
+		a.handleRouteSyncAck(msg)
 	case protocol.OpRequest:
-		a.handleRequest(msg)
+		a.dispatchRequest(msg)
+	case protocol.OpRequestChunk:
+		a.handleRequestChunk(msg)
+	case protocol.OpCancel:
+		a.handleCancel(msg)
+	case protocol.OpTransferAck:
+		a.handleTransferAck(msg)
+	case protocol.OpTargetAllowlist:
+		a.handleTargetAllowlist(msg)
+	case protocol.OpHeartbeatConfig:
+		a.handleHeartbeatConfig(msg)
 	case protocol.OpError:
 		a.handleError(msg)
 	default:
-		log.Printf("未知操作类型: %s", msg.Op)
+		wsLog.Warnf("未知操作类型: %s", msg.Op)
 	}
 }
 
@@ -362,7 +671,7 @@ func (a *Agent) handlePing(msg *protocol.Message) {
 	}
 
 	if err := a.sendMessageWithRetry(pongMsg); err != nil {
-		log.Printf("发送Pong失败: %v", err)
+		heartbeatLog.Errorf("发送Pong失败: %v", err)
 	}
 }
 
@@ -382,9 +691,9 @@ func (a *Agent) handlePong(msg *protocol.Message) {
 		// 更新网络质量
 		a.updateNetworkQuality()
 		
-		log.Printf("收到pong响应，RTT: %v, 网络质量: %.2f", a.rtt, a.networkQuality)
+		heartbeatLog.Debugf("收到pong响应，RTT: %v, 网络质量: %.2f", a.rtt, a.networkQuality)
 	} else {
-		log.Printf("收到pong响应")
+		heartbeatLog.Debugf("收到pong响应")
 	}
 }
 
@@ -411,114 +720,258 @@ func (a *Agent) updateNetworkQuality() {
 	a.networkQuality = a.networkQuality*0.7 + quality*0.3
 }
 
-// handleRouteSync 处理路由同步
-func (a *Agent) handleRouteSync(msg *protocol.Message) {
-	log.Printf("收到路由同步: %+v", msg.Payload)
-}
-
 // handleRequest 处理HTTP请求
 func (a *Agent) handleRequest(msg *protocol.Message) {
+	ctx, done := a.trackRequest(msg)
+	defer done()
+	if msg.MsgID != nil {
+		defer a.closeUpload(*msg.MsgID)
+	}
+
+	// 请求结束时写访问日志，fail 发送错误响应并记入访问日志
+	access := &accessEntry{start: time.Now()}
+	if msg.MsgID != nil {
+		access.msgID = *msg.MsgID
+	}
+	defer a.writeAccess(access)
+	defer a.countRequest(access)
+	timeout := a.config.HTTPTimeout()
+	fail := func(errorMsg string) {
+		access.status = http.StatusInternalServerError
+		access.err = errorMsg
+		access.spooled = a.sendErrorResponse(msg, errorMsg, timeout)
+	}
+
 	// 解析请求数据为RequestPayload结构
 	var reqPayload protocol.RequestPayload
 	
 	// 首先尝试直接解析Payload
 	if err := msg.ParsePayload(&reqPayload); err != nil {
-		log.Printf("解析RequestPayload失败: %v", err)
-		a.sendErrorResponse(msg, "解析请求数据失败")
+		httpLog.Errorf("解析RequestPayload失败: %v", err)
+		fail("解析请求数据失败")
 		return
 	}
 
-	log.Printf("收到请求: Method=%s, URLSuffix=%s", reqPayload.HTTPMethod, reqPayload.URLSuffix)
+	httpLog.Infof("收到请求: Method=%s, URLSuffix=%s", reqPayload.HTTPMethod, reqPayload.URLSuffix)
+	access.method = reqPayload.HTTPMethod
+	access.path = reqPayload.URLSuffix
+
+	if reqPayload.Echo {
+		a.sendEcho(msg, &reqPayload, timeout, access)
+		return
+	}
 
 	// 解析目标地址
 	targets, err := reqPayload.GetTargets()
 	if err != nil {
-		log.Printf("解析目标地址失败: %v", err)
-		a.sendErrorResponse(msg, "解析目标地址失败")
+		httpLog.Errorf("解析目标地址失败: %v", err)
+		fail("解析目标地址失败")
 		return
 	}
 
 	if len(targets) == 0 {
-		log.Printf("没有可用的目标地址")
-		a.sendErrorResponse(msg, "没有可用的目标地址")
+		httpLog.Warnf("没有可用的目标地址")
+		fail("没有可用的目标地址")
 		return
 	}
 
 	// 直接使用目标地址，不拼接URL后缀
 		targetURL := targets[0].URL
-		log.Printf("路由转发：直接转发到目标地址: %s (模式: %s)", targetURL, reqPayload.RouteMode)
+		access.target = targetURL
+		httpLog.Infof("路由转发：直接转发到目标地址: %s (模式: %s)", targetURL, reqPayload.RouteMode)
+
+	// 创建HTTP客户端，路由设置的整个请求超时优先
+	if reqPayload.TimeoutMS > 0 {
+		timeout = time.Duration(reqPayload.TimeoutMS) * time.Millisecond
+	} else if reqPayload.Timeout > 0 {
+		timeout = time.Duration(reqPayload.Timeout) * time.Millisecond
+	}
+
+	// 目标必须在服务端下发的白名单内，防止被篡改的路由把代理当作开放代理使用
+	if !a.targetAllowed(targetURL) {
+		httpLog.Warnf("目标不在服务端下发的白名单内，拒绝转发: %s", targetURL)
+		a.sendForbidden(msg, "目标不在客户端的白名单内", timeout, access)
+		a.reportViolation(reqPayload.HTTPMethod, targetURL, reqPayload.URLSuffix)
+		return
+	}
 
-	// 创建HTTP客户端
-	timeout := time.Duration(reqPayload.Timeout) * time.Millisecond
-	if timeout == 0 {
-		timeout = a.config.HTTPTimeout()
+	// unix套接字目标转换为HTTP请求URL，拒绝规则按其中的请求路径判断
+	requestURL := targetURL
+	if isUnixTarget(targetURL) {
+		if requestURL, err = unixRequestURL(targetURL); err != nil {
+			httpLog.Errorf("解析unix套接字目标失败: %v", err)
+			fail("解析目标地址失败")
+			return
+		}
+	}
+
+	// 转发前按拒绝规则检查；可缓存路由的响应在有效期内直接从缓存返回，过期后带上验证条件请求后端
+	reqHeader := payloadHeader(reqPayload.Headers)
+	if rule, denied := a.config.DenyRequest(reqPayload.HTTPMethod, requestURL, reqHeader); denied {
+		httpLog.Warnf("请求被拒绝规则拦截: %s %s (%s)", reqPayload.HTTPMethod, targetURL, rule)
+		a.sendForbidden(msg, "请求被客户端的拒绝规则拦截", timeout, access)
+		return
+	}
+	a.invalidateCache(&reqPayload, targetURL)
+	var cached *cachedResponse
+	cacheKey := ""
+	if usable, revalidate := a.cacheLookup(&reqPayload, reqHeader); usable {
+		cacheKey = responseCacheKey(reqPayload.HTTPMethod, targetURL)
+		cached = a.responseCache.get(cacheKey, reqHeader)
+		if cached != nil && !revalidate && cached.fresh(time.Now()) {
+			httpLog.Infof("命中响应缓存: %s", targetURL)
+			a.sendCachedResponse(msg, cached, cacheHit, 0, timeout, access)
+			return
+		}
 	}
 	
 	// 检测目标地址是否为HTTPS协议
 	isHTTPS := strings.HasPrefix(strings.ToLower(targets[0].URL), "https://")
+	streaming := reqPayload.BodyStreamed || reqPayload.StreamResponse
 	
 	client := &http.Client{
-		Timeout: timeout,
+		Timeout:   timeout,
+		Transport: a.targetTransport,
 	}
 	
-	// 如果是HTTPS协议，配置忽略证书校验
-	if isHTTPS {
-		transport := &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true,
-			},
+	// HTTPS、流式传输和路由设置了连接或响应头超时时使用单独的Transport
+	if isHTTPS || streaming || reqPayload.ConnectTimeoutMS > 0 || reqPayload.HeaderTimeoutMS > 0 {
+		transport := &http.Transport{}
+		if isHTTPS {
+			// 如果是HTTPS协议，配置忽略证书校验
+			transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+			httpLog.Infof("目标地址使用HTTPS协议，已配置忽略证书校验: %s", targets[0].URL)
+		}
+		dialer := &net.Dialer{}
+		if reqPayload.ConnectTimeoutMS > 0 {
+			dialer.Timeout = time.Duration(reqPayload.ConnectTimeoutMS) * time.Millisecond
+		}
+		transport.DialContext = a.targetDialContext(dialer)
+		if a.config.ProxyTargets() {
+			transport.Proxy = a.outboundProxy
+		}
+		if reqPayload.HeaderTimeoutMS > 0 {
+			transport.ResponseHeaderTimeout = time.Duration(reqPayload.HeaderTimeoutMS) * time.Millisecond
+		} else if streaming {
+			transport.ResponseHeaderTimeout = timeout
 		}
 		client.Transport = transport
-		log.Printf("目标地址使用HTTPS协议，已配置忽略证书校验: %s", targets[0].URL)
+		defer transport.CloseIdleConnections()
+	}
+
+	// 分片到达的请求体和分片发送的响应体不计入整个请求超时，等待响应头的时间仍受限制
+	if streaming {
+		client.Timeout = 0
 	}
 
 	// 构建请求体
 	var reqBody io.Reader
-	if reqPayload.Body != "" {
+	if reqPayload.BodyStreamed {
+		u := a.lookupUpload(*msg.MsgID)
+		if u == nil {
+			fail("请求体分片已失效")
+			return
+		}
+		reqBody = u.body(ctx)
+	} else if reqPayload.Body != "" {
 		reqBody = strings.NewReader(reqPayload.Body)
 	}
 
 	// 构建HTTP请求
-	req, err := http.NewRequest(reqPayload.HTTPMethod, targetURL, reqBody)
+	req, err := http.NewRequestWithContext(ctx, reqPayload.HTTPMethod, requestURL, reqBody)
 	if err != nil {
-		log.Printf("创建HTTP请求失败: %v", err)
-		a.sendErrorResponse(msg, "创建HTTP请求失败")
+		httpLog.Errorf("创建HTTP请求失败: %v", err)
+		fail("创建HTTP请求失败")
 		return
 	}
+	if requestURL != targetURL {
+		// unix套接字上的服务看到的Host头
+		req.Host = "localhost"
+	}
 
 	// 设置请求头
 	for name, value := range reqPayload.Headers {
 		req.Header.Set(name, value)
 	}
+	if reqPayload.BodyStreamed {
+		if length, err := strconv.ParseInt(reqPayload.Headers["Content-Length"], 10, 64); err == nil {
+			req.ContentLength = length
+		}
+	}
+	if cached != nil {
+		cached.addValidators(req.Header)
+	}
 
-	log.Printf("发送HTTP请求到: %s", targetURL)
+	httpLog.Infof("发送HTTP请求到: %s", targetURL)
 
 	// 发送请求
 	startTime := time.Now()
 	resp, err := client.Do(req)
 	latency := time.Since(startTime)
+	access.latency = latency
 	
+	if err != nil && ctx.Err() != nil && a.ctx.Err() == nil {
+		// 服务端已取消该请求，不再发送响应
+		httpLog.Warnf("请求已被服务端取消: %s", targetURL)
+		access.err = "请求已被服务端取消"
+		return
+	}
 	if err != nil {
-		log.Printf("发送HTTP请求失败: %v", err)
-		a.sendErrorResponse(msg, fmt.Sprintf("HTTP请求失败: %v", err))
+		a.targetTracker.Record(targetURL, 0, err, latency)
+		a.recentRequests.Record(reqPayload.HTTPMethod, reqPayload.URLSuffix, targetURL, 0, err, latency)
+		httpLog.Errorf("发送HTTP请求失败: %v", err)
+		fail(fmt.Sprintf("HTTP请求失败: %v", err))
 		return
 	}
 	defer resp.Body.Close()
-
-	// 读取响应体
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		log.Printf("读取响应体失败: %v", err)
-		a.sendErrorResponse(msg, "读取响应体失败")
+	a.targetTracker.Record(targetURL, resp.StatusCode, nil, latency)
+	a.recentRequests.Record(reqPayload.HTTPMethod, reqPayload.URLSuffix, targetURL, resp.StatusCode, nil, latency)
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		httpLog.Infof("后端确认缓存的响应仍然有效: %s", targetURL)
+		a.sendCachedResponse(msg, a.revalidateResponse(cached, reqHeader, resp), cacheRevalidated, latency, timeout, access)
 		return
 	}
 
-	// 构建响应头
+	// 后端超过空闲超时没有发送响应体数据时中断读取
+	if reqPayload.BodyIdleTimeoutMS > 0 {
+		body := newIdleTimeoutBody(resp.Body, time.Duration(reqPayload.BodyIdleTimeoutMS)*time.Millisecond)
+		defer body.Stop()
+		resp.Body = body
+	}
+
+	// 构建响应头，后端声明的trailer在响应体读完后单独发送
 	respHeaders := make(map[string]string)
+	respHeaderValues := make(map[string][]string, len(resp.Header))
 	for name, values := range resp.Header {
 		if len(values) > 0 {
 			respHeaders[name] = values[0]
+			respHeaderValues[name] = values
+		}
+	}
+	if len(resp.Trailer) > 0 {
+		names := make([]string, 0, len(resp.Trailer))
+		for name := range resp.Trailer {
+			names = append(names, name)
 		}
+		respHeaders["Trailer"] = strings.Join(names, ", ")
+		respHeaderValues["Trailer"] = []string{respHeaders["Trailer"]}
+	}
+
+	// 读取响应体，服务端允许时超过内联大小的响应体改为分片发送；
+	// 长度未知的响应（如 Transfer-Encoding: chunked）不等待读完，收到多少转发多少
+	var respBody []byte
+	streamAsIs := reqPayload.StreamResponse && resp.ContentLength < 0
+	if streamAsIs {
+		respBody = []byte{}
+	} else if reqPayload.StreamResponse {
+		respBody, err = io.ReadAll(io.LimitReader(resp.Body, transferInlineLimit+1))
+	} else {
+		respBody, err = io.ReadAll(resp.Body)
+	}
+	if err != nil {
+		httpLog.Errorf("读取响应体失败: %v", err)
+		fail("读取响应体失败")
+		return
 	}
 
 	// 构建响应载荷
@@ -527,6 +980,23 @@ func (a *Agent) handleRequest(msg *protocol.Message) {
 		Headers:    respHeaders,
 		Body:       string(respBody),
 		LatencyMS:  latency.Milliseconds(),
+		Trailers:   trailerValues(resp.Trailer),
+
+		HeaderValues: respHeaderValues,
+	}
+
+	var t *transfer
+	if streamAsIs || len(respBody) > transferInlineLimit {
+		// 先登记再发送响应，保证服务端的确认到达时能找到传输
+		t = a.openTransfer(protocol.GenerateMessageID(), respBody)
+		t.compression = reqPayload.Compression
+		responsePayload.Body = ""
+		responsePayload.TransferID = t.id
+		responsePayload.Trailers = nil
+	} else if cacheKey != "" && a.storeResponse(cacheKey, reqHeader, resp, respBody) {
+		access.cache = cacheMiss
+		respHeaders["X-Cache"] = cacheMiss
+		respHeaderValues["X-Cache"] = []string{cacheMiss}
 	}
 
 	// 发送响应
@@ -538,16 +1008,163 @@ func (a *Agent) handleRequest(msg *protocol.Message) {
 		Timestamp: time.Now().UnixMilli(),
 		Payload:   responsePayload,
 	}
+	responseMsg.UseCompression(reqPayload.Compression)
+
+	access.status = resp.StatusCode
+	access.bytes = int64(len(respBody))
+	// 连接断开时暂存响应，分片发送的响应体保留到服务端收到补发的响应后开始接收
+	spooled, err := a.sendResponse(responseMsg, timeout)
+	access.spooled = spooled
+	if err != nil {
+		httpLog.Errorf("发送响应失败: %v", err)
+		access.err = fmt.Sprintf("发送响应失败: %v", err)
+		if t != nil {
+			a.closeTransfer(t)
+		}
+		return
+	}
+	if t != nil {
+		err := a.runTransfer(ctx, t, resp.Body, resp.Trailer)
+		access.bytes = t.acked
+		if err != nil {
+			httpLog.Errorf("分片发送响应体失败: %s: %v", t.id, err)
+			access.err = fmt.Sprintf("分片发送响应体失败: %v", err)
+			return
+		}
+		httpLog.Infof("响应体分片发送完成: %s, %d字节", t.id, t.acked)
+	}
+	httpLog.Infof("成功处理请求，状态码: %d, 延迟: %dms", resp.StatusCode, latency.Milliseconds())
+}
+
+// dispatchRequest 在工作池中处理请求，避免慢请求阻塞消息读取
+func (a *Agent) dispatchRequest(msg *protocol.Message) {
+	if !a.beginRequest() {
+		a.rejectRequest(msg)
+		return
+	}
+	if payload, ok := msg.Payload.(map[string]interface{}); ok && payload["body_streamed"] == true && msg.MsgID != nil {
+		// 请求体随后分片到达：读取下一条消息前先登记；不占用工作池，保证到达的分片总有请求在消费
+		a.openUpload(*msg.MsgID)
+		go func() {
+			defer a.endRequest()
+			a.handleRequest(msg)
+		}()
+		return
+	}
+	select {
+	case <-a.workerPool:
+	case <-a.ctx.Done():
+		a.endRequest()
+		return
+	}
+	go func() {
+		defer a.endRequest()
+		defer func() { a.workerPool <- struct{}{} }()
+		a.handleRequest(msg)
+	}()
+}
 
+// beginRequest 登记新请求，正在停止时返回false
+func (a *Agent) beginRequest() bool {
+	a.drainMu.Lock()
+	defer a.drainMu.Unlock()
+	if a.draining {
+		return false
+	}
+	a.pending++
+	return true
+}
+
+// endRequest 请求处理结束
+func (a *Agent) endRequest() {
+	a.drainMu.Lock()
+	defer a.drainMu.Unlock()
+	a.pending--
+	if a.draining && a.pending == 0 {
+		close(a.drained)
+	}
+}
+
+// drain 停止接受新请求并等待处理中的请求完成，最多等待 timeout
+// 等待期间连接保持打开，响应和响应体分片可以继续发送，服务端的确认也能正常接收
+func (a *Agent) drain(timeout time.Duration) {
+	a.drainMu.Lock()
+	if a.draining {
+		a.drainMu.Unlock()
+		return
+	}
+	a.draining = true
+	pending := a.pending
+	a.drainMu.Unlock()
+	if pending == 0 {
+		return
+	}
+
+	httpLog.Infof("等待 %d 个处理中的请求完成，最多等待 %v", pending, timeout)
+	select {
+	case <-a.drained:
+		httpLog.Infof("处理中的请求已全部完成")
+	case <-time.After(timeout):
+		httpLog.Warnf("等待处理中的请求超时，取消剩余请求")
+	}
+}
+
+// rejectRequest 停止过程中拒绝新请求，返回503
+func (a *Agent) rejectRequest(msg *protocol.Message) {
+	errorMsg := "客户端正在停止"
+	responseMsg := &protocol.Message{
+		MsgID:     msg.MsgID,
+		Type:      protocol.MessageTypeBusiness,
+		Op:        protocol.OpResponse,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload: &protocol.ResponsePayload{
+			HTTPStatus: http.StatusServiceUnavailable,
+			Headers:    map[string]string{"Retry-After": "5"},
+			Error:      &errorMsg,
+		},
+	}
 	if err := a.sendMessageWithRetry(responseMsg); err != nil {
-		log.Printf("发送响应失败: %v", err)
-	} else {
-		log.Printf("成功处理请求，状态码: %d, 延迟: %dms", resp.StatusCode, latency.Milliseconds())
+		httpLog.Errorf("发送拒绝响应失败: %v", err)
+	}
+}
+
+// trackRequest 登记处理中的请求，返回的 done 在请求结束时调用
+func (a *Agent) trackRequest(msg *protocol.Message) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(a.ctx)
+	if msg.MsgID == nil {
+		return ctx, cancel
+	}
+
+	msgID := *msg.MsgID
+	a.inflightMu.Lock()
+	a.inflight[msgID] = cancel
+	a.inflightMu.Unlock()
+	return ctx, func() {
+		a.inflightMu.Lock()
+		delete(a.inflight, msgID)
+		a.inflightMu.Unlock()
+		cancel()
 	}
 }
 
-// sendErrorResponse 发送错误响应
-func (a *Agent) sendErrorResponse(msg *protocol.Message, errorMsg string) {
+// handleCancel 取消服务端不再等待的请求
+func (a *Agent) handleCancel(msg *protocol.Message) {
+	if msg.MsgID == nil {
+		return
+	}
+
+	a.inflightMu.Lock()
+	cancel, exists := a.inflight[*msg.MsgID]
+	a.inflightMu.Unlock()
+	if exists {
+		httpLog.Warnf("取消请求: %s", *msg.MsgID)
+		cancel()
+	}
+}
+
+// sendErrorResponse 发送错误响应，连接断开时暂存到重连后补发，返回响应是否已暂存
+func (a *Agent) sendErrorResponse(msg *protocol.Message, errorMsg string, ttl time.Duration) bool {
 	errorPayload := &protocol.ResponsePayload{
 		HTTPStatus: 500,
 		Headers:    make(map[string]string),
@@ -565,21 +1182,57 @@ func (a *Agent) sendErrorResponse(msg *protocol.Message, errorMsg string) {
 		Payload:   errorPayload,
 	}
 
-	if err := a.sendMessageWithRetry(responseMsg); err != nil {
-		log.Printf("发送错误响应失败: %v", err)
+	spooled, err := a.sendResponse(responseMsg, ttl)
+	if err != nil {
+		httpLog.Errorf("发送错误响应失败: %v", err)
 	}
+	return spooled
+}
+
+// handleRegisterAck 注册成功后补发连接断开期间暂存的响应，注册被拒绝时服务端会断开连接
+func (a *Agent) handleRegisterAck(msg *protocol.Message) {
+	var payload protocol.RegisterAckPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		wsLog.Errorf("解析注册确认失败: %v", err)
+	} else if !payload.Success {
+		wsLog.Errorf("注册被服务端拒绝: %s", payload.Message)
+		return
+	}
+	// 启用加密时只有带有效签名的注册确认中的公钥可信，没有签名时可能是中间设备替换了公钥
+	if a.config.EncryptionEnabled() && !a.signingEnabled() {
+		wsLog.Errorf("注册确认没有有效签名，无法确认服务端的公钥，断开连接")
+		a.connMu.Lock()
+		if a.conn != nil {
+			a.conn.Close()
+			a.conn = nil
+		}
+		a.connMu.Unlock()
+		return
+	}
+	wsLog.Infof("注册成功: %s, 服务器: %s", a.config.ClientID(), a.serverURL())
+	a.markRegistered()
+	// 每次注册都以服务端下发的白名单为准，旧版本服务端不下发时不限制
+	a.setAllowedTargets(payload.AllowedTargets)
+	// 在读取下一条消息之前启用加密，之后服务端发来的消息可能已经加密
+	a.setupEncryption(payload.EncryptionKey)
+	a.setupCompression(payload.Compression)
+	a.setupFragmentation(payload.MaxMessageBytes)
+	a.setupHeartbeat(payload.Heartbeat)
+	a.setupResume(payload.ResumeToken, payload.Resumed)
+	go a.flushSpool()
+	go a.syncRoutes()
 }
 
 // handleError 处理错误消息
 func (a *Agent) handleError(msg *protocol.Message) {
-	log.Printf("收到错误消息: %s", msg.Payload)
+	wsLog.Errorf("收到错误消息: %s", msg.Payload)
 }
 
 // heartbeatLoop 心跳循环
 func (a *Agent) heartbeatLoop() {
 	defer a.wg.Done()
 
-	ticker := time.NewTicker(a.config.PingInterval())
+	ticker := time.NewTicker(a.pingInterval())
 	defer ticker.Stop()
 	
 	// 记录连续失败的ping次数
@@ -590,6 +1243,8 @@ func (a *Agent) heartbeatLoop() {
 		select {
 		case <-a.stopCh:
 			return
+		case <-a.heartbeatChanged:
+			ticker.Reset(a.pingInterval())
 		case <-ticker.C:
 			// 检查连接超时
 			a.qualityMu.RLock()
@@ -598,21 +1253,21 @@ func (a *Agent) heartbeatLoop() {
 			a.qualityMu.RUnlock()
 
 			// 根据网络质量调整心跳间隔
-			interval := a.config.PingInterval()
+			interval := a.pingInterval()
 			if quality < 0.5 && quality > 0 {
 				interval = interval * 2 / 3 // 网络质量差时适当增加心跳频率
 			}
 			ticker.Reset(interval)
 
-			// 使用配置的心跳超时时间进行检查
-			pingTimeout := a.config.PingTimeout()
+			// 使用服务端下发或配置的心跳超时时间进行检查
+			pingTimeout := a.pingTimeout()
 			if !lastPong.IsZero() && time.Since(lastPong) > pingTimeout {
-				log.Printf("心跳超时，上次pong时间: %v, 超时阈值: %v", lastPong, pingTimeout)
+				heartbeatLog.Warnf("心跳超时，上次pong时间: %v, 超时阈值: %v", lastPong, pingTimeout)
 				
 				// 主动关闭WebSocket连接，触发重连
 				a.connMu.Lock()
 				if a.conn != nil {
-					log.Printf("主动关闭WebSocket连接以触发重连")
+					heartbeatLog.Infof("主动关闭WebSocket连接以触发重连")
 					a.conn.Close()
 					a.conn = nil
 				}
@@ -624,16 +1279,16 @@ func (a *Agent) heartbeatLoop() {
 			// 发送ping
 			if err := a.sendPing(); err != nil {
 				consecutiveFailures++
-				log.Printf("发送ping失败 (%d/%d): %v", consecutiveFailures, maxFailures, err)
+				heartbeatLog.Errorf("发送ping失败 (%d/%d): %v", consecutiveFailures, maxFailures, err)
 				
 				// 连续失败达到阈值时才断开连接
 				if consecutiveFailures >= maxFailures {
-					log.Printf("连续ping失败%d次，主动断开连接", maxFailures)
+					heartbeatLog.Errorf("连续ping失败%d次，主动断开连接", maxFailures)
 					
 					// 主动关闭WebSocket连接，触发重连
 					a.connMu.Lock()
 					if a.conn != nil {
-						log.Printf("主动关闭WebSocket连接以触发重连")
+						heartbeatLog.Infof("主动关闭WebSocket连接以触发重连")
 						a.conn.Close()
 						a.conn = nil
 					}
@@ -664,14 +1319,81 @@ func (a *Agent) sendPing() error {
 		Payload:   &protocol.PingPayload{Timestamp: time.Now().UnixMilli()},
 	}
 
-	log.Printf("发送ping消息 #%d", pingCount)
+	heartbeatLog.Debugf("发送ping消息 #%d", pingCount)
 	err := a.sendMessageWithRetry(pingMsg)
 	if err != nil {
-		log.Printf("ping消息发送失败: %v", err)
+		heartbeatLog.Errorf("ping消息发送失败: %v", err)
 	}
 	return err
 }
 
+// statsReportLoop 定期向服务端上报运行状态和计数器
+func (a *Agent) statsReportLoop(connDone <-chan struct{}) {
+	defer a.wg.Done()
+
+	// 上报间隔可以在运行时修改，每秒检查是否到了上报时间，修改后立即生效
+	ticker := time.NewTicker(statsReportCheckInterval)
+	defer ticker.Stop()
+
+	lastReport := time.Now()
+	lastMetrics := a.metricsSample()
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case <-connDone:
+			return
+		case <-ticker.C:
+			interval := a.config.StatsReportInterval()
+			if interval > 0 && time.Since(lastReport) >= interval {
+				lastReport = time.Now()
+				if err := a.sendStatsReport(); err != nil {
+					wsLog.Errorf("上报运行状态失败: %v", err)
+				}
+			}
+
+			interval = a.config.MetricsInterval()
+			if interval > 0 && time.Since(time.UnixMilli(lastMetrics.Timestamp)) >= interval {
+				sample, err := a.sendMetrics(lastMetrics)
+				if err != nil {
+					wsLog.Errorf("上报计数器失败: %v", err)
+				}
+				lastMetrics = sample
+			}
+		}
+	}
+}
+
+// sendStatsReport 采集并发送一次运行状态
+func (a *Agent) sendStatsReport() error {
+	system := a.systemSampler.Sample()
+
+	a.stats.mu.RLock()
+	startTime := a.stats.startTime
+	a.stats.mu.RUnlock()
+
+	payload := &protocol.StatsReportPayload{
+		Timestamp:          time.Now().UnixMilli(),
+		UptimeSeconds:      int64(time.Since(startTime).Seconds()),
+		CPUPercent:         system.CPUPercent,
+		CPUCores:           system.CPUCores,
+		MemoryTotalBytes:   system.MemoryTotalBytes,
+		MemoryUsedBytes:    system.MemoryUsedBytes,
+		ProcessMemoryBytes: system.ProcessMemoryBytes,
+		Goroutines:         system.Goroutines,
+		Targets:            a.targetTracker.Snapshot(),
+	}
+
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeControl,
+		Op:        protocol.OpStatsReport,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   payload,
+	}
+	return a.sendMessageWithRetry(msg)
+}
+
 // GetStats 获取统计信息
 func (a *Agent) GetStats() map[string]interface{} {
 	a.stats.mu.RLock()
@@ -683,23 +1405,26 @@ func (a *Agent) GetStats() map[string]interface{} {
 		"messages_received": a.stats.messagesReceived,
 		"reconnect_count":   a.stats.reconnectCount,
 		"error_count":       a.stats.errorCount,
+		"requests":          a.stats.requests,
+		"failed_requests":   a.stats.failedRequests,
+		"response_bytes":    a.stats.responseBytes,
 		"start_time":        a.stats.startTime.Format(time.RFC3339),
 	}
 }
 
 // 事件处理方法
 func (a *Agent) onConnected() {
-	log.Println("WebSocket连接已建立")
+	wsLog.Infof("WebSocket连接已建立")
 	atomic.StoreInt32(&a.running, 1)
 }
 
 func (a *Agent) onDisconnected(err error) {
-	log.Printf("WebSocket连接已断开: %v", err)
+	wsLog.Warnf("WebSocket连接已断开: %v", err)
 	atomic.StoreInt32(&a.running, 0)
 }
 
 func (a *Agent) onError(err error) {
-	log.Printf("WebSocket连接错误: %v", err)
+	wsLog.Errorf("WebSocket连接错误: %v", err)
 	a.stats.mu.Lock()
 	a.stats.errorCount++
 	a.stats.mu.Unlock()
@@ -711,7 +1436,7 @@ func (a *Agent) HandleMessage(msg *wsconnector.Message) error {
 	a.stats.messagesReceived++
 	a.stats.mu.Unlock()
 	
-	log.Printf("收到消息: %s", msg.Type)
+	wsLog.Infof("收到消息: %s", msg.Type)
 	return nil
 }
 
@@ -721,7 +1446,7 @@ func (a *Agent) getLocalIPs() []string {
 	
 	interfaces, err := net.Interfaces()
 	if err != nil {
-		log.Printf("获取网络接口失败: %v", err)
+		wsLog.Errorf("获取网络接口失败: %v", err)
 		return ips
 	}
 	
@@ -733,7 +1458,7 @@ func (a *Agent) getLocalIPs() []string {
 		
 		addrs, err := iface.Addrs()
 		if err != nil {
-			log.Printf("获取接口 %s 地址失败: %v", iface.Name, err)
+			wsLog.Errorf("获取接口 %s 地址失败: %v", iface.Name, err)
 			continue
 		}
 		
@@ -753,7 +1478,7 @@ func (a *Agent) getLocalIPs() []string {
 		}
 	}
 	
-	log.Printf("获取到本地IP地址: %v", ips)
+	wsLog.Infof("获取到本地IP地址: %v", ips)
 	return ips
 }
 
@@ -762,12 +1487,27 @@ func (a *Agent) sendRegisterMessage() error {
 	// 获取本地IP地址
 	localIPs := a.getLocalIPs()
 	
-	// 创建注册载荷
+	// 创建注册载荷，启用加密时不带认证Token，由注册消息的签名证明
+	authToken := a.config.AuthToken()
+	if a.config.EncryptionEnabled() {
+		authToken = ""
+	}
 	payload := protocol.RegisterPayload{
 		ClientID:  a.config.ClientID(),
-		AuthToken: a.config.AuthToken(),
-		Version:   "1.0.0", // 可以从配置或常量获取
+		AuthToken: authToken,
+		Version:   Version,
 		LocalIPs:  localIPs,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Capabilities: protocol.Capabilities(),
+		GitCommit:    GitCommit,
+		BuildDate:    BuildDate,
+
+		EncryptionKey: a.prepareEncryption(),
+
+		MaxMessageBytes: a.config.WebSocketMaxMessageBytes(),
+
+		ResumeToken: a.resumeToken(),
 	}
 	
 	// 创建注册消息
@@ -790,14 +1530,22 @@ func (a *Agent) sendRegisterMessage() error {
 		return fmt.Errorf("连接不可用")
 	}
 	
-	log.Printf("发送注册消息: ClientID=%s, LocalIPs=%v",
+	wsLog.Infof("发送注册消息: ClientID=%s, LocalIPs=%v",
 		payload.ClientID, payload.LocalIPs)
 	
-	err := conn.WriteJSON(msg)
+	a.resetSigning()
+	a.resetCompression()
+	a.resetFragmentation()
+	a.resetHeartbeat()
+	signed, err := a.signMessage(&msg)
+	if err != nil {
+		return fmt.Errorf("注册消息签名失败: %w", err)
+	}
+	err = conn.WriteJSON(signed)
 	if err != nil {
 		return fmt.Errorf("发送注册消息失败: %w", err)
 	}
 	
-	log.Printf("注册消息发送成功")
+	wsLog.Infof("注册消息发送成功")
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// allowedTarget 目标白名单中的一项，格式为 主机[:端口] 或 unix:套接字路径
+type allowedTarget struct {
+	unixPath string     // unix套接字路径，只匹配该套接字
+	host     string     // 主机名或IP，为空时见 suffix 和 network
+	suffix   string     // *.example.com 形式的域名后缀，只匹配子域名
+	network  *net.IPNet // CIDR
+	any      bool       // * 匹配任意主机
+	port     string     // 为空时不限制端口
+}
+
+// parseAllowedTarget 解析白名单中的一项，服务端已校验过格式，无法解析的项不匹配任何目标
+func parseAllowedTarget(pattern string) (allowedTarget, bool) {
+	entry := allowedTarget{}
+	if socketPath := strings.TrimSpace(pattern); strings.HasPrefix(socketPath, "unix:") {
+		entry.unixPath = path.Clean(strings.TrimPrefix(strings.TrimPrefix(socketPath, "unix:"), "//"))
+		return entry, strings.HasPrefix(entry.unixPath, "/")
+	}
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host := pattern
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		host = h
+		if p != "*" {
+			entry.port = p
+		}
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]")
+	}
+
+	switch {
+	case host == "":
+		return entry, false
+	case host == "*":
+		entry.any = true
+	case strings.Contains(host, "/"):
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return entry, false
+		}
+		entry.network = network
+	case strings.HasPrefix(host, "*."):
+		entry.suffix = host[1:]
+	default:
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		entry.host = host
+	}
+	return entry, true
+}
+
+// matches 目标主机和端口是否在该项内，host 已转为小写
+func (e allowedTarget) matches(host, port string) bool {
+	if e.unixPath != "" || (e.port != "" && e.port != port) {
+		return false
+	}
+	switch {
+	case e.any:
+		return true
+	case e.network != nil:
+		ip := net.ParseIP(host)
+		return ip != nil && e.network.Contains(ip)
+	case e.suffix != "":
+		return strings.HasSuffix(host, e.suffix)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+	}
+	return host == e.host
+}
+
+// setAllowedTargets 更新服务端下发的目标白名单，为空时不限制
+func (a *Agent) setAllowedTargets(patterns []string) {
+	var entries []allowedTarget
+	for _, pattern := range patterns {
+		entry, ok := parseAllowedTarget(pattern)
+		if !ok {
+			httpLog.Warnf("无法解析目标白名单中的一项，忽略: %q", pattern)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	a.allowMu.Lock()
+	a.allowedTargets = patterns
+	a.allowEntries = entries
+	a.allowMu.Unlock()
+	if len(patterns) > 0 {
+		httpLog.Infof("服务端下发了目标白名单: %s", strings.Join(patterns, ", "))
+	}
+}
+
+// targetAllowed 目标地址是否在白名单内，没有白名单时总是允许
+// 按路由配置的目标地址判断，不考虑本地的主机名映射和出站代理
+func (a *Agent) targetAllowed(targetURL string) bool {
+	a.allowMu.RLock()
+	defer a.allowMu.RUnlock()
+	if len(a.allowedTargets) == 0 {
+		return true
+	}
+
+	// unix套接字目标只能由 unix:路径 或不限制端口的 * 放行
+	if isUnixTarget(targetURL) {
+		socketPath, _, err := parseUnixTarget(targetURL)
+		if err != nil {
+			return false
+		}
+		for _, entry := range a.allowEntries {
+			if entry.unixPath == socketPath || (entry.any && entry.port == "") {
+				return true
+			}
+		}
+		return false
+	}
+
+	u, err := url.Parse(targetURL)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if strings.EqualFold(u.Scheme, "https") {
+			port = "443"
+		}
+	}
+	for _, entry := range a.allowEntries {
+		if entry.matches(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedTargetList 当前的目标白名单，为nil时不限制
+func (a *Agent) allowedTargetList() []string {
+	a.allowMu.RLock()
+	defer a.allowMu.RUnlock()
+	return a.allowedTargets
+}
+
+// handleTargetAllowlist 处理服务端修改后下发的目标白名单
+func (a *Agent) handleTargetAllowlist(msg *protocol.Message) {
+	var payload protocol.TargetAllowlistPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		wsLog.Errorf("解析目标白名单失败: %v", err)
+		return
+	}
+	a.setAllowedTargets(payload.AllowedTargets)
+	if len(payload.AllowedTargets) == 0 {
+		httpLog.Infof("服务端取消了目标白名单")
+	}
+}
+
+// reportViolation 向服务端上报拒绝了白名单之外的目标
+func (a *Agent) reportViolation(method, targetURL, urlSuffix string) {
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeControl,
+		Op:        protocol.OpTargetViolation,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload: &protocol.TargetViolationPayload{
+			Method:    method,
+			URL:       targetURL,
+			URLSuffix: urlSuffix,
+			Timestamp: time.Now().UnixMilli(),
+		},
+	}
+	if err := a.sendMessageWithRetry(msg); err != nil {
+		httpLog.Errorf("上报白名单违规失败: %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+package agent
+
+import (
+	"container/list"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// 响应的缓存状态，通过 X-Cache 响应头返回
+const (
+	cacheHit         = "HIT"         // 缓存的响应在有效期内，没有请求后端
+	cacheRevalidated = "REVALIDATED" // 后端返回304，确认缓存的响应仍然有效
+	cacheMiss        = "MISS"        // 响应来自后端
+)
+
+// cachedResponse 缓存的后端响应，存入缓存后不再修改
+type cachedResponse struct {
+	key      string
+	status   int
+	header   http.Header
+	body     []byte
+	vary     map[string]string // Vary 列出的请求头及缓存时的值
+	storedAt time.Time         // 后端生成响应的时间，已扣除后端返回的 Age
+	expires  time.Time         // 之后使用前需要向后端重新验证
+}
+
+// responseCache 可缓存路由的后端响应，超过条目数或总大小时淘汰最久未使用的响应
+type responseCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int
+	bytes      int
+	entries    map[string]*list.Element
+	lru        *list.List // 最近使用的在前
+}
+
+// newResponseCache 创建响应缓存
+func newResponseCache(maxEntries, maxBytes int) *responseCache {
+	return &responseCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// responseCacheKey 缓存键，同一目标地址的 GET 和 HEAD 分别缓存
+func responseCacheKey(method, targetURL string) string {
+	return method + " " + targetURL
+}
+
+// get 查找缓存的响应，Vary 列出的请求头与缓存时不同时视为没有缓存
+func (c *responseCache) get(key string, header http.Header) *cachedResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, exists := c.entries[key]
+	if !exists {
+		return nil
+	}
+	entry := elem.Value.(*cachedResponse)
+	for name, value := range entry.vary {
+		if header.Get(name) != value {
+			return nil
+		}
+	}
+	c.lru.MoveToFront(elem)
+	return entry
+}
+
+// put 存入响应，替换同一缓存键之前的响应
+func (c *responseCache) put(entry *cachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, exists := c.entries[entry.key]; exists {
+		c.removeLocked(elem)
+	}
+	if len(entry.body) > c.maxBytes {
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.bytes += len(entry.body)
+	for c.lru.Len() > c.maxEntries || c.bytes > c.maxBytes {
+		c.removeLocked(c.lru.Back())
+	}
+}
+
+// remove 删除缓存的响应
+func (c *responseCache) remove(keys ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if elem, exists := c.entries[key]; exists {
+			c.removeLocked(elem)
+		}
+	}
+}
+
+// removeLocked 删除缓存项，调用方需持有锁
+func (c *responseCache) removeLocked(elem *list.Element) {
+	entry := c.lru.Remove(elem).(*cachedResponse)
+	delete(c.entries, entry.key)
+	c.bytes -= len(entry.body)
+}
+
+// len 缓存的响应数
+func (c *responseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// fresh 缓存的响应是否仍在有效期内
+func (e *cachedResponse) fresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+// addValidators 为重新验证的请求加上 If-None-Match 和 If-Modified-Since
+func (e *cachedResponse) addValidators(header http.Header) {
+	if etag := e.header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// payload 把缓存的响应转为响应载荷，加上 Age 和表示缓存状态的 X-Cache
+func (e *cachedResponse) payload(now time.Time, cacheStatus string, latency time.Duration) *protocol.ResponsePayload {
+	headers := make(map[string]string, len(e.header)+2)
+	headerValues := make(map[string][]string, len(e.header)+2)
+	for name, values := range e.header {
+		if len(values) > 0 {
+			headers[name] = values[0]
+			headerValues[name] = values
+		}
+	}
+	age := strconv.FormatInt(int64(now.Sub(e.storedAt)/time.Second), 10)
+	for name, value := range map[string]string{"Age": age, "X-Cache": cacheStatus} {
+		headers[name] = value
+		headerValues[name] = []string{value}
+	}
+	return &protocol.ResponsePayload{
+		HTTPStatus: e.status,
+		Headers:    headers,
+		Body:       string(e.body),
+		LatencyMS:  latency.Milliseconds(),
+
+		HeaderValues: headerValues,
+	}
+}
+
+// cacheDirectives 解析 Cache-Control，指令名转为小写
+func cacheDirectives(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// payloadHeader 把请求载荷中的请求头转为 http.Header，便于不区分大小写地读取
+func payloadHeader(headers map[string]string) http.Header {
+	header := make(http.Header, len(headers))
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+	return header
+}
+
+// cacheLookup 判断请求能否使用缓存，revalidate 表示请求要求先向后端验证缓存的响应
+// 带认证信息、Range 或自身验证条件的请求直接转发给后端，不读也不写缓存
+func (a *Agent) cacheLookup(payload *protocol.RequestPayload, header http.Header) (usable, revalidate bool) {
+	if a.responseCache == nil || !payload.Cacheable || payload.BodyStreamed {
+		return false, false
+	}
+	if payload.HTTPMethod != http.MethodGet && payload.HTTPMethod != http.MethodHead {
+		return false, false
+	}
+	for _, name := range []string{"Authorization", "Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since", "If-Range"} {
+		if header.Get(name) != "" {
+			return false, false
+		}
+	}
+	directives := cacheDirectives(header.Values("Cache-Control"))
+	if _, noStore := directives["no-store"]; noStore {
+		return false, false
+	}
+	_, noCache := directives["no-cache"]
+	maxAge, hasMaxAge := directives["max-age"]
+	revalidate = noCache || (hasMaxAge && maxAge == "0") || strings.EqualFold(header.Get("Pragma"), "no-cache")
+	return true, revalidate
+}
+
+// invalidateCache 可缓存路由上修改资源的请求使同一目标地址缓存的响应失效
+func (a *Agent) invalidateCache(payload *protocol.RequestPayload, targetURL string) {
+	if a.responseCache == nil || !payload.Cacheable {
+		return
+	}
+	switch payload.HTTPMethod {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return
+	}
+	a.responseCache.remove(responseCacheKey(http.MethodGet, targetURL), responseCacheKey(http.MethodHead, targetURL))
+}
+
+// storeResponse 按响应头缓存后端返回的200响应，不能缓存时删除之前缓存的响应，返回是否已缓存
+func (a *Agent) storeResponse(key string, reqHeader http.Header, resp *http.Response, body []byte) bool {
+	if resp.StatusCode != http.StatusOK || len(resp.Trailer) > 0 {
+		return false
+	}
+	entry := newCachedResponse(key, reqHeader, resp.StatusCode, resp.Header, body, time.Now())
+	if entry == nil {
+		a.responseCache.remove(key)
+		return false
+	}
+	a.responseCache.put(entry)
+	return true
+}
+
+// revalidateResponse 后端返回304后用其中的响应头更新缓存的响应，返回更新后的响应
+func (a *Agent) revalidateResponse(cached *cachedResponse, reqHeader http.Header, resp *http.Response) *cachedResponse {
+	header := cached.header.Clone()
+	for name, values := range resp.Header {
+		if name != "Content-Length" {
+			header[name] = values
+		}
+	}
+	entry := newCachedResponse(cached.key, reqHeader, cached.status, header, cached.body, time.Now())
+	if entry == nil {
+		// 后端不再允许缓存，本次仍返回缓存的响应体
+		a.responseCache.remove(cached.key)
+		updated := *cached
+		updated.header = header
+		return &updated
+	}
+	a.responseCache.put(entry)
+	return entry
+}
+
+// newCachedResponse 按 Cache-Control、Expires 和 Vary 生成缓存项，响应不能缓存时返回nil
+// 带 Set-Cookie、no-store 或 private 的响应不缓存；没有声明有效期但带 ETag 或 Last-Modified 的响应
+// 每次使用前向后端重新验证
+func newCachedResponse(key string, reqHeader http.Header, status int, header http.Header, body []byte, now time.Time) *cachedResponse {
+	if header.Get("Set-Cookie") != "" {
+		return nil
+	}
+	directives := cacheDirectives(header.Values("Cache-Control"))
+	for _, name := range []string{"no-store", "private"} {
+		if _, exists := directives[name]; exists {
+			return nil
+		}
+	}
+
+	vary := make(map[string]string)
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary[http.CanonicalHeaderKey(name)] = reqHeader.Get(name)
+			}
+		}
+	}
+
+	lifetime, ok := freshnessLifetime(header, directives, now)
+	if !ok {
+		return nil
+	}
+	storedAt := now
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil && age > 0 {
+		storedAt = now.Add(-time.Duration(age) * time.Second)
+	}
+	header = header.Clone()
+	header.Del("Age")
+	return &cachedResponse{
+		key:      key,
+		status:   status,
+		header:   header,
+		body:     body,
+		vary:     vary,
+		storedAt: storedAt,
+		expires:  storedAt.Add(lifetime),
+	}
+}
+
+// freshnessLifetime 响应的有效期，依次取 s-maxage、max-age 和 Expires
+// 有效期为0时只有带 ETag 或 Last-Modified 的响应可以缓存，第二个返回值为false表示不能缓存
+func freshnessLifetime(header http.Header, directives map[string]string, now time.Time) (time.Duration, bool) {
+	validated := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	if _, noCache := directives["no-cache"]; noCache {
+		return 0, validated
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, exists := directives[name]; exists {
+			if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second, true
+			}
+			return 0, validated
+		}
+	}
+	if expires, err := http.ParseTime(header.Get("Expires")); err == nil {
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+	}
+	return 0, validated
+}
+
+// sendCachedResponse 用缓存的响应回复请求
+func (a *Agent) sendCachedResponse(msg *protocol.Message, cached *cachedResponse, cacheStatus string, latency, ttl time.Duration, access *accessEntry) {
+	responseMsg := &protocol.Message{
+		MsgID:     msg.MsgID,
+		Type:      protocol.MessageTypeBusiness,
+		Op:        protocol.OpResponse,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   cached.payload(time.Now(), cacheStatus, latency),
+	}
+
+	access.status = cached.status
+	access.bytes = int64(len(cached.body))
+	access.cache = cacheStatus
+	spooled, err := a.sendResponse(responseMsg, ttl)
+	access.spooled = spooled
+	if err != nil {
+		httpLog.Errorf("发送响应失败: %v", err)
+		access.err = "发送响应失败: " + err.Error()
+	}
+}
@@ -0,0 +1,34 @@
+package agent
+
+import (
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// resetCompression 每次注册前停用上一次连接的压缩设置，等服务端在注册确认中下发后再压缩
+func (a *Agent) resetCompression() {
+	a.cryptoMu.Lock()
+	a.compression = nil
+	a.cryptoMu.Unlock()
+}
+
+// setupCompression 使用注册确认中服务端下发的默认载荷压缩设置，旧版本服务端不下发时不压缩
+func (a *Agent) setupCompression(settings *protocol.CompressionSettings) {
+	if settings == nil {
+		return
+	}
+	a.cryptoMu.Lock()
+	a.compression = settings
+	a.cryptoMu.Unlock()
+	wsLog.Infof("已启用载荷压缩: %s, 阈值 %d 字节", settings.Algorithm, settings.MinBytes)
+}
+
+// compressMessage 服务端支持压缩时按消息指定的设置（路由的覆盖设置）或默认设置返回载荷压缩后的消息副本
+func (a *Agent) compressMessage(msg *protocol.Message) (*protocol.Message, error) {
+	a.cryptoMu.Lock()
+	defaults := a.compression
+	a.cryptoMu.Unlock()
+	if defaults == nil {
+		return msg, nil
+	}
+	return msg.Compress(msg.Compression(defaults))
+}
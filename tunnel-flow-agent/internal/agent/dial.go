@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// newTargetTransport 转发普通请求使用的共享Transport，参数与 http.DefaultTransport 相同，连接时应用主机名映射
+// 开启了目标请求走出站代理时使用配置的代理，否则与 http.DefaultTransport 一样读取环境变量中的代理
+func (a *Agent) newTargetTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = a.targetDialContext(&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	})
+	if a.config.ProxyTargets() {
+		transport.Proxy = a.outboundProxy
+	}
+	return transport
+}
+
+// targetDialContext 连接目标地址，unix套接字目标连接对应的套接字，主机名在 hosts 映射中时改为连接映射的地址
+// 只改写连接的地址，请求的Host头和TLS的ServerName仍使用原主机名
+func (a *Agent) targetDialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if socketPath, ok := unixSocketForHost(addr); ok {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		if mapped, ok := a.config.MapHost(addr); ok {
+			httpLog.Debugf("主机名映射: %s -> %s", addr, mapped)
+			addr = mapped
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+}
+
+// outboundProxy 出站代理的 Proxy 函数，未配置代理、目标主机在 no_proxy 中或目标是unix套接字时直接连接
+func (a *Agent) outboundProxy(req *http.Request) (*url.URL, error) {
+	proxyURL := a.config.ProxyURL()
+	if proxyURL == nil || a.config.NoProxy(req.URL.Hostname()) || strings.HasSuffix(req.URL.Hostname(), unixHostSuffix) {
+		return nil, nil
+	}
+	return proxyURL, nil
+}
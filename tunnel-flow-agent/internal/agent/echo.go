@@ -0,0 +1,35 @@
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// sendEcho 服务端的隧道压测请求，不转发给后端，直接以200返回请求体
+func (a *Agent) sendEcho(msg *protocol.Message, reqPayload *protocol.RequestPayload, ttl time.Duration, access *accessEntry) {
+	responseMsg := &protocol.Message{
+		MsgID:     msg.MsgID,
+		Type:      protocol.MessageTypeBusiness,
+		Op:        protocol.OpResponse,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload: &protocol.ResponsePayload{
+			HTTPStatus: http.StatusOK,
+			Headers:    map[string]string{"Content-Type": "application/octet-stream"},
+			Body:       reqPayload.Body,
+			LatencyMS:  time.Since(access.start).Milliseconds(),
+		},
+	}
+	responseMsg.UseCompression(reqPayload.Compression)
+
+	access.target = "echo"
+	access.status = http.StatusOK
+	access.bytes = int64(len(reqPayload.Body))
+	spooled, err := a.sendResponse(responseMsg, ttl)
+	access.spooled = spooled
+	if err != nil {
+		httpLog.Errorf("发送响应失败: %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"crypto/cipher"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// prepareEncryption 每次注册前生成新的密钥对并停用上一次连接的密钥，返回注册时上报的公钥
+// 未开启加密或生成密钥失败时返回空，本次连接以明文传输
+func (a *Agent) prepareEncryption() string {
+	a.cryptoMu.Lock()
+	defer a.cryptoMu.Unlock()
+	a.encryptionKey = nil
+	a.sessionCipher = nil
+	if !a.config.EncryptionEnabled() {
+		return ""
+	}
+	key, publicKey, err := protocol.NewEncryptionKey()
+	if err != nil {
+		wsLog.Errorf("生成加密密钥失败，本次连接以明文传输: %v", err)
+		return ""
+	}
+	a.encryptionKey = key
+	return publicKey
+}
+
+// setupEncryption 用注册确认中服务端的公钥派生该连接的密钥，之后发送的消息载荷加密
+func (a *Agent) setupEncryption(serverKey string) {
+	a.cryptoMu.Lock()
+	defer a.cryptoMu.Unlock()
+	if a.encryptionKey == nil {
+		return
+	}
+	if serverKey == "" {
+		wsLog.Warnf("服务端不支持载荷加密，消息以明文传输")
+		return
+	}
+	aead, err := protocol.DeriveSessionCipher(a.encryptionKey, serverKey, a.config.ClientID(), a.config.AuthToken())
+	if err != nil {
+		wsLog.Errorf("协商加密密钥失败，消息以明文传输: %v", err)
+		return
+	}
+	a.sessionCipher = aead
+	wsLog.Infof("已启用载荷加密")
+}
+
+// getSessionCipher 当前连接的载荷密钥，未启用加密时为nil
+func (a *Agent) getSessionCipher() cipher.AEAD {
+	a.cryptoMu.Lock()
+	defer a.cryptoMu.Unlock()
+	return a.sessionCipher
+}
+
+// sealMessage 当前连接启用加密时返回载荷加密后的消息副本
+func (a *Agent) sealMessage(msg *protocol.Message) (*protocol.Message, error) {
+	aead := a.getSessionCipher()
+	if aead == nil || protocol.EncryptionExempt(msg.Op) {
+		return msg, nil
+	}
+	return msg.Seal(aead)
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"net/http"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// sendForbidden 请求被拒绝规则拦截，不转发给后端，直接返回403
+func (a *Agent) sendForbidden(msg *protocol.Message, reason string, ttl time.Duration, access *accessEntry) {
+	responseMsg := &protocol.Message{
+		MsgID:     msg.MsgID,
+		Type:      protocol.MessageTypeBusiness,
+		Op:        protocol.OpResponse,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload: &protocol.ResponsePayload{
+			HTTPStatus: http.StatusForbidden,
+			Headers:    map[string]string{"Content-Type": "text/plain; charset=utf-8"},
+			Body:       "Forbidden by agent filter\n",
+			Error:      &reason,
+		},
+	}
+
+	access.status = http.StatusForbidden
+	access.err = reason
+	spooled, err := a.sendResponse(responseMsg, ttl)
+	access.spooled = spooled
+	if err != nil {
+		httpLog.Errorf("发送响应失败: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// fragmentTimeout 超过该时间没有收到新分段的消息丢弃
+const fragmentTimeout = 2 * time.Minute
+
+// resetFragmentation 每次注册前停用上一次连接的分段设置，等服务端在注册确认中下发后再拆分
+func (a *Agent) resetFragmentation() {
+	a.cryptoMu.Lock()
+	a.maxMessageBytes = 0
+	a.cryptoMu.Unlock()
+}
+
+// setupFragmentation 使用注册确认中服务端下发的最大消息大小，旧版本服务端不下发时不拆分
+func (a *Agent) setupFragmentation(maxBytes int) {
+	if maxBytes <= 0 {
+		return
+	}
+	a.cryptoMu.Lock()
+	a.maxMessageBytes = maxBytes
+	a.cryptoMu.Unlock()
+	wsLog.Infof("已启用消息分段: 超过 %d 字节的消息拆分发送", maxBytes)
+}
+
+// splitMessage 序列化后的消息超过服务端下发的最大消息大小时拆分为分段帧，不需要拆分时返回nil
+func (a *Agent) splitMessage(data []byte) ([][]byte, error) {
+	a.cryptoMu.Lock()
+	maxBytes := a.maxMessageBytes
+	a.cryptoMu.Unlock()
+	return protocol.SplitMessage(data, maxBytes)
+}
+
+// writeFrames 依次发送分段帧，每个分段单独加写入锁，其他消息可以插在分段之间发送
+func (a *Agent) writeFrames(conn *websocket.Conn, frames [][]byte) error {
+	for _, frame := range frames {
+		a.writeMu.Lock()
+		err := conn.WriteMessage(websocket.BinaryMessage, frame)
+		a.writeMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package agent
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"tunnel-flow-agent/internal/config"
+	"tunnel-flow-agent/internal/logging"
+)
+
+// Group 按配置连接服务器的一组代理
+// active-standby 模式（以及只配置了一个服务器时）只有一个代理，连接失败时在服务器之间切换；
+// active-active 模式每个服务器一个代理，各自独立连接和注册，共享访问日志、目标健康状态、最近的请求和响应缓存
+type Group struct {
+	config    *config.Config
+	agents    []*Agent
+	accessLog *logging.Logger
+}
+
+// NewGroup 根据服务器配置创建代理
+func NewGroup(cfg *config.Config) *Group {
+	servers := cfg.ServerURLs()
+	if cfg.ServerMode() != config.ServerModeActiveActive || len(servers) == 1 {
+		return &Group{config: cfg, agents: []*Agent{NewAgent(cfg)}}
+	}
+
+	g := &Group{
+		config:    cfg,
+		accessLog: newAccessLog(cfg.AccessLogPath(), cfg.AccessLog.MaxSizeMB, cfg.AccessLog.MaxBackups),
+	}
+	for i, serverURL := range servers {
+		a := newAgent(cfg, []string{serverURL})
+		a.accessLog = g.accessLog
+		if i > 0 {
+			a.targetTracker = g.agents[0].targetTracker
+			a.recentRequests = g.agents[0].recentRequests
+			a.responseCache = g.agents[0].responseCache
+		}
+		g.agents = append(g.agents, a)
+	}
+	return g
+}
+
+// Start 启动所有代理
+func (g *Group) Start() error {
+	for _, a := range g.agents {
+		if err := a.Start(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stop 同时停止所有代理，等待各自处理中的请求完成
+func (g *Group) Stop() {
+	var wg sync.WaitGroup
+	for _, a := range g.agents {
+		wg.Add(1)
+		go func(a *Agent) {
+			defer wg.Done()
+			a.Stop()
+		}(a)
+	}
+	wg.Wait()
+	if g.accessLog != nil {
+		g.accessLog.Close()
+	}
+}
+
+// IsRunning 至少与一个服务器保持连接时返回true
+func (g *Group) IsRunning() bool {
+	for _, a := range g.agents {
+		if a.IsRunning() {
+			return true
+		}
+	}
+	return false
+}
+
+// GetStats 汇总所有代理的统计信息
+func (g *Group) GetStats() map[string]interface{} {
+	stats := g.agents[0].GetStats()
+	for _, a := range g.agents[1:] {
+		for name, value := range a.GetStats() {
+			switch v := value.(type) {
+			case int64:
+				stats[name] = stats[name].(int64) + v
+			case bool:
+				stats[name] = stats[name].(bool) || v
+			}
+		}
+	}
+	return stats
+}
+
+// Status 汇总所有代理的状态，active-active 模式下逐个列出与各服务器的连接
+func (g *Group) Status() Status {
+	status := g.agents[0].Status()
+	if len(g.agents) == 1 {
+		return status
+	}
+
+	statuses := []Status{status}
+	for _, a := range g.agents[1:] {
+		statuses = append(statuses, a.Status())
+	}
+	status.ServerURL = strings.Join(g.config.ServerURLs(), ", ")
+	status.Connected = false
+	status.InFlight = 0
+	status.Spooled = 0
+	status.ConnectFailures = 0
+	for _, s := range statuses {
+		status.Connected = status.Connected || s.Connected
+		status.InFlight += s.InFlight
+		status.Spooled += s.Spooled
+		status.ConnectFailures += s.ConnectFailures
+		status.Draining = status.Draining || s.Draining
+		status.Servers = append(status.Servers, ServerStatus{
+			URL:            s.ServerURL,
+			Connected:      s.Connected,
+			ConnectedSince: s.ConnectedSince,
+			RTT:            s.RTT,
+			InFlight:       s.InFlight,
+			Spooled:        s.Spooled,
+		})
+	}
+	return status
+}
+
+// StatusPageHandler 本地状态页
+func (g *Group) StatusPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	renderStatusPage(w, g.Status())
+}
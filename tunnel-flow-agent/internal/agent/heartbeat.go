@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// resetHeartbeat 每次注册前恢复本地默认的心跳间隔和超时，等服务端在注册确认中下发该客户端的设置
+func (a *Agent) resetHeartbeat() {
+	a.qualityMu.Lock()
+	a.heartbeatInterval = 0
+	a.heartbeatTimeout = 0
+	a.qualityMu.Unlock()
+}
+
+// setupHeartbeat 使用服务端下发的心跳间隔和超时，旧版本服务端不下发时使用本地默认值
+func (a *Agent) setupHeartbeat(settings *protocol.HeartbeatSettings) {
+	if settings == nil || settings.IntervalSeconds <= 0 || settings.TimeoutSeconds <= 0 {
+		return
+	}
+	a.qualityMu.Lock()
+	a.heartbeatInterval = time.Duration(settings.IntervalSeconds) * time.Second
+	a.heartbeatTimeout = time.Duration(settings.TimeoutSeconds) * time.Second
+	a.qualityMu.Unlock()
+	// 通知心跳循环按新的间隔发送心跳
+	select {
+	case a.heartbeatChanged <- struct{}{}:
+	default:
+	}
+	heartbeatLog.Infof("使用服务端设置的心跳: 间隔 %ds, 超时 %ds", settings.IntervalSeconds, settings.TimeoutSeconds)
+}
+
+// handleHeartbeatConfig 使用服务端运行时修改后下发的心跳间隔和超时
+func (a *Agent) handleHeartbeatConfig(msg *protocol.Message) {
+	var payload protocol.HeartbeatSettings
+	if err := msg.ParsePayload(&payload); err != nil {
+		heartbeatLog.Errorf("解析心跳设置失败: %v", err)
+		return
+	}
+	a.setupHeartbeat(&payload)
+}
+
+// pingInterval 当前的心跳间隔
+func (a *Agent) pingInterval() time.Duration {
+	a.qualityMu.RLock()
+	defer a.qualityMu.RUnlock()
+	if a.heartbeatInterval > 0 {
+		return a.heartbeatInterval
+	}
+	return a.config.PingInterval()
+}
+
+// pingTimeout 当前的心跳超时
+func (a *Agent) pingTimeout() time.Duration {
+	a.qualityMu.RLock()
+	defer a.qualityMu.RUnlock()
+	if a.heartbeatTimeout > 0 {
+		return a.heartbeatTimeout
+	}
+	return a.config.PingTimeout()
+}
@@ -0,0 +1,65 @@
+package agent
+
+import (
+	"sync/atomic"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// countRequest 请求结束时计入计数器，没有返回响应、转发失败或后端返回5xx计为失败
+func (a *Agent) countRequest(entry *accessEntry) {
+	a.stats.mu.Lock()
+	defer a.stats.mu.Unlock()
+	a.stats.requests++
+	if entry.status == 0 || entry.status >= 500 || entry.err != "" {
+		a.stats.failedRequests++
+	}
+	a.stats.responseBytes += entry.bytes
+}
+
+// metricsSample 采集当前的计数器
+func (a *Agent) metricsSample() protocol.MetricsPayload {
+	sample := protocol.MetricsPayload{
+		Timestamp:  time.Now().UnixMilli(),
+		Reconnects: atomic.LoadInt64(&a.reconnectCount),
+	}
+
+	a.stats.mu.RLock()
+	sample.MessagesSent = a.stats.messagesSent
+	sample.MessagesReceived = a.stats.messagesReceived
+	sample.Errors = a.stats.errorCount
+	sample.Requests = a.stats.requests
+	sample.FailedRequests = a.stats.failedRequests
+	sample.ResponseBytes = a.stats.responseBytes
+	a.stats.mu.RUnlock()
+
+	a.drainMu.Lock()
+	sample.InFlight = a.pending
+	a.drainMu.Unlock()
+
+	a.qualityMu.RLock()
+	sample.RTTMS = a.rtt.Milliseconds()
+	a.qualityMu.RUnlock()
+	return sample
+}
+
+// sendMetrics 发送计数器，吞吐量按距上次上报 previous 的增量计算，返回本次上报的计数器
+func (a *Agent) sendMetrics(previous protocol.MetricsPayload) (protocol.MetricsPayload, error) {
+	sample := a.metricsSample()
+	sample.IntervalMS = sample.Timestamp - previous.Timestamp
+	if sample.IntervalMS > 0 {
+		seconds := float64(sample.IntervalMS) / 1000
+		sample.RequestsPerSec = float64(sample.Requests-previous.Requests) / seconds
+		sample.BytesPerSec = float64(sample.ResponseBytes-previous.ResponseBytes) / seconds
+	}
+
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeControl,
+		Op:        protocol.OpMetrics,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   &sample,
+	}
+	return sample, a.sendMessageWithRetry(msg)
+}
@@ -0,0 +1,59 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// markRegistered 记录当前连接已在服务端注册成功，重新连接后需要重新注册
+func (a *Agent) markRegistered() {
+	a.connMu.Lock()
+	a.registeredConn = a.conn
+	a.connMu.Unlock()
+}
+
+// NotReadyReason 已在服务端注册、最近一个心跳超时内收到过服务端的pong且没有在停止时返回空字符串，否则返回未就绪的原因
+func (a *Agent) NotReadyReason() string {
+	a.drainMu.Lock()
+	draining := a.draining
+	a.drainMu.Unlock()
+	if draining {
+		return "draining"
+	}
+
+	a.connMu.RLock()
+	connected := a.conn != nil
+	registered := connected && a.registeredConn == a.conn
+	a.connMu.RUnlock()
+	switch {
+	case !connected:
+		return "not connected to server"
+	case !registered:
+		return "not registered with server"
+	}
+
+	a.qualityMu.RLock()
+	lastPong := a.lastPongTime
+	a.qualityMu.RUnlock()
+	if timeout := a.pingTimeout(); time.Since(lastPong) > timeout {
+		return fmt.Sprintf("no heartbeat from server for %v", time.Since(lastPong).Round(time.Second))
+	}
+	return ""
+}
+
+// NotReadyReason active-active 模式下至少一个代理就绪即就绪，都未就绪时返回各服务器的原因
+func (g *Group) NotReadyReason() string {
+	if len(g.agents) == 1 {
+		return g.agents[0].NotReadyReason()
+	}
+	var reasons []string
+	for _, a := range g.agents {
+		reason := a.NotReadyReason()
+		if reason == "" {
+			return ""
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %s", a.serverURL(), reason))
+	}
+	return strings.Join(reasons, "; ")
+}
@@ -0,0 +1,18 @@
+package agent
+
+// resumeToken 上次注册确认中的恢复令牌，重连注册时带上，服务端在保留时间内可以恢复断线前的会话
+func (a *Agent) resumeToken() string {
+	a.connMu.RLock()
+	defer a.connMu.RUnlock()
+	return a.sessionToken
+}
+
+// setupResume 保存注册确认中下发的恢复令牌，旧版本服务端或不保留会话时为空
+func (a *Agent) setupResume(token string, resumed bool) {
+	a.connMu.Lock()
+	a.sessionToken = token
+	a.connMu.Unlock()
+	if resumed {
+		wsLog.Infof("已恢复断线前的会话，服务端继续发送未发出的消息")
+	}
+}
@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// syncRoutes 注册成功后上报配置中声明的路由，没有声明时也上报空列表，服务端据此删除之前声明的路由
+func (a *Agent) syncRoutes() {
+	msg := &protocol.Message{
+		Type:      protocol.MessageTypeControl,
+		Op:        protocol.OpRouteSync,
+		ClientID:  a.config.ClientID(),
+		Timestamp: time.Now().UnixMilli(),
+		Payload:   &protocol.RouteSyncPayload{Routes: a.config.DeclaredRoutes()},
+	}
+	if err := a.sendMessageWithRetry(msg); err != nil {
+		wsLog.Errorf("上报声明的路由失败: %v", err)
+	}
+}
+
+// handleRouteSyncAck 记录服务端对声明路由的处理结果，管理员审批声明的路由后服务端也通过该消息推送审批结果
+func (a *Agent) handleRouteSyncAck(msg *protocol.Message) {
+	var payload protocol.RouteSyncAckPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		wsLog.Errorf("解析路由同步确认失败: %v", err)
+		return
+	}
+	for _, result := range payload.Results {
+		switch result.Status {
+		case protocol.RouteSyncRejected:
+			wsLog.Warnf("声明的路由 %s 被服务端拒绝: %s", result.URLSuffix, result.Reason)
+			continue
+		case protocol.RouteSyncPending:
+			wsLog.Warnf("声明的路由 %s 等待管理员审批，批准前不转发请求 (路由ID %d)", result.URLSuffix, result.RouteID)
+			continue
+		case protocol.RouteSyncApproved:
+			wsLog.Infof("声明的路由 %s 已被管理员批准 (路由ID %d)", result.URLSuffix, result.RouteID)
+			continue
+		}
+		wsLog.Infof("声明的路由 %s: %s (路由ID %d)", result.URLSuffix, result.Status, result.RouteID)
+	}
+	for _, urlSuffix := range payload.Removed {
+		wsLog.Infof("服务端已删除不再声明的路由: %s", urlSuffix)
+	}
+}
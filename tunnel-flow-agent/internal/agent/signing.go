@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// signatureWindow 服务端消息的签名时间与本地时间相差超过该值时拒绝
+const signatureWindow = 5 * time.Minute
+
+// signMessage 用认证Token对要发送的消息签名，不支持签名的旧服务端忽略签名字段
+func (a *Agent) signMessage(msg *protocol.Message) (*protocol.Message, error) {
+	return msg.Sign(a.config.AuthToken())
+}
+
+// resetSigning 每次注册前重置，等服务端在注册确认中带上签名后再要求签名
+func (a *Agent) resetSigning() {
+	a.cryptoMu.Lock()
+	a.signing = false
+	a.cryptoMu.Unlock()
+}
+
+// signingEnabled 当前连接是否要求服务端的消息带签名
+func (a *Agent) signingEnabled() bool {
+	a.cryptoMu.Lock()
+	defer a.cryptoMu.Unlock()
+	return a.signing
+}
+
+// verifyMessage 校验服务端消息的签名、签名时间和随机数，data 为收到的原始消息
+// 服务端的注册确认带有效签名后，之后不带签名的消息都拒绝
+func (a *Agent) verifyMessage(msg *protocol.Message, data []byte) error {
+	if msg.Signature == "" {
+		if a.signingEnabled() {
+			return protocol.ErrMissingSignature
+		}
+		return nil
+	}
+	if err := msg.VerifySignature(a.config.AuthToken(), data); err != nil {
+		return err
+	}
+	if err := a.nonces.Check(msg.Nonce, msg.SignedAt, time.Now()); err != nil {
+		return err
+	}
+	if msg.Op == protocol.OpRegisterAck && !a.signingEnabled() {
+		a.cryptoMu.Lock()
+		a.signing = true
+		a.cryptoMu.Unlock()
+		wsLog.Infof("服务端支持消息签名，之后不带签名的消息将被拒绝")
+	}
+	return nil
+}
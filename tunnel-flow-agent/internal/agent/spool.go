@@ -0,0 +1,108 @@
+package agent
+
+import (
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+const (
+	spoolMaxEntries = 256              // 暂存的响应数上限
+	spoolMaxBytes   = 16 * 1024 * 1024 // 暂存的响应体总大小上限
+)
+
+// spooledResponse 连接断开时没有发出的响应，重连注册成功后补发
+type spooledResponse struct {
+	msg      *protocol.Message
+	size     int
+	deadline time.Time // 服务端最晚等待到该时间，之后补发没有意义
+}
+
+// sendResponse 发送响应，连接不可用时暂存到重连后补发
+// ttl 为服务端等待该请求的时间，返回的 spooled 表示响应已暂存
+func (a *Agent) sendResponse(msg *protocol.Message, ttl time.Duration) (spooled bool, err error) {
+	err = a.sendMessageWithRetry(msg)
+	if err == nil || msg.MsgID == nil {
+		return false, err
+	}
+	if !a.spoolResponse(msg, ttl) {
+		return false, err
+	}
+	httpLog.Warnf("发送响应失败，暂存到重连后补发: %s: %v", *msg.MsgID, err)
+	return true, nil
+}
+
+// spoolResponse 暂存响应，超过数量或大小上限时返回false
+func (a *Agent) spoolResponse(msg *protocol.Message, ttl time.Duration) bool {
+	size := 0
+	if payload, ok := msg.Payload.(*protocol.ResponsePayload); ok {
+		if body, ok := payload.Body.(string); ok {
+			size = len(body)
+		}
+	}
+	now := time.Now()
+
+	a.spoolMu.Lock()
+	defer a.spoolMu.Unlock()
+	a.dropExpiredLocked(now)
+	if len(a.spool) >= spoolMaxEntries || a.spoolBytes+size > spoolMaxBytes {
+		httpLog.Errorf("暂存的响应已达上限(%d个, %d字节)，丢弃响应: %s", len(a.spool), a.spoolBytes, *msg.MsgID)
+		return false
+	}
+	a.spool = append(a.spool, &spooledResponse{msg: msg, size: size, deadline: now.Add(ttl)})
+	a.spoolBytes += size
+	return true
+}
+
+// dropExpiredLocked 丢弃服务端已不再等待的响应，调用方需持有锁
+func (a *Agent) dropExpiredLocked(now time.Time) {
+	kept := a.spool[:0]
+	for _, entry := range a.spool {
+		if now.After(entry.deadline) {
+			httpLog.Warnf("暂存的响应已过期，丢弃: %s", *entry.msg.MsgID)
+			a.spoolBytes -= entry.size
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	for i := len(kept); i < len(a.spool); i++ {
+		a.spool[i] = nil
+	}
+	a.spool = kept
+}
+
+// spooledCount 暂存等待补发的响应数
+func (a *Agent) spooledCount() int {
+	a.spoolMu.Lock()
+	defer a.spoolMu.Unlock()
+	return len(a.spool)
+}
+
+// flushSpool 重连注册成功后按暂存顺序补发响应
+// 服务端已放弃等待的响应会被服务端丢弃，补发失败时剩余的响应放回队列等待下次重连
+func (a *Agent) flushSpool() {
+	a.spoolMu.Lock()
+	a.dropExpiredLocked(time.Now())
+	entries := a.spool
+	a.spool = nil
+	a.spoolBytes = 0
+	a.spoolMu.Unlock()
+	if len(entries) == 0 {
+		return
+	}
+
+	httpLog.Infof("补发 %d 个暂存的响应", len(entries))
+	for i, entry := range entries {
+		entry.msg.Timestamp = time.Now().UnixMilli()
+		if err := a.sendMessageWithRetry(entry.msg); err != nil {
+			httpLog.Errorf("补发暂存的响应失败，等待下次重连: %v", err)
+			a.spoolMu.Lock()
+			for _, rest := range entries[i:] {
+				a.spoolBytes += rest.size
+			}
+			a.spool = append(entries[i:], a.spool...)
+			a.spoolMu.Unlock()
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"tunnel-flow-agent/internal/monitoring"
+	"tunnel-flow-agent/internal/protocol"
+)
+
+//go:embed status.html
+var statusFS embed.FS
+
+// statusTemplate 本地状态页模板
+var statusTemplate = template.Must(template.New("status.html").Funcs(template.FuncMap{
+	"since": func(t time.Time) string {
+		return time.Since(t).Round(time.Second).String()
+	},
+	"millis": func(ms int64) string {
+		if ms == 0 {
+			return "-"
+		}
+		return time.UnixMilli(ms).Format("2006-01-02 15:04:05")
+	},
+}).ParseFS(statusFS, "status.html"))
+
+// Status 代理当前状态，供本地状态页展示
+type Status struct {
+	Version         string
+	ClientID        string
+	ServerURL       string
+	Connected       bool
+	Encrypted       bool // 当前连接的消息载荷是否加密
+	Signed          bool // 服务端的消息是否带签名
+	ConnectedSince  time.Time
+	StartTime       time.Time
+	RTT             time.Duration
+	NetworkQuality  float64
+	ConnectFailures int64
+	InFlight        int
+	Spooled         int      // 暂存等待重连后补发的响应数
+	Cached          int      // 缓存的响应数
+	AllowedTargets  []string // 服务端下发的目标白名单，为空时不限制
+	Compression     string   // 服务端下发的默认载荷压缩设置，为空表示服务端不支持压缩
+	Draining        bool
+	Targets         []protocol.TargetHealth
+	Requests        []monitoring.RecentRequest
+	Config          []StatusItem
+	Servers         []ServerStatus // active-active 模式下每个服务器的连接状态
+}
+
+// ServerStatus active-active 模式下与单个服务器的连接状态
+type ServerStatus struct {
+	URL            string
+	Connected      bool
+	ConnectedSince time.Time
+	RTT            time.Duration
+	InFlight       int
+	Spooled        int
+}
+
+// StatusItem 状态页中的一项配置
+type StatusItem struct {
+	Name  string
+	Value interface{}
+}
+
+// Status 汇总连接状态、网络质量、目标健康状态和最近的请求
+func (a *Agent) Status() Status {
+	status := Status{
+		Version:         Version,
+		ClientID:        a.config.ClientID(),
+		ServerURL:       a.serverURL(),
+		Targets:         a.targetTracker.Snapshot(),
+		Requests:        a.recentRequests.Snapshot(),
+		ConnectFailures: atomic.LoadInt64(&a.reconnectCount),
+	}
+
+	a.connMu.RLock()
+	status.Connected = a.conn != nil
+	status.ConnectedSince = a.lastConnectTime
+	a.connMu.RUnlock()
+
+	a.qualityMu.RLock()
+	status.RTT = a.rtt
+	status.NetworkQuality = a.networkQuality
+	a.qualityMu.RUnlock()
+
+	a.stats.mu.RLock()
+	status.StartTime = a.stats.startTime
+	a.stats.mu.RUnlock()
+
+	a.drainMu.Lock()
+	status.InFlight = a.pending
+	status.Draining = a.draining
+	a.drainMu.Unlock()
+	status.Spooled = a.spooledCount()
+	if a.responseCache != nil {
+		status.Cached = a.responseCache.len()
+	}
+	status.AllowedTargets = a.allowedTargetList()
+	status.Encrypted = a.getSessionCipher() != nil
+	status.Signed = a.signingEnabled()
+	a.cryptoMu.Lock()
+	if compression := a.compression; compression != nil {
+		status.Compression = fmt.Sprintf("%s，达到 %d 字节时压缩", compression.Algorithm, compression.MinBytes)
+		if compression.Algorithm == protocol.CompressionNone {
+			status.Compression = "不压缩"
+		}
+	}
+	a.cryptoMu.Unlock()
+
+	// 认证Token不在状态页显示
+	status.Config = []StatusItem{
+		{"服务器地址", strings.Join(a.config.ServerURLs(), ", ")},
+		{"服务器连接方式", a.config.ServerMode()},
+		{"客户端ID", a.config.ClientID()},
+		{"跳过证书验证", a.config.SSLInsecureSkipVerify()},
+		{"载荷加密", a.config.EncryptionEnabled()},
+		{"要求的最大消息大小", a.config.WebSocketMaxMessageBytes()},
+		{"工作池大小", a.config.WorkerPoolSize()},
+		{"运行状态上报间隔", a.config.StatsReportInterval()},
+		{"停止时等待请求完成", a.config.DrainTimeout()},
+		{"监控端口", a.config.MonitoringPort()},
+		{"访问日志", a.config.AccessLogPath()},
+		{"响应缓存", a.config.CacheEnabled()},
+		{"拒绝规则", len(a.config.Filter.Deny)},
+		{"主机名映射", a.config.Hosts},
+		{"出站代理", a.config.Effective().ProxyURL},
+	}
+	return status
+}
+
+// StatusPageHandler 本地状态页，现场排查问题时可以直接用浏览器查看
+func (a *Agent) StatusPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	renderStatusPage(w, a.Status())
+}
+
+// renderStatusPage 渲染本地状态页
+func renderStatusPage(w http.ResponseWriter, status Status) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, status); err != nil {
+		log.Printf("渲染状态页失败: %v", err)
+	}
+}
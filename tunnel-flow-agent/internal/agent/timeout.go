@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// idleTimeoutBody 响应体读取空闲超时：单次读取等待超过 idle 时关闭响应体，读取返回超时错误
+// 只在读取时计时，暂停读取（例如等待服务端确认分片）不计入空闲时间
+type idleTimeoutBody struct {
+	io.ReadCloser
+	idle     time.Duration
+	timer    *time.Timer
+	timedOut atomic.Bool
+}
+
+// newIdleTimeoutBody 包装后端响应体，调用方结束后需调用 Stop
+func newIdleTimeoutBody(body io.ReadCloser, idle time.Duration) *idleTimeoutBody {
+	b := &idleTimeoutBody{ReadCloser: body, idle: idle}
+	b.timer = time.AfterFunc(idle, func() {
+		b.timedOut.Store(true)
+		body.Close()
+	})
+	b.timer.Stop()
+	return b
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	b.timer.Reset(b.idle)
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+	if err != nil && b.timedOut.Load() {
+		err = fmt.Errorf("后端超过%v没有发送响应体数据", b.idle)
+	}
+	return n, err
+}
+
+// Stop 停止计时
+func (b *idleTimeoutBody) Stop() {
+	b.timer.Stop()
+}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+const (
+	transferChunkSize     = 64 * 1024        // 每个响应体分片的大小
+	transferWindow        = 16               // 未确认的分片数上限，超出时暂停读取后端响应
+	transferInlineLimit   = 1024 * 1024      // 不超过该大小的响应体直接放在响应消息中
+	transferResumeTimeout = 60 * time.Second // 服务端超过该时间没有确认或续传时放弃
+)
+
+var (
+	errTransferAborted = errors.New("服务端放弃接收响应体")
+	errTransferStalled = errors.New("等待服务端确认响应体超时")
+)
+
+// transfer 分片发送中的可续传响应体
+// 已发送但未确认的数据保留在 buf 中，连接中断后服务端通过续传通知告知已接收的偏移量，从该处重新发送
+type transfer struct {
+	id   string
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	acked        int64  // 服务端已确认接收的字节数
+	buf          []byte // 从 acked 开始已读取但未确认的数据
+	pos          int64  // 下一个要发送的偏移量
+	eof          bool   // 后端响应体已读完
+	readErr      error  // 读取后端响应体失败
+	eofSent      bool   // 最后一个分片已发送
+	ready        bool   // 服务端已开始接收
+	stalled      bool   // 发送失败，等待重连后续传
+	aborted      bool
+	finished     bool
+	gen          int // 每次续传递增，丢弃续传前发送的结果
+	lastProgress time.Time
+	compression  *protocol.CompressionSettings // 路由覆盖的载荷压缩设置，为nil时使用默认设置
+}
+
+// openTransfer 登记可续传响应体，prefix 为判断是否超过内联大小时已读取的数据
+func (a *Agent) openTransfer(id string, prefix []byte) *transfer {
+	t := &transfer{id: id, buf: prefix, lastProgress: time.Now()}
+	t.cond = sync.NewCond(&t.mu)
+
+	a.transfersMu.Lock()
+	a.transfers[id] = t
+	a.transfersMu.Unlock()
+	return t
+}
+
+// closeTransfer 结束可续传响应体
+func (a *Agent) closeTransfer(t *transfer) {
+	a.transfersMu.Lock()
+	delete(a.transfers, t.id)
+	a.transfersMu.Unlock()
+
+	t.mu.Lock()
+	t.finished = true
+	t.cond.Broadcast()
+	t.mu.Unlock()
+}
+
+// handleTransferAck 处理服务端的确认、续传和放弃通知
+func (a *Agent) handleTransferAck(msg *protocol.Message) {
+	var ack protocol.TransferAckPayload
+	if err := msg.ParsePayload(&ack); err != nil {
+		httpLog.Errorf("解析响应体确认失败: %v", err)
+		return
+	}
+
+	a.transfersMu.Lock()
+	t := a.transfers[ack.TransferID]
+	a.transfersMu.Unlock()
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ack.Abort {
+		t.aborted = true
+		t.cond.Broadcast()
+		return
+	}
+	if ack.Offset > t.acked && ack.Offset <= t.acked+int64(len(t.buf)) {
+		t.buf = t.buf[ack.Offset-t.acked:]
+		t.acked = ack.Offset
+	}
+	if ack.Resume {
+		httpLog.Infof("从偏移量 %d 续传响应体: %s", t.acked, t.id)
+		t.pos = t.acked
+		t.eofSent = false
+		t.stalled = false
+		t.gen++
+	}
+	t.ready = true
+	t.lastProgress = time.Now()
+	t.cond.Broadcast()
+}
+
+// runTransfer 读取后端响应体并分片发送，直到服务端确认全部数据
+// trailer 在响应体读完后才有值，随最后一个分片发送
+func (a *Agent) runTransfer(ctx context.Context, t *transfer, body io.Reader, trailer http.Header) error {
+	defer a.closeTransfer(t)
+
+	go t.read(body)
+	go func() {
+		// 定期唤醒等待中的发送循环以检查超时和取消
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			t.mu.Lock()
+			finished := t.finished
+			t.cond.Broadcast()
+			t.mu.Unlock()
+			if finished {
+				return
+			}
+		}
+	}()
+
+	for {
+		t.mu.Lock()
+		var chunk *protocol.ResponseChunkPayload
+		for chunk == nil {
+			end := t.acked + int64(len(t.buf))
+			switch {
+			case t.aborted:
+				t.mu.Unlock()
+				return errTransferAborted
+			case ctx.Err() != nil:
+				t.mu.Unlock()
+				return ctx.Err()
+			case t.waitingForServer(end) && time.Since(t.lastProgress) > transferResumeTimeout:
+				t.mu.Unlock()
+				return errTransferStalled
+			case t.eof && t.eofSent && t.readErr == nil && t.acked == end:
+				t.mu.Unlock()
+				return nil
+			}
+
+			if t.ready && !t.stalled {
+				if t.pos < end {
+					size := end - t.pos
+					if size > transferChunkSize {
+						size = transferChunkSize
+					}
+					start := t.pos - t.acked
+					chunk = &protocol.ResponseChunkPayload{
+						TransferID: t.id,
+						Offset:     t.pos,
+						Data:       t.buf[start : start+size],
+						EOF:        t.eof && t.readErr == nil && t.pos+size == end,
+					}
+					if chunk.EOF {
+						chunk.Trailers = trailerValues(trailer)
+					}
+					break
+				}
+				if t.eof && !t.eofSent {
+					chunk = &protocol.ResponseChunkPayload{TransferID: t.id, Offset: end, EOF: true}
+					if t.readErr != nil {
+						chunk.Error = t.readErr.Error()
+					} else {
+						chunk.Trailers = trailerValues(trailer)
+					}
+					break
+				}
+			}
+			t.cond.Wait()
+		}
+		gen := t.gen
+		t.mu.Unlock()
+
+		chunkMsg := &protocol.Message{
+			Type:      protocol.MessageTypeBusiness,
+			Op:        protocol.OpResponseChunk,
+			ClientID:  a.config.ClientID(),
+			Timestamp: time.Now().UnixMilli(),
+			Payload:   chunk,
+		}
+		chunkMsg.UseCompression(t.compression)
+		err := a.sendMessageWithRetry(chunkMsg)
+
+		t.mu.Lock()
+		if gen == t.gen {
+			if err != nil {
+				httpLog.Errorf("发送响应体分片失败，等待重连后续传: %v", err)
+				t.stalled = true
+			} else {
+				t.pos = chunk.Offset + int64(len(chunk.Data))
+				t.eofSent = chunk.EOF
+			}
+		}
+		readErr := t.readErr
+		t.mu.Unlock()
+		if err == nil && chunk.Error != "" {
+			// 响应体不完整，不需要等待确认
+			return readErr
+		}
+	}
+}
+
+// waitingForServer 是否在等待服务端开始接收、确认或续传，调用方需持有锁
+// 数据已全部确认、只是后端暂时没有新数据时不算等待，长连接的流式响应可以长时间空闲
+func (t *transfer) waitingForServer(end int64) bool {
+	return !t.ready || t.stalled || t.acked < end || (t.eof && !t.eofSent)
+}
+
+// trailerValues 取出后端响应的trailer，每个名称只保留第一个值，没有trailer时返回nil
+func trailerValues(trailer http.Header) map[string]string {
+	var values map[string]string
+	for name, v := range trailer {
+		if len(v) == 0 {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]string)
+		}
+		values[name] = v[0]
+	}
+	return values
+}
+
+// read 读取后端响应体，未确认的数据达到窗口上限时暂停
+func (t *transfer) read(body io.Reader) {
+	p := make([]byte, transferChunkSize)
+	for {
+		t.mu.Lock()
+		for !t.finished && len(t.buf) >= transferWindow*transferChunkSize {
+			t.cond.Wait()
+		}
+		finished := t.finished
+		t.mu.Unlock()
+		if finished {
+			return
+		}
+
+		n, err := body.Read(p)
+		t.mu.Lock()
+		if len(t.buf) == 0 {
+			// 后端空闲期间不计入等待确认的时间
+			t.lastProgress = time.Now()
+		}
+		t.buf = append(t.buf, p[:n]...)
+		if err != nil {
+			t.eof = true
+			if err != io.EOF {
+				t.readErr = err
+			}
+		}
+		t.cond.Broadcast()
+		t.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"strings"
+	"sync"
+)
+
+// unix套接字目标：unix:///var/run/app.sock 表示通过该套接字以HTTP协议访问 /，
+// 请求路径写在套接字路径之后并用冒号分隔（与 nginx 相同），如 unix:///var/run/app.sock:/api/health?full=1
+
+// unixTargetPrefix unix套接字目标的前缀
+const unixTargetPrefix = "unix://"
+
+// unixHostSuffix 转发unix套接字目标时请求URL中使用的主机名后缀，每个套接字路径一个主机名，
+// 连接池按主机名区分，不同套接字的连接不会混用
+const unixHostSuffix = ".unix.invalid"
+
+// unixSockets 请求URL中的主机名到套接字路径
+var unixSockets sync.Map
+
+// isUnixTarget 目标地址是否为unix套接字
+func isUnixTarget(targetURL string) bool {
+	return len(targetURL) >= len(unixTargetPrefix) && strings.EqualFold(targetURL[:len(unixTargetPrefix)], unixTargetPrefix)
+}
+
+// parseUnixTarget 解析unix套接字目标，返回套接字路径和请求路径
+func parseUnixTarget(targetURL string) (socketPath, requestPath string, err error) {
+	rest := targetURL[len(unixTargetPrefix):]
+	socketPath, requestPath, _ = strings.Cut(rest, ":")
+	if !strings.HasPrefix(socketPath, "/") {
+		return "", "", fmt.Errorf("unix socket target must be an absolute path: %s", targetURL)
+	}
+	if requestPath == "" {
+		requestPath = "/"
+	} else if !strings.HasPrefix(requestPath, "/") {
+		return "", "", fmt.Errorf("request path of unix socket target must start with /: %s", targetURL)
+	}
+	return path.Clean(socketPath), requestPath, nil
+}
+
+// unixRequestURL 把unix套接字目标转换为HTTP请求URL，主机名由套接字路径生成，连接时再换回套接字路径
+func unixRequestURL(targetURL string) (string, error) {
+	socketPath, requestPath, err := parseUnixTarget(targetURL)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	h.Write([]byte(socketPath))
+	host := fmt.Sprintf("%x%s", h.Sum64(), unixHostSuffix)
+	unixSockets.Store(host, socketPath)
+	return "http://" + host + requestPath, nil
+}
+
+// unixSocketForHost 请求URL中的主机名对应的套接字路径，addr 为 主机:端口
+func unixSocketForHost(addr string) (string, bool) {
+	host := addr
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		host = addr[:i]
+	}
+	if !strings.HasSuffix(host, unixHostSuffix) {
+		return "", false
+	}
+	socketPath, ok := unixSockets.Load(host)
+	if !ok {
+		return "", false
+	}
+	return socketPath.(string), true
+}
@@ -0,0 +1,110 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// uploadChunkBuffer 每个上传缓存的分片数，缓存满时暂停读取服务端消息，由WebSocket连接形成背压
+const uploadChunkBuffer = 16
+
+// upload 分片到达的请求体
+type upload struct {
+	chunks chan protocol.RequestChunkPayload
+	done   chan struct{} // 请求结束后关闭，之后到达的分片直接丢弃
+	once   sync.Once
+}
+
+// openUpload 登记分片到达的请求体，需要在读取下一条消息前调用，保证分片到达时能找到对应请求
+func (a *Agent) openUpload(msgID string) {
+	a.uploadsMu.Lock()
+	a.uploads[msgID] = &upload{
+		chunks: make(chan protocol.RequestChunkPayload, uploadChunkBuffer),
+		done:   make(chan struct{}),
+	}
+	a.uploadsMu.Unlock()
+}
+
+// lookupUpload 查找请求的分片请求体
+func (a *Agent) lookupUpload(msgID string) *upload {
+	a.uploadsMu.Lock()
+	defer a.uploadsMu.Unlock()
+	return a.uploads[msgID]
+}
+
+// closeUpload 请求结束后调用，丢弃之后到达的分片；不是分片上传的请求直接返回
+func (a *Agent) closeUpload(msgID string) {
+	a.uploadsMu.Lock()
+	u := a.uploads[msgID]
+	delete(a.uploads, msgID)
+	a.uploadsMu.Unlock()
+	if u != nil {
+		u.once.Do(func() { close(u.done) })
+	}
+}
+
+// handleRequestChunk 把分片交给对应的请求，缓存满时等待请求消费
+func (a *Agent) handleRequestChunk(msg *protocol.Message) {
+	if msg.MsgID == nil {
+		return
+	}
+	var chunk protocol.RequestChunkPayload
+	if err := msg.ParsePayload(&chunk); err != nil {
+		httpLog.Errorf("解析请求体分片失败: %v", err)
+		return
+	}
+
+	u := a.lookupUpload(*msg.MsgID)
+	if u == nil {
+		// 请求已经结束
+		return
+	}
+	select {
+	case u.chunks <- chunk:
+	case <-u.done:
+	case <-a.ctx.Done():
+	}
+}
+
+// body 返回按顺序拼接分片的请求体，ctx 取消或请求结束时中断
+func (u *upload) body(ctx context.Context) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		next := 0
+		for {
+			select {
+			case chunk := <-u.chunks:
+				if chunk.Error != "" {
+					pw.CloseWithError(fmt.Errorf("服务端读取请求体失败: %s", chunk.Error))
+					return
+				}
+				if chunk.Seq != next {
+					pw.CloseWithError(fmt.Errorf("请求体分片顺序错误: 期望%d, 收到%d", next, chunk.Seq))
+					return
+				}
+				next++
+				if len(chunk.Data) > 0 {
+					if _, err := pw.Write(chunk.Data); err != nil {
+						// 请求已不再读取请求体
+						return
+					}
+				}
+				if chunk.EOF {
+					pw.Close()
+					return
+				}
+			case <-u.done:
+				pw.CloseWithError(io.ErrUnexpectedEOF)
+				return
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+		}
+	}()
+	return pr
+}
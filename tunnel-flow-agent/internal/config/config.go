@@ -2,20 +2,26 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"tunnel-flow-agent/internal/protocol"
 )
 
 // Config 配置结构
 type Config struct {
 	// 服务器配置
 	Server struct {
-		URL string `yaml:"url" json:"server_url"`
+		URL  string   `yaml:"url" json:"server_url"`
+		URLs []string `yaml:"urls" json:"server_urls"` // 多个服务器地址，配置后代替 url
+		Mode string   `yaml:"mode" json:"mode"`        // 多个服务器时的连接方式：active-standby 或 active-active
 	} `yaml:"server"`
 
 	// 客户端配置
@@ -28,11 +34,134 @@ type Config struct {
 	SSL struct {
 		InsecureSkipVerify bool `yaml:"insecure_skip_verify" json:"insecure_skip_verify"`
 	} `yaml:"ssl"`
+
+	// 载荷加密配置，开启后注册时与服务端协商密钥，请求和响应在WebSocket消息中加密传输
+	Encryption struct {
+		Enabled bool `yaml:"enabled" json:"enabled"`
+	} `yaml:"encryption"`
+
+	// WebSocket消息配置
+	WebSocket struct {
+		MaxMessageBytes int `yaml:"max_message_bytes" json:"max_message_bytes"` // 要求服务端发送的最大消息大小，0表示由服务端决定
+	} `yaml:"websocket"`
+
+	// 运行状态上报配置
+	Stats struct {
+		ReportIntervalSeconds  int `yaml:"report_interval_seconds" json:"report_interval_seconds"`   // 上报间隔，0表示不上报
+		MetricsIntervalSeconds int `yaml:"metrics_interval_seconds" json:"metrics_interval_seconds"` // 计数器上报间隔，0表示不上报
+	} `yaml:"stats"`
+
+	// 停止配置
+	Shutdown struct {
+		DrainTimeoutSeconds int `yaml:"drain_timeout_seconds" json:"drain_timeout_seconds"` // 停止时等待处理中请求完成的最长时间，0表示不等待
+	} `yaml:"shutdown"`
+
+	// 访问日志配置
+	AccessLog struct {
+		Enabled    bool   `yaml:"enabled" json:"enabled"`
+		Path       string `yaml:"path" json:"path"`
+		MaxSizeMB  int    `yaml:"max_size_mb" json:"max_size_mb"` // 单个文件的最大大小，超过后轮转
+		MaxBackups int    `yaml:"max_backups" json:"max_backups"` // 保留的轮转文件数
+	} `yaml:"access_log"`
+
+	// 响应缓存配置，只缓存服务端标记为可缓存的路由的响应
+	Cache struct {
+		Enabled    bool `yaml:"enabled" json:"enabled"`
+		MaxEntries int  `yaml:"max_entries" json:"max_entries"` // 缓存的响应数上限
+		MaxSizeMB  int  `yaml:"max_size_mb" json:"max_size_mb"` // 缓存的响应体总大小上限
+	} `yaml:"cache"`
+
+	// 请求过滤配置，转发前检查，服务端路由配置错误时也不会把请求转发到本地的敏感接口
+	Filter struct {
+		Deny []DenyRule `yaml:"deny" json:"deny"`
+	} `yaml:"filter"`
+
+	// 目标主机名映射，连接目标时把主机名换成映射的地址（可带端口），用于代理所在网络的DNS无法解析目标主机名的情况
+	Hosts map[string]string `yaml:"hosts" json:"hosts"`
+
+	// 出站代理配置，代理所在主机只能通过企业HTTP代理或SOCKS5代理访问外网时使用
+	Proxy struct {
+		URL      string   `yaml:"url" json:"url"` // 例如 http://proxy.example.com:3128 或 socks5://proxy.example.com:1080，为空表示不使用代理
+		Username string   `yaml:"username" json:"username"`
+		Password string   `yaml:"password" json:"password"`
+		NoProxy  []string `yaml:"no_proxy" json:"no_proxy"` // 不经过代理的主机：主机名、.example.com 形式的域名后缀、IP、CIDR 或 *
+		Targets  bool     `yaml:"targets" json:"targets"`   // 转发到目标的请求也经过代理
+	} `yaml:"proxy"`
+
+	// 声明要暴露的路由，见 routes.go
+	Routes []Route `yaml:"routes" json:"routes"`
+
+	// mu 保护可在运行时修改的配置项
+	mu sync.RWMutex
+}
+
+// 多个服务器时的连接方式
+const (
+	ServerModeActiveStandby = "active-standby" // 同一时间只连接一个服务器，连接失败时依次切换到下一个
+	ServerModeActiveActive  = "active-active"  // 同时连接所有服务器，各自独立注册
+)
+
+// Effective 生效中的配置，认证Token已隐去
+type Effective struct {
+	ServerURL                  string            `json:"server_url"`
+	ServerURLs                 []string          `json:"server_urls"`
+	ServerMode                 string            `json:"server_mode"`
+	ClientID                   string            `json:"client_id"`
+	AuthToken                  string            `json:"auth_token"`
+	SSLInsecureSkipVerify      bool              `json:"ssl_insecure_skip_verify"`
+	EncryptionEnabled          bool              `json:"encryption_enabled"`
+	WebSocketMaxMessageBytes   int               `json:"websocket_max_message_bytes"`
+	StatsReportIntervalSeconds int               `json:"stats_report_interval_seconds"`
+	MetricsIntervalSeconds     int               `json:"metrics_interval_seconds"`
+	DrainTimeoutSeconds        int               `json:"drain_timeout_seconds"`
+	ReconnectIntervalMS        int64             `json:"reconnect_interval_ms"`
+	PingIntervalMS             int64             `json:"ping_interval_ms"`
+	PingTimeoutMS              int64             `json:"ping_timeout_ms"`
+	HTTPTimeoutMS              int64             `json:"http_timeout_ms"`
+	MaxRetries                 int               `json:"max_retries"`
+	RetryDelayMS               int               `json:"retry_delay_ms"`
+	WorkerPoolSize             int               `json:"worker_pool_size"`
+	SendQueueSize              int               `json:"send_queue_size"`
+	MonitoringPort             int               `json:"monitoring_port"`
+	AccessLogPath              string            `json:"access_log_path"` // 未开启访问日志时为空
+	CacheEnabled               bool              `json:"cache_enabled"`
+	CacheMaxEntries            int               `json:"cache_max_entries"`
+	CacheMaxSizeMB             int               `json:"cache_max_size_mb"`
+	DenyRules                  []DenyRule        `json:"deny_rules,omitempty"`
+	Hosts                      map[string]string `json:"hosts,omitempty"`
+	ProxyURL                   string            `json:"proxy_url,omitempty"` // 不含认证信息
+	NoProxy                    []string          `json:"no_proxy,omitempty"`
+	ProxyTargets               bool              `json:"proxy_targets"`
+	Routes                     []Route           `json:"routes,omitempty"`
+}
+
+// RuntimeUpdate 运行时可修改的配置项，为nil的字段保持不变
+type RuntimeUpdate struct {
+	StatsReportIntervalSeconds *int `json:"stats_report_interval_seconds"`
+	MetricsIntervalSeconds     *int `json:"metrics_interval_seconds"`
+	DrainTimeoutSeconds        *int `json:"drain_timeout_seconds"`
 }
 
 // 配置访问方法
+// ServerURL 主服务器地址，配置了多个服务器时为第一个
 func (c *Config) ServerURL() string {
-	return c.Server.URL
+	return c.ServerURLs()[0]
+}
+
+// ServerURLs 所有服务器地址，active-standby 模式下按顺序作为主服务器和备用服务器
+func (c *Config) ServerURLs() []string {
+	if len(c.Server.URLs) > 0 {
+		return c.Server.URLs
+	}
+	return []string{c.Server.URL}
+}
+
+// ServerMode 多个服务器时的连接方式，默认 active-standby
+func (c *Config) ServerMode() string {
+	if c.Server.Mode == "" {
+		return ServerModeActiveStandby
+	}
+	return c.Server.Mode
 }
 
 func (c *Config) ClientID() string {
@@ -47,9 +176,205 @@ func (c *Config) SSLInsecureSkipVerify() bool {
 	return c.SSL.InsecureSkipVerify
 }
 
+// EncryptionEnabled 是否在注册时协商密钥加密消息载荷
+func (c *Config) EncryptionEnabled() bool {
+	return c.Encryption.Enabled
+}
+
+// WebSocketMaxMessageBytes 注册时要求服务端发送的最大消息大小，0表示由服务端决定
+func (c *Config) WebSocketMaxMessageBytes() int {
+	return c.WebSocket.MaxMessageBytes
+}
+
+// StatsReportInterval 运行状态上报间隔，返回0表示不上报
+func (c *Config) StatsReportInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Stats.ReportIntervalSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.Stats.ReportIntervalSeconds) * time.Second
+}
+
+// MetricsInterval 计数器上报间隔，返回0表示不上报
+func (c *Config) MetricsInterval() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Stats.MetricsIntervalSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.Stats.MetricsIntervalSeconds) * time.Second
+}
+
+// DrainTimeout 停止时等待处理中请求完成的最长时间
+func (c *Config) DrainTimeout() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Shutdown.DrainTimeoutSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(c.Shutdown.DrainTimeoutSeconds) * time.Second
+}
+
+// Effective 返回生效中的配置，用于监控接口展示
+func (c *Config) Effective() Effective {
+	c.mu.RLock()
+	statsInterval := c.Stats.ReportIntervalSeconds
+	metricsInterval := c.Stats.MetricsIntervalSeconds
+	drainTimeout := c.Shutdown.DrainTimeoutSeconds
+	c.mu.RUnlock()
+
+	token := ""
+	if c.Client.AuthToken != "" {
+		token = "******"
+	}
+	proxyURL := ""
+	if u := c.ProxyURL(); u != nil {
+		proxyURL = u.Redacted()
+	}
+	return Effective{
+		ServerURL:                  c.ServerURL(),
+		ServerURLs:                 c.ServerURLs(),
+		ServerMode:                 c.ServerMode(),
+		ClientID:                   c.ClientID(),
+		AuthToken:                  token,
+		SSLInsecureSkipVerify:      c.SSLInsecureSkipVerify(),
+		EncryptionEnabled:          c.EncryptionEnabled(),
+		WebSocketMaxMessageBytes:   c.WebSocketMaxMessageBytes(),
+		StatsReportIntervalSeconds: statsInterval,
+		MetricsIntervalSeconds:     metricsInterval,
+		DrainTimeoutSeconds:        drainTimeout,
+		ReconnectIntervalMS:        c.ReconnectInterval().Milliseconds(),
+		PingIntervalMS:             c.PingInterval().Milliseconds(),
+		PingTimeoutMS:              c.PingTimeout().Milliseconds(),
+		HTTPTimeoutMS:              c.HTTPTimeout().Milliseconds(),
+		MaxRetries:                 c.MaxRetries(),
+		RetryDelayMS:               c.RetryDelayMS(),
+		WorkerPoolSize:             c.WorkerPoolSize(),
+		SendQueueSize:              c.SendQueueSize(),
+		MonitoringPort:             c.MonitoringPort(),
+		AccessLogPath:              c.AccessLogPath(),
+		CacheEnabled:               c.Cache.Enabled,
+		CacheMaxEntries:            c.CacheSize(),
+		CacheMaxSizeMB:             c.Cache.MaxSizeMB,
+		DenyRules:                  c.Filter.Deny,
+		Hosts:                      c.Hosts,
+		ProxyURL:                   proxyURL,
+		NoProxy:                    c.Proxy.NoProxy,
+		ProxyTargets:               c.Proxy.Targets,
+		Routes:                     c.Routes,
+	}
+}
+
+// ProxyURL 出站代理地址，配置了用户名时带上认证信息；未配置代理时返回nil
+func (c *Config) ProxyURL() *url.URL {
+	if c.Proxy.URL == "" {
+		return nil
+	}
+	proxyURL, err := url.Parse(c.Proxy.URL)
+	if err != nil {
+		return nil
+	}
+	if c.Proxy.Username != "" {
+		proxyURL.User = url.UserPassword(c.Proxy.Username, c.Proxy.Password)
+	}
+	return proxyURL
+}
+
+// ProxyTargets 转发到目标的请求是否经过出站代理
+func (c *Config) ProxyTargets() bool {
+	return c.Proxy.URL != "" && c.Proxy.Targets
+}
+
+// NoProxy 连接该主机时是否绕过出站代理
+func (c *Config) NoProxy(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range c.Proxy.NoProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case host == strings.TrimPrefix(entry, "."):
+			return true
+		case ip == nil && strings.HasSuffix(host, "."+strings.TrimPrefix(entry, ".")):
+			return true
+		}
+	}
+	return false
+}
+
+// MapHost 按主机名映射改写要连接的地址（host:port），没有映射时返回false
+func (c *Config) MapHost(addr string) (string, bool) {
+	if len(c.Hosts) == 0 {
+		return addr, false
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, false
+	}
+	mapped, exists := c.Hosts[strings.ToLower(host)]
+	if !exists {
+		return addr, false
+	}
+	if _, _, err := net.SplitHostPort(mapped); err == nil {
+		return mapped, true
+	}
+	return net.JoinHostPort(mapped, port), true
+}
+
+// AccessLogPath 访问日志文件路径，返回空表示不记录访问日志
+func (c *Config) AccessLogPath() string {
+	if !c.AccessLog.Enabled {
+		return ""
+	}
+	return c.AccessLog.Path
+}
+
+// CacheEnabled 是否缓存可缓存路由的响应
+func (c *Config) CacheEnabled() bool {
+	return c.Cache.Enabled
+}
+
+// CacheMaxBytes 缓存的响应体总大小上限
+func (c *Config) CacheMaxBytes() int {
+	return c.Cache.MaxSizeMB * 1024 * 1024
+}
+
+// ApplyRuntimeUpdate 修改运行时可调整的配置项，只在内存中生效，重启后恢复为配置文件的值
+func (c *Config) ApplyRuntimeUpdate(update RuntimeUpdate) error {
+	if update.StatsReportIntervalSeconds != nil && *update.StatsReportIntervalSeconds < 0 {
+		return fmt.Errorf("stats_report_interval_seconds 不能小于0")
+	}
+	if update.MetricsIntervalSeconds != nil && *update.MetricsIntervalSeconds < 0 {
+		return fmt.Errorf("metrics_interval_seconds 不能小于0")
+	}
+	if update.DrainTimeoutSeconds != nil && *update.DrainTimeoutSeconds < 0 {
+		return fmt.Errorf("drain_timeout_seconds 不能小于0")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if update.StatsReportIntervalSeconds != nil {
+		c.Stats.ReportIntervalSeconds = *update.StatsReportIntervalSeconds
+	}
+	if update.MetricsIntervalSeconds != nil {
+		c.Stats.MetricsIntervalSeconds = *update.MetricsIntervalSeconds
+	}
+	if update.DrainTimeoutSeconds != nil {
+		c.Shutdown.DrainTimeoutSeconds = *update.DrainTimeoutSeconds
+	}
+	return nil
+}
+
 // UseSSL 根据WebSocket URL的协议类型判断是否使用SSL
 func (c *Config) UseSSL() bool {
-	u, err := url.Parse(c.Server.URL)
+	u, err := url.Parse(c.ServerURL())
 	if err != nil {
 		return false
 	}
@@ -58,7 +383,7 @@ func (c *Config) UseSSL() bool {
 
 // 以下方法提供默认值，保持向后兼容
 func (c *Config) ServerHost() string {
-	u, err := url.Parse(c.Server.URL)
+	u, err := url.Parse(c.ServerURL())
 	if err != nil {
 		return "localhost"
 	}
@@ -66,7 +391,7 @@ func (c *Config) ServerHost() string {
 }
 
 func (c *Config) ServerPort() int {
-	u, err := url.Parse(c.Server.URL)
+	u, err := url.Parse(c.ServerURL())
 	if err != nil {
 		return 8081
 	}
@@ -157,7 +482,7 @@ func (c *Config) BatchTimeoutMS() int {
 }
 
 func (c *Config) CacheSize() int {
-	return 1000
+	return c.Cache.MaxEntries
 }
 
 func (c *Config) CacheTTLSeconds() int {
@@ -194,6 +519,16 @@ func setDefaults(config *Config) {
 	config.Server.URL = "ws://localhost:8081/ws"
 	config.Client.ID = ""
 	config.Client.AuthToken = ""
+	config.Stats.ReportIntervalSeconds = 30
+	config.Stats.MetricsIntervalSeconds = 10
+	config.Shutdown.DrainTimeoutSeconds = 30
+	config.AccessLog.Enabled = true
+	config.AccessLog.Path = "logs/access.log"
+	config.AccessLog.MaxSizeMB = 100
+	config.AccessLog.MaxBackups = 5
+	config.Cache.Enabled = true
+	config.Cache.MaxEntries = 1000
+	config.Cache.MaxSizeMB = 64
 }
 
 // loadFromFile 从文件加载配置
@@ -218,6 +553,18 @@ func loadFromFile(config *Config, filename string) error {
 func loadFromEnv(config *Config) {
 	if serverURL := getEnv("SERVER_URL", ""); serverURL != "" {
 		config.Server.URL = serverURL
+		config.Server.URLs = nil
+	}
+	if serverURLs := getEnv("SERVER_URLS", ""); serverURLs != "" {
+		config.Server.URLs = nil
+		for _, serverURL := range strings.Split(serverURLs, ",") {
+			if serverURL = strings.TrimSpace(serverURL); serverURL != "" {
+				config.Server.URLs = append(config.Server.URLs, serverURL)
+			}
+		}
+	}
+	if mode := getEnv("SERVER_MODE", ""); mode != "" {
+		config.Server.Mode = mode
 	}
 	if clientID := getEnv("CLIENT_ID", ""); clientID != "" {
 		config.Client.ID = clientID
@@ -225,6 +572,73 @@ func loadFromEnv(config *Config) {
 	if authToken := getEnv("AUTH_TOKEN", ""); authToken != "" {
 		config.Client.AuthToken = authToken
 	}
+	if interval := os.Getenv("STATS_REPORT_INTERVAL"); interval != "" {
+		if seconds, err := strconv.Atoi(interval); err == nil {
+			config.Stats.ReportIntervalSeconds = seconds
+		}
+	}
+	if interval := os.Getenv("METRICS_INTERVAL"); interval != "" {
+		if seconds, err := strconv.Atoi(interval); err == nil {
+			config.Stats.MetricsIntervalSeconds = seconds
+		}
+	}
+	if timeout := os.Getenv("DRAIN_TIMEOUT_SECONDS"); timeout != "" {
+		if seconds, err := strconv.Atoi(timeout); err == nil {
+			config.Shutdown.DrainTimeoutSeconds = seconds
+		}
+	}
+	config.Encryption.Enabled = getEnvBool("ENCRYPTION_ENABLED", config.Encryption.Enabled)
+	if maxBytes := os.Getenv("WEBSOCKET_MAX_MESSAGE_BYTES"); maxBytes != "" {
+		if value, err := strconv.Atoi(maxBytes); err == nil {
+			config.WebSocket.MaxMessageBytes = value
+		}
+	}
+	config.AccessLog.Enabled = getEnvBool("ACCESS_LOG_ENABLED", config.AccessLog.Enabled)
+	if path := getEnv("ACCESS_LOG_PATH", ""); path != "" {
+		config.AccessLog.Path = path
+	}
+	config.Cache.Enabled = getEnvBool("CACHE_ENABLED", config.Cache.Enabled)
+	if entries := getEnvInt("CACHE_MAX_ENTRIES"); entries > 0 {
+		config.Cache.MaxEntries = entries
+	}
+	if size := getEnvInt("CACHE_MAX_SIZE_MB"); size > 0 {
+		config.Cache.MaxSizeMB = size
+	}
+	// DENY_PATH_PREFIXES 格式为 /admin,/actuator，追加一条只按路径前缀拒绝的规则
+	if prefixes := getEnv("DENY_PATH_PREFIXES", ""); prefixes != "" {
+		config.Filter.Deny = append(config.Filter.Deny, DenyRule{PathPrefixes: strings.Split(prefixes, ",")})
+	}
+	// HOSTS 格式为 api.internal=10.0.0.5,db.internal=10.0.0.6:5432
+	if hosts := getEnv("HOSTS", ""); hosts != "" {
+		config.Hosts = make(map[string]string)
+		for _, entry := range strings.Split(hosts, ",") {
+			if host, mapped, ok := strings.Cut(strings.TrimSpace(entry), "="); ok {
+				config.Hosts[strings.TrimSpace(host)] = strings.TrimSpace(mapped)
+			}
+		}
+	}
+
+	if proxyURL := getEnv("PROXY_URL", ""); proxyURL != "" {
+		config.Proxy.URL = proxyURL
+	}
+	if username := getEnv("PROXY_USERNAME", ""); username != "" {
+		config.Proxy.Username = username
+	}
+	if password := getEnv("PROXY_PASSWORD", ""); password != "" {
+		config.Proxy.Password = password
+	}
+	if noProxy := getEnv("NO_PROXY", ""); noProxy != "" {
+		config.Proxy.NoProxy = strings.Split(noProxy, ",")
+	}
+	config.Proxy.Targets = getEnvBool("PROXY_TARGETS", config.Proxy.Targets)
+
+	// 主机名不区分大小写
+	for host, mapped := range config.Hosts {
+		if lower := strings.ToLower(host); lower != host {
+			delete(config.Hosts, host)
+			config.Hosts[lower] = mapped
+		}
+	}
 }
 
 // validateConfig 验证配置
@@ -235,8 +649,43 @@ func validateConfig(config *Config) error {
 	if config.Client.AuthToken == "" {
 		return fmt.Errorf("认证Token不能为空，请在配置文件中设置client.auth_token或通过环境变量AUTH_TOKEN设置")
 	}
-	if config.Server.URL == "" {
-		return fmt.Errorf("服务器URL不能为空")
+	for _, serverURL := range config.ServerURLs() {
+		if serverURL == "" {
+			return fmt.Errorf("服务器URL不能为空")
+		}
+	}
+	if config.Cache.Enabled && (config.Cache.MaxEntries <= 0 || config.Cache.MaxSizeMB <= 0) {
+		return fmt.Errorf("开启响应缓存时 cache.max_entries 和 cache.max_size_mb 必须大于0")
+	}
+	if limit := config.WebSocket.MaxMessageBytes; limit < 0 || (limit > 0 && limit < protocol.MinMaxMessageBytes) {
+		return fmt.Errorf("websocket.max_message_bytes 必须为0或不小于%d", protocol.MinMaxMessageBytes)
+	}
+	for i := range config.Filter.Deny {
+		if err := config.Filter.Deny[i].compile(); err != nil {
+			return fmt.Errorf("第%d条拒绝规则无效: %w", i+1, err)
+		}
+	}
+	for host, mapped := range config.Hosts {
+		if host == "" || mapped == "" {
+			return fmt.Errorf("主机名映射不完整: %q -> %q", host, mapped)
+		}
+	}
+	if config.Proxy.URL != "" {
+		proxyURL, err := url.Parse(config.Proxy.URL)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("出站代理地址无效: %s", config.Proxy.URL)
+		}
+		if proxyURL.Scheme != "http" && proxyURL.Scheme != "socks5" {
+			return fmt.Errorf("不支持的出站代理协议: %s，只支持 http 和 socks5", proxyURL.Scheme)
+		}
+	}
+	if err := validateRoutes(config.Routes); err != nil {
+		return err
+	}
+	switch config.ServerMode() {
+	case ServerModeActiveStandby, ServerModeActiveActive:
+	default:
+		return fmt.Errorf("不支持的服务器连接方式: %s，可选 %s 或 %s", config.Server.Mode, ServerModeActiveStandby, ServerModeActiveActive)
 	}
 	return nil
 }
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// DenyRule 请求拒绝规则，设置的条件全部满足时拒绝转发，至少需要设置一个条件
+type DenyRule struct {
+	PathPrefixes []string          `yaml:"path_prefixes" json:"path_prefixes,omitempty"` // 目标地址的路径前缀，不区分大小写
+	Methods      []string          `yaml:"methods" json:"methods,omitempty"`
+	Headers      map[string]string `yaml:"headers" json:"headers,omitempty"` // 请求头名称 -> 正则表达式，请求头存在且值匹配时满足
+
+	headerPatterns map[string]*regexp.Regexp
+}
+
+// compile 校验规则并编译请求头的正则表达式
+func (r *DenyRule) compile() error {
+	if len(r.PathPrefixes) == 0 && len(r.Methods) == 0 && len(r.Headers) == 0 {
+		return fmt.Errorf("拒绝规则至少需要设置 path_prefixes、methods 或 headers 中的一项")
+	}
+	for i, prefix := range r.PathPrefixes {
+		if r.PathPrefixes[i] = strings.TrimSpace(prefix); r.PathPrefixes[i] == "" {
+			return fmt.Errorf("拒绝规则的路径前缀不能为空")
+		}
+	}
+	r.headerPatterns = make(map[string]*regexp.Regexp, len(r.Headers))
+	for name, pattern := range r.Headers {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("拒绝规则中请求头 %s 的正则表达式无效: %w", name, err)
+		}
+		r.headerPatterns[name] = re
+	}
+	return nil
+}
+
+// String 规则的简要描述，用于日志
+func (r *DenyRule) String() string {
+	var parts []string
+	if len(r.PathPrefixes) > 0 {
+		parts = append(parts, "path_prefixes="+strings.Join(r.PathPrefixes, ","))
+	}
+	if len(r.Methods) > 0 {
+		parts = append(parts, "methods="+strings.Join(r.Methods, ","))
+	}
+	for name, pattern := range r.Headers {
+		parts = append(parts, fmt.Sprintf("header %s~%s", name, pattern))
+	}
+	return strings.Join(parts, " ")
+}
+
+// matches 请求是否满足规则的所有条件，requestPath 为已规范化的路径
+func (r *DenyRule) matches(method, requestPath string, header http.Header) bool {
+	if len(r.Methods) > 0 && !containsFold(r.Methods, method) {
+		return false
+	}
+	if len(r.PathPrefixes) > 0 {
+		matched := false
+		for _, prefix := range r.PathPrefixes {
+			if strings.HasPrefix(requestPath, strings.ToLower(prefix)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	for name, re := range r.headerPatterns {
+		matched := false
+		for _, value := range header.Values(name) {
+			if re.MatchString(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// DenyRequest 按拒绝规则检查要转发的请求，返回匹配的规则
+// 路径取目标地址中的路径，先去掉 . 和 .. 再比较，避免绕过前缀
+func (c *Config) DenyRequest(method, targetURL string, header http.Header) (*DenyRule, bool) {
+	if len(c.Filter.Deny) == 0 {
+		return nil, false
+	}
+	requestPath := "/"
+	if u, err := url.Parse(targetURL); err == nil && u.Path != "" {
+		requestPath = path.Clean("/" + u.Path)
+		if strings.HasSuffix(u.Path, "/") && requestPath != "/" {
+			requestPath += "/"
+		}
+	}
+	requestPath = strings.ToLower(requestPath)
+	for i := range c.Filter.Deny {
+		if rule := &c.Filter.Deny[i]; rule.matches(method, requestPath, header) {
+			return rule, true
+		}
+	}
+	return nil, false
+}
+
+// containsFold 列表中是否有不区分大小写相同的值
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(strings.TrimSpace(v), value) {
+			return true
+		}
+	}
+	return false
+}
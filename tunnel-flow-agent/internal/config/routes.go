@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// Route 配置中声明的路由，注册成功后上报给服务端，服务端开启 agent.allow_routes 时创建或更新对应的路由，
+// 从配置中删除的路由在下次注册时由服务端删除
+type Route struct {
+	URLSuffix   string `yaml:"url_suffix" json:"url_suffix"`
+	Target      string `yaml:"target" json:"target"`                     // 目标地址，http(s):// 或 unix://
+	ListenPort  int    `yaml:"listen_port" json:"listen_port,omitempty"` // 独立监听端口，必须是服务端为该客户端预留的端口
+	Description string `yaml:"description" json:"description,omitempty"`
+}
+
+// validateRoutes 检查声明的路由，路径不能重复
+func validateRoutes(routes []Route) error {
+	seen := make(map[string]bool, len(routes))
+	for i, route := range routes {
+		if !strings.HasPrefix(route.URLSuffix, "/") {
+			return fmt.Errorf("第%d条路由的 url_suffix 必须以 / 开头: %q", i+1, route.URLSuffix)
+		}
+		if seen[route.URLSuffix] {
+			return fmt.Errorf("路由 %s 重复声明", route.URLSuffix)
+		}
+		seen[route.URLSuffix] = true
+		if route.Target == "" {
+			return fmt.Errorf("路由 %s 没有设置 target", route.URLSuffix)
+		}
+		if route.ListenPort < 0 || route.ListenPort > 65535 {
+			return fmt.Errorf("路由 %s 的 listen_port 必须在1到65535之间", route.URLSuffix)
+		}
+	}
+	return nil
+}
+
+// DeclaredRoutes 注册后上报给服务端的声明路由，没有声明时返回空列表
+func (c *Config) DeclaredRoutes() []protocol.DeclaredRoute {
+	routes := make([]protocol.DeclaredRoute, 0, len(c.Routes))
+	for _, route := range c.Routes {
+		routes = append(routes, protocol.DeclaredRoute{
+			URLSuffix:   route.URLSuffix,
+			Target:      route.Target,
+			ListenPort:  route.ListenPort,
+			Description: route.Description,
+		})
+	}
+	return routes
+}
@@ -216,8 +216,8 @@ func (l *Logger) WithComponent(component string) *Logger {
 	return newLogger
 }
 
-// log 写入日志
-func (l *Logger) log(level LogLevel, message string) {
+// log 写入日志，extra 为只属于本条日志的字段
+func (l *Logger) log(level LogLevel, message string, extra map[string]interface{}) {
 	if level < l.level {
 		return
 	}
@@ -230,11 +230,14 @@ func (l *Logger) log(level LogLevel, message string) {
 	}
 	
 	// 添加字段
-	if len(l.fields) > 0 {
+	if len(l.fields) > 0 || len(extra) > 0 {
 		entry.Fields = make(map[string]interface{})
 		for k, v := range l.fields {
 			entry.Fields[k] = v
 		}
+		for k, v := range extra {
+			entry.Fields[k] = v
+		}
 	}
 	
 	// 添加调用者信息
@@ -386,53 +389,59 @@ func (l *Logger) cleanupOldFiles() {
 
 // Debug 调试日志
 func (l *Logger) Debug(message string) {
-	l.log(DEBUG, message)
+	l.log(DEBUG, message, nil)
 }
 
 // Debugf 格式化调试日志
 func (l *Logger) Debugf(format string, args ...interface{}) {
-	l.log(DEBUG, fmt.Sprintf(format, args...))
+	l.log(DEBUG, fmt.Sprintf(format, args...), nil)
 }
 
 // Info 信息日志
 func (l *Logger) Info(message string) {
-	l.log(INFO, message)
+	l.log(INFO, message, nil)
 }
 
 // Infof 格式化信息日志
 func (l *Logger) Infof(format string, args ...interface{}) {
-	l.log(INFO, fmt.Sprintf(format, args...))
+	l.log(INFO, fmt.Sprintf(format, args...), nil)
+}
+
+// InfoWithFields 带字段的信息日志
+// 与 WithFields 不同，不创建新的日志器，由当前日志器直接写入，适合高频写入同一文件并需要轮转的场景
+func (l *Logger) InfoWithFields(message string, fields map[string]interface{}) {
+	l.log(INFO, message, fields)
 }
 
 // Warn 警告日志
 func (l *Logger) Warn(message string) {
-	l.log(WARN, message)
+	l.log(WARN, message, nil)
 }
 
 // Warnf 格式化警告日志
 func (l *Logger) Warnf(format string, args ...interface{}) {
-	l.log(WARN, fmt.Sprintf(format, args...))
+	l.log(WARN, fmt.Sprintf(format, args...), nil)
 }
 
 // Error 错误日志
 func (l *Logger) Error(message string) {
-	l.log(ERROR, message)
+	l.log(ERROR, message, nil)
 }
 
 // Errorf 格式化错误日志
 func (l *Logger) Errorf(format string, args ...interface{}) {
-	l.log(ERROR, fmt.Sprintf(format, args...))
+	l.log(ERROR, fmt.Sprintf(format, args...), nil)
 }
 
 // Fatal 致命错误日志
 func (l *Logger) Fatal(message string) {
-	l.log(FATAL, message)
+	l.log(FATAL, message, nil)
 	os.Exit(1)
 }
 
 // Fatalf 格式化致命错误日志
 func (l *Logger) Fatalf(format string, args ...interface{}) {
-	l.log(FATAL, fmt.Sprintf(format, args...))
+	l.log(FATAL, fmt.Sprintf(format, args...), nil)
 	os.Exit(1)
 }
 
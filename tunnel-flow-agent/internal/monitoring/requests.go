@@ -0,0 +1,68 @@
+package monitoring
+
+import (
+	"sync"
+	"time"
+)
+
+// recentRequestLimit 保留的最近请求数
+const recentRequestLimit = 50
+
+// RecentRequest 一次转发到本地目标的请求
+type RecentRequest struct {
+	Time      time.Time `json:"time"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	Target    string    `json:"target"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// RequestLog 保存最近的请求，超过上限时覆盖最早的记录
+type RequestLog struct {
+	mu      sync.Mutex
+	entries []RecentRequest
+	next    int
+}
+
+// NewRequestLog 创建最近请求记录
+func NewRequestLog() *RequestLog {
+	return &RequestLog{entries: make([]RecentRequest, 0, recentRequestLimit)}
+}
+
+// Record 记录一次请求
+func (l *RequestLog) Record(method, path, target string, status int, err error, latency time.Duration) {
+	entry := RecentRequest{
+		Time:      time.Now(),
+		Method:    method,
+		Path:      path,
+		Target:    target,
+		Status:    status,
+		LatencyMS: latency.Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.entries) < recentRequestLimit {
+		l.entries = append(l.entries, entry)
+		return
+	}
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % recentRequestLimit
+}
+
+// Snapshot 返回最近请求的副本，最新的在前
+func (l *RequestLog) Snapshot() []RecentRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]RecentRequest, 0, len(l.entries))
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		result = append(result, l.entries[(l.next+i)%len(l.entries)])
+	}
+	return result
+}
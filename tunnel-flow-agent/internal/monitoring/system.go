@@ -0,0 +1,127 @@
+package monitoring
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SystemStats 主机资源使用情况
+type SystemStats struct {
+	CPUPercent         float64
+	CPUCores           int
+	MemoryTotalBytes   uint64
+	MemoryUsedBytes    uint64
+	ProcessMemoryBytes uint64
+	Goroutines         int
+}
+
+// SystemSampler 主机资源采样器
+// CPU使用率根据两次采样之间 /proc/stat 的差值计算，非Linux系统只提供进程级指标
+type SystemSampler struct {
+	mu        sync.Mutex
+	lastIdle  uint64
+	lastTotal uint64
+}
+
+// NewSystemSampler 创建主机资源采样器
+func NewSystemSampler() *SystemSampler {
+	s := &SystemSampler{}
+	s.lastIdle, s.lastTotal, _ = readCPUTimes()
+	return s
+}
+
+// Sample 采集一次主机资源使用情况
+func (s *SystemSampler) Sample() SystemStats {
+	stats := SystemStats{
+		CPUCores:   runtime.NumCPU(),
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	stats.ProcessMemoryBytes = memStats.Sys
+
+	if idle, total, ok := readCPUTimes(); ok {
+		s.mu.Lock()
+		if total > s.lastTotal && s.lastTotal > 0 {
+			deltaTotal := total - s.lastTotal
+			deltaIdle := idle - s.lastIdle
+			stats.CPUPercent = float64(deltaTotal-deltaIdle) / float64(deltaTotal) * 100
+		}
+		s.lastIdle, s.lastTotal = idle, total
+		s.mu.Unlock()
+	}
+
+	if total, available, ok := readMemInfo(); ok {
+		stats.MemoryTotalBytes = total
+		stats.MemoryUsedBytes = total - available
+	}
+
+	return stats
+}
+
+// readCPUTimes 读取 /proc/stat 中的CPU累计时间，返回空闲时间和总时间
+func readCPUTimes() (idle, total uint64, ok bool) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, 0, false
+			}
+			total += value
+			// idle 和 iowait 都计为空闲
+			if i == 3 || i == 4 {
+				idle += value
+			}
+		}
+		return idle, total, true
+	}
+	return 0, 0, false
+}
+
+// readMemInfo 读取 /proc/meminfo 中的内存总量和可用量（字节）
+func readMemInfo() (total, available uint64, ok bool) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	var foundTotal, foundAvailable bool
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, foundTotal = value*1024, true
+		case "MemAvailable:":
+			available, foundAvailable = value*1024, true
+		}
+	}
+	if !foundTotal || !foundAvailable || available > total {
+		return 0, 0, false
+	}
+	return total, available, true
+}
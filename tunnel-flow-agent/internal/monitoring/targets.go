@@ -0,0 +1,73 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"tunnel-flow-agent/internal/protocol"
+)
+
+// targetStaleAfter 超过该时间没有转发的目标不再上报
+const targetStaleAfter = time.Hour
+
+// TargetTracker 根据实际转发结果记录本地目标的健康状态
+type TargetTracker struct {
+	mu      sync.Mutex
+	targets map[string]*protocol.TargetHealth
+}
+
+// NewTargetTracker 创建目标健康状态记录器
+func NewTargetTracker() *TargetTracker {
+	return &TargetTracker{
+		targets: make(map[string]*protocol.TargetHealth),
+	}
+}
+
+// Record 记录一次转发结果，err 不为空或状态码为5xx时视为不健康
+func (t *TargetTracker) Record(url string, status int, err error, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	target, exists := t.targets[url]
+	if !exists {
+		target = &protocol.TargetHealth{URL: url}
+		t.targets[url] = target
+	}
+
+	target.LastStatus = status
+	target.LastLatencyMS = latency.Milliseconds()
+	target.LastSeenAt = time.Now().UnixMilli()
+	target.LastError = ""
+	if err != nil {
+		target.LastError = err.Error()
+	}
+
+	target.Healthy = err == nil && status < 500
+	if target.Healthy {
+		target.SuccessCount++
+	} else {
+		target.FailureCount++
+	}
+}
+
+// Snapshot 返回最近活跃目标的健康状态副本，按URL排序
+func (t *TargetTracker) Snapshot() []protocol.TargetHealth {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := time.Now().Add(-targetStaleAfter).UnixMilli()
+	result := make([]protocol.TargetHealth, 0, len(t.targets))
+	for url, target := range t.targets {
+		if target.LastSeenAt < cutoff {
+			delete(t.targets, url)
+			continue
+		}
+		result = append(result, *target)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+	return result
+}
@@ -0,0 +1,122 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 载荷压缩：注册时上报 payload_compression 能力，服务端在注册确认中下发默认的压缩方式和阈值，
+// 之后双方发送的载荷达到阈值时压缩为 base64(压缩数据)，compressed 字段记录压缩方式，需要与服务端保持一致。
+// 路由覆盖的设置随请求下发，发送该请求的响应时使用。压缩在加密和签名之前进行，收到的消息校验签名并解密之后再解压
+
+// 载荷压缩方式
+const (
+	CompressionNone    = "none"
+	CompressionGzip    = "gzip"
+	CompressionDeflate = "deflate"
+)
+
+// CompressionSettings 载荷压缩方式和阈值
+type CompressionSettings struct {
+	Algorithm string `json:"algorithm"` // none、gzip 或 deflate
+	MinBytes  int    `json:"min_bytes"` // 载荷达到该字节数才压缩
+}
+
+// UseCompression 指定发送该消息时使用的压缩设置，覆盖服务端下发的默认设置
+func (m *Message) UseCompression(settings *CompressionSettings) {
+	m.compression = settings
+}
+
+// Compression 发送该消息时使用的压缩设置，消息未指定时使用默认设置
+func (m *Message) Compression(fallback *CompressionSettings) *CompressionSettings {
+	if m.compression != nil {
+		return m.compression
+	}
+	return fallback
+}
+
+// Compress 返回载荷压缩后的消息副本，不修改原消息，重试和暂存时仍使用原消息
+// 载荷小于阈值或压缩后没有变小时返回原消息
+func (m *Message) Compress(settings *CompressionSettings) (*Message, error) {
+	if settings == nil || m.Compressed != "" {
+		return m, nil
+	}
+	plaintext, err := json.Marshal(m.Payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(plaintext) < settings.MinBytes {
+		return m, nil
+	}
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch settings.Algorithm {
+	case CompressionGzip:
+		zw = gzip.NewWriter(&buf)
+	case CompressionDeflate:
+		zw, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	default:
+		return m, nil
+	}
+	if _, err := zw.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	// 压缩数据编码为 base64 字符串后仍要比原载荷小
+	if base64.StdEncoding.EncodedLen(buf.Len())+2 >= len(plaintext) {
+		return m, nil
+	}
+	compressed := *m
+	compressed.Payload = base64.StdEncoding.EncodeToString(buf.Bytes())
+	compressed.Compressed = settings.Algorithm
+	return &compressed, nil
+}
+
+// Decompress 解压载荷，消息未压缩时不做处理
+func (m *Message) Decompress() error {
+	if m.Compressed == "" {
+		return nil
+	}
+	encoded, ok := m.Payload.(string)
+	if !ok {
+		return errors.New("压缩的载荷格式错误")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("压缩的载荷格式错误")
+	}
+	var zr io.ReadCloser
+	switch m.Compressed {
+	case CompressionGzip:
+		if zr, err = gzip.NewReader(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("解压载荷失败: %w", err)
+		}
+	case CompressionDeflate:
+		zr = flate.NewReader(bytes.NewReader(data))
+	default:
+		return fmt.Errorf("不支持的压缩方式 %q", m.Compressed)
+	}
+	defer zr.Close()
+	plaintext, err := io.ReadAll(zr)
+	if err != nil {
+		return fmt.Errorf("解压载荷失败: %w", err)
+	}
+	// 与未压缩时得到的载荷结构相同
+	var payload interface{}
+	if len(plaintext) > 0 {
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			return fmt.Errorf("解压后的载荷格式错误: %w", err)
+		}
+	}
+	m.Payload = payload
+	m.Compressed = ""
+	return nil
+}
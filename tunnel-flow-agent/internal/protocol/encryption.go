@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// 端到端加密：注册时在 REGISTER 中带上 X25519 公钥，服务端在 REGISTER_ACK 中返回自己的公钥，
+// 双方用 ECDH 的共享密钥经 HKDF-SHA256 派生出该连接的 AES-256-GCM 密钥。
+// 注册确认之后的消息载荷加密为 base64(nonce || 密文)，类型、操作和消息ID作为附加数据，需要与服务端保持一致。
+// 公钥靠认证Token认证：注册消息和注册确认都带以Token为密钥的签名，覆盖双方的公钥，派生密钥的盐也由Token计算。
+// 启用加密时建立连接只发送 ConnectProof，注册消息中也不带Token，中间设备替换公钥后无法重新签名，
+// 注册确认没有有效签名时不启用加密并断开连接
+
+// encryptionInfo HKDF 的 info 参数，与服务端相同
+const encryptionInfo = "tunnel-flow e2e v2"
+
+// NewEncryptionKey 生成一次注册使用的 X25519 密钥对，返回私钥和 base64 编码的公钥
+func NewEncryptionKey() (*ecdh.PrivateKey, string, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()), nil
+}
+
+// DeriveSessionCipher 用己方私钥和服务端的公钥派生该连接的 AES-GCM 密钥，盐由客户端ID和认证Token计算
+func DeriveSessionCipher(key *ecdh.PrivateKey, peerPublicKey, clientID, authToken string) (cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(peerPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("公钥格式错误: %w", err)
+	}
+	peer, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("公钥格式错误: %w", err)
+	}
+	secret, err := key.ECDH(peer)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(hkdfSHA256(secret, sessionSalt(clientID, authToken), []byte(encryptionInfo)))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sessionSalt HKDF 的盐：以认证Token为密钥对客户端ID计算的 HMAC-SHA256，把密钥交换绑定到客户端的凭据
+func sessionSalt(clientID, authToken string) []byte {
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write([]byte(clientID))
+	return mac.Sum(nil)
+}
+
+// hkdfSHA256 RFC 5869 HKDF，输出一个 SHA-256 分组（32字节）
+func hkdfSHA256(secret, salt, info []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write(info)
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// EncryptionExempt 不加密的操作，注册和注册确认在密钥协商完成之前发送
+func EncryptionExempt(op string) bool {
+	return op == OpRegister || op == OpRegisterAck
+}
+
+// Seal 返回载荷加密后的消息副本，不修改原消息，重试和暂存时仍使用原消息
+func (m *Message) Seal(aead cipher.AEAD) (*Message, error) {
+	plaintext, err := json.Marshal(m.Payload)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, m.additionalData())
+	encrypted := *m
+	encrypted.Payload = base64.StdEncoding.EncodeToString(sealed)
+	encrypted.Encrypted = true
+	return &encrypted, nil
+}
+
+// Open 解密载荷，消息未加密时不做处理
+func (m *Message) Open(aead cipher.AEAD) error {
+	if !m.Encrypted {
+		return nil
+	}
+	if aead == nil {
+		return errors.New("密钥协商完成之前收到加密的消息")
+	}
+	encoded, ok := m.Payload.(string)
+	if !ok {
+		return errors.New("加密的载荷格式错误")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return errors.New("加密的载荷格式错误")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, m.additionalData())
+	if err != nil {
+		return fmt.Errorf("解密载荷失败: %w", err)
+	}
+	// 与未加密时 ReadJSON 得到的载荷结构相同，没有载荷的消息解密后为空
+	var payload interface{}
+	if len(plaintext) > 0 {
+		if err := json.Unmarshal(plaintext, &payload); err != nil {
+			return fmt.Errorf("解密后的载荷格式错误: %w", err)
+		}
+	}
+	m.Payload = payload
+	m.Encrypted = false
+	return nil
+}
+
+// additionalData 绑定到密文的消息头
+func (m *Message) additionalData() []byte {
+	msgID := ""
+	if m.MsgID != nil {
+		msgID = *m.MsgID
+	}
+	return []byte(m.Type + "|" + m.Op + "|" + msgID)
+}
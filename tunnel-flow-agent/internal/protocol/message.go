@@ -24,17 +24,70 @@ const (
 	OpRegisterAck = "REGISTER_ACK"
 	OpPing        = "PING"
 	OpPong        = "PONG"
-	OpRouteSync   = "ROUTE_SYNC"
+	OpRouteSync   = "ROUTE_SYNC" // 注册成功后上报配置中声明的路由
+	OpStatsReport = "STATS_REPORT"
+	OpMetrics     = "METRICS" // 定期上报计数器
+	OpCancel      = "CANCEL"  // 服务端放弃等待某个请求，msg_id 为被取消请求的ID
+
+	OpTargetAllowlist = "TARGET_ALLOWLIST" // 服务端修改后下发的目标白名单
+	OpTargetViolation = "TARGET_VIOLATION" // 上报拒绝了白名单之外的目标
+	OpHeartbeatConfig = "HEARTBEAT_CONFIG" // 服务端修改后下发的心跳间隔和超时
+	OpRouteSyncAck    = "ROUTE_SYNC_ACK"   // 服务端对声明路由的处理结果
 	
 	// 业务操作
-	OpRequest  = "REQUEST"
-	OpResponse = "RESPONSE"
+	OpRequest       = "REQUEST"
+	OpResponse      = "RESPONSE"
+	OpRequestChunk  = "REQUEST_CHUNK"  // 流式请求体的分片，msg_id 为所属请求的ID
+	OpResponseChunk = "RESPONSE_CHUNK" // 可续传响应体的分片
+	OpTransferAck   = "TRANSFER_ACK"   // 服务端确认已接收的响应体偏移量，或要求续传、放弃
 	
 	// 通用操作
 	OpACK   = "ACK"
 	OpError = "ERROR"
 )
 
+// 代理能力常量，注册时上报给服务端
+const (
+	CapabilityHTTPProxy         = "http_proxy"          // 转发HTTP请求到目标地址
+	CapabilityTLSInsecure       = "tls_insecure"        // 支持访问自签名证书的HTTPS目标
+	CapabilityLocalIPs          = "local_ips"           // 上报本地网卡IP地址
+	CapabilityPingPong          = "ping_pong"           // 应用层心跳
+	CapabilityStatsReport       = "stats_report"        // 定期上报运行状态
+	CapabilityCancel            = "cancel"              // 支持取消仍在处理的请求
+	CapabilityStreamUpload      = "stream_upload"       // 支持分片接收请求体
+	CapabilityResumableTransfer = "resumable_transfer"  // 支持分片发送可续传的大响应体
+	CapabilityMetrics           = "metrics"             // 定期上报计数器
+	CapabilityTargetAllowlist   = "target_allowlist"    // 按服务端下发的白名单限制可以连接的目标
+	CapabilityEncryption        = "e2e_encryption"      // 支持注册时协商密钥加密消息载荷
+	CapabilitySigning           = "message_signing"     // 收发的消息用认证Token签名
+	CapabilityCompression       = "payload_compression" // 按服务端下发的设置压缩和解压消息载荷
+	CapabilityFragmentation     = "message_fragments"   // 超过最大消息大小的消息拆分为分段收发
+	CapabilitySessionResume     = "session_resume"      // 断线重连时用恢复令牌继续之前的会话
+	CapabilityEcho              = "echo"                // 直接返回带 echo 标记的请求的请求体
+)
+
+// Capabilities 当前代理支持的能力列表
+func Capabilities() []string {
+	return []string{
+		CapabilityHTTPProxy,
+		CapabilityTLSInsecure,
+		CapabilityLocalIPs,
+		CapabilityPingPong,
+		CapabilityStatsReport,
+		CapabilityCancel,
+		CapabilityStreamUpload,
+		CapabilityResumableTransfer,
+		CapabilityMetrics,
+		CapabilityTargetAllowlist,
+		CapabilityEncryption,
+		CapabilitySigning,
+		CapabilityCompression,
+		CapabilityFragmentation,
+		CapabilitySessionResume,
+		CapabilityEcho,
+	}
+}
+
 // Message WebSocket消息结构
 type Message struct {
 	Type      string      `json:"type"`                // 消息类型
@@ -43,6 +96,16 @@ type Message struct {
 	MsgID     *string     `json:"msg_id,omitempty"`    // 消息ID（可选）
 	Timestamp int64       `json:"timestamp"`           // 时间戳
 	Payload   interface{} `json:"payload,omitempty"`   // 载荷数据
+
+	Encrypted  bool   `json:"encrypted,omitempty"`  // 载荷已用该连接协商的密钥加密
+	Compressed string `json:"compressed,omitempty"` // 载荷的压缩方式，见 compression.go
+
+	// 消息签名，见 signing.go
+	Nonce     string `json:"nonce,omitempty"`
+	SignedAt  int64  `json:"signed_at,omitempty"` // 签名时间（毫秒）
+	Signature string `json:"signature,omitempty"`
+
+	compression *CompressionSettings // 发送时使用的压缩设置，为空时使用服务端下发的默认设置
 }
 
 // NewMessage 创建新消息
@@ -73,30 +136,124 @@ func (m *Message) ParsePayload(target interface{}) error {
 
 // 注册载荷
 type RegisterPayload struct {
-	ClientID  string   `json:"client_id"`
-	AuthToken string   `json:"auth_token"`
-	Version   string   `json:"version"`
-	LocalIPs  []string `json:"local_ips"` // 本地网卡IP地址列表
+	ClientID     string   `json:"client_id"`
+	AuthToken    string   `json:"auth_token"`
+	Version      string   `json:"version"`
+	LocalIPs     []string `json:"local_ips"`    // 本地网卡IP地址列表
+	OS           string   `json:"os"`           // 操作系统
+	Arch         string   `json:"arch"`         // CPU架构
+	Capabilities []string `json:"capabilities"` // 支持的能力列表
+	GitCommit    string   `json:"git_commit"`   // 构建代理的代码提交
+	BuildDate    string   `json:"build_date"`   // 代理的构建时间
+
+	EncryptionKey string `json:"encryption_key,omitempty"` // X25519 公钥，启用载荷加密时上报
+
+	MaxMessageBytes int `json:"max_message_bytes,omitempty"` // 要求服务端发送的最大消息大小，0表示由服务端决定
+
+	ResumeToken string `json:"resume_token,omitempty"` // 上次注册确认中的恢复令牌，重连时用于恢复会话
+}
+
+// 计数器上报载荷，计数器从代理启动开始累计，吞吐量按距上次上报的增量计算
+type MetricsPayload struct {
+	Timestamp        int64   `json:"timestamp"`         // 采集时间（毫秒）
+	IntervalMS       int64   `json:"interval_ms"`       // 距上次上报的时间
+	MessagesSent     int64   `json:"messages_sent"`     // 发送的消息数
+	MessagesReceived int64   `json:"messages_received"` // 收到的消息数
+	Errors           int64   `json:"errors"`            // 消息发送失败次数
+	Reconnects       int64   `json:"reconnects"`        // 连接失败次数
+	Requests         int64   `json:"requests"`          // 处理的请求数
+	FailedRequests   int64   `json:"failed_requests"`   // 转发失败或后端返回5xx的请求数
+	ResponseBytes    int64   `json:"response_bytes"`    // 返回的响应体字节数
+	InFlight         int     `json:"in_flight"`         // 处理中的请求数
+	RTTMS            int64   `json:"rtt_ms"`            // 心跳往返延迟
+	RequestsPerSec   float64 `json:"requests_per_sec"`
+	BytesPerSec      float64 `json:"bytes_per_sec"`
+}
+
+// 运行状态上报载荷
+type StatsReportPayload struct {
+	Timestamp          int64          `json:"timestamp"`            // 采集时间（毫秒）
+	UptimeSeconds      int64          `json:"uptime_seconds"`       // 代理运行时长
+	CPUPercent         float64        `json:"cpu_percent"`          // 主机CPU使用率
+	CPUCores           int            `json:"cpu_cores"`            // CPU核数
+	MemoryTotalBytes   uint64         `json:"memory_total_bytes"`   // 主机内存总量
+	MemoryUsedBytes    uint64         `json:"memory_used_bytes"`    // 主机已用内存
+	ProcessMemoryBytes uint64         `json:"process_memory_bytes"` // 代理进程占用内存
+	Goroutines         int            `json:"goroutines"`           // 代理goroutine数量
+	Targets            []TargetHealth `json:"targets"`              // 本地目标健康状态
+}
+
+// 本地目标健康状态，根据最近的转发结果统计
+type TargetHealth struct {
+	URL           string `json:"url"`
+	Healthy       bool   `json:"healthy"`
+	LastStatus    int    `json:"last_status,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	LastLatencyMS int64  `json:"last_latency_ms"`
+	SuccessCount  int64  `json:"success_count"`
+	FailureCount  int64  `json:"failure_count"`
+	LastSeenAt    int64  `json:"last_seen_at"` // 最近一次转发时间（毫秒）
 }
 
 // 注册确认载荷
 type RegisterAckPayload struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success        bool     `json:"success"`
+	Message        string   `json:"message"`
+	AllowedTargets []string `json:"allowed_targets"` // 只能连接的目标，为空时不限制
+	EncryptionKey  string   `json:"encryption_key"`  // 服务端的 X25519 公钥，为空表示服务端不加密载荷
+
+	Compression *CompressionSettings `json:"compression"` // 默认的载荷压缩设置，为空表示服务端不支持压缩
+
+	MaxMessageBytes int `json:"max_message_bytes"` // 双方发送的最大消息大小，超过时拆分为分段；0表示不拆分
+
+	Heartbeat *HeartbeatSettings `json:"heartbeat"` // 服务端为该客户端设置的心跳间隔和超时，为空时使用本地默认值
+
+	// 会话恢复，服务端不保留会话时为空
+	ResumeToken string `json:"resume_token"` // 断线后在保留时间内带上该令牌重连，服务端继续发送之前未发出的消息
+	Resumed     bool   `json:"resumed"`      // 本次注册恢复了之前的会话
+}
+
+// 心跳间隔和超时（秒）
+type HeartbeatSettings struct {
+	IntervalSeconds int `json:"interval_seconds"`
+	TimeoutSeconds  int `json:"timeout_seconds"`
+}
+
+// 目标白名单载荷，为空时不限制
+type TargetAllowlistPayload struct {
+	AllowedTargets []string `json:"allowed_targets"`
+}
+
+// 拒绝白名单之外的目标后上报的载荷
+type TargetViolationPayload struct {
+	Method    string `json:"method"`
+	URL       string `json:"url"`        // 路由配置的目标地址
+	URLSuffix string `json:"url_suffix"` // 请求的路径
+	Timestamp int64  `json:"timestamp"`
 }
 
 // HTTP请求载荷
 type RequestPayload struct {
-	Method       string            `json:"method"`
-	URL          string            `json:"url"`
-	Headers      map[string]string `json:"headers"`
-	Body         string            `json:"body"`
-	Timeout      int               `json:"timeout"`        // 超时时间（毫秒）
-	URLSuffix    string            `json:"url_suffix"`     // URL后缀，用于路由匹配
-	TargetsJSON  string            `json:"targets_json"`   // 目标地址JSON数组
-	Strategy     string            `json:"strategy"`       // 负载均衡策略
-	HTTPMethod   string            `json:"http_method"`    // HTTP方法
-	RouteMode    string            `json:"route_mode"`     // 路由配置模式：basic/full
+	Method            string            `json:"method"`
+	URL               string            `json:"url"`
+	Headers           map[string]string `json:"headers"`
+	Body              string            `json:"body"`
+	Timeout           int               `json:"timeout"`              // 超时时间（毫秒）
+	TimeoutMS         int               `json:"timeout_ms"`           // 路由设置的整个请求超时（毫秒），优先于 Timeout
+	ConnectTimeoutMS  int               `json:"connect_timeout_ms"`   // 连接目标超时（毫秒）
+	HeaderTimeoutMS   int               `json:"header_timeout_ms"`    // 请求发送完成后等待响应头超时（毫秒）
+	BodyIdleTimeoutMS int               `json:"body_idle_timeout_ms"` // 读取响应体时两次收到数据的最长间隔（毫秒）
+	URLSuffix         string            `json:"url_suffix"`           // URL后缀，用于路由匹配
+	TargetsJSON       string            `json:"targets_json"`         // 目标地址JSON数组
+	Strategy          string            `json:"strategy"`             // 负载均衡策略
+	HTTPMethod        string            `json:"http_method"`          // HTTP方法
+	RouteMode         string            `json:"route_mode"`           // 路由配置模式：basic/full
+	BodyStreamed      bool              `json:"body_streamed"`        // 请求体随后通过REQUEST_CHUNK分片到达
+	StreamResponse    bool              `json:"stream_response"`      // 允许大响应体通过RESPONSE_CHUNK分片发送
+	Cacheable         bool              `json:"cacheable"`            // 路由允许按Cache-Control缓存响应
+	Echo              bool              `json:"echo"`                 // 服务端的隧道压测请求，不转发，直接返回请求体
+
+	Compression *CompressionSettings `json:"compression"` // 路由覆盖的载荷压缩设置，发送该请求的响应时使用
 }
 
 // GetTargets 解析路由目标
@@ -121,6 +278,14 @@ func (r *RequestPayload) GetTargets() ([]RouteTarget, error) {
 	return targets, nil
 }
 
+// 流式请求体分片载荷
+type RequestChunkPayload struct {
+	Seq   int    `json:"seq"`   // 分片序号，从0开始
+	Data  []byte `json:"data"`  // 分片数据（JSON中为base64）
+	EOF   bool   `json:"eof"`   // 最后一个分片
+	Error string `json:"error"` // 服务端读取请求体失败，放弃该请求
+}
+
 // HTTP响应载荷
 type ResponsePayload struct {
 	HTTPStatus int               `json:"http_status"`
@@ -128,6 +293,31 @@ type ResponsePayload struct {
 	Body       interface{}       `json:"body"`
 	LatencyMS  int64             `json:"latency_ms"`
 	Error      *string           `json:"error,omitempty"`
+
+	// 完整的多值响应头，同名头（如多个Set-Cookie）按后端返回的顺序保留所有值，Headers 只保留第一个值以兼容旧版本服务端
+	HeaderValues map[string][]string `json:"header_values,omitempty"`
+
+	TransferID string            `json:"transfer_id,omitempty"` // 非空时响应体不在Body中，随后通过RESPONSE_CHUNK分片发送
+	Trailers   map[string]string `json:"trailers,omitempty"`    // 后端响应的trailer，分片发送时随最后一个分片发送
+}
+
+// 可续传响应体分片载荷
+type ResponseChunkPayload struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"`          // 分片在响应体中的起始偏移量
+	Data       []byte `json:"data,omitempty"`  // 分片数据（JSON中为base64）
+	EOF        bool   `json:"eof,omitempty"`   // 最后一个分片
+	Error      string `json:"error,omitempty"` // 读取后端响应体失败，响应不完整
+
+	Trailers map[string]string `json:"trailers,omitempty"` // 后端响应的trailer，只在最后一个分片中
+}
+
+// 可续传响应体控制载荷
+type TransferAckPayload struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"` // 服务端已接收的字节数
+	Resume     bool   `json:"resume"` // 连接中断后重新注册，从 Offset 开始重新发送
+	Abort      bool   `json:"abort"`  // 服务端不再接收，停止发送
 }
 
 // ACK载荷
@@ -160,18 +350,41 @@ type RouteTarget struct {
 	Weight int    `json:"weight,omitempty"`
 }
 
-// 路由信息
-type RouteInfo struct {
-	ID          string        `json:"id"`
-	URLSuffix   string        `json:"url_suffix"`
-	TargetsJSON string        `json:"targets_json"`
-	Strategy    string        `json:"strategy"`
-	Targets     []RouteTarget `json:"targets,omitempty"` // 解析后的目标列表
+// DeclaredRoute 配置中声明的路由
+type DeclaredRoute struct {
+	URLSuffix   string `json:"url_suffix"`
+	Target      string `json:"target"`
+	ListenPort  int    `json:"listen_port,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
-// 路由同步载荷
+// RouteSyncPayload 注册成功后上报的全部声明路由，服务端删除之前声明而这次没有声明的路由
 type RouteSyncPayload struct {
-	Routes []RouteInfo `json:"routes"`
+	Routes []DeclaredRoute `json:"routes"`
+}
+
+// 服务端对声明路由的处理结果
+const (
+	RouteSyncCreated   = "created"
+	RouteSyncUpdated   = "updated"
+	RouteSyncUnchanged = "unchanged"
+	RouteSyncRejected  = "rejected"
+	RouteSyncPending   = "pending"  // 等待管理员审批，批准前不转发请求
+	RouteSyncApproved  = "approved" // 管理员批准，审批结果由服务端单独推送
+)
+
+// DeclaredRouteResult 一条声明路由的处理结果
+type DeclaredRouteResult struct {
+	URLSuffix string `json:"url_suffix"`
+	Status    string `json:"status"`
+	RouteID   int    `json:"route_id,omitempty"`
+	Reason    string `json:"reason,omitempty"` // 拒绝的原因
+}
+
+// RouteSyncAckPayload 服务端对 ROUTE_SYNC 的确认
+type RouteSyncAckPayload struct {
+	Results []DeclaredRouteResult `json:"results"`
+	Removed []string              `json:"removed,omitempty"` // 不再声明而被删除的路由路径
 }
 
 // GenerateMessageID 生成消息ID
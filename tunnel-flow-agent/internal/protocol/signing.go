@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 消息签名：对每条消息计算 HMAC-SHA256，密钥为认证Token，覆盖消息头、签名时间、随机数和发送的载荷字节
+// （加密时为密文），计算方式需要与服务端保持一致。接收方校验签名后按签名时间和随机数拒绝重放的消息
+
+// ErrMissingSignature 要求签名的连接上收到没有签名的消息
+var ErrMissingSignature = errors.New("消息没有签名")
+
+// Sign 返回带签名的消息副本，不修改原消息
+func (m *Message) Sign(key string) (*Message, error) {
+	// 先序列化载荷，签名覆盖实际发送的字节
+	payload, err := json.Marshal(m.Payload)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	signed := *m
+	signed.Payload = json.RawMessage(payload)
+	signed.Nonce = hex.EncodeToString(nonce)
+	signed.SignedAt = time.Now().UnixMilli()
+	signed.Signature = base64.StdEncoding.EncodeToString(signed.mac(key, payload))
+	return &signed, nil
+}
+
+// VerifySignature 校验从 data 解析出的消息的签名，不检查签名时间和随机数
+func (m *Message) VerifySignature(key string, data []byte) error {
+	if m.Signature == "" {
+		return ErrMissingSignature
+	}
+	// 签名覆盖收到的原始载荷字节
+	var raw struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	actual, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil || !hmac.Equal(m.mac(key, raw.Payload), actual) {
+		return errors.New("消息签名无效")
+	}
+	return nil
+}
+
+// mac 计算消息的 HMAC
+func (m *Message) mac(key string, payload []byte) []byte {
+	msgID := ""
+	if m.MsgID != nil {
+		msgID = *m.MsgID
+	}
+	h := hmac.New(sha256.New, []byte(key))
+	for _, field := range []string{m.Type, m.Op, msgID, m.ClientID, strconv.FormatInt(m.SignedAt, 10), m.Nonce} {
+		h.Write([]byte(field))
+		h.Write([]byte{'\n'})
+	}
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// NonceCache 记录有效期内已收到的随机数，拒绝过期或重复的消息
+type NonceCache struct {
+	mu       sync.Mutex
+	window   time.Duration
+	seen     map[string]time.Time // 随机数 -> 签名时间
+	prunedAt time.Time
+}
+
+// NewNonceCache 创建随机数缓存，签名时间与当前时间相差超过 window 的消息视为过期
+func NewNonceCache(window time.Duration) *NonceCache {
+	return &NonceCache{
+		window: window,
+		seen:   make(map[string]time.Time),
+	}
+}
+
+// Check 检查签名时间和随机数，通过时记录随机数
+func (c *NonceCache) Check(nonce string, signedAt int64, now time.Time) error {
+	at := time.UnixMilli(signedAt)
+	if skew := now.Sub(at); skew > c.window || skew < -c.window {
+		return fmt.Errorf("消息签名时间与本地时间相差 %s，超过 %s", skew.Round(time.Second), c.window)
+	}
+	if nonce == "" {
+		return errors.New("消息没有随机数")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.seen[nonce]; exists {
+		return errors.New("重放的消息")
+	}
+	// 过期的随机数不会再通过时间检查，定期删除
+	if now.Sub(c.prunedAt) > c.window/4 {
+		for n, t := range c.seen {
+			if now.Sub(t) > c.window {
+				delete(c.seen, n)
+			}
+		}
+		c.prunedAt = now
+	}
+	c.seen[nonce] = at
+	return nil
+}
+
+// ConnectProof 启用加密时建立连接代替认证Token发送的证明，返回签名时间、随机数和证明，计算方式需要与服务端保持一致
+func ConnectProof(authToken, clientID string) (int64, string, string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return 0, "", "", err
+	}
+	signedAt := time.Now().UnixMilli()
+	encoded := hex.EncodeToString(nonce)
+	h := hmac.New(sha256.New, []byte(authToken))
+	for _, field := range []string{"connect", clientID, strconv.FormatInt(signedAt, 10), encoded} {
+		h.Write([]byte(field))
+		h.Write([]byte{'\n'})
+	}
+	return signedAt, encoded, base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"sync"
@@ -14,9 +13,16 @@ import (
 	"github.com/google/uuid"
 
 	"tunnel-flow-agent/internal/config"
+	"tunnel-flow-agent/internal/logging"
 	"tunnel-flow-agent/internal/retry"
 )
 
+// 组件日志，级别可以通过监控接口单独调整
+var (
+	wsLog        = logging.NewComponent(logging.ComponentWebsocket)
+	heartbeatLog = logging.NewComponent(logging.ComponentHeartbeat)
+)
+
 // ConnectionState 连接状态
 type ConnectionState int
 
@@ -154,7 +160,7 @@ func (c *Connector) Connect() error {
 	headers := http.Header{}
 	headers.Set("User-Agent", "TunnelFlow-Agent/1.0")
 	
-	log.Printf("Connecting to WebSocket server: %s", wsURL)
+	wsLog.Infof("Connecting to WebSocket server: %s", wsURL)
 	
 	// 建立WebSocket连接
 	dialer := websocket.Dialer{
@@ -186,7 +192,7 @@ func (c *Connector) Connect() error {
 	go c.writeLoop()
 	go c.heartbeatLoop()
 	
-	log.Printf("Successfully connected to WebSocket server")
+	wsLog.Infof("Successfully connected to WebSocket server")
 	
 	// 触发连接事件
 	if c.onConnected != nil {
@@ -313,7 +319,7 @@ func (c *Connector) setState(state ConnectionState) {
 	oldState := c.state
 	c.state = state
 	
-	log.Printf("Connection state changed: %s -> %s", oldState, state)
+	wsLog.Infof("Connection state changed: %s -> %s", oldState, state)
 }
 
 // getState 获取连接状态
@@ -362,7 +368,7 @@ func (c *Connector) recordError(errMsg string) {
 	c.stats.LastError = errMsg
 	c.stats.LastErrorTime = time.Now()
 	
-	log.Printf("WebSocket error: %s", errMsg)
+	wsLog.Errorf("WebSocket error: %s", errMsg)
 	
 	// 触发错误事件
 	if c.onError != nil {
@@ -412,7 +418,7 @@ func (c *Connector) readLoop() {
 		case websocket.PongMessage:
 			c.handlePongMessage()
 		case websocket.CloseMessage:
-			log.Printf("Received close message")
+			wsLog.Infof("Received close message")
 			return
 		}
 	}
@@ -513,7 +519,7 @@ func (c *Connector) handleTextMessage(data []byte) {
 func (c *Connector) handlePongMessage() {
 	// 更新延迟统计
 	// 这里可以实现RTT计算
-	log.Printf("Received pong message")
+	heartbeatLog.Debugf("Received pong message")
 }
 
 // sendPongMessage 发送pong消息
@@ -543,7 +549,7 @@ func (c *Connector) startReconnect() {
 				return fmt.Errorf("connector is closed")
 			}
 			
-			log.Printf("Attempting to reconnect...")
+			wsLog.Infof("Attempting to reconnect...")
 			return c.Connect()
 		})
 		
@@ -556,7 +562,7 @@ func (c *Connector) startReconnect() {
 			c.stats.LastReconnectTime = time.Now()
 			c.statsMu.Unlock()
 			
-			log.Printf("Reconnected successfully")
+			wsLog.Infof("Reconnected successfully")
 		}
 	}()
 }
\ No newline at end of file
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
@@ -28,8 +29,10 @@ func main() {
 		os.Exit(1)
 	}
 	defer logger.Close()
+	logging.SetLevel(logConfig.Level)
 	
-	logger.Info("启动客户端代理...")
+	buildInfo := agent.GetBuildInfo()
+	logger.Infof("启动客户端代理 %s (commit %s, built %s)...", buildInfo.Version, buildInfo.GitCommit, buildInfo.BuildDate)
 	
 	// 加载配置
 	cfg, err := config.Load()
@@ -38,23 +41,23 @@ func main() {
 		os.Exit(1)
 	}
 	
-	logger.WithField("config", cfg).Info("配置加载成功")
+	logger.WithField("config", cfg.Effective()).Info("配置加载成功")
 	
 	// 创建监控收集器
 	metricsCollector := monitoring.NewMetricsCollector()
 	
-	// 创建代理
-	agentInstance := agent.NewAgent(cfg)
+	// 创建代理，配置了多个服务器时按连接方式创建一个或多个
+	agentInstance := agent.NewGroup(cfg)
 	
 	// 启动监控HTTP服务器
 	monitoringServer := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.MonitoringPort),
-		Handler: setupMonitoringRoutes(metricsCollector, agentInstance, logger),
+		Addr:    fmt.Sprintf(":%d", cfg.MonitoringPort()),
+		Handler: setupMonitoringRoutes(cfg, metricsCollector, agentInstance, logger),
 	}
 	
 	// 启动监控服务器
 	go func() {
-		logger.Infof("启动监控服务器在端口 %d", cfg.MonitoringPort)
+		logger.Infof("启动监控服务器在端口 %d", cfg.MonitoringPort())
 		if err := monitoringServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.Errorf("监控服务器启动失败: %v", err)
 		}
@@ -116,15 +119,24 @@ func main() {
 }
 
 // setupMonitoringRoutes 设置监控路由
-func setupMonitoringRoutes(metricsCollector *monitoring.MetricsCollector, agentInstance *agent.Agent, logger *logging.Logger) http.Handler {
+func setupMonitoringRoutes(cfg *config.Config, metricsCollector *monitoring.MetricsCollector, agentInstance *agent.Group, logger *logging.Logger) http.Handler {
 	mux := http.NewServeMux()
 	
+	// 本地状态页
+	mux.HandleFunc("/", agentInstance.StatusPageHandler)
+	
 	// 指标接口
 	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("处理指标请求")
 		metricsCollector.HTTPHandler(w, r)
 	})
 	
+	// 构建信息接口
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agent.GetBuildInfo())
+	})
+	
 	// 健康检查接口
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("处理健康检查请求")
@@ -141,6 +153,24 @@ func setupMonitoringRoutes(metricsCollector *monitoring.MetricsCollector, agentI
 		fmt.Fprintf(w, `{"status":"%s","timestamp":"%s"}`, status, time.Now().Format(time.RFC3339))
 	})
 	
+	// 存活检查：进程在运行即返回200，不关心与服务器的连接
+	mux.HandleFunc("/live", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"status":"ok","timestamp":"%s"}`, time.Now().Format(time.RFC3339))
+	})
+	
+	// 就绪检查：已在服务端注册且最近一个心跳超时内收到过服务端响应时返回200，否则返回503和原因
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		result := map[string]string{"status": "ok", "timestamp": time.Now().Format(time.RFC3339)}
+		if reason := agentInstance.NotReadyReason(); reason != "" {
+			result["status"] = "unavailable"
+			result["reason"] = reason
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	
 	// 统计信息接口
 	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("处理统计信息请求")
@@ -163,37 +193,77 @@ func setupMonitoringRoutes(metricsCollector *monitoring.MetricsCollector, agentI
 			stats["connection_state"])
 	})
 	
-	// 配置信息接口
+	// 配置信息接口：GET 返回生效中的配置（隐去认证Token），POST 修改运行时可调整的配置项
 	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
 		logger.Debug("处理配置信息请求")
 		
 		w.Header().Set("Content-Type", "application/json")
-		fmt.Fprintf(w, `{"message": "Configuration endpoint"}`)
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var update config.RuntimeUpdate
+			decoder := json.NewDecoder(r.Body)
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&update); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("无效的请求，只能修改 stats_report_interval_seconds、metrics_interval_seconds 和 drain_timeout_seconds: %v", err)})
+				return
+			}
+			if err := cfg.ApplyRuntimeUpdate(update); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+				return
+			}
+			effective := cfg.Effective()
+			logger.Infof("运行时配置已修改: stats_report_interval_seconds=%d, metrics_interval_seconds=%d, drain_timeout_seconds=%d",
+				effective.StatsReportIntervalSeconds, effective.MetricsIntervalSeconds, effective.DrainTimeoutSeconds)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(cfg.Effective())
 	})
 	
-	// 日志级别控制接口
+	// 日志级别控制接口：component 为空时修改全局级别，否则只修改该组件的级别，level=default 恢复使用全局级别
 	mux.HandleFunc("/log-level", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
 		if r.Method == "POST" {
 			level := r.URL.Query().Get("level")
-			switch level {
-			case "debug":
-				logger.SetLevel(logging.DEBUG)
-			case "info":
-				logger.SetLevel(logging.INFO)
-			case "warn":
-				logger.SetLevel(logging.WARN)
-			case "error":
-				logger.SetLevel(logging.ERROR)
-			default:
-				http.Error(w, "Invalid log level", http.StatusBadRequest)
+			component := r.URL.Query().Get("component")
+			
+			var err error
+			if component != "" && level == "default" {
+				err = logging.ResetComponentLevel(component)
+			} else {
+				var parsed logging.LogLevel
+				if parsed, err = logging.ParseLevel(level); err == nil {
+					if component == "" {
+						logger.SetLevel(parsed)
+						logging.SetLevel(parsed)
+					} else {
+						err = logging.SetComponentLevel(component, parsed)
+					}
+				}
+			}
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "components": logging.Components()})
 				return
 			}
 			
-			logger.Infof("日志级别已更改为: %s", level)
-			fmt.Fprintf(w, `{"message": "Log level changed to %s"}`, level)
+			if component == "" {
+				logger.Infof("日志级别已更改为: %s", level)
+				fmt.Fprintf(w, `{"message": "Log level changed to %s"}`, level)
+			} else {
+				logger.Infof("组件 %s 的日志级别已更改为: %s", component, level)
+				fmt.Fprintf(w, `{"message": "Log level of %s changed to %s"}`, component, level)
+			}
 		} else {
-			currentLevel := logger.GetLevel().String()
-			fmt.Fprintf(w, `{"current_level": "%s"}`, currentLevel)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"current_level": logger.GetLevel().String(),
+				"components":    logging.ComponentLevels(),
+			})
 		}
 	})
 	
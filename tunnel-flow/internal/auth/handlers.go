@@ -3,13 +3,17 @@ package auth
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
 	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
 )
 
 // User 用户结构
@@ -18,23 +22,40 @@ type User struct {
 	Username     string    `json:"username"`
 	PasswordHash string    `json:"-"` // 不在JSON中显示
 	Role         string    `json:"role"`
+	OrgID        int       `json:"org_id"`
 	CreatedAt    time.Time `json:"created_at"`
 	LastLogin    time.Time `json:"last_login"`
 }
 
+// newUser 将数据库用户记录转换为API用户
+func newUser(record *database.User) *User {
+	user := &User{
+		ID:           record.ID,
+		Username:     record.Username,
+		PasswordHash: record.PasswordHash,
+		Role:         record.Role,
+		OrgID:        record.OrgID,
+		CreatedAt:    time.UnixMilli(record.CreatedAt),
+	}
+	if record.LastLogin > 0 {
+		user.LastLogin = time.UnixMilli(record.LastLogin)
+	}
+	return user
+}
+
 // AuthHandler 认证处理器
 type AuthHandler struct {
-	auth  *AuthMiddleware
-	users map[string]*User // 简单的内存存储，生产环境应使用数据库
+	auth   *AuthMiddleware
+	db     *database.Repository
 	config *config.Config
 }
 
-// NewAuthHandler 创建认证处理器
-func NewAuthHandler(cfg *config.Config) *AuthHandler {
-	auth := NewAuthMiddleware(cfg)
+// NewAuthHandler 创建认证处理器，用户保存在数据库中
+func NewAuthHandler(cfg *config.Config, db *database.Repository) *AuthHandler {
+	auth := NewAuthMiddleware(cfg, db)
 	h := &AuthHandler{
 		auth:   auth,
-		users:  make(map[string]*User),
+		db:     db,
 		config: cfg,
 	}
 
@@ -43,24 +64,61 @@ func NewAuthHandler(cfg *config.Config) *AuthHandler {
 	return h
 }
 
-// createDefaultAdmin 创建默认管理员用户
+// createDefaultAdmin 创建默认组织的管理员用户
 func (h *AuthHandler) createDefaultAdmin() {
 	adminID := "admin"
 	adminPassword := "admin123" // 生产环境应该使用更安全的默认密码
 
 	// 检查是否已存在
-	if _, exists := h.users[adminID]; exists {
+	if _, err := h.db.GetUser(adminID); err == nil {
 		return
 	}
 
-	passwordHash := h.hashPassword(adminPassword)
-	h.users[adminID] = &User{
+	err := h.db.CreateUser(&database.User{
 		ID:           adminID,
 		Username:     "admin",
-		PasswordHash: passwordHash,
-		Role:         "admin",
-		CreatedAt:    time.Now(),
+		PasswordHash: h.hashPassword(adminPassword),
+		Role:         RoleAdmin,
+		OrgID:        database.DefaultOrgID,
+	})
+	if err != nil {
+		log.Printf("Failed to create default admin user: %v", err)
+	}
+}
+
+// CreateUser 在指定组织中创建用户，用户名已存在时返回 ErrUsernameExists
+func (h *AuthHandler) CreateUser(username, password, role string, orgID int) (*User, error) {
+	if _, err := h.db.GetUserByUsername(username); err == nil {
+		return nil, ErrUsernameExists
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	record := &database.User{
+		ID:           h.generateUserID(),
+		Username:     username,
+		PasswordHash: h.hashPassword(password),
+		Role:         role,
+		OrgID:        orgID,
+	}
+	if err := h.db.CreateUser(record); err != nil {
+		return nil, err
+	}
+	return newUser(record), nil
+}
+
+// ListOrgUsers 列出组织内的用户
+func (h *AuthHandler) ListOrgUsers(orgID int) ([]*User, error) {
+	records, err := h.db.ListUsers(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(records))
+	for _, record := range records {
+		users = append(users, newUser(record))
 	}
+	return users, nil
 }
 
 // LoginRequest 登录请求
@@ -85,32 +143,35 @@ type RegisterRequest struct {
 // Login 用户登录
 func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid request body")
 		return
 	}
 
 	// 验证用户凭据
 	user, err := h.validateCredentials(req.Username, req.Password)
 	if err != nil {
-		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid username or password")
 		return
 	}
 
 	// 生成token
-	token, err := h.auth.GenerateToken(user.ID, user.Username, user.Role)
+	token, err := h.auth.GenerateToken(user.ID, user.Username, user.Role, user.OrgID)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
 
 	// 更新最后登录时间
 	user.LastLogin = time.Now()
+	if err := h.db.UpdateUserLastLogin(user.ID, user.LastLogin.UnixMilli()); err != nil {
+		log.Printf("Failed to update last login for user %s: %v", user.Username, err)
+	}
 
 	// 返回响应
 	resp := LoginResponse{
@@ -125,62 +186,51 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 // Register 用户注册
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	var req RegisterRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid request body")
 		return
 	}
 
 	// 验证输入
 	if req.Username == "" || req.Password == "" {
-		http.Error(w, "Username and password are required", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Username and password are required")
 		return
 	}
 
-	// 检查用户名是否已存在
-	for _, user := range h.users {
-		if user.Username == req.Username {
-			http.Error(w, "Username already exists", http.StatusConflict)
-			return
-		}
-	}
-
 	// 设置默认角色
 	if req.Role == "" {
-		req.Role = "user"
+		req.Role = RoleUser
 	}
 
 	// 只有管理员可以创建管理员用户
-	if req.Role == "admin" {
+	if req.Role == RoleAdmin {
 		user, ok := GetUserFromContext(r.Context())
-		if !ok || user.Role != "admin" {
-			http.Error(w, "Only admins can create admin users", http.StatusForbidden)
+		if !ok || user.Role != RoleAdmin {
+			utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Only admins can create admin users")
 			return
 		}
 	}
 
-	// 创建用户
-	userID := h.generateUserID()
-	passwordHash := h.hashPassword(req.Password)
-
-	newUser := &User{
-		ID:           userID,
-		Username:     req.Username,
-		PasswordHash: passwordHash,
-		Role:         req.Role,
-		CreatedAt:    time.Now(),
+	// 自助注册的用户属于默认组织，其他组织的用户通过组织API创建
+	newUser, err := h.CreateUser(req.Username, req.Password, req.Role, database.DefaultOrgID)
+	if err == ErrUsernameExists {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Username already exists")
+		return
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
 	}
-
-	h.users[userID] = newUser
 
 	// 生成token
-	token, err := h.auth.GenerateToken(newUser.ID, newUser.Username, newUser.Role)
+	token, err := h.auth.GenerateToken(newUser.ID, newUser.Username, newUser.Role, newUser.OrgID)
 	if err != nil {
-		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
 
@@ -198,36 +248,32 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 // GetProfile 获取用户信息
 func (h *AuthHandler) GetProfile(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	user, ok := GetUserFromContext(r.Context())
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Unauthorized")
 		return
 	}
 
 	// 获取完整用户信息
-	fullUser, exists := h.users[user.UserID]
-	if !exists {
-		http.Error(w, "User not found", http.StatusNotFound)
+	record, err := h.db.GetUser(user.UserID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "User not found")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(fullUser)
+	json.NewEncoder(w).Encode(newUser(record))
 }
 
 // validateCredentials 验证用户凭据
 func (h *AuthHandler) validateCredentials(username, password string) (*User, error) {
-	for _, user := range h.users {
-		if user.Username == username {
-			if h.verifyPassword(password, user.PasswordHash) {
-				return user, nil
-			}
-			break
-		}
+	record, err := h.db.GetUserByUsername(username)
+	if err == nil && h.verifyPassword(password, record.PasswordHash) {
+		return newUser(record), nil
 	}
 	return nil, fmt.Errorf("invalid credentials")
 }
@@ -255,22 +301,27 @@ func (h *AuthHandler) GetAuthMiddleware() *AuthMiddleware {
 	return h.auth
 }
 
-// ListUsers 列出所有用户（仅管理员）
+// ListUsers 列出用户（仅管理员），平台管理员可以看到所有组织的用户
 func (h *AuthHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	user, ok := GetUserFromContext(r.Context())
-	if !ok || user.Role != "admin" {
-		http.Error(w, "Forbidden: admin access required", http.StatusForbidden)
+	if !ok || user.Role != RoleAdmin {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: admin access required")
 		return
 	}
 
-	users := make([]*User, 0, len(h.users))
-	for _, u := range h.users {
-		users = append(users, u)
+	orgID := user.EffectiveOrgID()
+	if user.IsPlatformAdmin() {
+		orgID = 0
+	}
+	users, err := h.ListOrgUsers(orgID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -2,35 +2,140 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
 )
 
+// 用户角色
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// ErrUsernameExists 用户名已存在
+var ErrUsernameExists = errors.New("username already exists")
+
 // Claims JWT声明
 type Claims struct {
 	UserID   string `json:"user_id"`
 	Username string `json:"username"`
 	Role     string `json:"role"`
+	OrgID    int    `json:"org_id"`
 	jwt.RegisteredClaims
 }
 
+// EffectiveOrgID 用户所属组织，升级前签发的token没有组织信息，视为默认组织
+func (c *Claims) EffectiveOrgID() int {
+	if c.OrgID == 0 {
+		return database.DefaultOrgID
+	}
+	return c.OrgID
+}
+
+// IsPlatformAdmin 默认组织的管理员可以管理所有组织
+func (c *Claims) IsPlatformAdmin() bool {
+	return c.Role == RoleAdmin && c.EffectiveOrgID() == database.DefaultOrgID
+}
+
+// APIKeyPrefix API密钥的固定前缀，完整格式为 tf_<组织标识>_<随机串>
+const APIKeyPrefix = "tf_"
+
+// apiKeyLastUsedInterval API密钥最后使用时间的最小更新间隔，避免每个请求都写数据库
+const apiKeyLastUsedInterval = time.Minute
+
 // AuthMiddleware 认证中间件
+// 每个组织使用独立的JWT签名密钥，一个组织的token泄露后无法在其他组织使用
 type AuthMiddleware struct {
-	jwtSecret []byte
+	jwtSecret []byte // 组织未设置独立密钥时使用的全局密钥
+	db        *database.Repository
+
+	mu      sync.RWMutex
+	orgKeys map[int][]byte // 组织签名密钥缓存
 }
 
-// NewAuthMiddleware 创建认证中间件
-func NewAuthMiddleware(cfg *config.Config) *AuthMiddleware {
+// NewAuthMiddleware 创建认证中间件，db 为nil时所有组织使用全局密钥且不支持API密钥
+func NewAuthMiddleware(cfg *config.Config, db *database.Repository) *AuthMiddleware {
 	return &AuthMiddleware{
 		jwtSecret: []byte(cfg.AuthJWTSecret),
+		db:        db,
+		orgKeys:   make(map[int][]byte),
 	}
 }
 
+// NewSigningKey 生成随机的组织签名密钥
+func NewSigningKey() string {
+	bytes := make([]byte, 32)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// signingKey 获取组织的签名密钥
+func (a *AuthMiddleware) signingKey(orgID int) ([]byte, error) {
+	if a.db == nil {
+		return a.jwtSecret, nil
+	}
+
+	a.mu.RLock()
+	key, ok := a.orgKeys[orgID]
+	a.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	org, err := a.db.GetOrganization(orgID)
+	if err != nil {
+		return nil, fmt.Errorf("unknown organization %d: %w", orgID, err)
+	}
+	key = a.jwtSecret
+	if org.SigningKey != "" {
+		key = []byte(org.SigningKey)
+	}
+
+	a.mu.Lock()
+	a.orgKeys[orgID] = key
+	a.mu.Unlock()
+	return key, nil
+}
+
+// RotateSigningKey 为组织生成新的签名密钥，该组织之前签发的token全部失效
+func (a *AuthMiddleware) RotateSigningKey(orgID int) error {
+	if a.db == nil {
+		return fmt.Errorf("signing key rotation requires a database")
+	}
+
+	key := NewSigningKey()
+	if err := a.db.UpdateOrganizationSigningKey(orgID, key); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.orgKeys[orgID] = []byte(key)
+	a.mu.Unlock()
+	return nil
+}
+
+// ForgetOrganization 清除已删除组织的密钥缓存
+func (a *AuthMiddleware) ForgetOrganization(orgID int) {
+	a.mu.Lock()
+	delete(a.orgKeys, orgID)
+	a.mu.Unlock()
+}
+
 // Middleware HTTP认证中间件
 func (a *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -43,14 +148,20 @@ func (a *AuthMiddleware) Middleware(next http.Handler) http.Handler {
 		// 提取token
 		token := a.extractToken(r)
 		if token == "" {
-			http.Error(w, "Missing or invalid authorization header", http.StatusUnauthorized)
+			utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Missing or invalid authorization header")
 			return
 		}
 
-		// 验证token
-		claims, err := a.ValidateToken(token)
+		// 验证token，API密钥和JWT使用同一个请求头
+		var claims *Claims
+		var err error
+		if strings.HasPrefix(token, APIKeyPrefix) {
+			claims, err = a.ValidateAPIKey(token)
+		} else {
+			claims, err = a.ValidateToken(token)
+		}
 		if err != nil {
-			http.Error(w, "Invalid token: "+err.Error(), http.StatusUnauthorized)
+			utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid or expired token")
 			return
 		}
 
@@ -93,6 +204,11 @@ func (a *AuthMiddleware) shouldSkipAuth(path string) bool {
 
 // extractToken 从请求中提取token
 func (a *AuthMiddleware) extractToken(r *http.Request) string {
+	// API密钥也可以通过专用请求头传递
+	if apiKey := r.Header.Get("X-API-Key"); apiKey != "" {
+		return apiKey
+	}
+
 	// 从Authorization头提取
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "" {
@@ -106,14 +222,18 @@ func (a *AuthMiddleware) extractToken(r *http.Request) string {
 	return r.URL.Query().Get("token")
 }
 
-// ValidateToken 验证JWT token
+// ValidateToken 验证JWT token，使用token中声明的组织的签名密钥校验
 func (a *AuthMiddleware) ValidateToken(tokenString string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		// 验证签名方法
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return a.jwtSecret, nil
+		claims, ok := token.Claims.(*Claims)
+		if !ok {
+			return nil, fmt.Errorf("invalid claims")
+		}
+		return a.signingKey(claims.EffectiveOrgID())
 	})
 
 	if err != nil {
@@ -127,12 +247,18 @@ func (a *AuthMiddleware) ValidateToken(tokenString string) (*Claims, error) {
 	return nil, fmt.Errorf("invalid token")
 }
 
-// GenerateToken 生成JWT token
-func (a *AuthMiddleware) GenerateToken(userID, username, role string) (string, error) {
+// GenerateToken 使用组织的签名密钥生成JWT token
+func (a *AuthMiddleware) GenerateToken(userID, username, role string, orgID int) (string, error) {
+	key, err := a.signingKey(orgID)
+	if err != nil {
+		return "", err
+	}
+
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
 		Role:     role,
+		OrgID:    orgID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)), // 24小时过期
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -143,7 +269,71 @@ func (a *AuthMiddleware) GenerateToken(userID, username, role string) (string, e
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(a.jwtSecret)
+	return token.SignedString(key)
+}
+
+// GenerateAPIKey 为组织生成API密钥，返回明文、展示用前缀和哈希
+// 密钥中包含组织标识，校验时要求与密钥记录的组织一致
+func GenerateAPIKey(orgSlug string) (plaintext, prefix, hash string) {
+	bytes := make([]byte, 24)
+	rand.Read(bytes)
+	plaintext = APIKeyPrefix + orgSlug + "_" + hex.EncodeToString(bytes)
+	prefix = plaintext[:len(APIKeyPrefix)+len(orgSlug)+7]
+	return plaintext, prefix, HashAPIKey(plaintext)
+}
+
+// HashAPIKey 计算API密钥的哈希
+func HashAPIKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// ValidateAPIKey 验证API密钥，返回以密钥身份表示的声明
+func (a *AuthMiddleware) ValidateAPIKey(key string) (*Claims, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("api keys are not supported")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(key, APIKeyPrefix), "_", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("malformed api key")
+	}
+
+	record, err := a.db.GetAPIKeyByHash(HashAPIKey(key))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invalid api key")
+		}
+		return nil, err
+	}
+
+	// 密钥只在其所属组织的命名空间内有效
+	org, err := a.db.GetOrganization(record.OrgID)
+	if err != nil || org.Slug != parts[0] {
+		return nil, fmt.Errorf("invalid api key")
+	}
+
+	now := time.Now()
+	if record.ExpiresAt > 0 && now.UnixMilli() >= record.ExpiresAt {
+		return nil, fmt.Errorf("api key expired")
+	}
+	if now.UnixMilli()-record.LastUsedAt >= apiKeyLastUsedInterval.Milliseconds() {
+		if err := a.db.UpdateAPIKeyLastUsed(record.ID, now.UnixMilli()); err != nil {
+			log.Printf("Failed to update last used time for api key %d: %v", record.ID, err)
+		}
+	}
+
+	return &Claims{
+		UserID:   APIKeyUserID(record.ID),
+		Username: record.Name,
+		Role:     record.Role,
+		OrgID:    record.OrgID,
+	}, nil
+}
+
+// APIKeyUserID 以API密钥认证时声明中的用户ID
+func APIKeyUserID(id int) string {
+	return "apikey:" + strconv.Itoa(id)
 }
 
 // GetUserFromContext 从上下文获取用户信息
@@ -152,18 +342,27 @@ func GetUserFromContext(ctx context.Context) (*Claims, bool) {
 	return user, ok
 }
 
+// OrgIDFromContext 获取请求所属组织，未认证的请求返回0
+func OrgIDFromContext(ctx context.Context) int {
+	user, ok := GetUserFromContext(ctx)
+	if !ok {
+		return 0
+	}
+	return user.EffectiveOrgID()
+}
+
 // RequireRole 要求特定角色的中间件
 func (a *AuthMiddleware) RequireRole(role string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			user, ok := GetUserFromContext(r.Context())
 			if !ok {
-				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Unauthorized")
 				return
 			}
 
 			if user.Role != role && user.Role != "admin" {
-				http.Error(w, "Forbidden: insufficient permissions", http.StatusForbidden)
+				utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: insufficient permissions")
 				return
 			}
 
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BindAll 监听所有IPv4和IPv6地址
+const BindAll = "*"
+
+// UnixSocketPrefix 以该前缀开头的监听地址是unix套接字路径，如 unix:/run/tunnel-flow/api.sock
+const UnixSocketPrefix = "unix:"
+
+// defaultUnixSocketMode unix套接字文件的默认权限，只允许所有者和同组用户（如 nginx）连接
+const defaultUnixSocketMode = "0660"
+
+// APIListenAddress 返回API端口的监听网络和地址
+func (c *Config) APIListenAddress() (network, address string) {
+	return listenAddress(c.APIBind, c.ServerHost, c.APIPort)
+}
+
+// WebSocketListenAddress 返回WebSocket端口的监听网络和地址
+func (c *Config) WebSocketListenAddress() (network, address string) {
+	return listenAddress(c.WebSocketBind, c.ServerHost, c.WebSocketPort)
+}
+
+// ProxyListenAddress 返回代理端口的监听网络和地址，没有配置 proxy.bind 时监听所有地址
+func (c *Config) ProxyListenAddress(port int) (network, address string) {
+	return listenAddress(c.ProxyBind, "", port)
+}
+
+// RouteListenAddress 返回路由独立端口的监听网络和地址，与代理端口使用相同的IP地址，代理端口使用unix套接字时监听所有地址
+func (c *Config) RouteListenAddress(port int) (network, address string) {
+	if strings.HasPrefix(strings.TrimSpace(c.ProxyBind), UnixSocketPrefix) {
+		return listenAddress("", "", port)
+	}
+	return listenAddress(c.ProxyBind, "", port)
+}
+
+// UnixSocketFileMode unix套接字文件的权限
+func (c *Config) UnixSocketFileMode() os.FileMode {
+	mode, err := strconv.ParseUint(c.UnixSocketMode, 8, 32)
+	if err != nil {
+		mode, _ = strconv.ParseUint(defaultUnixSocketMode, 8, 32)
+	}
+	return os.FileMode(mode)
+}
+
+// listenAddress 根据监听地址选择网络：unix: 开头的是unix套接字，IPv4地址只监听IPv4，IPv6地址只监听IPv6，
+// * 和主机名由系统决定，没有配置时按原来的方式监听 fallback
+func listenAddress(bind, fallback string, port int) (network, address string) {
+	portStr := strconv.Itoa(port)
+	bind = strings.TrimSpace(bind)
+	if strings.HasPrefix(bind, UnixSocketPrefix) {
+		return "unix", strings.TrimPrefix(bind, UnixSocketPrefix)
+	}
+	switch bind {
+	case "":
+		return "tcp", net.JoinHostPort(fallback, portStr)
+	case BindAll:
+		return "tcp", net.JoinHostPort("", portStr)
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(bind, "["), "]")
+	ip := net.ParseIP(strings.SplitN(host, "%", 2)[0])
+	switch {
+	case ip == nil:
+		return "tcp", net.JoinHostPort(host, portStr)
+	case ip.To4() != nil:
+		return "tcp4", net.JoinHostPort(host, portStr)
+	default:
+		return "tcp6", net.JoinHostPort(host, portStr)
+	}
+}
+
+// validateBind 检查监听地址是IP地址、主机名、* 或unix套接字路径，不能带端口
+func validateBind(bind string, allowUnix bool) error {
+	bind = strings.TrimSpace(bind)
+	if bind == "" || bind == BindAll {
+		return nil
+	}
+	if strings.HasPrefix(bind, UnixSocketPrefix) {
+		if !allowUnix {
+			return fmt.Errorf("unix sockets are only supported for api.bind and proxy.bind")
+		}
+		if strings.TrimPrefix(bind, UnixSocketPrefix) == "" {
+			return fmt.Errorf("unix socket path must not be empty")
+		}
+		return nil
+	}
+	host := bind
+	if strings.HasPrefix(host, "[") {
+		if !strings.HasSuffix(host, "]") {
+			return fmt.Errorf("invalid address %q, the port is configured separately", bind)
+		}
+		host = host[1 : len(host)-1]
+	}
+	if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); ip != nil {
+		return nil
+	}
+	if strings.ContainsAny(host, ":/[] ") {
+		return fmt.Errorf("invalid address %q, must be an IP address, host name or *, the port is configured separately", bind)
+	}
+	return nil
+}
@@ -2,11 +2,18 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"tunnel-flow/internal/geoip"
+	"tunnel-flow/internal/protocol"
 )
 
 // Config 配置结构
@@ -16,16 +23,38 @@ type Config struct {
 	WebSocketPort int    `json:"websocket_port" yaml:"server.websocket_port"` // WebSocket端口 (8081)
 	ProxyPort     int    `json:"proxy_port" yaml:"server.proxy_port"`         // HTTP代理端口 (8082)
 	ServerHost    string `json:"server_host" yaml:"server.host"`
-	ServerURL     string `json:"server_url"`
+	// 各端口单独的监听地址，为空时API和WebSocket端口使用 server.host，代理端口监听所有地址；
+	// * 表示同时监听所有IPv4和IPv6地址，IPv4地址（0.0.0.0 为所有IPv4地址）只监听IPv4，IPv6地址（:: 为所有IPv6地址）只监听IPv6
+	APIBind       string `json:"api_bind" yaml:"api.bind"`
+	WebSocketBind string `json:"websocket_bind" yaml:"websocket.bind"`
+	ProxyBind     string `json:"proxy_bind" yaml:"proxy.bind"` // 同时用于路由的独立端口
+	// api.bind 或 proxy.bind 为 unix:路径 时在unix套接字上提供服务（由本机的 nginx/caddy 转发），套接字文件的权限，八进制，默认0660
+	UnixSocketMode string `json:"unix_socket_mode" yaml:"server.unix_socket_mode"`
+	ServerURL      string `json:"server_url"`
+	ReadOnly       bool   `json:"read_only" yaml:"server.read_only"` // 只读模式启动，拒绝所有修改管理配置的请求
+	// 不中断重启：新进程用 SO_REUSEPORT 绑定与旧进程相同的端口，旧进程停止接受连接后等进行中的请求完成，再在 drain_seconds 内分批断开代理连接
+	ReusePort    bool `json:"reuse_port" yaml:"server.reuse_port"`
+	DrainSeconds int  `json:"drain_seconds" yaml:"server.drain_seconds"` // 停止时分批断开代理连接的时间，0表示同时断开
+	// 收到停止信号后 /ready 立即返回503，等待该时间让负载均衡（如 Kubernetes Service）摘除本实例后再停止接受连接
+	ShutdownDelaySeconds int `json:"shutdown_delay_seconds" yaml:"server.shutdown_delay_seconds"`
 
 	// 兼容性配置 (保持向后兼容)
 	ServerPort int `json:"server_port"` // 主端口，用于向后兼容
 
 	// 数据库配置
 	DatabasePath string `json:"database_path" yaml:"database.path"`
+	// 敏感字段加密：客户端认证令牌、组织签名密钥和代理请求的载荷使用AES-256-GCM加密保存，密钥从环境变量或文件读取，不写在配置文件中
+	DatabaseEncryptionEnabled bool   `json:"database_encryption_enabled" yaml:"database.encryption.enabled"`
+	DatabaseEncryptionKeyEnv  string `json:"database_encryption_key_env" yaml:"database.encryption.key_env"`   // 保存密钥的环境变量名，默认 DATABASE_ENCRYPTION_KEY
+	DatabaseEncryptionKeyFile string `json:"database_encryption_key_file" yaml:"database.encryption.key_file"` // 保存密钥的文件路径，优先于环境变量
+	DatabaseEncryptionKey     string `json:"-" yaml:"-"`                                                       // 启动时从环境变量或文件读取的密钥
 
 	// WebSocket配置
 	SendQueueSize int `json:"send_queue_size" yaml:"websocket.send_queue_size"`
+	// 与代理之间单条消息的最大字节数，超过时拆分为分段发送，0表示不限制
+	WebSocketMaxMessageBytes int `json:"websocket_max_message_bytes" yaml:"websocket.max_message_bytes"`
+	// 代理断线后保留会话的秒数，期间用注册时下发的恢复令牌重连可以继续未完成的请求，0表示不保留
+	WebSocketResumeGraceSeconds int `json:"websocket_resume_grace_seconds" yaml:"websocket.resume_grace_seconds"`
 
 	// WebSocket SSL/TLS配置
 	WebSocketSSLEnabled  bool   `json:"websocket_ssl_enabled" yaml:"websocket.ssl.enabled"`
@@ -49,7 +78,9 @@ type Config struct {
 	RetryMaxAttempts    int     `json:"retry_max_attempts" yaml:"retry.max_attempts"`
 
 	// 性能优化配置
-	WorkerPoolSize   int `json:"worker_pool_size" yaml:"performance.worker_pool_size"`
+	WorkerPoolSize   int `json:"worker_pool_size" yaml:"performance.worker_pool_size"`   // 最少工作协程数
+	WorkerPoolMax    int `json:"worker_pool_max" yaml:"performance.worker_pool_max"`     // 最多工作协程数，不大于 worker_pool_size 时不自动扩缩容
+	WorkerLatencyMS  int `json:"worker_latency_ms" yaml:"performance.worker_latency_ms"` // 所有工作协程都忙且任务平均耗时超过该值时扩容
 	WorkerQueueSize  int `json:"worker_queue_size" yaml:"performance.worker_queue_size"`
 	MessageQueueSize int `json:"message_queue_size" yaml:"performance.message_queue_size"`
 	BatchSize        int `json:"batch_size" yaml:"performance.batch_size"`
@@ -63,8 +94,88 @@ type Config struct {
 	// 缓存配置
 	CacheSize       int `json:"cache_size" yaml:"cache.size"`
 	CacheTTLSeconds int `json:"cache_ttl_seconds" yaml:"cache.ttl_seconds"`
+
+	// 客户端代理配置
+	MinAgentVersion        string `json:"min_agent_version" yaml:"agent.min_version"`                     // 低于该版本的代理注册时输出告警，为空不检查
+	RejectOutdatedAgents   bool   `json:"reject_outdated_agents" yaml:"agent.reject_outdated"`            // 拒绝低于最低版本的代理注册，默认只告警
+	RequireAgentEncryption bool   `json:"require_agent_encryption" yaml:"agent.require_encryption"`       // 拒绝未启用载荷加密的代理注册
+	SignatureWindowSeconds int    `json:"signature_window_seconds" yaml:"agent.signature_window_seconds"` // 签名消息的时间与服务端时间相差超过该秒数时拒绝，默认300
+	AllowAgentRoutes       bool   `json:"allow_agent_routes" yaml:"agent.allow_routes"`                   // 代理注册后按其配置中声明的路由创建、更新和删除路由
+	AgentRouteApproval     bool   `json:"agent_route_approval" yaml:"agent.require_route_approval"`       // 代理声明的新路由和目标变更需管理员批准后才转发
+
+	// 载荷压缩配置：服务端与支持压缩的代理之间的消息载荷，路由可以单独覆盖
+	CompressionAlgorithm string `json:"compression_algorithm" yaml:"compression.algorithm"` // none、gzip（默认）或 deflate
+	CompressionMinBytes  int    `json:"compression_min_bytes" yaml:"compression.min_bytes"` // 载荷达到该字节数才压缩，默认1024
+
+	// 用量配额配置
+	QuotaWebhookURL string `json:"quota_webhook_url" yaml:"quota.webhook_url"` // 用量达到配额80%时通知的地址，客户端可单独覆盖
+
+	// 客户端健康检查配置：短时间窗口内代理请求错误率过高的客户端暂时不参与路由选择
+	HealthWindowSeconds   int    `json:"health_window_seconds" yaml:"health.window_seconds"`     // 统计错误率的时间窗口，默认60秒
+	HealthErrorThreshold  int    `json:"health_error_threshold" yaml:"health.error_threshold"`   // 错误率达到该百分比时标记为不健康，默认50，0表示关闭
+	HealthMinRequests     int    `json:"health_min_requests" yaml:"health.min_requests"`         // 窗口内请求数达到该值才计算错误率，默认10
+	HealthCooldownSeconds int    `json:"health_cooldown_seconds" yaml:"health.cooldown_seconds"` // 标记为不健康后暂停选择的时间，默认30秒
+	HealthWebhookURL      string `json:"health_webhook_url" yaml:"health.webhook_url"`           // 客户端健康状态变化时通知的地址，为空不通知
+
+	// 代理配置
+	ProxyTenantDomain             string                `json:"proxy_tenant_domain" yaml:"proxy.tenant_domain"`                             // 租户子域名的上级域名，如 tunnel.example.com，为空时只按路径区分组织
+	ProxyPathPrefix               string                `json:"proxy_path_prefix" yaml:"proxy.path_prefix"`                                 // 代理请求的路径前缀，默认 /proxy
+	ProxyDirectMode               bool                  `json:"proxy_direct_mode" yaml:"proxy.direct_mode"`                                 // 是否允许不带前缀直接按路由路径访问，默认开启
+	ProxyPublicURL                string                `json:"proxy_public_url" yaml:"proxy.public_url"`                                   // 外部访问代理端口的地址，如 https://tunnel.example.com，用于生成分享链接，为空时按监听地址生成
+	ProxyGroupSelection           string                `json:"proxy_group_selection" yaml:"proxy.group_selection"`                         // 分组路由选择成员的方式：least_latency（默认）或 round_robin
+	ProxyIdempotencyWindowSeconds int                   `json:"proxy_idempotency_window_seconds" yaml:"proxy.idempotency_window_seconds"`   // 带Idempotency-Key的请求在该时间内重试时返回保存的响应，默认86400，0表示关闭
+	ProxyCompression              bool                  `json:"proxy_compression" yaml:"proxy.compression"`                                 // 客户端支持时是否gzip压缩代理响应，默认开启
+	ProxyCompressionMinSize       int                   `json:"proxy_compression_min_size" yaml:"proxy.compression_min_size"`               // 响应体达到该字节数才压缩，默认1024
+	ProxyReadHeaderTimeoutSeconds int                   `json:"proxy_read_header_timeout_seconds" yaml:"proxy.read_header_timeout_seconds"` // 读取请求头的超时（秒），默认10
+	ProxyMaxHeaderBytes           int                   `json:"proxy_max_header_bytes" yaml:"proxy.max_header_bytes"`                       // 请求头的最大字节数，默认65536
+	ProxyMaxConnections           int                   `json:"proxy_max_connections" yaml:"proxy.max_connections"`                         // 代理端口的最大并发连接数，超出时直接关闭新连接，默认10000，0表示不限制
+	ProxyErrorPages               ErrorPages            `json:"proxy_error_pages" yaml:"proxy.error_pages"`                                 // 路由不存在和没有可用后端时返回的页面模板，为空时返回JSON错误
+	ProxyHostErrorPages           map[string]ErrorPages `json:"proxy_host_error_pages" yaml:"proxy.error_pages.hosts"`                      // 按请求主机覆盖的页面模板，键可以是 *.example.com
+
+	// 路由自定义域名的HTTPS：代理在 tls_port 上按SNI为已验证的路由域名向ACME服务按需申请证书
+	ProxyTLSPort     int    `json:"proxy_tls_port" yaml:"proxy.tls_port"`         // HTTPS端口，通常为443，0表示关闭
+	ACMEEmail        string `json:"acme_email" yaml:"acme.email"`                 // 注册ACME账户的联系邮箱，可以为空
+	ACMEDirectoryURL string `json:"acme_directory_url" yaml:"acme.directory_url"` // ACME服务的目录地址，为空时使用 Let's Encrypt
+	ACMECacheDir     string `json:"acme_cache_dir" yaml:"acme.cache_dir"`         // 保存ACME账户密钥和证书的目录，默认 ./acme
+
+	// GeoIP数据库，用于按请求来源国家限制路由的访问，并在访问日志和请求抓取中记录国家
+	GeoIPDatabase string `json:"geoip_database" yaml:"geoip.database"` // MaxMind格式（.mmdb）的国家或城市数据库路径，为空时不解析国家
+
+	// 路由请求抓取的默认设置，路由可以单独覆盖
+	CaptureMaxBytes   int `json:"capture_max_bytes" yaml:"capture.max_bytes"`     // 请求体和响应体各自保存的最大字节数，默认65536
+	CaptureTTLSeconds int `json:"capture_ttl_seconds" yaml:"capture.ttl_seconds"` // 抓取记录保留的秒数，默认3600
+
+	// 日志输出，在日志文件之外发送到 syslog 或 Loki
+	LogSinkBufferSize      int               `json:"log_sink_buffer_size" yaml:"logging.buffer_size"`                  // 每个输出缓冲的日志条数，输出不可用且缓冲已满时丢弃新日志，默认10000
+	LogSyslogEnabled       bool              `json:"log_syslog_enabled" yaml:"logging.syslog.enabled"`                 // 是否发送到 syslog
+	LogSyslogNetwork       string            `json:"log_syslog_network" yaml:"logging.syslog.network"`                 // 为空时写入本机 syslog；udp、tcp 或 tcp+tls 时发送到远程服务器
+	LogSyslogAddress       string            `json:"log_syslog_address" yaml:"logging.syslog.address"`                 // 远程 syslog 服务器地址 host:port
+	LogSyslogTag           string            `json:"log_syslog_tag" yaml:"logging.syslog.tag"`                         // syslog 应用名称，默认 tunnel-flow
+	LogLokiURL             string            `json:"log_loki_url" yaml:"logging.loki.url"`                             // Loki push API 地址，为空不发送
+	LogLokiLabels          map[string]string `json:"log_loki_labels" yaml:"logging.loki.labels"`                       // 附加的流标签，默认 job=tunnel-flow
+	LogLokiTenantID        string            `json:"log_loki_tenant_id" yaml:"logging.loki.tenant_id"`                 // 多租户 Loki 的 X-Scope-OrgID
+	LogLokiBatchSize       int               `json:"log_loki_batch_size" yaml:"logging.loki.batch_size"`               // 每次发送的最大日志条数，默认500
+	LogLokiFlushIntervalMS int               `json:"log_loki_flush_interval_ms" yaml:"logging.loki.flush_interval_ms"` // 不满一批时最长等待时间，默认1000
+
+	// 日志脱敏和采样
+	LogRedactHeaders    []string `json:"log_redact_headers" yaml:"logging.redact.headers"`         // 在默认列表之外需要脱敏的请求头
+	LogRedactBodyFields []string `json:"log_redact_body_fields" yaml:"logging.redact.body_fields"` // 在默认列表之外需要脱敏的JSON字段和表单参数
+	LogSampleInitial    int      `json:"log_sample_initial" yaml:"logging.sampling.initial"`       // 每条路由每秒完整记录的请求数，0表示不采样
+	LogSampleThereafter int      `json:"log_sample_thereafter" yaml:"logging.sampling.thereafter"` // 超出后每多少个请求记录一个，0表示不再记录
 }
 
+// ErrorPages 代理错误页面的模板文件路径，按扩展名决定格式：.html/.htm 为HTML，.json 为JSON，其他为纯文本
+type ErrorPages struct {
+	NotFound  string `json:"not_found" yaml:"not_found"`   // 路由不存在（404）
+	NoBackend string `json:"no_backend" yaml:"no_backend"` // 没有可用后端（503）
+}
+
+// 分组成员选择方式
+const (
+	GroupSelectionLeastLatency = "least_latency" // 优先选择最近响应延迟最低的成员，测量过期时退回轮询
+	GroupSelectionRoundRobin   = "round_robin"   // 在在线成员之间轮询
+)
+
 // Load 加载配置
 func Load() (*Config, error) {
 	config := &Config{
@@ -74,8 +185,16 @@ func Load() (*Config, error) {
 		ProxyPort:     8082, // HTTP代理端口
 		ServerPort:    8080, // 向后兼容
 		ServerHost:    "0.0.0.0",
-		DatabasePath:  "",
-		SendQueueSize: 1000,
+		// unix套接字只允许所有者和同组用户连接
+		UnixSocketMode: defaultUnixSocketMode,
+		DatabasePath:   "",
+		// 数据库加密密钥默认从该环境变量读取
+		DatabaseEncryptionKeyEnv: "DATABASE_ENCRYPTION_KEY",
+		SendQueueSize:            1000,
+		// 单条消息默认不超过1MB
+		WebSocketMaxMessageBytes: 1 << 20,
+		// 断线后保留会话30秒
+		WebSocketResumeGraceSeconds: 30,
 		// WebSocket SSL 默认配置
 		WebSocketSSLEnabled:  true,
 		WebSocketSSLCertFile: "./ssl/server.crt",
@@ -92,6 +211,8 @@ func Load() (*Config, error) {
 		RetryMaxAttempts:     5,
 		// 性能优化默认值
 		WorkerPoolSize:   10,
+		WorkerPoolMax:    50,
+		WorkerLatencyMS:  200,
 		WorkerQueueSize:  1000,
 		MessageQueueSize: 10000,
 		BatchSize:        100,
@@ -103,6 +224,30 @@ func Load() (*Config, error) {
 		// 缓存默认值
 		CacheSize:       1000,
 		CacheTTLSeconds: 300,
+		// 客户端代理默认值
+		SignatureWindowSeconds: 300,
+		// 载荷压缩默认值
+		CompressionAlgorithm: protocol.CompressionGzip,
+		CompressionMinBytes:  1024,
+		// 客户端健康检查默认值
+		HealthWindowSeconds:   60,
+		HealthErrorThreshold:  50,
+		HealthMinRequests:     10,
+		HealthCooldownSeconds: 30,
+		// 代理默认值
+		ProxyPathPrefix:               "/proxy",
+		ProxyDirectMode:               true,
+		ProxyGroupSelection:           GroupSelectionLeastLatency,
+		ProxyIdempotencyWindowSeconds: 86400,
+		ProxyCompression:              true,
+		ProxyCompressionMinSize:       1024,
+		ProxyReadHeaderTimeoutSeconds: 10,
+		ProxyMaxHeaderBytes:           64 * 1024,
+		ProxyMaxConnections:           10000,
+		ACMECacheDir:                  "./acme",
+		// 请求抓取默认值
+		CaptureMaxBytes:   64 * 1024,
+		CaptureTTLSeconds: 3600,
 	}
 
 	// 尝试从YAML文件读取配置
@@ -133,14 +278,192 @@ func Load() (*Config, error) {
 		config.ServerHost = host
 	}
 
+	if readOnly, err := strconv.ParseBool(os.Getenv("READ_ONLY")); err == nil {
+		config.ReadOnly = readOnly
+	}
+
+	if reusePort, err := strconv.ParseBool(os.Getenv("SERVER_REUSE_PORT")); err == nil {
+		config.ReusePort = reusePort
+	}
+
+	if drain := os.Getenv("SERVER_DRAIN_SECONDS"); drain != "" {
+		if value, err := strconv.Atoi(drain); err == nil && value >= 0 {
+			config.DrainSeconds = value
+		}
+	}
+
+	if delay := os.Getenv("SERVER_SHUTDOWN_DELAY_SECONDS"); delay != "" {
+		if value, err := strconv.Atoi(delay); err == nil && value >= 0 {
+			config.ShutdownDelaySeconds = value
+		}
+	}
+
 	if dbPath := os.Getenv("DATABASE_PATH"); dbPath != "" {
 		config.DatabasePath = dbPath
 	}
 
+	if enabled, err := strconv.ParseBool(os.Getenv("DATABASE_ENCRYPTION_ENABLED")); err == nil {
+		config.DatabaseEncryptionEnabled = enabled
+	}
+
+	if keyFile := os.Getenv("DATABASE_ENCRYPTION_KEY_FILE"); keyFile != "" {
+		config.DatabaseEncryptionKeyFile = keyFile
+	}
+
+	if minVersion := os.Getenv("MIN_AGENT_VERSION"); minVersion != "" {
+		config.MinAgentVersion = minVersion
+	}
+
+	if reject, err := strconv.ParseBool(os.Getenv("REJECT_OUTDATED_AGENTS")); err == nil {
+		config.RejectOutdatedAgents = reject
+	}
+
+	if require, err := strconv.ParseBool(os.Getenv("REQUIRE_AGENT_ENCRYPTION")); err == nil {
+		config.RequireAgentEncryption = require
+	}
+
+	if allow, err := strconv.ParseBool(os.Getenv("ALLOW_AGENT_ROUTES")); err == nil {
+		config.AllowAgentRoutes = allow
+	}
+
+	if require, err := strconv.ParseBool(os.Getenv("REQUIRE_AGENT_ROUTE_APPROVAL")); err == nil {
+		config.AgentRouteApproval = require
+	}
+
+	if window := getEnvInt("SIGNATURE_WINDOW_SECONDS"); window > 0 {
+		config.SignatureWindowSeconds = window
+	}
+
+	if algorithm := os.Getenv("COMPRESSION_ALGORITHM"); algorithm != "" {
+		config.CompressionAlgorithm = algorithm
+	}
+
+	if minBytes := os.Getenv("COMPRESSION_MIN_BYTES"); minBytes != "" {
+		if value, err := strconv.Atoi(minBytes); err == nil && value >= 0 {
+			config.CompressionMinBytes = value
+		}
+	}
+
+	if webhookURL := os.Getenv("QUOTA_WEBHOOK_URL"); webhookURL != "" {
+		config.QuotaWebhookURL = webhookURL
+	}
+
+	if window := getEnvInt("HEALTH_WINDOW_SECONDS"); window > 0 {
+		config.HealthWindowSeconds = window
+	}
+
+	if threshold := os.Getenv("HEALTH_ERROR_THRESHOLD"); threshold != "" {
+		if value, err := strconv.Atoi(threshold); err == nil {
+			config.HealthErrorThreshold = value
+		}
+	}
+
+	if minRequests := getEnvInt("HEALTH_MIN_REQUESTS"); minRequests > 0 {
+		config.HealthMinRequests = minRequests
+	}
+
+	if cooldown := getEnvInt("HEALTH_COOLDOWN_SECONDS"); cooldown > 0 {
+		config.HealthCooldownSeconds = cooldown
+	}
+
+	if webhookURL := os.Getenv("HEALTH_WEBHOOK_URL"); webhookURL != "" {
+		config.HealthWebhookURL = webhookURL
+	}
+
+	if tenantDomain := os.Getenv("PROXY_TENANT_DOMAIN"); tenantDomain != "" {
+		config.ProxyTenantDomain = tenantDomain
+	}
+
+	if prefix := os.Getenv("PROXY_PATH_PREFIX"); prefix != "" {
+		config.ProxyPathPrefix = prefix
+	}
+
+	if directMode, err := strconv.ParseBool(os.Getenv("PROXY_DIRECT_MODE")); err == nil {
+		config.ProxyDirectMode = directMode
+	}
+
+	if publicURL := os.Getenv("PROXY_PUBLIC_URL"); publicURL != "" {
+		config.ProxyPublicURL = publicURL
+	}
+
+	if selection := os.Getenv("PROXY_GROUP_SELECTION"); selection != "" {
+		config.ProxyGroupSelection = selection
+	}
+
+	if window := os.Getenv("PROXY_IDEMPOTENCY_WINDOW_SECONDS"); window != "" {
+		if value, err := strconv.Atoi(window); err == nil {
+			config.ProxyIdempotencyWindowSeconds = value
+		}
+	}
+
+	if compression, err := strconv.ParseBool(os.Getenv("PROXY_COMPRESSION")); err == nil {
+		config.ProxyCompression = compression
+	}
+
+	if minSize := os.Getenv("PROXY_COMPRESSION_MIN_SIZE"); minSize != "" {
+		if value, err := strconv.Atoi(minSize); err == nil {
+			config.ProxyCompressionMinSize = value
+		}
+	}
+
+	if timeout := getEnvInt("PROXY_READ_HEADER_TIMEOUT_SECONDS"); timeout > 0 {
+		config.ProxyReadHeaderTimeoutSeconds = timeout
+	}
+
+	if maxHeaderBytes := getEnvInt("PROXY_MAX_HEADER_BYTES"); maxHeaderBytes > 0 {
+		config.ProxyMaxHeaderBytes = maxHeaderBytes
+	}
+
+	if maxConnections := os.Getenv("PROXY_MAX_CONNECTIONS"); maxConnections != "" {
+		if value, err := strconv.Atoi(maxConnections); err == nil {
+			config.ProxyMaxConnections = value
+		}
+	}
+
+	if port := getEnvInt("PROXY_TLS_PORT"); port > 0 {
+		config.ProxyTLSPort = port
+	}
+
+	if email := os.Getenv("ACME_EMAIL"); email != "" {
+		config.ACMEEmail = email
+	}
+
+	if directoryURL := os.Getenv("ACME_DIRECTORY_URL"); directoryURL != "" {
+		config.ACMEDirectoryURL = directoryURL
+	}
+
+	if cacheDir := os.Getenv("ACME_CACHE_DIR"); cacheDir != "" {
+		config.ACMECacheDir = cacheDir
+	}
+
+	if database := os.Getenv("GEOIP_DATABASE"); database != "" {
+		config.GeoIPDatabase = database
+	}
+
+	if page := os.Getenv("PROXY_NOT_FOUND_PAGE"); page != "" {
+		config.ProxyErrorPages.NotFound = page
+	}
+
+	if page := os.Getenv("PROXY_NO_BACKEND_PAGE"); page != "" {
+		config.ProxyErrorPages.NoBackend = page
+	}
+
 	if queueSize := getEnvInt("SEND_QUEUE_SIZE"); queueSize > 0 {
 		config.SendQueueSize = queueSize
 	}
 
+	if maxBytes := os.Getenv("WEBSOCKET_MAX_MESSAGE_BYTES"); maxBytes != "" {
+		if value, err := strconv.Atoi(maxBytes); err == nil {
+			config.WebSocketMaxMessageBytes = value
+		}
+	}
+
+	if grace := os.Getenv("WEBSOCKET_RESUME_GRACE_SECONDS"); grace != "" {
+		if value, err := strconv.Atoi(grace); err == nil {
+			config.WebSocketResumeGraceSeconds = value
+		}
+	}
+
 	if secret := os.Getenv("AUTH_JWT_SECRET"); secret != "" {
 		config.AuthJWTSecret = secret
 	}
@@ -182,6 +505,14 @@ func Load() (*Config, error) {
 		config.WorkerPoolSize = poolSize
 	}
 
+	if poolMax := getEnvInt("WORKER_POOL_MAX"); poolMax > 0 {
+		config.WorkerPoolMax = poolMax
+	}
+
+	if latency := getEnvInt("WORKER_LATENCY_MS"); latency > 0 {
+		config.WorkerLatencyMS = latency
+	}
+
 	if queueSize := getEnvInt("WORKER_QUEUE_SIZE"); queueSize > 0 {
 		config.WorkerQueueSize = queueSize
 	}
@@ -220,14 +551,206 @@ func Load() (*Config, error) {
 		config.CacheTTLSeconds = ttl
 	}
 
+	if maxBytes := getEnvInt("CAPTURE_MAX_BYTES"); maxBytes > 0 {
+		config.CaptureMaxBytes = maxBytes
+	}
+
+	if ttl := getEnvInt("CAPTURE_TTL_SECONDS"); ttl > 0 {
+		config.CaptureTTLSeconds = ttl
+	}
+
+	if bufferSize := getEnvInt("LOG_SINK_BUFFER_SIZE"); bufferSize > 0 {
+		config.LogSinkBufferSize = bufferSize
+	}
+
+	if enabled, err := strconv.ParseBool(os.Getenv("LOG_SYSLOG_ENABLED")); err == nil {
+		config.LogSyslogEnabled = enabled
+	}
+
+	if network := os.Getenv("LOG_SYSLOG_NETWORK"); network != "" {
+		config.LogSyslogNetwork = network
+	}
+
+	if address := os.Getenv("LOG_SYSLOG_ADDRESS"); address != "" {
+		config.LogSyslogAddress = address
+	}
+
+	if lokiURL := os.Getenv("LOG_LOKI_URL"); lokiURL != "" {
+		config.LogLokiURL = lokiURL
+	}
+
+	if tenantID := os.Getenv("LOG_LOKI_TENANT_ID"); tenantID != "" {
+		config.LogLokiTenantID = tenantID
+	}
+
+	if headers := os.Getenv("LOG_REDACT_HEADERS"); headers != "" {
+		config.LogRedactHeaders = strings.Split(headers, ",")
+	}
+
+	if fields := os.Getenv("LOG_REDACT_BODY_FIELDS"); fields != "" {
+		config.LogRedactBodyFields = strings.Split(fields, ",")
+	}
+
+	if initial := os.Getenv("LOG_SAMPLE_INITIAL"); initial != "" {
+		if value, err := strconv.Atoi(initial); err == nil {
+			config.LogSampleInitial = value
+		}
+	}
+
+	if thereafter := os.Getenv("LOG_SAMPLE_THEREAFTER"); thereafter != "" {
+		if value, err := strconv.Atoi(thereafter); err == nil {
+			config.LogSampleThereafter = value
+		}
+	}
+
+	// TF_ 前缀的环境变量可以覆盖任意配置项
+	if err := applyPrefixedEnv(config); err != nil {
+		return nil, err
+	}
+
+	// 代理通过网络连接WebSocket端口，只有API和代理端口可以使用unix套接字
+	for name, bind := range map[string]string{"api.bind": config.APIBind, "websocket.bind": config.WebSocketBind, "proxy.bind": config.ProxyBind} {
+		if err := validateBind(bind, name != "websocket.bind"); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if mode, err := strconv.ParseUint(config.UnixSocketMode, 8, 32); err != nil || mode > 0777 {
+		return nil, fmt.Errorf("server unix_socket_mode must be an octal file mode such as 0660")
+	}
+
+	// 规范化代理前缀：以/开头、不以/结尾，不能为根路径
+	config.ProxyPathPrefix = "/" + strings.Trim(config.ProxyPathPrefix, "/")
+	if config.ProxyPathPrefix == "/" {
+		return nil, fmt.Errorf("proxy path_prefix must not be the root path")
+	}
+	if config.ProxyPublicURL != "" {
+		publicURL, err := url.Parse(config.ProxyPublicURL)
+		if err != nil || (publicURL.Scheme != "http" && publicURL.Scheme != "https") || publicURL.Host == "" {
+			return nil, fmt.Errorf("proxy public_url must be an http or https URL")
+		}
+		config.ProxyPublicURL = strings.TrimSuffix(config.ProxyPublicURL, "/")
+	}
+	if config.ProxyTLSPort != 0 {
+		if config.ProxyTLSPort < 0 || config.ProxyTLSPort > 65535 {
+			return nil, fmt.Errorf("proxy tls_port must be between 1 and 65535")
+		}
+		if config.ProxyTLSPort == config.APIPort || config.ProxyTLSPort == config.WebSocketPort || config.ProxyTLSPort == config.ProxyPort {
+			return nil, fmt.Errorf("proxy tls_port %d is already used by another server port", config.ProxyTLSPort)
+		}
+		if config.ACMEDirectoryURL != "" {
+			if directoryURL, err := url.Parse(config.ACMEDirectoryURL); err != nil || directoryURL.Scheme != "https" || directoryURL.Host == "" {
+				return nil, fmt.Errorf("acme directory_url must be an https URL")
+			}
+		}
+		if config.ACMECacheDir == "" {
+			return nil, fmt.Errorf("acme cache_dir must not be empty")
+		}
+	}
+	switch config.ProxyGroupSelection {
+	case GroupSelectionLeastLatency, GroupSelectionRoundRobin:
+	default:
+		return nil, fmt.Errorf("proxy group_selection must be %s or %s", GroupSelectionLeastLatency, GroupSelectionRoundRobin)
+	}
+	if config.WebSocketMaxMessageBytes < 0 || (config.WebSocketMaxMessageBytes > 0 && config.WebSocketMaxMessageBytes < protocol.MinMaxMessageBytes) {
+		return nil, fmt.Errorf("websocket max_message_bytes must be 0 or at least %d", protocol.MinMaxMessageBytes)
+	}
+	if config.WebSocketResumeGraceSeconds < 0 {
+		return nil, fmt.Errorf("websocket resume_grace_seconds must not be negative")
+	}
+	if !protocol.IsValidCompressionAlgorithm(config.CompressionAlgorithm) {
+		return nil, fmt.Errorf("compression algorithm must be %s, %s or %s", protocol.CompressionNone, protocol.CompressionGzip, protocol.CompressionDeflate)
+	}
+	if err := validateErrorPages(config.ProxyErrorPages); err != nil {
+		return nil, err
+	}
+	for host, pages := range config.ProxyHostErrorPages {
+		if err := validateErrorPages(pages); err != nil {
+			return nil, fmt.Errorf("proxy error_pages for host %s: %w", host, err)
+		}
+	}
+	// GeoIP数据库在启动时检查格式，路由的国家限制依赖它
+	if config.GeoIPDatabase != "" {
+		reader, err := geoip.Open(config.GeoIPDatabase)
+		if err != nil {
+			return nil, fmt.Errorf("geoip database %s: %w", config.GeoIPDatabase, err)
+		}
+		reader.Close()
+	}
+
+	if config.DatabaseEncryptionEnabled {
+		key, err := loadDatabaseEncryptionKey(config.DatabaseEncryptionKeyFile, config.DatabaseEncryptionKeyEnv)
+		if err != nil {
+			return nil, err
+		}
+		config.DatabaseEncryptionKey = key
+	}
+
+	if config.LogSyslogEnabled {
+		switch config.LogSyslogNetwork {
+		case "":
+		case "udp", "tcp", "tcp+tls":
+			if config.LogSyslogAddress == "" {
+				return nil, fmt.Errorf("logging syslog address is required for network %s", config.LogSyslogNetwork)
+			}
+		default:
+			return nil, fmt.Errorf("logging syslog network must be empty, udp, tcp or tcp+tls")
+		}
+	}
+	if config.LogSampleInitial < 0 || config.LogSampleThereafter < 0 {
+		return nil, fmt.Errorf("logging sampling initial and thereafter must not be negative")
+	}
+	if config.LogLokiURL != "" {
+		if parsed, err := url.Parse(config.LogLokiURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("logging loki url must be an http or https URL")
+		}
+	}
+
 	// 构建服务器URL
 	if config.ServerURL == "" {
-		config.ServerURL = fmt.Sprintf("http://%s:%d", config.ServerHost, config.ServerPort)
+		config.ServerURL = fmt.Sprintf("http://%s", net.JoinHostPort(config.ServerHost, strconv.Itoa(config.ServerPort)))
 	}
 
 	return config, nil
 }
 
+// validateErrorPages 检查错误页面模板文件可以读取且语法正确，启动时发现配置错误
+func validateErrorPages(pages ErrorPages) error {
+	for _, path := range []string{pages.NotFound, pages.NoBackend} {
+		if path == "" {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read proxy error page: %w", err)
+		}
+		if _, err := template.New(path).Funcs(template.FuncMap{"json": func(interface{}) string { return "" }}).Parse(string(content)); err != nil {
+			return fmt.Errorf("invalid proxy error page %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadDatabaseEncryptionKey 读取数据库加密密钥，配置了文件时从文件读取，否则从指定的环境变量读取
+func loadDatabaseEncryptionKey(keyFile, keyEnv string) (string, error) {
+	var key string
+	if keyFile != "" {
+		content, err := os.ReadFile(keyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read database encryption key file: %w", err)
+		}
+		key = strings.TrimSpace(string(content))
+	} else if keyEnv != "" {
+		key = os.Getenv(keyEnv)
+	}
+	if key == "" {
+		return "", fmt.Errorf("database encryption is enabled but no key is set in key_file or the %s environment variable", keyEnv)
+	}
+	if len(key) < 16 {
+		return "", fmt.Errorf("database encryption key must be at least 16 characters")
+	}
+	return key, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -269,6 +792,10 @@ func (c *Config) BatchTimeout() time.Duration {
 	return time.Duration(c.BatchTimeoutMS) * time.Millisecond
 }
 
+func (c *Config) WorkerLatencyThreshold() time.Duration {
+	return time.Duration(c.WorkerLatencyMS) * time.Millisecond
+}
+
 func (c *Config) ConnMaxLifetimeDuration() time.Duration {
 	return time.Duration(c.ConnMaxLifetime) * time.Second
 }
@@ -277,6 +804,22 @@ func (c *Config) CacheTTL() time.Duration {
 	return time.Duration(c.CacheTTLSeconds) * time.Second
 }
 
+func (c *Config) ResumeGrace() time.Duration {
+	return time.Duration(c.WebSocketResumeGraceSeconds) * time.Second
+}
+
+func (c *Config) DrainPeriod() time.Duration {
+	return time.Duration(c.DrainSeconds) * time.Second
+}
+
+func (c *Config) ShutdownDelay() time.Duration {
+	return time.Duration(c.ShutdownDelaySeconds) * time.Second
+}
+
+func (c *Config) LokiFlushInterval() time.Duration {
+	return time.Duration(c.LogLokiFlushIntervalMS) * time.Millisecond
+}
+
 // loadFromYAML 从YAML文件加载配置
 func loadFromYAML(config *Config) error {
 	// 尝试读取config.yaml文件
@@ -288,17 +831,34 @@ func loadFromYAML(config *Config) error {
 	// 创建一个嵌套结构来匹配YAML格式
 	var yamlConfig struct {
 		Server struct {
-			APIPort       int    `yaml:"api_port"`
-			WebSocketPort int    `yaml:"websocket_port"`
-			ProxyPort     int    `yaml:"proxy_port"`
-			Host          string `yaml:"host"`
+			APIPort              int    `yaml:"api_port"`
+			WebSocketPort        int    `yaml:"websocket_port"`
+			ProxyPort            int    `yaml:"proxy_port"`
+			Host                 string `yaml:"host"`
+			ReadOnly             bool   `yaml:"read_only"`
+			ReusePort            bool   `yaml:"reuse_port"`
+			DrainSeconds         int    `yaml:"drain_seconds"`
+			ShutdownDelaySeconds int    `yaml:"shutdown_delay_seconds"`
+			UnixSocketMode       string `yaml:"unix_socket_mode"`
 		} `yaml:"server"`
+		API struct {
+			Bind string `yaml:"bind"`
+		} `yaml:"api"`
 		Database struct {
-			Path string `yaml:"path"`
+			Path       string `yaml:"path"`
+			Encryption struct {
+				Enabled bool   `yaml:"enabled"`
+				KeyEnv  string `yaml:"key_env"`
+				KeyFile string `yaml:"key_file"`
+			} `yaml:"encryption"`
 		} `yaml:"database"`
 		WebSocket struct {
-			SendQueueSize int `yaml:"send_queue_size"`
-			SSL           struct {
+			Bind            string `yaml:"bind"`
+			SendQueueSize   int    `yaml:"send_queue_size"`
+			MaxMessageBytes *int   `yaml:"max_message_bytes"`
+			// 断线后保留会话的秒数
+			ResumeGraceSeconds *int `yaml:"resume_grace_seconds"`
+			SSL                struct {
 				Enabled  bool   `yaml:"enabled"`
 				CertFile string `yaml:"cert_file"`
 				KeyFile  string `yaml:"key_file"`
@@ -322,6 +882,8 @@ func loadFromYAML(config *Config) error {
 		} `yaml:"retry"`
 		Performance struct {
 			WorkerPoolSize   int `yaml:"worker_pool_size"`
+			WorkerPoolMax    int `yaml:"worker_pool_max"`
+			WorkerLatencyMS  int `yaml:"worker_latency_ms"`
 			WorkerQueueSize  int `yaml:"worker_queue_size"`
 			MessageQueueSize int `yaml:"message_queue_size"`
 			BatchSize        int `yaml:"batch_size"`
@@ -336,6 +898,84 @@ func loadFromYAML(config *Config) error {
 			Size       int `yaml:"size"`
 			TTLSeconds int `yaml:"ttl_seconds"`
 		} `yaml:"cache"`
+		Agent struct {
+			MinVersion        string `yaml:"min_version"`
+			RejectOutdated    bool   `yaml:"reject_outdated"`
+			RequireEncryption bool   `yaml:"require_encryption"`
+			SignatureWindow   int    `yaml:"signature_window_seconds"`
+			AllowRoutes       bool   `yaml:"allow_routes"`
+			RequireApproval   bool   `yaml:"require_route_approval"`
+		} `yaml:"agent"`
+		Compression struct {
+			Algorithm string `yaml:"algorithm"`
+			MinBytes  *int   `yaml:"min_bytes"`
+		} `yaml:"compression"`
+		Quota struct {
+			WebhookURL string `yaml:"webhook_url"`
+		} `yaml:"quota"`
+		Health struct {
+			WindowSeconds   int    `yaml:"window_seconds"`
+			ErrorThreshold  *int   `yaml:"error_threshold"`
+			MinRequests     int    `yaml:"min_requests"`
+			CooldownSeconds int    `yaml:"cooldown_seconds"`
+			WebhookURL      string `yaml:"webhook_url"`
+		} `yaml:"health"`
+		Proxy struct {
+			Bind                     string `yaml:"bind"`
+			TenantDomain             string `yaml:"tenant_domain"`
+			PathPrefix               string `yaml:"path_prefix"`
+			DirectMode               *bool  `yaml:"direct_mode"`
+			PublicURL                string `yaml:"public_url"`
+			GroupSelection           string `yaml:"group_selection"`
+			IdempotencyWindowSeconds *int   `yaml:"idempotency_window_seconds"`
+			Compression              *bool  `yaml:"compression"`
+			CompressionMinSize       *int   `yaml:"compression_min_size"`
+			ReadHeaderTimeoutSeconds int    `yaml:"read_header_timeout_seconds"`
+			MaxHeaderBytes           int    `yaml:"max_header_bytes"`
+			MaxConnections           *int   `yaml:"max_connections"`
+			TLSPort                  int    `yaml:"tls_port"`
+			ErrorPages               struct {
+				NotFound  string                `yaml:"not_found"`
+				NoBackend string                `yaml:"no_backend"`
+				Hosts     map[string]ErrorPages `yaml:"hosts"`
+			} `yaml:"error_pages"`
+		} `yaml:"proxy"`
+		ACME struct {
+			Email        string `yaml:"email"`
+			DirectoryURL string `yaml:"directory_url"`
+			CacheDir     string `yaml:"cache_dir"`
+		} `yaml:"acme"`
+		GeoIP struct {
+			Database string `yaml:"database"`
+		} `yaml:"geoip"`
+		Capture struct {
+			MaxBytes   int `yaml:"max_bytes"`
+			TTLSeconds int `yaml:"ttl_seconds"`
+		} `yaml:"capture"`
+		Logging struct {
+			BufferSize int `yaml:"buffer_size"`
+			Syslog     struct {
+				Enabled bool   `yaml:"enabled"`
+				Network string `yaml:"network"`
+				Address string `yaml:"address"`
+				Tag     string `yaml:"tag"`
+			} `yaml:"syslog"`
+			Loki struct {
+				URL             string            `yaml:"url"`
+				Labels          map[string]string `yaml:"labels"`
+				TenantID        string            `yaml:"tenant_id"`
+				BatchSize       int               `yaml:"batch_size"`
+				FlushIntervalMS int               `yaml:"flush_interval_ms"`
+			} `yaml:"loki"`
+			Redact struct {
+				Headers    []string `yaml:"headers"`
+				BodyFields []string `yaml:"body_fields"`
+			} `yaml:"redact"`
+			Sampling struct {
+				Initial    int `yaml:"initial"`
+				Thereafter int `yaml:"thereafter"`
+			} `yaml:"sampling"`
+		} `yaml:"logging"`
 	}
 
 	// 解析YAML
@@ -356,12 +996,37 @@ func loadFromYAML(config *Config) error {
 	if yamlConfig.Server.Host != "" {
 		config.ServerHost = yamlConfig.Server.Host
 	}
+	config.APIBind = yamlConfig.API.Bind
+	config.WebSocketBind = yamlConfig.WebSocket.Bind
+	config.ProxyBind = yamlConfig.Proxy.Bind
+	if yamlConfig.Server.UnixSocketMode != "" {
+		config.UnixSocketMode = yamlConfig.Server.UnixSocketMode
+	}
+	config.ReadOnly = yamlConfig.Server.ReadOnly
+	config.ReusePort = yamlConfig.Server.ReusePort
+	if yamlConfig.Server.DrainSeconds > 0 {
+		config.DrainSeconds = yamlConfig.Server.DrainSeconds
+	}
+	if yamlConfig.Server.ShutdownDelaySeconds > 0 {
+		config.ShutdownDelaySeconds = yamlConfig.Server.ShutdownDelaySeconds
+	}
 	if yamlConfig.Database.Path != "" {
 		config.DatabasePath = yamlConfig.Database.Path
 	}
+	config.DatabaseEncryptionEnabled = yamlConfig.Database.Encryption.Enabled
+	if yamlConfig.Database.Encryption.KeyEnv != "" {
+		config.DatabaseEncryptionKeyEnv = yamlConfig.Database.Encryption.KeyEnv
+	}
+	config.DatabaseEncryptionKeyFile = yamlConfig.Database.Encryption.KeyFile
 	if yamlConfig.WebSocket.SendQueueSize > 0 {
 		config.SendQueueSize = yamlConfig.WebSocket.SendQueueSize
 	}
+	if yamlConfig.WebSocket.MaxMessageBytes != nil {
+		config.WebSocketMaxMessageBytes = *yamlConfig.WebSocket.MaxMessageBytes
+	}
+	if yamlConfig.WebSocket.ResumeGraceSeconds != nil {
+		config.WebSocketResumeGraceSeconds = *yamlConfig.WebSocket.ResumeGraceSeconds
+	}
 	// WebSocket SSL 配置
 	config.WebSocketSSLEnabled = yamlConfig.WebSocket.SSL.Enabled
 	if yamlConfig.WebSocket.SSL.CertFile != "" {
@@ -401,6 +1066,12 @@ func loadFromYAML(config *Config) error {
 	if yamlConfig.Performance.WorkerPoolSize > 0 {
 		config.WorkerPoolSize = yamlConfig.Performance.WorkerPoolSize
 	}
+	if yamlConfig.Performance.WorkerPoolMax > 0 {
+		config.WorkerPoolMax = yamlConfig.Performance.WorkerPoolMax
+	}
+	if yamlConfig.Performance.WorkerLatencyMS > 0 {
+		config.WorkerLatencyMS = yamlConfig.Performance.WorkerLatencyMS
+	}
 	if yamlConfig.Performance.WorkerQueueSize > 0 {
 		config.WorkerQueueSize = yamlConfig.Performance.WorkerQueueSize
 	}
@@ -428,6 +1099,126 @@ func loadFromYAML(config *Config) error {
 	if yamlConfig.Cache.TTLSeconds > 0 {
 		config.CacheTTLSeconds = yamlConfig.Cache.TTLSeconds
 	}
+	if yamlConfig.Agent.MinVersion != "" {
+		config.MinAgentVersion = yamlConfig.Agent.MinVersion
+	}
+	config.RejectOutdatedAgents = yamlConfig.Agent.RejectOutdated
+	config.RequireAgentEncryption = yamlConfig.Agent.RequireEncryption
+	config.AllowAgentRoutes = yamlConfig.Agent.AllowRoutes
+	config.AgentRouteApproval = yamlConfig.Agent.RequireApproval
+	if yamlConfig.Agent.SignatureWindow > 0 {
+		config.SignatureWindowSeconds = yamlConfig.Agent.SignatureWindow
+	}
+	if yamlConfig.Compression.Algorithm != "" {
+		config.CompressionAlgorithm = yamlConfig.Compression.Algorithm
+	}
+	if yamlConfig.Compression.MinBytes != nil && *yamlConfig.Compression.MinBytes >= 0 {
+		config.CompressionMinBytes = *yamlConfig.Compression.MinBytes
+	}
+	if yamlConfig.Quota.WebhookURL != "" {
+		config.QuotaWebhookURL = yamlConfig.Quota.WebhookURL
+	}
+	if yamlConfig.Health.WindowSeconds > 0 {
+		config.HealthWindowSeconds = yamlConfig.Health.WindowSeconds
+	}
+	if yamlConfig.Health.ErrorThreshold != nil {
+		config.HealthErrorThreshold = *yamlConfig.Health.ErrorThreshold
+	}
+	if yamlConfig.Health.MinRequests > 0 {
+		config.HealthMinRequests = yamlConfig.Health.MinRequests
+	}
+	if yamlConfig.Health.CooldownSeconds > 0 {
+		config.HealthCooldownSeconds = yamlConfig.Health.CooldownSeconds
+	}
+	if yamlConfig.Health.WebhookURL != "" {
+		config.HealthWebhookURL = yamlConfig.Health.WebhookURL
+	}
+	if yamlConfig.Proxy.TenantDomain != "" {
+		config.ProxyTenantDomain = yamlConfig.Proxy.TenantDomain
+	}
+	if yamlConfig.Proxy.PublicURL != "" {
+		config.ProxyPublicURL = yamlConfig.Proxy.PublicURL
+	}
+	if yamlConfig.Proxy.PathPrefix != "" {
+		config.ProxyPathPrefix = yamlConfig.Proxy.PathPrefix
+	}
+	if yamlConfig.Proxy.DirectMode != nil {
+		config.ProxyDirectMode = *yamlConfig.Proxy.DirectMode
+	}
+	if yamlConfig.Proxy.GroupSelection != "" {
+		config.ProxyGroupSelection = yamlConfig.Proxy.GroupSelection
+	}
+	if yamlConfig.Proxy.IdempotencyWindowSeconds != nil {
+		config.ProxyIdempotencyWindowSeconds = *yamlConfig.Proxy.IdempotencyWindowSeconds
+	}
+	if yamlConfig.Proxy.Compression != nil {
+		config.ProxyCompression = *yamlConfig.Proxy.Compression
+	}
+	if yamlConfig.Proxy.CompressionMinSize != nil {
+		config.ProxyCompressionMinSize = *yamlConfig.Proxy.CompressionMinSize
+	}
+	if yamlConfig.Proxy.ReadHeaderTimeoutSeconds > 0 {
+		config.ProxyReadHeaderTimeoutSeconds = yamlConfig.Proxy.ReadHeaderTimeoutSeconds
+	}
+	if yamlConfig.Proxy.MaxHeaderBytes > 0 {
+		config.ProxyMaxHeaderBytes = yamlConfig.Proxy.MaxHeaderBytes
+	}
+	if yamlConfig.Proxy.MaxConnections != nil {
+		config.ProxyMaxConnections = *yamlConfig.Proxy.MaxConnections
+	}
+	if yamlConfig.Proxy.TLSPort > 0 {
+		config.ProxyTLSPort = yamlConfig.Proxy.TLSPort
+	}
+	if yamlConfig.ACME.Email != "" {
+		config.ACMEEmail = yamlConfig.ACME.Email
+	}
+	if yamlConfig.ACME.DirectoryURL != "" {
+		config.ACMEDirectoryURL = yamlConfig.ACME.DirectoryURL
+	}
+	if yamlConfig.ACME.CacheDir != "" {
+		config.ACMECacheDir = yamlConfig.ACME.CacheDir
+	}
+	if yamlConfig.GeoIP.Database != "" {
+		config.GeoIPDatabase = yamlConfig.GeoIP.Database
+	}
+	if yamlConfig.Proxy.ErrorPages.NotFound != "" {
+		config.ProxyErrorPages.NotFound = yamlConfig.Proxy.ErrorPages.NotFound
+	}
+	if yamlConfig.Proxy.ErrorPages.NoBackend != "" {
+		config.ProxyErrorPages.NoBackend = yamlConfig.Proxy.ErrorPages.NoBackend
+	}
+	if len(yamlConfig.Proxy.ErrorPages.Hosts) > 0 {
+		config.ProxyHostErrorPages = make(map[string]ErrorPages, len(yamlConfig.Proxy.ErrorPages.Hosts))
+		for host, pages := range yamlConfig.Proxy.ErrorPages.Hosts {
+			config.ProxyHostErrorPages[strings.ToLower(host)] = pages
+		}
+	}
+	if yamlConfig.Capture.MaxBytes > 0 {
+		config.CaptureMaxBytes = yamlConfig.Capture.MaxBytes
+	}
+	if yamlConfig.Capture.TTLSeconds > 0 {
+		config.CaptureTTLSeconds = yamlConfig.Capture.TTLSeconds
+	}
+	if yamlConfig.Logging.BufferSize > 0 {
+		config.LogSinkBufferSize = yamlConfig.Logging.BufferSize
+	}
+	config.LogSyslogEnabled = yamlConfig.Logging.Syslog.Enabled
+	config.LogSyslogNetwork = yamlConfig.Logging.Syslog.Network
+	config.LogSyslogAddress = yamlConfig.Logging.Syslog.Address
+	config.LogSyslogTag = yamlConfig.Logging.Syslog.Tag
+	config.LogLokiURL = yamlConfig.Logging.Loki.URL
+	config.LogLokiLabels = yamlConfig.Logging.Loki.Labels
+	config.LogLokiTenantID = yamlConfig.Logging.Loki.TenantID
+	if yamlConfig.Logging.Loki.BatchSize > 0 {
+		config.LogLokiBatchSize = yamlConfig.Logging.Loki.BatchSize
+	}
+	if yamlConfig.Logging.Loki.FlushIntervalMS > 0 {
+		config.LogLokiFlushIntervalMS = yamlConfig.Logging.Loki.FlushIntervalMS
+	}
+	config.LogRedactHeaders = yamlConfig.Logging.Redact.Headers
+	config.LogRedactBodyFields = yamlConfig.Logging.Redact.BodyFields
+	config.LogSampleInitial = yamlConfig.Logging.Sampling.Initial
+	config.LogSampleThereafter = yamlConfig.Logging.Sampling.Thereafter
 
 	return nil
 }
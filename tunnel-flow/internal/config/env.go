@@ -0,0 +1,128 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// envPrefix 统一环境变量的前缀：每个配置项都可以用 TF_ 加上大写的YAML路径覆盖，
+// 路径中的点换成下划线，例如 websocket.ssl.cert_file 对应 TF_WEBSOCKET_SSL_CERT_FILE
+const envPrefix = "TF_"
+
+// envName 返回YAML路径对应的环境变量名
+func envName(path string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// applyPrefixedEnv 用 TF_ 前缀的环境变量覆盖配置，优先级高于配置文件和旧的环境变量，
+// 列表用逗号分隔，映射用逗号分隔的 key=value，其他复合类型用JSON
+func applyPrefixedEnv(config *Config) error {
+	var errs []string
+	forEachEnvField(reflect.ValueOf(config).Elem(), "", func(path string, field reflect.Value) {
+		name := envName(path)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if err := setFromEnv(field, value); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	})
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("invalid environment variables: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// forEachEnvField 遍历带YAML路径的字段，没有完整路径的结构体字段（如 ErrorPages）按子字段展开
+func forEachEnvField(v reflect.Value, prefix string, fn func(path string, field reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		tag := t.Field(i).Tag.Get("yaml")
+		if tag == "" || tag == "-" || !t.Field(i).IsExported() {
+			continue
+		}
+		path := tag
+		if prefix != "" {
+			path = prefix + "." + tag
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			forEachEnvField(field, path, fn)
+			continue
+		}
+		fn(path, field)
+	}
+}
+
+// setFromEnv 按字段类型解析环境变量的值
+func setFromEnv(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(value, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		field.SetInt(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("expected a number")
+		}
+		field.SetFloat(parsed)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return setFromJSON(field, value)
+		}
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if field.Type().Elem().Kind() != reflect.String || strings.HasPrefix(strings.TrimSpace(value), "{") {
+			return setFromJSON(field, value)
+		}
+		pairs := make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			key, val, ok := strings.Cut(item, "=")
+			if !ok {
+				return fmt.Errorf("expected comma-separated key=value pairs")
+			}
+			pairs[strings.TrimSpace(key)] = strings.TrimSpace(val)
+		}
+		field.Set(reflect.ValueOf(pairs))
+	default:
+		return setFromJSON(field, value)
+	}
+	return nil
+}
+
+// setFromJSON 用JSON解析复合类型的值
+func setFromJSON(field reflect.Value, value string) error {
+	target := reflect.New(field.Type())
+	if err := json.Unmarshal([]byte(value), target.Interface()); err != nil {
+		return fmt.Errorf("expected JSON: %v", err)
+	}
+	field.Set(target.Elem())
+	return nil
+}
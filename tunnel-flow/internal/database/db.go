@@ -1,8 +1,10 @@
 package database
 
 import (
+	"crypto/cipher"
 	"database/sql"
 	"fmt"
+	"sync"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -11,26 +13,35 @@ import (
 // DB 数据库连接
 type DB struct {
 	*sql.DB
+	path    string
+	secrets cipher.AEAD // 敏感字段加密器，为空时明文保存
+
+	mu      sync.RWMutex // 重新连接时替换 *sql.DB
+	metrics dbMetrics
+	closed  chan struct{}
+	closing sync.Once
 }
 
 // New 创建新的数据库连接
 func New(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite", dbPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
-	}
-
-	// 配置连接池 - 减少并发连接数以避免SQLite锁定
-	db.SetMaxOpenConns(1)  // SQLite建议使用单连接避免锁定
-	db.SetMaxIdleConns(1)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	return NewWithKey(dbPath, "")
+}
 
-	// 测试连接
-	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+// NewWithKey 创建数据库连接，密钥不为空时敏感字段加密保存，见 encryptedColumns
+func NewWithKey(dbPath, encryptionKey string) (*DB, error) {
+	wrapper := &DB{path: dbPath, closed: make(chan struct{})}
+	if encryptionKey != "" {
+		var err error
+		if wrapper.secrets, err = newSecretCipher(encryptionKey); err != nil {
+			return nil, fmt.Errorf("failed to initialize database encryption: %w", err)
+		}
 	}
 
-	wrapper := &DB{DB: db}
+	db, err := wrapper.open()
+	if err != nil {
+		return nil, err
+	}
+	wrapper.DB = db
 
 	// 初始化数据库
 	if err := wrapper.init(); err != nil {
@@ -40,8 +51,24 @@ func New(dbPath string) (*DB, error) {
 	return wrapper, nil
 }
 
-// init 初始化数据库
-func (db *DB) init() error {
+// open 打开数据库文件并设置连接参数，启动和重新连接时使用
+func (db *DB) open() (*sql.DB, error) {
+	handle, err := sql.Open("sqlite", db.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	// 配置连接池 - 减少并发连接数以避免SQLite锁定
+	handle.SetMaxOpenConns(1)  // SQLite建议使用单连接避免锁定
+	handle.SetMaxIdleConns(1)
+	handle.SetConnMaxLifetime(5 * time.Minute)
+
+	// 测试连接
+	if err := handle.Ping(); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
 	// 设置PRAGMA
 	pragmas := []string{
 		"PRAGMA journal_mode = WAL",     // WAL模式提供更好的并发性能
@@ -52,12 +79,23 @@ func (db *DB) init() error {
 		"PRAGMA wal_autocheckpoint = 1000", // WAL模式下的自动检查点
 	}
 
+	if db.secrets != nil {
+		// 加密明文字段时清零被覆盖的旧数据，避免明文残留在数据库文件的空闲页中
+		pragmas = append(pragmas, "PRAGMA secure_delete = ON")
+	}
+
 	for _, pragma := range pragmas {
-		if _, err := db.Exec(pragma); err != nil {
-			return fmt.Errorf("failed to execute pragma %s: %w", pragma, err)
+		if _, err := handle.Exec(pragma); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("failed to execute pragma %s: %w", pragma, err)
 		}
 	}
 
+	return handle, nil
+}
+
+// init 初始化数据库
+func (db *DB) init() error {
 	// 创建表
 	if err := db.createTables(); err != nil {
 		return fmt.Errorf("failed to create tables: %w", err)
@@ -68,6 +106,11 @@ func (db *DB) init() error {
 		return fmt.Errorf("failed to migrate client tables: %w", err)
 	}
 
+	// 校验加密密钥并加密遗留的明文敏感字段
+	if err := db.migrateSecrets(); err != nil {
+		return fmt.Errorf("failed to migrate encrypted secrets: %w", err)
+	}
+
 	return nil
 }
 
@@ -110,6 +153,84 @@ func (db *DB) createTables() error {
 			last_update INTEGER,
 			response_meta_json TEXT
 		)`,
+		`CREATE TABLE IF NOT EXISTS client_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			description TEXT,
+			org_id INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER,
+			updated_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS organizations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			slug TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			signing_key TEXT,
+			created_at INTEGER,
+			updated_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL,
+			org_id INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER,
+			last_login INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			org_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			key_prefix TEXT NOT NULL,
+			key_hash TEXT NOT NULL UNIQUE,
+			role TEXT NOT NULL,
+			created_by TEXT,
+			created_at INTEGER,
+			last_used_at INTEGER,
+			expires_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS resource_acls (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			org_id INTEGER NOT NULL,
+			resource_type TEXT NOT NULL,
+			resource_id TEXT NOT NULL,
+			subject_type TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			permission TEXT NOT NULL,
+			created_by TEXT,
+			created_at INTEGER,
+			UNIQUE(resource_type, resource_id, subject_type, subject)
+		)`,
+		`CREATE TABLE IF NOT EXISTS client_group_members (
+			group_id INTEGER NOT NULL,
+			client_id TEXT NOT NULL,
+			PRIMARY KEY(group_id, client_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS client_stats (
+			client_id TEXT PRIMARY KEY,
+			stats_json TEXT NOT NULL,
+			reported_at INTEGER
+		)`,
+		`CREATE TABLE IF NOT EXISTS traffic_hourly (
+			hour_ts INTEGER NOT NULL,
+			client_id TEXT NOT NULL,
+			route_id INTEGER NOT NULL,
+			url_suffix TEXT,
+			requests INTEGER NOT NULL DEFAULT 0,
+			errors INTEGER NOT NULL DEFAULT 0,
+			bytes_in INTEGER NOT NULL DEFAULT 0,
+			bytes_out INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(hour_ts, client_id, route_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS client_quotas (
+			client_id TEXT PRIMARY KEY,
+			requests_per_day INTEGER NOT NULL DEFAULT 0,
+			bytes_per_month INTEGER NOT NULL DEFAULT 0,
+			webhook_url TEXT,
+			created_at INTEGER,
+			updated_at INTEGER
+		)`,
 		`CREATE TABLE IF NOT EXISTS audit_logs (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			msg_id TEXT,
@@ -118,6 +239,58 @@ func (db *DB) createTables() error {
 			payload_summary TEXT,
 			ts INTEGER
 		)`,
+		`CREATE TABLE IF NOT EXISTS connection_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_id TEXT NOT NULL,
+			remote_ip TEXT,
+			connected_at INTEGER NOT NULL,
+			disconnected_at INTEGER,
+			duration_ms INTEGER,
+			reason TEXT
+		)`,
+		`CREATE TABLE IF NOT EXISTS dead_letters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			msg_id TEXT NOT NULL,
+			client_id TEXT NOT NULL,
+			url_suffix TEXT NOT NULL DEFAULT '',
+			request_meta_json TEXT NOT NULL DEFAULT '',
+			retry_count INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			dead_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS client_reservations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			client_id TEXT NOT NULL,
+			port_start INTEGER NOT NULL DEFAULT 0,
+			port_end INTEGER NOT NULL DEFAULT 0,
+			domain TEXT NOT NULL DEFAULT '',
+			created_by TEXT,
+			created_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS request_captures (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			request_id TEXT NOT NULL DEFAULT '',
+			org_id INTEGER NOT NULL,
+			route_id INTEGER NOT NULL,
+			client_id TEXT NOT NULL DEFAULT '',
+			method TEXT NOT NULL,
+			url_suffix TEXT NOT NULL,
+			query TEXT NOT NULL DEFAULT '',
+			request_headers TEXT NOT NULL DEFAULT '',
+			request_body BLOB,
+			request_size INTEGER NOT NULL DEFAULT 0,
+			request_truncated INTEGER NOT NULL DEFAULT 0,
+			status INTEGER NOT NULL DEFAULT 0,
+			response_headers TEXT NOT NULL DEFAULT '',
+			response_body BLOB,
+			response_size INTEGER NOT NULL DEFAULT 0,
+			response_truncated INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`,
 	}
 
 	for _, table := range tables {
@@ -138,9 +311,29 @@ func (db *DB) createTables() error {
 		"CREATE INDEX IF NOT EXISTS idx_pending_messages_client_id ON pending_messages(client_id)",
 		"CREATE INDEX IF NOT EXISTS idx_pending_messages_state ON pending_messages(state)",
 		"CREATE INDEX IF NOT EXISTS idx_pending_messages_next_try_ts ON pending_messages(next_try_ts)",
+		"CREATE INDEX IF NOT EXISTS idx_pending_messages_created_at ON pending_messages(created_at)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_pending_messages_idempotency_key ON pending_messages(idempotency_key) WHERE idempotency_key != ''",
+		"CREATE INDEX IF NOT EXISTS idx_server_routes_group_id ON server_routes(group_id)",
+		"CREATE INDEX IF NOT EXISTS idx_client_group_members_client_id ON client_group_members(client_id)",
+		"CREATE INDEX IF NOT EXISTS idx_traffic_hourly_client_id ON traffic_hourly(client_id, hour_ts)",
+		"CREATE INDEX IF NOT EXISTS idx_traffic_hourly_route_id ON traffic_hourly(route_id, hour_ts)",
+		"CREATE INDEX IF NOT EXISTS idx_traffic_hourly_org_id ON traffic_hourly(org_id, hour_ts)",
+		"CREATE INDEX IF NOT EXISTS idx_clients_org_id ON clients(org_id)",
+		"CREATE INDEX IF NOT EXISTS idx_server_routes_org_id ON server_routes(org_id)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_client_groups_org_name ON client_groups(org_id, name)",
+		"CREATE INDEX IF NOT EXISTS idx_users_org_id ON users(org_id)",
+		"CREATE INDEX IF NOT EXISTS idx_api_keys_org_id ON api_keys(org_id)",
+		"CREATE INDEX IF NOT EXISTS idx_resource_acls_subject ON resource_acls(org_id, subject_type, subject)",
 		"CREATE INDEX IF NOT EXISTS idx_audit_logs_msg_id ON audit_logs(msg_id)",
 		"CREATE INDEX IF NOT EXISTS idx_audit_logs_client_id ON audit_logs(client_id)",
 		"CREATE INDEX IF NOT EXISTS idx_audit_logs_ts ON audit_logs(ts)",
+		"CREATE INDEX IF NOT EXISTS idx_connection_history_client_id ON connection_history(client_id, id)",
+		"CREATE INDEX IF NOT EXISTS idx_dead_letters_client_id ON dead_letters(client_id)",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_server_routes_listen_port ON server_routes(listen_port) WHERE listen_port > 0",
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_server_routes_domain ON server_routes(domain) WHERE domain != ''",
+		"CREATE INDEX IF NOT EXISTS idx_client_reservations_client_id ON client_reservations(client_id)",
+		"CREATE INDEX IF NOT EXISTS idx_request_captures_org_id ON request_captures(org_id, id)",
+		"CREATE INDEX IF NOT EXISTS idx_request_captures_expires_at ON request_captures(expires_at)",
 	}
 
 	for _, index := range indexes {
@@ -164,11 +357,185 @@ func (db *DB) migrateDatabase() error {
 		return fmt.Errorf("failed to migrate server_routes V2: %w", err)
 	}
 
+	// 添加乐观锁版本号字段
+	if err := db.MigrateVersionColumns(); err != nil {
+		return fmt.Errorf("failed to migrate version columns: %w", err)
+	}
+
+	// 路由支持指向客户端分组
+	if err := db.MigrateClientGroups(); err != nil {
+		return fmt.Errorf("failed to migrate client groups: %w", err)
+	}
+
+	// 记录代理版本、平台和能力
+	if err := db.MigrateAgentInfo(); err != nil {
+		return fmt.Errorf("failed to migrate agent info: %w", err)
+	}
+
+	// 多租户：数据归属组织
+	if err := db.MigrateOrganizations(); err != nil {
+		return fmt.Errorf("failed to migrate organizations: %w", err)
+	}
+
+	// 组织独立的签名密钥
+	if _, err := db.addColumnIfNotExists("organizations", "signing_key", "TEXT"); err != nil {
+		return fmt.Errorf("failed to migrate organization signing keys: %w", err)
+	}
+
+	// 路由的请求头和查询参数匹配条件
+	if _, err := db.addColumnIfNotExists("server_routes", "match_headers", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate route match headers: %w", err)
+	}
+	if _, err := db.addColumnIfNotExists("server_routes", "match_query", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate route match query: %w", err)
+	}
+
+	// 路由权重
+	if _, err := db.addColumnIfNotExists("server_routes", "weight", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+		return fmt.Errorf("failed to migrate route weight: %w", err)
+	}
+
+	// 路由对冲请求延迟
+	if _, err := db.addColumnIfNotExists("server_routes", "hedge_delay_ms", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route hedge delay: %w", err)
+	}
+
+	// 代理请求的幂等键
+	if _, err := db.addColumnIfNotExists("pending_messages", "idempotency_key", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate pending message idempotency key: %w", err)
+	}
+
+	// 路由响应压缩方式
+	if _, err := db.addColumnIfNotExists("server_routes", "compression", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate route compression: %w", err)
+	}
+
+	// 路由超时设置
+	for _, column := range []string{"connect_timeout_ms", "header_timeout_ms", "body_idle_timeout_ms", "total_timeout_ms", "stream_idle_timeout_ms", "stream_total_timeout_ms"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+
+	// 路由是否允许代理缓存响应
+	if _, err := db.addColumnIfNotExists("server_routes", "cacheable", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route cacheable: %w", err)
+	}
+
+	// 路由的载荷压缩设置
+	if _, err := db.addColumnIfNotExists("server_routes", "payload_compression", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate route payload compression: %w", err)
+	}
+	if _, err := db.addColumnIfNotExists("server_routes", "payload_compression_min_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route payload compression min bytes: %w", err)
+	}
+
+	// 路由的独立监听端口
+	if _, err := db.addColumnIfNotExists("server_routes", "listen_port", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route listen_port: %w", err)
+	}
+
+	// 路由的请求抓取设置
+	if _, err := db.addColumnIfNotExists("server_routes", "capture", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route capture: %w", err)
+	}
+	for _, column := range []string{"capture_max_bytes", "capture_ttl_seconds"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+
+	// 路由的故障注入设置
+	for _, column := range []string{"fault_delay_ms", "fault_error_percent", "fault_error_status", "fault_drop_percent"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+
+	// 路由的来源，代理声明的路由在代理重新注册时同步
+	if _, err := db.addColumnIfNotExists("server_routes", "source", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate route source: %w", err)
+	}
+
+	// 代理声明路由的审批状态
+	for _, column := range []string{"approval_status", "approval_note", "reviewed_by"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+	if _, err := db.addColumnIfNotExists("server_routes", "reviewed_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route reviewed_at: %w", err)
+	}
+
+	// 临时路由的到期时间和到期后的处理方式
+	if _, err := db.addColumnIfNotExists("server_routes", "expires_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route expires_at: %w", err)
+	}
+	if _, err := db.addColumnIfNotExists("server_routes", "expire_action", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate route expire_action: %w", err)
+	}
+
+	// 私有路由和分享链接版本
+	if _, err := db.addColumnIfNotExists("server_routes", "private", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route private: %w", err)
+	}
+	if _, err := db.addColumnIfNotExists("server_routes", "share_version", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route share_version: %w", err)
+	}
+
+	// 路由的HTTP基本认证
+	for _, column := range []string{"basic_auth_user", "basic_auth_hash"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+
+	// 路由的自定义域名及其所有权验证
+	for _, column := range []string{"domain", "domain_verify_method", "domain_verify_token", "domain_verify_error"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+	if _, err := db.addColumnIfNotExists("server_routes", "domain_verified_at", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return fmt.Errorf("failed to migrate route domain_verified_at: %w", err)
+	}
+
+	// 路由按请求来源国家的访问限制，请求抓取记录来源国家
+	for _, column := range []string{"allowed_countries", "denied_countries"} {
+		if _, err := db.addColumnIfNotExists("server_routes", column, "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to migrate route %s: %w", column, err)
+		}
+	}
+	if _, err := db.addColumnIfNotExists("request_captures", "country", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate request capture country: %w", err)
+	}
+
+	// 客户端允许代理连接的目标白名单
+	if _, err := db.addColumnIfNotExists("clients", "allowed_targets", "TEXT"); err != nil {
+		return fmt.Errorf("failed to migrate client allowed_targets: %w", err)
+	}
+
+	// 客户端最近一次断开连接的原因和时间
+	if _, err := db.addColumnIfNotExists("clients", "last_disconnect_reason", "TEXT"); err != nil {
+		return fmt.Errorf("failed to migrate client last_disconnect_reason: %w", err)
+	}
+	if _, err := db.addColumnIfNotExists("clients", "last_disconnected_at", "INTEGER"); err != nil {
+		return fmt.Errorf("failed to migrate client last_disconnected_at: %w", err)
+	}
+
+	// 代理请求失败的原因
+	if _, err := db.addColumnIfNotExists("pending_messages", "last_error", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return fmt.Errorf("failed to migrate pending message last_error: %w", err)
+	}
+
 	return nil
 }
 
-// Close 关闭数据库连接
+// Close 关闭数据库连接，同时停止后台的重新连接
 func (db *DB) Close() error {
+	db.closing.Do(func() { close(db.closed) })
+	db.mu.Lock()
+	defer db.mu.Unlock()
 	return db.DB.Close()
 }
 
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// encryptedPrefix 加密字段的前缀，没有前缀的值按明文读取，兼容启用加密之前写入的数据
+const encryptedPrefix = "enc:v1:"
+
+// ErrEncryptionKeyRequired 数据库中有加密字段但没有配置密钥
+var ErrEncryptionKeyRequired = errors.New("database contains encrypted secrets but no encryption key is configured")
+
+// encryptedColumns 需要加密保存的字段：客户端认证令牌、组织签名密钥，以及代理请求的载荷，
+// 即待处理消息和死信的请求/响应元数据（包括请求头和请求体）、请求抓取的请求头、请求体、响应头和响应体。
+// 用于查询和统计的字段仍为明文，如客户端ID、请求方法、路径、查询参数、状态码、错误信息和审计日志摘要
+var encryptedColumns = []struct {
+	table  string
+	key    string
+	column string
+}{
+	{table: "clients", key: "client_id", column: "auth_token"},
+	{table: "organizations", key: "id", column: "signing_key"},
+	{table: "pending_messages", key: "msg_id", column: "request_meta_json"},
+	{table: "pending_messages", key: "msg_id", column: "response_meta_json"},
+	{table: "dead_letters", key: "id", column: "request_meta_json"},
+	{table: "request_captures", key: "id", column: "request_headers"},
+	{table: "request_captures", key: "id", column: "request_body"},
+	{table: "request_captures", key: "id", column: "response_headers"},
+	{table: "request_captures", key: "id", column: "response_body"},
+}
+
+// newSecretCipher 根据配置的密钥创建AES-256-GCM加密器，任意长度的密钥经SHA-256派生为32字节
+func newSecretCipher(key string) (cipher.AEAD, error) {
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypted 是否启用了敏感字段加密
+func (db *DB) Encrypted() bool {
+	return db.secrets != nil
+}
+
+// sealSecret 加密敏感字段，未启用加密或值为空时原样返回
+func (db *DB) sealSecret(value string) (string, error) {
+	if db.secrets == nil || value == "" || strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	return db.seal([]byte(value))
+}
+
+// sealBytes 加密二进制字段（请求抓取的请求体和响应体），未启用加密或值为空时原样返回
+func (db *DB) sealBytes(value []byte) ([]byte, error) {
+	if db.secrets == nil || len(value) == 0 {
+		return value, nil
+	}
+	sealed, err := db.seal(value)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(sealed), nil
+}
+
+// seal 加密并编码为带前缀的字符串
+func (db *DB) seal(value []byte) (string, error) {
+	nonce := make([]byte, db.secrets.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := db.secrets.Seal(nonce, nonce, value, nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// openSecret 解密敏感字段，没有加密前缀的值按明文返回
+func (db *DB) openSecret(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	if db.secrets == nil {
+		return "", ErrEncryptionKeyRequired
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	nonceSize := db.secrets.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("invalid encrypted value: too short")
+	}
+	plain, err := db.secrets.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value, the encryption key may be wrong: %w", err)
+	}
+	return string(plain), nil
+}
+
+// openBytes 解密二进制字段，没有加密前缀的值原样返回
+func (db *DB) openBytes(value []byte) ([]byte, error) {
+	if !bytes.HasPrefix(value, []byte(encryptedPrefix)) {
+		return value, nil
+	}
+	plain, err := db.openSecret(string(value))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(plain), nil
+}
+
+// migrateSecrets 启动时检查加密字段：启用加密时校验密钥并加密遗留的明文，
+// 未启用加密时如果已有加密数据则拒绝启动，避免在没有密钥的情况下运行后客户端全部认证失败
+func (db *DB) migrateSecrets() error {
+	for _, column := range encryptedColumns {
+		var sample sql.NullString
+		err := db.QueryRow(fmt.Sprintf(`SELECT %s FROM %s WHERE %s LIKE ? LIMIT 1`, column.column, column.table, column.column),
+			encryptedPrefix+"%").Scan(&sample)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to check encrypted %s.%s: %w", column.table, column.column, err)
+		}
+		if sample.Valid {
+			if _, err := db.openSecret(sample.String); err != nil {
+				return fmt.Errorf("%s.%s: %w", column.table, column.column, err)
+			}
+		}
+		if db.secrets == nil {
+			continue
+		}
+
+		if err := db.encryptColumn(column.table, column.key, column.column); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encryptColumn 加密一列中还是明文的值
+func (db *DB) encryptColumn(table, key, column string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(fmt.Sprintf(`SELECT %s, %s FROM %s WHERE %s IS NOT NULL AND %s != '' AND %s NOT LIKE ?`,
+		key, column, table, column, column, column), encryptedPrefix+"%")
+	if err != nil {
+		return fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+	}
+	plain := make(map[string]string)
+	for rows.Next() {
+		var id, value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+		}
+		plain[id] = value
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s.%s: %w", table, column, err)
+	}
+
+	for id, value := range plain {
+		sealed, err := db.sealSecret(value)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET %s = ? WHERE %s = ?`, table, column, key), sealed, id); err != nil {
+			return fmt.Errorf("failed to encrypt %s.%s: %w", table, column, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if len(plain) > 0 {
+		log.Printf("已加密 %d 条 %s.%s 明文记录", len(plain), table, column)
+	}
+	return nil
+}
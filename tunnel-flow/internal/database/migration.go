@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -100,7 +101,13 @@ func (db *DB) createNewClientsTable(tx *sql.Tx) error {
 			heartbeat_timeout INTEGER DEFAULT 90,
 			created_at INTEGER,
 			updated_at INTEGER,
-			local_ips TEXT
+			local_ips TEXT,
+			version INTEGER NOT NULL DEFAULT 1,
+			agent_version TEXT,
+			agent_os TEXT,
+			agent_arch TEXT,
+			capabilities TEXT,
+			org_id INTEGER NOT NULL DEFAULT 1
 		)
 	`
 
@@ -377,6 +384,116 @@ func (db *DB) MigrateServerRoutesV2() error {
 	return nil
 }
 
+// addColumnIfNotExists 字段不存在时为表添加字段，返回是否执行了添加
+func (db *DB) addColumnIfNotExists(table, column, definition string) (bool, error) {
+	var columnExists int
+	err := db.DB.QueryRow(`
+		SELECT COUNT(*) FROM pragma_table_info(?) 
+		WHERE name = ?
+	`, table, column).Scan(&columnExists)
+
+	if err != nil {
+		return false, fmt.Errorf("检查%s.%s字段失败: %v", table, column, err)
+	}
+
+	if columnExists > 0 {
+		return false, nil
+	}
+
+	_, err = db.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		return false, fmt.Errorf("添加%s.%s字段失败: %v", table, column, err)
+	}
+
+	log.Printf("添加%s.%s字段成功", table, column)
+	return true, nil
+}
+
+// MigrateVersionColumns 为clients和server_routes表添加乐观锁版本号字段
+func (db *DB) MigrateVersionColumns() error {
+	log.Println("开始执行版本号字段迁移...")
+
+	for _, table := range []string{"clients", "server_routes"} {
+		if _, err := db.addColumnIfNotExists(table, "version", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+			return err
+		}
+	}
+
+	log.Println("版本号字段迁移完成")
+	return nil
+}
+
+// MigrateClientGroups 为server_routes表添加group_id字段
+func (db *DB) MigrateClientGroups() error {
+	if _, err := db.addColumnIfNotExists("server_routes", "group_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// MigrateAgentInfo 为clients表添加代理版本、构建信息、平台和能力字段
+func (db *DB) MigrateAgentInfo() error {
+	for _, column := range []string{"agent_version", "agent_os", "agent_arch", "capabilities", "agent_commit", "agent_build_date"} {
+		if _, err := db.addColumnIfNotExists("clients", column, "TEXT"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateOrganizations 为客户端、路由、分组和流量汇总添加所属组织字段，
+// 已有数据归入默认组织，并把分组名称的唯一约束改为组织内唯一
+func (db *DB) MigrateOrganizations() error {
+	for _, table := range []string{"clients", "server_routes", "client_groups", "traffic_hourly"} {
+		if _, err := db.addColumnIfNotExists(table, "org_id", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UnixMilli()
+	if _, err := db.DB.Exec(`INSERT OR IGNORE INTO organizations (id, slug, name, created_at, updated_at) VALUES (?, 'default', 'Default', ?, ?)`,
+		DefaultOrgID, now, now); err != nil {
+		return fmt.Errorf("创建默认组织失败: %v", err)
+	}
+
+	// 旧版本的client_groups表在name列上有全局唯一约束，SQLite无法直接删除约束，需要重建表
+	var tableSQL string
+	if err := db.DB.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='client_groups'`).Scan(&tableSQL); err != nil {
+		return fmt.Errorf("读取client_groups表结构失败: %v", err)
+	}
+	if !strings.Contains(strings.ToUpper(tableSQL), "UNIQUE") {
+		return nil
+	}
+
+	log.Println("重建client_groups表，分组名称改为组织内唯一...")
+	tx, err := db.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`ALTER TABLE client_groups RENAME TO client_groups_old`,
+		`CREATE TABLE client_groups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			description TEXT,
+			org_id INTEGER NOT NULL DEFAULT 1,
+			created_at INTEGER,
+			updated_at INTEGER
+		)`,
+		`INSERT INTO client_groups (id, name, description, org_id, created_at, updated_at)
+			SELECT id, name, description, org_id, created_at, updated_at FROM client_groups_old`,
+		`DROP TABLE client_groups_old`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("重建client_groups表失败: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
 // GetMigrationStatus 获取迁移状态
 func (db *DB) GetMigrationStatus() (map[string]interface{}, error) {
 	status := make(map[string]interface{})
@@ -1,7 +1,11 @@
 package database
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"strings"
 	"time"
@@ -11,8 +15,9 @@ import (
 func (c *Client) MarshalJSON() ([]byte, error) {
 	type Alias Client
 	aux := &struct {
-		LastSeenTS *int64   `json:"last_seen_ts"`
-		LocalIPs   []string `json:"local_ips"`
+		LastSeenTS   *int64   `json:"last_seen_ts"`
+		LocalIPs     []string `json:"local_ips"`
+		Capabilities []string `json:"capabilities"`
 		*Alias
 	}{
 		Alias: (*Alias)(c),
@@ -35,6 +40,8 @@ func (c *Client) MarshalJSON() ([]byte, error) {
 	} else {
 		aux.LocalIPs = []string{}
 	}
+
+	aux.Capabilities = c.CapabilityList()
 	
 	return json.Marshal(aux)
 }
@@ -77,7 +84,46 @@ type Client struct {
 	CreatedAt         int64     `json:"created_at" db:"created_at"`
 	UpdatedAt         int64     `json:"updated_at" db:"updated_at"`
 	LocalIPs          string    `json:"local_ips" db:"local_ips"`  // JSON格式存储本地IP地址列表
+	Version           int64     `json:"version" db:"version"`      // 乐观锁版本号，每次修改递增
+	AgentVersion      string    `json:"agent_version" db:"agent_version"` // 代理注册时上报的版本
+	AgentOS           string    `json:"agent_os" db:"agent_os"`
+	AgentArch         string    `json:"agent_arch" db:"agent_arch"`
+	AgentCommit       string    `json:"agent_commit" db:"agent_commit"`         // 构建代理的代码提交
+	AgentBuildDate    string    `json:"agent_build_date" db:"agent_build_date"` // 代理的构建时间
+	Capabilities      string    `json:"capabilities" db:"capabilities"` // JSON格式存储代理能力列表
+	AgentOutdated     bool      `json:"agent_outdated" db:"-"`          // 代理版本低于配置的最低版本
+	VersionSkew       string    `json:"version_skew,omitempty" db:"-"`    // 代理与服务端的版本偏差：outdated / older / newer
+	VersionWarning    string    `json:"version_warning,omitempty" db:"-"` // 版本偏差的说明
+	Permission        string    `json:"permission,omitempty" db:"-"`    // 当前用户对该客户端的权限（view / edit）
+	OrgID             int       `json:"org_id" db:"org_id"`             // 所属组织
+	AllowedTargets    []string  `json:"allowed_targets,omitempty" db:"allowed_targets"` // 代理只能连接的目标（主机[:端口]），为空时不限制
 	LastSeen          time.Time `json:"last_seen" db:"-"`
+	Encrypted         bool      `json:"encrypted" db:"-"` // 当前连接是否加密消息载荷
+	Signed            bool      `json:"signed" db:"-"`    // 当前连接的消息是否签名
+	// 最近一次断开连接的原因和时间（毫秒），从未断开过时为空
+	LastDisconnectReason string `json:"last_disconnect_reason" db:"last_disconnect_reason"`
+	LastDisconnectedAt   *int64 `json:"last_disconnected_at" db:"last_disconnected_at"`
+}
+
+// CapabilityList 解析代理能力列表
+func (c *Client) CapabilityList() []string {
+	capabilities := []string{}
+	if c.Capabilities != "" {
+		if err := json.Unmarshal([]byte(c.Capabilities), &capabilities); err != nil {
+			return []string{}
+		}
+	}
+	return capabilities
+}
+
+// HasCapability 检查代理是否声明了指定能力
+func (c *Client) HasCapability(capability string) bool {
+	for _, item := range c.CapabilityList() {
+		if item == capability {
+			return true
+		}
+	}
+	return false
 }
 
 // IsEnabled 检查客户端是否启用
@@ -107,14 +153,313 @@ type ServerRoute struct {
 	Description    string `json:"description" db:"description"`      // 路由描述
 	CreatedAt      int64  `json:"created_at" db:"created_at"`
 	UpdatedAt      int64  `json:"updated_at" db:"updated_at"`
+	Version        int64  `json:"version" db:"version"`              // 乐观锁版本号，每次修改递增
+	GroupID        int    `json:"group_id" db:"group_id"`            // 目标客户端分组，非0时在分组在线成员间负载均衡
+	OrgID          int    `json:"org_id" db:"org_id"`                // 所属组织
+	// MatchHeaders 请求头匹配条件，路径匹配后还要求所有请求头满足条件，值为空表示只要求请求头存在
+	MatchHeaders map[string]string `json:"match_headers,omitempty" db:"match_headers"`
+	// MatchQuery 查询参数匹配条件，值为正则表达式（需完整匹配参数值），为空表示只要求参数存在
+	MatchQuery map[string]string `json:"match_query,omitempty" db:"match_query"`
+	// Weight 同优先级的多条路由同时匹配时按权重随机选择，默认为1
+	Weight int `json:"weight" db:"weight"`
+	// HedgeDelayMS 对冲延迟（毫秒），安全方法的请求超过该时间未响应时向备用客户端发送相同请求，0表示关闭
+	HedgeDelayMS int `json:"hedge_delay_ms" db:"hedge_delay_ms"`
+	// Compression 响应压缩方式，为空时按服务器配置使用gzip
+	Compression string `json:"compression" db:"compression"`
+	// 代理访问目标的超时（毫秒），0表示使用默认值
+	ConnectTimeoutMS  int `json:"connect_timeout_ms" db:"connect_timeout_ms"`     // 连接目标
+	HeaderTimeoutMS   int `json:"header_timeout_ms" db:"header_timeout_ms"`       // 请求发送完成后等待响应头
+	BodyIdleTimeoutMS int `json:"body_idle_timeout_ms" db:"body_idle_timeout_ms"` // 读取响应体时两次收到数据的最长间隔
+	TotalTimeoutMS    int `json:"total_timeout_ms" db:"total_timeout_ms"`         // 整个请求，同时是服务端等待响应的时间
+	// 流式响应（代理分片发送的响应体，如SSE）的超时（毫秒），响应体不受 total_timeout_ms 限制
+	StreamIdleTimeoutMS  int `json:"stream_idle_timeout_ms" db:"stream_idle_timeout_ms"`   // 服务端两次收到分片的最长间隔，每收到一个分片重新计时，0表示不限制
+	StreamTotalTimeoutMS int `json:"stream_total_timeout_ms" db:"stream_total_timeout_ms"` // 从开始接收到响应体结束的最长时间，0表示不限制
+	// Cacheable 允许代理按后端响应的Cache-Control在本地缓存GET/HEAD响应
+	Cacheable bool `json:"cacheable" db:"cacheable"`
+	// 服务端与代理之间的载荷压缩，覆盖服务器配置的 compression.algorithm 和 compression.min_bytes
+	PayloadCompression         string `json:"payload_compression" db:"payload_compression"`                     // none、gzip或deflate，为空时使用服务器配置
+	PayloadCompressionMinBytes int    `json:"payload_compression_min_bytes" db:"payload_compression_min_bytes"` // 0表示使用服务器配置
+	// ListenPort 独立监听端口，非0时服务器在该端口上把所有请求转发给这条路由，路由不再通过代理端口匹配
+	ListenPort int `json:"listen_port" db:"listen_port"`
+	// 请求抓取：开启后保存最近请求的完整请求体和响应体，用于排查后端的偶发问题
+	Capture           bool `json:"capture" db:"capture"`
+	CaptureMaxBytes   int  `json:"capture_max_bytes" db:"capture_max_bytes"`     // 请求体和响应体各自保存的最大字节数，超出部分截断，0表示使用服务器配置
+	CaptureTTLSeconds int  `json:"capture_ttl_seconds" db:"capture_ttl_seconds"` // 抓取记录保留的秒数，0表示使用服务器配置
+	// 故障注入：测试下游在延迟、错误和连接中断时的表现，全部为0时不注入
+	FaultDelayMS      int `json:"fault_delay_ms" db:"fault_delay_ms"`           // 转发前增加的延迟（毫秒）
+	FaultErrorPercent int `json:"fault_error_percent" db:"fault_error_percent"` // 不转发、直接返回错误的请求百分比
+	FaultErrorStatus  int `json:"fault_error_status" db:"fault_error_status"`   // 注入错误的状态码，0表示503
+	FaultDropPercent  int `json:"fault_drop_percent" db:"fault_drop_percent"`   // 收到响应后丢弃响应并断开连接的请求百分比
+	// Source 路由的来源，为空表示通过API创建，agent 表示由代理配置声明，路径、目标、独立端口和描述以代理配置为准
+	Source string `json:"source" db:"source"`
+	// 代理声明路由的审批，开启 agent.require_route_approval 后新声明或修改了目标的路由需管理员批准后才转发
+	ApprovalStatus string `json:"approval_status" db:"approval_status"` // 为空表示不需要审批，pending、approved 或 rejected
+	ApprovalNote   string `json:"approval_note" db:"approval_note"`     // 拒绝的原因
+	ReviewedBy     string `json:"reviewed_by" db:"reviewed_by"`         // 审批的用户
+	ReviewedAt     int64  `json:"reviewed_at" db:"reviewed_at"`         // 审批时间（毫秒）
+	// 临时路由，到期后由服务端禁用或删除，适合演示链接和短期调试隧道
+	ExpiresAt    int64  `json:"expires_at" db:"expires_at"`       // 到期时间（毫秒），0表示不过期
+	ExpireAction string `json:"expire_action" db:"expire_action"` // 到期后的处理：disable（默认）或 delete
+	// Private 私有路由只转发携带有效分享链接令牌的请求，分享链接通过 POST /routes/{id}/share 生成
+	Private bool `json:"private" db:"private"`
+	// ShareVersion 分享链接的版本，参与令牌签名，撤销分享时加一使之前的链接失效
+	ShareVersion int `json:"-" db:"share_version"`
+	// 代理转发前要求的HTTP基本认证，用户名为空表示不需要认证；密码只在创建和修改时提交，保存加盐的哈希
+	BasicAuthUser     string `json:"basic_auth_user" db:"basic_auth_user"`
+	BasicAuthHash     string `json:"-" db:"basic_auth_hash"`
+	BasicAuthPassword string `json:"basic_auth_password,omitempty" db:"-"`
+	// 路由绑定的自定义域名，验证域名所有权后代理按请求主机（含TLS的SNI）把该域名的所有路径转发给这条路由
+	Domain             string `json:"domain" db:"domain"`
+	DomainVerifyMethod string `json:"domain_verify_method" db:"domain_verify_method"` // 通过验证的方式：http 或 dns
+	DomainVerifyToken  string `json:"domain_verify_token" db:"domain_verify_token"`   // 验证令牌，设置域名时生成
+	DomainVerifiedAt   int64  `json:"domain_verified_at" db:"domain_verified_at"`     // 验证通过的时间（毫秒），0表示未验证
+	DomainVerifyError  string `json:"domain_verify_error" db:"domain_verify_error"`   // 最近一次验证失败的原因
+	// 按请求来源国家（ISO 3166-1两位代码）限制访问，需要配置 geoip.database；先检查拒绝列表，
+	// 允许列表不为空时只转发来自列表中国家的请求，无法确定国家的请求也被拒绝
+	AllowedCountries []string `json:"allowed_countries" db:"allowed_countries"`
+	DeniedCountries  []string `json:"denied_countries" db:"denied_countries"`
+}
+
+// ConditionCount 路由在路径之外的匹配条件数量，条件越多越具体
+func (sr *ServerRoute) ConditionCount() int {
+	return len(sr.MatchHeaders) + len(sr.MatchQuery)
+}
+
+// HasFaults 检查路由是否开启了故障注入
+func (sr *ServerRoute) HasFaults() bool {
+	return sr.FaultDelayMS > 0 || sr.FaultErrorPercent > 0 || sr.FaultDropPercent > 0
+}
+
+// DefaultRouteWeight 路由默认权重
+const DefaultRouteWeight = 1
+
+// IsGroupRoute 检查路由是否指向客户端分组
+func (sr *ServerRoute) IsGroupRoute() bool {
+	return sr.GroupID > 0
+}
+
+// ClientGroup 客户端分组，路由可以指向分组以在多个客户端之间实现高可用
+type ClientGroup struct {
+	ID          int      `json:"id" db:"id"`
+	Name        string   `json:"name" db:"name"`
+	Description string   `json:"description" db:"description"`
+	ClientIDs   []string `json:"client_ids" db:"-"`
+	OrgID       int      `json:"org_id" db:"org_id"` // 所属组织，分组名称在组织内唯一
+	CreatedAt   int64    `json:"created_at" db:"created_at"`
+	UpdatedAt   int64    `json:"updated_at" db:"updated_at"`
+}
+
+// DefaultOrgID 默认组织ID，升级前的数据都归属该组织，其管理员负责管理所有组织
+const DefaultOrgID = 1
+
+// Organization 组织（租户），客户端、路由、分组和用户都归属于某个组织
+type Organization struct {
+	ID         int    `json:"id" db:"id"`
+	Slug       string `json:"slug" db:"slug"` // 组织标识，创建后不可修改
+	Name       string `json:"name" db:"name"`
+	SigningKey string `json:"-" db:"signing_key"` // 组织独立的JWT签名密钥，为空时使用配置中的全局密钥
+	CreatedAt  int64  `json:"created_at" db:"created_at"`
+	UpdatedAt  int64  `json:"updated_at" db:"updated_at"`
+}
+
+// APIKey 组织的API密钥，明文只在创建和轮换时返回一次，数据库中只保存哈希
+type APIKey struct {
+	ID         int    `json:"id" db:"id"`
+	OrgID      int    `json:"org_id" db:"org_id"`
+	Name       string `json:"name" db:"name"`
+	Prefix     string `json:"prefix" db:"key_prefix"` // 明文前缀，便于识别密钥
+	KeyHash    string `json:"-" db:"key_hash"`
+	Role       string `json:"role" db:"role"`
+	CreatedBy  string `json:"created_by" db:"created_by"`
+	CreatedAt  int64  `json:"created_at" db:"created_at"`
+	LastUsedAt int64  `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  int64  `json:"expires_at" db:"expires_at"` // 0表示永不过期
 }
 
+// ClientReservation 为客户端预留的端口范围或域名，代理动态申请暴露的端口和域名只能在本客户端的预留范围内
+// 端口预留的 Domain 为空；域名预留的端口范围为0，域名可以是 *.example.com 形式的通配符
+type ClientReservation struct {
+	ID        int    `json:"id" db:"id"`
+	ClientID  string `json:"client_id" db:"client_id"`
+	PortStart int    `json:"port_start,omitempty" db:"port_start"`
+	PortEnd   int    `json:"port_end,omitempty" db:"port_end"` // 包含端口本身
+	Domain    string `json:"domain,omitempty" db:"domain"`
+	CreatedBy string `json:"created_by" db:"created_by"`
+	CreatedAt int64  `json:"created_at" db:"created_at"`
+}
+
+// IsDomain 是否为域名预留
+func (c *ClientReservation) IsDomain() bool {
+	return c.Domain != ""
+}
+
+// User 控制台用户
+type User struct {
+	ID           string `json:"id" db:"id"`
+	Username     string `json:"username" db:"username"`
+	PasswordHash string `json:"-" db:"password_hash"` // 不在JSON中显示
+	Role         string `json:"role" db:"role"`       // admin / user
+	OrgID        int    `json:"org_id" db:"org_id"`
+	CreatedAt    int64  `json:"created_at" db:"created_at"`
+	LastLogin    int64  `json:"last_login" db:"last_login"`
+}
+
+// ResourceACL 资源级访问控制条目
+// 用户或角色一旦存在任何条目就成为受限主体，只能访问条目中列出的路由和客户端
+type ResourceACL struct {
+	ID           int    `json:"id" db:"id"`
+	OrgID        int    `json:"org_id" db:"org_id"`
+	ResourceType string `json:"resource_type" db:"resource_type"` // route / client
+	ResourceID   string `json:"resource_id" db:"resource_id"`     // 路由ID或客户端ID
+	SubjectType  string `json:"subject_type" db:"subject_type"`   // user / role
+	Subject      string `json:"subject" db:"subject"`             // 用户ID（API密钥为 apikey:<id>）或角色名
+	Permission   string `json:"permission" db:"permission"`       // view / edit
+	CreatedBy    string `json:"created_by" db:"created_by"`
+	CreatedAt    int64  `json:"created_at" db:"created_at"`
+}
+
+// 访问控制的资源类型、主体类型和权限
+const (
+	ACLResourceRoute  = "route"
+	ACLResourceClient = "client"
+
+	ACLSubjectUser = "user"
+	ACLSubjectRole = "role"
+
+	ACLPermissionView = "view"
+	ACLPermissionEdit = "edit"
+)
+
+// ClientStats 代理最近一次上报的运行状态快照
+type ClientStats struct {
+	ClientID   string          `json:"client_id" db:"client_id"`
+	Stats      json.RawMessage `json:"stats" db:"stats_json"`
+	ReportedAt int64           `json:"reported_at" db:"reported_at"` // 服务端接收时间（毫秒）
+}
+
+// TrafficRollup 按小时汇总的流量记录，分组查询时未参与分组的字段为零值
+type TrafficRollup struct {
+	HourTS    int64  `json:"hour_ts,omitempty" db:"hour_ts"` // 小时起始时间（毫秒）
+	OrgID     int    `json:"-" db:"org_id"`
+	ClientID  string `json:"client_id,omitempty" db:"client_id"`
+	RouteID   int    `json:"route_id,omitempty" db:"route_id"`
+	URLSuffix string `json:"url_suffix,omitempty" db:"url_suffix"`
+	Requests  int64  `json:"requests" db:"requests"`
+	Errors    int64  `json:"errors" db:"errors"`
+	BytesIn   int64  `json:"bytes_in" db:"bytes_in"`
+	BytesOut  int64  `json:"bytes_out" db:"bytes_out"`
+}
+
+// TrafficFilter 流量查询条件，From/To 为毫秒时间戳，左闭右开
+type TrafficFilter struct {
+	From     int64
+	To       int64
+	OrgID    int // 0 表示不限组织
+	ClientID string
+	RouteID  int
+}
+
+// ClientQuota 客户端用量配额，限额为0表示不限制
+type ClientQuota struct {
+	ClientID       string `json:"client_id" db:"client_id"`
+	RequestsPerDay int64  `json:"requests_per_day" db:"requests_per_day"` // 每日请求数上限（UTC自然日）
+	BytesPerMonth  int64  `json:"bytes_per_month" db:"bytes_per_month"`   // 每月流量上限，请求和响应字节合计（UTC自然月）
+	WebhookURL     string `json:"webhook_url" db:"webhook_url"`           // 用量告警地址，为空时使用全局配置
+	CreatedAt      int64  `json:"created_at" db:"created_at"`
+	UpdatedAt      int64  `json:"updated_at" db:"updated_at"`
+}
+
+// IsUnlimited 检查配额是否没有任何限制
+func (q *ClientQuota) IsUnlimited() bool {
+	return q.RequestsPerDay <= 0 && q.BytesPerMonth <= 0
+}
+
+// 流量查询的分组方式
+const (
+	TrafficGroupByClient = "client"
+	TrafficGroupByRoute  = "route"
+	TrafficGroupByHour   = "hour"
+	TrafficGroupByNone   = "none"
+)
+
 // 路由配置模式常量
 const (
 	RouteModeOriginalPath  = "original_path"  // 原路径模式：目标地址为http://ip:port，请求路径保持不变
 	RouteModePathTransform = "path_transform" // 路径转换模式：目标地址为完整URL，直接转发到指定地址
 )
 
+// RouteSourceAgent 由代理配置声明的路由，代理每次注册时按其配置创建、更新或删除
+const RouteSourceAgent = "agent"
+
+// 代理声明路由的审批状态
+const (
+	RouteApprovalPending  = "pending"
+	RouteApprovalApproved = "approved"
+	RouteApprovalRejected = "rejected"
+)
+
+// 临时路由到期后的处理方式
+const (
+	RouteExpireDisable = "disable"
+	RouteExpireDelete  = "delete"
+)
+
+// IsValidRouteExpireAction 检查到期处理方式是否有效，为空表示禁用
+func IsValidRouteExpireAction(action string) bool {
+	return action == "" || action == RouteExpireDisable || action == RouteExpireDelete
+}
+
+// HasCountryRules 路由设置了按国家限制访问
+func (sr *ServerRoute) HasCountryRules() bool {
+	return len(sr.AllowedCountries) > 0 || len(sr.DeniedCountries) > 0
+}
+
+// AllowsCountry 检查是否转发来自国家的请求，country 为空表示无法确定国家
+func (sr *ServerRoute) AllowsCountry(country string) bool {
+	for _, denied := range sr.DeniedCountries {
+		if denied == country {
+			return false
+		}
+	}
+	if len(sr.AllowedCountries) == 0 {
+		return true
+	}
+	for _, allowed := range sr.AllowedCountries {
+		if allowed == country {
+			return true
+		}
+	}
+	return false
+}
+
+// 自定义域名所有权的验证方式
+const (
+	DomainVerifyHTTP = "http" // 通过域名访问代理端口上的验证路径
+	DomainVerifyDNS  = "dns"  // 在 _tunnel-flow.<域名> 添加包含令牌的TXT记录
+)
+
+// IsDomainVerified 路由绑定了自定义域名且已通过所有权验证
+func (sr *ServerRoute) IsDomainVerified() bool {
+	return sr.Domain != "" && sr.DomainVerifiedAt > 0
+}
+
+// 路由响应压缩方式常量
+const (
+	RouteCompressionDefault = ""     // 按服务器配置：开启压缩时使用gzip
+	RouteCompressionNone    = "none" // 不压缩
+	RouteCompressionGzip    = "gzip" // 只使用gzip
+	RouteCompressionBrotli  = "br"   // 优先使用br，客户端不支持时使用gzip
+)
+
+// IsValidRouteCompression 检查路由的响应压缩方式是否有效
+func IsValidRouteCompression(compression string) bool {
+	switch compression {
+	case RouteCompressionDefault, RouteCompressionNone, RouteCompressionGzip, RouteCompressionBrotli:
+		return true
+	}
+	return false
+}
+
 // RouteTarget 路由目标
 type RouteTarget struct {
 	URL    string `json:"url"`
@@ -164,6 +509,43 @@ func (sr *ServerRoute) IsEnabled() bool {
 	return sr.Enabled == 1
 }
 
+// IsExpired 检查临时路由在 now 时是否已到期，到期的路由不再转发请求
+func (sr *ServerRoute) IsExpired(now time.Time) bool {
+	return sr.ExpiresAt > 0 && sr.ExpiresAt <= now.UnixMilli()
+}
+
+// IsApproved 检查路由不需要审批或已被批准，等待审批和被拒绝的路由不转发请求
+func (sr *ServerRoute) IsApproved() bool {
+	return sr.ApprovalStatus == "" || sr.ApprovalStatus == RouteApprovalApproved
+}
+
+// SetBasicAuthPassword 保存基本认证密码的哈希，每次使用新的随机盐
+func (sr *ServerRoute) SetBasicAuthPassword(password string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	sr.BasicAuthHash = basicAuthHash(hex.EncodeToString(salt), password)
+	return nil
+}
+
+// CheckBasicAuth 检查请求的用户名和密码，路由没有设置基本认证时返回false
+func (sr *ServerRoute) CheckBasicAuth(username, password string) bool {
+	salt, _, ok := strings.Cut(sr.BasicAuthHash, "$")
+	if sr.BasicAuthUser == "" || !ok {
+		return false
+	}
+	userMatch := subtle.ConstantTimeCompare([]byte(username), []byte(sr.BasicAuthUser)) == 1
+	passwordMatch := subtle.ConstantTimeCompare([]byte(basicAuthHash(salt, password)), []byte(sr.BasicAuthHash)) == 1
+	return userMatch && passwordMatch
+}
+
+// basicAuthHash 以 salt$sha256(salt+password) 的格式保存密码
+func basicAuthHash(salt, password string) string {
+	sum := sha256.Sum256([]byte(salt + password))
+	return salt + "$" + hex.EncodeToString(sum[:])
+}
+
 // SetEnabled 设置路由启用状态
 func (sr *ServerRoute) SetEnabled(enabled bool) {
 	if enabled {
@@ -196,6 +578,38 @@ type PendingMessage struct {
 	CreatedAt        int64          `json:"created_at" db:"created_at"`
 	LastUpdate       int64          `json:"last_update" db:"last_update"`
 	ResponseMetaJSON sql.NullString `json:"response_meta_json" db:"response_meta_json"`
+	IdempotencyKey   string         `json:"idempotency_key,omitempty" db:"idempotency_key"` // 请求的幂等键（带组织前缀），同一时间只属于一条消息
+	// 请求失败或取消的原因，代理返回的错误响应记录在响应元数据中
+	LastError string `json:"last_error,omitempty" db:"last_error"`
+}
+
+// PendingMessageFilter 代理请求查询条件，From/To 为创建时间的毫秒时间戳，左闭右开，为0表示不限
+type PendingMessageFilter struct {
+	OrgID     int // 0 表示不限组织
+	State     string
+	ClientID  string
+	URLSuffix string
+	From      int64
+	To        int64
+	Limit     int
+	Offset    int
+}
+
+// MessageUpdate 待处理消息的一次状态变化
+type MessageUpdate struct {
+	MsgID            string `json:"msg_id"`
+	State            string `json:"state"`
+	LastError        string `json:"last_error,omitempty"`         // 不为空时同时记录失败原因
+	ResponseMetaJSON string `json:"response_meta_json,omitempty"` // 不为空时同时保存响应
+	NextTryTS        int64  `json:"next_try_ts,omitempty"`        // 不为0时同时更新下次检查的时间
+	FromPending      bool   `json:"from_pending,omitempty"`       // 只更新仍为 pending 的消息，不覆盖已经先到的结果
+}
+
+// WriteBatch 合并后在同一个事务中执行的一批写入
+type WriteBatch struct {
+	LastSeen       map[string]int64 // 客户端最后活跃时间（毫秒），每个客户端只保留最新的
+	AuditLogs      []*AuditLog
+	MessageUpdates []*MessageUpdate // 按发生顺序执行
 }
 
 // MessageState 消息状态
@@ -216,8 +630,17 @@ type RequestMeta struct {
 	TimeoutMS      int               `json:"timeout_ms"`
 	TargetsJSON    string            `json:"targets_json"`
 	DeliveryPolicy string            `json:"delivery_policy"`
+	// 重新投递时还原请求所需的设置
+	RouteMode    string `json:"route_mode,omitempty"`
+	BodyStreamed bool   `json:"body_streamed,omitempty"` // 请求体分片发送，没有保存在 Body 中，不能重新投递
 }
 
+// DeliveryPolicy 路由的投递策略，决定等待响应的请求中断（如服务端重启）后是否重新投递
+const (
+	DeliveryPolicyFirstSuccess = "first_success" // 默认，不重新投递，超过期限后标记为失败
+	DeliveryPolicyAtLeastOnce  = "at_least_once" // 超过期限仍没有响应时重新投递，代理可能收到多次
+)
+
 // ResponseMeta 响应元数据
 type ResponseMeta struct {
 	HTTPStatus int               `json:"http_status"`
@@ -225,6 +648,9 @@ type ResponseMeta struct {
 	Body       interface{}       `json:"body"`
 	LatencyMS  int64             `json:"latency_ms"`
 	Error      *string           `json:"error"`
+	Trailers   map[string]string `json:"trailers,omitempty"`
+
+	HeaderValues map[string][]string `json:"header_values,omitempty"` // 多值响应头，为空时使用 Headers
 }
 
 // GetRequestMeta 解析请求元数据
@@ -277,6 +703,63 @@ type AuditLog struct {
 	TS             int64  `json:"ts" db:"ts"`
 }
 
+// ConnectionHistory 客户端的一次WebSocket连接，连接中时 DisconnectedAt 为空
+type ConnectionHistory struct {
+	ID             int64  `json:"id" db:"id"`
+	ClientID       string `json:"client_id" db:"client_id"`
+	RemoteIP       string `json:"remote_ip" db:"remote_ip"`
+	ConnectedAt    int64  `json:"connected_at" db:"connected_at"`       // 毫秒
+	DisconnectedAt *int64 `json:"disconnected_at" db:"disconnected_at"` // 毫秒
+	DurationMS     *int64 `json:"duration_ms" db:"duration_ms"`
+	Reason         string `json:"reason" db:"reason"` // 断开原因，连接中时为空
+}
+
+// DeadLetter 重新投递次数用完仍没有成功的代理请求，修复后端后可以重新执行
+type DeadLetter struct {
+	ID              int64  `json:"id" db:"id"`
+	MsgID           string `json:"msg_id" db:"msg_id"` // 原请求的消息ID
+	ClientID        string `json:"client_id" db:"client_id"`
+	URLSuffix       string `json:"url_suffix" db:"url_suffix"`
+	RequestMetaJSON string `json:"-" db:"request_meta_json"`
+	RetryCount      int    `json:"retry_count" db:"retry_count"`
+	LastError       string `json:"last_error" db:"last_error"`
+	CreatedAt       int64  `json:"created_at" db:"created_at"` // 原请求的创建时间，毫秒
+	DeadAt          int64  `json:"dead_at" db:"dead_at"`       // 进入死信队列的时间，毫秒
+}
+
+// GetRequestMeta 解析请求元数据
+func (d *DeadLetter) GetRequestMeta() (*RequestMeta, error) {
+	var meta RequestMeta
+	err := json.Unmarshal([]byte(d.RequestMetaJSON), &meta)
+	return &meta, err
+}
+
+// RequestCapture 开启抓取的路由上一次代理请求的完整记录，过期后自动删除
+type RequestCapture struct {
+	ID                int64             `json:"id" db:"id"`
+	RequestID         string            `json:"request_id" db:"request_id"` // 代理请求的 X-Request-ID
+	OrgID             int               `json:"org_id" db:"org_id"`
+	RouteID           int               `json:"route_id" db:"route_id"`
+	ClientID          string            `json:"client_id" db:"client_id"`
+	Method            string            `json:"method" db:"method"`
+	URLSuffix         string            `json:"url_suffix" db:"url_suffix"` // 去掉代理前缀和组织前缀后的路径
+	Query             string            `json:"query" db:"query"`
+	RequestHeaders    map[string]string `json:"request_headers" db:"request_headers"`
+	RequestBody       []byte            `json:"-" db:"request_body"`
+	RequestSize       int64             `json:"request_size" db:"request_size"`             // 原请求体的字节数
+	RequestTruncated  bool              `json:"request_truncated" db:"request_truncated"`   // 请求体超出上限或流式上传，没有完整保存
+	Status            int               `json:"status" db:"status"`                         // 返回给调用方的状态码
+	ResponseHeaders   map[string]string `json:"response_headers" db:"response_headers"`
+	ResponseBody      []byte            `json:"-" db:"response_body"`
+	ResponseSize      int64             `json:"response_size" db:"response_size"`
+	ResponseTruncated bool              `json:"response_truncated" db:"response_truncated"` // 响应体超出上限或分片传输，没有完整保存
+	LatencyMS         int64             `json:"latency_ms" db:"latency_ms"`
+	Error             string            `json:"error,omitempty" db:"error"`
+	Country           string            `json:"country,omitempty" db:"country"` // 请求来源国家，没有配置GeoIP数据库或无法确定时为空
+	CreatedAt         int64             `json:"created_at" db:"created_at"`     // 毫秒
+	ExpiresAt         int64             `json:"expires_at" db:"expires_at"` // 毫秒
+}
+
 // Direction 方向
 const (
 	DirectionInbound  = "inbound"
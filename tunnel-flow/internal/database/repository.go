@@ -2,9 +2,36 @@ package database
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// ErrVersionConflict 乐观锁版本冲突，记录已被其他请求修改
+var ErrVersionConflict = errors.New("version conflict")
+
+// clientColumns clients表查询字段
+const clientColumns = `client_id, name, description, auth_token, status, enabled, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at, updated_at, local_ips, version, agent_version, agent_os, agent_arch, capabilities, org_id, allowed_targets, agent_commit, agent_build_date, last_disconnect_reason, last_disconnected_at`
+
+// serverRouteColumns server_routes表查询字段
+const serverRouteColumns = `id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at, version, group_id, org_id, match_headers, match_query, weight, hedge_delay_ms, compression, connect_timeout_ms, header_timeout_ms, body_idle_timeout_ms, total_timeout_ms, stream_idle_timeout_ms, stream_total_timeout_ms, cacheable, payload_compression, payload_compression_min_bytes, listen_port, capture, capture_max_bytes, capture_ttl_seconds, fault_delay_ms, fault_error_percent, fault_error_status, fault_drop_percent, source, approval_status, approval_note, reviewed_by, reviewed_at, expires_at, expire_action, private, share_version, basic_auth_user, basic_auth_hash, domain, domain_verify_method, domain_verify_token, domain_verified_at, domain_verify_error, allowed_countries, denied_countries`
+
+// pendingMessageColumns pending_messages表查询字段
+const pendingMessageColumns = `msg_id, client_id, url_suffix, request_meta_json, state, retry_count, next_try_ts, created_at, last_update, response_meta_json, idempotency_key, last_error`
+
+// IsUniqueConstraintError 判断是否为唯一约束冲突
+func IsUniqueConstraintError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
+
+// rowScanner 兼容*sql.Row和*sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
 // Repository 数据库操作接口
 type Repository struct {
 	db *DB
@@ -20,16 +47,22 @@ func (r *Repository) Ping() error {
 	return r.db.Ping()
 }
 
+// DatabaseMetrics 返回数据库查询耗时、错误和降级状态
+func (r *Repository) DatabaseMetrics() Metrics {
+	return r.db.Metrics()
+}
+
 // Client operations
 
 // CreateClient 创建客户端
 func (r *Repository) CreateClient(client *Client) error {
-	query := `INSERT INTO clients (client_id, name, description, auth_token, status, enabled, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at, updated_at) 
-			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO clients (client_id, name, description, auth_token, status, enabled, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at, updated_at, org_id) 
+			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
 	now := time.Now().Unix()
 	client.CreatedAt = now
 	client.UpdatedAt = now
+	client.Version = 1
 	// 不手动设置LastSeenTS，让心跳机制自动更新，设置为null
 	client.LastSeenTS = sql.NullInt64{}
 	
@@ -43,43 +76,26 @@ func (r *Repository) CreateClient(client *Client) error {
 	if client.Enabled == 0 {
 		client.Enabled = 1 // 默认启用
 	}
+	if client.OrgID == 0 {
+		client.OrgID = DefaultOrgID
+	}
 	
-	_, err := r.db.Exec(query, client.ClientID, client.Name, client.Description, client.AuthToken, 
+	authToken, err := r.db.sealSecret(client.AuthToken)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(query, client.ClientID, client.Name, client.Description, authToken, 
 		client.Status, client.Enabled, client.LastSeenTS, client.HeartbeatInterval, client.HeartbeatTimeout, 
-		client.CreatedAt, client.UpdatedAt)
+		client.CreatedAt, client.UpdatedAt, client.OrgID)
 	return err
 }
 
 // GetClient 获取客户端
 func (r *Repository) GetClient(clientID string) (*Client, error) {
-	query := `SELECT client_id, name, description, auth_token, status, enabled, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at, updated_at, local_ips 
+	query := `SELECT ` + clientColumns + `
 			   FROM clients WHERE client_id = ?`
 	
-	client := &Client{}
-	var description sql.NullString
-	var localIPs sql.NullString
-	err := r.db.QueryRow(query, clientID).Scan(
-		&client.ClientID, &client.Name, &description, &client.AuthToken,
-		&client.Status, &client.Enabled, &client.LastSeenTS, &client.HeartbeatInterval, &client.HeartbeatTimeout, 
-		&client.CreatedAt, &client.UpdatedAt, &localIPs)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	if description.Valid {
-		client.Description = description.String
-	}
-	if localIPs.Valid {
-		client.LocalIPs = localIPs.String
-	}
-	// 处理LastSeenTS的null值
-	if client.LastSeenTS.Valid {
-		client.LastSeen = time.UnixMilli(client.LastSeenTS.Int64)
-	}
-	// 设置是否有认证令牌
-	client.HasAuthToken = client.AuthToken != ""
-	return client, nil
+	return r.scanClient(r.db.QueryRow(query, clientID))
 }
 
 // UpdateClientStatus 更新客户端状态
@@ -103,6 +119,43 @@ func (r *Repository) UpdateClientLocalIPs(clientID string, localIPs string) erro
 	return err
 }
 
+// UpdateClientAgentInfo 更新代理注册时上报的版本、构建信息、平台和能力列表
+// capabilities 为JSON格式的字符串数组，该操作不修改乐观锁版本号
+func (r *Repository) UpdateClientAgentInfo(clientID, agentVersion, agentCommit, agentBuildDate, agentOS, agentArch, capabilities string) error {
+	query := `UPDATE clients SET agent_version = ?, agent_commit = ?, agent_build_date = ?, agent_os = ?, agent_arch = ?, capabilities = ? WHERE client_id = ?`
+	_, err := r.db.Exec(query, agentVersion, agentCommit, agentBuildDate, agentOS, agentArch, capabilities, clientID)
+	return err
+}
+
+// UpdateClientAllowedTargets 更新客户端的目标白名单，为空表示不限制，该操作不修改乐观锁版本号
+func (r *Repository) UpdateClientAllowedTargets(clientID string, allowedTargets []string) error {
+	value := ""
+	if len(allowedTargets) > 0 {
+		data, err := json.Marshal(allowedTargets)
+		if err != nil {
+			return err
+		}
+		value = string(data)
+	}
+	query := `UPDATE clients SET allowed_targets = ?, updated_at = ? WHERE client_id = ?`
+	_, err := r.db.Exec(query, value, time.Now().Unix(), clientID)
+	return err
+}
+
+// UpdateClientHeartbeat 更新客户端的心跳间隔和超时（秒）
+func (r *Repository) UpdateClientHeartbeat(clientID string, interval, timeout int) error {
+	query := `UPDATE clients SET heartbeat_interval = ?, heartbeat_timeout = ?, updated_at = ?, version = version + 1 WHERE client_id = ?`
+	_, err := r.db.Exec(query, interval, timeout, time.Now().Unix(), clientID)
+	return err
+}
+
+// UpdateClientDisconnect 记录客户端最近一次断开连接的原因和时间（毫秒）
+func (r *Repository) UpdateClientDisconnect(clientID, reason string, disconnectedAt int64) error {
+	query := `UPDATE clients SET last_disconnect_reason = ?, last_disconnected_at = ? WHERE client_id = ?`
+	_, err := r.db.Exec(query, reason, disconnectedAt, clientID)
+	return err
+}
+
 // UpdateClientLastActiveTime 更新客户端最后活跃时间
 func (r *Repository) UpdateClientLastActiveTime(clientID string, lastActiveTime time.Time) error {
 	query := `UPDATE clients SET last_seen_ts = ? WHERE client_id = ?`
@@ -110,18 +163,21 @@ func (r *Repository) UpdateClientLastActiveTime(clientID string, lastActiveTime
 	return err
 }
 
-// CleanupStaleClients 清理过期的客户端状态
-func (r *Repository) CleanupStaleClients() error {
+// CleanupStaleClients 清理过期的客户端状态，返回设置为离线的客户端数量
+func (r *Repository) CleanupStaleClients() (int64, error) {
 	// 将所有在线状态的客户端设置为离线，因为服务器重启后所有连接都已断开
 	query := `UPDATE clients SET status = 'offline', last_seen_ts = ? WHERE status = 'online'`
-	_, err := r.db.Exec(query, time.Now().UnixMilli())
-	return err
+	result, err := r.db.Exec(query, time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
 // GetStaleClients 获取超时的客户端
 func (r *Repository) GetStaleClients(timeoutSeconds int) ([]*Client, error) {
 	cutoffTime := time.Now().Add(-time.Duration(timeoutSeconds) * time.Second).UnixMilli()
-	query := `SELECT client_id, name, description, auth_token, status, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at 
+	query := `SELECT ` + clientColumns + `
 			   FROM clients WHERE status = 'online' AND last_seen_ts < ?`
 	
 	rows, err := r.db.Query(query, cutoffTime)
@@ -130,28 +186,7 @@ func (r *Repository) GetStaleClients(timeoutSeconds int) ([]*Client, error) {
 	}
 	defer rows.Close()
 	
-	var clients []*Client
-	for rows.Next() {
-		client := &Client{}
-		var description sql.NullString
-		err := rows.Scan(&client.ClientID, &client.Name, &description, &client.AuthToken,
-			&client.Status, &client.LastSeenTS, &client.HeartbeatInterval, &client.HeartbeatTimeout, &client.CreatedAt)
-		if err != nil {
-			return nil, err
-		}
-		if description.Valid {
-			client.Description = description.String
-		}
-		// 处理LastSeenTS的null值
-		if client.LastSeenTS.Valid {
-			client.LastSeen = time.UnixMilli(client.LastSeenTS.Int64)
-		}
-		// 设置是否有认证令牌
-		client.HasAuthToken = client.AuthToken != ""
-		clients = append(clients, client)
-	}
-	
-	return clients, rows.Err()
+	return r.scanClients(rows)
 }
 
 // ServerRoute additional operations
@@ -163,14 +198,14 @@ func (r *Repository) UpdateServerRouteEnabled(id int, enabled bool) error {
 		enabledValue = 1
 	}
 	
-	query := `UPDATE server_routes SET enabled = ?, updated_at = ? WHERE id = ?`
+	query := `UPDATE server_routes SET enabled = ?, updated_at = ?, version = version + 1 WHERE id = ?`
 	_, err := r.db.Exec(query, enabledValue, time.Now().UnixMilli(), id)
 	return err
 }
 
 // GetEnabledServerRoutes 获取所有启用的路由
 func (r *Repository) GetEnabledServerRoutes() ([]*ServerRoute, error) {
-	query := `SELECT id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at 
+	query := `SELECT ` + serverRouteColumns + `
 			   FROM server_routes WHERE enabled = 1 ORDER BY created_at DESC`
 	
 	rows, err := r.db.Query(query)
@@ -179,38 +214,13 @@ func (r *Repository) GetEnabledServerRoutes() ([]*ServerRoute, error) {
 	}
 	defer rows.Close()
 	
-	var routes []*ServerRoute
-	for rows.Next() {
-		route := &ServerRoute{}
-		var description sql.NullString
-		var updatedAt sql.NullInt64
-		
-		err := rows.Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
-			&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt)
-		if err != nil {
-			return nil, err
-		}
-		
-		// 处理可空字段
-		if description.Valid {
-			route.Description = description.String
-		}
-		if updatedAt.Valid {
-			route.UpdatedAt = updatedAt.Int64
-		} else {
-			route.UpdatedAt = route.CreatedAt // 兼容旧数据
-		}
-		
-		routes = append(routes, route)
-	}
-	
-	return routes, rows.Err()
+	return scanServerRoutes(rows)
 }
 
 // GetServerRoutesByPattern 根据URL模式匹配获取路由（支持通配符）
 func (r *Repository) GetServerRoutesByPattern(urlPath string) ([]*ServerRoute, error) {
 	// 获取所有启用的路由，然后在应用层进行模式匹配
-	query := `SELECT id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at 
+	query := `SELECT ` + serverRouteColumns + `
 			   FROM server_routes WHERE enabled = 1 ORDER BY created_at DESC`
 	
 	rows, err := r.db.Query(query)
@@ -219,36 +229,11 @@ func (r *Repository) GetServerRoutesByPattern(urlPath string) ([]*ServerRoute, e
 	}
 	defer rows.Close()
 	
-	var routes []*ServerRoute
-	for rows.Next() {
-		route := &ServerRoute{}
-		var description sql.NullString
-		var updatedAt sql.NullInt64
-		
-		err := rows.Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
-			&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt)
-		if err != nil {
-			return nil, err
-		}
-		
-		// 处理可空字段
-		if description.Valid {
-			route.Description = description.String
-		}
-		if updatedAt.Valid {
-			route.UpdatedAt = updatedAt.Int64
-		} else {
-			route.UpdatedAt = route.CreatedAt // 兼容旧数据
-		}
-		
-		routes = append(routes, route)
-	}
-	
-	return routes, rows.Err()
+	return scanServerRoutes(rows)
 }
 
-// BatchUpdateServerRoutesEnabled 批量更新路由启用状态
-func (r *Repository) BatchUpdateServerRoutesEnabled(ids []int, enabled bool) error {
+// BatchUpdateServerRoutesEnabled 批量更新组织内路由的启用状态，其他组织的路由ID会被忽略
+func (r *Repository) BatchUpdateServerRoutesEnabled(orgID int, ids []int, enabled bool) error {
 	if len(ids) == 0 {
 		return nil
 	}
@@ -259,7 +244,7 @@ func (r *Repository) BatchUpdateServerRoutesEnabled(ids []int, enabled bool) err
 	}
 	
 	// 构建批量更新SQL
-	query := `UPDATE server_routes SET enabled = ?, updated_at = ? WHERE id IN (`
+	query := `UPDATE server_routes SET enabled = ?, updated_at = ?, version = version + 1 WHERE org_id = ? AND id IN (`
 	for i := range ids {
 		if i > 0 {
 			query += ","
@@ -269,7 +254,7 @@ func (r *Repository) BatchUpdateServerRoutesEnabled(ids []int, enabled bool) err
 	query += ")"
 	
 	// 准备参数
-	args := []interface{}{enabledValue, time.Now().UnixMilli()}
+	args := []interface{}{enabledValue, time.Now().UnixMilli(), orgID}
 	for _, id := range ids {
 		args = append(args, id)
 	}
@@ -278,8 +263,8 @@ func (r *Repository) BatchUpdateServerRoutesEnabled(ids []int, enabled bool) err
 	return err
 }
 
-// GetServerRouteStats 获取路由统计信息
-func (r *Repository) GetServerRouteStats(clientID string) (map[string]int, error) {
+// GetServerRouteStats 获取组织内的路由统计信息
+func (r *Repository) GetServerRouteStats(orgID int, clientID string) (map[string]int, error) {
 	stats := make(map[string]int)
 	
 	var query string
@@ -290,14 +275,15 @@ func (r *Repository) GetServerRouteStats(clientID string) (map[string]int, error
 			COUNT(*) as total,
 			SUM(CASE WHEN enabled = 1 AND active = 1 THEN 1 ELSE 0 END) as enabled,
 			SUM(CASE WHEN enabled = 0 OR active = 0 THEN 1 ELSE 0 END) as disabled
-			FROM server_routes WHERE client_id = ?`
-		args = []interface{}{clientID}
+			FROM server_routes WHERE org_id = ? AND client_id = ?`
+		args = []interface{}{orgID, clientID}
 	} else {
 		query = `SELECT 
 			COUNT(*) as total,
 			SUM(CASE WHEN enabled = 1 AND active = 1 THEN 1 ELSE 0 END) as enabled,
 			SUM(CASE WHEN enabled = 0 OR active = 0 THEN 1 ELSE 0 END) as disabled
-			FROM server_routes`
+			FROM server_routes WHERE org_id = ?`
+		args = []interface{}{orgID}
 	}
 	
 	var total, enabled, disabled int
@@ -315,22 +301,100 @@ func (r *Repository) GetServerRouteStats(clientID string) (map[string]int, error
 
 // UpdateClient 更新客户端信息
 func (r *Repository) UpdateClient(client *Client) error {
-	query := `UPDATE clients SET name = ?, description = ?, auth_token = ?, enabled = ?, heartbeat_interval = ?, heartbeat_timeout = ?, updated_at = ? WHERE client_id = ?`
+	query := `UPDATE clients SET name = ?, description = ?, auth_token = ?, enabled = ?, heartbeat_interval = ?, heartbeat_timeout = ?, updated_at = ?, version = version + 1 WHERE client_id = ?`
 	client.UpdatedAt = time.Now().Unix()
-	_, err := r.db.Exec(query, client.Name, client.Description, client.AuthToken, client.Enabled, client.HeartbeatInterval, client.HeartbeatTimeout, client.UpdatedAt, client.ClientID)
+	authToken, err := r.db.sealSecret(client.AuthToken)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(query, client.Name, client.Description, authToken, client.Enabled, client.HeartbeatInterval, client.HeartbeatTimeout, client.UpdatedAt, client.ClientID)
+	if err == nil {
+		client.Version++
+	}
 	return err
 }
 
+// UpdateClientWithVersion 在版本号匹配时更新客户端信息，否则返回ErrVersionConflict
+func (r *Repository) UpdateClientWithVersion(client *Client, expectedVersion int64) error {
+	query := `UPDATE clients SET name = ?, description = ?, auth_token = ?, enabled = ?, heartbeat_interval = ?, heartbeat_timeout = ?, updated_at = ?, version = version + 1 
+			   WHERE client_id = ? AND version = ?`
+	client.UpdatedAt = time.Now().Unix()
+	authToken, err := r.db.sealSecret(client.AuthToken)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.Exec(query, client.Name, client.Description, authToken, client.Enabled, client.HeartbeatInterval, client.HeartbeatTimeout, client.UpdatedAt, client.ClientID, expectedVersion)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	client.Version = expectedVersion + 1
+	return nil
+}
+
 // DeleteClient 删除客户端
 func (r *Repository) DeleteClient(clientID string) error {
 	query := `DELETE FROM clients WHERE client_id = ?`
-	_, err := r.db.Exec(query, clientID)
+	if _, err := r.db.Exec(query, clientID); err != nil {
+		return err
+	}
+	
+	// 同时移除分组成员关系
+	if _, err := r.db.Exec(`DELETE FROM client_group_members WHERE client_id = ?`, clientID); err != nil {
+		return err
+	}
+	
+	if _, err := r.db.Exec(`DELETE FROM client_stats WHERE client_id = ?`, clientID); err != nil {
+		return err
+	}
+	
+	if _, err := r.db.Exec(`DELETE FROM client_quotas WHERE client_id = ?`, clientID); err != nil {
+		return err
+	}
+	
+	if _, err := r.db.Exec(`DELETE FROM connection_history WHERE client_id = ?`, clientID); err != nil {
+		return err
+	}
+	
+	if _, err := r.db.Exec(`DELETE FROM client_reservations WHERE client_id = ?`, clientID); err != nil {
+		return err
+	}
+	
+	return r.deleteResourceACLs(ACLResourceClient, clientID)
+}
+
+// SaveClientStats 保存代理上报的运行状态，每个客户端只保留最新一份
+func (r *Repository) SaveClientStats(clientID string, statsJSON string, reportedAt int64) error {
+	query := `INSERT INTO client_stats (client_id, stats_json, reported_at) VALUES (?, ?, ?)
+			   ON CONFLICT(client_id) DO UPDATE SET stats_json = excluded.stats_json, reported_at = excluded.reported_at`
+	_, err := r.db.Exec(query, clientID, statsJSON, reportedAt)
 	return err
 }
 
+// GetClientStats 获取代理最近一次上报的运行状态，没有上报过时返回 sql.ErrNoRows
+func (r *Repository) GetClientStats(clientID string) (*ClientStats, error) {
+	query := `SELECT client_id, stats_json, reported_at FROM client_stats WHERE client_id = ?`
+	
+	stats := &ClientStats{}
+	var statsJSON string
+	var reportedAt sql.NullInt64
+	if err := r.db.QueryRow(query, clientID).Scan(&stats.ClientID, &statsJSON, &reportedAt); err != nil {
+		return nil, err
+	}
+	stats.Stats = json.RawMessage(statsJSON)
+	stats.ReportedAt = reportedAt.Int64
+	return stats, nil
+}
+
 // ListClients 列出所有客户端
 func (r *Repository) ListClients() ([]*Client, error) {
-	query := `SELECT client_id, name, description, auth_token, status, enabled, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at, updated_at, local_ips 
+	query := `SELECT ` + clientColumns + `
 			   FROM clients ORDER BY created_at DESC`
 	
 	rows, err := r.db.Query(query)
@@ -339,45 +403,34 @@ func (r *Repository) ListClients() ([]*Client, error) {
 	}
 	defer rows.Close()
 	
-	var clients []*Client
-	for rows.Next() {
-		client := &Client{}
-		var description sql.NullString
-		var localIPs sql.NullString
-		err := rows.Scan(&client.ClientID, &client.Name, &description, &client.AuthToken,
-			&client.Status, &client.Enabled, &client.LastSeenTS, &client.HeartbeatInterval, &client.HeartbeatTimeout, 
-			&client.CreatedAt, &client.UpdatedAt, &localIPs)
-		if err != nil {
-			return nil, err
-		}
-		if description.Valid {
-			client.Description = description.String
-		}
-		if localIPs.Valid {
-			client.LocalIPs = localIPs.String
-		}
-		// 处理LastSeenTS的null值
-		if client.LastSeenTS.Valid {
-			client.LastSeen = time.UnixMilli(client.LastSeenTS.Int64)
-		}
-		// 设置是否有认证令牌
-		client.HasAuthToken = client.AuthToken != ""
-		clients = append(clients, client)
+	return r.scanClients(rows)
+}
+
+// ListClientsByOrg 列出组织内的客户端
+func (r *Repository) ListClientsByOrg(orgID int) ([]*Client, error) {
+	query := `SELECT ` + clientColumns + `
+			   FROM clients WHERE org_id = ? ORDER BY created_at DESC`
+	
+	rows, err := r.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 	
-	return clients, rows.Err()
+	return r.scanClients(rows)
 }
 
 // ServerRoute operations
 
 // CreateServerRoute 创建服务端路由
 func (r *Repository) CreateServerRoute(route *ServerRoute) error {
-	query := `INSERT INTO server_routes (url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at) 
-			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO server_routes (url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at, group_id, org_id, match_headers, match_query, weight, hedge_delay_ms, compression, connect_timeout_ms, header_timeout_ms, body_idle_timeout_ms, total_timeout_ms, stream_idle_timeout_ms, stream_total_timeout_ms, cacheable, payload_compression, payload_compression_min_bytes, listen_port, capture, capture_max_bytes, capture_ttl_seconds, fault_delay_ms, fault_error_percent, fault_error_status, fault_drop_percent, source, approval_status, approval_note, reviewed_by, reviewed_at, expires_at, expire_action, private, basic_auth_user, basic_auth_hash, domain, domain_verify_method, domain_verify_token, domain_verified_at, domain_verify_error, allowed_countries, denied_countries) 
+			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 	
 	now := time.Now().UnixMilli()
 	route.CreatedAt = now
 	route.UpdatedAt = now
+	route.Version = 1
 	
 	// 如果RouteMode为空，设置默认值
 	if route.RouteMode == "" {
@@ -388,9 +441,20 @@ func (r *Repository) CreateServerRoute(route *ServerRoute) error {
 	if route.Enabled == 0 {
 		route.Enabled = 0 // 默认禁用
 	}
+	if route.OrgID == 0 {
+		route.OrgID = DefaultOrgID
+	}
+	if route.Weight <= 0 {
+		route.Weight = DefaultRouteWeight
+	}
 	
 	result, err := r.db.Exec(query, route.URLSuffix, route.ClientID, route.TargetsJSON,
-		route.DeliveryPolicy, route.RouteMode, route.Enabled, route.Description, route.CreatedAt, route.UpdatedAt)
+		route.DeliveryPolicy, route.RouteMode, route.Enabled, route.Description, route.CreatedAt, route.UpdatedAt, route.GroupID, route.OrgID,
+		encodeMatchConditions(route.MatchHeaders), encodeMatchConditions(route.MatchQuery), route.Weight, route.HedgeDelayMS, route.Compression,
+		route.ConnectTimeoutMS, route.HeaderTimeoutMS, route.BodyIdleTimeoutMS, route.TotalTimeoutMS, route.StreamIdleTimeoutMS, route.StreamTotalTimeoutMS, route.Cacheable, route.PayloadCompression, route.PayloadCompressionMinBytes, route.ListenPort,
+		route.Capture, route.CaptureMaxBytes, route.CaptureTTLSeconds,
+		route.FaultDelayMS, route.FaultErrorPercent, route.FaultErrorStatus, route.FaultDropPercent, route.Source,
+		route.ApprovalStatus, route.ApprovalNote, route.ReviewedBy, route.ReviewedAt, route.ExpiresAt, route.ExpireAction, route.Private, route.BasicAuthUser, route.BasicAuthHash, route.Domain, route.DomainVerifyMethod, route.DomainVerifyToken, route.DomainVerifiedAt, route.DomainVerifyError, encodeStringList(route.AllowedCountries), encodeStringList(route.DeniedCountries))
 	if err != nil {
 		return err
 	}
@@ -406,36 +470,15 @@ func (r *Repository) CreateServerRoute(route *ServerRoute) error {
 
 // GetServerRoute 获取服务端路由
 func (r *Repository) GetServerRoute(id int) (*ServerRoute, error) {
-	query := `SELECT id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at
+	query := `SELECT ` + serverRouteColumns + `
 			   FROM server_routes WHERE id = ?`
 	
-	route := &ServerRoute{}
-	var description sql.NullString
-	var updatedAt sql.NullInt64
-	
-	err := r.db.QueryRow(query, id).Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
-		&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt)
-	
-	if err != nil {
-		return nil, err
-	}
-	
-	// 处理可空字段
-	if description.Valid {
-		route.Description = description.String
-	}
-	if updatedAt.Valid {
-		route.UpdatedAt = updatedAt.Int64
-	} else {
-		route.UpdatedAt = route.CreatedAt // 兼容旧数据
-	}
-	
-	return route, nil
+	return scanServerRoute(r.db.QueryRow(query, id))
 }
 
 // GetServerRoutesByURLSuffix 根据URL后缀获取路由
 func (r *Repository) GetServerRoutesByURLSuffix(urlSuffix string) ([]*ServerRoute, error) {
-	query := `SELECT id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at 
+	query := `SELECT ` + serverRouteColumns + `
 			   FROM server_routes WHERE url_suffix = ? AND enabled = 1`
 	
 	rows, err := r.db.Query(query, urlSuffix)
@@ -444,37 +487,12 @@ func (r *Repository) GetServerRoutesByURLSuffix(urlSuffix string) ([]*ServerRout
 	}
 	defer rows.Close()
 	
-	var routes []*ServerRoute
-	for rows.Next() {
-		route := &ServerRoute{}
-		var description sql.NullString
-		var updatedAt sql.NullInt64
-		
-		err := rows.Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
-			&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt)
-		if err != nil {
-			return nil, err
-		}
-		
-		// 处理可空字段
-		if description.Valid {
-			route.Description = description.String
-		}
-		if updatedAt.Valid {
-			route.UpdatedAt = updatedAt.Int64
-		} else {
-			route.UpdatedAt = route.CreatedAt // 兼容旧数据
-		}
-		
-		routes = append(routes, route)
-	}
-	
-	return routes, rows.Err()
+	return scanServerRoutes(rows)
 }
 
 // ListServerRoutes 列出所有服务端路由
 func (r *Repository) ListServerRoutes() ([]*ServerRoute, error) {
-	query := `SELECT id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at 
+	query := `SELECT ` + serverRouteColumns + `
 			   FROM server_routes ORDER BY created_at DESC`
 	
 	rows, err := r.db.Query(query)
@@ -483,254 +501,1876 @@ func (r *Repository) ListServerRoutes() ([]*ServerRoute, error) {
 	}
 	defer rows.Close()
 	
-	var routes []*ServerRoute
-	for rows.Next() {
-		route := &ServerRoute{}
-		var description sql.NullString
-		var updatedAt sql.NullInt64
-		
-		err := rows.Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
-			&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt)
-		if err != nil {
-			return nil, err
-		}
-		
-		// 处理可空字段
-		if description.Valid {
-			route.Description = description.String
-		}
-		if updatedAt.Valid {
-			route.UpdatedAt = updatedAt.Int64
-		} else {
-			route.UpdatedAt = route.CreatedAt // 兼容旧数据
-		}
-		
-		routes = append(routes, route)
-	}
-	
-	return routes, rows.Err()
+	return scanServerRoutes(rows)
 }
 
-// UpdateServerRoute 更新服务端路由
-func (r *Repository) UpdateServerRoute(route *ServerRoute) error {
-	// 设置更新时间
-	route.UpdatedAt = time.Now().UnixMilli()
+// ListServerRoutesByOrg 列出组织内的服务端路由
+func (r *Repository) ListServerRoutesByOrg(orgID int) ([]*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE org_id = ? ORDER BY created_at DESC`
 	
-	query := `UPDATE server_routes SET url_suffix = ?, client_id = ?, targets_json = ?, 
-			   delivery_policy = ?, route_mode = ?, enabled = ?, description = ?, updated_at = ? 
-			   WHERE id = ?`
+	rows, err := r.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	
-	_, err := r.db.Exec(query, route.URLSuffix, route.ClientID, route.TargetsJSON,
-		route.DeliveryPolicy, route.RouteMode, route.Enabled, route.Description, route.UpdatedAt, route.ID)
-	return err
-}
-
-// DeleteServerRoute 删除服务端路由
-func (r *Repository) DeleteServerRoute(id int) error {
-	query := `DELETE FROM server_routes WHERE id = ?`
-	_, err := r.db.Exec(query, id)
-	return err
+	return scanServerRoutes(rows)
 }
 
-// GetServerRoutesByClientID 根据客户端ID获取路由列表
-func (r *Repository) GetServerRoutesByClientID(clientID string) ([]*ServerRoute, error) {
-	query := `SELECT id, url_suffix, client_id, targets_json, delivery_policy, route_mode, enabled, description, created_at, updated_at 
-			   FROM server_routes WHERE client_id = ? ORDER BY created_at DESC`
+// ListListenPortRoutes 列出配置了独立监听端口的已启用路由
+func (r *Repository) ListListenPortRoutes() ([]*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE listen_port > 0 AND enabled = 1 AND approval_status IN ('', 'approved') ORDER BY listen_port`
 	
-	rows, err := r.db.Query(query, clientID)
+	rows, err := r.db.Query(query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
-	var routes []*ServerRoute
-	for rows.Next() {
-		route := &ServerRoute{}
-		var description sql.NullString
-		var updatedAt sql.NullInt64
-		
-		err := rows.Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
-			&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt)
-		if err != nil {
-			return nil, err
-		}
-		
-		// 处理可空字段
-		if description.Valid {
-			route.Description = description.String
-		}
-		if updatedAt.Valid {
-			route.UpdatedAt = updatedAt.Int64
-		} else {
-			route.UpdatedAt = route.CreatedAt // 兼容旧数据
-		}
-		
-		routes = append(routes, route)
+	return scanServerRoutes(rows)
+}
+
+// RotateServerRouteShareVersion 增加路由的分享链接版本，之前生成的分享链接全部失效，返回新的版本
+func (r *Repository) RotateServerRouteShareVersion(id int) (int, error) {
+	if _, err := r.db.Exec(`UPDATE server_routes SET share_version = share_version + 1 WHERE id = ?`, id); err != nil {
+		return 0, err
 	}
-	
-	return routes, rows.Err()
+	var version int
+	err := r.db.QueryRow(`SELECT share_version FROM server_routes WHERE id = ?`, id).Scan(&version)
+	return version, err
 }
 
-// PendingMessage operations
+// ListExpiredServerRoutes 列出到期时间不晚于 now（毫秒）且仍启用的临时路由
+func (r *Repository) ListExpiredServerRoutes(now int64) ([]*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE expires_at > 0 AND expires_at <= ? AND enabled = 1 ORDER BY expires_at`
 
-// CreatePendingMessage 创建待处理消息
-func (r *Repository) CreatePendingMessage(msg *PendingMessage) error {
-	query := `INSERT INTO pending_messages (msg_id, client_id, url_suffix, request_meta_json, 
-			   state, retry_count, next_try_ts, created_at, last_update) 
-			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
-	
-	now := time.Now().UnixMilli()
-	msg.CreatedAt = now
-	msg.LastUpdate = now
+	rows, err := r.db.Query(query, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanServerRoutes(rows)
+}
+
+// GetServerRouteByListenPort 获取使用指定独立监听端口的路由，包括禁用的路由
+func (r *Repository) GetServerRouteByListenPort(port int) (*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE listen_port = ?`
 	
-	_, err := r.db.Exec(query, msg.MsgID, msg.ClientID, msg.URLSuffix, msg.RequestMetaJSON,
-		msg.State, msg.RetryCount, msg.NextTryTS, msg.CreatedAt, msg.LastUpdate)
-	return err
+	return scanServerRoute(r.db.QueryRow(query, port))
 }
 
-// GetPendingMessage 获取待处理消息
-func (r *Repository) GetPendingMessage(msgID string) (*PendingMessage, error) {
-	query := `SELECT msg_id, client_id, url_suffix, request_meta_json, state, retry_count, 
-			   next_try_ts, created_at, last_update, response_meta_json 
-			   FROM pending_messages WHERE msg_id = ?`
+// GetServerRouteByDomain 按自定义域名获取路由，包括禁用和未验证的路由，域名需已规范化
+func (r *Repository) GetServerRouteByDomain(domain string) (*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE domain = ?`
 	
+	return scanServerRoute(r.db.QueryRow(query, domain))
+}
+
+// ListServerRoutesByListenPortRange 列出独立监听端口在范围内的路由，包括禁用的路由
+func (r *Repository) ListServerRoutesByListenPortRange(portStart, portEnd int) ([]*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE listen_port >= ? AND listen_port <= ? ORDER BY listen_port`
+	
+	rows, err := r.db.Query(query, portStart, portEnd)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	return scanServerRoutes(rows)
+}
+
+// UpdateServerRoute 更新服务端路由
+func (r *Repository) UpdateServerRoute(route *ServerRoute) error {
+	// 设置更新时间
+	route.UpdatedAt = time.Now().UnixMilli()
+	
+	query := `UPDATE server_routes SET url_suffix = ?, client_id = ?, targets_json = ?, 
+			   delivery_policy = ?, route_mode = ?, enabled = ?, description = ?, group_id = ?, match_headers = ?, match_query = ?, weight = ?, hedge_delay_ms = ?, compression = ?, connect_timeout_ms = ?, header_timeout_ms = ?, body_idle_timeout_ms = ?, total_timeout_ms = ?, stream_idle_timeout_ms = ?, stream_total_timeout_ms = ?, cacheable = ?, payload_compression = ?, payload_compression_min_bytes = ?, listen_port = ?, capture = ?, capture_max_bytes = ?, capture_ttl_seconds = ?, fault_delay_ms = ?, fault_error_percent = ?, fault_error_status = ?, fault_drop_percent = ?, approval_status = ?, approval_note = ?, reviewed_by = ?, reviewed_at = ?, expires_at = ?, expire_action = ?, private = ?, basic_auth_user = ?, basic_auth_hash = ?, domain = ?, domain_verify_method = ?, domain_verify_token = ?, domain_verified_at = ?, domain_verify_error = ?, allowed_countries = ?, denied_countries = ?, updated_at = ?, version = version + 1 
+			   WHERE id = ?`
+	
+	_, err := r.db.Exec(query, route.URLSuffix, route.ClientID, route.TargetsJSON,
+		route.DeliveryPolicy, route.RouteMode, route.Enabled, route.Description, route.GroupID, encodeMatchConditions(route.MatchHeaders), encodeMatchConditions(route.MatchQuery), route.Weight, route.HedgeDelayMS, route.Compression,
+		route.ConnectTimeoutMS, route.HeaderTimeoutMS, route.BodyIdleTimeoutMS, route.TotalTimeoutMS, route.StreamIdleTimeoutMS, route.StreamTotalTimeoutMS, route.Cacheable, route.PayloadCompression, route.PayloadCompressionMinBytes, route.ListenPort, route.Capture, route.CaptureMaxBytes, route.CaptureTTLSeconds,
+		route.FaultDelayMS, route.FaultErrorPercent, route.FaultErrorStatus, route.FaultDropPercent, route.ApprovalStatus, route.ApprovalNote, route.ReviewedBy, route.ReviewedAt, route.ExpiresAt, route.ExpireAction, route.Private, route.BasicAuthUser, route.BasicAuthHash, route.Domain, route.DomainVerifyMethod, route.DomainVerifyToken, route.DomainVerifiedAt, route.DomainVerifyError, encodeStringList(route.AllowedCountries), encodeStringList(route.DeniedCountries), route.UpdatedAt, route.ID)
+	if err == nil {
+		route.Version++
+	}
+	return err
+}
+
+// UpdateServerRouteWithVersion 在版本号匹配时更新服务端路由，否则返回ErrVersionConflict
+func (r *Repository) UpdateServerRouteWithVersion(route *ServerRoute, expectedVersion int64) error {
+	route.UpdatedAt = time.Now().UnixMilli()
+	
+	query := `UPDATE server_routes SET url_suffix = ?, client_id = ?, targets_json = ?, 
+			   delivery_policy = ?, route_mode = ?, enabled = ?, description = ?, group_id = ?, match_headers = ?, match_query = ?, weight = ?, hedge_delay_ms = ?, compression = ?, connect_timeout_ms = ?, header_timeout_ms = ?, body_idle_timeout_ms = ?, total_timeout_ms = ?, stream_idle_timeout_ms = ?, stream_total_timeout_ms = ?, cacheable = ?, payload_compression = ?, payload_compression_min_bytes = ?, listen_port = ?, capture = ?, capture_max_bytes = ?, capture_ttl_seconds = ?, fault_delay_ms = ?, fault_error_percent = ?, fault_error_status = ?, fault_drop_percent = ?, approval_status = ?, approval_note = ?, reviewed_by = ?, reviewed_at = ?, expires_at = ?, expire_action = ?, private = ?, basic_auth_user = ?, basic_auth_hash = ?, domain = ?, domain_verify_method = ?, domain_verify_token = ?, domain_verified_at = ?, domain_verify_error = ?, allowed_countries = ?, denied_countries = ?, updated_at = ?, version = version + 1 
+			   WHERE id = ? AND version = ?`
+	
+	result, err := r.db.Exec(query, route.URLSuffix, route.ClientID, route.TargetsJSON,
+		route.DeliveryPolicy, route.RouteMode, route.Enabled, route.Description, route.GroupID, encodeMatchConditions(route.MatchHeaders), encodeMatchConditions(route.MatchQuery), route.Weight, route.HedgeDelayMS, route.Compression,
+		route.ConnectTimeoutMS, route.HeaderTimeoutMS, route.BodyIdleTimeoutMS, route.TotalTimeoutMS, route.StreamIdleTimeoutMS, route.StreamTotalTimeoutMS, route.Cacheable, route.PayloadCompression, route.PayloadCompressionMinBytes, route.ListenPort, route.Capture, route.CaptureMaxBytes, route.CaptureTTLSeconds,
+		route.FaultDelayMS, route.FaultErrorPercent, route.FaultErrorStatus, route.FaultDropPercent, route.ApprovalStatus, route.ApprovalNote, route.ReviewedBy, route.ReviewedAt, route.ExpiresAt, route.ExpireAction, route.Private, route.BasicAuthUser, route.BasicAuthHash, route.Domain, route.DomainVerifyMethod, route.DomainVerifyToken, route.DomainVerifiedAt, route.DomainVerifyError, encodeStringList(route.AllowedCountries), encodeStringList(route.DeniedCountries), route.UpdatedAt, route.ID, expectedVersion)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionConflict
+	}
+	route.Version = expectedVersion + 1
+	return nil
+}
+
+// DeleteServerRoute 删除服务端路由
+func (r *Repository) DeleteServerRoute(id int) error {
+	query := `DELETE FROM server_routes WHERE id = ?`
+	if _, err := r.db.Exec(query, id); err != nil {
+		return err
+	}
+	return r.deleteResourceACLs(ACLResourceRoute, strconv.Itoa(id))
+}
+
+// BatchDeleteResult 批量删除中单个路由的处理结果
+type BatchDeleteResult struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"` // deleted / not_found
+}
+
+// BatchDeleteServerRoutes 在单个事务中批量删除组织内的路由，clientID不为空时额外删除该客户端的全部路由
+// 其他组织的路由视为不存在
+func (r *Repository) BatchDeleteServerRoutes(orgID int, ids []int, clientID string) ([]BatchDeleteResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	
+	// 合并按客户端筛选出的路由ID，保持请求中的顺序并去重
+	targetIDs := make([]int, 0, len(ids))
+	seen := make(map[int]bool)
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			targetIDs = append(targetIDs, id)
+		}
+	}
+	if clientID != "" {
+		rows, err := tx.Query(`SELECT id FROM server_routes WHERE org_id = ? AND client_id = ? ORDER BY id`, orgID, clientID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !seen[id] {
+				seen[id] = true
+				targetIDs = append(targetIDs, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	
+	results := make([]BatchDeleteResult, 0, len(targetIDs))
+	for _, id := range targetIDs {
+		result, err := tx.Exec(`DELETE FROM server_routes WHERE id = ? AND org_id = ?`, id, orgID)
+		if err != nil {
+			return nil, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		status := "deleted"
+		if affected == 0 {
+			status = "not_found"
+		} else if _, err := tx.Exec(`DELETE FROM resource_acls WHERE resource_type = ? AND resource_id = ?`, ACLResourceRoute, strconv.Itoa(id)); err != nil {
+			return nil, err
+		}
+		results = append(results, BatchDeleteResult{ID: id, Status: status})
+	}
+	
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// GetServerRoutesByClientID 根据客户端ID获取路由列表
+func (r *Repository) GetServerRoutesByClientID(clientID string) ([]*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes WHERE client_id = ? ORDER BY created_at DESC`
+	
+	rows, err := r.db.Query(query, clientID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	return scanServerRoutes(rows)
+}
+
+// escapeLike 转义LIKE查询中的通配符
+func escapeLike(keyword string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(keyword) + "%"
+}
+
+// SearchClients 在组织内按名称、客户端ID、描述和本地IP模糊搜索客户端
+func (r *Repository) SearchClients(orgID int, keyword string, limit int) ([]*Client, error) {
+	query := `SELECT ` + clientColumns + `
+			   FROM clients 
+			   WHERE org_id = ? AND (client_id LIKE ? ESCAPE '\' OR name LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR local_ips LIKE ? ESCAPE '\')
+			   ORDER BY created_at DESC LIMIT ?`
+	
+	pattern := escapeLike(keyword)
+	rows, err := r.db.Query(query, orgID, pattern, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	return r.scanClients(rows)
+}
+
+// SearchServerRoutes 在组织内按URL后缀、描述和目标地址模糊搜索路由
+func (r *Repository) SearchServerRoutes(orgID int, keyword string, limit int) ([]*ServerRoute, error) {
+	query := `SELECT ` + serverRouteColumns + `
+			   FROM server_routes 
+			   WHERE org_id = ? AND (url_suffix LIKE ? ESCAPE '\' OR description LIKE ? ESCAPE '\' OR targets_json LIKE ? ESCAPE '\')
+			   ORDER BY created_at DESC LIMIT ?`
+	
+	pattern := escapeLike(keyword)
+	rows, err := r.db.Query(query, orgID, pattern, pattern, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	return scanServerRoutes(rows)
+}
+
+// ClientGroup operations
+
+// CreateClientGroup 创建客户端分组
+func (r *Repository) CreateClientGroup(group *ClientGroup) error {
+	query := `INSERT INTO client_groups (name, description, org_id, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`
+	
+	now := time.Now().UnixMilli()
+	group.CreatedAt = now
+	group.UpdatedAt = now
+	if group.OrgID == 0 {
+		group.OrgID = DefaultOrgID
+	}
+	
+	result, err := r.db.Exec(query, group.Name, group.Description, group.OrgID, group.CreatedAt, group.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	group.ID = int(id)
+	
+	if len(group.ClientIDs) > 0 {
+		return r.SetClientGroupMembers(group.ID, group.ClientIDs)
+	}
+	group.ClientIDs = []string{}
+	return nil
+}
+
+// GetClientGroup 获取客户端分组及其成员
+func (r *Repository) GetClientGroup(id int) (*ClientGroup, error) {
+	query := `SELECT id, name, description, org_id, created_at, updated_at FROM client_groups WHERE id = ?`
+	
+	group := &ClientGroup{}
+	var description sql.NullString
+	err := r.db.QueryRow(query, id).Scan(&group.ID, &group.Name, &description, &group.OrgID, &group.CreatedAt, &group.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if description.Valid {
+		group.Description = description.String
+	}
+	
+	group.ClientIDs, err = r.GetClientGroupMembers(id)
+	if err != nil {
+		return nil, err
+	}
+	return group, nil
+}
+
+// ListClientGroups 列出组织内的客户端分组及其成员
+func (r *Repository) ListClientGroups(orgID int) ([]*ClientGroup, error) {
+	query := `SELECT id, name, description, org_id, created_at, updated_at FROM client_groups WHERE org_id = ? ORDER BY name`
+	
+	rows, err := r.db.Query(query, orgID)
+	if err != nil {
+		return nil, err
+	}
+	
+	var groups []*ClientGroup
+	for rows.Next() {
+		group := &ClientGroup{}
+		var description sql.NullString
+		if err := rows.Scan(&group.ID, &group.Name, &description, &group.OrgID, &group.CreatedAt, &group.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if description.Valid {
+			group.Description = description.String
+		}
+		groups = append(groups, group)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	
+	// 单连接SQLite下需要在关闭rows之后再查询成员
+	for _, group := range groups {
+		group.ClientIDs, err = r.GetClientGroupMembers(group.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return groups, nil
+}
+
+// UpdateClientGroup 更新客户端分组名称和描述
+func (r *Repository) UpdateClientGroup(group *ClientGroup) error {
+	query := `UPDATE client_groups SET name = ?, description = ?, updated_at = ? WHERE id = ?`
+	group.UpdatedAt = time.Now().UnixMilli()
+	_, err := r.db.Exec(query, group.Name, group.Description, group.UpdatedAt, group.ID)
+	return err
+}
+
+// DeleteClientGroup 删除客户端分组及其成员关系
+func (r *Repository) DeleteClientGroup(id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	if _, err := tx.Exec(`DELETE FROM client_group_members WHERE group_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM client_groups WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetClientGroupMembers 获取分组的成员客户端ID
+func (r *Repository) GetClientGroupMembers(groupID int) ([]string, error) {
+	rows, err := r.db.Query(`SELECT client_id FROM client_group_members WHERE group_id = ? ORDER BY client_id`, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	clientIDs := make([]string, 0)
+	for rows.Next() {
+		var clientID string
+		if err := rows.Scan(&clientID); err != nil {
+			return nil, err
+		}
+		clientIDs = append(clientIDs, clientID)
+	}
+	return clientIDs, rows.Err()
+}
+
+// SetClientGroupMembers 替换分组的全部成员
+func (r *Repository) SetClientGroupMembers(groupID int, clientIDs []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	if _, err := tx.Exec(`DELETE FROM client_group_members WHERE group_id = ?`, groupID); err != nil {
+		return err
+	}
+	for _, clientID := range clientIDs {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO client_group_members (group_id, client_id) VALUES (?, ?)`, groupID, clientID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE client_groups SET updated_at = ? WHERE id = ?`, time.Now().UnixMilli(), groupID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// AddClientGroupMember 向分组添加成员
+func (r *Repository) AddClientGroupMember(groupID int, clientID string) error {
+	_, err := r.db.Exec(`INSERT OR IGNORE INTO client_group_members (group_id, client_id) VALUES (?, ?)`, groupID, clientID)
+	return err
+}
+
+// RemoveClientGroupMember 从分组移除成员
+func (r *Repository) RemoveClientGroupMember(groupID int, clientID string) error {
+	_, err := r.db.Exec(`DELETE FROM client_group_members WHERE group_id = ? AND client_id = ?`, groupID, clientID)
+	return err
+}
+
+// CountServerRoutesByGroupID 统计指向分组的路由数量
+func (r *Repository) CountServerRoutesByGroupID(groupID int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT COUNT(*) FROM server_routes WHERE group_id = ?`, groupID).Scan(&count)
+	return count, err
+}
+
+// PendingMessage operations
+
+// CreatePendingMessage 创建待处理消息
+func (r *Repository) CreatePendingMessage(msg *PendingMessage) error {
+	query := `INSERT INTO pending_messages (msg_id, client_id, url_suffix, request_meta_json, 
+			   state, retry_count, next_try_ts, created_at, last_update, idempotency_key) 
+			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	
+	requestMeta, err := r.db.sealSecret(msg.RequestMetaJSON)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	msg.CreatedAt = now
+	msg.LastUpdate = now
+	
+	_, err = r.db.Exec(query, msg.MsgID, msg.ClientID, msg.URLSuffix, requestMeta,
+		msg.State, msg.RetryCount, msg.NextTryTS, msg.CreatedAt, msg.LastUpdate, msg.IdempotencyKey)
+	return err
+}
+
+// GetPendingMessage 获取待处理消息
+func (r *Repository) GetPendingMessage(msgID string) (*PendingMessage, error) {
+	query := `SELECT ` + pendingMessageColumns + ` FROM pending_messages WHERE msg_id = ?`
+	return r.scanPendingMessage(r.db.QueryRow(query, msgID))
+}
+
+// GetPendingMessageByIdempotencyKey 按幂等键获取待处理消息
+func (r *Repository) GetPendingMessageByIdempotencyKey(key string) (*PendingMessage, error) {
+	query := `SELECT ` + pendingMessageColumns + ` FROM pending_messages WHERE idempotency_key = ?`
+	return r.scanPendingMessage(r.db.QueryRow(query, key))
+}
+
+// ReleaseIdempotencyKey 释放消息占用的幂等键，之后相同键的请求会重新执行
+func (r *Repository) ReleaseIdempotencyKey(msgID string) error {
+	_, err := r.db.Exec(`UPDATE pending_messages SET idempotency_key = '' WHERE msg_id = ?`, msgID)
+	return err
+}
+
+// UpdatePendingMessageState 更新待处理消息状态
+func (r *Repository) UpdatePendingMessageState(msgID, state string) error {
+	query := `UPDATE pending_messages SET state = ?, last_update = ? WHERE msg_id = ?`
+	_, err := r.db.Exec(query, state, time.Now().UnixMilli(), msgID)
+	return err
+}
+
+// ListStalePendingMessages 列出超过 next_try_ts 仍处于 pending 或 processing 的消息，最早到期的在前
+func (r *Repository) ListStalePendingMessages(now int64, limit int) ([]*PendingMessage, error) {
+	query := `SELECT ` + pendingMessageColumns + ` FROM pending_messages
+			   WHERE state IN (?, ?) AND next_try_ts <= ? ORDER BY next_try_ts LIMIT ?`
+	rows, err := r.db.Query(query, MessageStatePending, MessageStateProcessing, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*PendingMessage
+	for rows.Next() {
+		msg, err := r.scanPendingMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// ListUnfinishedPendingMessages 列出 before 之前最后更新、仍处于 pending 或 processing 的消息，最早创建的在前，用于服务端启动时恢复上次运行遗留的消息
+func (r *Repository) ListUnfinishedPendingMessages(before int64, limit int) ([]*PendingMessage, error) {
+	query := `SELECT ` + pendingMessageColumns + ` FROM pending_messages
+			   WHERE state IN (?, ?) AND last_update < ? ORDER BY created_at LIMIT ?`
+	rows, err := r.db.Query(query, MessageStatePending, MessageStateProcessing, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*PendingMessage
+	for rows.Next() {
+		msg, err := r.scanPendingMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// RequeuePendingMessage 把消息重新设为 pending，到 nextTryTS 时由清理任务重新投递，不增加重试次数
+// 消息已经不是 pending 或 processing 时不更新并返回false
+func (r *Repository) RequeuePendingMessage(msgID string, nextTryTS int64, lastError string) (bool, error) {
+	query := `UPDATE pending_messages SET state = ?, next_try_ts = ?, last_error = ?, last_update = ?
+			   WHERE msg_id = ? AND state IN (?, ?)`
+	result, err := r.db.Exec(query, MessageStatePending, nextTryTS, lastError, time.Now().UnixMilli(),
+		msgID, MessageStatePending, MessageStateProcessing)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RetryPendingMessage 记录一次重新投递：重试次数加一，state 为发送后的状态（processing 或发送失败时的 pending），nextTryTS 为下次检查的时间
+// 消息已经不是 pending 或 processing（如响应刚好到达）时不更新并返回false
+func (r *Repository) RetryPendingMessage(msgID, state string, nextTryTS int64, lastError string) (bool, error) {
+	query := `UPDATE pending_messages SET state = ?, retry_count = retry_count + 1, next_try_ts = ?, last_error = ?, last_update = ?
+			   WHERE msg_id = ? AND state IN (?, ?)`
+	result, err := r.db.Exec(query, state, nextTryTS, lastError, time.Now().UnixMilli(),
+		msgID, MessageStatePending, MessageStateProcessing)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ExpirePendingMessage 把超过期限仍没有响应的消息标记为失败
+// 消息已经不是 pending 或 processing 时不更新并返回false
+func (r *Repository) ExpirePendingMessage(msgID, lastError string) (bool, error) {
+	query := `UPDATE pending_messages SET state = ?, last_error = ?, last_update = ? WHERE msg_id = ? AND state IN (?, ?)`
+	result, err := r.db.Exec(query, MessageStateFailed, lastError, time.Now().UnixMilli(),
+		msgID, MessageStatePending, MessageStateProcessing)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// DeadLetterPendingMessage 把重新投递次数用完的消息标记为失败并移入死信队列
+// 消息已经不是 pending 或 processing 时不处理并返回false
+func (r *Repository) DeadLetterPendingMessage(msgID, lastError string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	result, err := tx.Exec(`UPDATE pending_messages SET state = ?, last_error = ?, last_update = ? WHERE msg_id = ? AND state IN (?, ?)`,
+		MessageStateFailed, lastError, now, msgID, MessageStatePending, MessageStateProcessing)
+	if err != nil {
+		return false, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected == 0 {
+		return false, err
+	}
+	if _, err := tx.Exec(`INSERT INTO dead_letters (msg_id, client_id, url_suffix, request_meta_json, retry_count, last_error, created_at, dead_at)
+			   SELECT msg_id, client_id, COALESCE(url_suffix, ''), COALESCE(request_meta_json, ''), retry_count, last_error, created_at, ?
+			   FROM pending_messages WHERE msg_id = ?`, now, msgID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// UpdatePendingMessageResponse 更新待处理消息响应
+func (r *Repository) UpdatePendingMessageResponse(msgID, state, responseMetaJSON string) error {
+	responseMeta, err := r.db.sealSecret(responseMetaJSON)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE pending_messages SET state = ?, response_meta_json = ?, last_update = ? WHERE msg_id = ?`
+	_, err = r.db.Exec(query, state, responseMeta, time.Now().UnixMilli(), msgID)
+	return err
+}
+
+// ListPendingMessages 列出待处理消息
+func (r *Repository) ListPendingMessages(limit int) ([]*PendingMessage, error) {
+	messages, _, err := r.QueryPendingMessages(PendingMessageFilter{Limit: limit})
+	return messages, err
+}
+
+// QueryPendingMessages 按条件分页查询代理请求，最新的在前，同时返回满足条件的总数
+func (r *Repository) QueryPendingMessages(filter PendingMessageFilter) ([]*PendingMessage, int, error) {
+	conditions := []string{`1 = 1`}
+	var args []interface{}
+	if filter.OrgID > 0 {
+		conditions = append(conditions, `client_id IN (SELECT client_id FROM clients WHERE org_id = ?)`)
+		args = append(args, filter.OrgID)
+	}
+	if filter.State != "" {
+		conditions = append(conditions, `state = ?`)
+		args = append(args, filter.State)
+	}
+	if filter.ClientID != "" {
+		conditions = append(conditions, `client_id = ?`)
+		args = append(args, filter.ClientID)
+	}
+	if filter.URLSuffix != "" {
+		conditions = append(conditions, `url_suffix = ?`)
+		args = append(args, filter.URLSuffix)
+	}
+	if filter.From > 0 {
+		conditions = append(conditions, `created_at >= ?`)
+		args = append(args, filter.From)
+	}
+	if filter.To > 0 {
+		conditions = append(conditions, `created_at < ?`)
+		args = append(args, filter.To)
+	}
+	where := ` WHERE ` + strings.Join(conditions, " AND ")
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM pending_messages`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + pendingMessageColumns + ` FROM pending_messages` + where + ` ORDER BY created_at DESC, msg_id LIMIT ? OFFSET ?`
+	rows, err := r.db.Query(query, append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	messages := make([]*PendingMessage, 0)
+	for rows.Next() {
+		msg, err := r.scanPendingMessage(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, total, rows.Err()
+}
+
+// scanPendingMessage 扫描 pendingMessageColumns 对应的一行并解密请求和响应元数据
+func (r *Repository) scanPendingMessage(scanner rowScanner) (*PendingMessage, error) {
 	msg := &PendingMessage{}
-	err := r.db.QueryRow(query, msgID).Scan(
-		&msg.MsgID, &msg.ClientID, &msg.URLSuffix, &msg.RequestMetaJSON,
-		&msg.State, &msg.RetryCount, &msg.NextTryTS, &msg.CreatedAt,
-		&msg.LastUpdate, &msg.ResponseMetaJSON)
+	var nextTryTS sql.NullInt64
+	err := scanner.Scan(&msg.MsgID, &msg.ClientID, &msg.URLSuffix, &msg.RequestMetaJSON,
+		&msg.State, &msg.RetryCount, &nextTryTS, &msg.CreatedAt,
+		&msg.LastUpdate, &msg.ResponseMetaJSON, &msg.IdempotencyKey, &msg.LastError)
+	if err != nil {
+		return nil, err
+	}
+	msg.NextTryTS = nextTryTS.Int64
+	if msg.RequestMetaJSON, err = r.db.openSecret(msg.RequestMetaJSON); err != nil {
+		return nil, err
+	}
+	if msg.ResponseMetaJSON.String, err = r.db.openSecret(msg.ResponseMetaJSON.String); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DeadLetter operations
+
+// deadLetterColumns dead_letters表查询字段
+const deadLetterColumns = `id, msg_id, client_id, url_suffix, request_meta_json, retry_count, last_error, created_at, dead_at`
+
+// GetDeadLetter 获取死信
+func (r *Repository) GetDeadLetter(id int64) (*DeadLetter, error) {
+	d := &DeadLetter{}
+	err := r.db.QueryRow(`SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = ?`, id).Scan(
+		&d.ID, &d.MsgID, &d.ClientID, &d.URLSuffix, &d.RequestMetaJSON, &d.RetryCount, &d.LastError, &d.CreatedAt, &d.DeadAt)
+	if err != nil {
+		return nil, err
+	}
+	if d.RequestMetaJSON, err = r.db.openSecret(d.RequestMetaJSON); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ListDeadLetters 分页列出组织内的死信，clientID 不为空时只列出该客户端的，最新的在前，同时返回总数
+func (r *Repository) ListDeadLetters(orgID int, clientID string, limit, offset int) ([]*DeadLetter, int, error) {
+	where := ` WHERE client_id IN (SELECT client_id FROM clients WHERE org_id = ?)`
+	args := []interface{}{orgID}
+	if clientID != "" {
+		where += ` AND client_id = ?`
+		args = append(args, clientID)
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM dead_letters`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(`SELECT `+deadLetterColumns+` FROM dead_letters`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	letters := make([]*DeadLetter, 0)
+	for rows.Next() {
+		d := &DeadLetter{}
+		if err := rows.Scan(&d.ID, &d.MsgID, &d.ClientID, &d.URLSuffix, &d.RequestMetaJSON, &d.RetryCount, &d.LastError, &d.CreatedAt, &d.DeadAt); err != nil {
+			return nil, 0, err
+		}
+		var err error
+		if d.RequestMetaJSON, err = r.db.openSecret(d.RequestMetaJSON); err != nil {
+			return nil, 0, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, total, rows.Err()
+}
+
+// UpdateDeadLetterError 记录重新执行失败的原因
+func (r *Repository) UpdateDeadLetterError(id int64, lastError string) error {
+	_, err := r.db.Exec(`UPDATE dead_letters SET last_error = ? WHERE id = ?`, lastError, id)
+	return err
+}
+
+// DeleteDeadLetter 删除死信，重新执行成功后调用
+func (r *Repository) DeleteDeadLetter(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM dead_letters WHERE id = ?`, id)
+	return err
+}
+
+// ApplyWriteBatch 在一个事务中执行一批写入，任意一条失败时整批回滚
+func (r *Repository) ApplyWriteBatch(batch *WriteBatch) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for clientID, lastSeen := range batch.LastSeen {
+		if _, err := tx.Exec(`UPDATE clients SET last_seen_ts = ? WHERE client_id = ?`, lastSeen, clientID); err != nil {
+			return err
+		}
+	}
+	for _, log := range batch.AuditLogs {
+		if _, err := tx.Exec(`INSERT INTO audit_logs (msg_id, client_id, direction, payload_summary, ts) VALUES (?, ?, ?, ?, ?)`,
+			log.MsgID, log.ClientID, log.Direction, log.PayloadSummary, log.TS); err != nil {
+			return err
+		}
+	}
+	for _, update := range batch.MessageUpdates {
+		sets := []string{`state = ?`, `last_update = ?`}
+		args := []interface{}{update.State, time.Now().UnixMilli()}
+		if update.LastError != "" {
+			sets = append(sets, `last_error = ?`)
+			args = append(args, update.LastError)
+		}
+		if update.ResponseMetaJSON != "" {
+			responseMeta, err := r.db.sealSecret(update.ResponseMetaJSON)
+			if err != nil {
+				return err
+			}
+			sets = append(sets, `response_meta_json = ?`)
+			args = append(args, responseMeta)
+		}
+		if update.NextTryTS != 0 {
+			sets = append(sets, `next_try_ts = ?`)
+			args = append(args, update.NextTryTS)
+		}
+		query := `UPDATE pending_messages SET ` + strings.Join(sets, ", ") + ` WHERE msg_id = ?`
+		args = append(args, update.MsgID)
+		if update.FromPending {
+			query += ` AND state = ?`
+			args = append(args, MessageStatePending)
+		}
+		if _, err := tx.Exec(query, args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AuditLog operations
+
+// CreateAuditLog 创建审计日志
+func (r *Repository) CreateAuditLog(log *AuditLog) error {
+	query := `INSERT INTO audit_logs (msg_id, client_id, direction, payload_summary, ts) 
+			   VALUES (?, ?, ?, ?, ?)`
+	
+	log.TS = time.Now().UnixMilli()
+	
+	result, err := r.db.Exec(query, log.MsgID, log.ClientID, log.Direction, log.PayloadSummary, log.TS)
+	if err != nil {
+		return err
+	}
+	
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	log.ID = int(id)
+	
+	return nil
+}
+
+// ListAuditLogs 列出审计日志
+func (r *Repository) ListAuditLogs(limit int) ([]*AuditLog, error) {
+	query := `SELECT id, msg_id, client_id, direction, payload_summary, ts 
+			   FROM audit_logs ORDER BY ts DESC LIMIT ?`
+	
+	rows, err := r.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	var logs []*AuditLog
+	for rows.Next() {
+		log := &AuditLog{}
+		err := rows.Scan(&log.ID, &log.MsgID, &log.ClientID, &log.Direction, &log.PayloadSummary, &log.TS)
+		if err != nil {
+			return nil, err
+		}
+		logs = append(logs, log)
+	}
+	
+	return logs, rows.Err()
+}
+
+// Connection history operations
+
+// maxConnectionHistory 每个客户端保留的连接记录数
+const maxConnectionHistory = 1000
+
+// CreateConnectionHistory 记录客户端建立了一次连接，并删除超出保留数量的旧记录
+func (r *Repository) CreateConnectionHistory(history *ConnectionHistory) error {
+	query := `INSERT INTO connection_history (client_id, remote_ip, connected_at) VALUES (?, ?, ?)`
+	result, err := r.db.Exec(query, history.ClientID, history.RemoteIP, history.ConnectedAt)
+	if err != nil {
+		return err
+	}
+	if history.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(`DELETE FROM connection_history WHERE client_id = ? AND id <= ?`,
+		history.ClientID, history.ID-maxConnectionHistory)
+	return err
+}
+
+// CloseConnectionHistory 记录连接断开的时间和原因
+func (r *Repository) CloseConnectionHistory(id int64, disconnectedAt int64, reason string) error {
+	query := `UPDATE connection_history SET disconnected_at = ?, duration_ms = ? - connected_at, reason = ?
+			   WHERE id = ? AND disconnected_at IS NULL`
+	_, err := r.db.Exec(query, disconnectedAt, disconnectedAt, reason, id)
+	return err
+}
+
+// ListConnectionHistory 按时间倒序列出客户端的连接记录，同时返回记录总数
+func (r *Repository) ListConnectionHistory(clientID string, limit, offset int) ([]*ConnectionHistory, int, error) {
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM connection_history WHERE client_id = ?`, clientID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT id, client_id, COALESCE(remote_ip, ''), connected_at, disconnected_at, duration_ms, COALESCE(reason, '')
+			   FROM connection_history WHERE client_id = ? ORDER BY id DESC LIMIT ? OFFSET ?`
+	rows, err := r.db.Query(query, clientID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	history := make([]*ConnectionHistory, 0)
+	for rows.Next() {
+		h := &ConnectionHistory{}
+		if err := rows.Scan(&h.ID, &h.ClientID, &h.RemoteIP, &h.ConnectedAt, &h.DisconnectedAt, &h.DurationMS, &h.Reason); err != nil {
+			return nil, 0, err
+		}
+		history = append(history, h)
+	}
+	return history, total, rows.Err()
+}
+
+// Client enabled status operations
+
+// UpdateClientEnabled 更新客户端启用状态
+func (r *Repository) UpdateClientEnabled(clientID string, enabled bool) error {
+	query := `UPDATE clients SET enabled = ?, updated_at = ?, version = version + 1 WHERE client_id = ?`
+	updatedAt := time.Now().Unix()
+	_, err := r.db.Exec(query, enabled, updatedAt, clientID)
+	return err
+}
+
+// ListEnabledClients 列出所有启用的客户端
+func (r *Repository) ListEnabledClients() ([]*Client, error) {
+	query := `SELECT ` + clientColumns + `
+			   FROM clients WHERE enabled = 1 ORDER BY created_at DESC`
+	
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	return r.scanClients(rows)
+}
+
+// scanClient 扫描单条客户端记录
+func (r *Repository) scanClient(scanner rowScanner) (*Client, error) {
+	client := &Client{}
+	var description sql.NullString
+	var localIPs sql.NullString
+	var updatedAt sql.NullInt64
+	var version sql.NullInt64
+	var agentVersion, agentOS, agentArch, capabilities sql.NullString
+	var allowedTargets, agentCommit, agentBuildDate sql.NullString
+	var lastDisconnectReason sql.NullString
+	var lastDisconnectedAt sql.NullInt64
+	err := scanner.Scan(&client.ClientID, &client.Name, &description, &client.AuthToken,
+		&client.Status, &client.Enabled, &client.LastSeenTS, &client.HeartbeatInterval, &client.HeartbeatTimeout,
+		&client.CreatedAt, &updatedAt, &localIPs, &version, &agentVersion, &agentOS, &agentArch, &capabilities, &client.OrgID, &allowedTargets, &agentCommit, &agentBuildDate, &lastDisconnectReason, &lastDisconnectedAt)
+	if err != nil {
+		return nil, err
+	}
+	
+	if client.AuthToken, err = r.db.openSecret(client.AuthToken); err != nil {
+		return nil, fmt.Errorf("invalid auth_token for client %s: %w", client.ClientID, err)
+	}
+	if description.Valid {
+		client.Description = description.String
+	}
+	if localIPs.Valid {
+		client.LocalIPs = localIPs.String
+	}
+	if updatedAt.Valid {
+		client.UpdatedAt = updatedAt.Int64
+	}
+	client.Version = 1
+	if version.Valid {
+		client.Version = version.Int64
+	}
+	client.AgentVersion = agentVersion.String
+	client.AgentOS = agentOS.String
+	client.AgentArch = agentArch.String
+	client.Capabilities = capabilities.String
+	client.AgentCommit = agentCommit.String
+	client.AgentBuildDate = agentBuildDate.String
+	client.LastDisconnectReason = lastDisconnectReason.String
+	if lastDisconnectedAt.Valid {
+		client.LastDisconnectedAt = &lastDisconnectedAt.Int64
+	}
+	if allowedTargets.Valid && allowedTargets.String != "" {
+		if err := json.Unmarshal([]byte(allowedTargets.String), &client.AllowedTargets); err != nil {
+			return nil, fmt.Errorf("invalid allowed_targets for client %s: %v", client.ClientID, err)
+		}
+	}
+	// 处理LastSeenTS的null值
+	if client.LastSeenTS.Valid {
+		client.LastSeen = time.UnixMilli(client.LastSeenTS.Int64)
+	}
+	// 设置是否有认证令牌
+	client.HasAuthToken = client.AuthToken != ""
+	return client, nil
+}
+
+// scanClients 扫描客户端记录列表
+func (r *Repository) scanClients(rows *sql.Rows) ([]*Client, error) {
+	var clients []*Client
+	for rows.Next() {
+		client, err := r.scanClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	
+	return clients, rows.Err()
+}
+
+// scanServerRoute 扫描单条路由记录
+func scanServerRoute(scanner rowScanner) (*ServerRoute, error) {
+	route := &ServerRoute{}
+	var description sql.NullString
+	var updatedAt sql.NullInt64
+	var version sql.NullInt64
+	var groupID sql.NullInt64
+	var matchHeaders, matchQuery sql.NullString
+	var allowedCountries, deniedCountries string
+	
+	err := scanner.Scan(&route.ID, &route.URLSuffix, &route.ClientID, &route.TargetsJSON,
+		&route.DeliveryPolicy, &route.RouteMode, &route.Enabled, &description, &route.CreatedAt, &updatedAt, &version,
+		&groupID, &route.OrgID, &matchHeaders, &matchQuery, &route.Weight, &route.HedgeDelayMS, &route.Compression,
+		&route.ConnectTimeoutMS, &route.HeaderTimeoutMS, &route.BodyIdleTimeoutMS, &route.TotalTimeoutMS, &route.StreamIdleTimeoutMS, &route.StreamTotalTimeoutMS, &route.Cacheable, &route.PayloadCompression, &route.PayloadCompressionMinBytes, &route.ListenPort,
+		&route.Capture, &route.CaptureMaxBytes, &route.CaptureTTLSeconds,
+		&route.FaultDelayMS, &route.FaultErrorPercent, &route.FaultErrorStatus, &route.FaultDropPercent, &route.Source,
+		&route.ApprovalStatus, &route.ApprovalNote, &route.ReviewedBy, &route.ReviewedAt, &route.ExpiresAt, &route.ExpireAction, &route.Private, &route.ShareVersion, &route.BasicAuthUser, &route.BasicAuthHash, &route.Domain, &route.DomainVerifyMethod, &route.DomainVerifyToken, &route.DomainVerifiedAt, &route.DomainVerifyError, &allowedCountries, &deniedCountries)
+	if err != nil {
+		return nil, err
+	}
+	
+	// 处理可空字段
+	if description.Valid {
+		route.Description = description.String
+	}
+	if updatedAt.Valid {
+		route.UpdatedAt = updatedAt.Int64
+	} else {
+		route.UpdatedAt = route.CreatedAt // 兼容旧数据
+	}
+	route.Version = 1
+	if version.Valid {
+		route.Version = version.Int64
+	}
+	if groupID.Valid {
+		route.GroupID = int(groupID.Int64)
+	}
+	if matchHeaders.Valid && matchHeaders.String != "" {
+		if err := json.Unmarshal([]byte(matchHeaders.String), &route.MatchHeaders); err != nil {
+			return nil, fmt.Errorf("invalid match_headers for route %d: %v", route.ID, err)
+		}
+	}
+	if matchQuery.Valid && matchQuery.String != "" {
+		if err := json.Unmarshal([]byte(matchQuery.String), &route.MatchQuery); err != nil {
+			return nil, fmt.Errorf("invalid match_query for route %d: %v", route.ID, err)
+		}
+	}
+	if allowedCountries != "" {
+		if err := json.Unmarshal([]byte(allowedCountries), &route.AllowedCountries); err != nil {
+			return nil, fmt.Errorf("invalid allowed_countries for route %d: %v", route.ID, err)
+		}
+	}
+	if deniedCountries != "" {
+		if err := json.Unmarshal([]byte(deniedCountries), &route.DeniedCountries); err != nil {
+			return nil, fmt.Errorf("invalid denied_countries for route %d: %v", route.ID, err)
+		}
+	}
+	
+	return route, nil
+}
+
+// encodeMatchConditions 将路由匹配条件序列化为JSON，没有条件时存储空字符串
+func encodeMatchConditions(conditions map[string]string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(conditions)
+	return string(data)
+}
+
+// encodeStringList 将字符串列表序列化为JSON，列表为空时存储空字符串
+func encodeStringList(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// scanServerRoutes 扫描路由记录列表
+func scanServerRoutes(rows *sql.Rows) ([]*ServerRoute, error) {
+	var routes []*ServerRoute
+	for rows.Next() {
+		route, err := scanServerRoute(rows)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route)
+	}
+	
+	return routes, rows.Err()
+}
+
+// Traffic operations
+
+// AddTrafficRollups 将流量增量累加到小时汇总表
+func (r *Repository) AddTrafficRollups(rollups []TrafficRollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+	
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	
+	stmt, err := tx.Prepare(`INSERT INTO traffic_hourly (hour_ts, client_id, route_id, url_suffix, requests, errors, bytes_in, bytes_out, org_id)
+			   VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			   ON CONFLICT(hour_ts, client_id, route_id) DO UPDATE SET
+			   url_suffix = excluded.url_suffix,
+			   requests = requests + excluded.requests,
+			   errors = errors + excluded.errors,
+			   bytes_in = bytes_in + excluded.bytes_in,
+			   bytes_out = bytes_out + excluded.bytes_out`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	
+	for _, rollup := range rollups {
+		orgID := rollup.OrgID
+		if orgID == 0 {
+			orgID = DefaultOrgID
+		}
+		if _, err := stmt.Exec(rollup.HourTS, rollup.ClientID, rollup.RouteID, rollup.URLSuffix,
+			rollup.Requests, rollup.Errors, rollup.BytesIn, rollup.BytesOut, orgID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// QueryTrafficRollups 按时间范围查询流量汇总，groupBy 为 TrafficGroupBy* 之一
+func (r *Repository) QueryTrafficRollups(filter TrafficFilter, groupBy string) ([]TrafficRollup, error) {
+	var selectCols, groupCols, orderBy string
+	switch groupBy {
+	case TrafficGroupByClient:
+		selectCols = `0, client_id, 0, ''`
+		groupCols = `client_id`
+		orderBy = `SUM(bytes_in) + SUM(bytes_out) DESC, client_id`
+	case TrafficGroupByRoute:
+		selectCols = `0, '', route_id, MAX(url_suffix)`
+		groupCols = `route_id`
+		orderBy = `SUM(requests) DESC, route_id`
+	case TrafficGroupByHour:
+		selectCols = `hour_ts, '', 0, ''`
+		groupCols = `hour_ts`
+		orderBy = `hour_ts`
+	case TrafficGroupByNone:
+		selectCols = `hour_ts, client_id, route_id, url_suffix`
+		orderBy = `hour_ts, client_id, route_id`
+	default:
+		return nil, errors.New("invalid group by: " + groupBy)
+	}
+	
+	conditions := []string{`hour_ts >= ?`, `hour_ts < ?`}
+	args := []interface{}{filter.From, filter.To}
+	if filter.OrgID > 0 {
+		conditions = append(conditions, `org_id = ?`)
+		args = append(args, filter.OrgID)
+	}
+	if filter.ClientID != "" {
+		conditions = append(conditions, `client_id = ?`)
+		args = append(args, filter.ClientID)
+	}
+	if filter.RouteID > 0 {
+		conditions = append(conditions, `route_id = ?`)
+		args = append(args, filter.RouteID)
+	}
+	
+	valueCols := `SUM(requests), SUM(errors), SUM(bytes_in), SUM(bytes_out)`
+	if groupCols == "" {
+		valueCols = `requests, errors, bytes_in, bytes_out`
+	}
+	
+	query := `SELECT ` + selectCols + `, ` + valueCols + `
+			   FROM traffic_hourly WHERE ` + strings.Join(conditions, " AND ")
+	if groupCols != "" {
+		query += ` GROUP BY ` + groupCols
+	}
+	query += ` ORDER BY ` + orderBy
+	
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	rollups := make([]TrafficRollup, 0)
+	for rows.Next() {
+		var rollup TrafficRollup
+		var urlSuffix sql.NullString
+		if err := rows.Scan(&rollup.HourTS, &rollup.ClientID, &rollup.RouteID, &urlSuffix,
+			&rollup.Requests, &rollup.Errors, &rollup.BytesIn, &rollup.BytesOut); err != nil {
+			return nil, err
+		}
+		rollup.URLSuffix = urlSuffix.String
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// Quota operations
+
+const clientQuotaColumns = `client_id, requests_per_day, bytes_per_month, webhook_url, created_at, updated_at`
+
+// scanClientQuota 扫描一行配额记录
+func scanClientQuota(scanner rowScanner) (*ClientQuota, error) {
+	quota := &ClientQuota{}
+	var webhookURL sql.NullString
+	var createdAt, updatedAt sql.NullInt64
+	if err := scanner.Scan(&quota.ClientID, &quota.RequestsPerDay, &quota.BytesPerMonth, &webhookURL, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	quota.WebhookURL = webhookURL.String
+	quota.CreatedAt = createdAt.Int64
+	quota.UpdatedAt = updatedAt.Int64
+	return quota, nil
+}
+
+// GetClientQuota 获取客户端配额，未设置时返回 sql.ErrNoRows
+func (r *Repository) GetClientQuota(clientID string) (*ClientQuota, error) {
+	query := `SELECT ` + clientQuotaColumns + ` FROM client_quotas WHERE client_id = ?`
+	return scanClientQuota(r.db.QueryRow(query, clientID))
+}
+
+// ListClientQuotas 列出客户端配额，orgID 为0时列出所有组织的配额
+func (r *Repository) ListClientQuotas(orgID int) ([]*ClientQuota, error) {
+	query := `SELECT ` + clientQuotaColumns + ` FROM client_quotas`
+	args := []interface{}{}
+	if orgID > 0 {
+		query += ` WHERE client_id IN (SELECT client_id FROM clients WHERE org_id = ?)`
+		args = append(args, orgID)
+	}
+	query += ` ORDER BY client_id`
+	
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	
+	quotas := make([]*ClientQuota, 0)
+	for rows.Next() {
+		quota, err := scanClientQuota(rows)
+		if err != nil {
+			return nil, err
+		}
+		quotas = append(quotas, quota)
+	}
+	return quotas, rows.Err()
+}
+
+// SaveClientQuota 创建或更新客户端配额
+func (r *Repository) SaveClientQuota(quota *ClientQuota) error {
+	now := time.Now().UnixMilli()
+	if quota.CreatedAt == 0 {
+		quota.CreatedAt = now
+	}
+	quota.UpdatedAt = now
+	
+	query := `INSERT INTO client_quotas (` + clientQuotaColumns + `) VALUES (?, ?, ?, ?, ?, ?)
+			   ON CONFLICT(client_id) DO UPDATE SET
+			   requests_per_day = excluded.requests_per_day,
+			   bytes_per_month = excluded.bytes_per_month,
+			   webhook_url = excluded.webhook_url,
+			   updated_at = excluded.updated_at`
+	_, err := r.db.Exec(query, quota.ClientID, quota.RequestsPerDay, quota.BytesPerMonth, quota.WebhookURL, quota.CreatedAt, quota.UpdatedAt)
+	return err
+}
+
+// DeleteClientQuota 删除客户端配额
+func (r *Repository) DeleteClientQuota(clientID string) error {
+	_, err := r.db.Exec(`DELETE FROM client_quotas WHERE client_id = ?`, clientID)
+	return err
+}
+
+// Organization operations
+
+const organizationColumns = `id, slug, name, signing_key, created_at, updated_at`
+
+// scanOrganization 扫描一行组织记录
+func (r *Repository) scanOrganization(scanner rowScanner) (*Organization, error) {
+	org := &Organization{}
+	var signingKey sql.NullString
+	var createdAt, updatedAt sql.NullInt64
+	if err := scanner.Scan(&org.ID, &org.Slug, &org.Name, &signingKey, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+	key, err := r.db.openSecret(signingKey.String)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing_key for organization %d: %w", org.ID, err)
+	}
+	org.SigningKey = key
+	org.CreatedAt = createdAt.Int64
+	org.UpdatedAt = updatedAt.Int64
+	return org, nil
+}
+
+// CreateOrganization 创建组织
+func (r *Repository) CreateOrganization(org *Organization) error {
+	now := time.Now().UnixMilli()
+	org.CreatedAt = now
+	org.UpdatedAt = now
+	
+	signingKey, err := r.db.sealSecret(org.SigningKey)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.Exec(`INSERT INTO organizations (slug, name, signing_key, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		org.Slug, org.Name, signingKey, org.CreatedAt, org.UpdatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	org.ID = int(id)
+	return nil
+}
+
+// GetOrganization 获取组织
+func (r *Repository) GetOrganization(id int) (*Organization, error) {
+	return r.scanOrganization(r.db.QueryRow(`SELECT `+organizationColumns+` FROM organizations WHERE id = ?`, id))
+}
+
+// GetOrganizationBySlug 根据标识获取组织
+func (r *Repository) GetOrganizationBySlug(slug string) (*Organization, error) {
+	return r.scanOrganization(r.db.QueryRow(`SELECT `+organizationColumns+` FROM organizations WHERE slug = ?`, slug))
+}
+
+// ListOrganizations 列出所有组织
+func (r *Repository) ListOrganizations() ([]*Organization, error) {
+	rows, err := r.db.Query(`SELECT ` + organizationColumns + ` FROM organizations ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	
-	return msg, err
+	orgs := make([]*Organization, 0)
+	for rows.Next() {
+		org, err := r.scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+	return orgs, rows.Err()
 }
 
-// UpdatePendingMessageState 更新待处理消息状态
-func (r *Repository) UpdatePendingMessageState(msgID, state string) error {
-	query := `UPDATE pending_messages SET state = ?, last_update = ? WHERE msg_id = ?`
-	_, err := r.db.Exec(query, state, time.Now().UnixMilli(), msgID)
+// UpdateOrganization 更新组织名称
+func (r *Repository) UpdateOrganization(org *Organization) error {
+	org.UpdatedAt = time.Now().UnixMilli()
+	_, err := r.db.Exec(`UPDATE organizations SET name = ?, updated_at = ? WHERE id = ?`, org.Name, org.UpdatedAt, org.ID)
 	return err
 }
 
-// UpdatePendingMessageResponse 更新待处理消息响应
-func (r *Repository) UpdatePendingMessageResponse(msgID, state, responseMetaJSON string) error {
-	query := `UPDATE pending_messages SET state = ?, response_meta_json = ?, last_update = ? WHERE msg_id = ?`
-	_, err := r.db.Exec(query, state, responseMetaJSON, time.Now().UnixMilli(), msgID)
+// UpdateOrganizationSigningKey 更新组织的JWT签名密钥
+func (r *Repository) UpdateOrganizationSigningKey(id int, signingKey string) error {
+	signingKey, err := r.db.sealSecret(signingKey)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.Exec(`UPDATE organizations SET signing_key = ?, updated_at = ? WHERE id = ?`,
+		signingKey, time.Now().UnixMilli(), id)
 	return err
 }
 
-// ListPendingMessages 列出待处理消息
-func (r *Repository) ListPendingMessages(limit int) ([]*PendingMessage, error) {
-	query := `SELECT msg_id, client_id, url_suffix, request_meta_json, state, retry_count, 
-			   next_try_ts, created_at, last_update, response_meta_json 
-			   FROM pending_messages ORDER BY created_at DESC LIMIT ?`
+// CountOrganizationResources 统计组织内的客户端、路由和分组数量
+func (r *Repository) CountOrganizationResources(id int) (int, error) {
+	var count int
+	err := r.db.QueryRow(`SELECT
+			(SELECT COUNT(*) FROM clients WHERE org_id = ?) +
+			(SELECT COUNT(*) FROM server_routes WHERE org_id = ?) +
+			(SELECT COUNT(*) FROM client_groups WHERE org_id = ?)`, id, id, id).Scan(&count)
+	return count, err
+}
+
+// DeleteOrganization 删除组织及其用户和API密钥，调用方需确认组织内没有其他资源
+func (r *Repository) DeleteOrganization(id int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 	
-	rows, err := r.db.Query(query, limit)
+	if _, err := tx.Exec(`DELETE FROM users WHERE org_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM api_keys WHERE org_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM resource_acls WHERE org_id = ?`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM organizations WHERE id = ?`, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// User operations
+
+const userColumns = `id, username, password_hash, role, org_id, created_at, last_login`
+
+// scanUser 扫描一行用户记录
+func scanUser(scanner rowScanner) (*User, error) {
+	user := &User{}
+	var createdAt, lastLogin sql.NullInt64
+	if err := scanner.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.OrgID, &createdAt, &lastLogin); err != nil {
+		return nil, err
+	}
+	user.CreatedAt = createdAt.Int64
+	user.LastLogin = lastLogin.Int64
+	return user, nil
+}
+
+// CreateUser 创建用户
+func (r *Repository) CreateUser(user *User) error {
+	user.CreatedAt = time.Now().UnixMilli()
+	if user.OrgID == 0 {
+		user.OrgID = DefaultOrgID
+	}
+	_, err := r.db.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		user.ID, user.Username, user.PasswordHash, user.Role, user.OrgID, user.CreatedAt, user.LastLogin)
+	return err
+}
+
+// GetUser 获取用户
+func (r *Repository) GetUser(id string) (*User, error) {
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+// GetUserByUsername 根据用户名获取用户
+func (r *Repository) GetUserByUsername(username string) (*User, error) {
+	return scanUser(r.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE username = ?`, username))
+}
+
+// ListUsers 列出用户，orgID 为0时列出所有组织的用户
+func (r *Repository) ListUsers(orgID int) ([]*User, error) {
+	query := `SELECT ` + userColumns + ` FROM users`
+	args := []interface{}{}
+	if orgID > 0 {
+		query += ` WHERE org_id = ?`
+		args = append(args, orgID)
+	}
+	query += ` ORDER BY created_at`
+	
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
-	var messages []*PendingMessage
+	users := make([]*User, 0)
 	for rows.Next() {
-		msg := &PendingMessage{}
-		err := rows.Scan(&msg.MsgID, &msg.ClientID, &msg.URLSuffix, &msg.RequestMetaJSON,
-			&msg.State, &msg.RetryCount, &msg.NextTryTS, &msg.CreatedAt,
-			&msg.LastUpdate, &msg.ResponseMetaJSON)
+		user, err := scanUser(rows)
 		if err != nil {
 			return nil, err
 		}
-		messages = append(messages, msg)
+		users = append(users, user)
 	}
-	
-	return messages, rows.Err()
+	return users, rows.Err()
 }
 
-// AuditLog operations
+// UpdateUserLastLogin 更新用户最后登录时间
+func (r *Repository) UpdateUserLastLogin(id string, lastLogin int64) error {
+	_, err := r.db.Exec(`UPDATE users SET last_login = ? WHERE id = ?`, lastLogin, id)
+	return err
+}
 
-// CreateAuditLog 创建审计日志
-func (r *Repository) CreateAuditLog(log *AuditLog) error {
-	query := `INSERT INTO audit_logs (msg_id, client_id, direction, payload_summary, ts) 
-			   VALUES (?, ?, ?, ?, ?)`
-	
-	log.TS = time.Now().UnixMilli()
-	
-	result, err := r.db.Exec(query, log.MsgID, log.ClientID, log.Direction, log.PayloadSummary, log.TS)
+// DeleteUser 删除用户及其访问控制条目
+func (r *Repository) DeleteUser(id string) error {
+	if _, err := r.db.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`DELETE FROM resource_acls WHERE subject_type = ? AND subject = ?`, ACLSubjectUser, id)
+	return err
+}
+
+// API key operations
+
+const apiKeyColumns = `id, org_id, name, key_prefix, key_hash, role, created_by, created_at, last_used_at, expires_at`
+
+// scanAPIKey 扫描一行API密钥记录
+func scanAPIKey(scanner rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var createdBy sql.NullString
+	var createdAt, lastUsedAt, expiresAt sql.NullInt64
+	if err := scanner.Scan(&key.ID, &key.OrgID, &key.Name, &key.Prefix, &key.KeyHash, &key.Role,
+		&createdBy, &createdAt, &lastUsedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	key.CreatedBy = createdBy.String
+	key.CreatedAt = createdAt.Int64
+	key.LastUsedAt = lastUsedAt.Int64
+	key.ExpiresAt = expiresAt.Int64
+	return key, nil
+}
+
+// CreateAPIKey 创建API密钥
+func (r *Repository) CreateAPIKey(key *APIKey) error {
+	key.CreatedAt = time.Now().UnixMilli()
+	result, err := r.db.Exec(`INSERT INTO api_keys (org_id, name, key_prefix, key_hash, role, created_by, created_at, last_used_at, expires_at)
+			   VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?)`,
+		key.OrgID, key.Name, key.Prefix, key.KeyHash, key.Role, key.CreatedBy, key.CreatedAt, key.ExpiresAt)
 	if err != nil {
 		return err
 	}
-	
 	id, err := result.LastInsertId()
 	if err != nil {
 		return err
 	}
-	log.ID = int(id)
-	
+	key.ID = int(id)
 	return nil
 }
 
-// ListAuditLogs 列出审计日志
-func (r *Repository) ListAuditLogs(limit int) ([]*AuditLog, error) {
-	query := `SELECT id, msg_id, client_id, direction, payload_summary, ts 
-			   FROM audit_logs ORDER BY ts DESC LIMIT ?`
-	
-	rows, err := r.db.Query(query, limit)
+// GetAPIKey 获取API密钥
+func (r *Repository) GetAPIKey(id int) (*APIKey, error) {
+	return scanAPIKey(r.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = ?`, id))
+}
+
+// GetAPIKeyByHash 根据密钥哈希获取API密钥
+func (r *Repository) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	return scanAPIKey(r.db.QueryRow(`SELECT `+apiKeyColumns+` FROM api_keys WHERE key_hash = ?`, keyHash))
+}
+
+// ListAPIKeys 列出组织的API密钥
+func (r *Repository) ListAPIKeys(orgID int) ([]*APIKey, error) {
+	rows, err := r.db.Query(`SELECT `+apiKeyColumns+` FROM api_keys WHERE org_id = ? ORDER BY created_at`, orgID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
-	var logs []*AuditLog
+	keys := make([]*APIKey, 0)
 	for rows.Next() {
-		log := &AuditLog{}
-		err := rows.Scan(&log.ID, &log.MsgID, &log.ClientID, &log.Direction, &log.PayloadSummary, &log.TS)
+		key, err := scanAPIKey(rows)
 		if err != nil {
 			return nil, err
 		}
-		logs = append(logs, log)
+		keys = append(keys, key)
 	}
-	
-	return logs, rows.Err()
+	return keys, rows.Err()
 }
 
-// Client enabled status operations
+// UpdateAPIKeySecret 轮换API密钥，旧密钥立即失效
+func (r *Repository) UpdateAPIKeySecret(id int, prefix, keyHash string) error {
+	_, err := r.db.Exec(`UPDATE api_keys SET key_prefix = ?, key_hash = ? WHERE id = ?`, prefix, keyHash, id)
+	return err
+}
 
-// UpdateClientEnabled 更新客户端启用状态
-func (r *Repository) UpdateClientEnabled(clientID string, enabled bool) error {
-	query := `UPDATE clients SET enabled = ?, updated_at = ? WHERE client_id = ?`
-	updatedAt := time.Now().Unix()
-	_, err := r.db.Exec(query, enabled, updatedAt, clientID)
+// UpdateAPIKeyLastUsed 更新API密钥最后使用时间
+func (r *Repository) UpdateAPIKeyLastUsed(id int, lastUsedAt int64) error {
+	_, err := r.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, lastUsedAt, id)
 	return err
 }
 
-// ListEnabledClients 列出所有启用的客户端
-func (r *Repository) ListEnabledClients() ([]*Client, error) {
-	query := `SELECT client_id, name, description, auth_token, status, enabled, last_seen_ts, heartbeat_interval, heartbeat_timeout, created_at, updated_at 
-			   FROM clients WHERE enabled = 1 ORDER BY created_at DESC`
+// DeleteAPIKey 删除API密钥及其访问控制条目，密钥作为主体时以 apikey:<id> 表示
+func (r *Repository) DeleteAPIKey(id int) error {
+	if _, err := r.db.Exec(`DELETE FROM api_keys WHERE id = ?`, id); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`DELETE FROM resource_acls WHERE subject_type = ? AND subject = ?`, ACLSubjectUser, "apikey:"+strconv.Itoa(id))
+	return err
+}
+// Resource ACL operations
+
+const resourceACLColumns = `id, org_id, resource_type, resource_id, subject_type, subject, permission, created_by, created_at`
+
+// scanResourceACL 扫描一行访问控制条目
+func scanResourceACL(scanner rowScanner) (*ResourceACL, error) {
+	acl := &ResourceACL{}
+	var createdBy sql.NullString
+	var createdAt sql.NullInt64
+	if err := scanner.Scan(&acl.ID, &acl.OrgID, &acl.ResourceType, &acl.ResourceID, &acl.SubjectType, &acl.Subject,
+		&acl.Permission, &createdBy, &createdAt); err != nil {
+		return nil, err
+	}
+	acl.CreatedBy = createdBy.String
+	acl.CreatedAt = createdAt.Int64
+	return acl, nil
+}
+
+// queryResourceACLs 查询访问控制条目列表
+func (r *Repository) queryResourceACLs(query string, args ...interface{}) ([]*ResourceACL, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 	
-	rows, err := r.db.Query(query)
+	acls := make([]*ResourceACL, 0)
+	for rows.Next() {
+		acl, err := scanResourceACL(rows)
+		if err != nil {
+			return nil, err
+		}
+		acls = append(acls, acl)
+	}
+	return acls, rows.Err()
+}
+
+// CreateResourceACL 创建访问控制条目，同一主体对同一资源只能有一条
+func (r *Repository) CreateResourceACL(acl *ResourceACL) error {
+	acl.CreatedAt = time.Now().UnixMilli()
+	result, err := r.db.Exec(`INSERT INTO resource_acls (org_id, resource_type, resource_id, subject_type, subject, permission, created_by, created_at)
+			   VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		acl.OrgID, acl.ResourceType, acl.ResourceID, acl.SubjectType, acl.Subject, acl.Permission, acl.CreatedBy, acl.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	acl.ID = int(id)
+	return nil
+}
+
+// GetResourceACL 获取访问控制条目
+func (r *Repository) GetResourceACL(id int) (*ResourceACL, error) {
+	return scanResourceACL(r.db.QueryRow(`SELECT `+resourceACLColumns+` FROM resource_acls WHERE id = ?`, id))
+}
+
+// ListResourceACLs 列出组织的访问控制条目
+func (r *Repository) ListResourceACLs(orgID int) ([]*ResourceACL, error) {
+	return r.queryResourceACLs(`SELECT `+resourceACLColumns+` FROM resource_acls WHERE org_id = ? ORDER BY id`, orgID)
+}
+
+// ListSubjectACLs 列出适用于指定用户的访问控制条目，包括授予用户本身和其角色的条目
+func (r *Repository) ListSubjectACLs(orgID int, userID, role string) ([]*ResourceACL, error) {
+	return r.queryResourceACLs(`SELECT `+resourceACLColumns+` FROM resource_acls
+			   WHERE org_id = ? AND ((subject_type = ? AND subject = ?) OR (subject_type = ? AND subject = ?))`,
+		orgID, ACLSubjectUser, userID, ACLSubjectRole, role)
+}
+
+// DeleteResourceACL 删除访问控制条目
+func (r *Repository) DeleteResourceACL(id int) error {
+	_, err := r.db.Exec(`DELETE FROM resource_acls WHERE id = ?`, id)
+	return err
+}
+
+// deleteResourceACLs 删除资源的全部访问控制条目，资源删除时调用
+func (r *Repository) deleteResourceACLs(resourceType, resourceID string) error {
+	_, err := r.db.Exec(`DELETE FROM resource_acls WHERE resource_type = ? AND resource_id = ?`, resourceType, resourceID)
+	return err
+}
+
+// Client reservation operations
+
+const clientReservationColumns = `id, client_id, port_start, port_end, domain, created_by, created_at`
+
+// scanClientReservation 扫描一行客户端预留记录
+func scanClientReservation(scanner rowScanner) (*ClientReservation, error) {
+	reservation := &ClientReservation{}
+	var createdBy sql.NullString
+	if err := scanner.Scan(&reservation.ID, &reservation.ClientID, &reservation.PortStart, &reservation.PortEnd,
+		&reservation.Domain, &createdBy, &reservation.CreatedAt); err != nil {
+		return nil, err
+	}
+	reservation.CreatedBy = createdBy.String
+	return reservation, nil
+}
+
+// queryClientReservations 查询客户端预留记录列表
+func (r *Repository) queryClientReservations(query string, args ...interface{}) ([]*ClientReservation, error) {
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	
-	var clients []*Client
+	reservations := make([]*ClientReservation, 0)
 	for rows.Next() {
-		client := &Client{}
-		var description sql.NullString
-		err := rows.Scan(&client.ClientID, &client.Name, &description, &client.AuthToken,
-			&client.Status, &client.Enabled, &client.LastSeenTS, &client.HeartbeatInterval, &client.HeartbeatTimeout, 
-			&client.CreatedAt, &client.UpdatedAt)
+		reservation, err := scanClientReservation(rows)
 		if err != nil {
 			return nil, err
 		}
-		if description.Valid {
-			client.Description = description.String
+		reservations = append(reservations, reservation)
+	}
+	return reservations, rows.Err()
+}
+
+// CreateClientReservation 创建客户端预留，冲突检查由调用方负责
+func (r *Repository) CreateClientReservation(reservation *ClientReservation) error {
+	reservation.CreatedAt = time.Now().UnixMilli()
+	result, err := r.db.Exec(`INSERT INTO client_reservations (client_id, port_start, port_end, domain, created_by, created_at)
+			   VALUES (?, ?, ?, ?, ?, ?)`,
+		reservation.ClientID, reservation.PortStart, reservation.PortEnd, reservation.Domain, reservation.CreatedBy, reservation.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	reservation.ID = int(id)
+	return nil
+}
+
+// GetClientReservation 获取客户端预留
+func (r *Repository) GetClientReservation(id int) (*ClientReservation, error) {
+	return scanClientReservation(r.db.QueryRow(`SELECT `+clientReservationColumns+` FROM client_reservations WHERE id = ?`, id))
+}
+
+// ListClientReservations 列出客户端的预留
+func (r *Repository) ListClientReservations(clientID string) ([]*ClientReservation, error) {
+	return r.queryClientReservations(`SELECT `+clientReservationColumns+` FROM client_reservations WHERE client_id = ? ORDER BY id`, clientID)
+}
+
+// ListPortReservations 列出与端口范围重叠的端口预留
+func (r *Repository) ListPortReservations(portStart, portEnd int) ([]*ClientReservation, error) {
+	return r.queryClientReservations(`SELECT `+clientReservationColumns+` FROM client_reservations
+			   WHERE domain = '' AND port_start <= ? AND port_end >= ? ORDER BY id`, portEnd, portStart)
+}
+
+// ListDomainReservations 列出所有域名预留，通配符匹配在调用方完成
+func (r *Repository) ListDomainReservations() ([]*ClientReservation, error) {
+	return r.queryClientReservations(`SELECT ` + clientReservationColumns + ` FROM client_reservations WHERE domain != '' ORDER BY id`)
+}
+
+// DeleteClientReservation 删除客户端预留
+func (r *Repository) DeleteClientReservation(id int) error {
+	_, err := r.db.Exec(`DELETE FROM client_reservations WHERE id = ?`, id)
+	return err
+}
+
+// RequestCapture operations
+
+// requestCaptureSummaryColumns request_captures表列表查询字段，不包括请求体和响应体
+const requestCaptureSummaryColumns = `id, request_id, org_id, route_id, client_id, method, url_suffix, query, request_headers, request_size, request_truncated,
+	status, response_headers, response_size, response_truncated, latency_ms, error, country, created_at, expires_at`
+
+// CreateRequestCapture 保存一次请求的抓取记录，启用数据库加密时请求头、响应头和消息体加密保存
+func (r *Repository) CreateRequestCapture(capture *RequestCapture) error {
+	requestHeaders, err := r.sealCaptureHeaders(capture.RequestHeaders)
+	if err != nil {
+		return err
+	}
+	responseHeaders, err := r.sealCaptureHeaders(capture.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+	requestBody, err := r.db.sealBytes(capture.RequestBody)
+	if err != nil {
+		return err
+	}
+	responseBody, err := r.db.sealBytes(capture.ResponseBody)
+	if err != nil {
+		return err
+	}
+	result, err := r.db.Exec(`INSERT INTO request_captures (request_id, org_id, route_id, client_id, method, url_suffix, query, request_headers, request_body, request_size, request_truncated,
+			status, response_headers, response_body, response_size, response_truncated, latency_ms, error, country, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		capture.RequestID, capture.OrgID, capture.RouteID, capture.ClientID, capture.Method, capture.URLSuffix, capture.Query,
+		requestHeaders, requestBody, capture.RequestSize, capture.RequestTruncated,
+		capture.Status, responseHeaders, responseBody, capture.ResponseSize, capture.ResponseTruncated,
+		capture.LatencyMS, capture.Error, capture.Country, capture.CreatedAt, capture.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	capture.ID, err = result.LastInsertId()
+	return err
+}
+
+// sealCaptureHeaders 编码并加密抓取记录的请求头或响应头
+func (r *Repository) sealCaptureHeaders(headers map[string]string) (string, error) {
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return "", err
+	}
+	return r.db.sealSecret(string(encoded))
+}
+
+// scanRequestCapture 扫描并解密抓取记录，withBodies 为true时包括请求体和响应体
+func (r *Repository) scanRequestCapture(scanner rowScanner, withBodies bool) (*RequestCapture, error) {
+	c := &RequestCapture{}
+	var requestHeaders, responseHeaders string
+	dest := []interface{}{&c.ID, &c.RequestID, &c.OrgID, &c.RouteID, &c.ClientID, &c.Method, &c.URLSuffix, &c.Query, &requestHeaders, &c.RequestSize, &c.RequestTruncated,
+		&c.Status, &responseHeaders, &c.ResponseSize, &c.ResponseTruncated, &c.LatencyMS, &c.Error, &c.Country, &c.CreatedAt, &c.ExpiresAt}
+	if withBodies {
+		dest = append(dest, &c.RequestBody, &c.ResponseBody)
+	}
+	if err := scanner.Scan(dest...); err != nil {
+		return nil, err
+	}
+	var err error
+	if requestHeaders, err = r.db.openSecret(requestHeaders); err != nil {
+		return nil, err
+	}
+	if responseHeaders, err = r.db.openSecret(responseHeaders); err != nil {
+		return nil, err
+	}
+	if c.RequestBody, err = r.db.openBytes(c.RequestBody); err != nil {
+		return nil, err
+	}
+	if c.ResponseBody, err = r.db.openBytes(c.ResponseBody); err != nil {
+		return nil, err
+	}
+	if requestHeaders != "" {
+		json.Unmarshal([]byte(requestHeaders), &c.RequestHeaders)
+	}
+	if responseHeaders != "" {
+		json.Unmarshal([]byte(responseHeaders), &c.ResponseHeaders)
+	}
+	return c, nil
+}
+
+// GetRequestCapture 获取抓取记录，包括请求体和响应体，已过期的视为不存在
+func (r *Repository) GetRequestCapture(id int64) (*RequestCapture, error) {
+	row := r.db.QueryRow(`SELECT `+requestCaptureSummaryColumns+`, request_body, response_body
+		FROM request_captures WHERE id = ? AND expires_at > ?`, id, time.Now().UnixMilli())
+	return r.scanRequestCapture(row, true)
+}
+
+// ListRequestCaptures 分页列出组织内未过期的抓取记录，routeIDs 不为nil时只列出这些路由的，最新的在前，同时返回总数
+func (r *Repository) ListRequestCaptures(orgID int, routeIDs []int, limit, offset int) ([]*RequestCapture, int, error) {
+	if routeIDs != nil && len(routeIDs) == 0 {
+		return make([]*RequestCapture, 0), 0, nil
+	}
+	where := ` WHERE org_id = ? AND expires_at > ?`
+	args := []interface{}{orgID, time.Now().UnixMilli()}
+	if routeIDs != nil {
+		where += ` AND route_id IN (`
+		for i, id := range routeIDs {
+			if i > 0 {
+				where += ","
+			}
+			where += "?"
+			args = append(args, id)
 		}
-		// 处理LastSeenTS的null值
-		if client.LastSeenTS.Valid {
-			client.LastSeen = time.UnixMilli(client.LastSeenTS.Int64)
+		where += ")"
+	}
+
+	var total int
+	if err := r.db.QueryRow(`SELECT COUNT(*) FROM request_captures`+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(`SELECT `+requestCaptureSummaryColumns+` FROM request_captures`+where+` ORDER BY id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	captures := make([]*RequestCapture, 0)
+	for rows.Next() {
+		c, err := r.scanRequestCapture(rows, false)
+		if err != nil {
+			return nil, 0, err
 		}
-		clients = append(clients, client)
+		captures = append(captures, c)
 	}
-	
-	return clients, rows.Err()
-}
\ No newline at end of file
+	return captures, total, rows.Err()
+}
+
+// DeleteRequestCapture 删除抓取记录
+func (r *Repository) DeleteRequestCapture(id int64) error {
+	_, err := r.db.Exec(`DELETE FROM request_captures WHERE id = ?`, id)
+	return err
+}
+
+// DeleteExpiredRequestCaptures 删除已过期的抓取记录，返回删除的条数
+func (r *Repository) DeleteExpiredRequestCaptures() (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM request_captures WHERE expires_at <= ?`, time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,267 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// 连续出现该次数的连接级错误后进入降级状态并在后台重新打开数据库
+const reopenThreshold = 3
+
+// 重新打开数据库的退避时间
+const (
+	reopenInitialBackoff = time.Second
+	reopenMaxBackoff     = 30 * time.Second
+)
+
+// 指数移动平均的权重，越大越偏向最近的查询
+const metricsSmoothing = 0.1
+
+// Metrics 数据库运行指标
+type Metrics struct {
+	Degraded         bool       `json:"degraded"`                    // 连续出现连接级错误，正在重新连接
+	DegradedSince    *time.Time `json:"degraded_since,omitempty"`    // 进入降级状态的时间
+	Queries          int64      `json:"queries"`                     // 启动以来的查询次数
+	Errors           int64      `json:"errors"`                      // 启动以来的失败次数，不含查询无结果等正常情况
+	ErrorRate        float64    `json:"error_rate"`                  // 最近查询的失败比例（指数移动平均）
+	LatencyMS        float64    `json:"latency_ms"`                  // 最近查询的平均耗时（指数移动平均）
+	MaxLatencyMS     float64    `json:"max_latency_ms"`              // 启动以来最慢的查询耗时
+	ConsecutiveFails int        `json:"consecutive_failures"`        // 连续的连接级错误次数
+	LastError        string     `json:"last_error,omitempty"`        // 最近一次错误
+	LastErrorAt      *time.Time `json:"last_error_at,omitempty"`     // 最近一次错误的时间
+	Reopens          int64      `json:"reopens"`                     // 成功重新打开数据库的次数
+	LastReopenAt     *time.Time `json:"last_reopen_at,omitempty"`    // 最近一次重新打开的时间
+	LastReopenError  string     `json:"last_reopen_error,omitempty"` // 最近一次重新打开失败的原因
+}
+
+// dbMetrics 记录查询耗时和错误，判断是否需要重新打开数据库
+type dbMetrics struct {
+	mu      sync.Mutex
+	current Metrics
+	// 是否已有重新打开的协程在运行
+	reopening bool
+}
+
+// Exec 执行语句并记录耗时和错误
+func (db *DB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	start := time.Now()
+	result, err := db.DB.Exec(query, args...)
+	db.observe(start, err)
+	return result, err
+}
+
+// Query 执行查询并记录耗时和错误
+func (db *DB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	start := time.Now()
+	rows, err := db.DB.Query(query, args...)
+	db.observe(start, err)
+	return rows, err
+}
+
+// QueryRow 执行单行查询并记录耗时和错误，查询无结果不计为错误
+func (db *DB) QueryRow(query string, args ...interface{}) *sql.Row {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	start := time.Now()
+	row := db.DB.QueryRow(query, args...)
+	db.observe(start, row.Err())
+	return row
+}
+
+// Begin 开始事务并记录耗时和错误
+func (db *DB) Begin() (*sql.Tx, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	start := time.Now()
+	tx, err := db.DB.Begin()
+	db.observe(start, err)
+	return tx, err
+}
+
+// Ping 检查连接并记录耗时和错误
+func (db *DB) Ping() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	start := time.Now()
+	err := db.DB.Ping()
+	db.observe(start, err)
+	return err
+}
+
+// Metrics 返回数据库运行指标
+func (db *DB) Metrics() Metrics {
+	db.metrics.mu.Lock()
+	defer db.metrics.mu.Unlock()
+	return db.metrics.current
+}
+
+// Degraded 数据库是否处于降级状态
+func (db *DB) Degraded() bool {
+	db.metrics.mu.Lock()
+	defer db.metrics.mu.Unlock()
+	return db.metrics.current.Degraded
+}
+
+// observe 记录一次查询的结果，连续的连接级错误达到阈值时进入降级状态并开始重新连接
+func (db *DB) observe(start time.Time, err error) {
+	latency := float64(time.Since(start)) / float64(time.Millisecond)
+	failed := err != nil && !isExpectedError(err)
+
+	m := &db.metrics
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current := &m.current
+	current.Queries++
+	if current.Queries == 1 {
+		current.LatencyMS = latency
+	} else {
+		current.LatencyMS += metricsSmoothing * (latency - current.LatencyMS)
+	}
+	if latency > current.MaxLatencyMS {
+		current.MaxLatencyMS = latency
+	}
+	failure := 0.0
+	if failed {
+		failure = 1
+	}
+	current.ErrorRate += metricsSmoothing * (failure - current.ErrorRate)
+
+	if !failed {
+		current.ConsecutiveFails = 0
+		if current.Degraded && !m.reopening {
+			// 不能重新打开的数据库在查询恢复正常后退出降级状态
+			current.Degraded = false
+			current.DegradedSince = nil
+		}
+		return
+	}
+	now := time.Now()
+	current.Errors++
+	current.LastError = err.Error()
+	current.LastErrorAt = &now
+	if !isConnectionError(err) {
+		return
+	}
+	current.ConsecutiveFails++
+	if current.ConsecutiveFails < reopenThreshold || m.reopening {
+		return
+	}
+	if !current.Degraded {
+		current.Degraded = true
+		current.DegradedSince = &now
+	}
+	if !db.reopenable() {
+		return
+	}
+	m.reopening = true
+	log.Printf("数据库连续 %d 次出错，进入降级状态并尝试重新连接: %v", current.ConsecutiveFails, err)
+	go db.reopenLoop()
+}
+
+// reopenable 内存数据库重新打开后数据会丢失，只对文件数据库重新连接
+func (db *DB) reopenable() bool {
+	return db.path != "" && !strings.Contains(db.path, ":memory:") && !strings.Contains(db.path, "mode=memory")
+}
+
+// reopenLoop 按指数退避重新打开数据库，成功后替换连接并退出降级状态
+func (db *DB) reopenLoop() {
+	backoff := reopenInitialBackoff
+	for {
+		select {
+		case <-db.closed:
+			return
+		case <-time.After(backoff):
+		}
+
+		handle, err := db.reopen()
+		if err == nil {
+			db.mu.Lock()
+			select {
+			case <-db.closed:
+				db.mu.Unlock()
+				handle.Close()
+				return
+			default:
+			}
+			old := db.DB
+			db.DB = handle
+			db.mu.Unlock()
+			old.Close()
+
+			db.metrics.mu.Lock()
+			now := time.Now()
+			current := &db.metrics.current
+			current.Degraded = false
+			current.DegradedSince = nil
+			current.ConsecutiveFails = 0
+			current.Reopens++
+			current.LastReopenAt = &now
+			current.LastReopenError = ""
+			db.metrics.reopening = false
+			db.metrics.mu.Unlock()
+			log.Printf("数据库已重新连接，退出降级状态")
+			return
+		}
+
+		db.metrics.mu.Lock()
+		db.metrics.current.LastReopenError = err.Error()
+		db.metrics.mu.Unlock()
+		log.Printf("重新连接数据库失败，%v 后重试: %v", backoff, err)
+		backoff *= 2
+		if backoff > reopenMaxBackoff {
+			backoff = reopenMaxBackoff
+		}
+	}
+}
+
+// reopen 重新打开数据库文件，文件不存在时不创建，避免在文件被移走期间建出一个空数据库
+func (db *DB) reopen() (*sql.DB, error) {
+	if !strings.HasPrefix(db.path, "file:") {
+		if _, err := os.Stat(db.path); err != nil {
+			return nil, fmt.Errorf("database file is not available: %w", err)
+		}
+	}
+	return db.open()
+}
+
+// isExpectedError 不代表数据库故障的错误：查询无结果、事务已结束、调用方取消
+func isExpectedError(err error) bool {
+	return errors.Is(err, sql.ErrNoRows) || errors.Is(err, sql.ErrTxDone) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isConnectionError 重新打开数据库可能恢复的错误：文件被移动或删除、I/O错误、连接已关闭等，
+// 约束冲突和SQL语法错误等由请求本身导致的错误不计入
+func isConnectionError(err error) bool {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_IOERR, sqlite3.SQLITE_CORRUPT, sqlite3.SQLITE_CANTOPEN,
+			sqlite3.SQLITE_READONLY, sqlite3.SQLITE_NOTADB, sqlite3.SQLITE_FULL:
+			return true
+		}
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || err.Error() == "sql: database is closed" {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
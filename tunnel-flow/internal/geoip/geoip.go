@@ -0,0 +1,57 @@
+package geoip
+
+import (
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// record 数据库记录中用到的字段，GeoLite2/GeoIP2 的 Country 和 City 数据库都包含
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// 没有国家信息的地址（如卫星和匿名代理）使用注册国家
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// Reader 按IP地址查询国家，使用MaxMind格式（.mmdb）的数据库
+// 打开后可以并发查询，更新数据库文件后需要重启服务
+type Reader struct {
+	db *maxminddb.Reader
+}
+
+// Open 打开GeoIP数据库文件
+func Open(path string) (*Reader, error) {
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{db: db}, nil
+}
+
+// Country 返回IP地址所属国家的ISO 3166-1两位代码（大写），未配置数据库、地址无效或数据库中没有时返回空字符串
+func (r *Reader) Country(ip net.IP) string {
+	if r == nil || ip == nil {
+		return ""
+	}
+	var result record
+	if err := r.db.Lookup(ip, &result); err != nil {
+		return ""
+	}
+	if result.Country.ISOCode != "" {
+		return strings.ToUpper(result.Country.ISOCode)
+	}
+	return strings.ToUpper(result.RegisteredCountry.ISOCode)
+}
+
+// Close 关闭数据库
+func (r *Reader) Close() error {
+	if r == nil {
+		return nil
+	}
+	return r.db.Close()
+}
@@ -0,0 +1,218 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"tunnel-flow/internal/config"
+)
+
+// 健康状态变化事件
+const (
+	EventUnhealthy = "client.unhealthy"
+	EventRecovered = "client.recovered"
+)
+
+// bucketCount 时间窗口划分的桶数，窗口按桶滑动
+const bucketCount = 10
+
+// Event 客户端健康状态变化 webhook 的请求体
+type Event struct {
+	Event     string  `json:"event"` // client.unhealthy 或 client.recovered
+	ClientID  string  `json:"client_id"`
+	Requests  int64   `json:"requests"`        // 窗口内的请求数
+	Errors    int64   `json:"errors"`          // 窗口内的错误数
+	ErrorRate float64 `json:"error_rate"`      // 错误率百分比
+	Until     int64   `json:"until,omitempty"` // 暂停选择的截止时间（毫秒）
+	Timestamp int64   `json:"timestamp"`
+}
+
+// bucket 一个时间桶内的计数
+type bucket struct {
+	start    int64 // 桶的起始时间（纳秒）
+	requests int64
+	errors   int64
+}
+
+// clientHealth 单个客户端的错误统计和状态
+type clientHealth struct {
+	buckets   [bucketCount]bucket
+	unhealthy bool      // 已标记为不健康，冷却期后的第一个成功请求恢复
+	until     time.Time // 冷却期截止时间，之前不参与路由选择
+}
+
+// Tracker 按客户端统计短时间窗口内代理请求的错误率
+// 错误率超过阈值的客户端在冷却期内不参与路由选择；冷却期后重新放行，
+// 下一个请求成功即恢复，失败则再次进入冷却期。状态只保存在内存中
+type Tracker struct {
+	window      time.Duration
+	cooldown    time.Duration
+	threshold   int64
+	minRequests int64
+	webhookURL  string
+	httpClient  *http.Client
+	now         func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientHealth
+}
+
+// NewTracker 创建客户端健康统计，错误率阈值不大于0时返回nil表示关闭
+func NewTracker(cfg *config.Config) *Tracker {
+	if cfg.HealthErrorThreshold <= 0 {
+		return nil
+	}
+	return &Tracker{
+		window:      time.Duration(cfg.HealthWindowSeconds) * time.Second,
+		cooldown:    time.Duration(cfg.HealthCooldownSeconds) * time.Second,
+		threshold:   int64(cfg.HealthErrorThreshold),
+		minRequests: int64(cfg.HealthMinRequests),
+		webhookURL:  cfg.HealthWebhookURL,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		now:         time.Now,
+		clients:     make(map[string]*clientHealth),
+	}
+}
+
+// Healthy 检查客户端当前是否可以参与路由选择
+func (t *Tracker) Healthy(clientID string) bool {
+	if t == nil {
+		return true
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	health, exists := t.clients[clientID]
+	if !exists || !health.unhealthy {
+		return true
+	}
+	return !t.now().Before(health.until)
+}
+
+// Record 记录一次代理请求的结果，failed 表示转发失败或后端返回5xx
+func (t *Tracker) Record(clientID string, failed bool) {
+	if t == nil {
+		return
+	}
+
+	now := t.now()
+	t.mu.Lock()
+	health, exists := t.clients[clientID]
+	if !exists {
+		health = &clientHealth{}
+		t.clients[clientID] = health
+	}
+	t.add(health, now, failed)
+
+	var event *Event
+	switch {
+	case health.unhealthy && now.Before(health.until):
+		// 冷却期内仍在处理的请求不改变状态
+	case health.unhealthy && failed:
+		health.until = now.Add(t.cooldown)
+		log.Printf("Client %s still failing after cooldown, skipping until %s", clientID, health.until.Format(time.RFC3339))
+	case health.unhealthy:
+		event = t.event(EventRecovered, clientID, health, now)
+		health.unhealthy = false
+		health.buckets = [bucketCount]bucket{}
+	default:
+		requests, errors := t.totals(health, now)
+		if requests >= t.minRequests && errors*100 >= t.threshold*requests {
+			health.unhealthy = true
+			health.until = now.Add(t.cooldown)
+			event = t.event(EventUnhealthy, clientID, health, now)
+		}
+	}
+	t.mu.Unlock()
+
+	if event == nil {
+		return
+	}
+	if event.Event == EventUnhealthy {
+		log.Printf("Client %s marked unhealthy: %d/%d requests failed (%.0f%%) in the last %v, skipping until %s",
+			clientID, event.Errors, event.Requests, event.ErrorRate, t.window, time.UnixMilli(event.Until).Format(time.RFC3339))
+	} else {
+		log.Printf("Client %s recovered", clientID)
+	}
+	if t.webhookURL != "" {
+		go t.send(*event)
+	}
+}
+
+// add 把请求计入当前时间桶，过期的桶先清零，调用方需持有锁
+func (t *Tracker) add(health *clientHealth, now time.Time, failed bool) {
+	size := t.bucketSize()
+	start := now.UnixNano() / size * size
+	b := &health.buckets[(start/size)%bucketCount]
+	if b.start != start {
+		*b = bucket{start: start}
+	}
+	b.requests++
+	if failed {
+		b.errors++
+	}
+}
+
+// totals 汇总时间窗口内的请求数和错误数，调用方需持有锁
+func (t *Tracker) totals(health *clientHealth, now time.Time) (int64, int64) {
+	var requests, errors int64
+	windowStart := now.UnixNano() - int64(t.window)
+	for _, b := range health.buckets {
+		if b.start > windowStart {
+			requests += b.requests
+			errors += b.errors
+		}
+	}
+	return requests, errors
+}
+
+// bucketSize 每个时间桶的长度（纳秒）
+func (t *Tracker) bucketSize() int64 {
+	size := int64(t.window) / bucketCount
+	if size <= 0 {
+		return 1
+	}
+	return size
+}
+
+// event 构建健康状态变化事件，调用方需持有锁
+func (t *Tracker) event(name, clientID string, health *clientHealth, now time.Time) *Event {
+	requests, errors := t.totals(health, now)
+	event := &Event{
+		Event:     name,
+		ClientID:  clientID,
+		Requests:  requests,
+		Errors:    errors,
+		Timestamp: now.UnixMilli(),
+	}
+	if requests > 0 {
+		event.ErrorRate = float64(errors) * 100 / float64(requests)
+	}
+	if name == EventUnhealthy {
+		event.Until = health.until.UnixMilli()
+	}
+	return event
+}
+
+// send 发送健康状态变化 webhook，失败只记录日志
+func (t *Tracker) send(event Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode health event for client %s: %v", event.ClientID, err)
+		return
+	}
+
+	resp, err := t.httpClient.Post(t.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send health event for client %s: %v", event.ClientID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Health event webhook for client %s returned status %d", event.ClientID, resp.StatusCode)
+	}
+}
@@ -0,0 +1,168 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 可单独设置日志级别的组件
+const (
+	ComponentWebsocket = "websocket" // 客户端连接和消息收发
+	ComponentProxy     = "proxy"     // HTTP代理转发
+	ComponentHeartbeat = "heartbeat" // 心跳和延迟统计
+)
+
+// componentLevels 各组件的日志级别，没有单独设置的组件使用基础级别
+var componentLevels = struct {
+	mu        sync.RWMutex
+	base      LogLevel
+	overrides map[string]LogLevel
+}{
+	base:      INFO,
+	overrides: make(map[string]LogLevel),
+}
+
+// knownComponents 可设置级别的组件
+var knownComponents = map[string]bool{
+	ComponentWebsocket: true,
+	ComponentProxy:     true,
+	ComponentHeartbeat: true,
+}
+
+// ParseLevel 解析日志级别名称，不区分大小写
+func ParseLevel(name string) (LogLevel, error) {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "DEBUG":
+		return DEBUG, nil
+	case "INFO":
+		return INFO, nil
+	case "WARN", "WARNING":
+		return WARN, nil
+	case "ERROR":
+		return ERROR, nil
+	case "FATAL":
+		return FATAL, nil
+	}
+	return INFO, fmt.Errorf("invalid log level %q", name)
+}
+
+// SetLevel 设置全局日志级别，同时作为没有单独设置级别的组件的级别
+func SetLevel(level LogLevel) {
+	componentLevels.mu.Lock()
+	componentLevels.base = level
+	componentLevels.mu.Unlock()
+
+	if defaultLogger != nil {
+		defaultLogger.SetLevel(level)
+	}
+}
+
+// GetLevel 全局日志级别
+func GetLevel() LogLevel {
+	componentLevels.mu.RLock()
+	defer componentLevels.mu.RUnlock()
+	return componentLevels.base
+}
+
+// SetComponentLevel 单独设置组件的日志级别
+func SetComponentLevel(component string, level LogLevel) error {
+	if !knownComponents[component] {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	componentLevels.mu.Lock()
+	componentLevels.overrides[component] = level
+	componentLevels.mu.Unlock()
+	return nil
+}
+
+// ResetComponentLevel 取消组件的单独设置，恢复使用全局日志级别
+func ResetComponentLevel(component string) error {
+	if !knownComponents[component] {
+		return fmt.Errorf("unknown log component %q", component)
+	}
+	componentLevels.mu.Lock()
+	delete(componentLevels.overrides, component)
+	componentLevels.mu.Unlock()
+	return nil
+}
+
+// ComponentLevels 各组件当前生效的日志级别
+func ComponentLevels() map[string]string {
+	componentLevels.mu.RLock()
+	defer componentLevels.mu.RUnlock()
+
+	levels := make(map[string]string, len(knownComponents))
+	for component := range knownComponents {
+		level, exists := componentLevels.overrides[component]
+		if !exists {
+			level = componentLevels.base
+		}
+		levels[component] = level.String()
+	}
+	return levels
+}
+
+// Components 可设置级别的组件名称，按名称排序
+func Components() []string {
+	names := make([]string, 0, len(knownComponents))
+	for component := range knownComponents {
+		names = append(names, component)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// componentEnabled 检查组件是否输出该级别的日志
+func componentEnabled(component string, level LogLevel) bool {
+	componentLevels.mu.RLock()
+	defer componentLevels.mu.RUnlock()
+	threshold, exists := componentLevels.overrides[component]
+	if !exists {
+		threshold = componentLevels.base
+	}
+	return level >= threshold
+}
+
+// Component 按组件级别过滤的日志，通过标准库 log 输出，格式与直接调用 log.Printf 相同
+type Component struct {
+	name string
+}
+
+// NewComponent 创建组件日志，name 应为上面定义的组件之一
+func NewComponent(name string) *Component {
+	return &Component{name: name}
+}
+
+// Enabled 检查是否输出该级别的日志，用于跳过代价较高的参数计算
+func (c *Component) Enabled(level LogLevel) bool {
+	return componentEnabled(c.name, level)
+}
+
+// Debugf 调试日志
+func (c *Component) Debugf(format string, args ...interface{}) {
+	c.printf(DEBUG, format, args...)
+}
+
+// Infof 信息日志
+func (c *Component) Infof(format string, args ...interface{}) {
+	c.printf(INFO, format, args...)
+}
+
+// Warnf 警告日志
+func (c *Component) Warnf(format string, args ...interface{}) {
+	c.printf(WARN, format, args...)
+}
+
+// Errorf 错误日志
+func (c *Component) Errorf(format string, args ...interface{}) {
+	c.printf(ERROR, format, args...)
+}
+
+func (c *Component) printf(level LogLevel, format string, args ...interface{}) {
+	if !componentEnabled(c.name, level) {
+		return
+	}
+	outputComponent(c.name, level, fmt.Sprintf(format, args...))
+}
@@ -8,7 +8,6 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
-	"strings"
 	"sync"
 	"time"
 )
@@ -50,6 +49,7 @@ type LogEntry struct {
 	Fields    map[string]interface{} `json:"fields,omitempty"`
 	Caller    string                 `json:"caller,omitempty"`
 	TraceID   string                 `json:"trace_id,omitempty"`
+	Component string                 `json:"component,omitempty"` // 只用于实时日志流
 }
 
 // Logger 结构化日志器
@@ -92,21 +92,8 @@ func NewLogger(config *Config) (*Logger, error) {
 		filename:     config.Filename,
 	}
 	
-	// 设置日志级别
-	switch strings.ToUpper(config.Level) {
-	case "DEBUG":
-		logger.level = DEBUG
-	case "INFO":
-		logger.level = INFO
-	case "WARN":
-		logger.level = WARN
-	case "ERROR":
-		logger.level = ERROR
-	case "FATAL":
-		logger.level = FATAL
-	default:
-		logger.level = INFO
-	}
+	// 设置日志级别，无法识别时使用INFO
+	logger.level, _ = ParseLevel(config.Level)
 	
 	// 设置最大保存时间
 	if config.MaxAge != "" {
@@ -219,6 +206,8 @@ func (l *Logger) log(level LogLevel, message string) {
 	// 输出日志
 	data, _ := json.Marshal(entry)
 	fmt.Fprintln(l.output, string(data))
+	
+	publish(entry)
 }
 
 // Debug 调试日志
@@ -344,6 +333,11 @@ func (l *Logger) cleanupOldFiles() {
 	}
 }
 
+// SetLevel 设置日志级别
+func (l *Logger) SetLevel(level LogLevel) {
+	l.level = level
+}
+
 // Close 关闭日志器
 func (l *Logger) Close() error {
 	l.mu.Lock()
@@ -367,6 +361,7 @@ func InitDefaultLogger(config *Config) error {
 	}
 	
 	defaultLogger = logger
+	SetLevel(logger.level)
 	return nil
 }
 
@@ -0,0 +1,123 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Loki 输出：通过 push API（/loki/api/v1/push）成批发送日志，每个级别和组件的组合是一个流
+
+const (
+	defaultLokiBatchSize     = 500
+	defaultLokiFlushInterval = time.Second
+	lokiRequestTimeout       = 10 * time.Second
+)
+
+// LokiConfig Loki 输出配置
+type LokiConfig struct {
+	URL           string            // push API 地址，如 http://loki:3100/loki/api/v1/push
+	Labels        map[string]string // 附加到所有流的标签，默认 job=tunnel-flow
+	TenantID      string            // 多租户时的 X-Scope-OrgID
+	BatchSize     int               // 每批最多发送的日志条数
+	FlushInterval time.Duration     // 不满一批时最长等待时间
+	BufferSize    int               // 缓冲的日志条数
+}
+
+// lokiStream push API 中的一个流
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiSink Loki 输出
+type lokiSink struct {
+	config LokiConfig
+	client *http.Client
+}
+
+// AddLokiSink 启用 Loki 输出
+func AddLokiSink(cfg LokiConfig) error {
+	if cfg.URL == "" {
+		return fmt.Errorf("loki url is required")
+	}
+	if len(cfg.Labels) == 0 {
+		cfg.Labels = map[string]string{"job": "tunnel-flow"}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultLokiBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultLokiFlushInterval
+	}
+
+	writer := &lokiSink{config: cfg, client: &http.Client{Timeout: lokiRequestTimeout}}
+	addSink("loki "+cfg.URL, writer, cfg.BufferSize, cfg.BatchSize, cfg.FlushInterval)
+	return nil
+}
+
+func (l *lokiSink) write(entries []LogEntry) error {
+	streams := make(map[string]*lokiStream)
+	order := make([]string, 0)
+	for _, entry := range entries {
+		key := entry.Level + "/" + entry.Component
+		stream, ok := streams[key]
+		if !ok {
+			labels := make(map[string]string, len(l.config.Labels)+2)
+			for name, value := range l.config.Labels {
+				labels[name] = value
+			}
+			labels["level"] = entry.Level
+			labels["component"] = entry.Component
+			stream = &lokiStream{Stream: labels}
+			streams[key] = stream
+			order = append(order, key)
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(entry.Timestamp.UnixNano(), 10), entry.Message})
+	}
+
+	payload := struct {
+		Streams []*lokiStream `json:"streams"`
+	}{Streams: make([]*lokiStream, 0, len(order))}
+	for _, key := range order {
+		payload.Streams = append(payload.Streams, streams[key])
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, l.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if l.config.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", l.config.TenantID)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		// 请求本身被拒绝（如日志过旧或格式错误），重试也不会成功，丢弃这一批
+		return errDropBatch{fmt.Errorf("loki rejected %d entries: %s %s", len(entries), resp.Status, bytes.TrimSpace(detail))}
+	default:
+		return fmt.Errorf("loki push failed: %s %s", resp.Status, bytes.TrimSpace(detail))
+	}
+}
+
+func (l *lokiSink) close() error {
+	l.client.CloseIdleConnections()
+	return nil
+}
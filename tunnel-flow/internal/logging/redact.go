@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// 敏感信息脱敏：日志中的请求头和请求体/响应体预览按配置的名称把值替换为 [REDACTED]
+// 默认脱敏的请求头和字段始终生效，配置的名称在此基础上追加
+
+// Redacted 替换敏感值的占位符
+const Redacted = "[REDACTED]"
+
+// defaultRedactHeaders 默认脱敏的请求头
+var defaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"X-Auth-Token",
+}
+
+// defaultRedactFields 默认脱敏的请求体字段，匹配JSON的键和表单参数名，不区分大小写
+var defaultRedactFields = []string{
+	"password",
+	"passwd",
+	"secret",
+	"token",
+	"access_token",
+	"refresh_token",
+	"client_secret",
+	"api_key",
+	"apikey",
+}
+
+// redactor 当前生效的脱敏规则
+var redactor = struct {
+	mu      sync.RWMutex
+	headers map[string]bool
+	json    *regexp.Regexp
+	form    *regexp.Regexp
+}{}
+
+func init() {
+	SetRedaction(nil, nil)
+}
+
+// SetRedaction 设置需要脱敏的请求头和请求体字段，在默认列表基础上追加，启动时调用
+func SetRedaction(headers, fields []string) {
+	headerSet := make(map[string]bool)
+	for _, name := range append(append([]string{}, defaultRedactHeaders...), headers...) {
+		if name = strings.TrimSpace(name); name != "" {
+			headerSet[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+
+	quoted := make([]string, 0, len(defaultRedactFields)+len(fields))
+	seen := make(map[string]bool)
+	for _, name := range append(append([]string{}, defaultRedactFields...), fields...) {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		quoted = append(quoted, regexp.QuoteMeta(name))
+	}
+	names := strings.Join(quoted, "|")
+
+	redactor.mu.Lock()
+	defer redactor.mu.Unlock()
+	redactor.headers = headerSet
+	// 字符串值允许缺少结尾的引号，预览可能在值的中间截断
+	redactor.json = regexp.MustCompile(`(?i)("(?:` + names + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^\s,}\]]+)`)
+	redactor.form = regexp.MustCompile(`(?i)((?:^|[?&;])(?:` + names + `)=)([^&;\s]*)`)
+}
+
+// RedactHeader 返回请求头在日志中显示的值，敏感请求头返回占位符
+func RedactHeader(name, value string) string {
+	redactor.mu.RLock()
+	defer redactor.mu.RUnlock()
+	if redactor.headers[http.CanonicalHeaderKey(name)] {
+		return Redacted
+	}
+	return value
+}
+
+// RedactHeaders 返回脱敏后的请求头副本，用于打印日志
+func RedactHeaders(headers map[string]string) map[string]string {
+	result := make(map[string]string, len(headers))
+	for name, value := range headers {
+		result[name] = RedactHeader(name, value)
+	}
+	return result
+}
+
+// RedactBody 脱敏请求体或响应体预览中的JSON字段和表单参数
+func RedactBody(body string) string {
+	redactor.mu.RLock()
+	defer redactor.mu.RUnlock()
+	body = redactor.json.ReplaceAllString(body, `${1}"`+Redacted+`"`)
+	return redactor.form.ReplaceAllString(body, "${1}"+Redacted)
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"sync"
+	"time"
+)
+
+// 日志采样：高流量路由的每个请求都会产生多行详细日志，每秒只完整记录每个键的前 initial 个请求，
+// 之后每 thereafter 个记录一个；警告和错误日志不受采样影响
+
+// Sampler 按键采样，零值和nil都表示不采样
+type Sampler struct {
+	initial    int
+	thereafter int
+
+	mu      sync.Mutex
+	window  time.Time
+	counts  map[string]int
+	skipped int64
+}
+
+// NewSampler 创建采样器，initial 不大于0时不采样
+func NewSampler(initial, thereafter int) *Sampler {
+	return &Sampler{initial: initial, thereafter: thereafter, counts: make(map[string]int)}
+}
+
+// Allow 检查这次请求是否记录详细日志
+func (s *Sampler) Allow(key string) bool {
+	if s == nil || s.initial <= 0 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.window) >= time.Second {
+		s.window = now
+		s.counts = make(map[string]int)
+	}
+	s.counts[key]++
+	count := s.counts[key]
+	if count <= s.initial || (s.thereafter > 0 && (count-s.initial)%s.thereafter == 0) {
+		return true
+	}
+	s.skipped++
+	return false
+}
+
+// Skipped 因采样没有记录详细日志的请求数
+func (s *Sampler) Skipped() int64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.skipped
+}
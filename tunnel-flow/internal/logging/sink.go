@@ -0,0 +1,243 @@
+package logging
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 日志输出：在日志文件之外把日志发送到 syslog 或 Loki 等外部系统
+// 每个输出有自己的缓冲队列和发送协程，输出不可用时日志留在队列中等待重试，队列满后丢弃新日志并计数，不阻塞写日志的调用方
+
+const (
+	defaultSinkBufferSize = 10000
+	sinkRetryMin          = time.Second
+	sinkRetryMax          = 30 * time.Second
+)
+
+// sinkWriter 具体的日志输出
+type sinkWriter interface {
+	// write 发送一批日志，返回错误时整批稍后重试
+	write(entries []LogEntry) error
+	close() error
+}
+
+// errDropBatch 发送失败且重试也不会成功，丢弃这一批而不是重试
+type errDropBatch struct {
+	err error
+}
+
+func (e errDropBatch) Error() string { return e.err.Error() }
+
+// SinkStatus 日志输出的状态和计数
+type SinkStatus struct {
+	Name        string `json:"name"`
+	Healthy     bool   `json:"healthy"`  // 最近一次发送成功
+	Queued      int    `json:"queued"`   // 等待发送的日志条数
+	Sent        int64  `json:"sent"`     // 已发送的日志条数
+	Dropped     int64  `json:"dropped"`  // 队列满或无法发送而丢弃的日志条数
+	Failures    int64  `json:"failures"` // 发送失败的次数
+	LastError   string `json:"last_error,omitempty"`
+	LastErrorAt int64  `json:"last_error_at,omitempty"`
+}
+
+// sink 一个日志输出及其缓冲队列
+type sink struct {
+	name          string
+	writer        sinkWriter
+	queue         chan LogEntry
+	batchSize     int
+	flushInterval time.Duration
+	done          chan struct{}
+
+	sent     atomic.Int64
+	dropped  atomic.Int64
+	failures atomic.Int64
+	healthy  atomic.Bool
+
+	mu          sync.Mutex
+	lastError   string
+	lastErrorAt int64
+}
+
+// sinks 已启用的日志输出
+var sinks = struct {
+	mu   sync.RWMutex
+	list []*sink
+}{}
+
+// addSink 注册日志输出并启动发送协程
+func addSink(name string, writer sinkWriter, bufferSize, batchSize int, flushInterval time.Duration) {
+	if bufferSize <= 0 {
+		bufferSize = defaultSinkBufferSize
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	s := &sink{
+		name:          name,
+		writer:        writer,
+		queue:         make(chan LogEntry, bufferSize),
+		batchSize:     batchSize,
+		flushInterval: flushInterval,
+		done:          make(chan struct{}),
+	}
+	s.healthy.Store(true)
+	go s.run()
+
+	sinks.mu.Lock()
+	sinks.list = append(sinks.list, s)
+	sinks.mu.Unlock()
+}
+
+// dispatchToSinks 把日志放入所有输出的队列，队列已满时丢弃
+func dispatchToSinks(entry LogEntry) {
+	sinks.mu.RLock()
+	defer sinks.mu.RUnlock()
+	for _, s := range sinks.list {
+		select {
+		case s.queue <- entry:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// run 从队列中取出日志成批发送，发送失败时按退避间隔重试同一批
+func (s *sink) run() {
+	defer close(s.done)
+
+	batch := make([]LogEntry, 0, s.batchSize)
+	var flush <-chan time.Time
+	var timer *time.Timer
+	for {
+		if len(batch) > 0 && len(batch) < s.batchSize && s.flushInterval > 0 && flush == nil {
+			timer = time.NewTimer(s.flushInterval)
+			flush = timer.C
+		}
+
+		ready := len(batch) >= s.batchSize || (len(batch) > 0 && s.flushInterval <= 0)
+		if !ready {
+			select {
+			case entry, ok := <-s.queue:
+				if !ok {
+					if len(batch) > 0 {
+						s.send(batch, false)
+					}
+					s.writer.close()
+					return
+				}
+				batch = append(batch, entry)
+				continue
+			case <-flush:
+				flush = nil
+			}
+		}
+		if timer != nil {
+			timer.Stop()
+			timer, flush = nil, nil
+		}
+
+		s.send(batch, true)
+		batch = batch[:0]
+	}
+}
+
+// send 发送一批日志，retry 为true时失败后一直重试，直到成功或队列关闭
+func (s *sink) send(batch []LogEntry, retry bool) {
+	delay := sinkRetryMin
+	for {
+		err := s.writer.write(batch)
+		if err == nil {
+			s.sent.Add(int64(len(batch)))
+			if !s.healthy.Swap(true) {
+				log.Printf("Log sink %s recovered", s.name)
+			}
+			return
+		}
+
+		s.failures.Add(1)
+		s.mu.Lock()
+		s.lastError = err.Error()
+		s.lastErrorAt = time.Now().UnixMilli()
+		s.mu.Unlock()
+		if s.healthy.Swap(false) {
+			// 只在状态变化时记录，避免输出不可用时日志本身不断产生新日志
+			log.Printf("Log sink %s unavailable, buffering logs: %v", s.name, err)
+		}
+		if _, drop := err.(errDropBatch); drop || !retry || s.closing() {
+			s.dropped.Add(int64(len(batch)))
+			return
+		}
+
+		time.Sleep(delay)
+		if delay *= 2; delay > sinkRetryMax {
+			delay = sinkRetryMax
+		}
+	}
+}
+
+// closing 检查是否正在关闭，关闭时不再等待不可用的输出
+func (s *sink) closing() bool {
+	select {
+	case <-closingSinks:
+		return true
+	default:
+		return false
+	}
+}
+
+// closingSinks 关闭所有输出时关闭
+var closingSinks = make(chan struct{})
+
+// status 返回输出的状态
+func (s *sink) status() SinkStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SinkStatus{
+		Name:        s.name,
+		Healthy:     s.healthy.Load(),
+		Queued:      len(s.queue),
+		Sent:        s.sent.Load(),
+		Dropped:     s.dropped.Load(),
+		Failures:    s.failures.Load(),
+		LastError:   s.lastError,
+		LastErrorAt: s.lastErrorAt,
+	}
+}
+
+// SinkStatuses 返回所有日志输出的状态
+func SinkStatuses() []SinkStatus {
+	sinks.mu.RLock()
+	defer sinks.mu.RUnlock()
+	result := make([]SinkStatus, 0, len(sinks.list))
+	for _, s := range sinks.list {
+		result = append(result, s.status())
+	}
+	return result
+}
+
+// CloseSinks 发送队列中剩余的日志并关闭所有输出，最多等待 timeout，退出前调用
+func CloseSinks(timeout time.Duration) {
+	sinks.mu.Lock()
+	list := sinks.list
+	sinks.list = nil
+	sinks.mu.Unlock()
+	if len(list) == 0 {
+		return
+	}
+
+	close(closingSinks)
+	for _, s := range list {
+		close(s.queue)
+	}
+	deadline := time.After(timeout)
+	for _, s := range list {
+		select {
+		case <-s.done:
+		case <-deadline:
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package logging
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 实时日志流：所有日志同时发布给订阅者和日志输出，供管理接口实时查看
+// 组件日志带有组件名和级别；直接调用标准库 log 的日志没有级别信息，按INFO级别、general组件发布
+
+// ComponentGeneral 不属于任何组件的日志
+const ComponentGeneral = "general"
+
+// streamBacklogSize 保留的最近日志条数，订阅时可以先返回这些日志
+const streamBacklogSize = 500
+
+// StreamFilter 订阅的过滤条件，零值表示不过滤
+type StreamFilter struct {
+	MinLevel   LogLevel        // 只接收不低于该级别的日志
+	Components map[string]bool // 只接收这些组件的日志，为空时接收所有组件
+}
+
+// Match 检查日志是否满足过滤条件
+func (f StreamFilter) Match(entry LogEntry) bool {
+	if level, err := ParseLevel(entry.Level); err == nil && level < f.MinLevel {
+		return false
+	}
+	return len(f.Components) == 0 || f.Components[entry.Component]
+}
+
+// Subscription 一个实时日志订阅，处理不及时时丢弃日志而不阻塞写日志的调用方
+type Subscription struct {
+	C       <-chan LogEntry
+	ch      chan LogEntry
+	filter  StreamFilter
+	dropped int64 // 受 stream.mu 保护
+}
+
+// Dropped 因处理不及时丢弃的日志条数
+func (s *Subscription) Dropped() int64 {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	return s.dropped
+}
+
+// Close 取消订阅
+func (s *Subscription) Close() {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if _, ok := stream.subscribers[s]; ok {
+		delete(stream.subscribers, s)
+		close(s.ch)
+	}
+}
+
+// stream 日志订阅者和最近的日志
+var stream = struct {
+	mu          sync.Mutex
+	subscribers map[*Subscription]struct{}
+	backlog     []LogEntry // 环形缓冲区
+	next        int
+	full        bool
+}{
+	subscribers: make(map[*Subscription]struct{}),
+	backlog:     make([]LogEntry, streamBacklogSize),
+}
+
+// Subscribe 订阅实时日志，buffer 为订阅者的缓冲条数，使用完毕后需调用 Close
+func Subscribe(filter StreamFilter, buffer int) *Subscription {
+	ch := make(chan LogEntry, buffer)
+	sub := &Subscription{C: ch, ch: ch, filter: filter}
+
+	stream.mu.Lock()
+	stream.subscribers[sub] = struct{}{}
+	stream.mu.Unlock()
+	return sub
+}
+
+// Recent 返回满足过滤条件的最近 limit 条日志，按时间顺序排列
+func Recent(filter StreamFilter, limit int) []LogEntry {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	entries := make([]LogEntry, 0)
+	count := stream.next
+	if stream.full {
+		count = len(stream.backlog)
+	}
+	for i := 0; i < count && len(entries) < limit; i++ {
+		entry := stream.backlog[(stream.next-1-i+len(stream.backlog))%len(stream.backlog)]
+		if filter.Match(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries
+}
+
+// publish 保存日志并发送给日志输出和订阅者
+func publish(entry LogEntry) {
+	if entry.Component == "" {
+		entry.Component = ComponentGeneral
+	}
+	dispatchToSinks(entry)
+
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+
+	stream.backlog[stream.next] = entry
+	stream.next = (stream.next + 1) % len(stream.backlog)
+	if stream.next == 0 {
+		stream.full = true
+	}
+
+	for sub := range stream.subscribers {
+		if !sub.filter.Match(entry) {
+			continue
+		}
+		select {
+		case sub.ch <- entry:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// standardTap 替换标准库 log 的输出，写入原输出的同时发布日志
+type standardTap struct {
+	out io.Writer
+	raw *log.Logger // 组件日志直接写入原输出，避免再按无级别日志发布一次
+}
+
+var (
+	tapMu sync.RWMutex
+	tap   *standardTap
+)
+
+// CaptureStandardLog 开始把标准库 log 的输出发布到实时日志流，启动时调用一次
+func CaptureStandardLog() {
+	tapMu.Lock()
+	defer tapMu.Unlock()
+	if tap != nil {
+		return
+	}
+	out := log.Writer()
+	tap = &standardTap{out: out, raw: log.New(out, log.Prefix(), log.Flags())}
+	log.SetOutput(tap)
+}
+
+// Write 实现 io.Writer，标准库 log 每条日志调用一次
+func (t *standardTap) Write(p []byte) (int, error) {
+	n, err := t.out.Write(p)
+	publish(LogEntry{
+		Timestamp: time.Now(),
+		Level:     INFO.String(),
+		Message:   stripStandardPrefix(string(bytes.TrimRight(p, "\n"))),
+	})
+	return n, err
+}
+
+// stripStandardPrefix 去掉标准库 log 加在消息前的日期、时间和文件位置
+func stripStandardPrefix(line string) string {
+	line = strings.TrimPrefix(line, log.Prefix())
+	fields := 0
+	flags := log.Flags()
+	if flags&log.Ldate != 0 {
+		fields++
+	}
+	if flags&(log.Ltime|log.Lmicroseconds) != 0 {
+		fields++
+	}
+	if flags&(log.Lshortfile|log.Llongfile) != 0 {
+		fields++
+	}
+	for ; fields > 0; fields-- {
+		index := strings.IndexByte(line, ' ')
+		if index < 0 {
+			break
+		}
+		line = line[index+1:]
+	}
+	return line
+}
+
+// outputComponent 输出组件日志并带上组件名和级别发布，未开始捕获时等同于 log.Output
+func outputComponent(component string, level LogLevel, message string) {
+	tapMu.RLock()
+	current := tap
+	tapMu.RUnlock()
+	if current == nil {
+		log.Output(4, message)
+		return
+	}
+
+	current.raw.Output(4, message)
+	publish(LogEntry{
+		Timestamp: time.Now(),
+		Level:     level.String(),
+		Message:   message,
+		Component: component,
+	})
+}
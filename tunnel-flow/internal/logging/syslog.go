@@ -0,0 +1,140 @@
+package logging
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// syslog 输出：network 为空时写入本机 syslog，为 udp、tcp 或 tcp+tls 时按 RFC 5424 格式发送到远程服务器
+// TCP 和 TLS 连接使用 RFC 6587 的长度前缀分帧，连接断开后在下次发送时重新连接
+
+// syslogFacilityDaemon syslog 设施，系统守护进程
+const syslogFacilityDaemon = 3
+
+// syslogDialTimeout 连接远程 syslog 服务器的超时
+const syslogDialTimeout = 10 * time.Second
+
+// SyslogConfig syslog 输出配置
+type SyslogConfig struct {
+	Network    string // 为空时写入本机 syslog；udp、tcp 或 tcp+tls
+	Address    string // 远程服务器地址 host:port
+	Tag        string // 应用名称，默认 tunnel-flow
+	BufferSize int    // 缓冲的日志条数
+}
+
+// syslogSeverity 日志级别对应的 syslog 严重程度
+func syslogSeverity(level string) int {
+	switch level {
+	case DEBUG.String():
+		return 7
+	case WARN.String():
+		return 4
+	case ERROR.String():
+		return 3
+	case FATAL.String():
+		return 2
+	default:
+		return 6
+	}
+}
+
+// AddSyslogSink 启用 syslog 输出，远程服务器暂时不可用时日志在缓冲中等待重试
+func AddSyslogSink(cfg SyslogConfig) error {
+	if cfg.Tag == "" {
+		cfg.Tag = "tunnel-flow"
+	}
+
+	var writer sinkWriter
+	name := "syslog"
+	switch cfg.Network {
+	case "":
+		local, err := newLocalSyslog(cfg.Tag)
+		if err != nil {
+			return err
+		}
+		writer = local
+	case "udp", "tcp", "tcp+tls":
+		if cfg.Address == "" {
+			return fmt.Errorf("syslog address is required for network %s", cfg.Network)
+		}
+		hostname, _ := os.Hostname()
+		writer = &remoteSyslog{network: cfg.Network, address: cfg.Address, tag: cfg.Tag, hostname: hostname}
+		name = fmt.Sprintf("syslog %s://%s", cfg.Network, cfg.Address)
+	default:
+		return fmt.Errorf("syslog network must be empty, udp, tcp or tcp+tls")
+	}
+
+	addSink(name, writer, cfg.BufferSize, 1, 0)
+	return nil
+}
+
+// remoteSyslog 远程 syslog 服务器
+type remoteSyslog struct {
+	network  string
+	address  string
+	tag      string
+	hostname string
+	conn     net.Conn // 只在发送协程中使用
+}
+
+// connect 连接远程服务器
+func (s *remoteSyslog) connect() error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: syslogDialTimeout}
+	switch s.network {
+	case "tcp+tls":
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.address, &tls.Config{MinVersion: tls.VersionTLS12})
+	default:
+		conn, err = dialer.Dial(s.network, s.address)
+	}
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// format 按 RFC 5424 格式化一条日志，组件名作为 MSGID
+func (s *remoteSyslog) format(entry LogEntry) string {
+	priority := syslogFacilityDaemon*8 + syslogSeverity(entry.Level)
+	hostname := s.hostname
+	if hostname == "" {
+		hostname = "-"
+	}
+	message := strings.ReplaceAll(entry.Message, "\n", " ")
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s - %s", priority, entry.Timestamp.Format(time.RFC3339Nano), hostname, s.tag, os.Getpid(), entry.Component, message)
+}
+
+func (s *remoteSyslog) write(entries []LogEntry) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		message := s.format(entry)
+		if s.network != "udp" {
+			message = fmt.Sprintf("%d %s", len(message), message)
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogDialTimeout))
+		if _, err := s.conn.Write([]byte(message)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *remoteSyslog) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+
+package logging
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// localSyslog 本机 syslog
+type localSyslog struct {
+	writer *syslog.Writer
+}
+
+// newLocalSyslog 连接本机 syslog
+func newLocalSyslog(tag string) (*localSyslog, error) {
+	writer, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to local syslog: %w", err)
+	}
+	return &localSyslog{writer: writer}, nil
+}
+
+func (s *localSyslog) write(entries []LogEntry) error {
+	for _, entry := range entries {
+		message := fmt.Sprintf("[%s] %s", entry.Component, entry.Message)
+		var err error
+		switch entry.Level {
+		case DEBUG.String():
+			err = s.writer.Debug(message)
+		case WARN.String():
+			err = s.writer.Warning(message)
+		case ERROR.String():
+			err = s.writer.Err(message)
+		case FATAL.String():
+			err = s.writer.Crit(message)
+		default:
+			err = s.writer.Info(message)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *localSyslog) close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package logging
+
+import "fmt"
+
+// localSyslog Windows 没有本机 syslog
+type localSyslog struct{}
+
+// newLocalSyslog Windows 上只能发送到远程 syslog 服务器
+func newLocalSyslog(tag string) (*localSyslog, error) {
+	return nil, fmt.Errorf("local syslog is not supported on windows, set a remote syslog network and address")
+}
+
+func (s *localSyslog) write(entries []LogEntry) error {
+	return nil
+}
+
+func (s *localSyslog) close() error {
+	return nil
+}
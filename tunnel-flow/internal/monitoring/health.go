@@ -233,10 +233,27 @@ func (hc *HealthChecker) HTTPHandler() http.HandlerFunc {
 
 // 内置健康检查实现
 
+// 数据库最近查询的错误率或平均耗时超过该值时报告降级
+const (
+	databaseErrorRateThreshold = 0.2
+	databaseLatencyThreshold   = 500 * time.Millisecond
+)
+
+// DatabaseMetrics 数据库运行指标
+type DatabaseMetrics struct {
+	Degraded  bool          // 连续出现连接级错误，正在重新连接
+	ErrorRate float64       // 最近查询的失败比例
+	Latency   time.Duration // 最近查询的平均耗时
+	Errors    int64         // 启动以来的失败次数
+	LastError string        // 最近一次错误
+	Reopens   int64         // 重新打开数据库的次数
+}
+
 // DatabaseHealthCheck 数据库健康检查
 type DatabaseHealthCheck struct {
-	name string
-	ping func() error
+	name    string
+	ping    func() error
+	metrics func() DatabaseMetrics
 }
 
 // NewDatabaseHealthCheck 创建数据库健康检查
@@ -247,6 +264,12 @@ func NewDatabaseHealthCheck(name string, ping func() error) *DatabaseHealthCheck
 	}
 }
 
+// WithMetrics 附加数据库运行指标，处于降级状态或最近的错误率、耗时过高时报告降级
+func (d *DatabaseHealthCheck) WithMetrics(metrics func() DatabaseMetrics) *DatabaseHealthCheck {
+	d.metrics = metrics
+	return d
+}
+
 func (d *DatabaseHealthCheck) Name() string {
 	return d.name
 }
@@ -265,6 +288,33 @@ func (d *DatabaseHealthCheck) Check(ctx context.Context) HealthCheckResult {
 		result.Status = StatusHealthy
 		result.Message = "Database is accessible"
 	}
+
+	if d.metrics != nil {
+		metrics := d.metrics()
+		result.Details = map[string]interface{}{
+			"degraded":   metrics.Degraded,
+			"error_rate": metrics.ErrorRate,
+			"latency_ms": float64(metrics.Latency) / float64(time.Millisecond),
+			"errors":     metrics.Errors,
+			"reopens":    metrics.Reopens,
+		}
+		if metrics.LastError != "" {
+			result.Details["last_error"] = metrics.LastError
+		}
+		if result.Status == StatusHealthy {
+			switch {
+			case metrics.Degraded:
+				result.Status = StatusDegraded
+				result.Message = "Database is reconnecting after repeated errors"
+			case metrics.ErrorRate > databaseErrorRateThreshold:
+				result.Status = StatusDegraded
+				result.Message = fmt.Sprintf("Database error rate %.1f%% is high", metrics.ErrorRate*100)
+			case metrics.Latency > databaseLatencyThreshold:
+				result.Status = StatusDegraded
+				result.Message = fmt.Sprintf("Database latency %v is high", metrics.Latency)
+			}
+		}
+	}
 	
 	result.Duration = time.Since(start)
 	return result
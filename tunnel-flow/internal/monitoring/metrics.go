@@ -9,6 +9,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"tunnel-flow/internal/performance"
 )
 
 // Metrics 监控指标
@@ -23,6 +25,9 @@ type Metrics struct {
 	MessagesReceived     int64 `json:"messages_received"`
 	MessagesPerSecond    int64 `json:"messages_per_second"`
 	MessageErrors        int64 `json:"message_errors"`
+	DuplicateResponses   int64 `json:"duplicate_responses"` // 丢弃的重复响应数
+	ExpiredMessages      int64 `json:"expired_messages"`    // 超过期限仍没有响应、被标记为失败的待处理消息数
+	DeadLetters          int64 `json:"dead_letters"`        // 重新投递次数用完、移入死信队列的消息数
 	
 	// 性能指标
 	AverageResponseTime  int64 `json:"average_response_time_ms"`
@@ -44,10 +49,90 @@ type Metrics struct {
 	QueueCapacity        int64 `json:"queue_capacity"`
 	QueueUtilization     float64 `json:"queue_utilization_percent"`
 	
+	// 工作池指标
+	WorkerCount          int64 `json:"worker_count"`        // 当前工作协程数
+	BusyWorkers          int64 `json:"busy_workers"`        // 正在执行任务的工作协程数
+	WorkerQueueLength    int64 `json:"worker_queue_length"` // 等待执行的任务数
+	WorkerScaleUps       int64 `json:"worker_scale_ups"`
+	WorkerScaleDowns     int64 `json:"worker_scale_downs"`
+	
+	// 代理请求指标
+	Proxy                ProxyCounters `json:"proxy"`
+	
 	// 时间戳
 	Timestamp            time.Time `json:"timestamp"`
 }
 
+// 代理请求失败的原因，正常返回响应的请求为空
+const (
+	ProxyFailureTimeout   = "timeout"    // 等待客户端响应超时
+	ProxyFailureNoBackend = "no_backend" // 没有在线的可用客户端
+)
+
+// ProxyCounters 代理请求按状态码类别和失败原因的计数，超时和没有可用后端同时计入对应的5xx
+type ProxyCounters struct {
+	Requests  int64 `json:"requests"`
+	Status2xx int64 `json:"status_2xx"`
+	Status3xx int64 `json:"status_3xx"`
+	Status4xx int64 `json:"status_4xx"`
+	Status5xx int64 `json:"status_5xx"`
+	Timeouts  int64 `json:"timeouts"`
+	NoBackend int64 `json:"no_backend"`
+}
+
+// record 按状态码和失败原因累加计数
+func (c *ProxyCounters) record(status int, failure string) {
+	atomic.AddInt64(&c.Requests, 1)
+	switch {
+	case status >= 500:
+		atomic.AddInt64(&c.Status5xx, 1)
+	case status >= 400:
+		atomic.AddInt64(&c.Status4xx, 1)
+	case status >= 300:
+		atomic.AddInt64(&c.Status3xx, 1)
+	case status >= 200:
+		atomic.AddInt64(&c.Status2xx, 1)
+	}
+	switch failure {
+	case ProxyFailureTimeout:
+		atomic.AddInt64(&c.Timeouts, 1)
+	case ProxyFailureNoBackend:
+		atomic.AddInt64(&c.NoBackend, 1)
+	}
+}
+
+// snapshot 返回计数的副本
+func (c *ProxyCounters) snapshot() ProxyCounters {
+	return ProxyCounters{
+		Requests:  atomic.LoadInt64(&c.Requests),
+		Status2xx: atomic.LoadInt64(&c.Status2xx),
+		Status3xx: atomic.LoadInt64(&c.Status3xx),
+		Status4xx: atomic.LoadInt64(&c.Status4xx),
+		Status5xx: atomic.LoadInt64(&c.Status5xx),
+		Timeouts:  atomic.LoadInt64(&c.Timeouts),
+		NoBackend: atomic.LoadInt64(&c.NoBackend),
+	}
+}
+
+// Add 累加另一组计数
+func (c *ProxyCounters) Add(other ProxyCounters) {
+	c.Requests += other.Requests
+	c.Status2xx += other.Status2xx
+	c.Status3xx += other.Status3xx
+	c.Status4xx += other.Status4xx
+	c.Status5xx += other.Status5xx
+	c.Timeouts += other.Timeouts
+	c.NoBackend += other.NoBackend
+}
+
+// ErrorRate 5xx响应占请求数的比例，0-1
+func (c ProxyCounters) ErrorRate() float64 {
+	if c.Requests == 0 {
+		return 0
+	}
+	return float64(c.Status5xx) / float64(c.Requests)
+}
+
 // MetricsCollector 指标收集器
 type MetricsCollector struct {
 	metrics     *Metrics
@@ -63,6 +148,13 @@ type MetricsCollector struct {
 	responseTimes []int64
 	responseTimeMu sync.Mutex
 
+	// 工作池，收集指标时读取其统计信息
+	workerPool  *performance.WorkerPool
+
+	// 按路由ID区分的代理请求计数
+	routeCounters   map[int]*ProxyCounters
+	routeCountersMu sync.RWMutex
+
 	// 用于控制goroutine生命周期
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -82,6 +174,7 @@ func NewMetricsCollector() *MetricsCollector {
 		maxHistory: 100,
 		history:    make([]Metrics, 0, 100),
 		responseTimes: make([]int64, 0, 1000),
+		routeCounters: make(map[int]*ProxyCounters),
 		ctx:        ctx,
 		cancel:     cancel,
 	}
@@ -114,6 +207,21 @@ func (mc *MetricsCollector) IncrementErrors() {
 	atomic.AddInt64(&mc.metrics.MessageErrors, 1)
 }
 
+// IncrementDuplicateResponses 增加丢弃的重复响应数
+func (mc *MetricsCollector) IncrementDuplicateResponses() {
+	atomic.AddInt64(&mc.metrics.DuplicateResponses, 1)
+}
+
+// IncrementExpiredMessages 增加超过期限被标记为失败的待处理消息数
+func (mc *MetricsCollector) IncrementExpiredMessages() {
+	atomic.AddInt64(&mc.metrics.ExpiredMessages, 1)
+}
+
+// IncrementDeadLetters 增加移入死信队列的消息数
+func (mc *MetricsCollector) IncrementDeadLetters() {
+	atomic.AddInt64(&mc.metrics.DeadLetters, 1)
+}
+
 // IncrementRetries 增加重试数
 func (mc *MetricsCollector) IncrementRetries() {
 	atomic.AddInt64(&mc.metrics.RetryCount, 1)
@@ -140,6 +248,48 @@ func (mc *MetricsCollector) RecordResponseTime(duration time.Duration) {
 	}
 }
 
+// RecordProxyRequest 记录一次代理请求的结果，routeID 为0时（没有匹配的路由）只计入全局计数
+// status 为返回给调用方的状态码，failure 为 ProxyFailureTimeout、ProxyFailureNoBackend 或空
+func (mc *MetricsCollector) RecordProxyRequest(routeID int, status int, failure string) {
+	if mc == nil {
+		return
+	}
+	mc.mu.RLock()
+	mc.metrics.Proxy.record(status, failure)
+	mc.mu.RUnlock()
+	if routeID == 0 {
+		return
+	}
+
+	mc.routeCountersMu.RLock()
+	counters, ok := mc.routeCounters[routeID]
+	mc.routeCountersMu.RUnlock()
+	if !ok {
+		mc.routeCountersMu.Lock()
+		if counters, ok = mc.routeCounters[routeID]; !ok {
+			counters = &ProxyCounters{}
+			mc.routeCounters[routeID] = counters
+		}
+		mc.routeCountersMu.Unlock()
+	}
+	counters.record(status, failure)
+}
+
+// RouteProxyCounters 返回各路由的代理请求计数，按路由ID索引
+func (mc *MetricsCollector) RouteProxyCounters() map[int]ProxyCounters {
+	if mc == nil {
+		return map[int]ProxyCounters{}
+	}
+	mc.routeCountersMu.RLock()
+	defer mc.routeCountersMu.RUnlock()
+
+	result := make(map[int]ProxyCounters, len(mc.routeCounters))
+	for routeID, counters := range mc.routeCounters {
+		result[routeID] = counters.snapshot()
+	}
+	return result
+}
+
 // UpdateQueueMetrics 更新队列指标
 func (mc *MetricsCollector) UpdateQueueMetrics(size, capacity int64) {
 	atomic.StoreInt64(&mc.metrics.QueueSize, size)
@@ -153,6 +303,11 @@ func (mc *MetricsCollector) UpdateQueueMetrics(size, capacity int64) {
 	}
 }
 
+// TrackWorkerPool 设置要收集指标的工作池，需在开始收集之前调用
+func (mc *MetricsCollector) TrackWorkerPool(pool *performance.WorkerPool) {
+	mc.workerPool = pool
+}
+
 // UpdateSystemMetrics 更新系统指标
 func (mc *MetricsCollector) UpdateSystemMetrics() {
 	var m runtime.MemStats
@@ -163,6 +318,15 @@ func (mc *MetricsCollector) UpdateSystemMetrics() {
 	mc.mu.Lock()
 	mc.metrics.GoroutineCount = runtime.NumGoroutine()
 	mc.mu.Unlock()
+
+	if mc.workerPool != nil {
+		stats := mc.workerPool.GetStats()
+		atomic.StoreInt64(&mc.metrics.WorkerCount, int64(stats.ActiveWorkers))
+		atomic.StoreInt64(&mc.metrics.BusyWorkers, int64(stats.BusyWorkers))
+		atomic.StoreInt64(&mc.metrics.WorkerQueueLength, int64(stats.QueueLength))
+		atomic.StoreInt64(&mc.metrics.WorkerScaleUps, stats.ScaleUps)
+		atomic.StoreInt64(&mc.metrics.WorkerScaleDowns, stats.ScaleDowns)
+	}
 }
 
 // CalculateRates 计算速率指标
@@ -198,7 +362,9 @@ func (mc *MetricsCollector) GetMetrics() Metrics {
 	mc.mu.RLock()
 	defer mc.mu.RUnlock()
 	
-	return *mc.metrics
+	metrics := *mc.metrics
+	metrics.Proxy = mc.metrics.Proxy.snapshot()
+	return metrics
 }
 
 // GetHistory 获取历史指标
@@ -234,6 +400,10 @@ func (mc *MetricsCollector) Reset() {
 		Timestamp:       time.Now(),
 	}
 	mc.responseTimes = mc.responseTimes[:0]
+
+	mc.routeCountersMu.Lock()
+	mc.routeCounters = make(map[int]*ProxyCounters)
+	mc.routeCountersMu.Unlock()
 }
 
 // HTTPHandler 提供HTTP接口
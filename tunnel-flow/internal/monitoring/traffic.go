@@ -0,0 +1,373 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// trafficRetentionMinutes 流量统计保留的分钟数（24小时）
+const trafficRetentionMinutes = 24 * 60
+
+// TrafficRecord 一次代理请求的流量记录
+type TrafficRecord struct {
+	OrgID     int // 请求所属组织
+	RouteID   int
+	URLSuffix string
+	ClientID  string
+	Status    int
+	Latency   time.Duration
+	BytesIn   int64 // 请求体大小
+	BytesOut  int64 // 响应体大小
+}
+
+// RouteTraffic 单个路由的流量汇总
+type RouteTraffic struct {
+	RouteID      int     `json:"route_id"`
+	URLSuffix    string  `json:"url_suffix"`
+	Requests     int64   `json:"requests"`
+	Errors       int64   `json:"errors"`
+	BytesIn      int64   `json:"bytes_in"`
+	BytesOut     int64   `json:"bytes_out"`
+	AvgLatencyMS float64 `json:"avg_latency_ms"`
+	latencySum   int64
+}
+
+// ClientTraffic 单个客户端的流量汇总
+type ClientTraffic struct {
+	ClientID   string `json:"client_id"`
+	Requests   int64  `json:"requests"`
+	Errors     int64  `json:"errors"`
+	BytesIn    int64  `json:"bytes_in"`
+	BytesOut   int64  `json:"bytes_out"`
+	TotalBytes int64  `json:"total_bytes"`
+}
+
+// TrafficOverview 指定时间窗口内的流量概览
+type TrafficOverview struct {
+	WindowSeconds     int64            `json:"window_seconds"`
+	Requests          int64            `json:"requests"`
+	Errors            int64            `json:"errors"`
+	RequestsPerSecond float64          `json:"requests_per_second"`
+	ErrorRate         float64          `json:"error_rate"` // 0-1，状态码>=500视为错误
+	AvgLatencyMS      float64          `json:"avg_latency_ms"`
+	BytesIn           int64            `json:"bytes_in"`
+	BytesOut          int64            `json:"bytes_out"`
+	TopRoutes         []*RouteTraffic  `json:"top_routes"`
+	TopClients        []*ClientTraffic `json:"top_clients"`
+}
+
+// HourlyTraffic 按小时、客户端和路由累计的流量，用于持久化计费数据
+type HourlyTraffic struct {
+	HourTS    int64 // 小时起始时间（毫秒）
+	OrgID     int
+	ClientID  string
+	RouteID   int
+	URLSuffix string
+	Requests  int64
+	Errors    int64
+	BytesIn   int64
+	BytesOut  int64
+}
+
+// hourlyKey 小时汇总的键
+type hourlyKey struct {
+	hourTS   int64
+	clientID string
+	routeID  int
+}
+
+// trafficBucket 一分钟内的流量统计，按组织分别累计
+type trafficBucket struct {
+	minute int64
+	orgs   map[int]*trafficCounters
+}
+
+// trafficCounters 一个组织在一分钟内的流量计数
+type trafficCounters struct {
+	requests   int64
+	errors     int64
+	latencySum int64
+	bytesIn    int64
+	bytesOut   int64
+	routes     map[int]*RouteTraffic
+	clients    map[string]*ClientTraffic
+}
+
+// TrafficStats 按分钟聚合的代理流量统计，保存在内存中，保留最近24小时
+type TrafficStats struct {
+	mu        sync.Mutex
+	buckets   [trafficRetentionMinutes]*trafficBucket
+	hourly    map[hourlyKey]*HourlyTraffic // 尚未持久化的小时汇总增量
+	startTime time.Time
+	now       func() time.Time
+}
+
+// NewTrafficStats 创建流量统计
+func NewTrafficStats() *TrafficStats {
+	return &TrafficStats{
+		hourly:    make(map[hourlyKey]*HourlyTraffic),
+		startTime: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Record 记录一次代理请求
+func (t *TrafficStats) Record(rec TrafficRecord) {
+	if t == nil {
+		return
+	}
+
+	now := t.now()
+	minute := now.Unix() / 60
+	isError := rec.Status >= 500
+	latencyMS := rec.Latency.Milliseconds()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.bucketFor(minute).countersFor(rec.OrgID)
+	bucket.requests++
+	bucket.latencySum += latencyMS
+	bucket.bytesIn += rec.BytesIn
+	bucket.bytesOut += rec.BytesOut
+	if isError {
+		bucket.errors++
+	}
+
+	if rec.RouteID > 0 {
+		route, exists := bucket.routes[rec.RouteID]
+		if !exists {
+			route = &RouteTraffic{RouteID: rec.RouteID}
+			bucket.routes[rec.RouteID] = route
+		}
+		route.URLSuffix = rec.URLSuffix
+		route.Requests++
+		route.latencySum += latencyMS
+		route.BytesIn += rec.BytesIn
+		route.BytesOut += rec.BytesOut
+		if isError {
+			route.Errors++
+		}
+	}
+
+	if rec.ClientID != "" {
+		client, exists := bucket.clients[rec.ClientID]
+		if !exists {
+			client = &ClientTraffic{ClientID: rec.ClientID}
+			bucket.clients[rec.ClientID] = client
+		}
+		client.Requests++
+		client.BytesIn += rec.BytesIn
+		client.BytesOut += rec.BytesOut
+		if isError {
+			client.Errors++
+		}
+	}
+
+	key := hourlyKey{
+		hourTS:   now.Truncate(time.Hour).UnixMilli(),
+		clientID: rec.ClientID,
+		routeID:  rec.RouteID,
+	}
+	hourly, exists := t.hourly[key]
+	if !exists {
+		hourly = &HourlyTraffic{HourTS: key.hourTS, OrgID: rec.OrgID, ClientID: key.clientID, RouteID: key.routeID}
+		t.hourly[key] = hourly
+	}
+	hourly.URLSuffix = rec.URLSuffix
+	hourly.Requests++
+	hourly.BytesIn += rec.BytesIn
+	hourly.BytesOut += rec.BytesOut
+	if isError {
+		hourly.Errors++
+	}
+}
+
+// DrainHourly 取出并清空尚未持久化的小时汇总增量
+func (t *TrafficStats) DrainHourly() []HourlyTraffic {
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]HourlyTraffic, 0, len(t.hourly))
+	for _, hourly := range t.hourly {
+		result = append(result, *hourly)
+	}
+	t.hourly = make(map[hourlyKey]*HourlyTraffic)
+	return result
+}
+
+// RestoreHourly 持久化失败时把增量放回，等待下次重试
+func (t *TrafficStats) RestoreHourly(items []HourlyTraffic) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, item := range items {
+		key := hourlyKey{hourTS: item.HourTS, clientID: item.ClientID, routeID: item.RouteID}
+		hourly, exists := t.hourly[key]
+		if !exists {
+			copied := item
+			t.hourly[key] = &copied
+			continue
+		}
+		hourly.Requests += item.Requests
+		hourly.Errors += item.Errors
+		hourly.BytesIn += item.BytesIn
+		hourly.BytesOut += item.BytesOut
+	}
+}
+
+// bucketFor 获取指定分钟的桶，槽位被旧数据占用时重置，调用方需持有锁
+func (t *TrafficStats) bucketFor(minute int64) *trafficBucket {
+	index := minute % trafficRetentionMinutes
+	bucket := t.buckets[index]
+	if bucket == nil || bucket.minute != minute {
+		bucket = &trafficBucket{
+			minute: minute,
+			orgs:   make(map[int]*trafficCounters),
+		}
+		t.buckets[index] = bucket
+	}
+	return bucket
+}
+
+// countersFor 获取组织的计数，不存在时创建，调用方需持有锁
+func (b *trafficBucket) countersFor(orgID int) *trafficCounters {
+	counters, exists := b.orgs[orgID]
+	if !exists {
+		counters = &trafficCounters{
+			routes:  make(map[int]*RouteTraffic),
+			clients: make(map[string]*ClientTraffic),
+		}
+		b.orgs[orgID] = counters
+	}
+	return counters
+}
+
+// Overview 汇总最近 window 时间内的流量，topN 限制路由和客户端排行的数量
+// orgID 为0时汇总所有组织
+func (t *TrafficStats) Overview(orgID int, window time.Duration, topN int) *TrafficOverview {
+	overview := &TrafficOverview{
+		WindowSeconds: int64(window.Seconds()),
+		TopRoutes:     []*RouteTraffic{},
+		TopClients:    []*ClientTraffic{},
+	}
+	if t == nil {
+		return overview
+	}
+
+	now := t.now()
+	windowMinutes := int64(window.Minutes())
+	if windowMinutes < 1 {
+		windowMinutes = 1
+	}
+	if windowMinutes > trafficRetentionMinutes {
+		windowMinutes = trafficRetentionMinutes
+	}
+	oldest := now.Unix()/60 - windowMinutes + 1
+
+	routes := make(map[int]*RouteTraffic)
+	clients := make(map[string]*ClientTraffic)
+	var latencySum int64
+
+	t.mu.Lock()
+	for _, minuteBucket := range t.buckets {
+		if minuteBucket == nil || minuteBucket.minute < oldest {
+			continue
+		}
+		for bucketOrgID, bucket := range minuteBucket.orgs {
+			if orgID > 0 && bucketOrgID != orgID {
+				continue
+			}
+			overview.Requests += bucket.requests
+			overview.Errors += bucket.errors
+			overview.BytesIn += bucket.bytesIn
+			overview.BytesOut += bucket.bytesOut
+			latencySum += bucket.latencySum
+
+			for id, route := range bucket.routes {
+				total, exists := routes[id]
+				if !exists {
+					total = &RouteTraffic{RouteID: id}
+					routes[id] = total
+				}
+				total.URLSuffix = route.URLSuffix
+				total.Requests += route.Requests
+				total.Errors += route.Errors
+				total.BytesIn += route.BytesIn
+				total.BytesOut += route.BytesOut
+				total.latencySum += route.latencySum
+			}
+			for id, client := range bucket.clients {
+				total, exists := clients[id]
+				if !exists {
+					total = &ClientTraffic{ClientID: id}
+					clients[id] = total
+				}
+				total.Requests += client.Requests
+				total.Errors += client.Errors
+				total.BytesIn += client.BytesIn
+				total.BytesOut += client.BytesOut
+			}
+		}
+	}
+	t.mu.Unlock()
+
+	// 服务启动不足一个窗口时按实际运行时长计算速率
+	elapsed := window
+	if uptime := now.Sub(t.startTime); uptime < elapsed {
+		elapsed = uptime
+	}
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	overview.RequestsPerSecond = float64(overview.Requests) / elapsed.Seconds()
+	if overview.Requests > 0 {
+		overview.ErrorRate = float64(overview.Errors) / float64(overview.Requests)
+		overview.AvgLatencyMS = float64(latencySum) / float64(overview.Requests)
+	}
+
+	for _, route := range routes {
+		if route.Requests > 0 {
+			route.AvgLatencyMS = float64(route.latencySum) / float64(route.Requests)
+		}
+		overview.TopRoutes = append(overview.TopRoutes, route)
+	}
+	sort.Slice(overview.TopRoutes, func(i, j int) bool {
+		a, b := overview.TopRoutes[i], overview.TopRoutes[j]
+		if a.Requests != b.Requests {
+			return a.Requests > b.Requests
+		}
+		return a.RouteID < b.RouteID
+	})
+
+	for _, client := range clients {
+		client.TotalBytes = client.BytesIn + client.BytesOut
+		overview.TopClients = append(overview.TopClients, client)
+	}
+	sort.Slice(overview.TopClients, func(i, j int) bool {
+		a, b := overview.TopClients[i], overview.TopClients[j]
+		if a.TotalBytes != b.TotalBytes {
+			return a.TotalBytes > b.TotalBytes
+		}
+		return a.ClientID < b.ClientID
+	})
+
+	if topN > 0 {
+		if len(overview.TopRoutes) > topN {
+			overview.TopRoutes = overview.TopRoutes[:topN]
+		}
+		if len(overview.TopClients) > topN {
+			overview.TopClients = overview.TopClients[:topN]
+		}
+	}
+	return overview
+}
@@ -128,21 +128,52 @@ func (p *ObjectPool) PutResponse(resp *PooledResponse) {
 }
 
 // WorkerPool 工作池，用于并发处理任务
+// 工作协程数在 minWorkers 和 maxWorkers 之间自动调整：队列积压或所有协程都忙且任务变慢时扩容，
+// 持续空闲 scaleDownAfter 后每个检查周期减少一个协程，扩容后同样要重新空闲满这段时间才会缩容
+// 每个优先级有独立的任务队列，工作协程总是先执行优先级高的任务，负载高时低优先级任务会一直等待
 type WorkerPool struct {
-	workers    int
-	taskQueue  chan Task
-	resultChan chan TaskResult
-	ctx        context.Context
-	cancel     context.CancelFunc
-	wg         sync.WaitGroup
-	stats      *WorkerStats
+	minWorkers       int
+	maxWorkers       int
+	latencyThreshold time.Duration
+	queues           [PriorityCritical + 1]chan Task // 按 Priority 索引
+	resultChan       chan TaskResult
+	retire           chan struct{}
+	ctx              context.Context
+	cancel           context.CancelFunc
+	wg               sync.WaitGroup
+	stats            *WorkerStats
+	scaleMu          sync.Mutex
+	nextID           int
+	idleSince        time.Time
+	startOnce        sync.Once
+	stopOnce         sync.Once
+
+	// OnScale 工作协程数变化后调用，用于记录日志，需在 Start 之前设置
+	OnScale func(from, to int, reason string)
 }
 
+// WorkerPoolConfig 工作池配置
+type WorkerPoolConfig struct {
+	MinWorkers       int           // 最少工作协程数，默认CPU核数
+	MaxWorkers       int           // 最多工作协程数，不大于 MinWorkers 时固定为 MinWorkers
+	QueueSize        int           // 每个优先级的任务队列长度，默认 MaxWorkers*10
+	LatencyThreshold time.Duration // 所有协程都忙且任务平均耗时超过该值时扩容，为0时只按队列长度扩容
+}
+
+const (
+	// scaleInterval 检查是否需要扩缩容的间隔
+	scaleInterval = time.Second
+	// scaleDownAfter 持续空闲多久后开始缩容
+	scaleDownAfter = 30 * time.Second
+	// submitWait 队列已满时扩容后等待任务入队的时间
+	submitWait = 50 * time.Millisecond
+)
+
 // Task 任务接口
 type Task interface {
 	Execute() TaskResult
 	GetID() string
-	GetPriority() int
+	GetPriority() int // 取值为 Priority，越大越先执行
 }
 
 // TaskResult 任务结果
@@ -160,65 +191,179 @@ type WorkerStats struct {
 	TotalTasks     int64
 	CompletedTasks int64
 	FailedTasks    int64
-	ActiveWorkers  int32
+	ActiveWorkers  int32 // 当前工作协程数
+	BusyWorkers    int32 // 正在执行任务的工作协程数
+	MinWorkers     int32
+	MaxWorkers     int32
 	QueueLength    int32
 	AverageLatency time.Duration
+	RecentLatency  time.Duration // 最近任务耗时的指数移动平均，用于判断是否扩容
+	ScaleUps       int64
+	ScaleDowns     int64
 	mu             sync.RWMutex
 }
 
-// NewWorkerPool 创建新的工作池
+// NewWorkerPool 创建固定工作协程数的工作池
 func NewWorkerPool(workers int, queueSize int) *WorkerPool {
-	if workers <= 0 {
-		workers = runtime.NumCPU()
+	return NewWorkerPoolWithConfig(&WorkerPoolConfig{
+		MinWorkers: workers,
+		MaxWorkers: workers,
+		QueueSize:  queueSize,
+	})
+}
+
+// NewWorkerPoolWithConfig 创建按负载自动扩缩容的工作池
+func NewWorkerPoolWithConfig(config *WorkerPoolConfig) *WorkerPool {
+	minWorkers := config.MinWorkers
+	if minWorkers <= 0 {
+		minWorkers = runtime.NumCPU()
+	}
+	maxWorkers := config.MaxWorkers
+	if maxWorkers < minWorkers {
+		maxWorkers = minWorkers
 	}
+	queueSize := config.QueueSize
 	if queueSize <= 0 {
-		queueSize = workers * 10
+		queueSize = maxWorkers * 10
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
-	
-	return &WorkerPool{
-		workers:    workers,
-		taskQueue:  make(chan Task, queueSize),
-		resultChan: make(chan TaskResult, queueSize),
-		ctx:        ctx,
-		cancel:     cancel,
-		stats:      &WorkerStats{},
+
+	wp := &WorkerPool{
+		minWorkers:       minWorkers,
+		maxWorkers:       maxWorkers,
+		latencyThreshold: config.LatencyThreshold,
+		resultChan:       make(chan TaskResult, queueSize),
+		retire:           make(chan struct{}),
+		ctx:              ctx,
+		cancel:           cancel,
+		stats: &WorkerStats{
+			MinWorkers: int32(minWorkers),
+			MaxWorkers: int32(maxWorkers),
+		},
 	}
+	for i := range wp.queues {
+		wp.queues[i] = make(chan Task, queueSize)
+	}
+	return wp
 }
 
-// Start 启动工作池
+// Start 启动工作池，可重复调用
 func (wp *WorkerPool) Start() {
-	for i := 0; i < wp.workers; i++ {
-		wp.wg.Add(1)
-		go wp.worker(i)
-	}
+	wp.startOnce.Do(func() {
+		wp.scaleMu.Lock()
+		for i := 0; i < wp.minWorkers; i++ {
+			wp.spawnWorker()
+		}
+		wp.idleSince = time.Now()
+		wp.scaleMu.Unlock()
+
+		if wp.maxWorkers > wp.minWorkers {
+			go wp.autoscale()
+		}
+	})
 }
 
-// Stop 停止工作池
+// Stop 停止工作池，可重复调用
 func (wp *WorkerPool) Stop() {
-	wp.cancel()
-	close(wp.taskQueue)
-	wp.wg.Wait()
-	close(wp.resultChan)
+	wp.stopOnce.Do(func() {
+		wp.cancel()
+		// 等待进行中的扩容结束，之后不会再启动新的工作协程
+		wp.scaleMu.Lock()
+		wp.scaleMu.Unlock()
+		for _, queue := range wp.queues {
+			close(queue)
+		}
+		wp.wg.Wait()
+		close(wp.resultChan)
+	})
 }
 
-// Submit 提交任务
+// Submit 按任务优先级提交任务，队列已满时先尝试扩容并短暂等待，仍放不进去时返回 ErrQueueFull
 func (wp *WorkerPool) Submit(task Task) error {
+	queue := wp.queueFor(task.GetPriority())
 	select {
-	case wp.taskQueue <- task:
-		wp.stats.mu.Lock()
-		wp.stats.TotalTasks++
-		wp.stats.QueueLength = int32(len(wp.taskQueue))
-		wp.stats.mu.Unlock()
+	case queue <- task:
+		wp.recordSubmit()
 		return nil
 	case <-wp.ctx.Done():
 		return wp.ctx.Err()
 	default:
+	}
+
+	if !wp.scaleUp("queue full") {
+		return ErrQueueFull
+	}
+	timer := time.NewTimer(submitWait)
+	defer timer.Stop()
+	select {
+	case queue <- task:
+		wp.recordSubmit()
+		return nil
+	case <-wp.ctx.Done():
+		return wp.ctx.Err()
+	case <-timer.C:
 		return ErrQueueFull
 	}
 }
 
+// recordSubmit 记录入队的任务
+func (wp *WorkerPool) recordSubmit() {
+	wp.stats.mu.Lock()
+	wp.stats.TotalTasks++
+	wp.stats.QueueLength = int32(wp.queuedTasks())
+	wp.stats.mu.Unlock()
+}
+
+// queueFor 返回优先级对应的任务队列，超出范围的优先级按最低或最高处理
+func (wp *WorkerPool) queueFor(priority int) chan Task {
+	switch {
+	case priority < int(PriorityLow):
+		priority = int(PriorityLow)
+	case priority > int(PriorityCritical):
+		priority = int(PriorityCritical)
+	}
+	return wp.queues[priority]
+}
+
+// queuedTasks 返回所有优先级等待执行的任务数
+func (wp *WorkerPool) queuedTasks() int {
+	total := 0
+	for _, queue := range wp.queues {
+		total += len(queue)
+	}
+	return total
+}
+
+// nextTask 按优先级从高到低取出任务，都没有任务时等待，返回false表示工作协程应退出
+func (wp *WorkerPool) nextTask() (Task, bool) {
+	if wp.ctx.Err() != nil {
+		return nil, false
+	}
+	for priority := len(wp.queues) - 1; priority >= 0; priority-- {
+		select {
+		case task, ok := <-wp.queues[priority]:
+			return task, ok
+		default:
+		}
+	}
+
+	select {
+	case task, ok := <-wp.queues[PriorityCritical]:
+		return task, ok
+	case task, ok := <-wp.queues[PriorityHigh]:
+		return task, ok
+	case task, ok := <-wp.queues[PriorityNormal]:
+		return task, ok
+	case task, ok := <-wp.queues[PriorityLow]:
+		return task, ok
+	case <-wp.retire:
+		return nil, false
+	case <-wp.ctx.Done():
+		return nil, false
+	}
+}
+
 // GetResults 获取结果通道
 func (wp *WorkerPool) GetResults() <-chan TaskResult {
 	return wp.resultChan
@@ -228,17 +373,126 @@ func (wp *WorkerPool) GetResults() <-chan TaskResult {
 func (wp *WorkerPool) GetStats() WorkerStats {
 	wp.stats.mu.RLock()
 	defer wp.stats.mu.RUnlock()
-	return *wp.stats
+	return WorkerStats{
+		TotalTasks:     wp.stats.TotalTasks,
+		CompletedTasks: wp.stats.CompletedTasks,
+		FailedTasks:    wp.stats.FailedTasks,
+		ActiveWorkers:  wp.stats.ActiveWorkers,
+		BusyWorkers:    wp.stats.BusyWorkers,
+		MinWorkers:     wp.stats.MinWorkers,
+		MaxWorkers:     wp.stats.MaxWorkers,
+		QueueLength:    int32(wp.queuedTasks()),
+		AverageLatency: wp.stats.AverageLatency,
+		RecentLatency:  wp.stats.RecentLatency,
+		ScaleUps:       wp.stats.ScaleUps,
+		ScaleDowns:     wp.stats.ScaleDowns,
+	}
 }
 
-// worker 工作协程
-func (wp *WorkerPool) worker(id int) {
-	defer wp.wg.Done()
-	
+// autoscale 定期按队列长度和任务耗时调整工作协程数
+func (wp *WorkerPool) autoscale() {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			wp.rescale()
+		case <-wp.ctx.Done():
+			return
+		}
+	}
+}
+
+// rescale 检查一次负载：积压的任务多于工作协程数，或所有协程都忙且任务变慢时扩容；
+// 队列为空且不超过一半的协程在忙时视为空闲，持续空闲 scaleDownAfter 后减少一个协程
+func (wp *WorkerPool) rescale() {
+	stats := wp.GetStats()
+	queued := int(stats.QueueLength)
+	workers := int(stats.ActiveWorkers)
+	busy := int(stats.BusyWorkers)
+
+	switch {
+	case queued > workers:
+		wp.scaleUp(fmt.Sprintf("%d tasks queued", queued))
+		return
+	case wp.latencyThreshold > 0 && busy >= workers && stats.RecentLatency > wp.latencyThreshold:
+		wp.scaleUp(fmt.Sprintf("all workers busy, recent latency %v", stats.RecentLatency))
+		return
+	}
+
+	wp.scaleMu.Lock()
+	defer wp.scaleMu.Unlock()
+	if queued > 0 || busy*2 > workers {
+		wp.idleSince = time.Now()
+		return
+	}
+	if workers <= wp.minWorkers || time.Since(wp.idleSince) < scaleDownAfter {
+		return
+	}
+	// 只有空闲的协程会收到退出信号，都在忙时放弃本次缩容
+	select {
+	case wp.retire <- struct{}{}:
+		wp.stats.mu.Lock()
+		wp.stats.ScaleDowns++
+		wp.stats.mu.Unlock()
+		wp.notifyScale(workers, workers-1, "idle")
+	default:
+	}
+}
+
+// scaleUp 把工作协程数增加一半（至少一个），已达到上限或工作池已停止时返回false
+func (wp *WorkerPool) scaleUp(reason string) bool {
+	wp.scaleMu.Lock()
+	defer wp.scaleMu.Unlock()
+	if wp.ctx.Err() != nil {
+		return false
+	}
+
+	wp.stats.mu.RLock()
+	workers := int(wp.stats.ActiveWorkers)
+	wp.stats.mu.RUnlock()
+	if workers >= wp.maxWorkers {
+		return false
+	}
+	target := workers + (workers+1)/2
+	if target > wp.maxWorkers {
+		target = wp.maxWorkers
+	}
+	for i := workers; i < target; i++ {
+		wp.spawnWorker()
+	}
+	wp.idleSince = time.Now()
+
+	wp.stats.mu.Lock()
+	wp.stats.ScaleUps++
+	wp.stats.mu.Unlock()
+	wp.notifyScale(workers, target, reason)
+	return true
+}
+
+// spawnWorker 启动一个工作协程，调用方需持有 scaleMu
+func (wp *WorkerPool) spawnWorker() {
 	wp.stats.mu.Lock()
 	wp.stats.ActiveWorkers++
 	wp.stats.mu.Unlock()
-	
+
+	wp.nextID++
+	wp.wg.Add(1)
+	go wp.worker(wp.nextID)
+}
+
+// notifyScale 通知工作协程数的变化
+func (wp *WorkerPool) notifyScale(from, to int, reason string) {
+	if wp.OnScale != nil {
+		wp.OnScale(from, to, reason)
+	}
+}
+
+// worker 工作协程，收到退出信号或工作池停止时退出
+func (wp *WorkerPool) worker(id int) {
+	defer wp.wg.Done()
+
 	defer func() {
 		wp.stats.mu.Lock()
 		wp.stats.ActiveWorkers--
@@ -246,45 +500,51 @@ func (wp *WorkerPool) worker(id int) {
 	}()
 
 	for {
+		task, ok := wp.nextTask()
+		if !ok {
+			return
+		}
+
+		wp.stats.mu.Lock()
+		wp.stats.BusyWorkers++
+		wp.stats.mu.Unlock()
+
+		start := time.Now()
+		result := task.Execute()
+		result.Duration = time.Since(start)
+		result.Timestamp = time.Now()
+
+		// 更新统计信息
+		wp.stats.mu.Lock()
+		wp.stats.BusyWorkers--
+		wp.stats.QueueLength = int32(wp.queuedTasks())
+		if result.Success {
+			wp.stats.CompletedTasks++
+		} else {
+			wp.stats.FailedTasks++
+		}
+		// 更新平均延迟
+		totalCompleted := wp.stats.CompletedTasks + wp.stats.FailedTasks
+		if totalCompleted > 0 {
+			wp.stats.AverageLatency = time.Duration(
+				(int64(wp.stats.AverageLatency)*(totalCompleted-1) + int64(result.Duration)) / totalCompleted,
+			)
+		}
+		// 最近耗时的权重为1/5，能较快反映负载变化
+		if wp.stats.RecentLatency == 0 {
+			wp.stats.RecentLatency = result.Duration
+		} else {
+			wp.stats.RecentLatency = (wp.stats.RecentLatency*4 + result.Duration) / 5
+		}
+		wp.stats.mu.Unlock()
+
+		// 发送结果
 		select {
-		case task, ok := <-wp.taskQueue:
-			if !ok {
-				return
-			}
-			
-			start := time.Now()
-			result := task.Execute()
-			result.Duration = time.Since(start)
-			result.Timestamp = time.Now()
-			
-			// 更新统计信息
-			wp.stats.mu.Lock()
-			wp.stats.QueueLength = int32(len(wp.taskQueue))
-			if result.Success {
-				wp.stats.CompletedTasks++
-			} else {
-				wp.stats.FailedTasks++
-			}
-			// 更新平均延迟
-			totalCompleted := wp.stats.CompletedTasks + wp.stats.FailedTasks
-			if totalCompleted > 0 {
-				wp.stats.AverageLatency = time.Duration(
-					(int64(wp.stats.AverageLatency)*(totalCompleted-1) + int64(result.Duration)) / totalCompleted,
-				)
-			}
-			wp.stats.mu.Unlock()
-			
-			// 发送结果
-			select {
-			case wp.resultChan <- result:
-			case <-wp.ctx.Done():
-				return
-			default:
-				// 结果通道满，丢弃结果
-			}
-			
+		case wp.resultChan <- result:
 		case <-wp.ctx.Done():
 			return
+		default:
+			// 结果通道满，丢弃结果
 		}
 	}
 }
@@ -319,50 +319,32 @@ func (bp *BatchProcessor) Stop() {
 	bp.wg.Wait()
 }
 
-// run 运行批处理器
+// run 运行批处理器，每个周期取出队列中的全部消息按批次大小分批处理，停止时处理完剩余的消息
 func (bp *BatchProcessor) run() {
 	defer bp.wg.Done()
 	
 	ticker := time.NewTicker(bp.timeout)
 	defer ticker.Stop()
 	
-	var batch []*QueueMessage
-	
 	for {
 		select {
 		case <-bp.ctx.Done():
-			// 处理剩余的批次
-			if len(batch) > 0 {
-				bp.processor(batch)
-			}
+			bp.drain()
 			return
-			
 		case <-ticker.C:
-			// 超时处理
-			if len(batch) > 0 {
-				bp.processor(batch)
-				batch = batch[:0]
-			}
-			// 重置ticker以继续下一个周期
-			ticker.Reset(bp.timeout)
+			bp.drain()
 		}
-		
-		// 在每个周期内尝试收集消息直到达到批次大小或超时
-		for len(batch) < bp.batchSize {
-			msg := bp.queue.DequeueWithTimeout(10 * time.Millisecond)
-			if msg == nil {
-				break // 没有更多消息，退出内层循环
-			}
-			
-			batch = append(batch, msg)
-			
-			// 检查是否达到批次大小
-			if len(batch) >= bp.batchSize {
-				bp.processor(batch)
-				batch = batch[:0]
-				break
-			}
+	}
+}
+
+// drain 分批处理队列中当前的全部消息
+func (bp *BatchProcessor) drain() {
+	for {
+		batch := bp.queue.DequeueBatch(bp.batchSize)
+		if len(batch) == 0 {
+			return
 		}
+		bp.processor(batch)
 	}
 }
 
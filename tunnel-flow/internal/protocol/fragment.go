@@ -0,0 +1,153 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// 消息分段：序列化后超过最大消息大小的消息拆分为多个二进制帧发送，接收方按分段ID拼接完整后作为一条文本消息处理。
+// 不同消息的分段可以交错发送，大消息不会长时间独占连接，也不会超出中间设备的消息大小限制。
+// 签名、加密和压缩作用于完整的消息，分段本身不签名。
+// 分段格式：版本(1字节) | 分段ID(16字节) | 序号(4字节) | 总数(4字节) | 数据，整数为大端序
+
+const (
+	fragmentVersion    = 1
+	fragmentHeaderSize = 1 + 16 + 4 + 4
+	maxFragmentCount   = 1 << 17 // 一条消息的分段数上限
+	maxPartialMessages = 64      // 一个连接上同时拼接的消息数上限
+)
+
+// MinMaxMessageBytes 最大消息大小的下限，过小时分段过多
+const MinMaxMessageBytes = 4096
+
+// ReassemblyLimit 一个连接上未拼接完整的分段总大小上限
+const ReassemblyLimit = 512 << 20
+
+// SplitMessage 把序列化后超过 maxBytes 的消息拆分为分段帧，maxBytes 为0或消息未超过时返回nil
+func SplitMessage(data []byte, maxBytes int) ([][]byte, error) {
+	if maxBytes <= 0 || len(data) <= maxBytes {
+		return nil, nil
+	}
+	if maxBytes < MinMaxMessageBytes {
+		return nil, fmt.Errorf("max message size %d is below %d", maxBytes, MinMaxMessageBytes)
+	}
+	size := maxBytes - fragmentHeaderSize
+	count := (len(data) + size - 1) / size
+	if count > maxFragmentCount {
+		return nil, fmt.Errorf("message of %d bytes needs more than %d fragments", len(data), maxFragmentCount)
+	}
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, err
+	}
+	frames := make([][]byte, 0, count)
+	for index := 0; index < count; index++ {
+		chunk := data[index*size : min((index+1)*size, len(data))]
+		frame := make([]byte, fragmentHeaderSize+len(chunk))
+		frame[0] = fragmentVersion
+		copy(frame[1:17], id[:])
+		binary.BigEndian.PutUint32(frame[17:21], uint32(index))
+		binary.BigEndian.PutUint32(frame[21:25], uint32(count))
+		copy(frame[fragmentHeaderSize:], chunk)
+		frames = append(frames, frame)
+	}
+	return frames, nil
+}
+
+// Reassembler 拼接一个连接上收到的分段，不同消息的分段可以交错到达
+type Reassembler struct {
+	mu       sync.Mutex
+	limit    int           // 未拼接完整的分段总大小上限
+	timeout  time.Duration // 超过该时间没有收到新分段的消息丢弃
+	buffered int
+	partials map[[16]byte]*partialMessage
+}
+
+// partialMessage 尚未收齐分段的消息
+type partialMessage struct {
+	parts    [][]byte
+	received int
+	size     int
+	updated  time.Time
+}
+
+// NewReassembler 创建分段拼接器
+func NewReassembler(limit int, timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		limit:    limit,
+		timeout:  timeout,
+		partials: make(map[[16]byte]*partialMessage),
+	}
+}
+
+// Add 记录一个分段帧，收到消息的全部分段时返回拼接后的消息，否则返回nil
+func (r *Reassembler) Add(frame []byte, now time.Time) ([]byte, error) {
+	if len(frame) < fragmentHeaderSize || frame[0] != fragmentVersion {
+		return nil, errors.New("invalid message fragment")
+	}
+	var id [16]byte
+	copy(id[:], frame[1:17])
+	index := binary.BigEndian.Uint32(frame[17:21])
+	count := binary.BigEndian.Uint32(frame[21:25])
+	if count == 0 || count > maxFragmentCount || index >= count {
+		return nil, fmt.Errorf("invalid fragment %d of %d", index, count)
+	}
+	data := frame[fragmentHeaderSize:]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prune(now)
+	p := r.partials[id]
+	if p == nil {
+		if len(r.partials) >= maxPartialMessages {
+			return nil, fmt.Errorf("more than %d messages are being reassembled", maxPartialMessages)
+		}
+		p = &partialMessage{parts: make([][]byte, count)}
+		r.partials[id] = p
+	}
+	if len(p.parts) != int(count) {
+		return nil, fmt.Errorf("fragment count changed from %d to %d", len(p.parts), count)
+	}
+	// 重发的分段覆盖之前收到的
+	if p.parts[index] == nil {
+		p.received++
+	} else {
+		p.size -= len(p.parts[index])
+		r.buffered -= len(p.parts[index])
+	}
+	p.parts[index] = data
+	p.size += len(data)
+	r.buffered += len(data)
+	p.updated = now
+	if r.buffered > r.limit {
+		r.drop(id)
+		return nil, fmt.Errorf("buffered fragments exceed %d bytes", r.limit)
+	}
+	if p.received < len(p.parts) {
+		return nil, nil
+	}
+	r.drop(id)
+	return bytes.Join(p.parts, nil), nil
+}
+
+// prune 丢弃超时未收齐的消息
+func (r *Reassembler) prune(now time.Time) {
+	for id, p := range r.partials {
+		if now.Sub(p.updated) > r.timeout {
+			r.drop(id)
+		}
+	}
+}
+
+// drop 删除消息已收到的分段
+func (r *Reassembler) drop(id [16]byte) {
+	if p := r.partials[id]; p != nil {
+		r.buffered -= p.size
+		delete(r.partials, id)
+	}
+}
@@ -20,17 +20,39 @@ const (
 type Operation string
 
 const (
-	OpRegister     Operation = "REGISTER"
-	OpRegisterAck  Operation = "REGISTER_ACK"
-	OpRouteSync    Operation = "ROUTE_SYNC"
-	OpRouteSyncAck Operation = "ROUTE_SYNC_ACK"
-	OpRequest      Operation = "REQUEST"
-	OpResponse     Operation = "RESPONSE"
-	OpACK          Operation = "ACK"
-	OpPing         Operation = "PING"
-	OpPong         Operation = "PONG"
-	OpCancel       Operation = "CANCEL"
-	OpError        Operation = "ERROR"
+	OpRegister      Operation = "REGISTER"
+	OpRegisterAck   Operation = "REGISTER_ACK"
+	OpRouteSync     Operation = "ROUTE_SYNC"
+	OpRouteSyncAck  Operation = "ROUTE_SYNC_ACK"
+	OpRequest       Operation = "REQUEST"
+	OpResponse      Operation = "RESPONSE"
+	OpRequestChunk  Operation = "REQUEST_CHUNK"  // 流式请求体的分片，msg_id 为所属请求的ID
+	OpResponseChunk Operation = "RESPONSE_CHUNK" // 可续传响应体的分片
+	OpTransferAck   Operation = "TRANSFER_ACK"   // 服务端确认已接收的响应体偏移量，也用于通知续传和放弃
+	OpACK           Operation = "ACK"
+	OpPing          Operation = "PING"
+	OpPong          Operation = "PONG"
+	OpCancel        Operation = "CANCEL"
+	OpError         Operation = "ERROR"
+	OpStatsReport   Operation = "STATS_REPORT"
+	OpMetrics       Operation = "METRICS" // 代理定期上报的计数器
+
+	OpTargetAllowlist Operation = "TARGET_ALLOWLIST" // 服务端修改目标白名单后下发给在线的代理
+	OpTargetViolation Operation = "TARGET_VIOLATION" // 代理拒绝了白名单之外的目标
+	OpHeartbeatConfig Operation = "HEARTBEAT_CONFIG" // 服务端修改客户端的心跳设置后下发给在线的代理
+)
+
+// 代理能力，代理注册时上报
+const (
+	CapabilityStreamUpload      = "stream_upload"       // 支持分片接收请求体
+	CapabilityResumableTransfer = "resumable_transfer"  // 支持以可续传的分片发送大响应体
+	CapabilityTargetAllowlist   = "target_allowlist"    // 按服务端下发的白名单限制可以连接的目标
+	CapabilityEncryption        = "e2e_encryption"      // 支持注册时协商密钥加密消息载荷
+	CapabilitySigning           = "message_signing"     // 收发的消息用认证Token签名
+	CapabilityCompression       = "payload_compression" // 支持按服务端下发的设置压缩和解压消息载荷
+	CapabilityFragmentation     = "message_fragments"   // 支持把超过最大消息大小的消息拆分为分段收发
+	CapabilitySessionResume     = "session_resume"      // 断线重连时用恢复令牌继续之前的会话
+	CapabilityEcho              = "echo"                // 直接返回带 echo 标记的请求的请求体，用于隧道压测
 )
 
 // Message WebSocket消息结构
@@ -41,6 +63,16 @@ type Message struct {
 	ClientID string          `json:"client_id"`
 	TS       int64           `json:"ts"`
 	Payload  json.RawMessage `json:"payload"`
+
+	Encrypted  bool   `json:"encrypted,omitempty"`  // 载荷已用该连接协商的密钥加密
+	Compressed string `json:"compressed,omitempty"` // 载荷的压缩方式，见 compression.go
+
+	// 消息签名，见 signing.go
+	Nonce     string `json:"nonce,omitempty"`
+	SignedAt  int64  `json:"signed_at,omitempty"` // 签名时间（毫秒）
+	Signature string `json:"signature,omitempty"`
+
+	compression *CompressionSettings // 发送时使用的压缩设置，为空时使用连接的默认设置
 }
 
 // NewMessage 创建新消息
@@ -70,12 +102,133 @@ type RegisterPayload struct {
 	AuthToken string   `json:"auth_token"`
 	Version   string   `json:"version"`
 	LocalIPs  []string `json:"local_ips"` // 本地网卡IP地址列表
+	// 以下字段由较新的代理上报，旧代理为空
+	OS            string   `json:"os,omitempty"`             // 操作系统，如 linux
+	Arch          string   `json:"arch,omitempty"`           // CPU架构，如 amd64
+	Capabilities  []string `json:"capabilities,omitempty"`   // 代理支持的能力列表
+	GitCommit     string   `json:"git_commit,omitempty"`     // 构建代理的代码提交
+	BuildDate     string   `json:"build_date,omitempty"`     // 代理的构建时间
+	EncryptionKey string   `json:"encryption_key,omitempty"` // 代理的 X25519 公钥，为空表示不加密载荷
+
+	MaxMessageBytes int `json:"max_message_bytes,omitempty"` // 代理要求的最大消息大小，0表示不限制
+
+	ResumeToken string `json:"resume_token,omitempty"` // 上次注册确认中的恢复令牌，断线重连时用于恢复会话
+}
+
+// StatsReportPayload 代理运行状态上报载荷
+type StatsReportPayload struct {
+	Timestamp          int64          `json:"timestamp"`            // 采集时间（毫秒）
+	UptimeSeconds      int64          `json:"uptime_seconds"`       // 代理运行时长
+	CPUPercent         float64        `json:"cpu_percent"`          // 主机CPU使用率
+	CPUCores           int            `json:"cpu_cores"`            // CPU核数
+	MemoryTotalBytes   uint64         `json:"memory_total_bytes"`   // 主机内存总量
+	MemoryUsedBytes    uint64         `json:"memory_used_bytes"`    // 主机已用内存
+	ProcessMemoryBytes uint64         `json:"process_memory_bytes"` // 代理进程占用内存
+	Goroutines         int            `json:"goroutines"`           // 代理goroutine数量
+	Targets            []TargetHealth `json:"targets"`              // 代理本地目标健康状态
+}
+
+// MetricsPayload 代理计数器上报载荷，计数器从代理启动开始累计，吞吐量按距上次上报的增量计算
+type MetricsPayload struct {
+	Timestamp        int64   `json:"timestamp"`         // 采集时间（毫秒）
+	IntervalMS       int64   `json:"interval_ms"`       // 距上次上报的时间
+	MessagesSent     int64   `json:"messages_sent"`     // 代理发送的消息数
+	MessagesReceived int64   `json:"messages_received"` // 代理收到的消息数
+	Errors           int64   `json:"errors"`            // 消息发送失败次数
+	Reconnects       int64   `json:"reconnects"`        // 连接失败次数
+	Requests         int64   `json:"requests"`          // 处理的请求数
+	FailedRequests   int64   `json:"failed_requests"`   // 转发失败或后端返回5xx的请求数
+	ResponseBytes    int64   `json:"response_bytes"`    // 返回的响应体字节数
+	InFlight         int     `json:"in_flight"`         // 处理中的请求数
+	RTTMS            int64   `json:"rtt_ms"`            // 代理测得的心跳往返延迟
+	RequestsPerSec   float64 `json:"requests_per_sec"`
+	BytesPerSec      float64 `json:"bytes_per_sec"`
+}
+
+// TargetHealth 代理本地目标健康状态
+type TargetHealth struct {
+	URL           string `json:"url"`
+	Healthy       bool   `json:"healthy"`
+	LastStatus    int    `json:"last_status,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	LastLatencyMS int64  `json:"last_latency_ms"`
+	SuccessCount  int64  `json:"success_count"`
+	FailureCount  int64  `json:"failure_count"`
+	LastSeenAt    int64  `json:"last_seen_at"`
 }
 
 // RegisterAckPayload 注册确认消息载荷
 type RegisterAckPayload struct {
-	Success bool   `json:"success"`
-	Message string `json:"message"`
+	Success        bool     `json:"success"`
+	Message        string   `json:"message"`
+	AllowedTargets []string `json:"allowed_targets,omitempty"` // 代理只能连接的目标，为空时不限制
+	EncryptionKey  string   `json:"encryption_key,omitempty"`  // 服务端的 X25519 公钥，之后的消息载荷加密
+
+	Compression *CompressionSettings `json:"compression,omitempty"` // 默认的载荷压缩设置，代理上报支持压缩时下发
+
+	MaxMessageBytes int `json:"max_message_bytes,omitempty"` // 双方发送的最大消息大小，超过时拆分为分段；0表示不拆分
+
+	Heartbeat *HeartbeatSettings `json:"heartbeat,omitempty"` // 该客户端的心跳间隔和超时，代理按此发送心跳和判断连接超时
+
+	// 会话恢复，代理上报支持会话恢复且服务端保留会话时下发
+	ResumeToken string `json:"resume_token,omitempty"` // 断线后在保留时间内带上该令牌重连可以恢复会话
+	Resumed     bool   `json:"resumed,omitempty"`      // 本次注册恢复了之前的会话
+}
+
+// HeartbeatSettings 客户端的心跳间隔和超时（秒）
+type HeartbeatSettings struct {
+	IntervalSeconds int `json:"interval_seconds"`
+	TimeoutSeconds  int `json:"timeout_seconds"`
+}
+
+// TargetAllowlistPayload 目标白名单载荷，为空时不限制
+type TargetAllowlistPayload struct {
+	AllowedTargets []string `json:"allowed_targets"`
+}
+
+// TargetViolationPayload 代理拒绝白名单之外的目标后上报的载荷
+type TargetViolationPayload struct {
+	Method    string `json:"method"`
+	URL       string `json:"url"`        // 路由配置的目标地址
+	URLSuffix string `json:"url_suffix"` // 请求的路径
+	Timestamp int64  `json:"timestamp"`
+}
+
+// DeclaredRoute 代理配置中声明的路由，代理注册成功后通过 ROUTE_SYNC 上报
+type DeclaredRoute struct {
+	URLSuffix   string `json:"url_suffix"`
+	Target      string `json:"target"`                // 目标地址，http(s):// 或 unix://
+	ListenPort  int    `json:"listen_port,omitempty"` // 独立监听端口，必须是为该客户端预留的端口
+	Description string `json:"description,omitempty"`
+}
+
+// RouteSyncPayload 代理上报的全部声明路由，服务端删除该客户端不再声明的路由
+type RouteSyncPayload struct {
+	Routes []DeclaredRoute `json:"routes"`
+}
+
+// 声明路由的处理结果
+const (
+	RouteSyncCreated   = "created"
+	RouteSyncUpdated   = "updated"
+	RouteSyncUnchanged = "unchanged"
+	RouteSyncRejected  = "rejected"
+	RouteSyncPending   = "pending"  // 等待管理员审批，批准前不转发请求
+	RouteSyncApproved  = "approved" // 管理员批准，审批结果由服务端单独推送
+)
+
+// DeclaredRouteResult 一条声明路由的处理结果
+type DeclaredRouteResult struct {
+	URLSuffix string `json:"url_suffix"`
+	Status    string `json:"status"`
+	RouteID   int    `json:"route_id,omitempty"`
+	Reason    string `json:"reason,omitempty"` // 拒绝的原因
+}
+
+// RouteSyncAckPayload 服务端对 ROUTE_SYNC 的确认
+type RouteSyncAckPayload struct {
+	Results []DeclaredRouteResult `json:"results"`
+	Removed []string              `json:"removed,omitempty"` // 代理不再声明而删除的路由路径
 }
 
 // RouteTarget 路由目标
@@ -85,15 +238,32 @@ type RouteTarget struct {
 
 // RequestPayload 请求消息载荷
 type RequestPayload struct {
-	URLSuffix     string            `json:"url_suffix"`
-	HTTPMethod    string            `json:"http_method"`
-	Headers       map[string]string `json:"headers"`
-	Params        map[string]string `json:"params"`
-	Body          interface{}       `json:"body"`
-	TimeoutMS     int               `json:"timeout_ms"`
-	TargetsJSON   string            `json:"targets_json"`   // 路由目标JSON字符串
-	DeliveryPolicy string           `json:"delivery_policy"` // 投递策略
-	RouteMode     string            `json:"route_mode"`     // 路由配置模式：basic/full
+	URLSuffix         string            `json:"url_suffix"`
+	HTTPMethod        string            `json:"http_method"`
+	Headers           map[string]string `json:"headers"`
+	Params            map[string]string `json:"params"`
+	Body              interface{}       `json:"body"`
+	TimeoutMS         int               `json:"timeout_ms"`                     // 整个请求的超时
+	ConnectTimeoutMS  int               `json:"connect_timeout_ms,omitempty"`   // 连接目标超时
+	HeaderTimeoutMS   int               `json:"header_timeout_ms,omitempty"`    // 等待响应头超时
+	BodyIdleTimeoutMS int               `json:"body_idle_timeout_ms,omitempty"` // 响应体空闲超时
+	TargetsJSON       string            `json:"targets_json"`                   // 路由目标JSON字符串
+	DeliveryPolicy    string            `json:"delivery_policy"`                // 投递策略
+	RouteMode         string            `json:"route_mode"`                     // 路由配置模式：basic/full
+	BodyStreamed      bool              `json:"body_streamed,omitempty"`        // 请求体不在Body中，随后通过REQUEST_CHUNK分片发送
+	StreamResponse    bool              `json:"stream_response,omitempty"`      // 允许代理把大响应体作为可续传的分片发送
+	Cacheable         bool              `json:"cacheable,omitempty"`            // 路由允许代理按Cache-Control缓存响应
+	Echo              bool              `json:"echo,omitempty"`                 // 代理不转发请求，直接以200返回请求体
+
+	Compression *CompressionSettings `json:"compression,omitempty"` // 路由的载荷压缩设置，代理发送该请求的响应时使用
+}
+
+// RequestChunkPayload 流式请求体分片载荷
+type RequestChunkPayload struct {
+	Seq   int    `json:"seq"`             // 分片序号，从0开始
+	Data  []byte `json:"data,omitempty"`  // 分片数据（JSON中为base64）
+	EOF   bool   `json:"eof,omitempty"`   // 最后一个分片
+	Error string `json:"error,omitempty"` // 读取请求体失败，代理应放弃该请求
 }
 
 // GetTargets 解析路由目标
@@ -125,6 +295,32 @@ type ResponsePayload struct {
 	Body       interface{}       `json:"body"`
 	LatencyMS  int64             `json:"latency_ms"`
 	Error      *string           `json:"error"`
+
+	// HeaderValues 完整的多值响应头，同名头（如多个Set-Cookie）按后端返回的顺序保留所有值；
+	// 旧版本代理只发送 Headers（每个名称一个值）
+	HeaderValues map[string][]string `json:"header_values,omitempty"`
+
+	TransferID string            `json:"transfer_id,omitempty"` // 非空时响应体不在Body中，随后通过RESPONSE_CHUNK分片发送
+	Trailers   map[string]string `json:"trailers,omitempty"`    // 后端响应的trailer，分片发送时随最后一个分片到达
+}
+
+// ResponseChunkPayload 可续传响应体分片载荷
+type ResponseChunkPayload struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"`          // 分片在响应体中的起始偏移量
+	Data       []byte `json:"data,omitempty"`  // 分片数据（JSON中为base64）
+	EOF        bool   `json:"eof,omitempty"`   // 最后一个分片
+	Error      string `json:"error,omitempty"` // 代理读取后端响应体失败，响应不完整
+
+	Trailers map[string]string `json:"trailers,omitempty"` // 后端响应的trailer，只在最后一个分片中
+}
+
+// TransferAckPayload 响应体传输控制载荷
+type TransferAckPayload struct {
+	TransferID string `json:"transfer_id"`
+	Offset     int64  `json:"offset"`           // 服务端已接收的字节数，代理可以丢弃之前的数据
+	Resume     bool   `json:"resume,omitempty"` // 代理重连后从 Offset 重新发送
+	Abort      bool   `json:"abort,omitempty"`  // 服务端放弃该传输
 }
 
 // ACKPayload 确认消息载荷
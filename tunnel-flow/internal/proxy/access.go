@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 路由分享链接和基本认证：令牌包含路由ID和到期时间，用服务端的JWT密钥和路由的分享版本签名，不需要保存在数据库中。
+// 私有路由只转发携带有效令牌的请求，令牌放在查询参数 tf_share 中，验证通过后写入Cookie，
+// 浏览器之后访问该路由的其他路径（如页面引用的静态资源）不需要再带令牌。
+// 设置了基本认证的路由返回401要求浏览器输入用户名和密码，分享链接可以代替基本认证
+
+// ShareQueryParam 分享链接中携带令牌的查询参数
+const ShareQueryParam = "tf_share"
+
+// shareCookiePrefix 保存分享令牌的Cookie名称前缀，后接路由ID
+const shareCookiePrefix = "tf_share_"
+
+// SignShareToken 生成路由在 expiresAt 之前有效的分享令牌
+func SignShareToken(secret string, route *database.ServerRoute, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", route.ID, expiresAt.Unix())
+	return payload + "." + shareSignature(secret, payload, route.ShareVersion)
+}
+
+// shareSignature 计算令牌的签名，路由的分享版本变化后签名不同
+func shareSignature(secret, payload string, version int) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "route-share:%s:%d", payload, version)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyShareToken 检查令牌属于该路由、签名有效且未过期，返回令牌的到期时间
+func verifyShareToken(secret string, route *database.ServerRoute, token string, now time.Time) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	if id, err := strconv.Atoi(parts[0]); err != nil || id != route.ID {
+		return time.Time{}, false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return time.Time{}, false
+	}
+	expected := shareSignature(secret, parts[0]+"."+parts[1], route.ShareVersion)
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return time.Time{}, false
+	}
+	return time.Unix(expires, 0), true
+}
+
+// accessDenial 请求不能访问路由的原因
+type accessDenial int
+
+const (
+	accessAllowed    accessDenial = iota
+	accessCountry                 // 来源国家不在路由允许的范围内
+	accessNeedsShare              // 私有路由，请求没有有效的分享令牌
+	accessNeedsAuth               // 设置了基本认证的路由，请求没有有效的分享令牌和正确的用户名密码
+)
+
+// routeGrant 请求通过一条路由访问检查的凭据，选定可以访问的路由后再据此修改请求和响应
+type routeGrant struct {
+	route        *database.ServerRoute
+	shareToken   string    // 来自查询参数的分享令牌，需要写入Cookie
+	shareExpires time.Time // 分享令牌的到期时间
+	credentials  bool      // 通过基本认证访问
+}
+
+// shareToken 返回请求中该路由有效的分享令牌及其到期时间，fromQuery 表示令牌来自查询参数，不修改请求
+func (h *Handler) shareToken(r *http.Request, route *database.ServerRoute, now time.Time) (token string, expires time.Time, fromQuery bool, ok bool) {
+	if h.config == nil {
+		return "", time.Time{}, false, false
+	}
+	if token := r.URL.Query().Get(ShareQueryParam); token != "" {
+		expires, ok := verifyShareToken(h.config.AuthJWTSecret, route, token, now)
+		return token, expires, true, ok
+	}
+	if cookie, err := r.Cookie(shareCookiePrefix + strconv.Itoa(route.ID)); err == nil {
+		expires, ok := verifyShareToken(h.config.AuthJWTSecret, route, cookie.Value, now)
+		return cookie.Value, expires, false, ok
+	}
+	return "", time.Time{}, false, false
+}
+
+// checkRouteAccess 检查请求能否访问路由，不修改请求和响应
+// 先检查来源国家，分享令牌和基本认证都不能代替国家限制；有效的分享令牌可以代替基本认证；设置了基本认证的私有路由也接受正确的用户名和密码
+func (h *Handler) checkRouteAccess(r *http.Request, route *database.ServerRoute, now time.Time) (accessDenial, routeGrant) {
+	grant := routeGrant{route: route}
+	if route.HasCountryRules() && !route.AllowsCountry(requestCountry(r)) {
+		return accessCountry, grant
+	}
+	if !route.Private && route.BasicAuthUser == "" {
+		return accessAllowed, grant
+	}
+	if token, expires, fromQuery, ok := h.shareToken(r, route, now); ok {
+		if fromQuery {
+			grant.shareToken, grant.shareExpires = token, expires
+		}
+		return accessAllowed, grant
+	}
+	if route.BasicAuthUser != "" {
+		if username, password, ok := r.BasicAuth(); ok && route.CheckBasicAuth(username, password) {
+			grant.credentials = true
+			return accessAllowed, grant
+		}
+		return accessNeedsAuth, grant
+	}
+	return accessNeedsShare, grant
+}
+
+// permittedRoutes 过滤出请求可以访问的匹配路由，之后只在这些路由中选择转发目标和对冲请求的备选，
+// 同一路径下的其他路由不能用来绕过国家限制、私有路由和基本认证。
+// 优先级最高一档的路由都不能访问时按其中第一条路由写入错误响应并返回nil，不降级到低优先级的路由
+func (h *Handler) permittedRoutes(w http.ResponseWriter, r *http.Request, matchedRoutes []*database.ServerRoute, logPrefix string) []*database.ServerRoute {
+	now := time.Now()
+	permitted := make([]*database.ServerRoute, 0, len(matchedRoutes))
+	grants := make([]routeGrant, 0, len(matchedRoutes))
+	denials := make([]accessDenial, len(matchedRoutes))
+	for i, route := range matchedRoutes {
+		denial, grant := h.checkRouteAccess(r, route, now)
+		denials[i] = denial
+		if denial == accessAllowed {
+			permitted = append(permitted, route)
+			grants = append(grants, grant)
+		}
+	}
+	if len(permitted) == 0 || !sameRouteTier(permitted[0], matchedRoutes[0]) {
+		h.writeAccessDenied(w, r, matchedRoutes[0], denials[0], logPrefix)
+		return nil
+	}
+	if len(permitted) < len(matchedRoutes) {
+		proxyLog.Infof("%s Excluded %d matching routes the request may not access", logPrefix, len(matchedRoutes)-len(permitted))
+	}
+
+	for _, grant := range grants {
+		if grant.credentials {
+			// 认证信息只用于访问隧道，不转发给后端
+			r.Header.Del("Authorization")
+		}
+		if grant.shareToken == "" {
+			continue
+		}
+		// 令牌来自查询参数时写入Cookie，并从转发的请求中去掉
+		http.SetCookie(w, &http.Cookie{
+			Name:     shareCookiePrefix + strconv.Itoa(grant.route.ID),
+			Value:    grant.shareToken,
+			Path:     "/",
+			Expires:  grant.shareExpires,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		query := r.URL.Query()
+		query.Del(ShareQueryParam)
+		r.URL.RawQuery = query.Encode()
+	}
+	return permitted
+}
+
+// writeAccessDenied 按路由不能访问的原因写入错误响应
+func (h *Handler) writeAccessDenied(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, denial accessDenial, logPrefix string) {
+	switch denial {
+	case accessCountry:
+		country := requestCountry(r)
+		if country == "" {
+			country = "unknown"
+		}
+		proxyLog.Infof("%s Rejecting request from %s: country %s is not allowed by route %d", logPrefix, r.RemoteAddr, country, route.ID)
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Access from your country is not allowed")
+	case accessNeedsAuth:
+		proxyLog.Infof("%s Rejected request for route %d without valid basic auth credentials", logPrefix, route.ID)
+		w.Header().Set("WWW-Authenticate", `Basic realm="tunnel-flow", charset="UTF-8"`)
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Authentication required")
+	case accessNeedsShare:
+		proxyLog.Infof("%s Rejected request for private route %d without a valid share token", logPrefix, route.ID)
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "A valid share link is required to access this route")
+	}
+}
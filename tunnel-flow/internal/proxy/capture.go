@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 请求抓取：开启抓取的路由上保存最近请求的完整请求和响应，通过 /api/v1/requests 查看
+// 请求体和响应体各自最多保存 capture_max_bytes 字节，记录在 capture_ttl_seconds 后过期删除
+
+// capturePruneInterval 删除过期抓取记录的间隔
+const capturePruneInterval = time.Minute
+
+// captureLimits 路由的抓取上限和保留时间，路由未设置时使用服务器配置
+func (h *Handler) captureLimits(route *database.ServerRoute) (int, time.Duration) {
+	maxBytes, ttlSeconds := route.CaptureMaxBytes, route.CaptureTTLSeconds
+	if maxBytes <= 0 && h.config != nil {
+		maxBytes = h.config.CaptureMaxBytes
+	}
+	if ttlSeconds <= 0 && h.config != nil {
+		ttlSeconds = h.config.CaptureTTLSeconds
+	}
+	return maxBytes, time.Duration(ttlSeconds) * time.Second
+}
+
+// newCapture 开始记录请求，路由未开启抓取时返回nil；streamed 为true时请求体以流式上传，不保存
+func (h *Handler) newCapture(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, urlPath string, body []byte, streamed bool) *database.RequestCapture {
+	if !route.Capture {
+		return nil
+	}
+
+	maxBytes, _ := h.captureLimits(route)
+	headers := make(map[string]string, len(r.Header))
+	for name, values := range r.Header {
+		headers[name] = strings.Join(values, ", ")
+	}
+	capture := &database.RequestCapture{
+		RequestID:      utils.GetRequestID(w, r),
+		OrgID:          route.OrgID,
+		Method:         r.Method,
+		URLSuffix:      urlPath,
+		Query:          r.URL.RawQuery,
+		RequestHeaders: headers,
+		RequestSize:    int64(len(body)),
+		Country:        requestCountry(r),
+		CreatedAt:      time.Now().UnixMilli(),
+	}
+	if streamed {
+		capture.RequestSize = r.ContentLength
+		capture.RequestTruncated = true
+	} else {
+		capture.RequestBody, capture.RequestTruncated = truncateCapture(body, maxBytes)
+	}
+	return capture
+}
+
+// finishCapture 记录响应并异步保存抓取记录，capture 为nil时不做任何事
+// responseBody 为nil且 responseSize 大于0表示响应体以分片传输，没有保存
+func (h *Handler) finishCapture(capture *database.RequestCapture, route *database.ServerRoute, clientID string, status int, headers map[string]string, responseBody interface{}, responseSize int64, failure error) {
+	if capture == nil {
+		return
+	}
+
+	maxBytes, ttl := h.captureLimits(route)
+	capture.RouteID = route.ID
+	capture.ClientID = clientID
+	capture.Status = status
+	capture.ResponseHeaders = headers
+	capture.LatencyMS = time.Now().UnixMilli() - capture.CreatedAt
+	capture.ExpiresAt = capture.CreatedAt + ttl.Milliseconds()
+	if failure != nil {
+		capture.Error = failure.Error()
+	}
+
+	var body []byte
+	switch value := responseBody.(type) {
+	case nil:
+	case string:
+		body = []byte(value)
+	case []byte:
+		body = value
+	default:
+		body = []byte(fmt.Sprintf("%v", value))
+	}
+	capture.ResponseSize = int64(len(body))
+	capture.ResponseBody, capture.ResponseTruncated = truncateCapture(body, maxBytes)
+	if responseBody == nil && responseSize > 0 {
+		capture.ResponseSize = responseSize
+		capture.ResponseTruncated = true
+	}
+
+	// 保存失败不影响代理请求
+	go func() {
+		if err := h.db.CreateRequestCapture(capture); err != nil {
+			proxyLog.Errorf("[HTTP Proxy] Failed to save capture of request %s on route %d: %v", capture.RequestID, capture.RouteID, err)
+		}
+	}()
+}
+
+// truncateCapture 按上限截断请求体或响应体，返回保存的内容和是否被截断
+func truncateCapture(body []byte, maxBytes int) ([]byte, bool) {
+	if maxBytes > 0 && len(body) > maxBytes {
+		return append([]byte(nil), body[:maxBytes]...), true
+	}
+	return append([]byte(nil), body...), false
+}
+
+// PruneCaptures 定期删除过期的抓取记录，直到 ctx 取消
+func (h *Handler) PruneCaptures(ctx context.Context) {
+	ticker := time.NewTicker(capturePruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := h.db.DeleteExpiredRequestCaptures()
+			if err != nil {
+				proxyLog.Errorf("[HTTP Proxy] Failed to delete expired request captures: %v", err)
+			} else if deleted > 0 {
+				proxyLog.Debugf("[HTTP Proxy] Deleted %d expired request captures", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/andybalholm/brotli"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/protocol"
+)
+
+// 代理响应压缩
+// 客户端接受路由允许的编码且响应体达到最小长度时压缩文本类响应；后端已经压缩的响应和声明 no-transform 的响应原样转发
+// 路由未设置压缩方式时按服务器配置使用gzip，设置为br时优先使用br
+// 服务端与代理之间的载荷压缩见 protocol/compression.go，路由可以覆盖其压缩方式和阈值
+
+// compressionStats 响应压缩统计
+type compressionStats struct {
+	responses atomic.Int64 // 压缩的响应数
+	bytesIn   atomic.Int64 // 压缩前的字节数
+	bytesOut  atomic.Int64 // 压缩后的字节数
+}
+
+// compressibleTypes 可以压缩的内容类型前缀
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/x-javascript",
+	"application/x-www-form-urlencoded",
+	"image/svg+xml",
+}
+
+// routeEncodings 路由允许的响应编码，按优先顺序排列
+func (h *Handler) routeEncodings(route *database.ServerRoute) []string {
+	compression := database.RouteCompressionDefault
+	if route != nil {
+		compression = route.Compression
+	}
+	switch compression {
+	case database.RouteCompressionNone:
+		return nil
+	case database.RouteCompressionGzip:
+		return []string{"gzip"}
+	case database.RouteCompressionBrotli:
+		return []string{"br", "gzip"}
+	}
+	if h.config == nil || !h.config.ProxyCompression {
+		return nil
+	}
+	return []string{"gzip"}
+}
+
+// payloadCompression 路由覆盖的载荷压缩设置，未覆盖的项使用服务器配置；路由没有覆盖时返回nil，使用连接的默认设置
+func (h *Handler) payloadCompression(route *database.ServerRoute) *protocol.CompressionSettings {
+	if route == nil || h.config == nil || (route.PayloadCompression == "" && route.PayloadCompressionMinBytes == 0) {
+		return nil
+	}
+	settings := &protocol.CompressionSettings{
+		Algorithm: h.config.CompressionAlgorithm,
+		MinBytes:  h.config.CompressionMinBytes,
+	}
+	if route.PayloadCompression != "" {
+		settings.Algorithm = route.PayloadCompression
+	}
+	if route.PayloadCompressionMinBytes > 0 {
+		settings.MinBytes = route.PayloadCompressionMinBytes
+	}
+	return settings
+}
+
+// compressResponse 按客户端的 Accept-Encoding 和路由的压缩方式压缩响应体，压缩时同步修改响应头
+func (h *Handler) compressResponse(r *http.Request, route *database.ServerRoute, header http.Header, status int, body []byte) []byte {
+	if h.config == nil || len(body) < h.config.ProxyCompressionMinSize {
+		return body
+	}
+	if r.Method == http.MethodHead || status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return body
+	}
+	if header.Get("Content-Encoding") != "" || header.Get("Content-Range") != "" ||
+		strings.Contains(strings.ToLower(header.Get("Cache-Control")), "no-transform") {
+		return body
+	}
+	if !isCompressible(header.Get("Content-Type")) {
+		return body
+	}
+	encoding := ""
+	for _, candidate := range h.routeEncodings(route) {
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), candidate) {
+			encoding = candidate
+			break
+		}
+	}
+	if encoding == "" {
+		return body
+	}
+
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	if encoding == "br" {
+		zw = brotli.NewWriterLevel(&buf, brotli.DefaultCompression)
+	} else {
+		zw, _ = gzip.NewWriterLevel(&buf, gzip.DefaultCompression)
+	}
+	if _, err := zw.Write(body); err != nil {
+		return body
+	}
+	if err := zw.Close(); err != nil || buf.Len() >= len(body) {
+		return body
+	}
+
+	header.Set("Content-Encoding", encoding)
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	header.Add("Vary", "Accept-Encoding")
+	// 压缩后的内容与原内容不同，强ETag改为弱ETag
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+
+	h.compression.responses.Add(1)
+	h.compression.bytesIn.Add(int64(len(body)))
+	h.compression.bytesOut.Add(int64(buf.Len()))
+	return buf.Bytes()
+}
+
+// isCompressible 检查内容类型是否值得压缩，未声明类型的响应不压缩
+func isCompressible(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0]))
+	if contentType == "" {
+		return false
+	}
+	if strings.HasSuffix(contentType, "+json") || strings.HasSuffix(contentType, "+xml") {
+		return true
+	}
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsEncoding 检查 Accept-Encoding 是否接受指定编码（q=0表示拒绝）
+func acceptsEncoding(acceptEncoding, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != encoding && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				q = parsed
+			}
+		}
+		if coding == encoding {
+			// 明确列出的编码优先于通配符
+			return q > 0
+		}
+		accepted = q > 0
+	}
+	return accepted
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 路由自定义域名：请求主机是某条路由绑定的域名时，该域名的所有路径都转发给这条路由
+
+const (
+	// DomainChallengePath 域名所有权HTTP验证的路径前缀，GET <前缀><令牌> 返回令牌本身
+	DomainChallengePath = "/.well-known/tunnel-flow/"
+	// DomainChallengeRecord 域名所有权DNS验证的TXT记录名前缀，记录名为 _tunnel-flow.<域名>
+	DomainChallengeRecord = "_tunnel-flow."
+)
+
+// requestHostname 返回请求的主机名，去掉端口并转为小写
+func requestHostname(r *http.Request) string {
+	host := r.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// DomainMiddleware 请求主机是路由的自定义域名时转发给该路由，否则交给 next 按路径匹配
+func (h *Handler) DomainMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := requestHostname(r)
+		if !strings.Contains(host, ".") || net.ParseIP(host) != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, err := h.db.GetServerRouteByDomain(host)
+		if err == sql.ErrNoRows {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err != nil {
+			proxyLog.Errorf("[Domain %s] Failed to get route: %v", host, err)
+			utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrCodeInternal, "Internal server error")
+			return
+		}
+		h.serveDomain(w, r, route, host)
+	})
+}
+
+// serveDomain 处理路由自定义域名上的请求：验证路径返回令牌，域名通过验证后其余请求转发给路由
+func (h *Handler) serveDomain(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, host string) {
+	if token := strings.TrimPrefix(r.URL.Path, DomainChallengePath); token != r.URL.Path && route.DomainVerifyToken != "" && token == route.DomainVerifyToken {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte(route.DomainVerifyToken))
+		return
+	}
+
+	logPrefix := fmt.Sprintf("[Domain %s]", host)
+	proxyLog.Infof("%s Received %s request: %s from %s", logPrefix, r.Method, r.URL.Path, remoteDescription(r))
+	if !route.IsDomainVerified() {
+		proxyLog.Infof("%s Domain of route %d is not verified", logPrefix, route.ID)
+		h.writeRouteNotFound(w, r, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	h.serveRoute(w, r, route, logPrefix)
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/utils"
+)
+
+// 代理错误页面：运营者可以为“路由不存在”和“没有可用后端”提供HTML、JSON或纯文本模板，全局配置并按请求主机覆盖
+// 模板可以使用的变量见 errorPageData，JSON模板中的字符串应使用 {{json .Path}} 输出以正确转义
+
+// errorPageData 错误页面模板可以使用的变量
+type errorPageData struct {
+	Status    int    // HTTP状态码
+	Code      string // 错误码，如 ROUTE_NOT_FOUND
+	Message   string // 错误信息
+	RequestID string
+	Timestamp string // RFC3339格式的当前时间
+	Method    string
+	Host      string
+	Path      string
+}
+
+// templateExecutor html/template 和 text/template 的公共接口
+type templateExecutor interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// errorPage 解析后的错误页面模板
+type errorPage struct {
+	template    templateExecutor
+	contentType string
+}
+
+// errorPageSet 一组错误页面，未配置的页面为空
+type errorPageSet struct {
+	notFound  *errorPage
+	noBackend *errorPage
+}
+
+// errorPages 全局和按主机配置的错误页面
+type errorPages struct {
+	global errorPageSet
+	hosts  map[string]errorPageSet // 键为小写主机名或 *.example.com
+}
+
+// errorPageFuncs 模板中可以使用的函数
+var errorPageFuncs = map[string]interface{}{
+	"json": func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return `""`
+		}
+		return string(data)
+	},
+}
+
+// loadErrorPages 读取配置的错误页面模板，读取或解析失败的页面记录日志后退回默认的JSON错误
+func loadErrorPages(cfg *config.Config) *errorPages {
+	pages := &errorPages{hosts: make(map[string]errorPageSet)}
+	if cfg == nil {
+		return pages
+	}
+	pages.global = loadErrorPageSet(cfg.ProxyErrorPages)
+	for host, files := range cfg.ProxyHostErrorPages {
+		pages.hosts[strings.ToLower(host)] = loadErrorPageSet(files)
+	}
+	return pages
+}
+
+// loadErrorPageSet 读取一组错误页面模板
+func loadErrorPageSet(files config.ErrorPages) errorPageSet {
+	return errorPageSet{
+		notFound:  loadErrorPage(files.NotFound),
+		noBackend: loadErrorPage(files.NoBackend),
+	}
+}
+
+// loadErrorPage 读取并解析一个模板，按扩展名决定格式；.html/.htm 使用 html/template 自动转义
+func loadErrorPage(path string) *errorPage {
+	if path == "" {
+		return nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read proxy error page %s: %v", path, err)
+		return nil
+	}
+
+	var tmpl templateExecutor
+	contentType := "text/plain; charset=utf-8"
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".html", ".htm":
+		contentType = "text/html; charset=utf-8"
+		tmpl, err = htmltemplate.New(filepath.Base(path)).Funcs(errorPageFuncs).Parse(string(content))
+	case ".json":
+		contentType = "application/json; charset=utf-8"
+		tmpl, err = template.New(filepath.Base(path)).Funcs(errorPageFuncs).Parse(string(content))
+	default:
+		tmpl, err = template.New(filepath.Base(path)).Funcs(errorPageFuncs).Parse(string(content))
+	}
+	if err != nil {
+		log.Printf("Failed to parse proxy error page %s: %v", path, err)
+		return nil
+	}
+	return &errorPage{template: tmpl, contentType: contentType}
+}
+
+// lookup 返回适用于请求主机的页面：精确匹配的主机优先，其次是最长的通配符，最后是全局页面
+func (p *errorPages) lookup(r *http.Request, pick func(errorPageSet) *errorPage) *errorPage {
+	host := strings.ToLower(r.Host)
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	if set, ok := p.hosts[host]; ok {
+		if page := pick(set); page != nil {
+			return page
+		}
+	}
+	var best *errorPage
+	bestLen := 0
+	for pattern, set := range p.hosts {
+		page := pick(set)
+		if page == nil || !strings.HasPrefix(pattern, "*.") || len(pattern) <= bestLen || !utils.DomainCovers(pattern, host) {
+			continue
+		}
+		best, bestLen = page, len(pattern)
+	}
+	if best != nil {
+		return best
+	}
+	return pick(p.global)
+}
+
+// write 使用配置的模板写入错误响应，没有模板或渲染失败时写入默认的JSON错误
+func (p *errorPages) write(w http.ResponseWriter, r *http.Request, page *errorPage, status int, code, message string) {
+	if page == nil {
+		utils.WriteError(w, r, status, code, message)
+		return
+	}
+
+	data := errorPageData{
+		Status:    status,
+		Code:      code,
+		Message:   message,
+		RequestID: utils.GetRequestID(w, r),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Method:    r.Method,
+		Host:      r.Host,
+		Path:      r.URL.Path,
+	}
+	var body bytes.Buffer
+	if err := page.template.Execute(&body, data); err != nil {
+		log.Printf("Failed to render proxy error page: %v", err)
+		utils.WriteError(w, r, status, code, message)
+		return
+	}
+
+	w.Header().Set("Content-Type", page.contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// writeRouteNotFound 返回路由不存在的响应，请求没有对应的路由，只计入全局计数
+func (h *Handler) writeRouteNotFound(w http.ResponseWriter, r *http.Request, code, message string) {
+	h.metrics.RecordProxyRequest(0, http.StatusNotFound, "")
+	page := h.errorPages.lookup(r, func(set errorPageSet) *errorPage { return set.notFound })
+	h.errorPages.write(w, r, page, http.StatusNotFound, code, message)
+}
+
+// writeNoBackend 返回没有可用后端的响应
+func (h *Handler) writeNoBackend(w http.ResponseWriter, r *http.Request) {
+	page := h.errorPages.lookup(r, func(set errorPageSet) *errorPage { return set.noBackend })
+	h.errorPages.write(w, r, page, http.StatusServiceUnavailable, utils.ErrCodeNoBackend, "No available backend")
+}
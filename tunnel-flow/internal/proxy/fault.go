@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/monitoring"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+)
+
+// 故障注入：路由按设置在转发前增加延迟、按比例直接返回错误，或收到响应后按比例丢弃响应并断开连接
+// 注入了故障的响应带有 X-Tunnel-Fault 头，便于和后端真实的故障区分
+
+// faultHeader 标记响应注入了哪种故障
+const faultHeader = "X-Tunnel-Fault"
+
+// errFaultDropped 响应被故障注入丢弃
+var errFaultDropped = errors.New("response dropped by fault injection")
+
+// injectFault 按路由设置注入延迟和错误，返回true表示请求已结束，不再转发
+func (h *Handler) injectFault(w http.ResponseWriter, r *http.Request, route *database.ServerRoute) bool {
+	if route.FaultDelayMS > 0 {
+		w.Header().Add(faultHeader, "delay="+strconv.Itoa(route.FaultDelayMS)+"ms")
+		timer := time.NewTimer(time.Duration(route.FaultDelayMS) * time.Millisecond)
+		select {
+		case <-timer.C:
+		case <-r.Context().Done():
+			// 调用方在延迟期间断开
+			timer.Stop()
+			return true
+		}
+	}
+
+	if route.FaultErrorPercent > 0 && rand.Intn(100) < route.FaultErrorPercent {
+		status := route.FaultErrorStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		proxyLog.Warnf("[HTTP Proxy] Injecting status %d for route %d by fault injection", status, route.ID)
+		h.recordTraffic(monitoring.TrafficRecord{
+			OrgID:     route.OrgID,
+			RouteID:   route.ID,
+			URLSuffix: route.URLSuffix,
+			Status:    status,
+		}, "")
+		w.Header().Add(faultHeader, "error")
+		utils.WriteError(w, r, status, utils.ErrCodeFaultInjected, "Fault injected by route configuration")
+		return true
+	}
+	return false
+}
+
+// dropFault 按路由设置决定是否丢弃客户端返回的响应，丢弃分片传输的响应时通知代理放弃发送
+func (h *Handler) dropFault(route *database.ServerRoute, response *protocol.ResponsePayload) bool {
+	if route.FaultDropPercent <= 0 || rand.Intn(100) >= route.FaultDropPercent {
+		return false
+	}
+	if response.TransferID != "" {
+		h.wsManager.CloseTransfer(response.TransferID, false)
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/geoip"
+)
+
+// countryKey 请求上下文中来源国家的键
+type countryKey struct{}
+
+// openGeoIP 打开配置的GeoIP数据库，未配置或打开失败时返回nil，此时不解析请求来源国家
+func openGeoIP(cfg *config.Config) *geoip.Reader {
+	if cfg == nil || cfg.GeoIPDatabase == "" {
+		return nil
+	}
+	reader, err := geoip.Open(cfg.GeoIPDatabase)
+	if err != nil {
+		log.Printf("Failed to open GeoIP database %s: %v", cfg.GeoIPDatabase, err)
+		return nil
+	}
+	return reader
+}
+
+// CountryMiddleware 按连接的来源IP解析国家并保存在请求上下文中，供路由的国家限制、访问日志和请求抓取使用
+// 没有配置GeoIP数据库时直接交给 next
+func (h *Handler) CountryMiddleware(next http.Handler) http.Handler {
+	if h.geo == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ip net.IP
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			ip = net.ParseIP(host)
+		}
+		country := h.geo.Country(ip)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), countryKey{}, country)))
+	})
+}
+
+// requestCountry 返回请求来源国家的两位代码，没有解析或无法确定时为空
+func requestCountry(r *http.Request) string {
+	country, _ := r.Context().Value(countryKey{}).(string)
+	return country
+}
+
+// remoteDescription 访问日志中的请求来源，解析出国家时附加在地址后，如 203.0.113.5:50312 [DE]
+func remoteDescription(r *http.Request) string {
+	if country := requestCountry(r); country != "" {
+		return r.RemoteAddr + " [" + country + "]"
+	}
+	return r.RemoteAddr
+}
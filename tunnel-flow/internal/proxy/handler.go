@@ -1,227 +1,781 @@
 package proxy
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"math/rand"
+	"net"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"tunnel-flow/internal/config"
 	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/geoip"
+	"tunnel-flow/internal/logging"
+	"tunnel-flow/internal/health"
+	"tunnel-flow/internal/monitoring"
 	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/quota"
 	"tunnel-flow/internal/utils"
 	"tunnel-flow/internal/websocket"
 )
 
+// proxyLog 代理转发日志，级别可以在运行时单独调整
+var proxyLog = logging.NewComponent(logging.ComponentProxy)
+
+// proxyRequestTimeout 等待客户端响应的默认超时时间，路由可以设置总超时覆盖
+const proxyRequestTimeout = 30 * time.Second
+
 // Handler 代理处理器
 type Handler struct {
+	config    *config.Config
 	db        *database.Repository
 	wsManager *websocket.Manager
+	traffic   *monitoring.TrafficStats
+	metrics   *monitoring.MetricsCollector
+	quota     *quota.Manager
+	health    *health.Tracker
+
+	// 路由不存在和没有可用后端时返回的页面
+	errorPages *errorPages
+
+	// 高流量路由的详细日志采样，为nil时不采样
+	logSampler *logging.Sampler
+
+	// 解析请求来源国家，没有配置GeoIP数据库时为nil
+	geo *geoip.Reader
+
+	// 响应压缩统计
+	compression compressionStats
+
+	// 分组路由的轮询计数，按路由ID区分
+	mu         sync.Mutex
+	roundRobin map[int]uint64
 }
 
-// NewHandler 创建新的代理处理器
-func NewHandler(db *database.Repository, wsManager *websocket.Manager) *Handler {
+// NewHandler 创建新的代理处理器，traffic 为空时不统计流量，metrics 为空时不按状态码计数，
+// quotas 为空时不限制用量，tracker 为空时不跳过错误率高的客户端
+func NewHandler(cfg *config.Config, db *database.Repository, wsManager *websocket.Manager, traffic *monitoring.TrafficStats, metrics *monitoring.MetricsCollector, quotas *quota.Manager, tracker *health.Tracker) *Handler {
 	return &Handler{
-		db:        db,
-		wsManager: wsManager,
+		config:     cfg,
+		db:         db,
+		wsManager:  wsManager,
+		traffic:    traffic,
+		metrics:    metrics,
+		quota:      quotas,
+		health:     tracker,
+		errorPages: loadErrorPages(cfg),
+		logSampler: newLogSampler(cfg),
+		geo:        openGeoIP(cfg),
+		roundRobin: make(map[int]uint64),
+	}
+}
+
+// PathPrefix 代理请求的路径前缀，未配置时为 /proxy
+func (h *Handler) PathPrefix() string {
+	if h.config == nil || h.config.ProxyPathPrefix == "" {
+		return "/proxy"
 	}
+	return h.config.ProxyPathPrefix
 }
 
-// HandleProxyRequest 处理代理请求
+// HandleProxyRequest 处理带路径前缀的代理请求
 func (h *Handler) HandleProxyRequest(w http.ResponseWriter, r *http.Request) {
 	// 记录8082端口请求接收日志
-	log.Printf("[8082 Proxy] Received %s request: %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+	proxyLog.Infof("[8082 Proxy] Received %s request: %s from %s", r.Method, r.URL.Path, remoteDescription(r))
 	
 	// 提取URL后缀
-	urlPath := strings.TrimPrefix(r.URL.Path, "/proxy")
+	urlPath := strings.TrimPrefix(r.URL.Path, h.PathPrefix())
 	if urlPath == "" {
-		log.Printf("[8082 Proxy] Invalid proxy path: %s", r.URL.Path)
-		http.Error(w, "Invalid proxy path", http.StatusBadRequest)
+		proxyLog.Infof("[8082 Proxy] Invalid proxy path: %s", r.URL.Path)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid proxy path")
 		return
 	}
 
-	// 查找路由
-	routes, err := h.db.ListServerRoutes()
-	if err != nil {
-		log.Printf("Failed to get routes for %s: %v", urlPath, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	h.dispatch(w, r, urlPath, "[8082 Proxy]")
+}
+
+// HandleDirectProxyRequest 处理直接代理请求（不带路径前缀），配置关闭直接访问时一律返回404
+func (h *Handler) HandleDirectProxyRequest(w http.ResponseWriter, r *http.Request) {
+	// 跳过特殊路径
+	if r.URL.Path == uploadPathPrefix || strings.HasPrefix(r.URL.Path, uploadPathPrefix+"/") || r.URL.Path == "/health" || r.URL.Path == "/status" || r.URL.Path == "/live" || r.URL.Path == "/ready" || strings.HasPrefix(r.URL.Path, h.PathPrefix()+"/") ||
+		(h.config != nil && !h.config.ProxyDirectMode) {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Not found")
+		return
+	}
+	
+	// 记录8082端口请求接收日志
+	proxyLog.Infof("[8082 Direct] Received %s request: %s from %s", r.Method, r.URL.Path, remoteDescription(r))
+	
+	// 直接使用URL路径，不需要移除前缀
+	urlPath := r.URL.Path
+	if urlPath == "/" {
+		proxyLog.Infof("[8082 Direct] Root path access not allowed")
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Root path not allowed")
 		return
 	}
 
-	// 过滤匹配的路由（支持通配符）并排除禁用的路由
-	matchedRoutes := make([]*database.ServerRoute, 0)
-	for _, route := range routes {
-		if utils.MatchPattern(route.URLSuffix, urlPath) && route.IsEnabled() {
-			matchedRoutes = append(matchedRoutes, route)
+	h.dispatch(w, r, urlPath, "[8082 Direct]")
+}
+
+// resolveTenant 确定请求所属组织，返回组织ID和去掉组织前缀后的路径
+// 配置了租户域名时优先按子域名（acme.tunnel.example.com）区分，否则按路径第一段的组织标识（/acme/api/...）区分
+// 路径第一段不是组织标识时属于默认组织，保持单租户时的URL不变
+func (h *Handler) resolveTenant(r *http.Request, urlPath string) (int, string, error) {
+	if h.config != nil && h.config.ProxyTenantDomain != "" {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		suffix := "." + strings.ToLower(h.config.ProxyTenantDomain)
+		if slug := strings.TrimSuffix(strings.ToLower(host), suffix); slug != strings.ToLower(host) {
+			org, err := h.db.GetOrganizationBySlug(slug)
+			if err != nil {
+				return 0, "", err
+			}
+			return org.ID, urlPath, nil
 		}
 	}
 
-	// 按优先级排序路由（优先级高的在前）
-	if len(matchedRoutes) > 1 {
-		sort.Slice(matchedRoutes, func(i, j int) bool {
-			priorityI := utils.GetPatternPriority(matchedRoutes[i].URLSuffix)
-			priorityJ := utils.GetPatternPriority(matchedRoutes[j].URLSuffix)
-			return priorityI > priorityJ
-		})
+	segment := strings.TrimPrefix(urlPath, "/")
+	rest := "/"
+	if idx := strings.Index(segment, "/"); idx >= 0 {
+		segment, rest = segment[:idx], segment[idx:]
+	}
+	if !utils.IsValidSlug(segment) {
+		return database.DefaultOrgID, urlPath, nil
+	}
+
+	org, err := h.db.GetOrganizationBySlug(segment)
+	if err == sql.ErrNoRows {
+		return database.DefaultOrgID, urlPath, nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return org.ID, rest, nil
+}
+
+// dispatch 确定组织、匹配该组织的路由、选择可用客户端并转发请求
+func (h *Handler) dispatch(w http.ResponseWriter, r *http.Request, urlPath string, logPrefix string) {
+	orgID, urlPath, err := h.resolveTenant(r, urlPath)
+	if err == sql.ErrNoRows {
+		proxyLog.Warnf("%s Unknown organization for host: %s", logPrefix, r.Host)
+		h.writeRouteNotFound(w, r, utils.ErrCodeNotFound, "Unknown organization")
+		return
+	}
+	if err != nil {
+		proxyLog.Errorf("%s Failed to resolve organization for %s: %v", logPrefix, urlPath, err)
+		utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrCodeInternal, "Internal server error")
+		return
+	}
+
+	// 只查找该组织的路由，不同组织可以注册相同的路径
+	routes, err := h.db.ListServerRoutesByOrg(orgID)
+	if err != nil {
+		proxyLog.Errorf("Failed to get routes for %s: %v", urlPath, err)
+		utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrCodeInternal, "Internal server error")
+		return
 	}
 
+	matchedRoutes := matchRoutes(routes, urlPath, r)
 	if len(matchedRoutes) == 0 {
-		log.Printf("[8082 Proxy] No route found for path: %s", urlPath)
-		http.Error(w, "Route not found", http.StatusNotFound)
+		proxyLog.Infof("%s No route found for path: %s", logPrefix, urlPath)
+		h.writeRouteNotFound(w, r, utils.ErrCodeRouteNotFound, "Route not found")
 		return
 	}
 
-	log.Printf("[8082 Proxy] Found %d matching routes for path: %s", len(matchedRoutes), urlPath)
+	proxyLog.Infof("%s Found %d matching routes for path: %s", logPrefix, len(matchedRoutes), urlPath)
 
-	// 选择第一个可用的路由
-	var selectedRoute *database.ServerRoute
-	for _, route := range matchedRoutes {
-		// 检查客户端是否连接且启用
-		if h.wsManager.IsClientConnected(route.ClientID) {
-			// 检查客户端是否启用
-			clientInfo, err := h.db.GetClient(route.ClientID)
-			if err != nil || clientInfo.Enabled != 1 {
-				log.Printf("[8082 Proxy] Skipping disabled client: %s", route.ClientID)
-				continue // 跳过禁用的客户端
-			}
-			selectedRoute = route
-			log.Printf("[8082 Proxy] Selected route with client: %s", route.ClientID)
-			break
-		} else {
-			log.Printf("[8082 Proxy] Client not connected: %s", route.ClientID)
-		}
+	h.serveMatched(w, r, urlPath, matchedRoutes, logPrefix)
+}
+
+// HandleRouteRequest 处理路由独立监听端口上的请求，端口上的所有路径都转发给这条路由
+// 每次请求重新读取路由，路由已禁用、已删除或改用其他端口时返回404
+func (h *Handler) HandleRouteRequest(w http.ResponseWriter, r *http.Request, routeID int, port int) {
+	logPrefix := fmt.Sprintf("[Port %d]", port)
+	proxyLog.Infof("%s Received %s request: %s from %s", logPrefix, r.Method, r.URL.Path, remoteDescription(r))
+
+	route, err := h.db.GetServerRoute(routeID)
+	if err != nil && err != sql.ErrNoRows {
+		proxyLog.Errorf("%s Failed to get route %d: %v", logPrefix, routeID, err)
+		utils.WriteError(w, r, http.StatusInternalServerError, utils.ErrCodeInternal, "Internal server error")
+		return
+	}
+	if err == sql.ErrNoRows || route.ListenPort != port {
+		h.writeRouteNotFound(w, r, utils.ErrCodeRouteNotFound, "Route not found")
+		return
 	}
 
-	if selectedRoute == nil {
-		log.Printf("[8082 Proxy] No available backend for path: %s", urlPath)
-		http.Error(w, "No available backend", http.StatusServiceUnavailable)
+	h.serveRoute(w, r, route, logPrefix)
+}
+
+// serveRoute 把请求的所有路径转发给单条路由，路由已禁用、未批准、已过期或请求不满足匹配条件时返回404
+func (h *Handler) serveRoute(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, logPrefix string) {
+	if !route.IsEnabled() || !route.IsApproved() || route.IsExpired(time.Now()) {
+		h.writeRouteNotFound(w, r, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if !matchHeaders(route.MatchHeaders, r.Header) || !matchQuery(route.MatchQuery, r.URL.Query()) {
+		proxyLog.Infof("%s Request does not match conditions of route %d", logPrefix, route.ID)
+		h.writeRouteNotFound(w, r, utils.ErrCodeRouteNotFound, "Route not found")
 		return
 	}
 
-	// 转发请求到客户端
-	h.forwardRequestToClient(w, r, selectedRoute, urlPath)
+	h.serveMatched(w, r, r.URL.Path, []*database.ServerRoute{route}, logPrefix)
 }
 
-// HandleDirectProxyRequest 处理直接代理请求（不带/proxy前缀）
-func (h *Handler) HandleDirectProxyRequest(w http.ResponseWriter, r *http.Request) {
-	// 跳过特殊路径
-	if r.URL.Path == "/upload" || r.URL.Path == "/health" || r.URL.Path == "/status" || strings.HasPrefix(r.URL.Path, "/proxy/") {
-		http.NotFound(w, r)
+// serveMatched 在匹配的路由中选择可用客户端、检查配额并转发请求
+func (h *Handler) serveMatched(w http.ResponseWriter, r *http.Request, urlPath string, matchedRoutes []*database.ServerRoute, logPrefix string) {
+	// 检查来源国家，私有路由只转发携带有效分享令牌的请求，设置了基本认证的路由要求正确的用户名和密码，
+	// 转发和对冲都只使用请求可以访问的路由
+	if matchedRoutes = h.permittedRoutes(w, r, matchedRoutes, logPrefix); matchedRoutes == nil {
 		return
 	}
-	
-	// 记录8082端口请求接收日志
-	log.Printf("[8082 Direct] Received %s request: %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
-	
-	// 直接使用URL路径，不需要移除前缀
-	urlPath := r.URL.Path
-	if urlPath == "/" {
-		log.Printf("[8082 Direct] Root path access not allowed")
-		http.Error(w, "Root path not allowed", http.StatusBadRequest)
+
+	selectedRoute, clientID := h.selectTarget(matchedRoutes, logPrefix)
+	if selectedRoute == nil {
+		proxyLog.Warnf("%s No available backend for path: %s", logPrefix, urlPath)
+		h.recordTraffic(monitoring.TrafficRecord{
+			OrgID:     matchedRoutes[0].OrgID,
+			RouteID:   matchedRoutes[0].ID,
+			URLSuffix: matchedRoutes[0].URLSuffix,
+			Status:    http.StatusServiceUnavailable,
+		}, monitoring.ProxyFailureNoBackend)
+		h.writeNoBackend(w, r)
 		return
 	}
 
-	// 查找路由
-	routes, err := h.db.ListServerRoutes()
-	if err != nil {
-		log.Printf("Failed to get routes for %s: %v", urlPath, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	if violation := h.quota.Check(clientID); violation != nil {
+		proxyLog.Warnf("%s Rejecting request for client %s: %v", logPrefix, clientID, violation)
+		h.recordTraffic(monitoring.TrafficRecord{
+			OrgID:     selectedRoute.OrgID,
+			RouteID:   selectedRoute.ID,
+			URLSuffix: selectedRoute.URLSuffix,
+			Status:    violation.Status,
+		}, "")
+		writeQuotaError(w, r, violation)
+		return
+	}
+
+	// 开启故障注入的路由先注入延迟和错误
+	if h.injectFault(w, r, selectedRoute) {
 		return
 	}
 
-	// 过滤匹配的路由（支持通配符）并排除禁用的路由
+	// 转发请求到客户端，其余匹配的路由作为对冲请求的备选
+	r = h.sampleDetailLog(r, selectedRoute.ID)
+	h.forwardRequestToClient(w, r, selectedRoute, clientID, urlPath, matchedRoutes)
+}
+
+// writeQuotaError 返回超出配额的错误响应，Retry-After 为计数器重置前的秒数
+func writeQuotaError(w http.ResponseWriter, r *http.Request, violation *quota.Violation) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(violation.RetryAfter.Seconds())+1))
+	if violation.Metric == quota.MetricRequestsPerDay {
+		utils.WriteError(w, r, violation.Status, utils.ErrCodeRequestQuota, "Daily request quota exceeded")
+		return
+	}
+	utils.WriteError(w, r, violation.Status, utils.ErrCodeTrafficQuota, "Monthly traffic quota exceeded")
+}
+
+// matchRoutes 过滤匹配的路由（支持通配符）并排除禁用、未批准和已到期的路由，按优先级排序
+// 路径匹配后再检查路由的请求头和查询参数条件，同一路径优先级下条件多的路由排在前面
+// 配置了独立监听端口的路由只能通过该端口访问，不参与匹配
+func matchRoutes(routes []*database.ServerRoute, urlPath string, r *http.Request) []*database.ServerRoute {
 	matchedRoutes := make([]*database.ServerRoute, 0)
+	var query url.Values
+	now := time.Now()
 	for _, route := range routes {
-		if utils.MatchPattern(route.URLSuffix, urlPath) && route.IsEnabled() {
+		if route.ListenPort > 0 || !utils.MatchPattern(route.URLSuffix, urlPath) || !route.IsEnabled() || !route.IsApproved() || route.IsExpired(now) || !matchHeaders(route.MatchHeaders, r.Header) {
+			continue
+		}
+		if len(route.MatchQuery) > 0 && query == nil {
+			query = r.URL.Query()
+		}
+		if matchQuery(route.MatchQuery, query) {
 			matchedRoutes = append(matchedRoutes, route)
 		}
 	}
 
 	// 按优先级排序路由（优先级高的在前）
 	if len(matchedRoutes) > 1 {
-		sort.Slice(matchedRoutes, func(i, j int) bool {
+		sort.SliceStable(matchedRoutes, func(i, j int) bool {
 			priorityI := utils.GetPatternPriority(matchedRoutes[i].URLSuffix)
 			priorityJ := utils.GetPatternPriority(matchedRoutes[j].URLSuffix)
-			return priorityI > priorityJ
+			if priorityI != priorityJ {
+				return priorityI > priorityJ
+			}
+			return matchedRoutes[i].ConditionCount() > matchedRoutes[j].ConditionCount()
 		})
 	}
+	return matchedRoutes
+}
 
-	if len(matchedRoutes) == 0 {
-		log.Printf("[8082 Direct] No route found for path: %s", urlPath)
-		http.Error(w, "Route not found", http.StatusNotFound)
-		return
+// matchHeaders 检查请求是否满足路由的所有请求头条件
+// 条件值为空时只要求请求头存在，否则要求任一同名请求头的值完全相等
+func matchHeaders(conditions map[string]string, header http.Header) bool {
+	for name, expected := range conditions {
+		values := header.Values(name)
+		if len(values) == 0 {
+			return false
+		}
+		if expected == "" {
+			continue
+		}
+		found := false
+		for _, value := range values {
+			if strings.TrimSpace(value) == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
 	}
+	return true
+}
 
-	log.Printf("[8082 Direct] Found %d matching routes for path: %s", len(matchedRoutes), urlPath)
-
-	// 选择第一个可用的路由
-	var selectedRoute *database.ServerRoute
-	for _, route := range matchedRoutes {
-		// 检查客户端是否连接且启用
-		if h.wsManager.IsClientConnected(route.ClientID) {
-			// 检查客户端是否启用
-			clientInfo, err := h.db.GetClient(route.ClientID)
-			if err != nil || clientInfo.Enabled != 1 {
-				log.Printf("[8082 Direct] Skipping disabled client: %s", route.ClientID)
-				continue // 跳过禁用的客户端
+// matchQuery 检查请求是否满足路由的所有查询参数条件
+// 条件值为空时只要求参数存在，否则要求任一同名参数的值完整匹配正则
+func matchQuery(conditions map[string]string, query url.Values) bool {
+	for name, pattern := range conditions {
+		values, exists := query[name]
+		if !exists {
+			return false
+		}
+		if pattern == "" {
+			continue
+		}
+		found := false
+		for _, value := range values {
+			if utils.MatchValuePattern(pattern, value) {
+				found = true
+				break
 			}
-			selectedRoute = route
-			log.Printf("[8082 Direct] Selected route with client: %s", route.ClientID)
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// selectTarget 按优先级依次尝试匹配的路由，返回路由和实际处理请求的客户端
+// 路径优先级和条件数量都相同的路由属于同一档，档内按权重随机选择，选中的路由不可用时在剩余路由中重新选择
+// 错误率过高的客户端先被跳过，所有路由都没有健康的客户端时才会使用
+func (h *Handler) selectTarget(matchedRoutes []*database.ServerRoute, logPrefix string) (*database.ServerRoute, string) {
+	for _, allowUnhealthy := range []bool{false, true} {
+		if allowUnhealthy && h.health == nil {
 			break
-		} else {
-			log.Printf("[8082 Direct] Client not connected: %s", route.ClientID)
 		}
+		for start := 0; start < len(matchedRoutes); {
+			end := start + 1
+			for end < len(matchedRoutes) && sameRouteTier(matchedRoutes[start], matchedRoutes[end]) {
+				end++
+			}
+			if route, clientID := h.selectFromTier(matchedRoutes[start:end], allowUnhealthy, logPrefix); route != nil {
+				return route, clientID
+			}
+			start = end
+		}
+		if !allowUnhealthy && h.health != nil {
+			proxyLog.Warnf("%s No healthy client available, falling back to unhealthy clients", logPrefix)
+		}
+	}
+	return nil, ""
+}
+
+// sameRouteTier 检查两条路由的选择优先级是否相同
+func sameRouteTier(a, b *database.ServerRoute) bool {
+	return utils.GetPatternPriority(a.URLSuffix) == utils.GetPatternPriority(b.URLSuffix) &&
+		a.ConditionCount() == b.ConditionCount()
+}
+
+// selectFromTier 在同一优先级的路由之间按权重随机选择一个可用的路由
+func (h *Handler) selectFromTier(tier []*database.ServerRoute, allowUnhealthy bool, logPrefix string) (*database.ServerRoute, string) {
+	candidates := append([]*database.ServerRoute(nil), tier...)
+	for len(candidates) > 0 {
+		index, totalWeight := pickWeighted(candidates)
+		route := candidates[index]
+
+		if route.IsGroupRoute() {
+			if clientID := h.pickGroupMember(route, allowUnhealthy, logPrefix); clientID != "" {
+				proxyLog.Infof("%s Selected route %d (weight %d/%d) with group %d member: %s", logPrefix, route.ID, routeWeight(route), totalWeight, route.GroupID, clientID)
+				return route, clientID
+			}
+		} else if h.isClientSelectable(route.ClientID, allowUnhealthy, logPrefix) {
+			proxyLog.Infof("%s Selected route %d (weight %d/%d) with client: %s", logPrefix, route.ID, routeWeight(route), totalWeight, route.ClientID)
+			return route, route.ClientID
+		}
+
+		candidates = append(candidates[:index], candidates[index+1:]...)
 	}
+	return nil, ""
+}
 
-	if selectedRoute == nil {
-		log.Printf("[8082 Direct] No available backend for path: %s", urlPath)
-		http.Error(w, "No available backend", http.StatusServiceUnavailable)
-		return
+// pickWeighted 按权重随机选择一条路由，返回下标和候选路由的总权重
+func pickWeighted(candidates []*database.ServerRoute) (int, int) {
+	totalWeight := 0
+	for _, route := range candidates {
+		totalWeight += routeWeight(route)
+	}
+	if len(candidates) == 1 {
+		return 0, totalWeight
 	}
 
-	// 转发请求到客户端
-	h.forwardRequestToClient(w, r, selectedRoute, urlPath)
+	n := rand.Intn(totalWeight)
+	for i, route := range candidates {
+		n -= routeWeight(route)
+		if n < 0 {
+			return i, totalWeight
+		}
+	}
+	return len(candidates) - 1, totalWeight
 }
 
-// forwardRequestToClient 转发请求到客户端的公共函数
-func (h *Handler) forwardRequestToClient(w http.ResponseWriter, r *http.Request, selectedRoute *database.ServerRoute, urlPath string) {
-	// 读取请求体
-	body := make([]byte, 0)
-	if r.Body != nil {
-		body, _ = io.ReadAll(r.Body)
-		r.Body.Close()
+// routeWeight 路由的有效权重，未设置时使用默认权重
+func routeWeight(route *database.ServerRoute) int {
+	if route.Weight <= 0 {
+		return database.DefaultRouteWeight
+	}
+	return route.Weight
+}
+
+// isClientAvailable 检查客户端是否已连接且启用
+func (h *Handler) isClientAvailable(clientID string, logPrefix string) bool {
+	if !h.wsManager.IsClientConnected(clientID) {
+		proxyLog.Infof("%s Client not connected: %s", logPrefix, clientID)
+		return false
+	}
+
+	clientInfo, err := h.db.GetClient(clientID)
+	if err != nil || clientInfo.Enabled != 1 {
+		proxyLog.Warnf("%s Skipping disabled client: %s", logPrefix, clientID)
+		return false
+	}
+	return true
+}
+
+// isClientSelectable 检查客户端是否可用，allowUnhealthy 为false时还要求客户端没有因错误率过高被暂停
+func (h *Handler) isClientSelectable(clientID string, allowUnhealthy bool, logPrefix string) bool {
+	if !allowUnhealthy && !h.health.Healthy(clientID) {
+		proxyLog.Warnf("%s Skipping unhealthy client: %s", logPrefix, clientID)
+		return false
+	}
+	return h.isClientAvailable(clientID, logPrefix)
+}
+
+// pickGroupMember 在分组的可用成员之间选择一个客户端
+// 按延迟选择时优先选择最近平均响应延迟最低的成员，有成员没有最近的测量数据时退回轮询，使其重新得到测量
+func (h *Handler) pickGroupMember(route *database.ServerRoute, allowUnhealthy bool, logPrefix string) string {
+	members, err := h.db.GetClientGroupMembers(route.GroupID)
+	if err != nil {
+		proxyLog.Errorf("%s Failed to load members of group %d: %v", logPrefix, route.GroupID, err)
+		return ""
+	}
+
+	available := make([]string, 0, len(members))
+	for _, clientID := range members {
+		if h.isClientSelectable(clientID, allowUnhealthy, logPrefix) {
+			available = append(available, clientID)
+		}
 	}
+	if len(available) == 0 {
+		proxyLog.Infof("%s No online member in group %d", logPrefix, route.GroupID)
+		return ""
+	}
+
+	if h.config == nil || h.config.ProxyGroupSelection != config.GroupSelectionRoundRobin {
+		if clientID, latency, ok := h.lowestLatencyMember(available); ok {
+			proxyLog.Infof("%s Group %d member %s has the lowest recent latency: %v", logPrefix, route.GroupID, clientID, latency)
+			return clientID
+		}
+	}
+
+	h.mu.Lock()
+	index := h.roundRobin[route.ID]
+	h.roundRobin[route.ID] = index + 1
+	h.mu.Unlock()
+
+	return available[index%uint64(len(available))]
+}
+
+// lowestLatencyMember 返回最近平均响应延迟最低的成员，任一成员的测量数据缺失或过期时返回false
+func (h *Handler) lowestLatencyMember(members []string) (string, time.Duration, bool) {
+	if len(members) < 2 {
+		return "", 0, false
+	}
+
+	best := ""
+	var bestLatency time.Duration
+	for _, clientID := range members {
+		latency, ok := h.wsManager.ClientLatency(clientID)
+		if !ok {
+			return "", 0, false
+		}
+		if best == "" || latency < bestLatency {
+			best, bestLatency = clientID, latency
+		}
+	}
+	return best, bestLatency, true
+}
 
-	// 构建请求消息
+// newRequestPayload 构建发送给客户端的请求消息
+func (h *Handler) newRequestPayload(r *http.Request, route *database.ServerRoute, urlPath string, body []byte) *protocol.RequestPayload {
 	requestPayload := &protocol.RequestPayload{
 		HTTPMethod:     r.Method,
 		URLSuffix:      urlPath,
 		Headers:        make(map[string]string),
 		Body:           string(body),
-		TargetsJSON:    selectedRoute.TargetsJSON,
-		DeliveryPolicy: selectedRoute.DeliveryPolicy,
-		RouteMode:      selectedRoute.RouteMode,
+		TargetsJSON:    route.TargetsJSON,
+		DeliveryPolicy: route.DeliveryPolicy,
+		RouteMode:      route.RouteMode,
+		Cacheable:      route.Cacheable,
+
+		TimeoutMS:         route.TotalTimeoutMS,
+		ConnectTimeoutMS:  route.ConnectTimeoutMS,
+		HeaderTimeoutMS:   route.HeaderTimeoutMS,
+		BodyIdleTimeoutMS: route.BodyIdleTimeoutMS,
+
+		Compression: h.payloadCompression(route),
 	}
 
-	// 复制请求头
+	// 复制请求头，Expect 已由代理服务器处理，不再转发给后端
 	for name, values := range r.Header {
+		if name == "Expect" {
+			continue
+		}
 		if len(values) > 0 {
 			requestPayload.Headers[name] = values[0]
 		}
 	}
+	return requestPayload
+}
+
+// routeTimeout 等待客户端响应的时间，路由设置了总超时时使用路由的设置
+func routeTimeout(route *database.ServerRoute) time.Duration {
+	if route.TotalTimeoutMS > 0 {
+		return time.Duration(route.TotalTimeoutMS) * time.Millisecond
+	}
+	return proxyRequestTimeout
+}
+
+// exchangeResult 一次转发的结果
+type exchangeResult struct {
+	response *protocol.ResponsePayload
+	route    *database.ServerRoute
+	clientID string
+	err      error
+}
+
+// exchange 发送请求并等待响应，返回实际处理请求的路由和客户端
+// 路由开启对冲且请求方法是安全方法时，超过对冲延迟仍未响应则向备用客户端发送相同请求，
+// 采用先成功返回的响应并取消另一个请求
+// 带幂等键的请求不对冲，键只能由一条待处理消息占用
+func (h *Handler) exchange(r *http.Request, route *database.ServerRoute, clientID string, urlPath string, body []byte, matchedRoutes []*database.ServerRoute, idempotencyKey string) exchangeResult {
+	if route.HedgeDelayMS <= 0 || !isSafeMethod(r.Method) || idempotencyKey != "" {
+		ctx := r.Context()
+		if idempotencyKey != "" {
+			ctx = websocket.WithIdempotencyKey(ctx, idempotencyKey)
+		}
+		// 幂等键保存完整响应用于重放，不使用分片传输
+		payload := h.newRequestPayload(r, route, urlPath, body)
+		if idempotencyKey == "" {
+			payload = h.clientRequestPayload(r, route, clientID, urlPath, body)
+		}
+		response, err := h.wsManager.SendRequestAndWaitContext(ctx, clientID, payload, routeTimeout(route))
+		return exchangeResult{response: response, route: route, clientID: clientID, err: err}
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel() // 返回时取消仍未完成的请求
+
+	results := make(chan exchangeResult, 2)
+	send := func(route *database.ServerRoute, clientID string) {
+		go func() {
+			response, err := h.wsManager.SendRequestAndWaitContext(ctx, clientID, h.clientRequestPayload(r, route, clientID, urlPath, body), routeTimeout(route))
+			results <- exchangeResult{response: response, route: route, clientID: clientID, err: err}
+		}()
+	}
+	send(route, clientID)
+	inFlight := 1
+
+	timer := time.NewTimer(time.Duration(route.HedgeDelayMS) * time.Millisecond)
+	defer timer.Stop()
+
+	var failed exchangeResult
+	for inFlight > 0 {
+		select {
+		case result := <-results:
+			inFlight--
+			if result.err == nil {
+				if result.clientID != clientID {
+					proxyLog.Infof("[HTTP Proxy] Hedged request to client %s answered first", result.clientID)
+				}
+				return result
+			}
+			failed = result
+			if timer.Stop() {
+				// 对冲请求发出前原请求已失败，不再发送对冲请求
+				return failed
+			}
+		case <-timer.C:
+			hedgeRoute, hedgeClientID := h.selectAlternate(matchedRoutes, route, clientID)
+			if hedgeRoute == nil {
+				proxyLog.Warnf("[HTTP Proxy] No alternate client to hedge request for route %d", route.ID)
+				continue
+			}
+			proxyLog.Infof("[HTTP Proxy] No response from client %s after %dms, hedging to client %s (route %d)", clientID, route.HedgeDelayMS, hedgeClientID, hedgeRoute.ID)
+			send(hedgeRoute, hedgeClientID)
+			inFlight++
+		}
+	}
+	return failed
+}
+
+// selectAlternate 为对冲请求选择一个不同于原客户端的备用客户端
+// 分组路由优先选择同组的其他成员，否则按优先级依次查找其他匹配路由的可用客户端
+func (h *Handler) selectAlternate(matchedRoutes []*database.ServerRoute, route *database.ServerRoute, clientID string) (*database.ServerRoute, string) {
+	if route.IsGroupRoute() {
+		if members, err := h.db.GetClientGroupMembers(route.GroupID); err == nil {
+			for _, member := range members {
+				if member != clientID && h.isHedgeTarget(member) {
+					return route, member
+				}
+			}
+		}
+	}
+
+	for _, candidate := range matchedRoutes {
+		if candidate.ID == route.ID {
+			continue
+		}
+		if candidate.IsGroupRoute() {
+			if members, err := h.db.GetClientGroupMembers(candidate.GroupID); err == nil {
+				for _, member := range members {
+					if member != clientID && h.isHedgeTarget(member) {
+						return candidate, member
+					}
+				}
+			}
+			continue
+		}
+		if candidate.ClientID != clientID && h.isHedgeTarget(candidate.ClientID) {
+			return candidate, candidate.ClientID
+		}
+	}
+	return nil, ""
+}
+
+// isHedgeTarget 客户端能否接收对冲请求，与原客户端一样需要可用且未超出配额
+func (h *Handler) isHedgeTarget(clientID string) bool {
+	const logPrefix = "[HTTP Proxy]"
+	if !h.isClientSelectable(clientID, false, logPrefix) {
+		return false
+	}
+	if violation := h.quota.Check(clientID); violation != nil {
+		proxyLog.Infof("%s Skipping client %s for hedged request: %v", logPrefix, clientID, violation)
+		return false
+	}
+	return true
+}
+
+// isSafeMethod 检查请求方法是否可以安全地重复发送
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+// forwardRequestToClient 转发请求到客户端的公共函数
+func (h *Handler) forwardRequestToClient(w http.ResponseWriter, r *http.Request, selectedRoute *database.ServerRoute, clientID string, urlPath string, matchedRoutes []*database.ServerRoute) {
+	// 读取请求体，文件上传以分片方式流式转发，不读入内存
+	body := make([]byte, 0)
+	var upload *countingReader
+	if h.streamsUpload(r, clientID) {
+		upload = &countingReader{r: r.Body}
+	} else if r.Body != nil {
+		body, _ = io.ReadAll(r.Body)
+		r.Body.Close()
+	}
+
+	// 相同幂等键的重试直接返回保存的响应，流式上传的请求体不保存，不支持幂等键
+	idempotencyKey := ""
+	if upload == nil {
+		idempotencyKey = h.idempotencyKey(r, selectedRoute)
+	}
+	if idempotencyKey != "" && h.replayIdempotent(w, r, selectedRoute, idempotencyKey, urlPath, body) {
+		return
+	}
+
+	// 路由的总超时超过代理服务器写超时时，延长写超时以便等到响应
+	if timeout := routeTimeout(selectedRoute); timeout > proxyRequestTimeout {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + proxyRequestTimeout))
+	}
+
+	// 开启抓取的路由记录完整的请求和响应
+	capture := h.newCapture(w, r, selectedRoute, urlPath, body, upload != nil)
 
 	// 发送请求并等待响应
-	log.Printf("[HTTP Proxy] Sending request to client %s for path: %s", selectedRoute.ClientID, urlPath)
-	response, err := h.wsManager.SendRequestAndWait(selectedRoute.ClientID, requestPayload, 30*time.Second)
+	detailLogf(r, "[HTTP Proxy] Sending request to client %s for path: %s", clientID, urlPath)
+	startTime := time.Now()
+	var result exchangeResult
+	if upload != nil {
+		result = h.exchangeStreamed(w, r, selectedRoute, clientID, urlPath, upload)
+	} else {
+		result = h.exchange(r, selectedRoute, clientID, urlPath, body, matchedRoutes, idempotencyKey)
+	}
+	response, err := result.response, result.err
+	if err == websocket.ErrIdempotencyKeyInUse {
+		// 并发的相同请求已占用幂等键
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+	selectedRoute, clientID = result.route, result.clientID
+	record := monitoring.TrafficRecord{
+		OrgID:     selectedRoute.OrgID,
+		RouteID:   selectedRoute.ID,
+		URLSuffix: selectedRoute.URLSuffix,
+		ClientID:  clientID,
+		Latency:   time.Since(startTime),
+		BytesIn:   int64(len(body)),
+	}
+	if upload != nil {
+		record.BytesIn = upload.n
+	}
 	if err != nil {
-		log.Printf("[HTTP Proxy] Failed to send request to client %s: %v", selectedRoute.ClientID, err)
-		http.Error(w, "Backend request failed", http.StatusBadGateway)
+		record.Status = http.StatusBadGateway
+		failure := ""
+		if errors.Is(err, websocket.ErrRequestTimeout) {
+			failure = monitoring.ProxyFailureTimeout
+		}
+		h.recordTraffic(record, failure)
+		h.quota.Record(clientID, record.BytesIn)
+		h.health.Record(clientID, true)
+		h.finishCapture(capture, selectedRoute, clientID, http.StatusBadGateway, nil, nil, 0, err)
+		proxyLog.Errorf("[HTTP Proxy] Failed to send request to client %s: %v", clientID, err)
+		utils.WriteError(w, r, http.StatusBadGateway, utils.ErrCodeBadGateway, "Backend request failed")
 		return
 	}
 
-	log.Printf("[HTTP Proxy] Received response from client %s - Status: %d", selectedRoute.ClientID, response.HTTPStatus)
+	detailLogf(r, "[HTTP Proxy] Received response from client %s - Status: %d", clientID, response.HTTPStatus)
+	if h.dropFault(selectedRoute, response) {
+		h.quota.Record(clientID, record.BytesIn)
+		h.finishCapture(capture, selectedRoute, clientID, response.HTTPStatus, response.Headers, nil, 0, errFaultDropped)
+		proxyLog.Warnf("[HTTP Proxy] Dropping response from client %s for route %d by fault injection", clientID, selectedRoute.ID)
+		panic(http.ErrAbortHandler)
+	}
 	
 	// 打印响应详情
 	bodyPreview := ""
@@ -253,54 +807,147 @@ func (h *Handler) forwardRequestToClient(w http.ResponseWriter, r *http.Request,
 		}
 	}
 	
-	log.Printf("[HTTP Proxy] Response details - Headers: %v, Body length: %d bytes", response.Headers, bodyLength)
-	log.Printf("[HTTP Proxy] Response body preview: %s", bodyPreview)
+	detailLogf(r, "[HTTP Proxy] Response details - Headers: %v, Body length: %d bytes", logging.RedactHeaders(response.Headers), bodyLength)
+	detailLogf(r, "[HTTP Proxy] Response body preview: %s", logging.RedactBody(bodyPreview))
+
+	// 大响应体由客户端分片发送
+	if response.TransferID != "" {
+		written, err := h.writeTransfer(w, r, selectedRoute, response)
+		record.Status = response.HTTPStatus
+		record.BytesOut = written
+		h.recordTraffic(record, "")
+		h.quota.Record(clientID, record.BytesIn+record.BytesOut)
+		h.health.Record(clientID, err != nil || response.HTTPStatus >= http.StatusInternalServerError)
+		h.finishCapture(capture, selectedRoute, clientID, response.HTTPStatus, response.Headers, nil, written, err)
+		if err != nil {
+			// 响应头已发送，只能中断连接让调用方感知响应不完整
+			proxyLog.Errorf("[HTTP Proxy] Response transfer %s from client %s failed after %d bytes: %v", response.TransferID, clientID, written, err)
+			panic(http.ErrAbortHandler)
+		}
+		detailLogf(r, "[HTTP Proxy] Successfully streamed %d bytes to HTTP response for path: %s", written, urlPath)
+		return
+	}
+
+	bytesWritten := h.writeResponse(w, r, selectedRoute, responseHeader(response.Headers, response.HeaderValues), response.HTTPStatus, response.Body, response.Trailers)
+	detailLogf(r, "[HTTP Proxy] Successfully wrote %d bytes to HTTP response for path: %s", bytesWritten, urlPath)
+
+	record.Status = response.HTTPStatus
+	record.BytesOut = int64(bytesWritten)
+	h.recordTraffic(record, "")
+	h.quota.Record(clientID, record.BytesIn+record.BytesOut)
+	h.health.Record(clientID, response.HTTPStatus >= http.StatusInternalServerError)
+	var backendErr error
+	if response.Error != nil {
+		backendErr = errors.New(*response.Error)
+	}
+	h.finishCapture(capture, selectedRoute, clientID, response.HTTPStatus, response.Headers, response.Body, 0, backendErr)
+
+	// 如果有错误，记录日志
+	if response.Error != nil {
+		proxyLog.Errorf("[HTTP Proxy] Backend returned error: %s", *response.Error)
+	}
+}
+
+// recordTraffic 记录代理请求的流量和按状态码的计数，failure 为 monitoring.ProxyFailure* 或空
+func (h *Handler) recordTraffic(record monitoring.TrafficRecord, failure string) {
+	h.traffic.Record(record)
+	h.metrics.RecordProxyRequest(record.RouteID, record.Status, failure)
+}
+
+// writeResponse 写入客户端返回的响应头、状态码、响应体和trailer，返回写入的字节数
+func (h *Handler) writeResponse(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, header http.Header, status int, responseBody interface{}, trailers map[string]string) int {
+	var body []byte
+	switch value := responseBody.(type) {
+	case nil:
+	case string:
+		body = []byte(value)
+	case []byte:
+		body = value
+	default:
+		// 对于其他类型，尝试转换为字符串
+		body = []byte(fmt.Sprintf("%v", value))
+	}
 
 	// 设置响应头
-	for name, value := range response.Headers {
-		w.Header().Set(name, value)
-		log.Printf("[HTTP Proxy] Setting response header: %s = %s", name, value)
+	for name, values := range header {
+		w.Header()[name] = values
+		detailLogf(r, "[HTTP Proxy] Setting response header: %s = %s", name, logging.RedactHeader(name, strings.Join(values, "; ")))
+	}
+	body = h.compressResponse(r, route, w.Header(), status, body)
+	if len(trailers) > 0 {
+		// trailer 需要分块编码发送
+		declareTrailers(w.Header(), trailers)
+		w.Header().Del("Content-Length")
 	}
 
 	// 设置状态码
-	log.Printf("[HTTP Proxy] Setting response status code: %d", response.HTTPStatus)
-	w.WriteHeader(response.HTTPStatus)
+	detailLogf(r, "[HTTP Proxy] Setting response status code: %d", status)
+	w.WriteHeader(status)
 
 	// 写入响应体
-	bytesWritten := 0
-	if response.Body != nil {
-		switch body := response.Body.(type) {
-		case string:
-			bytesWritten, _ = w.Write([]byte(body))
-		case []byte:
-			bytesWritten, _ = w.Write(body)
-		default:
-			// 对于其他类型，尝试转换为字符串
-			if bodyStr := fmt.Sprintf("%v", body); bodyStr != "" {
-				bytesWritten, _ = w.Write([]byte(bodyStr))
-			}
+	bytesWritten, _ := w.Write(body)
+	writeTrailers(w.Header(), trailers)
+	return bytesWritten
+}
+
+// responseHeader 把客户端返回的响应头转换为 http.Header，名称统一为规范格式
+// 新版本客户端发送完整的多值响应头，旧版本只有每个名称一个值
+func responseHeader(headers map[string]string, values map[string][]string) http.Header {
+	header := make(http.Header, len(headers))
+	if values != nil {
+		for name, v := range values {
+			key := http.CanonicalHeaderKey(name)
+			header[key] = append(header[key], v...)
 		}
+		return header
 	}
-	
-	log.Printf("[HTTP Proxy] Successfully wrote %d bytes to HTTP response for path: %s", bytesWritten, urlPath)
+	for name, value := range headers {
+		header.Set(name, value)
+	}
+	return header
+}
 
-	// 如果有错误，记录日志
-	if response.Error != nil {
-		log.Printf("[HTTP Proxy] Backend returned error: %s", *response.Error)
+// declareTrailers 在响应头中声明将要发送的trailer，已声明的不重复添加
+func declareTrailers(header http.Header, trailers map[string]string) {
+	declared := declaredTrailers(header)
+	for name := range trailers {
+		if !declared[http.CanonicalHeaderKey(name)] {
+			header.Add("Trailer", name)
+		}
+	}
+}
+
+// writeTrailers 响应体写完后设置trailer的值，未在响应头中声明的trailer通过 http.TrailerPrefix 发送
+func writeTrailers(header http.Header, trailers map[string]string) {
+	declared := declaredTrailers(header)
+	for name, value := range trailers {
+		if declared[http.CanonicalHeaderKey(name)] {
+			header.Set(name, value)
+		} else {
+			header.Set(http.TrailerPrefix+name, value)
+		}
 	}
 }
 
-// HandleFileUpload 处理文件上传
-func (h *Handler) HandleFileUpload(w http.ResponseWriter, r *http.Request) {
-	// 文件上传处理逻辑
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("File upload handled"))
+// declaredTrailers 响应头 Trailer 中声明的名称
+func declaredTrailers(header http.Header) map[string]bool {
+	declared := make(map[string]bool)
+	for _, value := range header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			declared[http.CanonicalHeaderKey(strings.TrimSpace(name))] = true
+		}
+	}
+	return declared
 }
 
 // GetStats 获取代理统计信息
 func (h *Handler) GetStats() map[string]interface{} {
 	return map[string]interface{}{
-		"total_requests": 0,
-		"active_routes":  0,
+		"total_requests":          0,
+		"active_routes":           0,
+		"compressed_responses":    h.compression.responses.Load(),
+		"compression_bytes_in":    h.compression.bytesIn.Load(),
+		"compression_bytes_out":   h.compression.bytesOut.Load(),
+		"compression_bytes_saved": h.compression.bytesIn.Load() - h.compression.bytesOut.Load(),
 	}
 }
\ No newline at end of file
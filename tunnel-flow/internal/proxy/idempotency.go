@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 幂等请求
+// 带 Idempotency-Key 请求头的请求在转发时占用该键（保存在待处理消息中），
+// 窗口期内用相同的键重试时直接返回保存的响应；原请求没有得到响应（超时、转发失败）时释放键，允许重新执行
+
+// maxIdempotencyKeyLength 幂等键的最大长度
+const maxIdempotencyKeyLength = 255
+
+// idempotencyKey 返回请求的幂等键（带组织前缀，不同组织的键互不影响），未携带或功能关闭时返回空字符串
+func (h *Handler) idempotencyKey(r *http.Request, route *database.ServerRoute) string {
+	key := r.Header.Get("Idempotency-Key")
+	if key == "" || h.config == nil || h.config.ProxyIdempotencyWindowSeconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", route.OrgID, key)
+}
+
+// replayIdempotent 处理已经用过的幂等键，返回true表示已写入响应
+// 保存有响应时原样返回，原请求仍在处理时返回409，请求内容与原请求不同时返回422
+func (h *Handler) replayIdempotent(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, key string, urlPath string, body []byte) bool {
+	if len(key) > maxIdempotencyKeyLength {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Idempotency-Key is too long")
+		return true
+	}
+
+	msg, err := h.db.GetPendingMessageByIdempotencyKey(key)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		proxyLog.Errorf("[HTTP Proxy] Failed to look up idempotency key: %v", err)
+		return false
+	}
+
+	window := time.Duration(h.config.ProxyIdempotencyWindowSeconds) * time.Second
+	if time.Since(time.UnixMilli(msg.CreatedAt)) > window {
+		h.releaseIdempotencyKey(msg.MsgID)
+		return false
+	}
+
+	if meta, err := msg.GetRequestMeta(); err != nil || meta.HTTPMethod != r.Method || msg.URLSuffix != urlPath || fmt.Sprint(meta.Body) != string(body) {
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, utils.ErrCodeIdempotency, "Idempotency-Key was already used for a different request")
+		return true
+	}
+
+	response, err := msg.GetResponseMeta()
+	if err != nil {
+		proxyLog.Errorf("[HTTP Proxy] Failed to decode stored response for %s: %v", msg.MsgID, err)
+	}
+	if response != nil && err == nil {
+		proxyLog.Infof("[HTTP Proxy] Replaying stored response of %s for idempotent request", msg.MsgID)
+		w.Header().Set("Idempotent-Replayed", "true")
+		h.writeResponse(w, r, route, responseHeader(response.Headers, response.HeaderValues), response.HTTPStatus, response.Body, response.Trailers)
+		return true
+	}
+
+	switch msg.State {
+	case database.MessageStatePending, database.MessageStateProcessing:
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "A request with this Idempotency-Key is still being processed")
+		return true
+	}
+
+	// 原请求没有得到响应，允许重新执行
+	h.releaseIdempotencyKey(msg.MsgID)
+	return false
+}
+
+// releaseIdempotencyKey 释放幂等键，失败只记录日志
+func (h *Handler) releaseIdempotencyKey(msgID string) {
+	if err := h.db.ReleaseIdempotencyKey(msgID); err != nil {
+		proxyLog.Errorf("[HTTP Proxy] Failed to release idempotency key of %s: %v", msgID, err)
+	}
+}
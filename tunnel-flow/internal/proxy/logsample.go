@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/logging"
+)
+
+// detailLogKey 请求上下文中是否记录详细日志的标记
+type detailLogKey struct{}
+
+// newLogSampler 按配置创建代理详细日志的采样器，未配置采样时返回nil
+func newLogSampler(cfg *config.Config) *logging.Sampler {
+	if cfg == nil || cfg.LogSampleInitial <= 0 {
+		return nil
+	}
+	return logging.NewSampler(cfg.LogSampleInitial, cfg.LogSampleThereafter)
+}
+
+// sampleDetailLog 按路由采样，决定这次请求是否记录转发过程的详细日志
+func (h *Handler) sampleDetailLog(r *http.Request, routeID int) *http.Request {
+	if h.logSampler.Allow(strconv.Itoa(routeID)) {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), detailLogKey{}, false))
+}
+
+// detailLogf 记录请求的详细日志，请求被采样跳过时不记录
+func detailLogf(r *http.Request, format string, args ...interface{}) {
+	if enabled, ok := r.Context().Value(detailLogKey{}).(bool); ok && !enabled {
+		return
+	}
+	proxyLog.Infof(format, args...)
+}
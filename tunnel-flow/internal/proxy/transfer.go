@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/websocket"
+)
+
+// errTransferGone 响应体传输已超时放弃
+var errTransferGone = errors.New("response transfer no longer available")
+
+// errStreamIdle 超过路由的 stream_idle_timeout_ms 没有收到响应体分片
+var errStreamIdle = errors.New("no response chunk received within stream idle timeout")
+
+// errStreamTotal 响应体超过路由的 stream_total_timeout_ms 仍未结束
+var errStreamTotal = errors.New("response stream exceeded stream total timeout")
+
+// clientRequestPayload 构建发往指定客户端的请求载荷，客户端支持时大响应体以可续传分片返回
+func (h *Handler) clientRequestPayload(r *http.Request, route *database.ServerRoute, clientID string, urlPath string, body []byte) *protocol.RequestPayload {
+	payload := h.newRequestPayload(r, route, urlPath, body)
+	payload.StreamResponse = h.wsManager.ClientHasCapability(clientID, protocol.CapabilityResumableTransfer)
+	return payload
+}
+
+// writeTransfer 接收客户端分片发送的响应体并边收边写，返回写入的字节数
+// 响应体不压缩；客户端连接中断时等待其重连后从已接收的偏移量继续
+// 响应体不受路由的 total_timeout_ms 限制，只按路由的流式超时设置中断
+func (h *Handler) writeTransfer(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, response *protocol.ResponsePayload) (int64, error) {
+	transfer := h.wsManager.AcceptTransfer(response.TransferID)
+	if transfer == nil {
+		return 0, errTransferGone
+	}
+	completed := false
+	defer func() {
+		h.wsManager.CloseTransfer(response.TransferID, completed)
+	}()
+
+	for name, values := range responseHeader(response.Headers, response.HeaderValues) {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.HTTPStatus)
+
+	// 大文件下载耗时不受代理服务器写超时限制
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	ctx := r.Context()
+	if route.StreamTotalTimeoutMS > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, time.Duration(route.StreamTotalTimeoutMS)*time.Millisecond, errStreamTotal)
+		defer cancel()
+	}
+	idle := time.Duration(route.StreamIdleTimeoutMS) * time.Millisecond
+
+	var written int64
+	for {
+		data, eof, err := nextChunk(ctx, transfer, idle)
+		if err != nil {
+			return written, err
+		}
+		if len(data) > 0 {
+			n, err := w.Write(data)
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+			if err := controller.Flush(); err != nil {
+				return written, err
+			}
+		}
+		// 最后一个分片也需要确认，代理据此结束传输
+		transfer.Ack()
+		if eof {
+			completed = true
+			writeTrailers(w.Header(), transfer.Trailers())
+			return written, nil
+		}
+	}
+}
+
+// nextChunk 等待下一段响应体数据，idle 大于0时每段数据单独计时，超时返回 errStreamIdle
+func nextChunk(ctx context.Context, transfer *websocket.Transfer, idle time.Duration) ([]byte, bool, error) {
+	if idle > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, idle, errStreamIdle)
+		defer cancel()
+	}
+	data, eof, err := transfer.Next(ctx)
+	if err != nil && ctx.Err() != nil {
+		err = context.Cause(ctx)
+	}
+	return data, eof, err
+}
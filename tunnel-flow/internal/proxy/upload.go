@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+)
+
+// 流式上传
+// multipart/form-data 请求体不整体读入内存，而是分片流式发送给支持的代理，二进制文件和大文件可以完整到达后端；
+// 带 Expect: 100-continue 的请求（curl -T 和 S3 类SDK的大文件PUT）同样流式转发。
+// 100 Continue 由HTTP服务器在开始读取请求体时自动发送，此时路由和客户端已经选定，
+// 匹配失败等提前拒绝的请求直接返回最终响应，调用方不会发送请求体。
+// 不支持分片接收的旧版代理仍按原方式转发
+
+// uploadPathPrefix 文件上传入口的路径前缀，/upload/api/files 按路由 /api/files 转发
+const uploadPathPrefix = "/upload"
+
+// HandleFileUpload 处理文件上传，只接受 multipart/form-data 请求，按路由转发并流式发送请求体
+func (h *Handler) HandleFileUpload(w http.ResponseWriter, r *http.Request) {
+	proxyLog.Infof("[8082 Upload] Received %s request: %s from %s", r.Method, r.URL.Path, remoteDescription(r))
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		utils.WriteError(w, r, http.StatusMethodNotAllowed, utils.ErrCodeMethodNotAllowed, "Uploads must use POST or PUT")
+		return
+	}
+	if !isMultipartUpload(r) {
+		utils.WriteError(w, r, http.StatusUnsupportedMediaType, utils.ErrCodeBadRequest, "Uploads must be multipart/form-data")
+		return
+	}
+	urlPath := strings.TrimPrefix(r.URL.Path, uploadPathPrefix)
+	if urlPath == "" || urlPath == "/" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Upload path must include the route path, e.g. /upload/api/files")
+		return
+	}
+
+	h.dispatch(w, r, urlPath, "[8082 Upload]")
+}
+
+// isMultipartUpload 检查请求是否为 multipart/form-data 上传
+func isMultipartUpload(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data"
+}
+
+// expectsContinue 检查请求是否带 Expect: 100-continue
+func expectsContinue(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Expect"), "100-continue")
+}
+
+// streamsUpload 检查请求体是否以分片方式流式转发给客户端
+func (h *Handler) streamsUpload(r *http.Request, clientID string) bool {
+	if r.Body == nil || r.Body == http.NoBody || !(isMultipartUpload(r) || expectsContinue(r)) {
+		return false
+	}
+	client, err := h.db.GetClient(clientID)
+	if err != nil || !client.HasCapability(protocol.CapabilityStreamUpload) {
+		proxyLog.Infof("[HTTP Proxy] Client %s does not support streamed uploads, buffering request body", clientID)
+		return false
+	}
+	return true
+}
+
+// countingReader 统计已读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// exchangeStreamed 发送请求并流式发送请求体，然后等待响应；上传请求不对冲
+func (h *Handler) exchangeStreamed(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, clientID string, urlPath string, body io.Reader) exchangeResult {
+	// 上传耗时不受代理服务器读写超时限制，等待响应仍受路由总超时限制
+	controller := http.NewResponseController(w)
+	controller.SetReadDeadline(time.Time{})
+	controller.SetWriteDeadline(time.Time{})
+
+	response, err := h.wsManager.SendStreamingRequestAndWait(r.Context(), clientID, h.clientRequestPayload(r, route, clientID, urlPath, nil), body, routeTimeout(route))
+	controller.SetWriteDeadline(time.Now().Add(proxyRequestTimeout))
+	return exchangeResult{response: response, route: route, clientID: clientID, err: err}
+}
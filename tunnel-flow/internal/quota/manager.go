@@ -0,0 +1,383 @@
+package quota
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"tunnel-flow/internal/database"
+)
+
+// warningPercent 用量达到配额的该百分比时发送告警
+const warningPercent = 80
+
+// 配额指标
+const (
+	MetricRequestsPerDay = "requests_per_day"
+	MetricBytesPerMonth  = "bytes_per_month"
+)
+
+// Violation 超出配额的原因，由代理转换为错误响应
+type Violation struct {
+	Status     int    // 429 请求数超限，402 流量超限
+	Metric     string // 超限的指标
+	Limit      int64
+	Used       int64
+	RetryAfter time.Duration // 距离计数器重置的时间
+}
+
+// Error 实现 error 接口
+func (v *Violation) Error() string {
+	return fmt.Sprintf("%s quota exceeded: %d/%d", v.Metric, v.Used, v.Limit)
+}
+
+// Usage 客户端当前周期的用量
+type Usage struct {
+	RequestsToday  int64 `json:"requests_today"`
+	BytesThisMonth int64 `json:"bytes_this_month"`
+	DayStart       int64 `json:"day_start"`      // 当日周期起点（毫秒，UTC零点）
+	DayResetAt     int64 `json:"day_reset_at"`   // 请求计数重置时间（毫秒）
+	MonthStart     int64 `json:"month_start"`    // 当月周期起点（毫秒）
+	MonthResetAt   int64 `json:"month_reset_at"` // 流量计数重置时间（毫秒）
+}
+
+// WarningEvent 用量告警 webhook 的请求体
+type WarningEvent struct {
+	Event       string  `json:"event"` // 固定为 quota.warning
+	ClientID    string  `json:"client_id"`
+	Metric      string  `json:"metric"`
+	Limit       int64   `json:"limit"`
+	Used        int64   `json:"used"`
+	Percent     float64 `json:"percent"`
+	PeriodStart int64   `json:"period_start"`
+	PeriodEnd   int64   `json:"period_end"`
+	Timestamp   int64   `json:"timestamp"`
+}
+
+// clientUsage 客户端在当前周期内的计数
+type clientUsage struct {
+	requests       int64
+	bytes          int64
+	warnedRequests bool // 本日已发送过请求数告警
+	warnedBytes    bool // 本月已发送过流量告警
+}
+
+// Manager 客户端用量配额管理
+// 计数保存在内存中，启动时从流量小时汇总表恢复当前周期的用量；
+// 请求数按UTC自然日、流量按UTC自然月统计，到期自动清零
+type Manager struct {
+	db         *database.Repository
+	webhookURL string
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu         sync.Mutex
+	quotas     map[string]*database.ClientQuota
+	usage      map[string]*clientUsage
+	dayStart   time.Time
+	monthStart time.Time
+}
+
+// NewManager 创建配额管理器，webhookURL 为全局告警地址
+func NewManager(db *database.Repository, webhookURL string) *Manager {
+	m := &Manager{
+		db:         db,
+		webhookURL: webhookURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		now:        time.Now,
+		quotas:     make(map[string]*database.ClientQuota),
+		usage:      make(map[string]*clientUsage),
+	}
+	m.dayStart, m.monthStart = periodStarts(m.now())
+	return m
+}
+
+// periodStarts 返回 t 所在UTC自然日和自然月的起点
+func periodStarts(t time.Time) (time.Time, time.Time) {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC),
+		time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Load 加载配额配置并从流量汇总表恢复当前周期的用量
+func (m *Manager) Load() error {
+	if m == nil {
+		return nil
+	}
+
+	quotas, err := m.db.ListClientQuotas(0)
+	if err != nil {
+		return err
+	}
+
+	now := m.now()
+	dayStart, monthStart := periodStarts(now)
+	to := now.Add(time.Hour).UnixMilli()
+
+	daily, err := m.db.QueryTrafficRollups(database.TrafficFilter{From: dayStart.UnixMilli(), To: to}, database.TrafficGroupByClient)
+	if err != nil {
+		return err
+	}
+	monthly, err := m.db.QueryTrafficRollups(database.TrafficFilter{From: monthStart.UnixMilli(), To: to}, database.TrafficGroupByClient)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dayStart, m.monthStart = dayStart, monthStart
+	m.quotas = make(map[string]*database.ClientQuota, len(quotas))
+	for _, quota := range quotas {
+		m.quotas[quota.ClientID] = quota
+	}
+	m.usage = make(map[string]*clientUsage)
+	for _, item := range daily {
+		if item.ClientID != "" {
+			m.usageFor(item.ClientID).requests = item.Requests
+		}
+	}
+	for _, item := range monthly {
+		if item.ClientID != "" {
+			m.usageFor(item.ClientID).bytes = item.BytesIn + item.BytesOut
+		}
+	}
+
+	log.Printf("Loaded %d client quotas", len(quotas))
+	return nil
+}
+
+// Run 在每个UTC零点重置计数，直到 ctx 结束
+func (m *Manager) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+
+	for {
+		now := m.now()
+		dayStart, _ := periodStarts(now)
+		timer := time.NewTimer(dayStart.AddDate(0, 0, 1).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			m.mu.Lock()
+			m.rollover(m.now())
+			m.mu.Unlock()
+		}
+	}
+}
+
+// rollover 进入新的日或月周期时清零对应计数，调用方需持有锁
+func (m *Manager) rollover(now time.Time) {
+	dayStart, monthStart := periodStarts(now)
+	if !dayStart.After(m.dayStart) {
+		return
+	}
+
+	newMonth := monthStart.After(m.monthStart)
+	for _, usage := range m.usage {
+		usage.requests = 0
+		usage.warnedRequests = false
+		if newMonth {
+			usage.bytes = 0
+			usage.warnedBytes = false
+		}
+	}
+	m.dayStart = dayStart
+	if newMonth {
+		m.monthStart = monthStart
+		log.Printf("Quota counters reset for month starting %s", monthStart.Format("2006-01"))
+	}
+	log.Printf("Quota request counters reset for %s", dayStart.Format("2006-01-02"))
+}
+
+// usageFor 获取客户端计数，不存在时创建，调用方需持有锁
+func (m *Manager) usageFor(clientID string) *clientUsage {
+	usage, exists := m.usage[clientID]
+	if !exists {
+		usage = &clientUsage{}
+		m.usage[clientID] = usage
+	}
+	return usage
+}
+
+// Check 检查客户端是否还有可用配额，超限时返回 Violation
+func (m *Manager) Check(clientID string) *Violation {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.rollover(now)
+
+	quota, exists := m.quotas[clientID]
+	if !exists {
+		return nil
+	}
+	usage := m.usageFor(clientID)
+
+	if quota.RequestsPerDay > 0 && usage.requests >= quota.RequestsPerDay {
+		return &Violation{
+			Status:     http.StatusTooManyRequests,
+			Metric:     MetricRequestsPerDay,
+			Limit:      quota.RequestsPerDay,
+			Used:       usage.requests,
+			RetryAfter: m.dayStart.AddDate(0, 0, 1).Sub(now),
+		}
+	}
+	if quota.BytesPerMonth > 0 && usage.bytes >= quota.BytesPerMonth {
+		return &Violation{
+			Status:     http.StatusPaymentRequired,
+			Metric:     MetricBytesPerMonth,
+			Limit:      quota.BytesPerMonth,
+			Used:       usage.bytes,
+			RetryAfter: m.monthStart.AddDate(0, 1, 0).Sub(now),
+		}
+	}
+	return nil
+}
+
+// Record 记录一次转发到客户端的请求及其流量，用量首次达到80%时发送告警
+func (m *Manager) Record(clientID string, size int64) {
+	if m == nil || clientID == "" {
+		return
+	}
+
+	m.mu.Lock()
+	m.rollover(m.now())
+
+	usage := m.usageFor(clientID)
+	usage.requests++
+	usage.bytes += size
+
+	var events []WarningEvent
+	webhookURL := m.webhookURL
+	if quota, exists := m.quotas[clientID]; exists {
+		if quota.WebhookURL != "" {
+			webhookURL = quota.WebhookURL
+		}
+		if quota.RequestsPerDay > 0 && !usage.warnedRequests && usage.requests*100 >= quota.RequestsPerDay*warningPercent {
+			usage.warnedRequests = true
+			events = append(events, m.warningEvent(clientID, MetricRequestsPerDay, quota.RequestsPerDay, usage.requests))
+		}
+		if quota.BytesPerMonth > 0 && !usage.warnedBytes && usage.bytes*100 >= quota.BytesPerMonth*warningPercent {
+			usage.warnedBytes = true
+			events = append(events, m.warningEvent(clientID, MetricBytesPerMonth, quota.BytesPerMonth, usage.bytes))
+		}
+	}
+	m.mu.Unlock()
+
+	for _, event := range events {
+		log.Printf("Client %s reached %.0f%% of %s quota (%d/%d)", clientID, event.Percent, event.Metric, event.Used, event.Limit)
+		if webhookURL != "" {
+			go m.sendWarning(webhookURL, event)
+		}
+	}
+}
+
+// warningEvent 构建告警事件，调用方需持有锁
+func (m *Manager) warningEvent(clientID, metric string, limit, used int64) WarningEvent {
+	periodStart := m.dayStart
+	periodEnd := m.dayStart.AddDate(0, 0, 1)
+	if metric == MetricBytesPerMonth {
+		periodStart = m.monthStart
+		periodEnd = m.monthStart.AddDate(0, 1, 0)
+	}
+	return WarningEvent{
+		Event:       "quota.warning",
+		ClientID:    clientID,
+		Metric:      metric,
+		Limit:       limit,
+		Used:        used,
+		Percent:     float64(used) * 100 / float64(limit),
+		PeriodStart: periodStart.UnixMilli(),
+		PeriodEnd:   periodEnd.UnixMilli(),
+		Timestamp:   m.now().UnixMilli(),
+	}
+}
+
+// sendWarning 发送告警 webhook，失败只记录日志
+func (m *Manager) sendWarning(url string, event WarningEvent) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Failed to encode quota warning for client %s: %v", event.ClientID, err)
+		return
+	}
+
+	resp, err := m.httpClient.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send quota warning for client %s: %v", event.ClientID, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("Quota warning webhook for client %s returned status %d", event.ClientID, resp.StatusCode)
+	}
+}
+
+// SetQuota 更新缓存中的客户端配额，并重新允许本周期发送告警
+func (m *Manager) SetQuota(quota *database.ClientQuota) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copied := *quota
+	m.quotas[quota.ClientID] = &copied
+	usage := m.usageFor(quota.ClientID)
+	usage.warnedRequests = false
+	usage.warnedBytes = false
+}
+
+// RemoveQuota 移除缓存中的客户端配额
+func (m *Manager) RemoveQuota(clientID string) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.quotas, clientID)
+}
+
+// Usage 返回客户端当前周期的用量
+func (m *Manager) Usage(clientID string) Usage {
+	if m == nil {
+		dayStart, monthStart := periodStarts(time.Now())
+		return Usage{
+			DayStart:     dayStart.UnixMilli(),
+			DayResetAt:   dayStart.AddDate(0, 0, 1).UnixMilli(),
+			MonthStart:   monthStart.UnixMilli(),
+			MonthResetAt: monthStart.AddDate(0, 1, 0).UnixMilli(),
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rollover(m.now())
+	result := Usage{
+		DayStart:     m.dayStart.UnixMilli(),
+		DayResetAt:   m.dayStart.AddDate(0, 0, 1).UnixMilli(),
+		MonthStart:   m.monthStart.UnixMilli(),
+		MonthResetAt: m.monthStart.AddDate(0, 1, 0).UnixMilli(),
+	}
+	if usage, exists := m.usage[clientID]; exists {
+		result.RequestsToday = usage.requests
+		result.BytesThisMonth = usage.bytes
+	}
+	return result
+}
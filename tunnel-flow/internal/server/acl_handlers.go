@@ -0,0 +1,401 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 路由和客户端的访问控制API
+// 没有任何条目的用户不受限制，可以访问组织内的全部资源
+// 用户本身或其角色存在条目时成为受限用户，只能看到条目中列出的资源，并且只有 edit 权限才能修改
+// 对客户端的权限同时适用于指向该客户端的路由，便于按团队划分各自的隧道
+
+// accessScope 当前用户的资源访问范围
+type accessScope struct {
+	restricted bool
+	routes     map[string]string // 路由ID -> 权限
+	clients    map[string]string // 客户端ID -> 权限
+}
+
+// strongerPermission 返回两个权限中较高的一个，edit 包含 view
+func strongerPermission(a, b string) string {
+	if a == database.ACLPermissionEdit || b == database.ACLPermissionEdit {
+		return database.ACLPermissionEdit
+	}
+	if a == database.ACLPermissionView || b == database.ACLPermissionView {
+		return database.ACLPermissionView
+	}
+	return ""
+}
+
+// accessScopeFor 加载当前用户的访问范围，未经认证的内部调用不受限制
+func (s *apiHandlers) accessScopeFor(r *http.Request) (*accessScope, error) {
+	scope := &accessScope{
+		routes:  make(map[string]string),
+		clients: make(map[string]string),
+	}
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		return scope, nil
+	}
+
+	acls, err := s.db.ListSubjectACLs(requestOrgID(r), user.UserID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	for _, acl := range acls {
+		scope.restricted = true
+		switch acl.ResourceType {
+		case database.ACLResourceRoute:
+			scope.routes[acl.ResourceID] = strongerPermission(scope.routes[acl.ResourceID], acl.Permission)
+		case database.ACLResourceClient:
+			scope.clients[acl.ResourceID] = strongerPermission(scope.clients[acl.ResourceID], acl.Permission)
+		}
+	}
+	return scope, nil
+}
+
+// clientPermission 对客户端的权限，无权访问时返回空字符串
+func (a *accessScope) clientPermission(clientID string) string {
+	if !a.restricted {
+		return database.ACLPermissionEdit
+	}
+	return a.clients[clientID]
+}
+
+// routePermission 对路由的权限，取路由本身和其指向客户端的较高权限
+func (a *accessScope) routePermission(route *database.ServerRoute) string {
+	if !a.restricted {
+		return database.ACLPermissionEdit
+	}
+	permission := a.routes[strconv.Itoa(route.ID)]
+	if route.ClientID != "" {
+		permission = strongerPermission(permission, a.clients[route.ClientID])
+	}
+	return permission
+}
+
+// canTarget 检查用户能否让路由指向指定客户端或分组
+// 受限用户只能使用有编辑权限的客户端，不能使用分组，因为分组成员不在其权限范围内
+func (a *accessScope) canTarget(clientID string, groupID int) bool {
+	if !a.restricted {
+		return true
+	}
+	return groupID == 0 && clientID != "" && a.clients[clientID] == database.ACLPermissionEdit
+}
+
+// requireClientEdit 要求对客户端有编辑权限，返回false表示已写入错误响应
+func (s *apiHandlers) requireClientEdit(w http.ResponseWriter, r *http.Request, clientID string) bool {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if scope.clientPermission(clientID) != database.ACLPermissionEdit {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: no edit permission for this client")
+		return false
+	}
+	return true
+}
+
+// requireRouteEdit 要求对路由有编辑权限，返回false表示已写入错误响应
+func (s *apiHandlers) requireRouteEdit(w http.ResponseWriter, r *http.Request, route *database.ServerRoute) bool {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if scope.routePermission(route) != database.ACLPermissionEdit {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: no edit permission for this route")
+		return false
+	}
+	return true
+}
+
+// requireRouteTarget 要求能让路由指向指定客户端或分组，返回false表示已写入错误响应
+func (s *apiHandlers) requireRouteTarget(w http.ResponseWriter, r *http.Request, clientID string, groupID int) bool {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if !scope.canTarget(clientID, groupID) {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: routes may only target clients you can edit")
+		return false
+	}
+	return true
+}
+
+// requireRoutesEdit 批量操作前检查组织内的每个路由都有编辑权限，不存在的路由交给后续处理
+// 返回false表示已写入错误响应
+func (s *apiHandlers) requireRoutesEdit(w http.ResponseWriter, r *http.Request, ids []int) bool {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if !scope.restricted {
+		return true
+	}
+	for _, id := range ids {
+		route, err := s.db.GetServerRoute(id)
+		if err == sql.ErrNoRows || (err == nil && route.OrgID != requestOrgID(r)) {
+			continue
+		}
+		if err != nil {
+			utils.WriteInternalError(w, r, err)
+			return false
+		}
+		if scope.routePermission(route) != database.ACLPermissionEdit {
+			utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: no edit permission for route "+strconv.Itoa(id))
+			return false
+		}
+	}
+	return true
+}
+
+// requireUnrestricted 要求不受访问控制限制的用户，用于创建客户端、管理分组等无法按资源授权的操作
+// 返回false表示已写入错误响应
+func (s *apiHandlers) requireUnrestricted(w http.ResponseWriter, r *http.Request) bool {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if scope.restricted {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: your access is limited to specific routes and clients")
+		return false
+	}
+	return true
+}
+
+// visibleClients 过滤出当前用户可见的客户端，并标注当前用户的权限
+func (s *apiHandlers) visibleClients(r *http.Request, clients []*database.Client) ([]*database.Client, error) {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*database.Client, 0, len(clients))
+	for _, client := range clients {
+		if client.Permission = scope.clientPermission(client.ClientID); client.Permission != "" {
+			result = append(result, client)
+		}
+	}
+	return result, nil
+}
+
+// routesForAPI 过滤出当前用户可见的路由并转换为API返回格式，附带当前用户的权限
+func (s *apiHandlers) routesForAPI(r *http.Request, routes []*database.ServerRoute) ([]map[string]interface{}, error) {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		return nil, err
+	}
+	visible := make([]*database.ServerRoute, 0, len(routes))
+	permissions := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if permission := scope.routePermission(route); permission != "" {
+			visible = append(visible, route)
+			permissions = append(permissions, permission)
+		}
+	}
+
+	result := convertRoutesForAPI(visible)
+	for i := range result {
+		result[i]["permission"] = permissions[i]
+	}
+	return result, nil
+}
+
+// requireACLAdmin 管理访问控制需要不受限制的组织管理员，返回false表示已写入错误响应
+func (s *apiHandlers) requireACLAdmin(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	user, ok := requireOrgAdmin(w, r)
+	if !ok {
+		return nil, false
+	}
+	if !s.requireUnrestricted(w, r) {
+		return nil, false
+	}
+	return user, true
+}
+
+// handleGetACLs 列出当前组织的访问控制条目，可按 resource_type、resource_id、subject_type、subject 过滤
+func (s *apiHandlers) handleGetACLs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireACLAdmin(w, r); !ok {
+		return
+	}
+
+	acls, err := s.db.ListResourceACLs(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	filtered := make([]*database.ResourceACL, 0, len(acls))
+	for _, acl := range acls {
+		if (query.Get("resource_type") != "" && acl.ResourceType != query.Get("resource_type")) ||
+			(query.Get("resource_id") != "" && acl.ResourceID != query.Get("resource_id")) ||
+			(query.Get("subject_type") != "" && acl.SubjectType != query.Get("subject_type")) ||
+			(query.Get("subject") != "" && acl.Subject != query.Get("subject")) {
+			continue
+		}
+		filtered = append(filtered, acl)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filtered)
+}
+
+// handleGetMyACLs 返回当前用户的访问范围，供前端决定显示哪些操作
+func (s *apiHandlers) handleGetMyACLs(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	acls, err := s.db.ListSubjectACLs(requestOrgID(r), user.UserID, user.Role)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"restricted": len(acls) > 0,
+		"entries":    acls,
+	})
+}
+
+// handleCreateACL 授予用户或角色对路由或客户端的权限
+// 主体一旦获得第一条授权就只能访问授权过的资源，因此不允许限制自己
+func (s *apiHandlers) handleCreateACL(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.requireACLAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var acl database.ResourceACL
+	if err := json.NewDecoder(r.Body).Decode(&acl); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	acl.ResourceID = strings.TrimSpace(acl.ResourceID)
+	acl.Subject = strings.TrimSpace(acl.Subject)
+	if acl.Permission == "" {
+		acl.Permission = database.ACLPermissionView
+	}
+	if acl.Permission != database.ACLPermissionView && acl.Permission != database.ACLPermissionEdit {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "permission must be view or edit")
+		return
+	}
+
+	switch acl.ResourceType {
+	case database.ACLResourceRoute:
+		id, err := strconv.Atoi(acl.ResourceID)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Route does not exist")
+			return
+		}
+		if _, err := s.getOrgRoute(r, id); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Route does not exist")
+			return
+		}
+		acl.ResourceID = strconv.Itoa(id)
+	case database.ACLResourceClient:
+		if _, err := s.getOrgClient(r, acl.ResourceID); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Client does not exist")
+			return
+		}
+	default:
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "resource_type must be route or client")
+		return
+	}
+
+	switch acl.SubjectType {
+	case database.ACLSubjectUser:
+		if !s.subjectInOrg(r, acl.Subject) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "User does not exist")
+			return
+		}
+		if acl.Subject == user.UserID {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Cannot restrict your own access")
+			return
+		}
+	case database.ACLSubjectRole:
+		if acl.Subject != auth.RoleAdmin && acl.Subject != auth.RoleUser {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "role must be admin or user")
+			return
+		}
+		if acl.Subject == user.Role {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Cannot restrict your own access")
+			return
+		}
+	default:
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "subject_type must be user or role")
+		return
+	}
+
+	acl.ID = 0
+	acl.OrgID = requestOrgID(r)
+	acl.CreatedBy = user.Username
+	if err := s.db.CreateResourceACL(&acl); err != nil {
+		if database.IsUniqueConstraintError(err) {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "An entry for this subject and resource already exists")
+			return
+		}
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(acl)
+}
+
+// subjectInOrg 检查用户或API密钥（apikey:<id>）属于当前组织
+func (s *apiHandlers) subjectInOrg(r *http.Request, subject string) bool {
+	if idStr, ok := strings.CutPrefix(subject, "apikey:"); ok {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			return false
+		}
+		key, err := s.db.GetAPIKey(id)
+		return err == nil && key.OrgID == requestOrgID(r)
+	}
+	user, err := s.db.GetUser(subject)
+	return err == nil && user.OrgID == requestOrgID(r)
+}
+
+// handleDeleteACL 删除访问控制条目，主体的最后一条条目删除后恢复为不受限制
+func (s *apiHandlers) handleDeleteACL(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.requireACLAdmin(w, r); !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid ACL ID")
+		return
+	}
+	acl, err := s.db.GetResourceACL(id)
+	if err != nil || acl.OrgID != requestOrgID(r) {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "ACL entry not found")
+		return
+	}
+
+	if err := s.db.DeleteResourceACL(acl.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/database"
+)
+
+// 路由自定义域名的证书：客户端第一次通过SNI访问已验证的域名时向ACME服务申请证书，
+// 证书和账户密钥保存在 acme.cache_dir，到期前自动续期
+
+// newCertManager 创建按需申请证书的管理器，只为启用且通过所有权验证的路由域名申请
+func newCertManager(cfg *config.Config, db *database.Repository) *autocert.Manager {
+	manager := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Cache:  autocert.DirCache(cfg.ACMECacheDir),
+		Email:  cfg.ACMEEmail,
+		HostPolicy: func(ctx context.Context, host string) error {
+			return allowCertificate(db, host)
+		},
+	}
+	if cfg.ACMEDirectoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL}
+	}
+	return manager
+}
+
+// allowCertificate 检查能否为主机申请证书，缓存中没有该主机的证书时才调用，防止任意SNI触发申请耗尽ACME服务的配额
+func allowCertificate(db *database.Repository, host string) error {
+	route, err := db.GetServerRouteByDomain(strings.ToLower(host))
+	if err != nil {
+		return fmt.Errorf("host %s is not a route domain", host)
+	}
+	if !route.IsDomainVerified() || !route.IsEnabled() {
+		return fmt.Errorf("domain %s of route %d is not verified or the route is disabled", host, route.ID)
+	}
+	log.Printf("Requesting certificate for domain %s of route %d", host, route.ID)
+	return nil
+}
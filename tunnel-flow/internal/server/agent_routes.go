@@ -0,0 +1,328 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+)
+
+// 代理声明的路由
+// 代理在配置文件的 routes 中声明要暴露的路由，注册成功后通过 ROUTE_SYNC 上报全部声明；
+// 服务端开启 agent.allow_routes 时校验并创建或更新这些路由（新建的路由直接启用），删除该客户端不再声明的路由。
+// 路由记为 source=agent，管理员仍可通过API修改超时、启用状态等设置，路径、目标、独立端口和描述以代理配置为准。
+// 开启 agent.require_route_approval 时，新声明的路由和目标或独立端口变更的路由进入 pending 状态，
+// 管理员批准前不转发请求；审批结果推送给在线的代理，被拒绝的路由在代理修改声明后重新等待审批
+
+// syncAgentRoutes 按代理上报的声明同步该客户端的路由，返回每条声明的处理结果和删除的路由路径
+func (s *apiHandlers) syncAgentRoutes(clientID string, declared []protocol.DeclaredRoute) ([]protocol.DeclaredRouteResult, []string) {
+	results := make([]protocol.DeclaredRouteResult, 0, len(declared))
+	rejectAll := func(reason string) ([]protocol.DeclaredRouteResult, []string) {
+		for _, d := range declared {
+			results = append(results, protocol.DeclaredRouteResult{URLSuffix: d.URLSuffix, Status: protocol.RouteSyncRejected, Reason: reason})
+		}
+		return results, nil
+	}
+	if !s.config.AllowAgentRoutes {
+		if len(declared) > 0 {
+			log.Printf("Ignoring %d routes declared by client %s: agent.allow_routes is disabled", len(declared), clientID)
+		}
+		return rejectAll("agent-declared routes are disabled on the server")
+	}
+	if s.freeze.Status().ReadOnly {
+		return rejectAll("server is in read-only mode")
+	}
+
+	client, err := s.db.GetClient(clientID)
+	if err != nil {
+		log.Printf("Failed to load client %s for route sync: %v", clientID, err)
+		return rejectAll("internal error")
+	}
+	// 只有同一组织的路由会冲突，也只加载该组织的路由
+	routes, err := s.db.ListServerRoutesByOrg(client.OrgID)
+	if err != nil {
+		log.Printf("Failed to list routes for route sync of client %s: %v", clientID, err)
+		return rejectAll("internal error")
+	}
+
+	// 该客户端之前声明的路由，按路径索引
+	owned := make(map[string]*database.ServerRoute)
+	for _, route := range routes {
+		if route.ClientID == clientID && route.Source == database.RouteSourceAgent {
+			owned[route.URLSuffix] = route
+		}
+	}
+
+	declaredSuffixes := make(map[string]bool, len(declared))
+	changed := false
+	for _, d := range declared {
+		result := protocol.DeclaredRouteResult{URLSuffix: d.URLSuffix}
+		duplicate := declaredSuffixes[d.URLSuffix]
+		// 校验失败的声明也保留已有的路由，避免配置写错时路由被删除
+		declaredSuffixes[d.URLSuffix] = true
+
+		reason := validateDeclaredRoute(d)
+		if reason == "" && duplicate {
+			reason = "url_suffix is declared more than once"
+		}
+		if reason == "" {
+			reason = declaredSuffixConflict(clientID, d.URLSuffix, routes)
+		}
+		if reason == "" {
+			reason, err = s.routeSuffixConflict(&database.ServerRoute{OrgID: client.OrgID, URLSuffix: d.URLSuffix})
+			if err != nil {
+				log.Printf("Failed to check organization slugs for route %s declared by client %s: %v", d.URLSuffix, clientID, err)
+				reason = "internal error"
+			}
+		}
+		if reason == "" {
+			result.RouteID, result.Status, reason, err = s.applyDeclaredRoute(client, d, owned[d.URLSuffix])
+			if err != nil {
+				log.Printf("Failed to save route %s declared by client %s: %v", d.URLSuffix, clientID, err)
+				reason = "internal error"
+			}
+		}
+		if reason != "" {
+			result.Status = protocol.RouteSyncRejected
+			result.Reason = reason
+			log.Printf("Rejected route %s declared by client %s: %s", d.URLSuffix, clientID, reason)
+		} else if result.Status != protocol.RouteSyncUnchanged {
+			changed = true
+			log.Printf("Route %d (%s) %s from client %s declaration", result.RouteID, d.URLSuffix, result.Status, clientID)
+		}
+		results = append(results, result)
+	}
+
+	var removed []string
+	for suffix, route := range owned {
+		if declaredSuffixes[suffix] {
+			continue
+		}
+		if err := s.db.DeleteServerRoute(route.ID); err != nil {
+			log.Printf("Failed to delete route %d no longer declared by client %s: %v", route.ID, clientID, err)
+			continue
+		}
+		log.Printf("Route %d (%s) deleted: no longer declared by client %s", route.ID, suffix, clientID)
+		removed = append(removed, suffix)
+		changed = true
+	}
+
+	if changed {
+		s.routesChanged()
+	}
+	return results, removed
+}
+
+// applyDeclaredRoute 创建声明的路由，或按声明更新之前创建的路由，拒绝时返回原因
+func (s *apiHandlers) applyDeclaredRoute(client *database.Client, d protocol.DeclaredRoute, existing *database.ServerRoute) (int, string, string, error) {
+	targetsJSON, err := json.Marshal([]protocol.RouteTarget{{URL: d.Target}})
+	if err != nil {
+		return 0, "", "", err
+	}
+
+	route := &database.ServerRoute{
+		URLSuffix:   d.URLSuffix,
+		ClientID:    client.ClientID,
+		TargetsJSON: string(targetsJSON),
+		Enabled:     1,
+		Description: d.Description,
+		OrgID:       client.OrgID,
+		ListenPort:  d.ListenPort,
+		Source:      database.RouteSourceAgent,
+	}
+	if existing == nil {
+		if s.config.AgentRouteApproval {
+			route.ApprovalStatus = database.RouteApprovalPending
+		}
+	} else {
+		targetChanged := existing.TargetsJSON != route.TargetsJSON || existing.ListenPort != d.ListenPort
+		if !targetChanged && existing.Description == d.Description {
+			switch existing.ApprovalStatus {
+			case database.RouteApprovalPending:
+				return existing.ID, protocol.RouteSyncPending, "", nil
+			case database.RouteApprovalRejected:
+				return existing.ID, "", rejectedReason(existing), nil
+			}
+			return existing.ID, protocol.RouteSyncUnchanged, "", nil
+		}
+		// 保留管理员修改过的其他设置
+		updated := *existing
+		updated.TargetsJSON = route.TargetsJSON
+		updated.ListenPort = d.ListenPort
+		updated.Description = d.Description
+		// 目标变更需要重新审批，被拒绝的路由修改声明后也重新等待审批
+		if (s.config.AgentRouteApproval && targetChanged) || existing.ApprovalStatus == database.RouteApprovalRejected {
+			updated.ApprovalStatus = database.RouteApprovalPending
+			updated.ApprovalNote, updated.ReviewedBy, updated.ReviewedAt = "", "", 0
+		}
+		route = &updated
+	}
+
+	// 代理只能在为该客户端预留的端口上暴露路由
+	if route.ListenPort > 0 {
+		reason, err := s.checkListenPort(route, true)
+		if err != nil || reason != "" {
+			return 0, "", reason, err
+		}
+	}
+
+	status := protocol.RouteSyncCreated
+	if existing != nil {
+		if err := s.db.UpdateServerRoute(route); err != nil {
+			return 0, "", "", err
+		}
+		status = protocol.RouteSyncUpdated
+	} else if err := s.db.CreateServerRoute(route); err != nil {
+		return 0, "", "", err
+	}
+	if route.ApprovalStatus == database.RouteApprovalPending {
+		status = protocol.RouteSyncPending
+	}
+	return route.ID, status, "", nil
+}
+
+// rejectedReason 被管理员拒绝的路由返回给代理的原因
+func rejectedReason(route *database.ServerRoute) string {
+	if route.ApprovalNote == "" {
+		return "rejected by administrator"
+	}
+	return "rejected by administrator: " + route.ApprovalNote
+}
+
+// validateDeclaredRoute 检查声明路由的格式，返回空字符串表示有效
+func validateDeclaredRoute(d protocol.DeclaredRoute) string {
+	if !strings.HasPrefix(d.URLSuffix, "/") || strings.ContainsAny(d.URLSuffix, " \t\r\n") {
+		return "url_suffix must start with / and must not contain whitespace"
+	}
+	if d.ListenPort < 0 || d.ListenPort > 65535 {
+		return "listen_port must be between 1 and 65535"
+	}
+	if strings.HasPrefix(strings.ToLower(d.Target), "unix:") {
+		return ""
+	}
+	target, err := url.Parse(d.Target)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return fmt.Sprintf("target must be an http://, https:// or unix:// address: %q", d.Target)
+	}
+	return ""
+}
+
+// declaredSuffixConflict 声明的路径已被其他客户端、客户端分组或通过API创建的路由使用时返回原因
+func declaredSuffixConflict(clientID, urlSuffix string, routes []*database.ServerRoute) string {
+	for _, route := range routes {
+		if route.URLSuffix != urlSuffix {
+			continue
+		}
+		switch {
+		case route.ClientID == clientID && route.Source == database.RouteSourceAgent:
+		case route.ClientID == clientID:
+			return fmt.Sprintf("url_suffix is already used by route %d managed through the API", route.ID)
+		default:
+			return "url_suffix is already used by another client's route"
+		}
+	}
+	return ""
+}
+
+// filterRoutesByApproval 只保留指定审批状态的路由，状态为空时不过滤
+func filterRoutesByApproval(routes []*database.ServerRoute, status string) []*database.ServerRoute {
+	if status == "" {
+		return routes
+	}
+	filtered := make([]*database.ServerRoute, 0)
+	for _, route := range routes {
+		if route.ApprovalStatus == status {
+			filtered = append(filtered, route)
+		}
+	}
+	return filtered
+}
+
+// handleApproveRoute 批准代理声明的路由，批准后开始转发请求，仅管理员可用
+func (s *apiHandlers) handleApproveRoute(w http.ResponseWriter, r *http.Request) {
+	s.reviewRoute(w, r, database.RouteApprovalApproved)
+}
+
+// handleRejectRoute 拒绝代理声明的路由，可以在请求体的 reason 中说明原因，仅管理员可用
+func (s *apiHandlers) handleRejectRoute(w http.ResponseWriter, r *http.Request) {
+	s.reviewRoute(w, r, database.RouteApprovalRejected)
+}
+
+// reviewRoute 记录管理员对代理声明路由的审批结果并推送给代理
+func (s *apiHandlers) reviewRoute(w http.ResponseWriter, r *http.Request, decision string) {
+	user, ok := requireOrgAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
+		return
+	}
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+
+	route, err := s.getOrgRoute(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if !s.requireRouteEdit(w, r, route) {
+		return
+	}
+	if route.Source != database.RouteSourceAgent {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Only routes declared by an agent can be approved or rejected")
+		return
+	}
+
+	route.ApprovalStatus = decision
+	route.ApprovalNote = ""
+	if decision == database.RouteApprovalRejected {
+		route.ApprovalNote = strings.TrimSpace(request.Reason)
+	}
+	route.ReviewedBy = user.Username
+	route.ReviewedAt = time.Now().UnixMilli()
+	if err := s.db.UpdateServerRoute(route); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.routesChanged()
+	log.Printf("Route %d (%s) of client %s %s by %s", route.ID, route.URLSuffix, route.ClientID, decision, user.Username)
+
+	result := protocol.DeclaredRouteResult{URLSuffix: route.URLSuffix, Status: protocol.RouteSyncApproved, RouteID: route.ID}
+	if decision == database.RouteApprovalRejected {
+		result.Status = protocol.RouteSyncRejected
+		result.Reason = rejectedReason(route)
+	}
+	if err := s.wsManager.PushRouteDecision(route.ClientID, result); err != nil {
+		log.Printf("Failed to push route decision to client %s: %v", route.ClientID, err)
+	}
+
+	converted, err := s.routesForAPI(r, []*database.ServerRoute{route})
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(route.Version))
+	json.NewEncoder(w).Encode(converted[0])
+}
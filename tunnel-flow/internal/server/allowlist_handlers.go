@@ -0,0 +1,100 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+	"tunnel-flow/internal/websocket"
+)
+
+// 客户端目标白名单API，代理注册时下发白名单，只转发到白名单内的目标
+
+// allowlistResponse 目标白名单接口的响应
+type allowlistResponse struct {
+	ClientID       string                      `json:"client_id"`
+	AllowedTargets []string                    `json:"allowed_targets"` // 为空时不限制
+	Online         bool                        `json:"online"`
+	Enforced       bool                        `json:"enforced"`   // 在线的代理支持按白名单限制目标
+	Violations     []websocket.TargetViolation `json:"violations"` // 代理最近上报的违规，最新的在最后
+}
+
+// allowlistStatus 汇总客户端的目标白名单和代理的执行情况
+func (s *apiHandlers) allowlistStatus(clientID string, allowedTargets []string) allowlistResponse {
+	if allowedTargets == nil {
+		allowedTargets = []string{}
+	}
+	_, online := s.wsManager.ClientMetrics(clientID)
+	return allowlistResponse{
+		ClientID:       clientID,
+		AllowedTargets: allowedTargets,
+		Online:         online,
+		Enforced:       s.wsManager.ClientHasCapability(clientID, protocol.CapabilityTargetAllowlist),
+		Violations:     s.wsManager.TargetViolations(clientID),
+	}
+}
+
+// handleGetClientAllowedTargets 获取客户端的目标白名单和最近的违规记录
+func (s *apiHandlers) handleGetClientAllowedTargets(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	client, err := s.getOrgClient(r, clientID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.allowlistStatus(clientID, client.AllowedTargets))
+}
+
+// handleSetClientAllowedTargets 设置客户端的目标白名单，空列表表示不限制；客户端在线时立即下发
+// 每一项的格式为 主机[:端口]，主机可以是主机名、*.example.com、IP、CIDR 或 *
+func (s *apiHandlers) handleSetClientAllowedTargets(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+
+	var request struct {
+		AllowedTargets []string `json:"allowed_targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	allowedTargets := make([]string, 0, len(request.AllowedTargets))
+	seen := make(map[string]bool)
+	for _, target := range request.AllowedTargets {
+		normalized, err := utils.NormalizeTargetPattern(target)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid allowed_targets: %v", err))
+			return
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			allowedTargets = append(allowedTargets, normalized)
+		}
+	}
+
+	if err := s.db.UpdateClientAllowedTargets(clientID, allowedTargets); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if err := s.wsManager.PushAllowedTargets(clientID, allowedTargets); err != nil {
+		log.Printf("Failed to push allowed targets to client %s: %v", clientID, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.allowlistStatus(clientID, allowedTargets))
+}
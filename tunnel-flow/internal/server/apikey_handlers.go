@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 组织API密钥管理API
+// 密钥只能访问所属组织的数据，明文只在创建和轮换时返回一次
+
+// APIKeyWithSecret 创建或轮换后返回的密钥，包含明文
+type APIKeyWithSecret struct {
+	*database.APIKey
+	Key string `json:"key"`
+}
+
+// requireOrgAdmin 要求当前组织的管理员，返回false表示已写入错误响应
+func requireOrgAdmin(w http.ResponseWriter, r *http.Request) (*auth.Claims, bool) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok || user.Role != auth.RoleAdmin {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: admin access required")
+		return nil, false
+	}
+	return user, true
+}
+
+// apiKeyFromRequest 解析路径中的密钥ID并加载当前组织的密钥，其他组织的密钥视为不存在
+// 返回nil表示已写入错误响应
+func (s *apiHandlers) apiKeyFromRequest(w http.ResponseWriter, r *http.Request) *database.APIKey {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid API key ID")
+		return nil
+	}
+
+	key, err := s.db.GetAPIKey(id)
+	if err != nil || key.OrgID != requestOrgID(r) {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "API key not found")
+		return nil
+	}
+	return key
+}
+
+// handleGetAPIKeys 列出当前组织的API密钥
+func (s *apiHandlers) handleGetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	keys, err := s.db.ListAPIKeys(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// handleCreateAPIKey 为当前组织创建API密钥，expires_in_days 为0表示永不过期
+func (s *apiHandlers) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user, ok := requireOrgAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var request struct {
+		Name          string `json:"name"`
+		Role          string `json:"role"`
+		ExpiresInDays int    `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	request.Name = strings.TrimSpace(request.Name)
+	if request.Name == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "API key name is required")
+		return
+	}
+	if request.Role == "" {
+		request.Role = auth.RoleUser
+	}
+	if request.Role != auth.RoleUser && request.Role != auth.RoleAdmin {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "role must be admin or user")
+		return
+	}
+	if request.ExpiresInDays < 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "expires_in_days must not be negative")
+		return
+	}
+
+	org, err := s.db.GetOrganization(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	plaintext, prefix, hash := auth.GenerateAPIKey(org.Slug)
+	key := &database.APIKey{
+		OrgID:     org.ID,
+		Name:      request.Name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Role:      request.Role,
+		CreatedBy: user.Username,
+	}
+	if request.ExpiresInDays > 0 {
+		key.ExpiresAt = time.Now().AddDate(0, 0, request.ExpiresInDays).UnixMilli()
+	}
+	if err := s.db.CreateAPIKey(key); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(APIKeyWithSecret{APIKey: key, Key: plaintext})
+}
+
+// handleRotateAPIKey 为密钥生成新的明文，名称、角色和有效期保持不变，旧明文立即失效
+func (s *apiHandlers) handleRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+	key := s.apiKeyFromRequest(w, r)
+	if key == nil {
+		return
+	}
+
+	org, err := s.db.GetOrganization(key.OrgID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	plaintext, prefix, hash := auth.GenerateAPIKey(org.Slug)
+	if err := s.db.UpdateAPIKeySecret(key.ID, prefix, hash); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	key.Prefix = prefix
+	key.KeyHash = hash
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIKeyWithSecret{APIKey: key, Key: plaintext})
+}
+
+// handleDeleteAPIKey 吊销API密钥
+func (s *apiHandlers) handleDeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+	key := s.apiKeyFromRequest(w, r)
+	if key == nil {
+		return
+	}
+
+	if err := s.db.DeleteAPIKey(key.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
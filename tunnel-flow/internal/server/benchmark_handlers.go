@@ -0,0 +1,256 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+	"tunnel-flow/internal/websocket"
+)
+
+// 隧道压测API：向在线代理发送带 echo 标记的合成请求，代理不转发给后端，直接返回请求体
+// 测得的是服务器到代理之间隧道本身的吞吐量和往返延迟；压测请求不保存为消息，不出现在 /messages 中
+
+// 压测参数的默认值和上限
+const (
+	defaultBenchmarkRequests    = 100
+	maxBenchmarkRequests        = 10000
+	defaultBenchmarkConcurrency = 10
+	maxBenchmarkConcurrency     = 100
+	defaultBenchmarkSize        = 1024
+	maxBenchmarkSize            = 1024 * 1024
+	maxBenchmarkErrors          = 10 // 结果中最多列出的不同错误数
+)
+
+// benchmarkRequest 压测参数
+type benchmarkRequest struct {
+	Requests    int `json:"requests"`    // 请求总数，默认100，最大10000
+	Concurrency int `json:"concurrency"` // 同时发送的请求数，默认10，最大100
+	Size        int `json:"size"`        // 每个请求的请求体字节数，默认1024，最大1MiB，代理原样返回
+	TimeoutMS   int `json:"timeout_ms"`  // 单个请求的超时，默认使用服务器的请求超时
+}
+
+// benchmarkLatency 往返延迟统计（毫秒）
+type benchmarkLatency struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P95  float64 `json:"p95"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// benchmarkResponse 压测结果，吞吐量按成功请求的请求体和响应体字节数计算
+type benchmarkResponse struct {
+	ClientID          string           `json:"client_id"`
+	Requests          int              `json:"requests"`
+	Concurrency       int              `json:"concurrency"`
+	Size              int              `json:"size"`
+	Succeeded         int              `json:"succeeded"`
+	Failed            int              `json:"failed"`
+	DurationMS        float64          `json:"duration_ms"`
+	RequestsPerSecond float64          `json:"requests_per_second"`
+	BytesPerSecond    float64          `json:"bytes_per_second"`
+	Latency           benchmarkLatency `json:"latency_ms"` // 只统计成功的请求
+	Errors            map[string]int   `json:"errors,omitempty"`
+}
+
+// handleBenchmarkClient 通过隧道向客户端发送合成请求并返回吞吐量和延迟分位数，请求结束前不返回
+// 需要对客户端有编辑权限；代理需要支持 echo 能力，调用方断开时停止压测
+func (s *apiHandlers) handleBenchmarkClient(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+
+	request := benchmarkRequest{
+		Requests:    defaultBenchmarkRequests,
+		Concurrency: defaultBenchmarkConcurrency,
+		Size:        defaultBenchmarkSize,
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+	if request.Requests <= 0 || request.Requests > maxBenchmarkRequests {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "requests must be between 1 and 10000")
+		return
+	}
+	if request.Concurrency <= 0 || request.Concurrency > maxBenchmarkConcurrency {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "concurrency must be between 1 and 100")
+		return
+	}
+	if request.Size < 0 || request.Size > maxBenchmarkSize {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "size must be between 0 and 1048576")
+		return
+	}
+	if request.TimeoutMS < 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "timeout_ms must not be negative")
+		return
+	}
+	if request.Concurrency > request.Requests {
+		request.Concurrency = request.Requests
+	}
+
+	if !s.wsManager.IsClientConnected(clientID) {
+		utils.WriteError(w, r, http.StatusServiceUnavailable, utils.ErrCodeNoBackend, "Client is not connected")
+		return
+	}
+	if !s.wsManager.ClientHasCapability(clientID, protocol.CapabilityEcho) {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Client agent does not support benchmarking, upgrade the agent")
+		return
+	}
+
+	timeout := s.config.RequestTimeout()
+	if request.TimeoutMS > 0 {
+		timeout = time.Duration(request.TimeoutMS) * time.Millisecond
+	}
+	// 压测可能超过 API 服务器的写超时，按每批请求都等到超时的最坏情况延长写超时，留出写响应的时间
+	batches := (request.Requests + request.Concurrency - 1) / request.Concurrency
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(time.Duration(batches)*timeout + time.Minute))
+	result := s.runBenchmark(websocket.WithoutRecord(r.Context()), clientID, request, timeout)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// runBenchmark 按并发数发送压测请求，ctx 取消后未发送的请求计为失败
+func (s *apiHandlers) runBenchmark(ctx context.Context, clientID string, request benchmarkRequest, timeout time.Duration) *benchmarkResponse {
+	body := benchmarkBody(request.Size)
+	result := &benchmarkResponse{
+		ClientID:    clientID,
+		Requests:    request.Requests,
+		Concurrency: request.Concurrency,
+		Size:        request.Size,
+	}
+
+	var mu sync.Mutex
+	latencies := make([]time.Duration, 0, request.Requests)
+	recordError := func(reason string) {
+		mu.Lock()
+		defer mu.Unlock()
+		result.Failed++
+		if _, ok := result.Errors[reason]; ok || len(result.Errors) < maxBenchmarkErrors {
+			if result.Errors == nil {
+				result.Errors = make(map[string]int)
+			}
+			result.Errors[reason]++
+		}
+	}
+
+	jobs := make(chan struct{}, request.Requests)
+	for i := 0; i < request.Requests; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < request.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				if ctx.Err() != nil {
+					recordError(ctx.Err().Error())
+					continue
+				}
+				payload := &protocol.RequestPayload{
+					HTTPMethod: http.MethodPost,
+					URLSuffix:  "/benchmark",
+					Headers:    map[string]string{"Content-Type": "application/octet-stream"},
+					Body:       body,
+					Echo:       true,
+				}
+				sentAt := time.Now()
+				response, err := s.wsManager.SendRequestAndWaitContext(ctx, clientID, payload, timeout)
+				latency := time.Since(sentAt)
+				switch {
+				case err != nil:
+					recordError(err.Error())
+				case response.Error != nil:
+					recordError(*response.Error)
+				case response.HTTPStatus != http.StatusOK:
+					recordError(http.StatusText(response.HTTPStatus))
+				case response.Body != body:
+					recordError("echoed body does not match")
+				default:
+					mu.Lock()
+					latencies = append(latencies, latency)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result.Succeeded = len(latencies)
+	result.DurationMS = durationMS(elapsed)
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		result.RequestsPerSecond = float64(result.Succeeded) / seconds
+		result.BytesPerSecond = float64(2*request.Size*result.Succeeded) / seconds
+	}
+	result.Latency = latencyStats(latencies)
+	return result
+}
+
+// benchmarkBody 生成指定长度的随机可打印请求体，避免载荷压缩让结果失真
+func benchmarkBody(size int) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	body := make([]byte, size)
+	for i := range body {
+		body[i] = alphabet[rand.Intn(len(alphabet))]
+	}
+	return string(body)
+}
+
+// latencyStats 计算延迟的最小值、平均值、分位数（最近秩法）和最大值
+func latencyStats(latencies []time.Duration) benchmarkLatency {
+	if len(latencies) == 0 {
+		return benchmarkLatency{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	percentile := func(p int) float64 {
+		rank := (p*len(latencies) + 99) / 100
+		if rank < 1 {
+			rank = 1
+		}
+		return durationMS(latencies[rank-1])
+	}
+	return benchmarkLatency{
+		Min:  durationMS(latencies[0]),
+		Mean: durationMS(total / time.Duration(len(latencies))),
+		P50:  percentile(50),
+		P90:  percentile(90),
+		P95:  percentile(95),
+		P99:  percentile(99),
+		Max:  durationMS(latencies[len(latencies)-1]),
+	}
+}
+
+// durationMS 转换为毫秒，保留三位小数
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
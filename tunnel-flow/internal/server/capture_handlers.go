@@ -0,0 +1,410 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/logging"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+)
+
+// 请求抓取API，开启 capture 的路由保存最近请求的完整请求和响应，用于调试
+// 记录在过期前可以查看，认证相关的请求头和响应头按日志脱敏规则隐藏
+// 完整抓取了请求体的记录可以重放：通过隧道重新发送原请求，返回新的响应
+
+// maxRouteCaptureBytes 路由 capture_max_bytes 的上限
+const maxRouteCaptureBytes = 10 * 1024 * 1024
+
+// validateRouteCapture 检查路由的抓取设置
+func validateRouteCapture(route *database.ServerRoute) error {
+	if route.CaptureMaxBytes < 0 || route.CaptureMaxBytes > maxRouteCaptureBytes {
+		return fmt.Errorf("capture_max_bytes must be between 0 and %d", maxRouteCaptureBytes)
+	}
+	if route.CaptureTTLSeconds < 0 {
+		return fmt.Errorf("capture_ttl_seconds must not be negative")
+	}
+	return nil
+}
+
+// requestCaptureListResponse 抓取列表接口的响应，列表中不包含请求体和响应体
+type requestCaptureListResponse struct {
+	Total  int                        `json:"total"`
+	Limit  int                        `json:"limit"`
+	Offset int                        `json:"offset"`
+	Items  []*database.RequestCapture `json:"items"` // 最近的请求在前
+}
+
+// requestCaptureDetail 单条抓取记录，UTF-8 文本的请求体和响应体原样返回，其他内容用 base64 编码
+type requestCaptureDetail struct {
+	*database.RequestCapture
+	RequestBody        string `json:"request_body,omitempty"`
+	RequestBodyBase64  string `json:"request_body_base64,omitempty"`
+	ResponseBody       string `json:"response_body,omitempty"`
+	ResponseBodyBase64 string `json:"response_body_base64,omitempty"`
+}
+
+// requestReplayResponse 重放抓取请求的结果
+type requestReplayResponse struct {
+	CaptureID    int64             `json:"capture_id"`
+	RouteID      int               `json:"route_id"`
+	ClientID     string            `json:"client_id"`
+	HTTPStatus   int               `json:"http_status"`
+	Headers      map[string]string `json:"headers"`
+	Body         string            `json:"body,omitempty"`
+	BodyBase64   string            `json:"body_base64,omitempty"`
+	ResponseSize int               `json:"response_size"`
+	LatencyMS    int64             `json:"latency_ms"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// captureBody 按内容选择返回文本还是 base64
+func captureBody(body []byte) (string, string) {
+	if utf8.Valid(body) {
+		return string(body), ""
+	}
+	return "", base64.StdEncoding.EncodeToString(body)
+}
+
+// redactCaptureHeaders 返回脱敏后的请求头或响应头
+func redactCaptureHeaders(headers map[string]string) map[string]string {
+	redacted := make(map[string]string, len(headers))
+	for name, value := range headers {
+		redacted[name] = logging.RedactHeader(name, value)
+	}
+	return redacted
+}
+
+// requestCaptureFromRequest 解析路径中的抓取ID并加载当前组织的记录，其他组织、已过期或无权查看其路由的记录视为不存在
+// 返回nil表示已写入错误响应
+func (s *apiHandlers) requestCaptureFromRequest(w http.ResponseWriter, r *http.Request) *database.RequestCapture {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid request capture ID")
+		return nil
+	}
+
+	capture, err := s.db.GetRequestCapture(id)
+	if err == sql.ErrNoRows || (err == nil && capture.OrgID != requestOrgID(r)) {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Request capture not found")
+		return nil
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return nil
+	}
+	visible, err := s.captureVisible(r, capture)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return nil
+	}
+	if !visible {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Request capture not found")
+		return nil
+	}
+	return capture
+}
+
+// captureVisible 当前用户能否查看抓取记录，受限用户需要对记录的路由有查看权限，路由已删除的记录不可见
+func (s *apiHandlers) captureVisible(r *http.Request, capture *database.RequestCapture) (bool, error) {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		return false, err
+	}
+	if !scope.restricted {
+		return true, nil
+	}
+	route, err := s.db.GetServerRoute(capture.RouteID)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return route.OrgID == capture.OrgID && scope.routePermission(route) != "", nil
+}
+
+// visibleCaptureRoutes 列出抓取记录时限定的路由，不限制时返回nil
+// 受限用户只能看到有查看权限的路由的记录，指定了 routeID 时只保留该路由
+func (s *apiHandlers) visibleCaptureRoutes(r *http.Request, routeID int) ([]int, error) {
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		return nil, err
+	}
+	if !scope.restricted {
+		if routeID > 0 {
+			return []int{routeID}, nil
+		}
+		return nil, nil
+	}
+
+	routes, err := s.db.ListServerRoutesByOrg(requestOrgID(r))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for _, route := range routes {
+		if (routeID == 0 || route.ID == routeID) && scope.routePermission(route) != "" {
+			ids = append(ids, route.ID)
+		}
+	}
+	return ids, nil
+}
+
+// handleGetRequestCaptures 分页列出当前组织未过期的抓取记录，可按 route_id 过滤，limit 默认50、最大500，仅管理员可用
+// 受限用户只能看到有查看权限的路由的记录
+func (s *apiHandlers) handleGetRequestCaptures(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	limit := defaultMessageLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		value, err := strconv.Atoi(limitStr)
+		if err != nil || value <= 0 || value > maxMessageLimit {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "limit must be between 1 and 500")
+			return
+		}
+		limit = value
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		value, err := strconv.Atoi(offsetStr)
+		if err != nil || value < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "offset must be a non-negative integer")
+			return
+		}
+		offset = value
+	}
+	routeID := 0
+	if routeStr := r.URL.Query().Get("route_id"); routeStr != "" {
+		value, err := strconv.Atoi(routeStr)
+		if err != nil || value <= 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "route_id must be a positive integer")
+			return
+		}
+		routeID = value
+	}
+
+	routeIDs, err := s.visibleCaptureRoutes(r, routeID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	items, total, err := s.db.ListRequestCaptures(requestOrgID(r), routeIDs, limit, offset)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	for _, item := range items {
+		item.RequestHeaders = redactCaptureHeaders(item.RequestHeaders)
+		item.ResponseHeaders = redactCaptureHeaders(item.ResponseHeaders)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requestCaptureListResponse{
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Items:  items,
+	})
+}
+
+// handleGetRequestCapture 返回一条抓取记录的完整请求和响应，仅管理员可用
+func (s *apiHandlers) handleGetRequestCapture(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+	capture := s.requestCaptureFromRequest(w, r)
+	if capture == nil {
+		return
+	}
+
+	capture.RequestHeaders = redactCaptureHeaders(capture.RequestHeaders)
+	capture.ResponseHeaders = redactCaptureHeaders(capture.ResponseHeaders)
+	detail := requestCaptureDetail{RequestCapture: capture}
+	detail.RequestBody, detail.RequestBodyBase64 = captureBody(capture.RequestBody)
+	detail.ResponseBody, detail.ResponseBodyBase64 = captureBody(capture.ResponseBody)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(detail)
+}
+
+// handleDeleteRequestCapture 删除一条抓取记录，仅管理员可用
+func (s *apiHandlers) handleDeleteRequestCapture(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+	capture := s.requestCaptureFromRequest(w, r)
+	if capture == nil {
+		return
+	}
+
+	if err := s.db.DeleteRequestCapture(capture.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// replayClient 选择重放请求的客户端：直连路由使用路由的客户端，分组路由使用第一个在线且启用的成员
+// 没有可用客户端时返回空字符串
+func (s *apiHandlers) replayClient(route *database.ServerRoute) (string, error) {
+	candidates := []string{route.ClientID}
+	if route.IsGroupRoute() {
+		members, err := s.db.GetClientGroupMembers(route.GroupID)
+		if err != nil {
+			return "", err
+		}
+		candidates = members
+	}
+
+	for _, clientID := range candidates {
+		if !s.wsManager.IsClientConnected(clientID) {
+			continue
+		}
+		if client, err := s.db.GetClient(clientID); err == nil && client.Enabled == 1 {
+			return clientID, nil
+		}
+	}
+	return "", nil
+}
+
+// replayPayload 用抓取的请求和目标路由的当前设置构建请求消息，请求ID换成本次重放的ID
+func replayPayload(capture *database.RequestCapture, route *database.ServerRoute, requestID string) *protocol.RequestPayload {
+	payload := &protocol.RequestPayload{
+		HTTPMethod:     capture.Method,
+		URLSuffix:      capture.URLSuffix,
+		Headers:        make(map[string]string, len(capture.RequestHeaders)),
+		Body:           string(capture.RequestBody),
+		TargetsJSON:    route.TargetsJSON,
+		DeliveryPolicy: route.DeliveryPolicy,
+		RouteMode:      route.RouteMode,
+
+		TimeoutMS:         route.TotalTimeoutMS,
+		ConnectTimeoutMS:  route.ConnectTimeoutMS,
+		HeaderTimeoutMS:   route.HeaderTimeoutMS,
+		BodyIdleTimeoutMS: route.BodyIdleTimeoutMS,
+	}
+	for name, value := range capture.RequestHeaders {
+		payload.Headers[name] = value
+	}
+	payload.Headers[http.CanonicalHeaderKey(utils.RequestIDHeader)] = requestID
+
+	if query, err := url.ParseQuery(capture.Query); err == nil && len(query) > 0 {
+		payload.Params = make(map[string]string, len(query))
+		for name, values := range query {
+			payload.Params[name] = values[0]
+		}
+	}
+	return payload
+}
+
+// handleReplayRequestCapture 通过隧道重新发送抓取的请求并返回新的响应，仅管理员可用
+// 请求体中的 route_id 可以指定另一条本组织的路由，默认使用原请求的路由；请求体被截断或以流式上传的记录不能重放
+// 重放会通过隧道发出请求，需要对原请求的路由和指定的路由都有编辑权限
+// 重放作为新的代理请求记录在 /messages 中，响应头按脱敏规则隐藏
+func (s *apiHandlers) handleReplayRequestCapture(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	var options struct {
+		RouteID *int `json:"route_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&options); err != nil && err != io.EOF {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+
+	capture := s.requestCaptureFromRequest(w, r)
+	if capture == nil {
+		return
+	}
+	if capture.RequestTruncated {
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, utils.ErrCodeValidation, "Request body was not fully captured and cannot be replayed")
+		return
+	}
+
+	// 原请求的路由已删除时只检查指定的路由
+	original, err := s.getOrgRoute(r, capture.RouteID)
+	if err != nil && err != sql.ErrNoRows {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if original != nil && !s.requireRouteEdit(w, r, original) {
+		return
+	}
+
+	routeID := capture.RouteID
+	if options.RouteID != nil {
+		routeID = *options.RouteID
+	}
+	route, err := s.getOrgRoute(r, routeID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if route.ID != capture.RouteID && !s.requireRouteEdit(w, r, route) {
+		return
+	}
+	clientID, err := s.replayClient(route)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if clientID == "" {
+		utils.WriteError(w, r, http.StatusServiceUnavailable, utils.ErrCodeNoBackend, "No connected client for route")
+		return
+	}
+
+	timeout := s.config.RequestTimeout()
+	if route.TotalTimeoutMS > 0 {
+		timeout = time.Duration(route.TotalTimeoutMS) * time.Millisecond
+	}
+	payload := replayPayload(capture, route, utils.GetRequestID(w, r))
+	response, err := s.wsManager.SendRequestAndWaitContext(r.Context(), clientID, payload, timeout)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadGateway, utils.ErrCodeBadGateway, "Replay failed: "+err.Error())
+		return
+	}
+
+	var body []byte
+	switch value := response.Body.(type) {
+	case nil:
+	case string:
+		body = []byte(value)
+	case []byte:
+		body = value
+	default:
+		body = []byte(fmt.Sprintf("%v", value))
+	}
+	result := requestReplayResponse{
+		CaptureID:    capture.ID,
+		RouteID:      route.ID,
+		ClientID:     clientID,
+		HTTPStatus:   response.HTTPStatus,
+		Headers:      redactCaptureHeaders(response.Headers),
+		ResponseSize: len(body),
+		LatencyMS:    response.LatencyMS,
+	}
+	result.Body, result.BodyBase64 = captureBody(body)
+	if response.Error != nil {
+		result.Error = *response.Error
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+	"tunnel-flow/internal/websocket"
+)
+
+// 死信队列API，重新投递次数用完的代理请求进入死信队列，修复后端后可以重新执行
+// 重新执行成功（收到状态码小于400的响应）后从队列中删除
+
+// deadLetterListResponse 死信列表接口的响应
+type deadLetterListResponse struct {
+	Total  int                    `json:"total"`
+	Limit  int                    `json:"limit"`
+	Offset int                    `json:"offset"`
+	Items  []*database.DeadLetter `json:"items"` // 最近进入队列的在前
+}
+
+// deadLetterRetryResponse 重新执行死信的结果
+type deadLetterRetryResponse struct {
+	ID         int64  `json:"id"`
+	Resolved   bool   `json:"resolved"` // 为true时死信已删除
+	HTTPStatus int    `json:"http_status"`
+	Error      string `json:"error,omitempty"`
+}
+
+// deadLetterFromRequest 解析路径中的死信ID并加载当前组织的死信，其他组织的死信视为不存在
+// 返回nil表示已写入错误响应
+func (s *apiHandlers) deadLetterFromRequest(w http.ResponseWriter, r *http.Request) *database.DeadLetter {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid dead letter ID")
+		return nil
+	}
+
+	letter, err := s.db.GetDeadLetter(id)
+	if err == sql.ErrNoRows {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Dead letter not found")
+		return nil
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return nil
+	}
+	if _, err := s.getOrgClient(r, letter.ClientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Dead letter not found")
+		return nil
+	}
+	return letter
+}
+
+// handleGetDeadLetters 分页列出当前组织的死信，可按 client_id 过滤，limit 默认50、最大500，仅管理员可用
+func (s *apiHandlers) handleGetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	limit := defaultMessageLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		value, err := strconv.Atoi(limitStr)
+		if err != nil || value <= 0 || value > maxMessageLimit {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "limit must be between 1 and 500")
+			return
+		}
+		limit = value
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		value, err := strconv.Atoi(offsetStr)
+		if err != nil || value < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "offset must be a non-negative integer")
+			return
+		}
+		offset = value
+	}
+
+	items, total, err := s.db.ListDeadLetters(requestOrgID(r), r.URL.Query().Get("client_id"), limit, offset)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deadLetterListResponse{
+		Total:  total,
+		Limit:  limit,
+		Offset: offset,
+		Items:  items,
+	})
+}
+
+// handleRetryDeadLetter 用保存的请求重新执行死信并等待响应，仅管理员可用
+// 重新执行作为新的代理请求记录在 /messages 中；没有收到响应时返回502，收到失败的响应时记录原因并保留死信
+func (s *apiHandlers) handleRetryDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+	letter := s.deadLetterFromRequest(w, r)
+	if letter == nil {
+		return
+	}
+
+	meta, err := letter.GetRequestMeta()
+	if err != nil {
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, utils.ErrCodeValidation, "Stored request cannot be decoded")
+		return
+	}
+	if !s.wsManager.IsClientConnected(letter.ClientID) {
+		utils.WriteError(w, r, http.StatusServiceUnavailable, utils.ErrCodeNoBackend, "Client is not connected")
+		return
+	}
+
+	timeout := s.config.RequestTimeout()
+	if meta.TimeoutMS > 0 {
+		timeout = time.Duration(meta.TimeoutMS) * time.Millisecond
+	}
+	response, err := s.wsManager.SendRequestAndWaitContext(r.Context(), letter.ClientID,
+		websocket.StoredRequestPayload(letter.URLSuffix, meta), timeout)
+	if err != nil {
+		if err := s.db.UpdateDeadLetterError(letter.ID, err.Error()); err != nil {
+			utils.WriteInternalError(w, r, err)
+			return
+		}
+		utils.WriteError(w, r, http.StatusBadGateway, utils.ErrCodeBadGateway, "Retry failed: "+err.Error())
+		return
+	}
+
+	result := deadLetterRetryResponse{ID: letter.ID, HTTPStatus: response.HTTPStatus}
+	switch {
+	case response.Error != nil:
+		result.Error = *response.Error
+	case response.HTTPStatus >= 400:
+		result.Error = fmt.Sprintf("backend returned status %d", response.HTTPStatus)
+	default:
+		result.Resolved = true
+	}
+	if result.Resolved {
+		err = s.db.DeleteDeadLetter(letter.ID)
+	} else {
+		err = s.db.UpdateDeadLetterError(letter.ID, result.Error)
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/proxy"
+	"tunnel-flow/internal/utils"
+)
+
+// 路由自定义域名API：设置域名时生成验证令牌，通过HTTP或DNS验证域名所有权后代理按请求主机转发，
+// 开启 proxy.tls_port 时第一次访问按需申请证书，见 proxy/domain.go 和 acme.go
+
+const domainVerifyTimeout = 10 * time.Second // 一次所有权验证的超时
+
+// requireRouteDomain 规范化并检查路由的自定义域名，域名修改后重新生成验证令牌并清除之前的验证结果
+// 域名不能是租户域名或其子域名，不能被其他路由使用，也不能预留给其他客户端。返回false表示已写入错误响应
+func (s *apiHandlers) requireRouteDomain(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, previousDomain string) bool {
+	if route.Domain != "" {
+		domain, err := utils.NormalizeDomain(route.Domain)
+		if err != nil || strings.HasPrefix(domain, "*.") {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "domain must be a host name such as app.example.com")
+			return false
+		}
+		route.Domain = domain
+	}
+	if route.Domain == previousDomain {
+		return true
+	}
+
+	route.DomainVerifyMethod, route.DomainVerifyToken, route.DomainVerifiedAt, route.DomainVerifyError = "", "", 0, ""
+	if route.Domain == "" {
+		return true
+	}
+	reason, err := s.checkRouteDomain(route)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if reason != "" {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, reason)
+		return false
+	}
+	route.DomainVerifyToken = generateAuthToken()
+	return true
+}
+
+// checkRouteDomain 检查路由能否使用其自定义域名，返回空字符串表示可以，否则返回拒绝的原因
+func (s *apiHandlers) checkRouteDomain(route *database.ServerRoute) (string, error) {
+	if tenant := strings.ToLower(s.config.ProxyTenantDomain); tenant != "" && (route.Domain == tenant || strings.HasSuffix(route.Domain, "."+tenant)) {
+		return fmt.Sprintf("Domain %s is under the tenant domain %s", route.Domain, tenant), nil
+	}
+	existing, err := s.db.GetServerRouteByDomain(route.Domain)
+	if err == nil && existing.ID != route.ID {
+		return fmt.Sprintf("Domain %s is already used by route %d", route.Domain, existing.ID), nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return s.checkDomainClaim(route.ClientID, route.Domain, false)
+}
+
+// handleVerifyRouteDomain 验证路由自定义域名的所有权并把结果记录在路由中
+// method 为 http（默认）时请求 http://<域名>/.well-known/tunnel-flow/<令牌>，域名需已解析到代理端口；
+// 为 dns 时查询 _tunnel-flow.<域名> 的TXT记录。已通过验证的域名再次验证失败时只记录失败原因
+func (s *apiHandlers) handleVerifyRouteDomain(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Method string `json:"method"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+	if request.Method == "" {
+		request.Method = database.DomainVerifyHTTP
+	}
+	if request.Method != database.DomainVerifyHTTP && request.Method != database.DomainVerifyDNS {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "method must be http or dns")
+		return
+	}
+
+	route := s.routeFromRequest(w, r)
+	if route == nil {
+		return
+	}
+	if route.Domain == "" {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Route has no custom domain")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), domainVerifyTimeout)
+	defer cancel()
+	var verifyErr error
+	if request.Method == database.DomainVerifyDNS {
+		verifyErr = verifyDomainDNS(ctx, route.Domain, route.DomainVerifyToken)
+	} else {
+		verifyErr = verifyDomainHTTP(ctx, route.Domain, route.DomainVerifyToken)
+	}
+
+	if verifyErr != nil {
+		route.DomainVerifyError = verifyErr.Error()
+	} else {
+		route.DomainVerifyMethod = request.Method
+		route.DomainVerifiedAt = time.Now().UnixMilli()
+		route.DomainVerifyError = ""
+	}
+	if err := s.db.UpdateServerRoute(route); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if verifyErr != nil {
+		log.Printf("Domain %s of route %d failed %s verification: %v", route.Domain, route.ID, request.Method, verifyErr)
+		utils.WriteError(w, r, http.StatusUnprocessableEntity, utils.ErrCodeValidation, "Domain verification failed: "+verifyErr.Error())
+		return
+	}
+	log.Printf("Domain %s of route %d verified by %s", route.Domain, route.ID, request.Method)
+
+	converted, err := s.routesForAPI(r, []*database.ServerRoute{route})
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(route.Version))
+	json.NewEncoder(w).Encode(converted[0])
+}
+
+// verifyDomainHTTP 通过域名请求代理端口上的验证路径，响应必须是验证令牌，不跟随重定向
+func verifyDomainHTTP(ctx context.Context, domain, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+domain+proxy.DomainChallengePath+token, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != token {
+		return fmt.Errorf("%s%s did not return the verification token (status %d)", domain, proxy.DomainChallengePath+token, resp.StatusCode)
+	}
+	return nil
+}
+
+// verifyDomainDNS 查询 _tunnel-flow.<域名> 的TXT记录，其中一条必须是验证令牌
+func verifyDomainDNS(ctx context.Context, domain, token string) error {
+	name := proxy.DomainChallengeRecord + domain
+	records, err := net.DefaultResolver.LookupTXT(ctx, name)
+	if err != nil {
+		return fmt.Errorf("TXT lookup of %s failed: %v", name, err)
+	}
+	for _, record := range records {
+		if strings.TrimSpace(record) == token {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record of %s matches the verification token", name)
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 事件流API，以Server-Sent Events向组织管理员推送路由等资源的变化，例如临时路由到期被禁用或删除
+
+// 事件类型
+const (
+	EventRouteExpired = "route.expired" // 临时路由到期，data 中的 action 为 disable 或 delete
+)
+
+// eventStreamBuffer 每个连接缓冲的事件数，处理不及时时丢弃
+const eventStreamBuffer = 64
+
+// Event 事件流中的一个事件，只推送给所属组织的订阅者
+type Event struct {
+	Type  string      `json:"type"`
+	OrgID int         `json:"org_id"`
+	Time  int64       `json:"time"` // 毫秒
+	Data  interface{} `json:"data"`
+}
+
+// eventSubscription 一个事件流连接的订阅
+type eventSubscription struct {
+	ch      chan Event
+	orgID   int
+	dropped int64
+}
+
+// eventHub 事件流的订阅者
+type eventHub struct {
+	mu          sync.Mutex
+	subscribers map[*eventSubscription]struct{}
+}
+
+// newEventHub 创建事件流
+func newEventHub() *eventHub {
+	return &eventHub{subscribers: make(map[*eventSubscription]struct{})}
+}
+
+// subscribe 订阅组织的事件，使用完毕后需调用 unsubscribe
+func (h *eventHub) subscribe(orgID int) *eventSubscription {
+	sub := &eventSubscription{ch: make(chan Event, eventStreamBuffer), orgID: orgID}
+	h.mu.Lock()
+	h.subscribers[sub] = struct{}{}
+	h.mu.Unlock()
+	return sub
+}
+
+// unsubscribe 取消订阅
+func (h *eventHub) unsubscribe(sub *eventSubscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subscribers, sub)
+}
+
+// droppedCount 订阅者因处理不及时丢弃的事件数
+func (h *eventHub) droppedCount(sub *eventSubscription) int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return sub.dropped
+}
+
+// publish 把事件发送给所属组织的订阅者，不阻塞
+func (h *eventHub) publish(eventType string, orgID int, data interface{}) {
+	event := Event{Type: eventType, OrgID: orgID, Time: time.Now().UnixMilli(), Data: data}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subscribers {
+		if sub.orgID != orgID {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+// handleStreamEvents 实时推送当前组织的事件（仅组织管理员），每个事件的SSE事件名为事件类型；
+// 因处理不及时丢弃事件时发送 dropped 事件，包含累计丢弃的条数
+func (s *apiHandlers) handleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	// 长连接不受服务器写超时限制
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	subscription := s.events.subscribe(requestOrgID(r))
+	defer s.events.unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(logStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	var reportedDropped int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		case event := <-subscription.ch:
+			if dropped := s.events.droppedCount(subscription); dropped > reportedDropped {
+				writeStreamEvent(w, "dropped", map[string]int64{"dropped": dropped})
+				reportedDropped = dropped
+			}
+			writeStreamEvent(w, event.Type, event)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/utils"
+)
+
+// 只读（冻结）模式
+// 冻结期间管理API拒绝所有修改请求并返回423，查询、代理转发和客户端连接不受影响
+// 用于故障处理和数据迁移期间防止配置被修改
+
+// freezeExemptPaths 冻结期间仍允许的修改请求：登录、解除冻结和调整日志级别
+var freezeExemptPaths = map[string]bool{
+	"/api/v1/auth/login":      true,
+	"/api/v1/admin/freeze":    true,
+	"/api/v1/admin/log-level": true,
+}
+
+// FreezeStatus 冻结状态
+type FreezeStatus struct {
+	ReadOnly bool   `json:"read_only"`
+	Reason   string `json:"reason,omitempty"`
+	Since    int64  `json:"since,omitempty"` // 进入只读模式的时间（毫秒）
+	By       string `json:"by,omitempty"`    // 开启只读模式的用户，配置文件开启时为空
+}
+
+// FreezeState 只读模式开关，API服务器和各处理函数共享
+type FreezeState struct {
+	mu     sync.RWMutex
+	status FreezeStatus
+}
+
+// NewFreezeState 创建只读模式开关，readOnly 为配置文件中的初始值
+func NewFreezeState(readOnly bool) *FreezeState {
+	state := &FreezeState{}
+	if readOnly {
+		state.status = FreezeStatus{ReadOnly: true, Reason: "read_only enabled in configuration", Since: time.Now().UnixMilli()}
+	}
+	return state
+}
+
+// Status 当前冻结状态
+func (f *FreezeState) Status() FreezeStatus {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.status
+}
+
+// Set 开启或关闭只读模式，通过API修改的状态在重启后恢复为配置值
+func (f *FreezeState) Set(readOnly bool, reason, by string) FreezeStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !readOnly {
+		f.status = FreezeStatus{}
+		return f.status
+	}
+	if !f.status.ReadOnly {
+		f.status.Since = time.Now().UnixMilli()
+	}
+	f.status.ReadOnly = true
+	f.status.Reason = reason
+	f.status.By = by
+	return f.status
+}
+
+// Middleware 冻结期间拒绝修改请求
+func (f *FreezeState) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if freezeExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		status := f.Status()
+		if !status.ReadOnly {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		message := "Server is in read-only mode"
+		if status.Reason != "" {
+			message += ": " + status.Reason
+		}
+		utils.WriteError(w, r, http.StatusLocked, utils.ErrCodeReadOnly, message)
+	})
+}
+
+// handleGetFreeze 查询只读模式状态
+func (s *apiHandlers) handleGetFreeze(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.freeze.Status())
+}
+
+// handleSetFreeze 开启或关闭只读模式（仅平台管理员）
+func (s *apiHandlers) handleSetFreeze(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+
+	var request struct {
+		ReadOnly *bool  `json:"read_only"`
+		Reason   string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	if request.ReadOnly == nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "read_only is required")
+		return
+	}
+
+	user, _ := auth.GetUserFromContext(r.Context())
+	status := s.freeze.Set(*request.ReadOnly, strings.TrimSpace(request.Reason), user.Username)
+	if status.ReadOnly {
+		log.Printf("Read-only mode enabled by %s: %s", user.Username, status.Reason)
+	} else {
+		log.Printf("Read-only mode disabled by %s", user.Username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 客户端分组管理API
+// 分组成员不在访问控制范围内，受限用户只能查看分组，不能修改
+
+// groupFromRequest 解析路径中的分组ID并加载分组
+// 返回nil表示已写入错误响应
+func (s *apiHandlers) groupFromRequest(w http.ResponseWriter, r *http.Request) *database.ClientGroup {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid group ID")
+		return nil
+	}
+
+	group, err := s.getOrgGroup(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client group not found")
+		return nil
+	}
+	return group
+}
+
+// validateClientIDs 检查客户端ID都存在且属于当前组织
+func (s *apiHandlers) validateClientIDs(r *http.Request, clientIDs []string) error {
+	for _, clientID := range clientIDs {
+		if _, err := s.getOrgClient(r, clientID); err != nil {
+			return fmt.Errorf("client %s does not exist", clientID)
+		}
+	}
+	return nil
+}
+
+// withOnlineMembers 为分组附加当前在线成员，便于前端展示可用性
+func (s *apiHandlers) withOnlineMembers(group *database.ClientGroup) map[string]interface{} {
+	online := make([]string, 0)
+	for _, clientID := range group.ClientIDs {
+		if s.wsManager.IsClientConnected(clientID) {
+			online = append(online, clientID)
+		}
+	}
+	return map[string]interface{}{
+		"id":                group.ID,
+		"name":              group.Name,
+		"description":       group.Description,
+		"org_id":            group.OrgID,
+		"client_ids":        group.ClientIDs,
+		"online_client_ids": online,
+		"created_at":        group.CreatedAt,
+		"updated_at":        group.UpdatedAt,
+	}
+}
+
+func (s *apiHandlers) handleGetGroups(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.db.ListClientGroups(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	result := make([]map[string]interface{}, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, s.withOnlineMembers(group))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *apiHandlers) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	group := s.groupFromRequest(w, r)
+	if group == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.withOnlineMembers(group))
+}
+
+func (s *apiHandlers) handleCreateGroup(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+
+	var group database.ClientGroup
+	if err := json.NewDecoder(r.Body).Decode(&group); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	group.Name = strings.TrimSpace(group.Name)
+	if group.Name == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Group name is required")
+		return
+	}
+	if err := s.validateClientIDs(r, group.ClientIDs); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	group.OrgID = requestOrgID(r)
+
+	if err := s.db.CreateClientGroup(&group); err != nil {
+		if database.IsUniqueConstraintError(err) {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Group name already exists")
+			return
+		}
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(s.withOnlineMembers(&group))
+}
+
+func (s *apiHandlers) handleUpdateGroup(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+
+	group := s.groupFromRequest(w, r)
+	if group == nil {
+		return
+	}
+
+	var updateData struct {
+		Name        *string `json:"name"`
+		Description *string `json:"description"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	if updateData.Name != nil {
+		group.Name = strings.TrimSpace(*updateData.Name)
+		if group.Name == "" {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Group name is required")
+			return
+		}
+	}
+	if updateData.Description != nil {
+		group.Description = *updateData.Description
+	}
+
+	if err := s.db.UpdateClientGroup(group); err != nil {
+		if database.IsUniqueConstraintError(err) {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Group name already exists")
+			return
+		}
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.withOnlineMembers(group))
+}
+
+func (s *apiHandlers) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+
+	group := s.groupFromRequest(w, r)
+	if group == nil {
+		return
+	}
+
+	// 仍有路由指向该分组时不允许删除，避免路由失去目标
+	count, err := s.db.CountServerRoutesByGroupID(group.ID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if count > 0 {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Group is still used by %d route(s)", count))
+		return
+	}
+
+	if err := s.db.DeleteClientGroup(group.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleSetGroupMembers 替换分组的全部成员
+func (s *apiHandlers) handleSetGroupMembers(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+
+	group := s.groupFromRequest(w, r)
+	if group == nil {
+		return
+	}
+
+	var request struct {
+		ClientIDs []string `json:"client_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	if err := s.validateClientIDs(r, request.ClientIDs); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+
+	if err := s.db.SetClientGroupMembers(group.ID, request.ClientIDs); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	s.writeGroup(w, r, group.ID)
+}
+
+// handleAddGroupMember 向分组添加单个成员
+func (s *apiHandlers) handleAddGroupMember(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+
+	group := s.groupFromRequest(w, r)
+	if group == nil {
+		return
+	}
+
+	var request struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	if err := s.validateClientIDs(r, []string{request.ClientID}); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+
+	if err := s.db.AddClientGroupMember(group.ID, request.ClientID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	s.writeGroup(w, r, group.ID)
+}
+
+// handleRemoveGroupMember 从分组移除单个成员
+func (s *apiHandlers) handleRemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+
+	group := s.groupFromRequest(w, r)
+	if group == nil {
+		return
+	}
+
+	if err := s.db.RemoveClientGroupMember(group.ID, mux.Vars(r)["client_id"]); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeGroup 重新加载分组并写入响应
+func (s *apiHandlers) writeGroup(w http.ResponseWriter, r *http.Request, id int) {
+	group, err := s.db.GetClientGroup(id)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.withOnlineMembers(group))
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/monitoring"
+	"tunnel-flow/internal/quota"
+	"tunnel-flow/internal/websocket"
+)
+
+// apiHandlers API处理函数及其依赖，单端口的Server和多端口的APIServer共用，
+// 新增API只需实现处理函数并在 registerRoutes 中注册一次
+type apiHandlers struct {
+	config      *config.Config
+	db          *database.Repository
+	authHandler *auth.AuthHandler
+	wsManager   *websocket.Manager
+	traffic     *monitoring.TrafficStats
+	metrics     *monitoring.MetricsCollector // 为空时按路由的代理计数为空
+	quota       *quota.Manager
+	freeze      *FreezeState
+
+	// routeListeners 路由独立端口的注册表，单端口模式下为nil
+	routeListeners *RouteListeners
+
+	// events 推送给事件流订阅者的事件，见 events.go
+	events *eventHub
+
+	// streamsDone 服务器关闭时关闭，结束实时日志流等长连接请求
+	streamsDone     chan struct{}
+	stopStreamsOnce sync.Once
+}
+
+// newAPIHandlers 创建API处理函数
+func newAPIHandlers(cfg *config.Config, db *database.Repository, wsManager *websocket.Manager, traffic *monitoring.TrafficStats, metrics *monitoring.MetricsCollector, quotas *quota.Manager) *apiHandlers {
+	s := &apiHandlers{
+		config:      cfg,
+		db:          db,
+		authHandler: auth.NewAuthHandler(cfg, db),
+		wsManager:   wsManager,
+		traffic:     traffic,
+		metrics:     metrics,
+		quota:       quotas,
+		freeze:      NewFreezeState(cfg.ReadOnly),
+		events:      newEventHub(),
+		streamsDone: make(chan struct{}),
+	}
+	// 代理上报的声明路由按API创建路由的规则校验，见 agent_routes.go
+	wsManager.OnRouteSync = s.syncAgentRoutes
+	return s
+}
+
+// stopStreams 结束所有长连接请求，服务器关闭时调用，否则关闭会一直等待这些请求
+func (s *apiHandlers) stopStreams() {
+	s.stopStreamsOnce.Do(func() { close(s.streamsDone) })
+}
+
+// registerRoutes 在 /api/v1 子路由上注册所有API路由
+func (s *apiHandlers) registerRoutes(api *mux.Router) {
+	api.Use(s.freeze.Middleware)
+
+	// 认证相关路由（公开访问）
+	api.HandleFunc("/auth/login", s.authHandler.Login).Methods("POST")
+	api.HandleFunc("/auth/register", s.authHandler.Register).Methods("POST")
+	api.HandleFunc("/auth/profile", s.authHandler.GetProfile).Methods("GET")
+
+	// 系统状态（公开访问）
+	api.HandleFunc("/status", s.handleGetStatus).Methods("GET")
+	api.HandleFunc("/server-info", s.handleGetServerInfo).Methods("GET")
+	api.HandleFunc("/version", s.handleGetVersion).Methods("GET")
+
+	// 应用认证中间件到需要保护的路由
+	protected := api.PathPrefix("").Subrouter()
+	protected.Use(s.authHandler.GetAuthMiddleware().Middleware)
+
+	// 用户列表（管理员）
+	protected.HandleFunc("/auth/users", s.authHandler.ListUsers).Methods("GET")
+
+	// 客户端管理（需要认证）
+	protected.HandleFunc("/clients", s.handleGetClients).Methods("GET")
+	protected.HandleFunc("/clients/{id}", s.handleGetClient).Methods("GET")
+	protected.HandleFunc("/clients", s.handleCreateClient).Methods("POST")
+	protected.HandleFunc("/clients/{id}", s.handleUpdateClient).Methods("PUT")
+	protected.HandleFunc("/clients/{id}", s.handlePatchClient).Methods("PATCH")
+	protected.HandleFunc("/clients/{id}/status", s.handleUpdateClientStatus).Methods("PUT")
+	protected.HandleFunc("/clients/{id}", s.handleDeleteClient).Methods("DELETE")
+
+	// 客户端启用状态管理（需要认证）
+	protected.HandleFunc("/clients/{id}/enabled", s.handleUpdateClientEnabled).Methods("PUT")
+	protected.HandleFunc("/clients/{id}/stats", s.handleGetClientStats).Methods("GET")
+	protected.HandleFunc("/clients/{id}/metrics", s.handleGetClientMetrics).Methods("GET")
+	protected.HandleFunc("/clients/{id}/history", s.handleGetClientHistory).Methods("GET")
+	protected.HandleFunc("/clients/{id}/allowed-targets", s.handleGetClientAllowedTargets).Methods("GET")
+	protected.HandleFunc("/clients/{id}/allowed-targets", s.handleSetClientAllowedTargets).Methods("PUT")
+	protected.HandleFunc("/clients/{id}/heartbeat", s.handleSetClientHeartbeat).Methods("PUT")
+	protected.HandleFunc("/clients/{id}/benchmark", s.handleBenchmarkClient).Methods("POST")
+	protected.HandleFunc("/clients/{id}/quota", s.handleGetClientQuota).Methods("GET")
+	protected.HandleFunc("/clients/{id}/quota", s.handleSetClientQuota).Methods("PUT")
+	protected.HandleFunc("/clients/{id}/reservations", s.handleGetClientReservations).Methods("GET")
+	protected.HandleFunc("/clients/{id}/reservations", s.handleCreateClientReservation).Methods("POST")
+	protected.HandleFunc("/clients/{id}/reservations/{rid:[0-9]+}", s.handleDeleteClientReservation).Methods("DELETE")
+	protected.HandleFunc("/clients/{id}/quota", s.handleDeleteClientQuota).Methods("DELETE")
+	protected.HandleFunc("/quotas", s.handleGetQuotas).Methods("GET")
+
+	// 路由管理
+	protected.HandleFunc("/routes", s.handleGetRoutes).Methods("GET")
+	protected.HandleFunc("/routes", s.handleCreateRoute).Methods("POST")
+	protected.HandleFunc("/routes/{id:[0-9]+}", s.handleGetRoute).Methods("GET")
+	protected.HandleFunc("/routes/{id:[0-9]+}", s.handleUpdateRoute).Methods("PUT")
+	protected.HandleFunc("/routes/{id:[0-9]+}", s.handlePatchRoute).Methods("PATCH")
+	protected.HandleFunc("/routes/{id:[0-9]+}", s.handleDeleteRoute).Methods("DELETE")
+	protected.HandleFunc("/routes/{id:[0-9]+}/clone", s.handleCloneRoute).Methods("POST")
+	protected.HandleFunc("/routes/{id:[0-9]+}/approve", s.handleApproveRoute).Methods("POST")
+	protected.HandleFunc("/routes/{id:[0-9]+}/reject", s.handleRejectRoute).Methods("POST")
+	protected.HandleFunc("/routes/{id:[0-9]+}/share", s.handleCreateRouteShare).Methods("POST")
+	protected.HandleFunc("/routes/{id:[0-9]+}/share", s.handleRevokeRouteShares).Methods("DELETE")
+	protected.HandleFunc("/routes/{id:[0-9]+}/domain/verify", s.handleVerifyRouteDomain).Methods("POST")
+
+	// 路由启用状态管理
+	protected.HandleFunc("/routes/{id:[0-9]+}/enabled", s.handleUpdateRouteEnabled).Methods("PUT")
+	protected.HandleFunc("/routes/batch/enabled", s.handleBatchUpdateRoutesEnabled).Methods("PUT")
+	protected.HandleFunc("/routes/batch", s.handleBatchDeleteRoutes).Methods("DELETE")
+	protected.HandleFunc("/routes/stats", s.handleGetRouteStats).Methods("GET")
+	protected.HandleFunc("/routes/listeners", s.handleGetRouteListeners).Methods("GET")
+
+	// 统一搜索
+	protected.HandleFunc("/search", s.handleSearch).Methods("GET")
+
+	// 统计概览
+	protected.HandleFunc("/stats/overview", s.handleGetStatsOverview).Methods("GET")
+	protected.HandleFunc("/stats/routes", s.handleGetRouteProxyCounters).Methods("GET")
+	protected.HandleFunc("/traffic", s.handleGetTraffic).Methods("GET")
+	protected.HandleFunc("/usage/export", s.handleExportUsage).Methods("GET")
+
+	// 代理请求记录和死信队列
+	protected.HandleFunc("/messages", s.handleGetMessages).Methods("GET")
+	protected.HandleFunc("/messages/{id}", s.handleGetMessage).Methods("GET")
+	protected.HandleFunc("/dlq", s.handleGetDeadLetters).Methods("GET")
+	protected.HandleFunc("/dlq/{id:[0-9]+}/retry", s.handleRetryDeadLetter).Methods("POST")
+	protected.HandleFunc("/requests", s.handleGetRequestCaptures).Methods("GET")
+	protected.HandleFunc("/requests/{id:[0-9]+}", s.handleGetRequestCapture).Methods("GET")
+	protected.HandleFunc("/requests/{id:[0-9]+}", s.handleDeleteRequestCapture).Methods("DELETE")
+	protected.HandleFunc("/requests/{id:[0-9]+}/replay", s.handleReplayRequestCapture).Methods("POST")
+
+	// 客户端分组
+	protected.HandleFunc("/groups", s.handleGetGroups).Methods("GET")
+	protected.HandleFunc("/groups", s.handleCreateGroup).Methods("POST")
+	protected.HandleFunc("/groups/{id:[0-9]+}", s.handleGetGroup).Methods("GET")
+	protected.HandleFunc("/groups/{id:[0-9]+}", s.handleUpdateGroup).Methods("PUT")
+	protected.HandleFunc("/groups/{id:[0-9]+}", s.handleDeleteGroup).Methods("DELETE")
+	protected.HandleFunc("/groups/{id:[0-9]+}/members", s.handleSetGroupMembers).Methods("PUT")
+	protected.HandleFunc("/groups/{id:[0-9]+}/members", s.handleAddGroupMember).Methods("POST")
+	protected.HandleFunc("/groups/{id:[0-9]+}/members/{client_id}", s.handleRemoveGroupMember).Methods("DELETE")
+
+	// 组织（多租户）
+	protected.HandleFunc("/orgs", s.handleGetOrgs).Methods("GET")
+	protected.HandleFunc("/orgs", s.handleCreateOrg).Methods("POST")
+	protected.HandleFunc("/orgs/{id:[0-9]+}", s.handleGetOrg).Methods("GET")
+	protected.HandleFunc("/orgs/{id:[0-9]+}", s.handleUpdateOrg).Methods("PUT")
+	protected.HandleFunc("/orgs/{id:[0-9]+}", s.handleDeleteOrg).Methods("DELETE")
+	protected.HandleFunc("/orgs/{id:[0-9]+}/signing-key/rotate", s.handleRotateOrgSigningKey).Methods("POST")
+	protected.HandleFunc("/orgs/{id:[0-9]+}/users", s.handleGetOrgUsers).Methods("GET")
+	protected.HandleFunc("/orgs/{id:[0-9]+}/users", s.handleCreateOrgUser).Methods("POST")
+	protected.HandleFunc("/orgs/{id:[0-9]+}/users/{user_id}", s.handleDeleteOrgUser).Methods("DELETE")
+
+	// 组织API密钥
+	protected.HandleFunc("/api-keys", s.handleGetAPIKeys).Methods("GET")
+	protected.HandleFunc("/api-keys", s.handleCreateAPIKey).Methods("POST")
+	protected.HandleFunc("/api-keys/{id:[0-9]+}", s.handleDeleteAPIKey).Methods("DELETE")
+	protected.HandleFunc("/api-keys/{id:[0-9]+}/rotate", s.handleRotateAPIKey).Methods("POST")
+
+	// 路由和客户端访问控制
+	protected.HandleFunc("/acls", s.handleGetACLs).Methods("GET")
+	protected.HandleFunc("/acls", s.handleCreateACL).Methods("POST")
+	protected.HandleFunc("/acls/me", s.handleGetMyACLs).Methods("GET")
+	protected.HandleFunc("/acls/{id:[0-9]+}", s.handleDeleteACL).Methods("DELETE")
+
+	// 只读模式
+	protected.HandleFunc("/admin/freeze", s.handleGetFreeze).Methods("GET")
+	protected.HandleFunc("/admin/freeze", s.handleSetFreeze).Methods("PUT")
+
+	// 日志级别
+	protected.HandleFunc("/admin/log-level", s.handleGetLogLevel).Methods("GET")
+	protected.HandleFunc("/admin/log-level", s.handleSetLogLevel).Methods("PUT")
+	protected.HandleFunc("/admin/log-sinks", s.handleGetLogSinks).Methods("GET")
+	protected.HandleFunc("/logs/stream", s.handleStreamLogs).Methods("GET")
+
+	// 事件流
+	protected.HandleFunc("/events/stream", s.handleStreamEvents).Methods("GET")
+}
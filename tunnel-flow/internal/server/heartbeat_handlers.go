@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+)
+
+// 客户端心跳设置API，网络不稳定的代理（如卫星链路）可以使用比局域网代理更长的心跳超时
+
+// heartbeatResponse 心跳设置接口的响应
+type heartbeatResponse struct {
+	ClientID          string `json:"client_id"`
+	HeartbeatInterval int    `json:"heartbeat_interval"` // 心跳间隔（秒）
+	HeartbeatTimeout  int    `json:"heartbeat_timeout"`  // 超过该时间（秒）没有收到消息时断开连接
+	Online            bool   `json:"online"`             // 客户端在线时已立即下发，否则在下次注册时下发
+}
+
+// handleSetClientHeartbeat 修改客户端的心跳间隔和超时，客户端在线时立即下发并按新的超时检查连接
+func (s *apiHandlers) handleSetClientHeartbeat(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+
+	var request struct {
+		HeartbeatInterval int `json:"heartbeat_interval"`
+		HeartbeatTimeout  int `json:"heartbeat_timeout"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	if request.HeartbeatInterval <= 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "heartbeat_interval must be a positive integer")
+		return
+	}
+	if request.HeartbeatTimeout <= request.HeartbeatInterval {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "heartbeat_timeout must be greater than heartbeat_interval")
+		return
+	}
+
+	if err := s.db.UpdateClientHeartbeat(clientID, request.HeartbeatInterval, request.HeartbeatTimeout); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	settings := &protocol.HeartbeatSettings{
+		IntervalSeconds: request.HeartbeatInterval,
+		TimeoutSeconds:  request.HeartbeatTimeout,
+	}
+	if err := s.wsManager.PushHeartbeat(clientID, settings); err != nil {
+		log.Printf("Failed to push heartbeat settings to client %s: %v", clientID, err)
+	}
+
+	_, online := s.wsManager.ClientMetrics(clientID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(heartbeatResponse{
+		ClientID:          clientID,
+		HeartbeatInterval: request.HeartbeatInterval,
+		HeartbeatTimeout:  request.HeartbeatTimeout,
+		Online:            online,
+	})
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 客户端连接历史API，从中可以看出频繁断线重连的代理
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// connectionHistoryResponse 连接历史接口的响应
+type connectionHistoryResponse struct {
+	ClientID string                        `json:"client_id"`
+	Total    int                           `json:"total"`
+	Limit    int                           `json:"limit"`
+	Offset   int                           `json:"offset"`
+	Items    []*database.ConnectionHistory `json:"items"` // 最近的连接在前
+}
+
+// handleGetClientHistory 分页返回客户端的连接和断开记录，limit 默认50、最大500，offset 默认0
+func (s *apiHandlers) handleGetClientHistory(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		value, err := strconv.Atoi(limitStr)
+		if err != nil || value <= 0 || value > maxHistoryLimit {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "limit must be between 1 and 500")
+			return
+		}
+		limit = value
+	}
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		value, err := strconv.Atoi(offsetStr)
+		if err != nil || value < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "offset must be a non-negative integer")
+			return
+		}
+		offset = value
+	}
+
+	items, total, err := s.db.ListConnectionHistory(clientID, limit, offset)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(connectionHistoryResponse{
+		ClientID: clientID,
+		Total:    total,
+		Limit:    limit,
+		Offset:   offset,
+		Items:    items,
+	})
+}
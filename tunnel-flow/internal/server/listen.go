@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"tunnel-flow/internal/config"
+)
+
+// listen 按配置的网络监听：unix 监听unix套接字，其他监听TCP地址
+func listen(cfg *config.Config, network, addr string) (net.Listener, error) {
+	if network == "unix" {
+		return listenUnix(addr, cfg.UnixSocketFileMode())
+	}
+	return listenTCP(network, addr, cfg.ReusePort)
+}
+
+// listenTCP 监听TCP地址，network 为 tcp4 或 tcp6 时只监听对应的协议，reusePort 为 true 时设置 SO_REUSEPORT，
+// 新进程可以在旧进程仍在监听时绑定同一端口，旧进程停止接受连接后由新进程接替
+func listenTCP(network, addr string, reusePort bool) (net.Listener, error) {
+	if !reusePort {
+		return net.Listen(network, addr)
+	}
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// listenUnix 监听unix套接字并设置文件权限，上次运行异常退出遗留的套接字文件会被删除，
+// 仍有进程在该路径上提供服务时返回错误；监听器关闭时删除套接字文件
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a unix socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale unix socket %s: %w", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of unix socket %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// listenURL 返回监听地址的展示形式，unix套接字显示为 unix:路径
+func listenURL(scheme, network, address string) string {
+	if network == "unix" {
+		return config.UnixSocketPrefix + address
+	}
+	return scheme + "://" + address
+}
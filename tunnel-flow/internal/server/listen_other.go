@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package server
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+)
+
+// reusePortControl 当前平台不支持 SO_REUSEPORT
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("server.reuse_port is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/logging"
+	"tunnel-flow/internal/utils"
+)
+
+// LogLevelStatus 全局和各组件当前生效的日志级别
+type LogLevelStatus struct {
+	Level      string            `json:"level"`
+	Components map[string]string `json:"components"`
+}
+
+// currentLogLevels 当前日志级别
+func currentLogLevels() LogLevelStatus {
+	return LogLevelStatus{
+		Level:      logging.GetLevel().String(),
+		Components: logging.ComponentLevels(),
+	}
+}
+
+// handleGetLogLevel 查询日志级别
+func (s *apiHandlers) handleGetLogLevel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentLogLevels())
+}
+
+// handleSetLogLevel 修改全局或单个组件的日志级别（仅平台管理员），只在内存中生效
+// component 为空时修改全局级别；level 为 default 时取消组件的单独设置，恢复使用全局级别
+func (s *apiHandlers) handleSetLogLevel(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+
+	var request struct {
+		Component string `json:"component"`
+		Level     string `json:"level"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	component := strings.TrimSpace(request.Component)
+
+	var err error
+	if component != "" && strings.EqualFold(strings.TrimSpace(request.Level), "default") {
+		err = logging.ResetComponentLevel(component)
+	} else {
+		var level logging.LogLevel
+		level, err = logging.ParseLevel(request.Level)
+		if err == nil {
+			if component == "" {
+				logging.SetLevel(level)
+			} else {
+				err = logging.SetComponentLevel(component, level)
+			}
+		}
+	}
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation,
+			err.Error()+"; components: "+strings.Join(logging.Components(), ", "))
+		return
+	}
+
+	user, _ := auth.GetUserFromContext(r.Context())
+	if component == "" {
+		log.Printf("Log level set to %s by %s", strings.ToUpper(request.Level), user.Username)
+	} else {
+		log.Printf("Log level of component %s set to %s by %s", component, strings.ToUpper(request.Level), user.Username)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentLogLevels())
+}
+
+// handleGetLogSinks 查询 syslog、Loki 等日志输出的状态、缓冲和丢弃计数（仅平台管理员）
+func (s *apiHandlers) handleGetLogSinks(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"sinks": logging.SinkStatuses(),
+	})
+}
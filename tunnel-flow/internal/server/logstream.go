@@ -0,0 +1,128 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tunnel-flow/internal/logging"
+	"tunnel-flow/internal/utils"
+)
+
+// 实时日志流API，以Server-Sent Events推送服务端日志，浏览器的 EventSource 可以通过 ?token= 认证
+
+const (
+	logStreamBuffer     = 256              // 每个连接缓冲的日志条数，处理不及时时丢弃
+	logStreamKeepAlive  = 15 * time.Second // 没有日志时发送注释行，防止代理断开空闲连接
+	maxLogStreamBacklog = 500              // backlog 参数的最大值
+)
+
+// parseLogStreamFilter 解析 level（最低级别）和 component（逗号分隔的组件名）参数
+func parseLogStreamFilter(query url.Values) (logging.StreamFilter, error) {
+	filter := logging.StreamFilter{MinLevel: logging.DEBUG}
+	if levelName := query.Get("level"); levelName != "" {
+		level, err := logging.ParseLevel(levelName)
+		if err != nil {
+			return filter, err
+		}
+		filter.MinLevel = level
+	}
+
+	if components := query.Get("component"); components != "" {
+		known := map[string]bool{logging.ComponentGeneral: true}
+		for _, name := range logging.Components() {
+			known[name] = true
+		}
+		filter.Components = make(map[string]bool)
+		for _, name := range strings.Split(components, ",") {
+			name = strings.TrimSpace(name)
+			if !known[name] {
+				return filter, fmt.Errorf("unknown log component %q", name)
+			}
+			filter.Components[name] = true
+		}
+	}
+	return filter, nil
+}
+
+// handleStreamLogs 实时推送服务端日志（仅平台管理员），backlog 指定先返回的最近日志条数
+// 每条日志是一个 log 事件；因处理不及时丢弃日志时发送 dropped 事件，包含累计丢弃的条数
+func (s *apiHandlers) handleStreamLogs(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+
+	filter, err := parseLogStreamFilter(r.URL.Query())
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	backlog := 0
+	if backlogStr := r.URL.Query().Get("backlog"); backlogStr != "" {
+		backlog, err = strconv.Atoi(backlogStr)
+		if err != nil || backlog < 0 || backlog > maxLogStreamBacklog {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("backlog must be between 0 and %d", maxLogStreamBacklog))
+			return
+		}
+	}
+
+	// 长连接不受服务器写超时限制
+	controller := http.NewResponseController(w)
+	controller.SetWriteDeadline(time.Time{})
+
+	// 先订阅再读取最近的日志，避免两者之间的日志丢失
+	subscription := logging.Subscribe(filter, logStreamBuffer)
+	defer subscription.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	for _, entry := range logging.Recent(filter, backlog) {
+		writeStreamEvent(w, "log", entry)
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(logStreamKeepAlive)
+	defer keepAlive.Stop()
+
+	var reportedDropped int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			return
+		case entry, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			if dropped := subscription.Dropped(); dropped > reportedDropped {
+				writeStreamEvent(w, "dropped", map[string]int64{"dropped": dropped})
+				reportedDropped = dropped
+			}
+			writeStreamEvent(w, "log", entry)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamEvent 写入一个Server-Sent Events事件
+func writeStreamEvent(w http.ResponseWriter, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
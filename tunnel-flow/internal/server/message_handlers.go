@@ -0,0 +1,180 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 代理请求查询API，每个经代理转发的请求都记录在 pending_messages 中，
+// 状态依次为 pending（已创建）、processing（已发给代理）、done/failed/cancelled
+
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 500
+)
+
+// MessageView 接口返回的代理请求，请求和响应元数据只在详情中返回
+type MessageView struct {
+	MsgID          string                 `json:"msg_id"`
+	ClientID       string                 `json:"client_id"`
+	URLSuffix      string                 `json:"url_suffix"`
+	State          string                 `json:"state"`
+	RetryCount     int                    `json:"retry_count"`
+	NextTryTS      int64                  `json:"next_try_ts"`
+	CreatedAt      int64                  `json:"created_at"`
+	LastUpdate     int64                  `json:"last_update"`
+	IdempotencyKey string                 `json:"idempotency_key,omitempty"`
+	LastError      string                 `json:"last_error,omitempty"`
+	Request        *database.RequestMeta  `json:"request,omitempty"`
+	Response       *database.ResponseMeta `json:"response,omitempty"`
+}
+
+// messageListResponse 代理请求列表接口的响应
+type messageListResponse struct {
+	Total  int            `json:"total"`
+	Limit  int            `json:"limit"`
+	Offset int            `json:"offset"`
+	Items  []*MessageView `json:"items"` // 最新的请求在前
+}
+
+// newMessageView 转换为接口返回的代理请求，withMeta 为true时解析请求和响应元数据
+func newMessageView(msg *database.PendingMessage, withMeta bool) *MessageView {
+	view := &MessageView{
+		MsgID:          msg.MsgID,
+		ClientID:       msg.ClientID,
+		URLSuffix:      msg.URLSuffix,
+		State:          msg.State,
+		RetryCount:     msg.RetryCount,
+		NextTryTS:      msg.NextTryTS,
+		CreatedAt:      msg.CreatedAt,
+		LastUpdate:     msg.LastUpdate,
+		IdempotencyKey: msg.IdempotencyKey,
+		LastError:      msg.LastError,
+	}
+	if withMeta {
+		if meta, err := msg.GetRequestMeta(); err == nil {
+			view.Request = meta
+		}
+		if meta, err := msg.GetResponseMeta(); err == nil {
+			view.Response = meta
+		}
+	}
+	return view
+}
+
+// isMessageState 检查是否为有效的消息状态
+func isMessageState(state string) bool {
+	switch state {
+	case database.MessageStatePending, database.MessageStateProcessing, database.MessageStateDone,
+		database.MessageStateFailed, database.MessageStateCancelled:
+		return true
+	}
+	return false
+}
+
+// handleGetMessages 分页查询当前组织的代理请求，仅管理员可用
+// 支持 state、client_id、url_suffix、from/to（创建时间，毫秒时间戳、RFC3339 或 YYYY-MM-DD）过滤，limit 默认50、最大500
+func (s *apiHandlers) handleGetMessages(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	filter := database.PendingMessageFilter{
+		OrgID:     requestOrgID(r),
+		State:     query.Get("state"),
+		ClientID:  query.Get("client_id"),
+		URLSuffix: query.Get("url_suffix"),
+		Limit:     defaultMessageLimit,
+	}
+	if filter.State != "" && !isMessageState(filter.State) {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "state must be one of pending, processing, done, failed, cancelled")
+		return
+	}
+	if value := query.Get("from"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid from: use unix milliseconds, RFC3339 or YYYY-MM-DD")
+			return
+		}
+		filter.From = t.UnixMilli()
+	}
+	if value := query.Get("to"); value != "" {
+		t, err := parseTimeParam(value, true)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid to: use unix milliseconds, RFC3339 or YYYY-MM-DD")
+			return
+		}
+		filter.To = t.UnixMilli()
+	}
+	if filter.From > 0 && filter.To > 0 && filter.From >= filter.To {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "from must be earlier than to")
+		return
+	}
+	if limitStr := query.Get("limit"); limitStr != "" {
+		value, err := strconv.Atoi(limitStr)
+		if err != nil || value <= 0 || value > maxMessageLimit {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "limit must be between 1 and 500")
+			return
+		}
+		filter.Limit = value
+	}
+	if offsetStr := query.Get("offset"); offsetStr != "" {
+		value, err := strconv.Atoi(offsetStr)
+		if err != nil || value < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "offset must be a non-negative integer")
+			return
+		}
+		filter.Offset = value
+	}
+
+	messages, total, err := s.db.QueryPendingMessages(filter)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	items := make([]*MessageView, 0, len(messages))
+	for _, msg := range messages {
+		items = append(items, newMessageView(msg, false))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messageListResponse{
+		Total:  total,
+		Limit:  filter.Limit,
+		Offset: filter.Offset,
+		Items:  items,
+	})
+}
+
+// handleGetMessage 返回代理请求的详情，包括请求和响应元数据，仅管理员可用
+func (s *apiHandlers) handleGetMessage(w http.ResponseWriter, r *http.Request) {
+	if _, ok := requireOrgAdmin(w, r); !ok {
+		return
+	}
+
+	msg, err := s.db.GetPendingMessage(mux.Vars(r)["id"])
+	if err == sql.ErrNoRows {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Message not found")
+		return
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	// 其他组织客户端的请求视为不存在
+	if _, err := s.getOrgClient(r, msg.ClientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Message not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newMessageView(msg, true))
+}
@@ -0,0 +1,457 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 组织（多租户）管理API
+// 客户端、路由、分组和用户都属于一个组织，所有管理接口只能看到当前用户所在组织的数据
+// 默认组织的管理员是平台管理员，可以创建和删除组织
+
+// requestOrgID 请求所属组织，未经认证的内部调用视为默认组织
+func requestOrgID(r *http.Request) int {
+	if orgID := auth.OrgIDFromContext(r.Context()); orgID > 0 {
+		return orgID
+	}
+	return database.DefaultOrgID
+}
+
+// getOrgClient 获取当前组织内当前用户可见的客户端，其他组织或无权查看的客户端视为不存在
+func (s *apiHandlers) getOrgClient(r *http.Request, clientID string) (*database.Client, error) {
+	client, err := s.db.GetClient(clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.OrgID != requestOrgID(r) {
+		return nil, sql.ErrNoRows
+	}
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		return nil, err
+	}
+	if client.Permission = scope.clientPermission(clientID); client.Permission == "" {
+		return nil, sql.ErrNoRows
+	}
+	return client, nil
+}
+
+// getOrgRoute 获取当前组织内当前用户可见的路由，其他组织或无权查看的路由视为不存在
+func (s *apiHandlers) getOrgRoute(r *http.Request, id int) (*database.ServerRoute, error) {
+	route, err := s.db.GetServerRoute(id)
+	if err != nil {
+		return nil, err
+	}
+	if route.OrgID != requestOrgID(r) {
+		return nil, sql.ErrNoRows
+	}
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		return nil, err
+	}
+	if scope.routePermission(route) == "" {
+		return nil, sql.ErrNoRows
+	}
+	return route, nil
+}
+
+// getOrgGroup 获取当前组织内的客户端分组，其他组织的分组视为不存在
+func (s *apiHandlers) getOrgGroup(r *http.Request, id int) (*database.ClientGroup, error) {
+	group, err := s.db.GetClientGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	if group.OrgID != requestOrgID(r) {
+		return nil, sql.ErrNoRows
+	}
+	return group, nil
+}
+
+// orgFromRequest 解析路径中的组织ID并校验访问权限，requireAdmin 表示需要该组织的管理员
+// 平台管理员可以访问所有组织，其他用户访问别的组织时返回404
+// 返回nil表示已写入错误响应
+func (s *apiHandlers) orgFromRequest(w http.ResponseWriter, r *http.Request, requireAdmin bool) *database.Organization {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid organization ID")
+		return nil
+	}
+
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Unauthorized")
+		return nil
+	}
+	if !user.IsPlatformAdmin() {
+		if user.EffectiveOrgID() != id {
+			utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Organization not found")
+			return nil
+		}
+		if requireAdmin && user.Role != auth.RoleAdmin {
+			utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: admin access required")
+			return nil
+		}
+	}
+
+	org, err := s.db.GetOrganization(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Organization not found")
+			return nil
+		}
+		utils.WriteInternalError(w, r, err)
+		return nil
+	}
+	return org
+}
+
+// requirePlatformAdmin 要求平台管理员，返回false表示已写入错误响应
+func requirePlatformAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok || !user.IsPlatformAdmin() {
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Forbidden: platform admin access required")
+		return false
+	}
+	return true
+}
+
+// slugShadowsRoutes 代理按路径第一段区分组织，组织标识与默认组织路由的第一段相同时这些路由将无法访问
+func (s *apiHandlers) slugShadowsRoutes(slug string) (bool, error) {
+	routes, err := s.db.ListServerRoutesByOrg(database.DefaultOrgID)
+	if err != nil {
+		return false, err
+	}
+	for _, route := range routes {
+		segment := strings.SplitN(strings.TrimPrefix(route.URLSuffix, "/"), "/", 2)[0]
+		if segment == slug {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// routeSuffixConflict 反过来检查默认组织的路由：路径第一段与已有的组织标识相同时，代理会把请求分给该组织，路由无法访问
+// 返回拒绝的原因，没有冲突时返回空字符串
+func (s *apiHandlers) routeSuffixConflict(route *database.ServerRoute) (string, error) {
+	if route.OrgID != database.DefaultOrgID || route.ListenPort > 0 {
+		return "", nil
+	}
+	segment := strings.SplitN(strings.TrimPrefix(route.URLSuffix, "/"), "/", 2)[0]
+	if !utils.IsValidSlug(segment) {
+		return "", nil
+	}
+	if _, err := s.db.GetOrganizationBySlug(segment); err == sql.ErrNoRows {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("url_suffix conflicts with organization %q: requests under /%s are routed to that organization", segment, segment), nil
+}
+
+// requireRouteSuffix 创建或修改路由路径时检查 routeSuffixConflict，路径未修改时不检查
+// 返回false表示已写入错误响应
+func (s *apiHandlers) requireRouteSuffix(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, previousSuffix string) bool {
+	if route.URLSuffix == previousSuffix {
+		return true
+	}
+	reason, err := s.routeSuffixConflict(route)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if reason != "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, reason)
+		return false
+	}
+	return true
+}
+
+// handleGetOrgs 列出组织，平台管理员可以看到所有组织，其他用户只能看到自己的组织
+func (s *apiHandlers) handleGetOrgs(w http.ResponseWriter, r *http.Request) {
+	user, ok := auth.GetUserFromContext(r.Context())
+	if !ok {
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Unauthorized")
+		return
+	}
+
+	var orgs []*database.Organization
+	if user.IsPlatformAdmin() {
+		var err error
+		orgs, err = s.db.ListOrganizations()
+		if err != nil {
+			utils.WriteInternalError(w, r, err)
+			return
+		}
+	} else {
+		org, err := s.db.GetOrganization(user.EffectiveOrgID())
+		if err != nil {
+			utils.WriteInternalError(w, r, err)
+			return
+		}
+		orgs = []*database.Organization{org}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(orgs)
+}
+
+// handleCreateOrg 创建组织（仅平台管理员），可同时创建该组织的第一个管理员
+func (s *apiHandlers) handleCreateOrg(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+
+	var request struct {
+		Slug          string `json:"slug"`
+		Name          string `json:"name"`
+		AdminUsername string `json:"admin_username"`
+		AdminPassword string `json:"admin_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	request.Slug = strings.TrimSpace(request.Slug)
+	request.Name = strings.TrimSpace(request.Name)
+	if !utils.IsValidSlug(request.Slug) {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "slug must be 2-63 lowercase letters, digits or hyphens")
+		return
+	}
+	if request.Name == "" {
+		request.Name = request.Slug
+	}
+	if (request.AdminUsername == "") != (request.AdminPassword == "") {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "admin_username and admin_password must be provided together")
+		return
+	}
+	shadowed, err := s.slugShadowsRoutes(request.Slug)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if shadowed {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Slug conflicts with existing default organization routes under /%s", request.Slug))
+		return
+	}
+	if request.AdminUsername != "" {
+		if _, err := s.db.GetUserByUsername(request.AdminUsername); err == nil {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Username already exists")
+			return
+		}
+	}
+
+	org := &database.Organization{Slug: request.Slug, Name: request.Name, SigningKey: auth.NewSigningKey()}
+	if err := s.db.CreateOrganization(org); err != nil {
+		if database.IsUniqueConstraintError(err) {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Organization slug already exists")
+			return
+		}
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	response := struct {
+		*database.Organization
+		Admin *auth.User `json:"admin,omitempty"`
+	}{Organization: org}
+	if request.AdminUsername != "" {
+		admin, err := s.authHandler.CreateUser(request.AdminUsername, request.AdminPassword, auth.RoleAdmin, org.ID)
+		if err != nil {
+			utils.WriteInternalError(w, r, err)
+			return
+		}
+		response.Admin = admin
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *apiHandlers) handleGetOrg(w http.ResponseWriter, r *http.Request) {
+	org := s.orgFromRequest(w, r, false)
+	if org == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// handleUpdateOrg 修改组织名称，标识创建后不可修改
+func (s *apiHandlers) handleUpdateOrg(w http.ResponseWriter, r *http.Request) {
+	org := s.orgFromRequest(w, r, true)
+	if org == nil {
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+	org.Name = strings.TrimSpace(request.Name)
+	if org.Name == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Organization name is required")
+		return
+	}
+
+	if err := s.db.UpdateOrganization(org); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(org)
+}
+
+// handleDeleteOrg 删除组织及其用户（仅平台管理员），组织内仍有客户端、路由或分组时不允许删除
+func (s *apiHandlers) handleDeleteOrg(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+	org := s.orgFromRequest(w, r, true)
+	if org == nil {
+		return
+	}
+
+	if org.ID == database.DefaultOrgID {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "The default organization cannot be deleted")
+		return
+	}
+
+	count, err := s.db.CountOrganizationResources(org.ID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	if count > 0 {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Organization still owns %d client(s), route(s) or group(s)", count))
+		return
+	}
+
+	if err := s.db.DeleteOrganization(org.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.authHandler.GetAuthMiddleware().ForgetOrganization(org.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRotateOrgSigningKey 轮换组织的JWT签名密钥，该组织已签发的token全部失效，用户需要重新登录
+func (s *apiHandlers) handleRotateOrgSigningKey(w http.ResponseWriter, r *http.Request) {
+	org := s.orgFromRequest(w, r, true)
+	if org == nil {
+		return
+	}
+
+	if err := s.authHandler.GetAuthMiddleware().RotateSigningKey(org.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	log.Printf("Signing key rotated for organization %s", org.Slug)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleGetOrgUsers 列出组织内的用户
+func (s *apiHandlers) handleGetOrgUsers(w http.ResponseWriter, r *http.Request) {
+	org := s.orgFromRequest(w, r, true)
+	if org == nil {
+		return
+	}
+
+	users, err := s.authHandler.ListOrgUsers(org.ID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+// handleCreateOrgUser 在组织内创建用户，角色默认为 user
+func (s *apiHandlers) handleCreateOrgUser(w http.ResponseWriter, r *http.Request) {
+	org := s.orgFromRequest(w, r, true)
+	if org == nil {
+		return
+	}
+
+	var request struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	request.Username = strings.TrimSpace(request.Username)
+	if request.Username == "" || request.Password == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Username and password are required")
+		return
+	}
+	if request.Role == "" {
+		request.Role = auth.RoleUser
+	}
+	if request.Role != auth.RoleUser && request.Role != auth.RoleAdmin {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "role must be admin or user")
+		return
+	}
+
+	user, err := s.authHandler.CreateUser(request.Username, request.Password, request.Role, org.ID)
+	if err == auth.ErrUsernameExists {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Username already exists")
+		return
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(user)
+}
+
+// handleDeleteOrgUser 删除组织内的用户，不能删除自己
+func (s *apiHandlers) handleDeleteOrgUser(w http.ResponseWriter, r *http.Request) {
+	org := s.orgFromRequest(w, r, true)
+	if org == nil {
+		return
+	}
+
+	userID := mux.Vars(r)["user_id"]
+	user, err := s.db.GetUser(userID)
+	if err != nil || user.OrgID != org.ID {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "User not found")
+		return
+	}
+	if current, ok := auth.GetUserFromContext(r.Context()); ok && current.UserID == user.ID {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "You cannot delete your own account")
+		return
+	}
+
+	if err := s.db.DeleteUser(user.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
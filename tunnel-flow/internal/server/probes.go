@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// livenessTimeout 存活检查的超时时间，超时说明进程卡死
+const livenessTimeout = 5 * time.Second
+
+// probeState 存活和就绪探针的状态，API、WebSocket和代理端口共用，用于 Kubernetes 的 livenessProbe 和 readinessProbe：
+// /live 只检查进程没有卡死，失败时应重启；/ready 在端口都绑定成功、数据库可用且没有开始停止时才返回200，失败时只摘除流量
+type probeState struct {
+	bound    atomic.Bool // API、WebSocket和代理端口都绑定成功
+	draining atomic.Bool // 收到停止信号，等待摘除流量或正在断开连接
+	// check 检查客户端连接表和数据库能否访问，死锁时会阻塞
+	check func() error
+	// degraded 数据库是否处于降级状态
+	degraded func() bool
+}
+
+// probeResult 探针的响应
+type probeResult struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleLive 存活探针：检查在超时前完成即返回200，数据库出错不算卡死
+func (p *probeState) handleLive(w http.ResponseWriter, r *http.Request) {
+	if p == nil || p.check == nil {
+		writeProbe(w, "")
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.check()
+	}()
+	select {
+	case <-done:
+		writeProbe(w, "")
+	case <-time.After(livenessTimeout):
+		writeProbe(w, "liveness check timed out")
+	}
+}
+
+// handleReady 就绪探针：返回当前实例是否应该接收流量
+func (p *probeState) handleReady(w http.ResponseWriter, r *http.Request) {
+	writeProbe(w, p.notReadyReason())
+}
+
+// notReadyReason 返回不能接收流量的原因，就绪时返回空字符串
+func (p *probeState) notReadyReason() string {
+	switch {
+	case p == nil:
+		return ""
+	case p.draining.Load():
+		return "shutting down"
+	case !p.bound.Load():
+		return "starting"
+	case p.degraded != nil && p.degraded():
+		return "database degraded"
+	}
+	return ""
+}
+
+// writeProbe 写入探针响应，reason 非空时返回503
+func writeProbe(w http.ResponseWriter, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	result := probeResult{Status: "ok"}
+	if reason != "" {
+		result = probeResult{Status: "unavailable", Reason: reason}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(result)
+}
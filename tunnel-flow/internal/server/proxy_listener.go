@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// proxyListener 代理端口的监听器，限制并发连接数并统计异常连接
+// 达到上限时新连接被立即关闭而不是排队等待，避免慢速客户端占满连接后拖住正常请求
+type proxyListener struct {
+	net.Listener
+	maxConnections int64
+
+	active   atomic.Int64 // 当前打开的连接数
+	rejected atomic.Int64 // 因达到上限被关闭的连接数
+	idle     atomic.Int64 // 没有发送完整请求就被关闭的连接数（读取请求头超时、请求头过大等）
+}
+
+// proxyConn 代理端口的连接，served 记录是否有请求到达处理函数
+type proxyConn struct {
+	net.Conn
+	served atomic.Bool
+}
+
+// proxyConnKey 请求上下文中保存 proxyConn 的键
+type proxyConnKey struct{}
+
+// newProxyListener 包装监听器，maxConnections 不大于0时不限制
+func newProxyListener(listener net.Listener, maxConnections int) *proxyListener {
+	return &proxyListener{Listener: listener, maxConnections: int64(maxConnections)}
+}
+
+// Accept 接受新连接，达到上限时关闭并继续等待下一个连接
+func (l *proxyListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.maxConnections > 0 && l.active.Load() >= l.maxConnections {
+			l.rejected.Add(1)
+			conn.Close()
+			continue
+		}
+		l.active.Add(1)
+		return &proxyConn{Conn: conn}, nil
+	}
+}
+
+// connContext 作为 http.Server.ConnContext 回调，把连接放入请求上下文
+func (l *proxyListener) connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, proxyConnKey{}, conn)
+}
+
+// middleware 标记连接上已有完整的请求到达
+func (l *proxyListener) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if conn, ok := r.Context().Value(proxyConnKey{}).(*proxyConn); ok {
+			conn.served.Store(true)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackState 作为 http.Server.ConnState 回调，统计关闭的连接
+func (l *proxyListener) trackState(conn net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	if c, ok := conn.(*proxyConn); ok && !c.served.Load() {
+		l.idle.Add(1)
+	}
+	l.active.Add(-1)
+}
+
+// Stats 连接统计
+func (l *proxyListener) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"active_connections":     l.active.Load(),
+		"max_connections":        l.maxConnections,
+		"rejected_connections":   l.rejected.Load(),
+		"closed_without_request": l.idle.Load(),
+	}
+}
@@ -7,9 +7,14 @@ import (
 	"net/http"
 	"time"
 
+	"golang.org/x/crypto/acme/autocert"
 	"tunnel-flow/internal/config"
 	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/health"
+	"tunnel-flow/internal/monitoring"
 	"tunnel-flow/internal/proxy"
+	"tunnel-flow/internal/quota"
+	"tunnel-flow/internal/utils"
 	"tunnel-flow/internal/websocket"
 )
 
@@ -19,20 +24,26 @@ type ProxyServer struct {
 	db        *database.Repository
 	handler   *proxy.Handler
 	server    *http.Server
+	listener  *proxyListener
+	tlsServer *http.Server   // 路由自定义域名的HTTPS端口，未配置 proxy.tls_port 时为nil
+	routes    *RouteListeners // 路由独立端口
+	probes    *probeState
 	ctx       context.Context
 	cancel    context.CancelFunc
 }
 
 // NewProxyServer 创建新的代理服务器
-func NewProxyServer(cfg *config.Config, db *database.Repository, wsManager *websocket.Manager) *ProxyServer {
+func NewProxyServer(cfg *config.Config, db *database.Repository, wsManager *websocket.Manager, traffic *monitoring.TrafficStats, metrics *monitoring.MetricsCollector, quotas *quota.Manager) *ProxyServer {
 	ctx, cancel := context.WithCancel(context.Background())
 	
-	handler := proxy.NewHandler(db, wsManager)
+	// 错误率统计只用于本服务器的路由选择
+	handler := proxy.NewHandler(cfg, db, wsManager, traffic, metrics, quotas, health.NewTracker(cfg))
 	
 	return &ProxyServer{
 		config:  cfg,
 		db:      db,
 		handler: handler,
+		routes:  NewRouteListeners(cfg, db, handler),
 		ctx:     ctx,
 		cancel:  cancel,
 	}
@@ -43,42 +54,119 @@ func (s *ProxyServer) Start() error {
 	mux := http.NewServeMux()
 	
 	// 代理路由
-	mux.HandleFunc("/proxy/", s.handler.HandleProxyRequest)
+	mux.HandleFunc(s.handler.PathPrefix()+"/", s.handler.HandleProxyRequest)
 	
 	// 文件上传路由
 	mux.HandleFunc("/upload", s.handler.HandleFileUpload)
+	mux.HandleFunc("/upload/", s.handler.HandleFileUpload)
 	
 	// 健康检查
 	mux.HandleFunc("/health", s.handleHealth)
 	
+	// 存活和就绪探针
+	mux.HandleFunc("/live", s.probes.handleLive)
+	mux.HandleFunc("/ready", s.probes.handleReady)
+	
 	// 状态信息
 	mux.HandleFunc("/status", s.handleStatus)
 	
-	// 添加根路径处理器，支持直接访问路由路径
+	// 添加根路径处理器，支持直接访问路由路径，关闭直接访问时返回404
 	mux.HandleFunc("/", s.handler.HandleDirectProxyRequest)
 	
+	// 解析请求来源国家；请求主机是路由的自定义域名时转发给该路由；开启HTTPS端口时代理端口同时响应ACME的HTTP-01验证
+	handler := s.handler.CountryMiddleware(s.handler.DomainMiddleware(mux))
+	var certs *autocert.Manager
+	if s.config.ProxyTLSPort > 0 {
+		certs = newCertManager(s.config, s.db)
+		handler = certs.HTTPHandler(handler)
+	}
+	
+	network, address := s.config.ProxyListenAddress(s.config.ProxyPort)
+	listener, err := listen(s.config, network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on proxy port %d: %w", s.config.ProxyPort, err)
+	}
+	s.listener = newProxyListener(listener, s.config.ProxyMaxConnections)
+	
+	// 代理端口面向公网：限制读取请求头的时间、请求头大小和并发连接数，防止慢速攻击耗尽资源
 	s.server = &http.Server{
-		Addr:         fmt.Sprintf(":%d", s.config.ProxyPort),
-		Handler:      mux,
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              address,
+		Handler:           s.listener.middleware(utils.RequestIDMiddleware(handler)),
+		ReadHeaderTimeout: time.Duration(s.config.ProxyReadHeaderTimeoutSeconds) * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    s.config.ProxyMaxHeaderBytes,
+		ConnState:         s.listener.trackState,
+		ConnContext:       s.listener.connContext,
 	}
 	
-	log.Printf("Starting proxy server on port %d", s.config.ProxyPort)
+	log.Printf("Starting proxy server on port %d (max connections: %d)", s.config.ProxyPort, s.config.ProxyMaxConnections)
 	
 	go func() {
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := s.server.Serve(s.listener); err != nil && err != http.ErrServerClosed {
 			log.Printf("Proxy server error: %v", err)
 		}
 	}()
 	
+	if certs != nil {
+		if err := s.startTLS(certs, handler); err != nil {
+			return err
+		}
+	}
+	
+	// 打开路由的独立端口
+	go s.routes.Run(s.ctx)
+	
+	// 删除过期的请求抓取记录
+	go s.handler.PruneCaptures(s.ctx)
+	
+	return nil
+}
+
+// startTLS 在 proxy.tls_port 上启动HTTPS服务，证书按SNI从ACME服务按需申请，连接限制与代理端口相同
+func (s *ProxyServer) startTLS(certs *autocert.Manager, handler http.Handler) error {
+	network, address := s.config.RouteListenAddress(s.config.ProxyTLSPort)
+	ln, err := listenTCP(network, address, s.config.ReusePort)
+	if err != nil {
+		return fmt.Errorf("failed to listen on proxy TLS port %d: %w", s.config.ProxyTLSPort, err)
+	}
+	listener := newProxyListener(ln, s.config.ProxyMaxConnections)
+	
+	s.tlsServer = &http.Server{
+		Handler:           listener.middleware(utils.RequestIDMiddleware(handler)),
+		TLSConfig:         certs.TLSConfig(),
+		ReadHeaderTimeout: time.Duration(s.config.ProxyReadHeaderTimeoutSeconds) * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    s.config.ProxyMaxHeaderBytes,
+		ConnState:         listener.trackState,
+		ConnContext:       listener.connContext,
+	}
+	
+	log.Printf("Starting proxy TLS server on port %d (ACME cache: %s)", s.config.ProxyTLSPort, s.config.ACMECacheDir)
+	
+	go func() {
+		if err := s.tlsServer.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+			log.Printf("Proxy TLS server error: %v", err)
+		}
+	}()
 	return nil
 }
 
 // Stop 停止代理服务器
 func (s *ProxyServer) Stop() error {
 	s.cancel()
+	s.routes.Close()
+	
+	if s.tlsServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.tlsServer.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down proxy TLS server: %v", err)
+		}
+	}
 	
 	if s.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -104,6 +192,7 @@ func (s *ProxyServer) handleHealth(w http.ResponseWriter, r *http.Request) {
 // handleStatus 状态信息处理器
 func (s *ProxyServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 	stats := s.handler.GetStats()
+	connections := s.listener.Stats()
 	
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -113,8 +202,16 @@ func (s *ProxyServer) handleStatus(w http.ResponseWriter, r *http.Request) {
 		"service": "proxy",
 		"port": %d,
 		"connected_clients": %v,
-		"total_routes": %v
-	}`, s.config.ProxyPort, stats["connected_clients"], stats["total_routes"])
+		"total_routes": %v,
+		"compressed_responses": %v,
+		"compression_bytes_saved": %v,
+		"active_connections": %v,
+		"max_connections": %v,
+		"rejected_connections": %v,
+		"closed_without_request": %v,
+		"route_listeners": %d
+	}`, s.config.ProxyPort, stats["connected_clients"], stats["total_routes"], stats["compressed_responses"], stats["compression_bytes_saved"],
+		connections["active_connections"], connections["max_connections"], connections["rejected_connections"], connections["closed_without_request"], len(s.routes.Status()))
 	
 	w.Write([]byte(response))
 }
\ No newline at end of file
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/quota"
+	"tunnel-flow/internal/utils"
+)
+
+// 客户端用量配额API
+
+// bytesPerGB 配额接口中 gb_per_month 的换算单位
+const bytesPerGB = 1 << 30
+
+// ClientQuotaStatus 客户端配额及当前周期用量
+type ClientQuotaStatus struct {
+	ClientID        string                `json:"client_id"`
+	Quota           *database.ClientQuota `json:"quota"` // 未设置配额时为null
+	GBPerMonth      float64               `json:"gb_per_month"`
+	Usage           quota.Usage           `json:"usage"`
+	RequestsPercent float64               `json:"requests_percent"` // 未限制时为0
+	BytesPercent    float64               `json:"bytes_percent"`
+}
+
+// quotaStatus 组装客户端配额状态
+func (s *apiHandlers) quotaStatus(clientID string, clientQuota *database.ClientQuota) ClientQuotaStatus {
+	status := ClientQuotaStatus{
+		ClientID: clientID,
+		Quota:    clientQuota,
+		Usage:    s.quota.Usage(clientID),
+	}
+	if clientQuota == nil {
+		return status
+	}
+
+	status.GBPerMonth = float64(clientQuota.BytesPerMonth) / bytesPerGB
+	if clientQuota.RequestsPerDay > 0 {
+		status.RequestsPercent = float64(status.Usage.RequestsToday) * 100 / float64(clientQuota.RequestsPerDay)
+	}
+	if clientQuota.BytesPerMonth > 0 {
+		status.BytesPercent = float64(status.Usage.BytesThisMonth) * 100 / float64(clientQuota.BytesPerMonth)
+	}
+	return status
+}
+
+// handleGetQuotas 列出当前组织内已设置配额的客户端及其用量
+func (s *apiHandlers) handleGetQuotas(w http.ResponseWriter, r *http.Request) {
+	quotas, err := s.db.ListClientQuotas(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	result := make([]ClientQuotaStatus, 0, len(quotas))
+	for _, clientQuota := range quotas {
+		if scope.clientPermission(clientQuota.ClientID) == "" {
+			continue
+		}
+		result = append(result, s.quotaStatus(clientQuota.ClientID, clientQuota))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// handleGetClientQuota 获取客户端配额和当前周期用量
+func (s *apiHandlers) handleGetClientQuota(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+
+	clientQuota, err := s.db.GetClientQuota(clientID)
+	if err != nil && err != sql.ErrNoRows {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.quotaStatus(clientID, clientQuota))
+}
+
+// handleSetClientQuota 设置客户端配额，限额为0表示不限制
+// 月流量可以用 bytes_per_month 或 gb_per_month 指定，二者只能选一个
+func (s *apiHandlers) handleSetClientQuota(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+
+	var request struct {
+		RequestsPerDay int64    `json:"requests_per_day"`
+		BytesPerMonth  int64    `json:"bytes_per_month"`
+		GBPerMonth     *float64 `json:"gb_per_month"`
+		WebhookURL     string   `json:"webhook_url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	if request.RequestsPerDay < 0 || request.BytesPerMonth < 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Quota limits must not be negative")
+		return
+	}
+	if request.GBPerMonth != nil {
+		if request.BytesPerMonth > 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Specify either bytes_per_month or gb_per_month, not both")
+			return
+		}
+		if *request.GBPerMonth < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Quota limits must not be negative")
+			return
+		}
+		request.BytesPerMonth = int64(*request.GBPerMonth * bytesPerGB)
+	}
+
+	request.WebhookURL = strings.TrimSpace(request.WebhookURL)
+	if request.WebhookURL != "" {
+		parsed, err := url.Parse(request.WebhookURL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "webhook_url must be an http or https URL")
+			return
+		}
+	}
+
+	clientQuota := &database.ClientQuota{
+		ClientID:       clientID,
+		RequestsPerDay: request.RequestsPerDay,
+		BytesPerMonth:  request.BytesPerMonth,
+		WebhookURL:     request.WebhookURL,
+	}
+	if existing, err := s.db.GetClientQuota(clientID); err == nil {
+		clientQuota.CreatedAt = existing.CreatedAt
+	}
+
+	if err := s.db.SaveClientQuota(clientQuota); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.quota.SetQuota(clientQuota)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.quotaStatus(clientID, clientQuota))
+}
+
+// handleDeleteClientQuota 删除客户端配额，恢复为不限制
+func (s *apiHandlers) handleDeleteClientQuota(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+
+	if err := s.db.DeleteClientQuota(clientID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.quota.RemoveQuota(clientID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/auth"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/utils"
+)
+
+// 客户端预留API，平台管理员为客户端预留端口范围和域名
+// 预留的端口和域名只能由该客户端使用：其他客户端的路由不能占用，代理动态申请暴露时也只能申请本客户端预留的范围
+
+// reservationRequest 创建预留的请求，端口范围和域名二选一
+type reservationRequest struct {
+	PortStart int    `json:"port_start"`
+	PortEnd   int    `json:"port_end"` // 为0时只预留 port_start
+	Domain    string `json:"domain"`   // 完整域名或 *.example.com
+}
+
+// reservationClient 获取预留所属的客户端，平台管理员可以访问所有组织的客户端
+func (s *apiHandlers) reservationClient(r *http.Request, clientID string) (*database.Client, error) {
+	if user, ok := auth.GetUserFromContext(r.Context()); ok && user.IsPlatformAdmin() {
+		return s.db.GetClient(clientID)
+	}
+	return s.getOrgClient(r, clientID)
+}
+
+// checkPortClaim 检查客户端能否使用端口，返回空字符串表示可以，否则返回拒绝的原因
+// 端口预留给其他客户端时拒绝；requireReserved 为true时端口还必须预留给该客户端，用于代理动态申请
+func (s *apiHandlers) checkPortClaim(clientID string, port int, requireReserved bool) (string, error) {
+	reservations, err := s.db.ListPortReservations(port, port)
+	if err != nil {
+		return "", err
+	}
+	for _, reservation := range reservations {
+		if reservation.ClientID != clientID {
+			return fmt.Sprintf("Port %d is reserved for client %s", port, reservation.ClientID), nil
+		}
+	}
+	if requireReserved && len(reservations) == 0 {
+		return fmt.Sprintf("Port %d is not reserved for client %s", port, clientID), nil
+	}
+	return "", nil
+}
+
+// checkDomainClaim 检查客户端能否使用域名，规则与 checkPortClaim 相同，域名需已规范化
+// 域名被其他客户端的预留包含或包含其他客户端的预留时都拒绝
+func (s *apiHandlers) checkDomainClaim(clientID, domain string, requireReserved bool) (string, error) {
+	reservations, err := s.db.ListDomainReservations()
+	if err != nil {
+		return "", err
+	}
+	covered := false
+	for _, reservation := range reservations {
+		if reservation.ClientID != clientID {
+			if utils.DomainsOverlap(reservation.Domain, domain) {
+				return fmt.Sprintf("Domain %s is reserved for client %s", domain, reservation.ClientID), nil
+			}
+			continue
+		}
+		if utils.DomainCovers(reservation.Domain, domain) {
+			covered = true
+		}
+	}
+	if requireReserved && !covered {
+		return fmt.Sprintf("Domain %s is not reserved for client %s", domain, clientID), nil
+	}
+	return "", nil
+}
+
+// handleGetClientReservations 列出客户端预留的端口范围和域名
+func (s *apiHandlers) handleGetClientReservations(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+	if _, err := s.reservationClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+
+	reservations, err := s.db.ListClientReservations(clientID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reservations)
+}
+
+// handleCreateClientReservation 为客户端预留端口范围或域名，仅平台管理员可用
+// 与已有预留重叠，或范围内已有其他客户端的路由使用独立端口时返回409
+func (s *apiHandlers) handleCreateClientReservation(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+	clientID := mux.Vars(r)["id"]
+	if _, err := s.reservationClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+
+	var req reservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	reservation := &database.ClientReservation{ClientID: clientID}
+	if user, ok := auth.GetUserFromContext(r.Context()); ok {
+		reservation.CreatedBy = user.Username
+	}
+
+	switch {
+	case req.Domain != "" && (req.PortStart != 0 || req.PortEnd != 0):
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Specify either a port range or a domain")
+		return
+	case req.Domain != "":
+		domain, err := utils.NormalizeDomain(req.Domain)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+			return
+		}
+		reservation.Domain = domain
+		if !s.checkDomainReservation(w, r, reservation) {
+			return
+		}
+	default:
+		if req.PortEnd == 0 {
+			req.PortEnd = req.PortStart
+		}
+		if req.PortStart < 1 || req.PortEnd > 65535 || req.PortStart > req.PortEnd {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "port_start and port_end must be between 1 and 65535 with port_start <= port_end")
+			return
+		}
+		reservation.PortStart, reservation.PortEnd = req.PortStart, req.PortEnd
+		if !s.checkPortReservation(w, r, reservation) {
+			return
+		}
+	}
+
+	if err := s.db.CreateClientReservation(reservation); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(reservation)
+}
+
+// checkPortReservation 检查端口范围能否预留：不能包含服务器自身的端口，不能与已有预留重叠，
+// 范围内也不能有其他客户端的路由使用独立端口。返回false表示已写入错误响应
+func (s *apiHandlers) checkPortReservation(w http.ResponseWriter, r *http.Request, reservation *database.ClientReservation) bool {
+	for _, port := range []int{s.config.APIPort, s.config.WebSocketPort, s.config.ProxyPort, s.config.ServerPort} {
+		if port >= reservation.PortStart && port <= reservation.PortEnd {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Port %d is used by the server", port))
+			return false
+		}
+	}
+
+	existing, err := s.db.ListPortReservations(reservation.PortStart, reservation.PortEnd)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if len(existing) > 0 {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Ports %d-%d overlap reservation %d of client %s",
+			reservation.PortStart, reservation.PortEnd, existing[0].ID, existing[0].ClientID))
+		return false
+	}
+
+	routes, err := s.db.ListServerRoutesByListenPortRange(reservation.PortStart, reservation.PortEnd)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	for _, route := range routes {
+		if route.ClientID != reservation.ClientID {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Port %d is used by route %d", route.ListenPort, route.ID))
+			return false
+		}
+	}
+	return true
+}
+
+// checkDomainReservation 检查域名能否预留，与已有预留包含或被包含时冲突。返回false表示已写入错误响应
+func (s *apiHandlers) checkDomainReservation(w http.ResponseWriter, r *http.Request, reservation *database.ClientReservation) bool {
+	existing, err := s.db.ListDomainReservations()
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	for _, other := range existing {
+		if utils.DomainsOverlap(other.Domain, reservation.Domain) {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, fmt.Sprintf("Domain %s overlaps reservation %d (%s) of client %s",
+				reservation.Domain, other.ID, other.Domain, other.ClientID))
+			return false
+		}
+	}
+	return true
+}
+
+// handleDeleteClientReservation 删除客户端预留，仅平台管理员可用；已使用预留资源的路由不受影响
+func (s *apiHandlers) handleDeleteClientReservation(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["rid"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid reservation ID")
+		return
+	}
+	reservation, err := s.db.GetClientReservation(id)
+	if err != nil || reservation.ClientID != vars["id"] {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Reservation not found")
+		return
+	}
+
+	if err := s.db.DeleteClientReservation(reservation.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
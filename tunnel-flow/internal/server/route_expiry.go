@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"tunnel-flow/internal/database"
+)
+
+// 临时路由：设置了 expires_at 的路由到期后不再转发请求，由服务端定期按 expire_action 禁用或删除，
+// 并向事件流推送 route.expired 事件。到期被禁用的路由重新启用前需要延长或清除 expires_at
+
+// routeExpiryInterval 检查到期路由的间隔，到期后到被禁用之前代理也不会转发该路由的请求
+const routeExpiryInterval = 5 * time.Second
+
+// validateRouteExpiry 检查路由的到期设置，previousExpiresAt 为修改前的到期时间，新建路由为0
+func validateRouteExpiry(route *database.ServerRoute, previousExpiresAt int64) error {
+	if route.ExpiresAt < 0 {
+		return fmt.Errorf("expires_at must not be negative")
+	}
+	if !database.IsValidRouteExpireAction(route.ExpireAction) {
+		return fmt.Errorf("expire_action must be one of disable, delete or empty")
+	}
+	now := time.Now()
+	if route.ExpiresAt != previousExpiresAt && route.IsExpired(now) {
+		return fmt.Errorf("expires_at must be in the future")
+	}
+	if route.IsEnabled() && route.IsExpired(now) {
+		return fmt.Errorf("route has expired; extend or clear expires_at before enabling it")
+	}
+	return nil
+}
+
+// runRouteExpiry 定期禁用或删除到期的临时路由，ctx 取消后返回
+func (s *apiHandlers) runRouteExpiry(ctx context.Context) {
+	ticker := time.NewTicker(routeExpiryInterval)
+	defer ticker.Stop()
+
+	s.expireRoutes()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.expireRoutes()
+		}
+	}
+}
+
+// expireRoutes 处理已到期且仍启用的路由，只读模式下不修改路由，到期的路由仍不转发请求
+func (s *apiHandlers) expireRoutes() {
+	if s.freeze.Status().ReadOnly {
+		return
+	}
+	routes, err := s.db.ListExpiredServerRoutes(time.Now().UnixMilli())
+	if err != nil {
+		log.Printf("Failed to list expired routes: %v", err)
+		return
+	}
+
+	changed := false
+	for _, route := range routes {
+		action := route.ExpireAction
+		if action == "" {
+			action = database.RouteExpireDisable
+		}
+		if action == database.RouteExpireDelete {
+			err = s.db.DeleteServerRoute(route.ID)
+		} else {
+			err = s.db.UpdateServerRouteEnabled(route.ID, false)
+		}
+		if err != nil {
+			log.Printf("Failed to %s expired route %d: %v", action, route.ID, err)
+			continue
+		}
+		changed = true
+		log.Printf("Route %d (%s) expired, action: %s", route.ID, route.URLSuffix, action)
+
+		s.events.publish(EventRouteExpired, route.OrgID, map[string]interface{}{
+			"route_id":   route.ID,
+			"url_suffix": route.URLSuffix,
+			"client_id":  route.ClientID,
+			"expires_at": route.ExpiresAt,
+			"action":     action,
+		})
+	}
+	if changed {
+		s.routesChanged()
+	}
+}
@@ -0,0 +1,266 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/proxy"
+	"tunnel-flow/internal/utils"
+)
+
+// 路由独立端口：配置了 listen_port 的已启用路由在该端口上有自己的HTTP服务器，端口上的所有请求都转发给这条路由
+// 路由创建、修改、启用、禁用或删除后API通知注册表立即同步，另外定期同步以覆盖删除客户端等间接修改
+
+// routeListenerSyncInterval 定期同步独立端口的间隔
+const routeListenerSyncInterval = 10 * time.Second
+
+// RouteListeners 路由独立端口的监听器注册表
+type RouteListeners struct {
+	config  *config.Config
+	db      *database.Repository
+	handler *proxy.Handler
+
+	mu        sync.Mutex
+	listeners map[int]*routeListener      // 按端口索引
+	failed    map[int]RouteListenerStatus // 监听失败的端口，下次同步时重试
+	trigger   chan struct{}
+}
+
+// routeListener 一个路由独立端口上的服务器
+type routeListener struct {
+	routeID  int
+	server   *http.Server
+	listener *proxyListener
+}
+
+// RouteListenerStatus 独立端口的状态
+type RouteListenerStatus struct {
+	Port              int    `json:"port"`
+	RouteID           int    `json:"route_id"`
+	ActiveConnections int64  `json:"active_connections"`
+	Error             string `json:"error,omitempty"` // 监听失败的原因
+}
+
+// NewRouteListeners 创建路由独立端口的监听器注册表
+func NewRouteListeners(cfg *config.Config, db *database.Repository, handler *proxy.Handler) *RouteListeners {
+	return &RouteListeners{
+		config:    cfg,
+		db:        db,
+		handler:   handler,
+		listeners: make(map[int]*routeListener),
+		failed:    make(map[int]RouteListenerStatus),
+		trigger:   make(chan struct{}, 1),
+	}
+}
+
+// Run 打开已有路由的独立端口并持续同步，ctx 取消后关闭所有端口
+func (l *RouteListeners) Run(ctx context.Context) {
+	ticker := time.NewTicker(routeListenerSyncInterval)
+	defer ticker.Stop()
+
+	l.sync()
+	for {
+		select {
+		case <-ctx.Done():
+			l.Close()
+			return
+		case <-l.trigger:
+			l.sync()
+		case <-ticker.C:
+			l.sync()
+		}
+	}
+}
+
+// Notify 通知路由已修改，不等待同步完成
+func (l *RouteListeners) Notify() {
+	select {
+	case l.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// sync 按数据库中的路由打开新端口、关闭不再需要的端口
+func (l *RouteListeners) sync() {
+	routes, err := l.db.ListListenPortRoutes()
+	if err != nil {
+		log.Printf("Failed to list routes with listen ports: %v", err)
+		return
+	}
+	wanted := make(map[int]int, len(routes))
+	for _, route := range routes {
+		wanted[route.ListenPort] = route.ID
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for port, current := range l.listeners {
+		if routeID, ok := wanted[port]; !ok || routeID != current.routeID {
+			l.closeListener(port, current)
+		}
+	}
+	for port := range l.failed {
+		if _, ok := wanted[port]; !ok {
+			delete(l.failed, port)
+		}
+	}
+	for port, routeID := range wanted {
+		if _, ok := l.listeners[port]; ok {
+			continue
+		}
+		if err := l.openListener(port, routeID); err != nil {
+			if previous, ok := l.failed[port]; !ok || previous.RouteID != routeID || previous.Error != err.Error() {
+				log.Printf("Failed to open listen port %d for route %d: %v", port, routeID, err)
+			}
+			l.failed[port] = RouteListenerStatus{Port: port, RouteID: routeID, Error: err.Error()}
+			continue
+		}
+		delete(l.failed, port)
+	}
+}
+
+// openListener 在端口上启动转发给路由的服务器，限制与代理端口相同
+func (l *RouteListeners) openListener(port, routeID int) error {
+	network, address := l.config.RouteListenAddress(port)
+	ln, err := listenTCP(network, address, l.config.ReusePort)
+	if err != nil {
+		return err
+	}
+	listener := newProxyListener(ln, l.config.ProxyMaxConnections)
+
+	handler := l.handler.CountryMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.handler.HandleRouteRequest(w, r, routeID, port)
+	}))
+	server := &http.Server{
+		Handler:           listener.middleware(utils.RequestIDMiddleware(handler)),
+		ReadHeaderTimeout: time.Duration(l.config.ProxyReadHeaderTimeoutSeconds) * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		MaxHeaderBytes:    l.config.ProxyMaxHeaderBytes,
+		ConnState:         listener.trackState,
+		ConnContext:       listener.connContext,
+	}
+	l.listeners[port] = &routeListener{routeID: routeID, server: server, listener: listener}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Route listener on port %d error: %v", port, err)
+		}
+	}()
+	log.Printf("Route %d listening on port %d", routeID, port)
+	return nil
+}
+
+// closeListener 关闭端口并等待进行中的请求完成，调用方需持有 mu
+func (l *RouteListeners) closeListener(port int, current *routeListener) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := current.server.Shutdown(ctx); err != nil {
+		log.Printf("Error closing listen port %d: %v", port, err)
+	}
+	delete(l.listeners, port)
+	log.Printf("Route %d stopped listening on port %d", current.routeID, port)
+}
+
+// Close 关闭所有独立端口
+func (l *RouteListeners) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for port, current := range l.listeners {
+		l.closeListener(port, current)
+	}
+}
+
+// Status 返回所有独立端口的状态，包括监听失败的端口
+func (l *RouteListeners) Status() []RouteListenerStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	result := make([]RouteListenerStatus, 0, len(l.listeners)+len(l.failed))
+	for port, current := range l.listeners {
+		result = append(result, RouteListenerStatus{
+			Port:              port,
+			RouteID:           current.routeID,
+			ActiveConnections: current.listener.active.Load(),
+		})
+	}
+	for _, status := range l.failed {
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Port < result[j].Port })
+	return result
+}
+
+// routesChanged 通知注册表路由已修改
+func (s *apiHandlers) routesChanged() {
+	if s.routeListeners != nil {
+		s.routeListeners.Notify()
+	}
+}
+
+// requireListenPort 检查路由的独立监听端口，0表示不使用；设置或修改端口需要平台管理员权限
+// 端口不能是服务器自身的端口，不能被其他路由使用，也不能预留给其他客户端。返回false表示已写入错误响应
+func (s *apiHandlers) requireListenPort(w http.ResponseWriter, r *http.Request, route *database.ServerRoute, previousPort int) bool {
+	port := route.ListenPort
+	if port == 0 || port == previousPort {
+		return true
+	}
+	if port < 0 || port > 65535 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "listen_port must be between 1 and 65535")
+		return false
+	}
+	if !requirePlatformAdmin(w, r) {
+		return false
+	}
+	reason, err := s.checkListenPort(route, false)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	if reason != "" {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, reason)
+		return false
+	}
+	return true
+}
+
+// checkListenPort 检查路由能否使用其独立监听端口，返回空字符串表示可以，否则返回拒绝的原因
+// 端口不能是服务器自身的端口，不能被其他路由使用，端口预留的规则见 checkPortClaim
+func (s *apiHandlers) checkListenPort(route *database.ServerRoute, requireReserved bool) (string, error) {
+	port := route.ListenPort
+	if port == s.config.APIPort || port == s.config.WebSocketPort || port == s.config.ProxyPort || port == s.config.ServerPort {
+		return fmt.Sprintf("listen_port %d is used by the server", port), nil
+	}
+	existing, err := s.db.GetServerRouteByListenPort(port)
+	if err == nil && existing.ID != route.ID {
+		return fmt.Sprintf("listen_port %d is already used by route %d", port, existing.ID), nil
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return s.checkPortClaim(route.ClientID, port, requireReserved)
+}
+
+// handleGetRouteListeners 列出路由独立端口的状态，包括监听失败的端口，仅平台管理员可用
+func (s *apiHandlers) handleGetRouteListeners(w http.ResponseWriter, r *http.Request) {
+	if !requirePlatformAdmin(w, r) {
+		return
+	}
+	listeners := make([]RouteListenerStatus, 0)
+	if s.routeListeners != nil {
+		listeners = s.routeListeners.Status()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listeners)
+}
@@ -4,12 +4,13 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/sha256"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -17,36 +18,43 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
-	"tunnel-flow/internal/auth"
 	"tunnel-flow/internal/config"
 	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/monitoring"
 	"tunnel-flow/internal/performance"
 	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/proxy"
+	"tunnel-flow/internal/quota"
 	"tunnel-flow/internal/utils"
+	"tunnel-flow/internal/version"
 	"tunnel-flow/internal/web"
 	"tunnel-flow/internal/websocket"
 )
 
-// Server HTTP服务器
+// Server HTTP服务器，在同一端口上提供API、WebSocket和代理
 type Server struct {
-	config         *config.Config
-	db             *database.Repository
-	authHandler    *auth.AuthHandler
-	wsManager      *websocket.Manager
-	server         *http.Server
+	*apiHandlers
+	proxyHandler *proxy.Handler
+	server       *http.Server
 }
 
 // NewServer 创建新的HTTP服务器
 func NewServer(cfg *config.Config, db *database.Repository) *Server {
 	// 创建性能优化组件
 	objectPool := performance.NewObjectPool()
-	workerPool := performance.NewWorkerPool(cfg.WorkerPoolSize, cfg.WorkerQueueSize)
+	workerPool := performance.NewWorkerPoolWithConfig(&performance.WorkerPoolConfig{
+		MinWorkers:       cfg.WorkerPoolSize,
+		MaxWorkers:       cfg.WorkerPoolMax,
+		QueueSize:        cfg.WorkerQueueSize,
+		LatencyThreshold: cfg.WorkerLatencyThreshold(),
+	})
+	
+	wsManager := websocket.NewManager(cfg, db, objectPool, workerPool, nil)
+	traffic := monitoring.NewTrafficStats()
 	
 	return &Server{
-		config:         cfg,
-		db:             db,
-		authHandler:    auth.NewAuthHandler(cfg),
-		wsManager:      websocket.NewManager(cfg, db, objectPool, workerPool, nil),
+		apiHandlers:  newAPIHandlers(cfg, db, wsManager, traffic, nil, nil),
+		proxyHandler: proxy.NewHandler(cfg, db, wsManager, traffic, nil, nil, nil),
 	}
 }
 
@@ -57,8 +65,9 @@ func (s *Server) Start() error {
 	// 配置CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"}, // 生产环境中应该限制
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag", utils.RequestIDHeader},
 		AllowCredentials: true,
 	})
 	
@@ -74,6 +83,7 @@ func (s *Server) Start() error {
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
+	s.server.RegisterOnShutdown(s.stopStreams)
 	
 	log.Printf("Starting HTTP server on %s", addr)
 	return s.server.ListenAndServe()
@@ -90,55 +100,19 @@ func (s *Server) Stop(ctx context.Context) error {
 // setupRoutes 设置路由
 func (s *Server) setupRoutes() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(utils.RequestIDMiddleware)
 	
 	// 获取认证中间件
 	authMiddleware := s.authHandler.GetAuthMiddleware()
 	
 	// API路由
-	api := r.PathPrefix("/api/v1").Subrouter()
-	
-	// 认证相关路由（公开访问）
-	api.HandleFunc("/auth/login", s.authHandler.Login).Methods("POST")
-	api.HandleFunc("/auth/register", s.authHandler.Register).Methods("POST")
-	api.HandleFunc("/auth/profile", s.authHandler.GetProfile).Methods("GET")
-	api.HandleFunc("/auth/users", s.authHandler.ListUsers).Methods("GET")
-	
-	// 系统状态（公开访问）
-	api.HandleFunc("/status", s.handleGetStatus).Methods("GET")
-	api.HandleFunc("/server-info", s.handleGetServerInfo).Methods("GET")
-	
-	// 应用认证中间件到需要保护的路由
-	protected := api.PathPrefix("").Subrouter()
-	protected.Use(authMiddleware.Middleware)
-	
-	// 客户端管理（需要认证）
-	protected.HandleFunc("/clients", s.handleGetClients).Methods("GET")
-	protected.HandleFunc("/clients/{id}", s.handleGetClient).Methods("GET")
-	protected.HandleFunc("/clients", s.handleCreateClient).Methods("POST")
-	protected.HandleFunc("/clients/{id}", s.handleUpdateClient).Methods("PUT")
-	protected.HandleFunc("/clients/{id}/status", s.handleUpdateClientStatus).Methods("PUT")
-	protected.HandleFunc("/clients/{id}", s.handleDeleteClient).Methods("DELETE")
-	
-	// 客户端启用状态管理（需要认证）
-	protected.HandleFunc("/clients/{id}/enabled", s.handleUpdateClientEnabled).Methods("PUT")
-	
-	// 路由管理
-	protected.HandleFunc("/routes", s.handleGetRoutes).Methods("GET")
-	protected.HandleFunc("/routes", s.handleCreateRoute).Methods("POST")
-	protected.HandleFunc("/routes/{id}", s.handleGetRoute).Methods("GET")
-	protected.HandleFunc("/routes/{id}", s.handleUpdateRoute).Methods("PUT")
-	protected.HandleFunc("/routes/{id}", s.handleDeleteRoute).Methods("DELETE")
-	
-	// 路由启用状态管理
-	protected.HandleFunc("/routes/{id}/enabled", s.handleUpdateRouteEnabled).Methods("PUT")
-	protected.HandleFunc("/routes/batch/enabled", s.handleBatchUpdateRoutesEnabled).Methods("PUT")
-	protected.HandleFunc("/routes/stats", s.handleGetRouteStats).Methods("GET")
+	s.registerRoutes(r.PathPrefix("/api/v1").Subrouter())
 	
 	// WebSocket连接（agent连接，不需要认证中间件）
 	r.HandleFunc("/ws", s.wsManager.HandleWebSocket).Methods("GET")
 
 	// 代理请求处理（核心功能，需要认证）
-	r.PathPrefix("/proxy/").Handler(authMiddleware.Middleware(http.HandlerFunc(s.handleProxyRequest)))
+	r.PathPrefix(s.proxyHandler.PathPrefix() + "/").Handler(authMiddleware.Middleware(http.HandlerFunc(s.handleProxyRequest)))
 
 	// 静态文件服务（前端）- 使用嵌入的文件系统
 	if handler, err := web.GetDistHandler(); err == nil {
@@ -148,124 +122,28 @@ func (s *Server) setupRoutes() *mux.Router {
 	return r
 }
 
-// handleProxyRequest 处理代理请求
+// handleProxyRequest 处理代理请求，复用代理服务器的路由匹配和转发逻辑
 func (s *Server) handleProxyRequest(w http.ResponseWriter, r *http.Request) {
-	// 提取URL后缀
-	urlPath := strings.TrimPrefix(r.URL.Path, "/proxy")
-	if urlPath == "" {
-		http.Error(w, "Invalid proxy path", http.StatusBadRequest)
-		return
-	}
-	
-	// 查找路由
-	routes, err := s.db.ListServerRoutes()
-	if err != nil {
-		log.Printf("Failed to get routes for %s: %v", urlPath, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	
-	// 过滤匹配的路由（支持通配符）并排除禁用的路由
-	matchedRoutes := make([]*database.ServerRoute, 0)
-	for _, route := range routes {
-		if utils.MatchPattern(route.URLSuffix, urlPath) && route.IsEnabled() {
-			matchedRoutes = append(matchedRoutes, route)
-		}
-	}
-	
-	// 按优先级排序路由（优先级高的在前）
-	if len(matchedRoutes) > 1 {
-		sort.Slice(matchedRoutes, func(i, j int) bool {
-			priorityI := utils.GetPatternPriority(matchedRoutes[i].URLSuffix)
-			priorityJ := utils.GetPatternPriority(matchedRoutes[j].URLSuffix)
-			return priorityI > priorityJ
-		})
-	}
-	
-	if len(matchedRoutes) == 0 {
-		http.Error(w, "Route not found", http.StatusNotFound)
-		return
-	}
-	
-	// 选择第一个可用的路由（简化处理，实际应该有负载均衡）
-	var selectedRoute *database.ServerRoute
-	for _, route := range matchedRoutes {
-		// 检查客户端是否连接且启用
-		if s.wsManager.IsClientConnected(route.ClientID) {
-			// 检查客户端是否启用
-			clientInfo, err := s.db.GetClient(route.ClientID)
-			if err != nil || clientInfo.Enabled != 1 {
-				continue // 跳过禁用的客户端
-			}
-			selectedRoute = route
-			break
-		}
-	}
-	
-	if selectedRoute == nil {
-		http.Error(w, "No available backend", http.StatusServiceUnavailable)
-		return
-	}
-	
-	// 读取请求体
-	body := make([]byte, 0)
-	if r.Body != nil {
-		body, _ = io.ReadAll(r.Body)
-		r.Body.Close()
-	}
-	
-	// 构建请求消息
-	requestPayload := &protocol.RequestPayload{
-		HTTPMethod:     r.Method,
-		URLSuffix:      urlPath,
-		Headers:        make(map[string]string),
-		Body:           string(body),
-		TargetsJSON:    selectedRoute.TargetsJSON,
-		DeliveryPolicy: selectedRoute.DeliveryPolicy,
-		RouteMode:      selectedRoute.RouteMode,
-	}
-	
-	// 复制请求头
-	for name, values := range r.Header {
-		if len(values) > 0 {
-			requestPayload.Headers[name] = values[0]
-		}
-	}
-	
-	// 发送请求并等待响应
-	response, err := s.wsManager.SendRequestAndWait(selectedRoute.ClientID, requestPayload, 30*time.Second)
-	if err != nil {
-		log.Printf("Failed to send request to client %s: %v", selectedRoute.ClientID, err)
-		http.Error(w, "Backend request failed", http.StatusBadGateway)
-		return
-	}
-	
-	// 设置响应头
-	for name, value := range response.Headers {
-		w.Header().Set(name, value)
-	}
-	
-	// 设置状态码
-	w.WriteHeader(response.HTTPStatus)
-	
-	// 写入响应体
-	if bodyStr, ok := response.Body.(string); ok {
-		w.Write([]byte(bodyStr))
-	} else if bodyBytes, ok := response.Body.([]byte); ok {
-		w.Write(bodyBytes)
-	}
-	
-	// 如果有错误，记录日志
-	if response.Error != nil {
-		log.Printf("Backend returned error: %s", *response.Error)
-	}
+	s.proxyHandler.HandleProxyRequest(w, r)
+}
+
+// markVersionSkew 根据服务端版本和配置的最低代理版本标记客户端的版本偏差，从未注册过的客户端不标记
+func (s *apiHandlers) markVersionSkew(client *database.Client) {
+	client.VersionSkew = utils.VersionSkew(client.AgentVersion, version.Version, s.config.MinAgentVersion)
+	client.VersionWarning = utils.VersionSkewWarning(client.VersionSkew, client.AgentVersion, version.Version, s.config.MinAgentVersion)
+	client.AgentOutdated = client.VersionSkew == utils.VersionSkewOutdated
 }
 
 // 客户端管理API
-func (s *Server) handleGetClients(w http.ResponseWriter, r *http.Request) {
-	clients, err := s.db.ListClients()
+func (s *apiHandlers) handleGetClients(w http.ResponseWriter, r *http.Request) {
+	clients, err := s.db.ListClientsByOrg(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	clients, err = s.visibleClients(r, clients)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
 	
@@ -280,11 +158,14 @@ func (s *Server) handleGetClients(w http.ResponseWriter, r *http.Request) {
 			clients[i].Status = "offline"
 			if s.wsManager.IsClientConnected(clients[i].ClientID) {
 				clients[i].Status = "online"
+				clients[i].Encrypted = s.wsManager.ClientEncrypted(clients[i].ClientID)
+				clients[i].Signed = s.wsManager.ClientSigned(clients[i].ClientID)
 				if lastSeen, ok := s.wsManager.GetClientLastSeen(clients[i].ClientID); ok {
 					clients[i].LastSeen = lastSeen
 				}
 			}
 		}
+		s.markVersionSkew(clients[i])
 	}
 	
 	// 根据查询参数进行筛选
@@ -309,6 +190,14 @@ func (s *Server) handleGetClients(w http.ResponseWriter, r *http.Request) {
 				if client.Status == "disabled" {
 					filteredClients = append(filteredClients, client)
 				}
+			case "outdated":
+				if client.AgentOutdated {
+					filteredClients = append(filteredClients, client)
+				}
+			case "version_skew":
+				if client.VersionSkew != "" {
+					filteredClients = append(filteredClients, client)
+				}
 			}
 		}
 		clients = filteredClients
@@ -318,13 +207,13 @@ func (s *Server) handleGetClients(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(clients)
 }
 
-func (s *Server) handleGetClient(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleGetClient(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	clientID := vars["id"]
 	
-	client, err := s.db.GetClient(clientID)
+	client, err := s.getOrgClient(r, clientID)
 	if err != nil {
-		http.Error(w, "Client not found", http.StatusNotFound)
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
 		return
 	}
 	
@@ -335,20 +224,28 @@ func (s *Server) handleGetClient(w http.ResponseWriter, r *http.Request) {
 		client.Status = "offline"
 		if s.wsManager.IsClientConnected(client.ClientID) {
 			client.Status = "online"
+			client.Encrypted = s.wsManager.ClientEncrypted(client.ClientID)
+			client.Signed = s.wsManager.ClientSigned(client.ClientID)
 			if lastSeen, ok := s.wsManager.GetClientLastSeen(client.ClientID); ok {
 				client.LastSeen = lastSeen
 			}
 		}
 	}
+	s.markVersionSkew(client)
 	
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(client.Version))
 	json.NewEncoder(w).Encode(client)
 }
 
-func (s *Server) handleCreateClient(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleCreateClient(w http.ResponseWriter, r *http.Request) {
+	if !s.requireUnrestricted(w, r) {
+		return
+	}
+	
 	var client database.Client
 	if err := json.NewDecoder(r.Body).Decode(&client); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
 		return
 	}
 	
@@ -356,10 +253,11 @@ func (s *Server) handleCreateClient(w http.ResponseWriter, r *http.Request) {
 	client.ClientID = generateClientID()
 	authToken := generateAuthToken()
 	client.AuthToken = authToken // 直接存储明文令牌
+	client.OrgID = requestOrgID(r)
 	// 不设置Status，让其保持空值，避免触发last_seen_ts的自动更新
 	
 	if err := s.db.CreateClient(&client); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
 	
@@ -377,7 +275,7 @@ func (s *Server) handleCreateClient(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func (s *Server) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	clientID := vars["id"]
 	
@@ -388,14 +286,17 @@ func (s *Server) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&updateData); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
 		return
 	}
 	
 	// 获取现有客户端信息
-	existingClient, err := s.db.GetClient(clientID)
+	existingClient, err := s.getOrgClient(r, clientID)
 	if err != nil {
-		http.Error(w, "Client not found", http.StatusNotFound)
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
 		return
 	}
 	
@@ -403,16 +304,72 @@ func (s *Server) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
 	existingClient.Name = updateData.Name
 	existingClient.Description = updateData.Description
 	
-	if err := s.db.UpdateClient(existingClient); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	if !s.saveClient(w, r, existingClient) {
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(existingClient.Version))
+	json.NewEncoder(w).Encode(existingClient)
+}
+
+// handlePatchClient 按JSON Merge Patch语义部分更新客户端，只修改请求中出现的字段
+func (s *apiHandlers) handlePatchClient(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["id"]
+	
+	patch, ok := decodeMergePatch(w, r)
+	if !ok {
+		return
+	}
+	
+	existingClient, err := s.getOrgClient(r, clientID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+	
+	for field, raw := range patch {
+		var err error
+		switch field {
+		case "name":
+			err = decodePatchString(raw, &existingClient.Name)
+			if err == nil && strings.TrimSpace(existingClient.Name) == "" {
+				err = fmt.Errorf("must not be empty")
+			}
+		case "description":
+			err = decodePatchString(raw, &existingClient.Description)
+		case "heartbeat_interval":
+			err = decodePatchPositiveInt(raw, &existingClient.HeartbeatInterval, 30)
+		case "heartbeat_timeout":
+			err = decodePatchPositiveInt(raw, &existingClient.HeartbeatTimeout, 90)
+		default:
+			err = fmt.Errorf("field is unknown or read-only")
+		}
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid field '%s': %v", field, err))
+			return
+		}
+	}
+	
+	if existingClient.HeartbeatTimeout <= existingClient.HeartbeatInterval {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "heartbeat_timeout must be greater than heartbeat_interval")
+		return
+	}
+	
+	if !s.saveClient(w, r, existingClient) {
 		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(existingClient.Version))
 	json.NewEncoder(w).Encode(existingClient)
 }
 
-func (s *Server) handleUpdateClientStatus(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleUpdateClientStatus(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	clientID := vars["id"]
 	
@@ -421,13 +378,21 @@ func (s *Server) handleUpdateClientStatus(w http.ResponseWriter, r *http.Request
 	}
 	
 	if err := json.NewDecoder(r.Body).Decode(&statusUpdate); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
 		return
 	}
 	
 	// 验证状态值
 	if statusUpdate.Status != "online" && statusUpdate.Status != "disabled" {
-		http.Error(w, "Invalid status. Must be 'online' or 'disabled'", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid status. Must be 'online' or 'disabled'")
+		return
+	}
+	
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
 		return
 	}
 	
@@ -445,7 +410,7 @@ func (s *Server) handleUpdateClientStatus(w http.ResponseWriter, r *http.Request
 	}
 	
 	if err := s.db.UpdateClientEnabled(clientID, enabled == 1); err != nil {
-		http.Error(w, "Failed to update client status", http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
 	
@@ -458,18 +423,81 @@ func (s *Server) handleUpdateClientStatus(w http.ResponseWriter, r *http.Request
 	json.NewEncoder(w).Encode(map[string]string{"status": statusUpdate.Status})
 }
 
-func (s *Server) handleDeleteClient(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleDeleteClient(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	clientID := vars["id"]
 	
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+	
 	if err := s.db.DeleteClient(clientID); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
+	s.quota.RemoveQuota(clientID)
+	s.routesChanged()
 	
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// saveClient 保存客户端修改，携带If-Match时进行乐观锁校验
+// 返回false表示已写入错误响应
+func (s *apiHandlers) saveClient(w http.ResponseWriter, r *http.Request, client *database.Client) bool {
+	expectedVersion, present, err := parseIfMatch(r)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return false
+	}
+	
+	if present {
+		err = s.db.UpdateClientWithVersion(client, expectedVersion)
+	} else {
+		err = s.db.UpdateClient(client)
+	}
+	
+	if err == database.ErrVersionConflict {
+		utils.WriteError(w, r, http.StatusPreconditionFailed, utils.ErrCodePrecondition, "Client was modified by another request, reload and try again")
+		return false
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// saveRoute 保存路由修改，携带If-Match时进行乐观锁校验
+// 返回false表示已写入错误响应
+func (s *apiHandlers) saveRoute(w http.ResponseWriter, r *http.Request, route *database.ServerRoute) bool {
+	expectedVersion, present, err := parseIfMatch(r)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, err.Error())
+		return false
+	}
+	
+	if present {
+		err = s.db.UpdateServerRouteWithVersion(route, expectedVersion)
+	} else {
+		err = s.db.UpdateServerRoute(route)
+	}
+	
+	if err == database.ErrVersionConflict {
+		utils.WriteError(w, r, http.StatusPreconditionFailed, utils.ErrCodePrecondition, "Route was modified by another request, reload and try again")
+		return false
+	}
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return false
+	}
+	s.routesChanged()
+	return true
+}
+
 // convertRoutesForAPI 转换路由数据为API返回格式
 func convertRoutesForAPI(routes []*database.ServerRoute) []map[string]interface{} {
 	result := make([]map[string]interface{}, len(routes))
@@ -509,66 +537,144 @@ func convertRoutesForAPI(routes []*database.ServerRoute) []map[string]interface{
 			"description":     route.Description,
 			"created_at":      route.CreatedAt,
 			"updated_at":      route.UpdatedAt,
+			"version":         route.Version,
+			"group_id":        route.GroupID,
+			"org_id":          route.OrgID,
+			"match_headers":   route.MatchHeaders,
+			"match_query":     route.MatchQuery,
+			"weight":          route.Weight,
+			"hedge_delay_ms":  route.HedgeDelayMS,
+			"compression":     route.Compression,
+			"cacheable":       route.Cacheable,
+			"source":          route.Source,
+
+			"approval_status": route.ApprovalStatus,
+			"approval_note":   route.ApprovalNote,
+			"reviewed_by":     route.ReviewedBy,
+			"reviewed_at":     route.ReviewedAt,
+
+			"connect_timeout_ms":   route.ConnectTimeoutMS,
+			"header_timeout_ms":    route.HeaderTimeoutMS,
+			"body_idle_timeout_ms": route.BodyIdleTimeoutMS,
+			"total_timeout_ms":     route.TotalTimeoutMS,
+
+			"stream_idle_timeout_ms":  route.StreamIdleTimeoutMS,
+			"stream_total_timeout_ms": route.StreamTotalTimeoutMS,
+
+			"payload_compression":           route.PayloadCompression,
+			"payload_compression_min_bytes": route.PayloadCompressionMinBytes,
+			"listen_port":                   route.ListenPort,
+
+			"capture":             route.Capture,
+			"capture_max_bytes":   route.CaptureMaxBytes,
+			"capture_ttl_seconds": route.CaptureTTLSeconds,
+
+			"fault_injection":     route.HasFaults(),
+			"fault_delay_ms":      route.FaultDelayMS,
+			"fault_error_percent": route.FaultErrorPercent,
+			"fault_error_status":  route.FaultErrorStatus,
+			"fault_drop_percent":  route.FaultDropPercent,
+
+			"expires_at":    route.ExpiresAt,
+			"expire_action": route.ExpireAction,
+			"private":       route.Private,
+
+			"basic_auth_user": route.BasicAuthUser,
+
+			"domain":               route.Domain,
+			"domain_verify_method": route.DomainVerifyMethod,
+			"domain_verify_token":  route.DomainVerifyToken,
+			"domain_verified_at":   route.DomainVerifiedAt,
+			"domain_verify_error":  route.DomainVerifyError,
+
+			"allowed_countries": route.AllowedCountries,
+			"denied_countries":  route.DeniedCountries,
 		}
 	}
 	return result
 }
 
 // 路由管理API
-func (s *Server) handleGetRoutes(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleGetRoutes(w http.ResponseWriter, r *http.Request) {
+	// approval_status 只返回该审批状态的代理声明路由，例如 pending 列出等待审批的路由
+	approvalStatus := r.URL.Query().Get("approval_status")
+	switch approvalStatus {
+	case "", database.RouteApprovalPending, database.RouteApprovalApproved, database.RouteApprovalRejected:
+	default:
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "approval_status must be pending, approved or rejected")
+		return
+	}
+
 	// 检查是否有客户端ID查询参数
 	clientID := r.URL.Query().Get("client_id")
 	if clientID != "" {
-		// 按客户端ID查询路由
-		routes, err := s.db.GetServerRoutesByClientID(clientID)
+		// 按客户端ID查询路由，其他组织的客户端视为没有路由
+		routes := make([]*database.ServerRoute, 0)
+		if client, err := s.db.GetClient(clientID); err == nil && client.OrgID == requestOrgID(r) {
+			routes, err = s.db.GetServerRoutesByClientID(clientID)
+			if err != nil {
+				utils.WriteInternalError(w, r, err)
+				return
+			}
+		}
+		result, err := s.routesForAPI(r, filterRoutesByApproval(routes, approvalStatus))
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+			utils.WriteInternalError(w, r, err)
 			return
 		}
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(convertRoutesForAPI(routes))
+		json.NewEncoder(w).Encode(result)
 		return
 	}
 	
-	// 查询所有路由
-	routes, err := s.db.ListServerRoutes()
+	// 查询组织内当前用户可见的路由
+	routes, err := s.db.ListServerRoutesByOrg(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	result, err := s.routesForAPI(r, filterRoutesByApproval(routes, approvalStatus))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(convertRoutesForAPI(routes))
+	json.NewEncoder(w).Encode(result)
 }
 
-func (s *Server) handleGetRoute(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleGetRoute(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	routeID := vars["id"]
 
 	id, err := strconv.Atoi(routeID)
 	if err != nil {
-		http.Error(w, "Invalid route ID", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
 		return
 	}
 
-	route, err := s.db.GetServerRoute(id)
+	route, err := s.getOrgRoute(r, id)
 	if err != nil {
-		http.Error(w, "Route not found", http.StatusNotFound)
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
 		return
 	}
 	
 	// 转换单个路由的 targets_json 格式
-	routes := []*database.ServerRoute{route}
-	convertedRoutes := convertRoutesForAPI(routes)
+	convertedRoutes, err := s.routesForAPI(r, []*database.ServerRoute{route})
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
 	
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(route.Version))
 	json.NewEncoder(w).Encode(convertedRoutes[0])
 }
 
-func (s *Server) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
 	var route database.ServerRoute
 	if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
 		return
 	}
 	
@@ -581,49 +687,176 @@ func (s *Server) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
 	// 如果是其他值，保持不变
 	}
 	
+	if err := validateTargetsJSON(route.TargetsJSON); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid targets_json: %v", err))
+		return
+	}
+
 	route.CreatedAt = time.Now().UnixMilli()
+	route.OrgID = requestOrgID(r)
+	route.Source = "" // 代理声明的路由只能由代理同步创建
+	route.ApprovalStatus, route.ApprovalNote, route.ReviewedBy, route.ReviewedAt = "", "", "", 0
+	route.DomainVerifyMethod, route.DomainVerifyToken, route.DomainVerifiedAt, route.DomainVerifyError = "", "", 0, ""
+
+	matchHeaders, err := normalizeMatchHeaders(route.MatchHeaders)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid match_headers: %v", err))
+		return
+	}
+	route.MatchHeaders = matchHeaders
+	for _, field := range routeCountryFields(&route) {
+		countries, err := normalizeCountries(*field.value)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid %s: %v", field.name, err))
+			return
+		}
+		*field.value = countries
+	}
+	if err := s.checkCountryRules(&route); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	matchQuery, err := normalizeMatchQuery(route.MatchQuery)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid match_query: %v", err))
+		return
+	}
+	route.MatchQuery = matchQuery
+	if route.Weight < 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "weight must be a positive integer")
+		return
+	}
+	if route.HedgeDelayMS < 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "hedge_delay_ms must not be negative")
+		return
+	}
+	if !database.IsValidRouteCompression(route.Compression) {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, invalidCompressionMessage)
+		return
+	}
+	if !isValidRoutePayloadCompression(route.PayloadCompression) {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, invalidPayloadCompressionMessage)
+		return
+	}
+	if route.PayloadCompressionMinBytes < 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "payload_compression_min_bytes must not be negative")
+		return
+	}
+	for _, field := range routeTimeoutFields(&route) {
+		if *field.value < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, field.name+" must not be negative")
+			return
+		}
+	}
+	if err := validateRouteCapture(&route); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if err := validateRouteFaults(&route); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if err := validateRouteExpiry(&route, 0); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	route.BasicAuthHash = ""
+	if err := applyRouteBasicAuth(&route); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+
+	// 路由只能指向本组织的客户端和分组
+	if route.ClientID != "" {
+		if _, err := s.getOrgClient(r, route.ClientID); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Client does not exist")
+			return
+		}
+	}
+	if route.GroupID > 0 {
+		if _, err := s.getOrgGroup(r, route.GroupID); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Client group does not exist")
+			return
+		}
+	}
+	if !s.requireRouteTarget(w, r, route.ClientID, route.GroupID) {
+		return
+	}
+	if !s.requireListenPort(w, r, &route, 0) {
+		return
+	}
+	if !s.requireRouteDomain(w, r, &route, "") {
+		return
+	}
+	if !s.requireRouteSuffix(w, r, &route, "") {
+		return
+	}
 
 	if err := s.db.CreateServerRoute(&route); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		utils.WriteInternalError(w, r, err)
 		return
 	}
+	s.routesChanged()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(route)
 }
 
-func (s *Server) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
+func (s *apiHandlers) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	routeID := vars["id"]
 
 	id, err := strconv.Atoi(routeID)
 	if err != nil {
-		http.Error(w, "Invalid route ID", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
 		return
 	}
 
 	var updates map[string]interface{}
 	if err := json.NewDecoder(r.Body).Decode(&updates); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
 		return
 	}
 
 	// 获取现有路由并更新
-	existingRoute, err := s.db.GetServerRoute(id)
+	existingRoute, err := s.getOrgRoute(r, id)
 	if err != nil {
-		http.Error(w, "Route not found", http.StatusNotFound)
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if !s.requireRouteEdit(w, r, existingRoute) {
 		return
 	}
+	originalClientID, originalGroupID := existingRoute.ClientID, existingRoute.GroupID
+	originalListenPort := existingRoute.ListenPort
+	originalExpiresAt := existingRoute.ExpiresAt
+	originalDomain := existingRoute.Domain
+	originalSuffix := existingRoute.URLSuffix
 	
 	// 更新字段
 	if urlSuffix, ok := updates["url_suffix"].(string); ok {
+		// 与 PATCH 相同的路径校验
+		if !utils.IsValidPattern(urlSuffix) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid url_suffix: must start with '/' and must not contain '//'")
+			return
+		}
 		existingRoute.URLSuffix = urlSuffix
 	}
 	if clientID, ok := updates["client_id"].(string); ok {
+		if clientID != "" {
+			if _, err := s.getOrgClient(r, clientID); err != nil {
+				utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Client does not exist")
+				return
+			}
+		}
 		existingRoute.ClientID = clientID
 	}
 	if targetsJSON, ok := updates["targets_json"].(string); ok {
+		if err := validateTargetsJSON(targetsJSON); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid targets_json: %v", err))
+			return
+		}
 		existingRoute.TargetsJSON = targetsJSON
 	}
 	if routeMode, ok := updates["route_mode"].(string); ok {
@@ -647,194 +880,1359 @@ func (s *Server) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
 	if description, ok := updates["description"].(string); ok {
 		existingRoute.Description = description
 	}
-	
-	if err := s.db.UpdateServerRoute(existingRoute); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if groupID, ok := updates["group_id"].(float64); ok {
+		if groupID > 0 {
+			if _, err := s.getOrgGroup(r, int(groupID)); err != nil {
+				utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Client group does not exist")
+				return
+			}
+		}
+		existingRoute.GroupID = int(groupID)
 	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-func (s *Server) handleDeleteRoute(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	routeID := vars["id"]
-
-	id, err := strconv.Atoi(routeID)
-	if err != nil {
-		http.Error(w, "Invalid route ID", http.StatusBadRequest)
-		return
+	if raw, ok := updates["match_headers"]; ok {
+		encoded, _ := json.Marshal(raw)
+		if err := decodePatchConditions(encoded, &existingRoute.MatchHeaders, normalizeMatchHeaders); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid match_headers: %v", err))
+			return
+		}
 	}
-
-	if err := s.db.DeleteServerRoute(id); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	countriesChanged := false
+	for _, field := range routeCountryFields(existingRoute) {
+		if raw, ok := updates[field.name]; ok {
+			encoded, _ := json.Marshal(raw)
+			if err := decodePatchCountries(encoded, field.value); err != nil {
+				utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid %s: %v", field.name, err))
+				return
+			}
+			countriesChanged = true
+		}
 	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// 更新单个路由的启用状态
-func (s *Server) handleUpdateRouteEnabled(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	routeID := vars["id"]
-
-	id, err := strconv.Atoi(routeID)
-	if err != nil {
-		http.Error(w, "Invalid route ID", http.StatusBadRequest)
-		return
+	if countriesChanged {
+		if err := s.checkCountryRules(existingRoute); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+			return
+		}
 	}
-
-	var request struct {
-		Enabled bool `json:"enabled"`
+	if weight, ok := updates["weight"].(float64); ok {
+		if weight < 1 || weight != float64(int(weight)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "weight must be a positive integer")
+			return
+		}
+		existingRoute.Weight = int(weight)
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
+	if hedgeDelay, ok := updates["hedge_delay_ms"].(float64); ok {
+		if hedgeDelay < 0 || hedgeDelay != float64(int(hedgeDelay)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "hedge_delay_ms must be a non-negative integer")
+			return
+		}
+		existingRoute.HedgeDelayMS = int(hedgeDelay)
 	}
-
-	if err := s.db.UpdateServerRouteEnabled(id, request.Enabled); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if compression, ok := updates["compression"].(string); ok {
+		if !database.IsValidRouteCompression(compression) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, invalidCompressionMessage)
+			return
+		}
+		existingRoute.Compression = compression
 	}
-
-	w.WriteHeader(http.StatusNoContent)
-}
-
-// 批量更新路由启用状态
-func (s *Server) handleBatchUpdateRoutesEnabled(w http.ResponseWriter, r *http.Request) {
-	var request struct {
-		RouteIDs []int `json:"route_ids"`
-		Enabled  bool  `json:"enabled"`
+	if cacheable, ok := updates["cacheable"].(bool); ok {
+		existingRoute.Cacheable = cacheable
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
+	if compression, ok := updates["payload_compression"].(string); ok {
+		if !isValidRoutePayloadCompression(compression) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, invalidPayloadCompressionMessage)
+			return
+		}
+		existingRoute.PayloadCompression = compression
 	}
-
-	if len(request.RouteIDs) == 0 {
-		http.Error(w, "No route IDs provided", http.StatusBadRequest)
+	if minBytes, ok := updates["payload_compression_min_bytes"].(float64); ok {
+		if minBytes < 0 || minBytes != float64(int(minBytes)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "payload_compression_min_bytes must be a non-negative integer")
+			return
+		}
+		existingRoute.PayloadCompressionMinBytes = int(minBytes)
+	}
+	for _, field := range routeTimeoutFields(existingRoute) {
+		if timeout, ok := updates[field.name].(float64); ok {
+			if timeout < 0 || timeout != float64(int(timeout)) {
+				utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, field.name+" must be a non-negative integer")
+				return
+			}
+			*field.value = int(timeout)
+		}
+	}
+	if raw, ok := updates["match_query"]; ok {
+		encoded, _ := json.Marshal(raw)
+		if err := decodePatchConditions(encoded, &existingRoute.MatchQuery, normalizeMatchQuery); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid match_query: %v", err))
+			return
+		}
+	}
+	if listenPort, ok := updates["listen_port"].(float64); ok {
+		if listenPort != float64(int(listenPort)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "listen_port must be an integer")
+			return
+		}
+		existingRoute.ListenPort = int(listenPort)
+	}
+	if capture, ok := updates["capture"].(bool); ok {
+		existingRoute.Capture = capture
+	}
+	if maxBytes, ok := updates["capture_max_bytes"].(float64); ok {
+		if maxBytes != float64(int(maxBytes)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "capture_max_bytes must be an integer")
+			return
+		}
+		existingRoute.CaptureMaxBytes = int(maxBytes)
+	}
+	if ttl, ok := updates["capture_ttl_seconds"].(float64); ok {
+		if ttl != float64(int(ttl)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "capture_ttl_seconds must be an integer")
+			return
+		}
+		existingRoute.CaptureTTLSeconds = int(ttl)
+	}
+	if err := validateRouteCapture(existingRoute); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
 		return
 	}
+	for _, field := range routeFaultFields(existingRoute) {
+		if value, ok := updates[field.name].(float64); ok {
+			if value != float64(int(value)) {
+				utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, field.name+" must be an integer")
+				return
+			}
+			*field.value = int(value)
+		}
+	}
+	if err := validateRouteFaults(existingRoute); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if expiresAt, ok := updates["expires_at"].(float64); ok {
+		if expiresAt != float64(int64(expiresAt)) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "expires_at must be an integer")
+			return
+		}
+		existingRoute.ExpiresAt = int64(expiresAt)
+	}
+	if action, ok := updates["expire_action"].(string); ok {
+		existingRoute.ExpireAction = action
+	}
+	if private, ok := updates["private"].(bool); ok {
+		existingRoute.Private = private
+	}
+	if err := validateRouteExpiry(existingRoute, originalExpiresAt); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if user, ok := updates["basic_auth_user"]; ok {
+		existingRoute.BasicAuthUser, _ = user.(string)
+	}
+	if password, ok := updates["basic_auth_password"].(string); ok {
+		existingRoute.BasicAuthPassword = password
+	}
+	if err := applyRouteBasicAuth(existingRoute); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if domain, ok := updates["domain"]; ok {
+		existingRoute.Domain, _ = domain.(string)
+	}
+	
+	// 修改路由目标时要求对新目标有编辑权限
+	if existingRoute.ClientID != originalClientID || existingRoute.GroupID != originalGroupID {
+		if !s.requireRouteTarget(w, r, existingRoute.ClientID, existingRoute.GroupID) {
+			return
+		}
+	}
+	if !s.requireListenPort(w, r, existingRoute, originalListenPort) {
+		return
+	}
+	if !s.requireRouteDomain(w, r, existingRoute, originalDomain) {
+		return
+	}
+	if !s.requireRouteSuffix(w, r, existingRoute, originalSuffix) {
+		return
+	}
+	
+	if !s.saveRoute(w, r, existingRoute) {
+		return
+	}
+
+	w.Header().Set("ETag", formatETag(existingRoute.Version))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePatchRoute 按JSON Merge Patch语义部分更新路由，只修改请求中出现的字段
+func (s *apiHandlers) handlePatchRoute(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	routeID := vars["id"]
+
+	id, err := strconv.Atoi(routeID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
+		return
+	}
+
+	patch, ok := decodeMergePatch(w, r)
+	if !ok {
+		return
+	}
+
+	existingRoute, err := s.getOrgRoute(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if !s.requireRouteEdit(w, r, existingRoute) {
+		return
+	}
+	originalClientID, originalGroupID := existingRoute.ClientID, existingRoute.GroupID
+	originalListenPort := existingRoute.ListenPort
+	originalExpiresAt := existingRoute.ExpiresAt
+	originalDomain := existingRoute.Domain
+	originalSuffix := existingRoute.URLSuffix
+	countriesChanged := false
+	
+	for field, raw := range patch {
+		var err error
+		switch field {
+		case "url_suffix":
+			err = decodePatchString(raw, &existingRoute.URLSuffix)
+			if err == nil && !utils.IsValidPattern(existingRoute.URLSuffix) {
+				err = fmt.Errorf("must start with '/' and must not contain '//'")
+			}
+		case "client_id":
+			err = decodePatchString(raw, &existingRoute.ClientID)
+			if err == nil {
+				if _, lookupErr := s.getOrgClient(r, existingRoute.ClientID); lookupErr != nil {
+					err = fmt.Errorf("client does not exist")
+				}
+			}
+		case "targets_json":
+			err = decodePatchString(raw, &existingRoute.TargetsJSON)
+			if err == nil && strings.TrimSpace(existingRoute.TargetsJSON) == "" {
+				err = fmt.Errorf("must not be empty")
+			}
+			if err == nil {
+				err = validateTargetsJSON(existingRoute.TargetsJSON)
+			}
+		case "delivery_policy":
+			err = decodePatchString(raw, &existingRoute.DeliveryPolicy)
+		case "route_mode":
+			var routeMode string
+			err = decodePatchString(raw, &routeMode)
+			if err == nil {
+				// 将前端的route_mode值映射为数据库期望的值
+				switch routeMode {
+				case "basic", database.RouteModeOriginalPath, "":
+					existingRoute.RouteMode = database.RouteModeOriginalPath
+				case "full", database.RouteModePathTransform:
+					existingRoute.RouteMode = database.RouteModePathTransform
+				default:
+					err = fmt.Errorf("must be one of basic, full")
+				}
+			}
+		case "enabled":
+			// null 恢复默认值：启用
+			enabled := true
+			if string(raw) != "null" {
+				err = decodePatchBool(raw, &enabled)
+			}
+			if err == nil {
+				existingRoute.SetEnabled(enabled)
+			}
+		case "description":
+			err = decodePatchString(raw, &existingRoute.Description)
+		case "group_id":
+			var groupID int
+			if string(raw) != "null" {
+				if err = json.Unmarshal(raw, &groupID); err != nil || groupID < 0 {
+					err = fmt.Errorf("must be a non-negative integer")
+				}
+			}
+			if err == nil && groupID > 0 {
+				if _, lookupErr := s.getOrgGroup(r, groupID); lookupErr != nil {
+					err = fmt.Errorf("client group does not exist")
+				}
+			}
+			if err == nil {
+				existingRoute.GroupID = groupID
+			}
+		case "match_headers":
+			err = decodePatchConditions(raw, &existingRoute.MatchHeaders, normalizeMatchHeaders)
+		case "match_query":
+			err = decodePatchConditions(raw, &existingRoute.MatchQuery, normalizeMatchQuery)
+		case "weight":
+			err = decodePatchPositiveInt(raw, &existingRoute.Weight, database.DefaultRouteWeight)
+		case "hedge_delay_ms":
+			var hedgeDelay int
+			if string(raw) != "null" {
+				if err = json.Unmarshal(raw, &hedgeDelay); err != nil || hedgeDelay < 0 {
+					err = fmt.Errorf("must be a non-negative integer")
+				}
+			}
+			if err == nil {
+				existingRoute.HedgeDelayMS = hedgeDelay
+			}
+		case "compression":
+			var compression string
+			if err = decodePatchString(raw, &compression); err == nil && !database.IsValidRouteCompression(compression) {
+				err = fmt.Errorf("must be one of none, gzip, br or empty")
+			}
+			if err == nil {
+				existingRoute.Compression = compression
+			}
+		case "cacheable":
+			err = decodePatchBool(raw, &existingRoute.Cacheable)
+		case "payload_compression":
+			var compression string
+			if err = decodePatchString(raw, &compression); err == nil && !isValidRoutePayloadCompression(compression) {
+				err = fmt.Errorf("must be one of none, gzip, deflate or empty")
+			}
+			if err == nil {
+				existingRoute.PayloadCompression = compression
+			}
+		case "payload_compression_min_bytes":
+			err = decodePatchNonNegativeInt(raw, &existingRoute.PayloadCompressionMinBytes)
+		case "listen_port":
+			err = decodePatchNonNegativeInt(raw, &existingRoute.ListenPort)
+		case "capture":
+			err = decodePatchBool(raw, &existingRoute.Capture)
+		case "capture_max_bytes":
+			if err = decodePatchNonNegativeInt(raw, &existingRoute.CaptureMaxBytes); err == nil && existingRoute.CaptureMaxBytes > maxRouteCaptureBytes {
+				err = fmt.Errorf("must not exceed %d", maxRouteCaptureBytes)
+			}
+		case "capture_ttl_seconds":
+			err = decodePatchNonNegativeInt(raw, &existingRoute.CaptureTTLSeconds)
+		case "connect_timeout_ms", "header_timeout_ms", "body_idle_timeout_ms", "total_timeout_ms", "stream_idle_timeout_ms", "stream_total_timeout_ms":
+			for _, timeoutField := range routeTimeoutFields(existingRoute) {
+				if timeoutField.name == field {
+					err = decodePatchNonNegativeInt(raw, timeoutField.value)
+				}
+			}
+		case "fault_delay_ms", "fault_error_percent", "fault_error_status", "fault_drop_percent":
+			for _, faultField := range routeFaultFields(existingRoute) {
+				if faultField.name == field {
+					err = decodePatchNonNegativeInt(raw, faultField.value)
+				}
+			}
+		case "expires_at":
+			var expiresAt int64
+			if string(raw) != "null" {
+				if err = json.Unmarshal(raw, &expiresAt); err != nil || expiresAt < 0 {
+					err = fmt.Errorf("must be a non-negative integer")
+				}
+			}
+			if err == nil {
+				existingRoute.ExpiresAt = expiresAt
+			}
+		case "private":
+			err = decodePatchBool(raw, &existingRoute.Private)
+		case "basic_auth_user":
+			err = decodePatchString(raw, &existingRoute.BasicAuthUser)
+		case "basic_auth_password":
+			err = decodePatchString(raw, &existingRoute.BasicAuthPassword)
+		case "domain":
+			err = decodePatchString(raw, &existingRoute.Domain)
+		case "allowed_countries":
+			err = decodePatchCountries(raw, &existingRoute.AllowedCountries)
+			countriesChanged = true
+		case "denied_countries":
+			err = decodePatchCountries(raw, &existingRoute.DeniedCountries)
+			countriesChanged = true
+		case "expire_action":
+			var action string
+			if err = decodePatchString(raw, &action); err == nil && !database.IsValidRouteExpireAction(action) {
+				err = fmt.Errorf("must be one of disable, delete or empty")
+			}
+			if err == nil {
+				existingRoute.ExpireAction = action
+			}
+		default:
+			err = fmt.Errorf("field is unknown or read-only")
+		}
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("Invalid field '%s': %v", field, err))
+			return
+		}
+	}
+	if err := validateRouteFaults(existingRoute); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if err := validateRouteExpiry(existingRoute, originalExpiresAt); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if err := applyRouteBasicAuth(existingRoute); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+		return
+	}
+	if countriesChanged {
+		if err := s.checkCountryRules(existingRoute); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, err.Error())
+			return
+		}
+	}
+	
+	if existingRoute.ClientID != originalClientID || existingRoute.GroupID != originalGroupID {
+		if !s.requireRouteTarget(w, r, existingRoute.ClientID, existingRoute.GroupID) {
+			return
+		}
+	}
+	if !s.requireListenPort(w, r, existingRoute, originalListenPort) {
+		return
+	}
+	if !s.requireRouteDomain(w, r, existingRoute, originalDomain) {
+		return
+	}
+	if !s.requireRouteSuffix(w, r, existingRoute, originalSuffix) {
+		return
+	}
+	
+	if !s.saveRoute(w, r, existingRoute) {
+		return
+	}
+
+	convertedRoutes, err := s.routesForAPI(r, []*database.ServerRoute{existingRoute})
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(existingRoute.Version))
+	json.NewEncoder(w).Encode(convertedRoutes[0])
+}
+
+// handleCloneRoute 复制已有路由的完整配置，可在请求体中覆盖client_id、group_id和url_suffix
+// 复制出的路由默认禁用，除非请求中显式指定enabled；独立监听端口不能共用，不复制
+func (s *apiHandlers) handleCloneRoute(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	routeID := vars["id"]
+
+	id, err := strconv.Atoi(routeID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
+		return
+	}
+
+	var overrides struct {
+		ClientID    *string `json:"client_id"`
+		GroupID     *int    `json:"group_id"`
+		URLSuffix   *string `json:"url_suffix"`
+		Description *string `json:"description"`
+		Enabled     *bool   `json:"enabled"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&overrides); err != nil && err != io.EOF {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+
+	source, err := s.getOrgRoute(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+
+	clone := &database.ServerRoute{
+		URLSuffix:      source.URLSuffix,
+		ClientID:       source.ClientID,
+		GroupID:        source.GroupID,
+		TargetsJSON:    source.TargetsJSON,
+		DeliveryPolicy: source.DeliveryPolicy,
+		RouteMode:      source.RouteMode,
+		Enabled:        0,
+		Description:    source.Description,
+		OrgID:          source.OrgID,
+		MatchHeaders:   source.MatchHeaders,
+		MatchQuery:     source.MatchQuery,
+		Weight:         source.Weight,
+		HedgeDelayMS:   source.HedgeDelayMS,
+		Compression:    source.Compression,
+		Cacheable:      source.Cacheable,
+
+		ConnectTimeoutMS:  source.ConnectTimeoutMS,
+		HeaderTimeoutMS:   source.HeaderTimeoutMS,
+		BodyIdleTimeoutMS: source.BodyIdleTimeoutMS,
+		TotalTimeoutMS:    source.TotalTimeoutMS,
+
+		StreamIdleTimeoutMS:  source.StreamIdleTimeoutMS,
+		StreamTotalTimeoutMS: source.StreamTotalTimeoutMS,
+
+		PayloadCompression:         source.PayloadCompression,
+		PayloadCompressionMinBytes: source.PayloadCompressionMinBytes,
+
+		Capture:           source.Capture,
+		CaptureMaxBytes:   source.CaptureMaxBytes,
+		CaptureTTLSeconds: source.CaptureTTLSeconds,
+
+		FaultDelayMS:      source.FaultDelayMS,
+		FaultErrorPercent: source.FaultErrorPercent,
+		FaultErrorStatus:  source.FaultErrorStatus,
+		FaultDropPercent:  source.FaultDropPercent,
+
+		Private:       source.Private,
+		BasicAuthUser: source.BasicAuthUser,
+		BasicAuthHash: source.BasicAuthHash,
+
+		AllowedCountries: source.AllowedCountries,
+		DeniedCountries:  source.DeniedCountries,
+
+		ExpiresAt:    source.ExpiresAt,
+		ExpireAction: source.ExpireAction,
+	}
+	// 只覆盖客户端或分组之一时清除另一个，克隆的路由不会混用源路由的目标
+	if overrides.ClientID != nil {
+		if _, err := s.getOrgClient(r, *overrides.ClientID); err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Target client does not exist")
+			return
+		}
+		clone.ClientID = *overrides.ClientID
+		if overrides.GroupID == nil {
+			clone.GroupID = 0
+		}
+	}
+	if overrides.GroupID != nil {
+		if *overrides.GroupID < 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid group_id")
+			return
+		}
+		if *overrides.GroupID > 0 {
+			if _, err := s.getOrgGroup(r, *overrides.GroupID); err != nil {
+				utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Client group does not exist")
+				return
+			}
+		}
+		clone.GroupID = *overrides.GroupID
+		if overrides.ClientID == nil {
+			clone.ClientID = ""
+		}
+	}
+	if overrides.URLSuffix != nil {
+		if !utils.IsValidPattern(*overrides.URLSuffix) {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid url_suffix")
+			return
+		}
+		clone.URLSuffix = *overrides.URLSuffix
+	}
+	if overrides.Description != nil {
+		clone.Description = *overrides.Description
+	}
+	if overrides.Enabled != nil && *overrides.Enabled {
+		clone.Enabled = 1
+	}
+	// 克隆的路由同样只能指向有编辑权限的客户端和分组
+	if !s.requireRouteTarget(w, r, clone.ClientID, clone.GroupID) {
+		return
+	}
+	if !s.requireRouteSuffix(w, r, clone, "") {
+		return
+	}
+
+	if err := s.db.CreateServerRoute(clone); err != nil {
+		if database.IsUniqueConstraintError(err) {
+			utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "A route with the same url_suffix already exists for this client")
+			return
+		}
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	convertedRoutes, err := s.routesForAPI(r, []*database.ServerRoute{clone})
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", formatETag(clone.Version))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(convertedRoutes[0])
+}
+
+func (s *apiHandlers) handleDeleteRoute(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	routeID := vars["id"]
+
+	id, err := strconv.Atoi(routeID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
+		return
+	}
+
+	route, err := s.getOrgRoute(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if !s.requireRouteEdit(w, r, route) {
+		return
+	}
+
+	if err := s.db.DeleteServerRoute(id); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.routesChanged()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 更新单个路由的启用状态
+func (s *apiHandlers) handleUpdateRouteEnabled(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	routeID := vars["id"]
+
+	id, err := strconv.Atoi(routeID)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
+		return
+	}
+
+	var request struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	route, err := s.getOrgRoute(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return
+	}
+	if !s.requireRouteEdit(w, r, route) {
+		return
+	}
+	if request.Enabled && route.IsExpired(time.Now()) {
+		utils.WriteError(w, r, http.StatusConflict, utils.ErrCodeConflict, "Route has expired; extend or clear expires_at before enabling it")
+		return
+	}
+
+	if err := s.db.UpdateServerRouteEnabled(id, request.Enabled); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.routesChanged()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 批量更新路由启用状态
+func (s *apiHandlers) handleBatchUpdateRoutesEnabled(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RouteIDs []int `json:"route_ids"`
+		Enabled  bool  `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	if len(request.RouteIDs) == 0 {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "No route IDs provided")
+		return
+	}
+
+	if !s.requireRoutesEdit(w, r, request.RouteIDs) {
+		return
+	}
+
+	if err := s.db.BatchUpdateServerRoutesEnabled(requestOrgID(r), request.RouteIDs, request.Enabled); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.routesChanged()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 批量删除路由
+func (s *apiHandlers) handleBatchDeleteRoutes(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		RouteIDs []int  `json:"route_ids"`
+		ClientID string `json:"client_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+		return
+	}
+
+	if len(request.RouteIDs) == 0 && request.ClientID == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Either route_ids or client_id must be provided")
+		return
+	}
+
+	if !s.requireRoutesEdit(w, r, request.RouteIDs) {
+		return
+	}
+	if request.ClientID != "" && !s.requireClientEdit(w, r, request.ClientID) {
+		return
+	}
+
+	results, err := s.db.BatchDeleteServerRoutes(requestOrgID(r), request.RouteIDs, request.ClientID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	s.routesChanged()
+
+	deleted := 0
+	for _, result := range results {
+		if result.Status == "deleted" {
+			deleted++
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"deleted":   deleted,
+		"not_found": len(results) - deleted,
+		"results":   results,
+	})
+}
+
+// 获取路由统计信息
+func (s *apiHandlers) handleGetRouteStats(w http.ResponseWriter, r *http.Request) {
+	clientID := r.URL.Query().Get("client_id")
+
+	stats, err := s.db.GetServerRouteStats(requestOrgID(r), clientID)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// SearchResult 统一搜索结果
+type SearchResult struct {
+	Type          string   `json:"type"` // client / route
+	ID            string   `json:"id"`
+	Title         string   `json:"title"`
+	Subtitle      string   `json:"subtitle"`
+	Status        string   `json:"status,omitempty"`
+	MatchedFields []string `json:"matched_fields"`
+}
+
+// handleSearch 同时搜索客户端和路由，返回带类型的结果供前端搜索框使用
+func (s *apiHandlers) handleSearch(w http.ResponseWriter, r *http.Request) {
+	keyword := strings.TrimSpace(r.URL.Query().Get("q"))
+	if keyword == "" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Query parameter 'q' is required")
+		return
+	}
+	
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		if l, err := strconv.Atoi(limitStr); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	
+	clients, err := s.db.SearchClients(requestOrgID(r), keyword, limit)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	routes, err := s.db.SearchServerRoutes(requestOrgID(r), keyword, limit)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	
+	// 只返回当前用户可见的结果
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	
+	lowerKeyword := strings.ToLower(keyword)
+	contains := func(value string) bool {
+		return strings.Contains(strings.ToLower(value), lowerKeyword)
+	}
+	
+	results := make([]SearchResult, 0, len(clients)+len(routes))
+	for _, client := range clients {
+		if scope.clientPermission(client.ClientID) == "" {
+			continue
+		}
+		matched := make([]string, 0)
+		if contains(client.Name) {
+			matched = append(matched, "name")
+		}
+		if contains(client.ClientID) {
+			matched = append(matched, "client_id")
+		}
+		if contains(client.Description) {
+			matched = append(matched, "description")
+		}
+		if contains(client.LocalIPs) {
+			matched = append(matched, "local_ips")
+		}
+		
+		status := "offline"
+		if client.Enabled != 1 {
+			status = "disabled"
+		} else if s.wsManager.IsClientConnected(client.ClientID) {
+			status = "online"
+		}
+		
+		results = append(results, SearchResult{
+			Type:          "client",
+			ID:            client.ClientID,
+			Title:         client.Name,
+			Subtitle:      client.ClientID,
+			Status:        status,
+			MatchedFields: matched,
+		})
+	}
+	for _, route := range routes {
+		if scope.routePermission(route) == "" {
+			continue
+		}
+		matched := make([]string, 0)
+		if contains(route.URLSuffix) {
+			matched = append(matched, "url_suffix")
+		}
+		if contains(route.Description) {
+			matched = append(matched, "description")
+		}
+		if contains(route.TargetsJSON) {
+			matched = append(matched, "targets_json")
+		}
+		
+		status := "disabled"
+		if route.IsEnabled() {
+			status = "enabled"
+		}
+		
+		results = append(results, SearchResult{
+			Type:          "route",
+			ID:            strconv.Itoa(route.ID),
+			Title:         route.URLSuffix,
+			Subtitle:      route.ClientID,
+			Status:        status,
+			MatchedFields: matched,
+		})
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"query":   keyword,
+		"total":   len(results),
+		"results": results,
+	})
+}
+
+func (s *apiHandlers) handleGetStatus(w http.ResponseWriter, r *http.Request) {
+	// 获取总客户端数量
+	allClients, err := s.db.ListClients()
+	totalClients := 0
+	if err == nil {
+		totalClients = len(allClients)
+	}
+
+	// 检查数据库连接状态
+	dbStatus := "connected"
+	if err := s.db.Ping(); err != nil {
+		dbStatus = "disconnected"
+	}
+	dbMetrics := s.db.DatabaseMetrics()
+	if dbMetrics.Degraded {
+		dbStatus = "degraded"
+	}
+
+	status := map[string]interface{}{
+		"server": map[string]interface{}{
+			"status":    "running",
+			"timestamp": time.Now(),
+			"version":   version.Version,
+		},
+		"database": map[string]interface{}{
+			"status":   dbStatus,
+			"degraded": dbMetrics.Degraded,
+			"metrics":  dbMetrics,
+		},
+		"clients": map[string]interface{}{
+			"connected": s.wsManager.GetConnectedClientCount(),
+			"total":     totalClients,
+		},
+		"read_only": s.freeze.Status().ReadOnly,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleGetVersion 获取服务端的构建信息（无需认证）
+func (s *apiHandlers) handleGetVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// proxyURL 外部访问代理端口的地址，优先使用配置的 proxy.public_url，
+// 否则代理端口配置了具体的监听地址时使用该地址，再否则使用 serverHost
+func (s *apiHandlers) proxyURL(serverHost string) string {
+	if s.config.ProxyPublicURL != "" {
+		return s.config.ProxyPublicURL
+	}
+	proxyHost := serverHost
+	if bind := strings.TrimSuffix(strings.TrimPrefix(s.config.ProxyBind, "["), "]"); bind != "" && bind != config.BindAll && !strings.HasPrefix(bind, config.UnixSocketPrefix) {
+		if ip := net.ParseIP(bind); ip == nil || !ip.IsUnspecified() {
+			proxyHost = bind
+		}
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(proxyHost, strconv.Itoa(s.config.ProxyPort)))
+}
+
+// advertisedHost 返回给客户端的服务器地址，配置为0.0.0.0时使用本地IP
+func (s *apiHandlers) advertisedHost() string {
+	serverHost := s.config.ServerHost
+	if serverHost == "0.0.0.0" || serverHost == "::" {
+		if localIP, err := utils.GetLocalIP(); err == nil {
+			serverHost = localIP
+		}
+	}
+	return serverHost
+}
+
+func (s *apiHandlers) handleGetServerInfo(w http.ResponseWriter, r *http.Request) {
+	serverHost := s.advertisedHost()
+	
+	info := map[string]interface{}{
+		"name":        "Tunnel Flow Server",
+		"version":     version.Version,
+		"description": "HTTP tunnel and proxy server",
+		"api_port":    s.config.APIPort,
+		"ws_port":     s.config.WebSocketPort,
+		"proxy_port":  s.config.ProxyPort,
+		"server_host": serverHost,
+		"proxy_url":   s.proxyURL(serverHost),
+		"proxy_path_prefix": s.config.ProxyPathPrefix,
+		"proxy_direct_mode": s.config.ProxyDirectMode,
+		"uptime":      time.Since(time.Now()).String(), // 简化处理
+		"features": []string{
+			"HTTP Tunneling",
+			"WebSocket Support",
+			"Multi-client Management",
+			"Route Management",
+			"Authentication",
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(info)
+}
+
+// handleGetClientStats 获取代理最近一次上报的主机运行状态
+func (s *apiHandlers) handleGetClientStats(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+	
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	
+	stats, err := s.db.GetClientStats(clientID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			utils.WriteInternalError(w, r, err)
+			return
+		}
+		// 代理尚未上报过运行状态
+		stats = &database.ClientStats{ClientID: clientID, Stats: json.RawMessage("null")}
+	}
+	
+	response := struct {
+		*database.ClientStats
+		Online bool `json:"online"`
+	}{
+		ClientStats: stats,
+		Online:      s.wsManager.IsClientConnected(clientID),
+	}
+	
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleGetClientMetrics 获取客户端的统一指标：服务端对当前连接的统计和代理最近一次上报的计数器
+func (s *apiHandlers) handleGetClientMetrics(w http.ResponseWriter, r *http.Request) {
+	clientID := mux.Vars(r)["id"]
+
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+
+	// 客户端不在线或代理没有上报过时对应字段为null
+	response := struct {
+		ClientID string                   `json:"client_id"`
+		Online   bool                     `json:"online"`
+		Server   *websocket.ClientMetrics `json:"server"`
+		Agent    *websocket.AgentMetrics  `json:"agent"`
+	}{ClientID: clientID}
+	if metrics, ok := s.wsManager.ClientMetrics(clientID); ok {
+		response.Online = true
+		response.Server = &metrics
+	}
+	if metrics, ok := s.wsManager.AgentMetrics(clientID); ok {
+		response.Agent = &metrics
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 客户端配置管理处理器
+// 客户端启用状态管理API
+func (s *apiHandlers) handleUpdateClientEnabled(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	clientID := vars["id"]
+	
+	var request struct {
+		Enabled bool `json:"enabled"`
+	}
+	
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid request body")
+		return
+	}
+	
+	if _, err := s.getOrgClient(r, clientID); err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
+		return
+	}
+	if !s.requireClientEdit(w, r, clientID) {
+		return
+	}
+	
+	if err := s.db.UpdateClientEnabled(clientID, request.Enabled); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 辅助函数
+
+// formatETag 根据版本号生成ETag
+func formatETag(version int64) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// parseIfMatch 解析If-Match请求头，返回期望的版本号
+// 未携带If-Match或值为*时present为false，表示不做并发检查
+func parseIfMatch(r *http.Request) (version int64, present bool, err error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return 0, false, nil
+	}
+	
+	// 只取第一个ETag，兼容弱校验前缀
+	if idx := strings.Index(ifMatch, ","); idx >= 0 {
+		ifMatch = strings.TrimSpace(ifMatch[:idx])
+	}
+	ifMatch = strings.TrimPrefix(ifMatch, "W/")
+	ifMatch = strings.Trim(ifMatch, `"`)
+	
+	version, err = strconv.ParseInt(ifMatch, 10, 64)
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid If-Match header")
+	}
+	return version, true, nil
+}
+
+// decodeMergePatch 解析JSON Merge Patch请求体，要求是JSON对象
+// 返回false表示已写入错误响应
+func decodeMergePatch(w http.ResponseWriter, r *http.Request) (map[string]json.RawMessage, bool) {
+	var patch map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil || patch == nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Request body must be a JSON object")
+		return nil, false
+	}
+	return patch, true
+}
 
-	if err := s.db.BatchUpdateServerRoutesEnabled(request.RouteIDs, request.Enabled); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// invalidCompressionMessage 路由压缩方式无效时的错误信息
+const invalidCompressionMessage = "compression must be one of none, gzip, br or empty for the server default"
+
+// invalidPayloadCompressionMessage 路由载荷压缩方式无效时的错误信息
+const invalidPayloadCompressionMessage = "payload_compression must be one of none, gzip, deflate or empty for the server default"
+
+// isValidRoutePayloadCompression 检查路由的载荷压缩方式是否有效，为空表示使用服务器配置
+func isValidRoutePayloadCompression(algorithm string) bool {
+	return algorithm == "" || protocol.IsValidCompressionAlgorithm(algorithm)
+}
+
+// routeTimeoutField 路由的一个超时设置字段
+type routeTimeoutField struct {
+	name  string
+	value *int
+}
+
+// routeTimeoutFields 路由的超时设置字段，按API字段名访问
+func routeTimeoutFields(route *database.ServerRoute) []routeTimeoutField {
+	return []routeTimeoutField{
+		{"connect_timeout_ms", &route.ConnectTimeoutMS},
+		{"header_timeout_ms", &route.HeaderTimeoutMS},
+		{"body_idle_timeout_ms", &route.BodyIdleTimeoutMS},
+		{"total_timeout_ms", &route.TotalTimeoutMS},
+		{"stream_idle_timeout_ms", &route.StreamIdleTimeoutMS},
+		{"stream_total_timeout_ms", &route.StreamTotalTimeoutMS},
 	}
+}
 
-	w.WriteHeader(http.StatusNoContent)
+// maxRouteFaultDelayMS 路由 fault_delay_ms 的上限
+const maxRouteFaultDelayMS = 5 * 60 * 1000
+
+// routeFaultFields 路由的故障注入字段，按API字段名访问
+func routeFaultFields(route *database.ServerRoute) []routeTimeoutField {
+	return []routeTimeoutField{
+		{"fault_delay_ms", &route.FaultDelayMS},
+		{"fault_error_percent", &route.FaultErrorPercent},
+		{"fault_error_status", &route.FaultErrorStatus},
+		{"fault_drop_percent", &route.FaultDropPercent},
+	}
 }
 
-// 获取路由统计信息
-func (s *Server) handleGetRouteStats(w http.ResponseWriter, r *http.Request) {
-	clientID := r.URL.Query().Get("client_id")
+// routeCountryField 路由的一个国家列表字段
+type routeCountryField struct {
+	name  string
+	value *[]string
+}
 
-	stats, err := s.db.GetServerRouteStats(clientID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// routeCountryFields 路由的国家限制字段，按API字段名访问
+func routeCountryFields(route *database.ServerRoute) []routeCountryField {
+	return []routeCountryField{
+		{"allowed_countries", &route.AllowedCountries},
+		{"denied_countries", &route.DeniedCountries},
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+// validateRouteFaults 检查路由的故障注入设置
+func validateRouteFaults(route *database.ServerRoute) error {
+	if route.FaultDelayMS < 0 || route.FaultDelayMS > maxRouteFaultDelayMS {
+		return fmt.Errorf("fault_delay_ms must be between 0 and %d", maxRouteFaultDelayMS)
+	}
+	if route.FaultErrorPercent < 0 || route.FaultErrorPercent > 100 {
+		return fmt.Errorf("fault_error_percent must be between 0 and 100")
+	}
+	if route.FaultErrorStatus != 0 && (route.FaultErrorStatus < 400 || route.FaultErrorStatus > 599) {
+		return fmt.Errorf("fault_error_status must be an HTTP error status between 400 and 599")
+	}
+	if route.FaultDropPercent < 0 || route.FaultDropPercent > 100 {
+		return fmt.Errorf("fault_drop_percent must be between 0 and 100")
+	}
+	return nil
 }
 
+// decodePatchNonNegativeInt 解析非负整数字段，null表示恢复为0
+func decodePatchNonNegativeInt(raw json.RawMessage, dst *int) error {
+	value := 0
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &value); err != nil || value < 0 {
+			return fmt.Errorf("must be a non-negative integer")
+		}
+	}
+	*dst = value
+	return nil
+}
 
+// applyRouteBasicAuth 按提交的用户名和密码设置路由的基本认证：提交密码时重新计算哈希，
+// 用户名为空时取消认证。密码不保存在路由中
+func applyRouteBasicAuth(route *database.ServerRoute) error {
+	password := route.BasicAuthPassword
+	route.BasicAuthPassword = ""
+	if strings.ContainsAny(route.BasicAuthUser, ":\r\n") {
+		return fmt.Errorf("basic_auth_user must not contain ':' or line breaks")
+	}
+	if route.BasicAuthUser == "" {
+		if password != "" {
+			return fmt.Errorf("basic_auth_user is required when basic_auth_password is set")
+		}
+		route.BasicAuthHash = ""
+		return nil
+	}
+	if password != "" {
+		return route.SetBasicAuthPassword(password)
+	}
+	if route.BasicAuthHash == "" {
+		return fmt.Errorf("basic_auth_password is required when basic_auth_user is set")
+	}
+	return nil
+}
 
+// validateTargetsJSON 检查路由目标：单个目标的URL，或JSON数组形式的目标列表，如 [{"url":"http://127.0.0.1:8080"}]
+// 以 [ 或 { 开头的值按JSON解析，格式错误时拒绝，避免保存后被代理当作URL转发
+func validateTargetsJSON(value string) error {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "[") && !strings.HasPrefix(value, "{") {
+		return nil
+	}
+	if !json.Valid([]byte(value)) {
+		return fmt.Errorf("must be a URL or valid JSON")
+	}
+	var targets []database.RouteTarget
+	if err := json.Unmarshal([]byte(value), &targets); err != nil || len(targets) == 0 {
+		return fmt.Errorf("must be a non-empty JSON array of targets")
+	}
+	for _, target := range targets {
+		if strings.TrimSpace(target.URL) == "" {
+			return fmt.Errorf("every target must have a url")
+		}
+	}
+	return nil
+}
 
+// decodePatchString 解析字符串字段，null表示清空
+func decodePatchString(raw json.RawMessage, dst *string) error {
+	if string(raw) == "null" {
+		*dst = ""
+		return nil
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("must be a string")
+	}
+	return nil
+}
 
-func (s *Server) handleGetStatus(w http.ResponseWriter, r *http.Request) {
-	// 获取总客户端数量
-	allClients, err := s.db.ListClients()
-	totalClients := 0
-	if err == nil {
-		totalClients = len(allClients)
+// decodePatchBool 解析布尔字段，null表示false
+func decodePatchBool(raw json.RawMessage, dst *bool) error {
+	if string(raw) == "null" {
+		*dst = false
+		return nil
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("must be a boolean")
 	}
+	return nil
+}
 
-	// 检查数据库连接状态
-	dbStatus := "connected"
-	if err := s.db.Ping(); err != nil {
-		dbStatus = "disconnected"
+// decodePatchPositiveInt 解析正整数字段，null表示恢复默认值
+func decodePatchPositiveInt(raw json.RawMessage, dst *int, defaultValue int) error {
+	if string(raw) == "null" {
+		*dst = defaultValue
+		return nil
 	}
+	var value int
+	if err := json.Unmarshal(raw, &value); err != nil || value <= 0 {
+		return fmt.Errorf("must be a positive integer")
+	}
+	*dst = value
+	return nil
+}
 
-	status := map[string]interface{}{
-		"server": map[string]interface{}{
-			"status":    "running",
-			"timestamp": time.Now(),
-			"version":   "1.0.0",
-		},
-		"database": map[string]interface{}{
-			"status": dbStatus,
-		},
-		"clients": map[string]interface{}{
-			"connected": s.wsManager.GetConnectedClientCount(),
-			"total":     totalClients,
-		},
+// normalizeMatchHeaders 校验路由的请求头匹配条件并规范化请求头名称
+func normalizeMatchHeaders(headers map[string]string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+	normalized := make(map[string]string, len(headers))
+	for name, value := range headers {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") {
+			return nil, fmt.Errorf("invalid header name '%s'", name)
+		}
+		canonical := http.CanonicalHeaderKey(name)
+		if _, exists := normalized[canonical]; exists {
+			return nil, fmt.Errorf("duplicate header '%s'", canonical)
+		}
+		normalized[canonical] = value
 	}
+	return normalized, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+// normalizeMatchQuery 校验路由的查询参数匹配条件，非空的条件值必须是有效的正则表达式
+func normalizeMatchQuery(query map[string]string) (map[string]string, error) {
+	if len(query) == 0 {
+		return nil, nil
+	}
+	for name, pattern := range query {
+		if name == "" {
+			return nil, fmt.Errorf("parameter name must not be empty")
+		}
+		if pattern == "" {
+			continue
+		}
+		if _, err := utils.CompileValuePattern(pattern); err != nil {
+			return nil, fmt.Errorf("invalid pattern for parameter '%s': %v", name, err)
+		}
+	}
+	return query, nil
 }
 
-func (s *Server) handleGetServerInfo(w http.ResponseWriter, r *http.Request) {
-	// 获取实际IP地址，如果配置为0.0.0.0则使用本地IP
-	serverHost := s.config.ServerHost
-	if serverHost == "0.0.0.0" {
-		if localIP, err := utils.GetLocalIP(); err == nil {
-			serverHost = localIP
+// decodePatchConditions 解析路由匹配条件，null表示清除所有条件
+func decodePatchConditions(raw json.RawMessage, dst *map[string]string, normalize func(map[string]string) (map[string]string, error)) error {
+	var conditions map[string]string
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &conditions); err != nil {
+			return fmt.Errorf("must be an object of names to string values")
 		}
 	}
-	
-	// 构建代理服务器URL
-	proxyURL := fmt.Sprintf("http://%s:%d", serverHost, s.config.ProxyPort)
-	
-	info := map[string]interface{}{
-		"name":        "Tunnel Flow Server",
-		"version":     "1.0.0",
-		"description": "HTTP tunnel and proxy server",
-		"api_port":    s.config.APIPort,
-		"ws_port":     s.config.WebSocketPort,
-		"proxy_port":  s.config.ProxyPort,
-		"server_host": serverHost,
-		"proxy_url":   proxyURL,
-		"uptime":      time.Since(time.Now()).String(), // 简化处理
-		"features": []string{
-			"HTTP Tunneling",
-			"WebSocket Support",
-			"Multi-client Management",
-			"Route Management",
-			"Authentication",
-		},
+	normalized, err := normalize(conditions)
+	if err != nil {
+		return err
 	}
+	*dst = normalized
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(info)
+// normalizeCountries 校验路由的国家列表并转为大写的ISO 3166-1两位代码，去掉重复的国家
+func normalizeCountries(countries []string) ([]string, error) {
+	if len(countries) == 0 {
+		return nil, nil
+	}
+	normalized := make([]string, 0, len(countries))
+	seen := make(map[string]bool, len(countries))
+	for _, country := range countries {
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if len(country) != 2 || country[0] < 'A' || country[0] > 'Z' || country[1] < 'A' || country[1] > 'Z' {
+			return nil, fmt.Errorf("invalid country code '%s', expected a two-letter code such as US", country)
+		}
+		if !seen[country] {
+			seen[country] = true
+			normalized = append(normalized, country)
+		}
+	}
+	return normalized, nil
 }
 
-// 客户端配置管理处理器
-// 客户端启用状态管理API
-func (s *Server) handleUpdateClientEnabled(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	clientID := vars["id"]
-	
-	var request struct {
-		Enabled bool `json:"enabled"`
+// decodePatchCountries 解码并规范化路由的国家列表，null 表示清空
+func decodePatchCountries(raw json.RawMessage, dst *[]string) error {
+	var countries []string
+	if string(raw) != "null" {
+		if err := json.Unmarshal(raw, &countries); err != nil {
+			return fmt.Errorf("must be an array of country codes")
+		}
 	}
-	
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
+	normalized, err := normalizeCountries(countries)
+	if err != nil {
+		return err
 	}
-	
-	if err := s.db.UpdateClientEnabled(clientID, request.Enabled); err != nil {
-		http.Error(w, "Failed to update client enabled status", http.StatusInternalServerError)
-		return
+	*dst = normalized
+	return nil
+}
+
+// checkCountryRules 路由设置国家限制时要求配置了GeoIP数据库，否则无法确定请求来源国家
+func (s *apiHandlers) checkCountryRules(route *database.ServerRoute) error {
+	if route.HasCountryRules() && s.config.GeoIPDatabase == "" {
+		return fmt.Errorf("allowed_countries and denied_countries require geoip.database to be configured")
 	}
-	
-	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
-// 辅助函数
 func generateClientID() string {
 	return fmt.Sprintf("client_%d", time.Now().UnixNano())
 }
@@ -856,6 +2254,8 @@ type MultiServer struct {
 	config        *config.Config
 	db            *database.Repository
 	wsManager     *websocket.Manager
+	traffic       *monitoring.TrafficStats
+	quota         *quota.Manager
 	
 	apiServer     *APIServer
 	wsServer      *WebSocketServer
@@ -864,15 +2264,15 @@ type MultiServer struct {
 	wg            sync.WaitGroup
 	ctx           context.Context
 	cancel        context.CancelFunc
+	ready         chan struct{} // API、WebSocket和代理端口都绑定成功后关闭
+	probes        *probeState   // 三个端口共用的存活和就绪探针
 }
 
-// APIServer API服务器（原Server重命名）
+// APIServer 多端口模式下的API服务器，与Server共用API处理函数
 type APIServer struct {
-	config         *config.Config
-	db             *database.Repository
-	authHandler    *auth.AuthHandler
-	wsManager      *websocket.Manager
-	server         *http.Server
+	*apiHandlers
+	server *http.Server
+	probes *probeState
 }
 
 // NewMultiServer 创建多端口服务器管理器
@@ -880,17 +2280,67 @@ func NewMultiServer(cfg *config.Config, db *database.Repository, objectPool *per
 	// 创建WebSocket管理器
 	wsManager := websocket.NewManager(cfg, db, objectPool, workerPool, metrics)
 	
+	// 代理服务器记录流量，API服务器读取流量概览
+	traffic := monitoring.NewTrafficStats()
+	
+	// 代理服务器执行用量配额，API服务器管理配额配置
+	quotas := quota.NewManager(db, cfg.QuotaWebhookURL)
+	
+	// 代理服务器按路由和状态码计数，API服务器读取计数
+	collector, _ := metrics.(*monitoring.MetricsCollector)
+	
 	// 创建各个服务器
-	apiServer := NewAPIServer(cfg, db, wsManager)
+	apiServer := NewAPIServer(cfg, db, wsManager, traffic, collector, quotas)
 	wsServer := NewWebSocketServer(cfg, wsManager)
-	proxyServer := NewProxyServer(cfg, db, wsManager)
+	proxyServer := NewProxyServer(cfg, db, wsManager, traffic, collector, quotas)
+	
+	// 路由修改后API服务器通知代理服务器打开或关闭独立端口
+	apiServer.routeListeners = proxyServer.routes
+	
+	// 三个端口都提供 /live 和 /ready，探针可以配置在任意端口上
+	probes := &probeState{
+		check: func() error {
+			wsManager.GetConnectedClientCount()
+			return db.Ping()
+		},
+		degraded: func() bool { return db.DatabaseMetrics().Degraded },
+	}
+	apiServer.probes = probes
+	wsServer.probes = probes
+	proxyServer.probes = probes
+	
+	ctx, cancel := context.WithCancel(context.Background())
 	
 	return &MultiServer{
 		config:      cfg,
+		db:          db,
 		apiServer:   apiServer,
 		wsServer:    wsServer,
 		proxyServer: proxyServer,
 		wsManager:   wsManager,
+		traffic:     traffic,
+		quota:       quotas,
+		ctx:         ctx,
+		cancel:      cancel,
+		ready:       make(chan struct{}),
+		probes:      probes,
+	}
+}
+
+// Ready 返回在API、WebSocket和代理端口都绑定成功后关闭的通道，有端口绑定失败时不会关闭
+func (ms *MultiServer) Ready() <-chan struct{} {
+	return ms.ready
+}
+
+// CheckLiveness 检查进程没有卡死：能获取客户端连接表的锁并访问数据库，调用会在死锁时阻塞
+func (ms *MultiServer) CheckLiveness() error {
+	return ms.probes.check()
+}
+
+// SetDraining 开始停止：/ready 立即返回503，负载均衡摘除本实例后再调用 Stop，端口在此之前仍正常处理请求
+func (ms *MultiServer) SetDraining() {
+	if !ms.probes.draining.Swap(true) {
+		log.Println("Readiness set to false, waiting for load balancers to stop sending traffic")
 	}
 }
 
@@ -898,37 +2348,55 @@ func NewMultiServer(cfg *config.Config, db *database.Repository, objectPool *per
 func (ms *MultiServer) Start() error {
 	log.Println("Starting multi-port tunnel-flow servers...")
 	
-	// 启动API服务器
+	// 加载用量配额并恢复当前周期的用量
+	if err := ms.quota.Load(); err != nil {
+		log.Printf("Failed to load client quotas: %v", err)
+	}
+	
+	// 依次启动API、WebSocket和代理服务器，绑定端口后在后台处理请求
+	bound := true
+	if err := ms.apiServer.Start(); err != nil {
+		log.Printf("API server error: %v", err)
+		bound = false
+	}
+	if err := ms.wsServer.Start(); err != nil {
+		log.Printf("WebSocket server error: %v", err)
+		bound = false
+	}
+	if err := ms.proxyServer.Start(); err != nil {
+		log.Printf("Proxy server error: %v", err)
+		bound = false
+	}
+	if bound {
+		ms.probes.bound.Store(true)
+		close(ms.ready)
+	}
+	
+	// 定期持久化流量小时汇总
 	ms.wg.Add(1)
-	go func() {
-		defer ms.wg.Done()
-		if err := ms.apiServer.Start(); err != nil && err != http.ErrServerClosed {
-			log.Printf("API server error: %v", err)
-		}
-	}()
+	go ms.runTrafficFlusher()
 	
-	// 启动WebSocket服务器
+	// 禁用或删除到期的临时路由
 	ms.wg.Add(1)
 	go func() {
 		defer ms.wg.Done()
-		if err := ms.wsServer.Start(); err != nil && err != http.ErrServerClosed {
-			log.Printf("WebSocket server error: %v", err)
-		}
+		ms.apiServer.runRouteExpiry(ms.ctx)
 	}()
 	
-	// 启动代理服务器
+	// 按周期重置配额计数
 	ms.wg.Add(1)
 	go func() {
 		defer ms.wg.Done()
-		if err := ms.proxyServer.Start(); err != nil && err != http.ErrServerClosed {
-			log.Printf("Proxy server error: %v", err)
-		}
+		ms.quota.Run(ms.ctx)
 	}()
 	
 	log.Printf("All servers started successfully:")
-	log.Printf("  - API Server: http://%s:%d", ms.config.ServerHost, ms.config.APIPort)
-	log.Printf("  - WebSocket Server: ws://%s:%d", ms.config.ServerHost, ms.config.WebSocketPort)
-	log.Printf("  - Proxy Server: http://%s:%d", ms.config.ServerHost, ms.config.ProxyPort)
+	apiNetwork, apiAddress := ms.config.APIListenAddress()
+	wsNetwork, wsAddress := ms.config.WebSocketListenAddress()
+	proxyNetwork, proxyAddress := ms.config.ProxyListenAddress(ms.config.ProxyPort)
+	log.Printf("  - API Server: %s", listenURL("http", apiNetwork, apiAddress))
+	log.Printf("  - WebSocket Server: %s", listenURL("ws", wsNetwork, wsAddress))
+	log.Printf("  - Proxy Server: %s", listenURL("http", proxyNetwork, proxyAddress))
 	
 	// 等待所有服务器完成
 	ms.wg.Wait()
@@ -937,21 +2405,22 @@ func (ms *MultiServer) Start() error {
 
 // Stop 停止所有服务器
 func (ms *MultiServer) Stop() error {
+	ms.SetDraining()
 	ms.cancel()
 	
-	// 停止各个服务器
+	// 停止各个服务器，代理服务器先于WebSocket服务器停止，进行中的代理请求可以等到代理的响应
 	if err := ms.apiServer.Stop(context.Background()); err != nil {
 		log.Printf("Error stopping API server: %v", err)
 	}
 	
-	if err := ms.wsServer.Stop(); err != nil {
-		log.Printf("Error stopping WebSocket server: %v", err)
-	}
-	
 	if err := ms.proxyServer.Stop(); err != nil {
 		log.Printf("Error stopping Proxy server: %v", err)
 	}
 	
+	if err := ms.wsServer.Stop(); err != nil {
+		log.Printf("Error stopping WebSocket server: %v", err)
+	}
+	
 	// 等待所有goroutine完成
 	ms.wg.Wait()
 	
@@ -959,40 +2428,99 @@ func (ms *MultiServer) Stop() error {
 	return nil
 }
 
+// trafficFlushInterval 流量小时汇总的持久化间隔
+const trafficFlushInterval = time.Minute
+
+// runTrafficFlusher 定期把流量增量写入小时汇总表，停止时再写入一次
+func (ms *MultiServer) runTrafficFlusher() {
+	defer ms.wg.Done()
+	
+	ticker := time.NewTicker(trafficFlushInterval)
+	defer ticker.Stop()
+	
+	for {
+		select {
+		case <-ms.ctx.Done():
+			ms.flushTraffic()
+			return
+		case <-ticker.C:
+			ms.flushTraffic()
+		}
+	}
+}
+
+// flushTraffic 持久化流量增量，失败时放回等待下次重试
+func (ms *MultiServer) flushTraffic() {
+	items := ms.traffic.DrainHourly()
+	if len(items) == 0 {
+		return
+	}
+	
+	rollups := make([]database.TrafficRollup, len(items))
+	for i, item := range items {
+		rollups[i] = database.TrafficRollup{
+			HourTS:    item.HourTS,
+			OrgID:     item.OrgID,
+			ClientID:  item.ClientID,
+			RouteID:   item.RouteID,
+			URLSuffix: item.URLSuffix,
+			Requests:  item.Requests,
+			Errors:    item.Errors,
+			BytesIn:   item.BytesIn,
+			BytesOut:  item.BytesOut,
+		}
+	}
+	
+	if err := ms.db.AddTrafficRollups(rollups); err != nil {
+		log.Printf("Failed to persist traffic rollups: %v", err)
+		ms.traffic.RestoreHourly(items)
+	}
+}
+
 // NewAPIServer 创建新的API服务器
-func NewAPIServer(cfg *config.Config, db *database.Repository, wsManager *websocket.Manager) *APIServer {
+func NewAPIServer(cfg *config.Config, db *database.Repository, wsManager *websocket.Manager, traffic *monitoring.TrafficStats, metrics *monitoring.MetricsCollector, quotas *quota.Manager) *APIServer {
 	return &APIServer{
-		config:         cfg,
-		db:             db,
-		authHandler:    auth.NewAuthHandler(cfg),
-		wsManager:      wsManager,
+		apiHandlers: newAPIHandlers(cfg, db, wsManager, traffic, metrics, quotas),
 	}
 }
 
-// Start 启动API服务器
+// Start 绑定端口后在后台启动API服务器
 func (s *APIServer) Start() error {
 	router := s.setupRoutes()
 	
 	// 配置CORS
 	c := cors.New(cors.Options{
 		AllowedOrigins: []string{"*"},
-		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
 		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"ETag", utils.RequestIDHeader},
 		AllowCredentials: true,
 	})
 	
 	handler := c.Handler(router)
 	
+	network, address := s.config.APIListenAddress()
 	s.server = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", s.config.ServerHost, s.config.APIPort),
+		Addr:         address,
 		Handler:      handler,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
+	s.server.RegisterOnShutdown(s.stopStreams)
 	
-	log.Printf("API server starting on %s:%d", s.config.ServerHost, s.config.APIPort)
-	return s.server.ListenAndServe()
+	listener, err := listen(s.config, network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on API port %d: %w", s.config.APIPort, err)
+	}
+
+	log.Printf("API server starting on %s (%s)", address, network)
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("API server error: %v", err)
+		}
+	}()
+	return nil
 }
 
 // Stop 停止API服务器
@@ -1006,43 +2534,10 @@ func (s *APIServer) Stop(ctx context.Context) error {
 // setupRoutes 设置API路由
 func (s *APIServer) setupRoutes() *mux.Router {
 	r := mux.NewRouter()
+	r.Use(utils.RequestIDMiddleware)
 	
 	// API路由组
-	api := r.PathPrefix("/api/v1").Subrouter()
-	
-	// 认证相关路由（公开访问）
-	api.HandleFunc("/auth/login", s.authHandler.Login).Methods("POST")
-	api.HandleFunc("/auth/register", s.authHandler.Register).Methods("POST")
-	api.HandleFunc("/auth/profile", s.authHandler.GetProfile).Methods("GET")
-	
-	// 系统状态（公开访问）
-	api.HandleFunc("/status", s.handleGetStatus).Methods("GET")
-	api.HandleFunc("/server-info", s.handleGetServerInfo).Methods("GET")
-	
-	// 需要认证的路由
-	protected := api.PathPrefix("").Subrouter()
-	protected.Use(s.authHandler.GetAuthMiddleware().Middleware)
-	
-	// 客户端管理
-	protected.HandleFunc("/clients", s.handleGetClients).Methods("GET")
-	protected.HandleFunc("/clients", s.handleCreateClient).Methods("POST")
-	protected.HandleFunc("/clients/{id}", s.handleGetClient).Methods("GET")
-	protected.HandleFunc("/clients/{id}", s.handleUpdateClient).Methods("PUT")
-	protected.HandleFunc("/clients/{id}", s.handleDeleteClient).Methods("DELETE")
-	protected.HandleFunc("/clients/{id}/status", s.handleUpdateClientStatus).Methods("PUT")
-	protected.HandleFunc("/clients/{id}/enabled", s.handleUpdateClientEnabled).Methods("PUT")
-	
-	// 路由管理
-	protected.HandleFunc("/routes", s.handleGetRoutes).Methods("GET")
-	protected.HandleFunc("/routes", s.handleCreateRoute).Methods("POST")
-	protected.HandleFunc("/routes/{id}", s.handleGetRoute).Methods("GET")
-	protected.HandleFunc("/routes/{id}", s.handleUpdateRoute).Methods("PUT")
-	protected.HandleFunc("/routes/{id}", s.handleDeleteRoute).Methods("DELETE")
-	
-	// 路由启用状态管理
-	protected.HandleFunc("/routes/{id}/enabled", s.handleUpdateRouteEnabled).Methods("PUT")
-	protected.HandleFunc("/routes/batch/enabled", s.handleBatchUpdateRoutesEnabled).Methods("PUT")
-	protected.HandleFunc("/routes/stats", s.handleGetRouteStats).Methods("GET")
+	s.registerRoutes(r.PathPrefix("/api/v1").Subrouter())
 	
 	// 健康检查（无需认证）
 	r.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
@@ -1050,6 +2545,10 @@ func (s *APIServer) setupRoutes() *mux.Router {
 		w.Write([]byte("API Server OK"))
 	}).Methods("GET")
 	
+	// 存活和就绪探针（无需认证）
+	r.HandleFunc("/live", s.probes.handleLive).Methods("GET")
+	r.HandleFunc("/ready", s.probes.handleReady).Methods("GET")
+	
 	// 静态文件服务（如果需要）- 使用嵌入的文件系统
 	// 注意：静态文件服务应该放在最后，并且不应该拦截API路由
 	if handler, err := web.GetDistHandler(); err == nil {
@@ -1057,7 +2556,7 @@ func (s *APIServer) setupRoutes() *mux.Router {
 		r.PathPrefix("/").Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 			// 如果是API路径，返回404让API路由处理
 			if strings.HasPrefix(req.URL.Path, "/api/") {
-				http.NotFound(w, req)
+				utils.WriteError(w, req, http.StatusNotFound, utils.ErrCodeNotFound, "Not found")
 				return
 			}
 			// 否则使用静态文件处理器
@@ -1067,182 +2566,3 @@ func (s *APIServer) setupRoutes() *mux.Router {
 	
 	return r
 }
-
-// APIServer的处理函数 - 简单包装Server的方法
-func (s *APIServer) handleGetClients(w http.ResponseWriter, r *http.Request) {
-	// 创建临时Server实例来复用逻辑
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetClients(w, r)
-}
-
-func (s *APIServer) handleGetClient(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetClient(w, r)
-}
-
-func (s *APIServer) handleCreateClient(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleCreateClient(w, r)
-}
-
-func (s *APIServer) handleUpdateClient(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleUpdateClient(w, r)
-}
-
-func (s *APIServer) handleDeleteClient(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleDeleteClient(w, r)
-}
-
-func (s *APIServer) handleUpdateClientStatus(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleUpdateClientStatus(w, r)
-}
-
-func (s *APIServer) handleUpdateClientEnabled(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleUpdateClientEnabled(w, r)
-}
-
-func (s *APIServer) handleGetRoutes(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetRoutes(w, r)
-}
-
-func (s *APIServer) handleGetRoute(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetRoute(w, r)
-}
-
-func (s *APIServer) handleCreateRoute(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleCreateRoute(w, r)
-}
-
-func (s *APIServer) handleUpdateRoute(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleUpdateRoute(w, r)
-}
-
-func (s *APIServer) handleDeleteRoute(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleDeleteRoute(w, r)
-}
-
-// 更新单个路由的启用状态
-func (s *APIServer) handleUpdateRouteEnabled(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleUpdateRouteEnabled(w, r)
-}
-
-// 批量更新路由启用状态
-func (s *APIServer) handleBatchUpdateRoutesEnabled(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleBatchUpdateRoutesEnabled(w, r)
-}
-
-// 获取路由统计信息
-func (s *APIServer) handleGetRouteStats(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetRouteStats(w, r)
-}
-
-
-
-
-
-func (s *APIServer) handleGetStatus(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetStatus(w, r)
-}
-
-func (s *APIServer) handleGetServerInfo(w http.ResponseWriter, r *http.Request) {
-	tempServer := &Server{
-		config:      s.config,
-		db:          s.db,
-		authHandler: s.authHandler,
-		wsManager:   s.wsManager,
-	}
-	tempServer.handleGetServerInfo(w, r)
-}
\ No newline at end of file
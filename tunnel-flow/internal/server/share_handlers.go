@@ -0,0 +1,150 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/proxy"
+	"tunnel-flow/internal/utils"
+)
+
+// 路由分享链接API：生成带签名令牌的公开链接，私有路由只接受携带有效令牌的请求，见 proxy/access.go
+
+const (
+	defaultShareTTL = 24 * time.Hour      // 分享链接默认的有效期
+	maxShareTTL     = 30 * 24 * time.Hour // 分享链接最长的有效期
+)
+
+// RouteShare 生成的分享链接
+type RouteShare struct {
+	RouteID   int    `json:"route_id"`
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"` // 毫秒
+	Private   bool   `json:"private"`    // 路由不是私有路由时不带令牌也可以访问
+}
+
+// routeFromRequest 解析路径中的路由ID并加载当前用户可以编辑的路由，返回nil表示已写入错误响应
+func (s *apiHandlers) routeFromRequest(w http.ResponseWriter, r *http.Request) *database.ServerRoute {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeBadRequest, "Invalid route ID")
+		return nil
+	}
+	route, err := s.getOrgRoute(r, id)
+	if err != nil {
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeRouteNotFound, "Route not found")
+		return nil
+	}
+	if !s.requireRouteEdit(w, r, route) {
+		return nil
+	}
+	return route
+}
+
+// handleCreateRouteShare 生成路由的分享链接，ttl_seconds 为有效期，默认24小时、最长30天
+func (s *apiHandlers) handleCreateRouteShare(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		TTLSeconds int64 `json:"ttl_seconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil && err != io.EOF {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeInvalidJSON, "Invalid JSON")
+			return
+		}
+	}
+	ttl := defaultShareTTL
+	if request.TTLSeconds != 0 {
+		ttl = time.Duration(request.TTLSeconds) * time.Second
+		if request.TTLSeconds < 0 || ttl > maxShareTTL {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, fmt.Sprintf("ttl_seconds must be between 1 and %d", int64(maxShareTTL/time.Second)))
+			return
+		}
+	}
+
+	route := s.routeFromRequest(w, r)
+	if route == nil {
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	token := proxy.SignShareToken(s.config.AuthJWTSecret, route, expiresAt)
+	shareURL, err := s.routeShareURL(route, token)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(RouteShare{
+		RouteID:   route.ID,
+		URL:       shareURL,
+		Token:     token,
+		ExpiresAt: expiresAt.UnixMilli(),
+		Private:   route.Private,
+	})
+}
+
+// handleRevokeRouteShares 使路由之前生成的分享链接全部失效
+func (s *apiHandlers) handleRevokeRouteShares(w http.ResponseWriter, r *http.Request) {
+	route := s.routeFromRequest(w, r)
+	if route == nil {
+		return
+	}
+	if _, err := s.db.RotateServerRouteShareVersion(route.ID); err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// routeShareURL 生成访问路由的公开地址：独立端口的路由使用该端口，其他路由按代理的路径前缀、
+// 组织标识和路由路径拼接，通配符路由使用通配符之前的路径
+func (s *apiHandlers) routeShareURL(route *database.ServerRoute, token string) (string, error) {
+	base, err := url.Parse(s.proxyURL(s.advertisedHost()))
+	if err != nil {
+		return "", err
+	}
+
+	path := "/"
+	if route.ListenPort > 0 {
+		base.Host = net.JoinHostPort(base.Hostname(), strconv.Itoa(route.ListenPort))
+	} else {
+		path = route.URLSuffix
+		if idx := strings.Index(path, "*"); idx >= 0 {
+			path = path[:strings.LastIndex(path[:idx], "/")+1]
+		}
+		if route.OrgID != database.DefaultOrgID {
+			org, err := s.db.GetOrganization(route.OrgID)
+			if err != nil {
+				return "", err
+			}
+			if s.config.ProxyTenantDomain != "" {
+				host := org.Slug + "." + s.config.ProxyTenantDomain
+				if port := base.Port(); port != "" {
+					host = net.JoinHostPort(host, port)
+				}
+				base.Host = host
+			} else {
+				path = "/" + org.Slug + path
+			}
+		}
+		if !s.config.ProxyDirectMode {
+			path = s.config.ProxyPathPrefix + path
+		}
+	}
+
+	base.Path = strings.TrimSuffix(base.Path, "/") + path
+	base.RawQuery = url.Values{proxy.ShareQueryParam: {token}}.Encode()
+	return base.String(), nil
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/monitoring"
+	"tunnel-flow/internal/utils"
+)
+
+// 统计概览API
+
+const (
+	defaultOverviewTopN = 10
+	maxOverviewTopN     = 100
+)
+
+// StatsOverview 仪表盘统计概览
+type StatsOverview struct {
+	GeneratedAt int64                       `json:"generated_at"`
+	Hour        *monitoring.TrafficOverview `json:"hour"`
+	Day         *monitoring.TrafficOverview `json:"day"`
+}
+
+// handleGetStatsOverview 返回当前组织最近一小时和一天的代理流量概览，limit 控制排行数量
+func (s *apiHandlers) handleGetStatsOverview(w http.ResponseWriter, r *http.Request) {
+	topN := defaultOverviewTopN
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxOverviewTopN {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "limit must be between 1 and 100")
+			return
+		}
+		topN = limit
+	}
+
+	orgID := requestOrgID(r)
+	overview := StatsOverview{
+		GeneratedAt: time.Now().UnixMilli(),
+		Hour:        s.traffic.Overview(orgID, time.Hour, topN),
+		Day:         s.traffic.Overview(orgID, 24*time.Hour, topN),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(overview)
+}
+
+// RouteProxyCounters 单个路由自服务器启动以来的代理请求计数
+type RouteProxyCounters struct {
+	RouteID   int    `json:"route_id"`
+	URLSuffix string `json:"url_suffix"`
+	monitoring.ProxyCounters
+	ErrorRate float64 `json:"error_rate"` // 0-1，5xx响应占请求数的比例
+}
+
+// RouteProxyCountersReport 当前组织各路由的代理请求计数
+type RouteProxyCountersReport struct {
+	Routes    []RouteProxyCounters     `json:"routes"`
+	Totals    monitoring.ProxyCounters `json:"totals"`
+	ErrorRate float64                  `json:"error_rate"`
+}
+
+// handleGetRouteProxyCounters 返回当前用户可见路由按状态码类别、超时和没有可用后端的请求计数，用于按路由跟踪错误预算
+func (s *apiHandlers) handleGetRouteProxyCounters(w http.ResponseWriter, r *http.Request) {
+	routes, err := s.db.ListServerRoutesByOrg(requestOrgID(r))
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+	scope, err := s.accessScopeFor(r)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	counters := s.metrics.RouteProxyCounters()
+	report := RouteProxyCountersReport{Routes: make([]RouteProxyCounters, 0, len(routes))}
+	for _, route := range routes {
+		if scope.routePermission(route) == "" {
+			continue
+		}
+		item := RouteProxyCounters{
+			RouteID:       route.ID,
+			URLSuffix:     route.URLSuffix,
+			ProxyCounters: counters[route.ID],
+		}
+		item.ErrorRate = item.ProxyCounters.ErrorRate()
+		report.Routes = append(report.Routes, item)
+		report.Totals.Add(item.ProxyCounters)
+	}
+	report.ErrorRate = report.Totals.ErrorRate()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// TrafficReport 流量计费查询结果
+type TrafficReport struct {
+	From    int64                    `json:"from"`
+	To      int64                    `json:"to"`
+	GroupBy string                   `json:"group_by"`
+	Items   []database.TrafficRollup `json:"items"`
+	Totals  database.TrafficRollup   `json:"totals"`
+}
+
+// parseTimeParam 解析时间参数，支持毫秒时间戳、RFC3339 和 UTC 日期（2006-01-02）
+// 日期格式用作结束时间时包含当天，即返回次日零点
+func parseTimeParam(value string, isEnd bool) (time.Time, error) {
+	if ms, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		if isEnd {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
+
+// parseTrafficQuery 解析流量查询参数：from/to（默认最近24小时）、client_id、route_id、group_by（默认client）
+// 查询范围限定在当前组织内，返回false表示已写入错误响应
+func parseTrafficQuery(w http.ResponseWriter, r *http.Request) (database.TrafficFilter, string, bool) {
+	query := r.URL.Query()
+
+	to := time.Now()
+	if value := query.Get("to"); value != "" {
+		t, err := parseTimeParam(value, true)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid to: use unix milliseconds, RFC3339 or YYYY-MM-DD")
+			return database.TrafficFilter{}, "", false
+		}
+		to = t
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := query.Get("from"); value != "" {
+		t, err := parseTimeParam(value, false)
+		if err != nil {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid from: use unix milliseconds, RFC3339 or YYYY-MM-DD")
+			return database.TrafficFilter{}, "", false
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "from must be earlier than to")
+		return database.TrafficFilter{}, "", false
+	}
+
+	groupBy := query.Get("group_by")
+	if groupBy == "" {
+		groupBy = database.TrafficGroupByClient
+	}
+	switch groupBy {
+	case database.TrafficGroupByClient, database.TrafficGroupByRoute, database.TrafficGroupByHour, database.TrafficGroupByNone:
+	default:
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "group_by must be one of client, route, hour, none")
+		return database.TrafficFilter{}, "", false
+	}
+
+	// 小时汇总按小时起点存储，起始时间向下取整以包含所在小时
+	filter := database.TrafficFilter{
+		From:     from.Truncate(time.Hour).UnixMilli(),
+		To:       to.UnixMilli(),
+		OrgID:    requestOrgID(r),
+		ClientID: query.Get("client_id"),
+	}
+	if value := query.Get("route_id"); value != "" {
+		routeID, err := strconv.Atoi(value)
+		if err != nil || routeID <= 0 {
+			utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "Invalid route_id")
+			return database.TrafficFilter{}, "", false
+		}
+		filter.RouteID = routeID
+	}
+	return filter, groupBy, true
+}
+
+// queryTrafficReport 查询流量汇总并计算合计
+func (s *apiHandlers) queryTrafficReport(filter database.TrafficFilter, groupBy string) (*TrafficReport, error) {
+	items, err := s.db.QueryTrafficRollups(filter, groupBy)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TrafficReport{
+		From:    filter.From,
+		To:      filter.To,
+		GroupBy: groupBy,
+		Items:   items,
+	}
+	for _, item := range items {
+		report.Totals.Requests += item.Requests
+		report.Totals.Errors += item.Errors
+		report.Totals.BytesIn += item.BytesIn
+		report.Totals.BytesOut += item.BytesOut
+	}
+	return report, nil
+}
+
+// handleGetTraffic 按时间范围查询每小时汇总的流量，用于内部计费
+// 参数：from/to（默认最近24小时）、client_id、route_id、group_by（client/route/hour/none，默认client）
+func (s *apiHandlers) handleGetTraffic(w http.ResponseWriter, r *http.Request) {
+	filter, groupBy, ok := parseTrafficQuery(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := s.queryTrafficReport(filter, groupBy)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleExportUsage 导出流量汇总报表，用于计费和容量规划
+// 参数与 /traffic 相同，另支持 format=csv|json（默认csv），响应作为附件下载
+func (s *apiHandlers) handleExportUsage(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "format must be csv or json")
+		return
+	}
+
+	filter, groupBy, ok := parseTrafficQuery(w, r)
+	if !ok {
+		return
+	}
+
+	report, err := s.queryTrafficReport(filter, groupBy)
+	if err != nil {
+		utils.WriteInternalError(w, r, err)
+		return
+	}
+
+	filename := fmt.Sprintf("usage-%s-%s-%s.%s",
+		time.UnixMilli(filter.From).UTC().Format("20060102T15"),
+		time.UnixMilli(filter.To).UTC().Format("20060102T15"),
+		groupBy, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+		return
+	}
+
+	// 按客户端分组时附带客户端名称，便于对账
+	clientNames := make(map[string]string)
+	if groupBy == database.TrafficGroupByClient || groupBy == database.TrafficGroupByNone {
+		if clients, err := s.db.ListClientsByOrg(filter.OrgID); err == nil {
+			for _, client := range clients {
+				clientNames[client.ClientID] = client.Name
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	writer := csv.NewWriter(w)
+	writer.Write(usageCSVHeader(groupBy))
+	for _, item := range report.Items {
+		writer.Write(usageCSVRow(groupBy, item, clientNames))
+	}
+	writer.Write(usageCSVRow(groupBy, report.Totals, nil))
+	writer.Flush()
+}
+
+// usageCSVHeader 导出报表的表头，随分组方式变化
+func usageCSVHeader(groupBy string) []string {
+	var columns []string
+	switch groupBy {
+	case database.TrafficGroupByClient:
+		columns = []string{"client_id", "client_name"}
+	case database.TrafficGroupByRoute:
+		columns = []string{"route_id", "url_suffix"}
+	case database.TrafficGroupByHour:
+		columns = []string{"hour"}
+	default:
+		columns = []string{"hour", "client_id", "client_name", "route_id", "url_suffix"}
+	}
+	return append(columns, "requests", "errors", "bytes_in", "bytes_out", "total_bytes")
+}
+
+// usageCSVRow 导出报表的一行，clientNames 为空时表示合计行
+func usageCSVRow(groupBy string, item database.TrafficRollup, clientNames map[string]string) []string {
+	hour := ""
+	if item.HourTS > 0 {
+		hour = time.UnixMilli(item.HourTS).UTC().Format(time.RFC3339)
+	}
+	routeID := ""
+	if item.RouteID > 0 {
+		routeID = strconv.Itoa(item.RouteID)
+	}
+
+	var columns []string
+	switch groupBy {
+	case database.TrafficGroupByClient:
+		columns = []string{item.ClientID, clientNames[item.ClientID]}
+	case database.TrafficGroupByRoute:
+		columns = []string{routeID, item.URLSuffix}
+	case database.TrafficGroupByHour:
+		columns = []string{hour}
+	default:
+		columns = []string{hour, item.ClientID, clientNames[item.ClientID], routeID, item.URLSuffix}
+	}
+	if clientNames == nil {
+		columns[0] = "TOTAL"
+	}
+	return append(columns,
+		strconv.FormatInt(item.Requests, 10),
+		strconv.FormatInt(item.Errors, 10),
+		strconv.FormatInt(item.BytesIn, 10),
+		strconv.FormatInt(item.BytesOut, 10),
+		strconv.FormatInt(item.BytesIn+item.BytesOut, 10),
+	)
+}
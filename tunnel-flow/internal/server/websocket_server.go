@@ -17,6 +17,7 @@ type WebSocketServer struct {
 	config    *config.Config
 	wsManager *websocket.Manager
 	server    *http.Server
+	probes    *probeState
 	ctx       context.Context
 	cancel    context.CancelFunc
 }
@@ -43,17 +44,27 @@ func (s *WebSocketServer) Start() error {
 	// 健康检查
 	mux.HandleFunc("/health", s.handleHealth)
 	
+	// 存活和就绪探针
+	mux.HandleFunc("/live", s.probes.handleLive)
+	mux.HandleFunc("/ready", s.probes.handleReady)
+	
 	// 状态信息
 	mux.HandleFunc("/status", s.handleStatus)
 	
+	network, address := s.config.WebSocketListenAddress()
 	s.server = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", s.config.ServerHost, s.config.WebSocketPort),
+		Addr:         address,
 		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  60 * time.Second,
 	}
 	
+	listener, err := listenTCP(network, address, s.config.ReusePort)
+	if err != nil {
+		return fmt.Errorf("failed to listen on websocket port %d: %w", s.config.WebSocketPort, err)
+	}
+	
 	go func() {
 		// 检查是否启用 SSL/TLS
 		if s.config.WebSocketSSLEnabled {
@@ -69,17 +80,17 @@ func (s *WebSocketServer) Start() error {
 			
 			s.server.TLSConfig = tlsConfig
 			
-			log.Printf("WebSocket Secure (WSS) server starting on %s:%d", s.config.ServerHost, s.config.WebSocketPort)
+			log.Printf("WebSocket Secure (WSS) server starting on %s (%s)", address, network)
 			log.Printf("Using SSL certificate: %s", s.config.WebSocketSSLCertFile)
 			log.Printf("Using SSL key: %s", s.config.WebSocketSSLKeyFile)
 			log.Printf("Force SSL enabled: %v", s.config.WebSocketSSLForceSSL)
 			
-			if err := s.server.ListenAndServeTLS(s.config.WebSocketSSLCertFile, s.config.WebSocketSSLKeyFile); err != nil && err != http.ErrServerClosed {
+			if err := s.server.ServeTLS(listener, s.config.WebSocketSSLCertFile, s.config.WebSocketSSLKeyFile); err != nil && err != http.ErrServerClosed {
 				log.Printf("WebSocket server error: %v", err)
 			}
 		} else {
-			log.Printf("WebSocket server starting on %s:%d (non-secure)", s.config.ServerHost, s.config.WebSocketPort)
-			if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("WebSocket server starting on %s (%s, non-secure)", address, network)
+			if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
 				log.Printf("WebSocket server error: %v", err)
 			}
 		}
@@ -88,26 +99,34 @@ func (s *WebSocketServer) Start() error {
 	return nil
 }
 
-// Stop 停止WebSocket服务器
+// Stop 停止WebSocket服务器：先停止接受新连接，配置了 drain_seconds 时分批断开已连接的代理，再关闭管理器
 func (s *WebSocketServer) Stop() error {
 	s.cancel()
 	
-	// 关闭WebSocket Manager
-	if s.wsManager != nil {
-		s.wsManager.Close()
-		log.Println("WebSocket manager closed")
-	}
-	
+	var shutdownErr error
 	if s.server != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		
+		// 已升级的WebSocket连接不受 Shutdown 影响
 		if err := s.server.Shutdown(ctx); err != nil {
 			log.Printf("Error shutting down WebSocket server: %v", err)
-			return err
+			shutdownErr = err
 		}
 	}
 	
+	// 关闭WebSocket Manager
+	if s.wsManager != nil {
+		if drain := s.config.DrainPeriod(); drain > 0 {
+			s.wsManager.DrainClients(drain)
+		}
+		s.wsManager.Close()
+		log.Println("WebSocket manager closed")
+	}
+	
+	if shutdownErr != nil {
+		return shutdownErr
+	}
 	log.Println("WebSocket server stopped")
 	return nil
 }
@@ -0,0 +1,52 @@
+// Package systemd 实现 sd_notify 协议，在 systemd 以 Type=notify 启动服务时报告就绪状态和喂看门狗，
+// 没有设置 NOTIFY_SOCKET 时（不是由 systemd 启动）所有调用都不做任何事
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// 常用的通知状态
+const (
+	Ready    = "READY=1"    // 服务已经可以处理请求
+	Stopping = "STOPPING=1" // 服务开始停止
+	Watchdog = "WATCHDOG=1" // 喂看门狗
+)
+
+// Notify 向 systemd 发送状态，没有设置 NOTIFY_SOCKET 时返回 false
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// 以 @ 开头的是抽象命名空间的套接字
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval 返回 systemd 配置的看门狗超时（WatchdogSec），没有启用或不是发给当前进程时返回0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
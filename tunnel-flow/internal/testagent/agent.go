@@ -0,0 +1,269 @@
+// Package testagent 进程内的测试代理，供服务端的集成测试使用
+//
+// 测试代理通过回环地址上的 WebSocket 连接服务端（通常是 httptest.Server 包装的 Manager.HandleWebSocket），
+// 实现代理协议的最小子集：注册、应答心跳、处理请求和取消、echo 请求。
+// 转发来的请求交给进程内的 http.Handler 处理，不访问真实的目标地址，
+// 测试可以覆盖 代理端口 → Manager → 代理 → 响应 的完整路径而不需要启动代理程序。
+// 不支持加密、签名、载荷压缩、分段、流式上传和可续传响应体，注册时也不声明这些能力。
+package testagent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"tunnel-flow/internal/protocol"
+)
+
+// Version 测试代理注册时上报的版本
+const Version = "test"
+
+// registerTimeout 等待注册确认的时间
+const registerTimeout = 5 * time.Second
+
+// Agent 进程内的测试代理
+type Agent struct {
+	clientID  string
+	authToken string
+	handler   http.Handler
+
+	conn    *websocket.Conn
+	writeMu sync.Mutex // gorilla/websocket 不允许并发写
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc // 处理中的请求，按消息ID取消
+
+	requests atomic.Int64
+	done     chan struct{}
+}
+
+// New 创建测试代理，handler 处理转发来的请求，为nil时所有请求返回404
+func New(clientID, authToken string, handler http.Handler) *Agent {
+	if handler == nil {
+		handler = http.NotFoundHandler()
+	}
+	return &Agent{
+		clientID:  clientID,
+		authToken: authToken,
+		handler:   handler,
+		cancels:   make(map[string]context.CancelFunc),
+		done:      make(chan struct{}),
+	}
+}
+
+// Connect 连接服务端的 WebSocket 地址并完成注册，serverURL 可以是 http:// 或 ws:// 地址
+// 注册被拒绝或超时时返回错误
+func (a *Agent) Connect(serverURL string) error {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	query := u.Query()
+	query.Set("client_id", a.clientID)
+	query.Set("token", a.authToken)
+	u.RawQuery = query.Encode()
+
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", serverURL, err)
+	}
+	a.conn = conn
+
+	if err := a.send(protocol.MessageTypeControl, protocol.OpRegister, nil, &protocol.RegisterPayload{
+		AuthToken:    a.authToken,
+		Version:      Version,
+		Capabilities: []string{protocol.CapabilityEcho},
+	}); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// 注册确认之前服务端不会发送其他消息
+	conn.SetReadDeadline(time.Now().Add(registerTimeout))
+	var ack protocol.Message
+	if err := conn.ReadJSON(&ack); err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to read register ack: %w", err)
+	}
+	var payload protocol.RegisterAckPayload
+	if err := ack.ParsePayload(&payload); err != nil || ack.Op != protocol.OpRegisterAck {
+		conn.Close()
+		return fmt.Errorf("unexpected message %s while registering", ack.Op)
+	}
+	if !payload.Success {
+		conn.Close()
+		return fmt.Errorf("registration rejected: %s", payload.Message)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	go a.readLoop()
+	return nil
+}
+
+// Close 断开连接并等待读循环结束
+func (a *Agent) Close() error {
+	if a.conn == nil {
+		return nil
+	}
+	err := a.conn.Close()
+	<-a.done
+	return err
+}
+
+// Done 连接断开时关闭
+func (a *Agent) Done() <-chan struct{} {
+	return a.done
+}
+
+// Requests 已处理的请求数，包括 echo 请求
+func (a *Agent) Requests() int64 {
+	return a.requests.Load()
+}
+
+// readLoop 读取服务端的消息直到连接断开
+func (a *Agent) readLoop() {
+	defer close(a.done)
+	defer a.cancelAll()
+
+	for {
+		var msg protocol.Message
+		if err := a.conn.ReadJSON(&msg); err != nil {
+			return
+		}
+		switch msg.Op {
+		case protocol.OpPing:
+			var ping protocol.PingPayload
+			msg.ParsePayload(&ping)
+			a.send(protocol.MessageTypeControl, protocol.OpPong, nil, &protocol.PongPayload{Timestamp: ping.Timestamp})
+		case protocol.OpRequest:
+			if msg.MsgID != nil {
+				go a.handleRequest(*msg.MsgID, &msg)
+			}
+		case protocol.OpCancel:
+			if msg.MsgID != nil {
+				a.cancel(*msg.MsgID)
+			}
+		}
+	}
+}
+
+// handleRequest 用 handler 处理一个请求并发送响应，请求被取消时不发送响应
+func (a *Agent) handleRequest(msgID string, msg *protocol.Message) {
+	a.requests.Add(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	a.mu.Lock()
+	a.cancels[msgID] = cancel
+	a.mu.Unlock()
+	defer a.cancel(msgID)
+
+	start := time.Now()
+	var payload protocol.RequestPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		reason := "invalid request payload"
+		a.respond(msgID, &protocol.ResponsePayload{HTTPStatus: http.StatusInternalServerError, Error: &reason})
+		return
+	}
+	if payload.Echo {
+		a.respond(msgID, &protocol.ResponsePayload{
+			HTTPStatus: http.StatusOK,
+			Headers:    map[string]string{"Content-Type": "application/octet-stream"},
+			Body:       payload.Body,
+		})
+		return
+	}
+
+	body := ""
+	switch value := payload.Body.(type) {
+	case string:
+		body = value
+	case nil:
+	default:
+		encoded, _ := json.Marshal(value)
+		body = string(encoded)
+	}
+	target := &url.URL{Path: payload.URLSuffix}
+	if len(payload.Params) > 0 {
+		query := url.Values{}
+		for name, value := range payload.Params {
+			query.Set(name, value)
+		}
+		target.RawQuery = query.Encode()
+	}
+	req := httptest.NewRequest(payload.HTTPMethod, target.String(), strings.NewReader(body)).WithContext(ctx)
+	for name, value := range payload.Headers {
+		req.Header.Set(name, value)
+	}
+
+	recorder := httptest.NewRecorder()
+	a.handler.ServeHTTP(recorder, req)
+	if ctx.Err() != nil {
+		return
+	}
+
+	result := recorder.Result()
+	headers := make(map[string]string, len(result.Header))
+	for name, values := range result.Header {
+		headers[name] = values[0]
+	}
+	a.respond(msgID, &protocol.ResponsePayload{
+		HTTPStatus:   result.StatusCode,
+		Headers:      headers,
+		HeaderValues: result.Header,
+		Body:         recorder.Body.String(),
+		LatencyMS:    time.Since(start).Milliseconds(),
+	})
+}
+
+// respond 发送请求的响应
+func (a *Agent) respond(msgID string, response *protocol.ResponsePayload) {
+	if response.Headers == nil {
+		response.Headers = map[string]string{}
+	}
+	a.send(protocol.MessageTypeMessage, protocol.OpResponse, &msgID, response)
+}
+
+// cancel 取消并移除处理中的请求
+func (a *Agent) cancel(msgID string) {
+	a.mu.Lock()
+	cancel := a.cancels[msgID]
+	delete(a.cancels, msgID)
+	a.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+// cancelAll 连接断开时取消所有处理中的请求
+func (a *Agent) cancelAll() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for msgID, cancel := range a.cancels {
+		cancel()
+		delete(a.cancels, msgID)
+	}
+}
+
+// send 发送一条消息
+func (a *Agent) send(msgType protocol.MessageType, op protocol.Operation, msgID *string, payload interface{}) error {
+	msg, err := protocol.NewMessage(msgType, op, a.clientID, msgID, payload)
+	if err != nil {
+		return err
+	}
+	a.writeMu.Lock()
+	defer a.writeMu.Unlock()
+	return a.conn.WriteJSON(msg)
+}
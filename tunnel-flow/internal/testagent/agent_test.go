@@ -0,0 +1,112 @@
+package testagent
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tunnel-flow/internal/config"
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/monitoring"
+	"tunnel-flow/internal/performance"
+	"tunnel-flow/internal/proxy"
+	"tunnel-flow/internal/websocket"
+)
+
+// newTestServer 启动内存中的 Manager 和代理处理器，返回 WebSocket 和代理端口的测试服务器
+func newTestServer(t *testing.T) (*database.Repository, *httptest.Server, *httptest.Server) {
+	t.Helper()
+
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("database.New() error = %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	repo := database.NewRepository(db)
+
+	workerPool := performance.NewWorkerPoolWithConfig(&performance.WorkerPoolConfig{MinWorkers: 1, MaxWorkers: 2, QueueSize: 16})
+	// 工作池不停止：测试结束时断开的连接仍在后台注销，会继续提交任务
+	workerPool.Start()
+	manager := websocket.NewManager(cfg, repo, performance.NewObjectPool(), workerPool, nil)
+	t.Cleanup(manager.Close)
+	handler := proxy.NewHandler(cfg, repo, manager, monitoring.NewTrafficStats(), nil, nil, nil)
+
+	wsServer := httptest.NewServer(http.HandlerFunc(manager.HandleWebSocket))
+	t.Cleanup(wsServer.Close)
+	proxyServer := httptest.NewServer(http.HandlerFunc(handler.HandleDirectProxyRequest))
+	t.Cleanup(proxyServer.Close)
+	return repo, wsServer, proxyServer
+}
+
+func TestAgentServesProxiedRequests(t *testing.T) {
+	repo, wsServer, proxyServer := newTestServer(t)
+
+	client := &database.Client{ClientID: "test-client", Name: "test", AuthToken: "test-token"}
+	if err := repo.CreateClient(client); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+	route := &database.ServerRoute{URLSuffix: "/hello", ClientID: client.ClientID, TargetsJSON: "http://backend.invalid", Enabled: 1}
+	if err := repo.CreateServerRoute(route); err != nil {
+		t.Fatalf("CreateServerRoute() error = %v", err)
+	}
+
+	agent := New(client.ClientID, client.AuthToken, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Test-Method", r.Method)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello " + string(body)))
+	}))
+	if err := agent.Connect(wsServer.URL); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	defer agent.Close()
+
+	resp, err := http.Post(proxyServer.URL+"/hello", "text/plain", strings.NewReader("tunnel"))
+	if err != nil {
+		t.Fatalf("POST /hello error = %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusCreated)
+	}
+	if got := resp.Header.Get("X-Test-Method"); got != http.MethodPost {
+		t.Errorf("X-Test-Method = %q, want %q", got, http.MethodPost)
+	}
+	if string(body) != "hello tunnel" {
+		t.Errorf("body = %q, want %q", body, "hello tunnel")
+	}
+	if agent.Requests() != 1 {
+		t.Errorf("Requests() = %d, want 1", agent.Requests())
+	}
+}
+
+func TestAgentRejectsInvalidToken(t *testing.T) {
+	repo, wsServer, _ := newTestServer(t)
+
+	if err := repo.CreateClient(&database.Client{ClientID: "test-client", Name: "test", AuthToken: "test-token"}); err != nil {
+		t.Fatalf("CreateClient() error = %v", err)
+	}
+
+	agent := New("test-client", "wrong-token", nil)
+	done := make(chan error, 1)
+	go func() { done <- agent.Connect(wsServer.URL) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			agent.Close()
+			t.Fatal("Connect() with an invalid token succeeded")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Connect() with an invalid token did not return")
+	}
+}
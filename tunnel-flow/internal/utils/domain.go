@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NormalizeDomain 校验并规范化预留的域名，可以是 app.example.com 形式的完整域名，
+// 也可以是 *.example.com 形式的通配符，表示该域名下的所有子域名
+func NormalizeDomain(domain string) (string, error) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" {
+		return "", fmt.Errorf("domain must not be empty")
+	}
+	name := strings.TrimPrefix(domain, "*.")
+	if len(name) > 253 || !strings.Contains(name, ".") || !hostnamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid domain %q", domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) > 63 {
+			return "", fmt.Errorf("invalid domain %q", domain)
+		}
+	}
+	return domain, nil
+}
+
+// DomainCovers 判断域名规则是否包含指定域名，两者都应是规范化后的域名
+// 通配符只包含子域名，*.example.com 包含 a.example.com 和 *.a.example.com，不包含 example.com
+func DomainCovers(pattern, domain string) bool {
+	if pattern == domain {
+		return true
+	}
+	if base := strings.TrimPrefix(pattern, "*."); base != pattern {
+		return strings.HasSuffix(domain, "."+base)
+	}
+	return false
+}
+
+// DomainsOverlap 判断两条域名规则是否存在同时匹配的域名
+func DomainsOverlap(a, b string) bool {
+	return DomainCovers(a, b) || DomainCovers(b, a)
+}
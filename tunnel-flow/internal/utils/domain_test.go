@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestNormalizeDomain(t *testing.T) {
+	tests := []struct {
+		domain  string
+		want    string
+		wantErr bool
+		desc    string
+	}{
+		{"app.example.com", "app.example.com", false, "完整域名"},
+		{" App.Example.COM. ", "app.example.com", false, "转为小写并去掉末尾的点"},
+		{"*.example.com", "*.example.com", false, "通配符"},
+		{"", "", true, "空值"},
+		{"localhost", "", true, "没有点的主机名"},
+		{"*.com", "", true, "通配符只有一级"},
+		{"a.*.example.com", "", true, "通配符不在开头"},
+		{"app.example.com:443", "", true, "包含端口"},
+		{"-app.example.com", "", true, "以连字符开头"},
+		{"http://app.example.com", "", true, "不接受URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := NormalizeDomain(tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeDomain(%q) error = %v, wantErr %v", tt.domain, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeDomain(%q) = %q, want %q", tt.domain, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDomainCovers(t *testing.T) {
+	tests := []struct {
+		pattern string
+		domain  string
+		want    bool
+		desc    string
+	}{
+		{"app.example.com", "app.example.com", true, "完全相同"},
+		{"app.example.com", "api.example.com", false, "不同域名"},
+		{"*.example.com", "app.example.com", true, "通配符包含子域名"},
+		{"*.example.com", "a.b.example.com", true, "通配符包含多级子域名"},
+		{"*.example.com", "*.app.example.com", true, "通配符包含更具体的通配符"},
+		{"*.example.com", "example.com", false, "通配符不包含域名本身"},
+		{"*.example.com", "badexample.com", false, "只按完整标签匹配"},
+		{"app.example.com", "*.example.com", false, "完整域名不包含通配符"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			if got := DomainCovers(tt.pattern, tt.domain); got != tt.want {
+				t.Errorf("DomainCovers(%q, %q) = %v, want %v", tt.pattern, tt.domain, got, tt.want)
+			}
+		})
+	}
+}
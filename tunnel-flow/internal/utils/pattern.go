@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"regexp"
 	"strings"
+	"sync"
 )
 
 // MatchPattern 检查URL路径是否匹配给定的模式
@@ -279,4 +281,28 @@ func NormalizePattern(pattern string) string {
 	}
 	
 	return pattern
-}
\ No newline at end of file
+}
+// valuePatterns 已编译的值匹配正则缓存，路由条件在每次请求时都会用到
+var valuePatterns sync.Map
+
+// CompileValuePattern 编译值匹配正则，正则需要完整匹配整个值
+func CompileValuePattern(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := valuePatterns.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	valuePatterns.Store(pattern, re)
+	return re, nil
+}
+
+// MatchValuePattern 检查值是否完整匹配正则，正则无效时视为不匹配
+func MatchValuePattern(pattern, value string) bool {
+	re, err := CompileValuePattern(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(value)
+}
@@ -132,4 +132,30 @@ func TestNormalizePattern(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestMatchValuePattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+		desc    string
+	}{
+		{"2", "2", true, "精确匹配"},
+		{"2", "12", false, "需要完整匹配"},
+		{"2|3", "3", true, "分支匹配"},
+		{"2|3", "23", false, "分支也需要完整匹配"},
+		{"v[0-9]+", "v10", true, "字符类匹配"},
+		{"beta.*", "beta-1", true, "前缀匹配"},
+		{"(", "(", false, "无效正则视为不匹配"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := MatchValuePattern(tt.pattern, tt.value)
+			if got != tt.want {
+				t.Errorf("MatchValuePattern(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader 请求ID头
+const RequestIDHeader = "X-Request-ID"
+
+// 稳定的错误码，前端和调用方可以依赖这些值做判断
+const (
+	ErrCodeBadRequest       = "BAD_REQUEST"
+	ErrCodeInvalidJSON      = "INVALID_JSON"
+	ErrCodeValidation       = "VALIDATION_FAILED"
+	ErrCodeUnauthorized     = "UNAUTHORIZED"
+	ErrCodeForbidden        = "FORBIDDEN"
+	ErrCodeNotFound         = "NOT_FOUND"
+	ErrCodeMethodNotAllowed = "METHOD_NOT_ALLOWED"
+	ErrCodeConflict         = "CONFLICT"
+	ErrCodePrecondition     = "PRECONDITION_FAILED"
+	ErrCodeInternal         = "INTERNAL_ERROR"
+	ErrCodeRouteNotFound    = "ROUTE_NOT_FOUND"
+	ErrCodeNoBackend        = "NO_AVAILABLE_BACKEND"
+	ErrCodeBadGateway       = "BACKEND_REQUEST_FAILED"
+	ErrCodeRequestQuota     = "REQUEST_QUOTA_EXCEEDED"
+	ErrCodeTrafficQuota     = "TRAFFIC_QUOTA_EXCEEDED"
+	ErrCodeReadOnly         = "READ_ONLY_MODE"
+	ErrCodeIdempotency      = "IDEMPOTENCY_KEY_MISMATCH"
+	ErrCodeFaultInjected    = "FAULT_INJECTED"
+)
+
+// ErrorBody 错误详情
+type ErrorBody struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ErrorResponse 统一的JSON错误响应
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// RequestIDMiddleware 为每个请求分配请求ID，优先沿用调用方传入的ID
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" || len(requestID) > 128 {
+			requestID = uuid.New().String()
+			r.Header.Set(RequestIDHeader, requestID)
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetRequestID 获取当前请求的请求ID，没有时生成一个新的并写入响应头
+func GetRequestID(w http.ResponseWriter, r *http.Request) string {
+	if requestID := w.Header().Get(RequestIDHeader); requestID != "" {
+		return requestID
+	}
+	requestID := ""
+	if r != nil {
+		requestID = r.Header.Get(RequestIDHeader)
+	}
+	if requestID == "" || len(requestID) > 128 {
+		requestID = uuid.New().String()
+	}
+	w.Header().Set(RequestIDHeader, requestID)
+	return requestID
+}
+
+// WriteError 写入JSON格式的错误响应
+// message 会直接返回给调用方，不能包含数据库错误等内部信息
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	requestID := GetRequestID(w, r)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   message,
+			RequestID: requestID,
+		},
+	})
+}
+
+// WriteInternalError 记录内部错误并返回不含细节的500响应
+func WriteInternalError(w http.ResponseWriter, r *http.Request, err error) {
+	requestID := GetRequestID(w, r)
+	if r != nil {
+		log.Printf("[%s] Internal error on %s %s: %v", requestID, r.Method, r.URL.Path, err)
+	} else {
+		log.Printf("[%s] Internal error: %v", requestID, err)
+	}
+	WriteError(w, r, http.StatusInternalServerError, ErrCodeInternal, "Internal server error")
+}
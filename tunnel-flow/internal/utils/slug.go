@@ -0,0 +1,20 @@
+package utils
+
+// IsValidSlug 检查标识是否有效：2-63位小写字母、数字和连字符，首尾必须是字母或数字
+// 标识会出现在URL路径中，因此不允许大写和其他字符
+func IsValidSlug(slug string) bool {
+	if len(slug) < 2 || len(slug) > 63 {
+		return false
+	}
+
+	for i := 0; i < len(slug); i++ {
+		c := slug[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case c == '-' && i > 0 && i < len(slug)-1:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsValidSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+		desc string
+	}{
+		{"acme", true, "小写字母"},
+		{"team-42", true, "包含连字符和数字"},
+		{"42", true, "纯数字"},
+		{"a", false, "长度不足"},
+		{strings.Repeat("a", 63), true, "最大长度"},
+		{strings.Repeat("a", 64), false, "超过最大长度"},
+		{"Acme", false, "包含大写字母"},
+		{"-acme", false, "以连字符开头"},
+		{"acme-", false, "以连字符结尾"},
+		{"ac_me", false, "包含下划线"},
+		{"ac/me", false, "包含斜杠"},
+		{"", false, "空标识"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := IsValidSlug(tt.slug)
+			if got != tt.want {
+				t.Errorf("IsValidSlug(%q) = %v, want %v", tt.slug, got, tt.want)
+			}
+		})
+	}
+}
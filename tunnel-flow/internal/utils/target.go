@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// hostnamePattern 白名单中允许的主机名
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?(\.[a-z0-9_]([a-z0-9_-]*[a-z0-9_])?)*$`)
+
+// NormalizeTargetPattern 校验并规范化目标白名单中的一项，格式为 主机[:端口] 或 unix:套接字路径
+// 主机可以是主机名、*.example.com 形式的域名后缀、IP、CIDR 或表示任意主机的 *；
+// 没有端口或端口为 * 时不限制端口，IPv6 地址带端口时需要用方括号括起来
+func NormalizeTargetPattern(pattern string) (string, error) {
+	pattern = strings.TrimSpace(pattern)
+	if len(pattern) >= len(unixTargetPrefix) && strings.EqualFold(pattern[:len(unixTargetPrefix)], unixTargetPrefix) {
+		return normalizeUnixTarget(pattern[len(unixTargetPrefix):])
+	}
+	pattern = strings.ToLower(pattern)
+	if pattern == "" {
+		return "", fmt.Errorf("target must not be empty")
+	}
+
+	host, port := pattern, ""
+	if h, p, err := net.SplitHostPort(pattern); err == nil {
+		host, port = h, p
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(pattern, "["), "]")
+	}
+	if port == "*" {
+		port = ""
+	}
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return "", fmt.Errorf("invalid port in target %q", pattern)
+		}
+	}
+
+	switch {
+	case host == "*":
+	case strings.Contains(host, "/"):
+		_, network, err := net.ParseCIDR(host)
+		if err != nil {
+			return "", fmt.Errorf("invalid CIDR in target %q", pattern)
+		}
+		host = network.String()
+	case net.ParseIP(host) != nil:
+		host = net.ParseIP(host).String()
+	case hostnamePattern.MatchString(strings.TrimPrefix(host, "*.")):
+	default:
+		return "", fmt.Errorf("invalid host in target %q", pattern)
+	}
+
+	if port == "" {
+		return host, nil
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// unixTargetPrefix 白名单中unix套接字目标的前缀
+const unixTargetPrefix = "unix:"
+
+// normalizeUnixTarget 规范化unix套接字路径，必须是绝对路径，接受 unix:///path 形式，路径区分大小写
+func normalizeUnixTarget(socketPath string) (string, error) {
+	socketPath = strings.TrimPrefix(socketPath, "//")
+	if !strings.HasPrefix(socketPath, "/") {
+		return "", fmt.Errorf("unix socket target must be an absolute path")
+	}
+	return unixTargetPrefix + path.Clean(socketPath), nil
+}
@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestNormalizeTargetPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+		desc    string
+	}{
+		{"api.internal", "api.internal", false, "主机名"},
+		{" API.Internal:8080 ", "api.internal:8080", false, "转为小写并去掉空白"},
+		{"*.corp.example.com:443", "*.corp.example.com:443", false, "域名后缀"},
+		{"10.0.0.5:*", "10.0.0.5", false, "端口为*表示不限制"},
+		{"10.1.2.3/8", "10.0.0.0/8", false, "CIDR规范化为网络地址"},
+		{"192.168.0.0/16:443", "192.168.0.0/16:443", false, "CIDR带端口"},
+		{"::1", "::1", false, "IPv6地址"},
+		{"[fd00::/8]:443", "[fd00::/8]:443", false, "IPv6 CIDR带端口"},
+		{"*:443", "*:443", false, "任意主机的指定端口"},
+		{"", "", true, "空值"},
+		{"host:0", "", true, "端口超出范围"},
+		{"host:http", "", true, "端口不是数字"},
+		{"bad host", "", true, "主机名包含空格"},
+		{"10.0.0.0/33", "", true, "无效的CIDR"},
+		{"http://api.internal", "", true, "不接受URL"},
+		{"unix:/var/run/App.sock", "unix:/var/run/App.sock", false, "unix套接字路径区分大小写"},
+		{"UNIX:///var/run/../run/app.sock", "unix:/var/run/app.sock", false, "unix:///形式并规范化路径"},
+		{"unix:app.sock", "", true, "unix套接字必须是绝对路径"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got, err := NormalizeTargetPattern(tt.pattern)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NormalizeTargetPattern(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NormalizeTargetPattern(%q) = %q, want %q", tt.pattern, got, tt.want)
+			}
+		})
+	}
+}
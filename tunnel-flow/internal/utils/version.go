@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CompareVersions 比较两个点分版本号，a<b 返回-1，a==b 返回0，a>b 返回1
+// 支持可选的 v 前缀，预发布/构建后缀（-rc1、+build）会被忽略，缺失的段按0处理
+func CompareVersions(a, b string) int {
+	pa := parseVersion(a)
+	pb := parseVersion(b)
+
+	n := len(pa)
+	if len(pb) > n {
+		n = len(pb)
+	}
+	for i := 0; i < n; i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// IsVersionOlder 判断版本 v 是否低于 min，min 为空时不做限制
+func IsVersionOlder(v, min string) bool {
+	if strings.TrimSpace(min) == "" {
+		return false
+	}
+	return CompareVersions(v, min) < 0
+}
+
+// 代理与服务端的版本偏差
+const (
+	VersionSkewOutdated = "outdated" // 低于配置的最低代理版本
+	VersionSkewOlder    = "older"    // 低于服务端版本
+	VersionSkewNewer    = "newer"    // 高于服务端版本
+)
+
+// VersionSkew 比较代理与服务端的版本，版本一致或代理未上报版本时返回空
+func VersionSkew(agentVersion, serverVersion, minVersion string) string {
+	if strings.TrimSpace(agentVersion) == "" {
+		return ""
+	}
+	if IsVersionOlder(agentVersion, minVersion) {
+		return VersionSkewOutdated
+	}
+	switch CompareVersions(agentVersion, serverVersion) {
+	case -1:
+		return VersionSkewOlder
+	case 1:
+		return VersionSkewNewer
+	}
+	return ""
+}
+
+// VersionSkewWarning 版本偏差的说明，没有偏差时返回空
+func VersionSkewWarning(skew, agentVersion, serverVersion, minVersion string) string {
+	switch skew {
+	case VersionSkewOutdated:
+		return fmt.Sprintf("Agent version %s is older than the minimum supported version %s; please upgrade", agentVersion, minVersion)
+	case VersionSkewOlder:
+		return fmt.Sprintf("Agent version %s is older than server version %s", agentVersion, serverVersion)
+	case VersionSkewNewer:
+		return fmt.Sprintf("Agent version %s is newer than server version %s; some features may not be supported", agentVersion, serverVersion)
+	}
+	return ""
+}
+
+// parseVersion 将版本号解析为数字段
+func parseVersion(v string) []int {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(strings.TrimPrefix(v, "v"), "V")
+	if i := strings.IndexAny(v, "-+ "); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil
+	}
+
+	parts := strings.Split(v, ".")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			n = 0
+		}
+		nums[i] = n
+	}
+	return nums
+}
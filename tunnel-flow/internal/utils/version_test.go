@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a    string
+		b    string
+		want int
+		desc string
+	}{
+		{"1.0.0", "1.0.0", 0, "版本相同"},
+		{"1.0.0", "1.0.1", -1, "补丁版本较低"},
+		{"1.2.0", "1.10.0", -1, "按数字而非字符串比较"},
+		{"2.0", "1.9.9", 1, "主版本较高"},
+		{"1.0", "1.0.0", 0, "缺失的段按0处理"},
+		{"v1.2.3", "1.2.3", 0, "忽略v前缀"},
+		{"1.2.3-rc1", "1.2.3", 0, "忽略预发布后缀"},
+		{"", "1.0.0", -1, "空版本视为最低"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := CompareVersions(tt.a, tt.b)
+			if got != tt.want {
+				t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsVersionOlder(t *testing.T) {
+	tests := []struct {
+		v    string
+		min  string
+		want bool
+		desc string
+	}{
+		{"1.0.0", "", false, "未配置最低版本"},
+		{"1.0.0", "1.1.0", true, "低于最低版本"},
+		{"1.1.0", "1.1.0", false, "等于最低版本"},
+		{"", "1.0.0", true, "未上报版本"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := IsVersionOlder(tt.v, tt.min)
+			if got != tt.want {
+				t.Errorf("IsVersionOlder(%q, %q) = %v, want %v", tt.v, tt.min, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionSkew(t *testing.T) {
+	tests := []struct {
+		agent  string
+		server string
+		min    string
+		want   string
+		desc   string
+	}{
+		{"1.0.0", "1.0.0", "", "", "版本一致"},
+		{"", "1.0.0", "1.0.0", "", "未上报版本"},
+		{"0.9.0", "1.0.0", "", VersionSkewOlder, "低于服务端版本"},
+		{"1.1.0", "1.0.0", "", VersionSkewNewer, "高于服务端版本"},
+		{"0.9.0", "1.0.0", "0.9.5", VersionSkewOutdated, "低于最低版本"},
+		{"1.1.0", "1.0.0", "1.2.0", VersionSkewOutdated, "最低版本优先于服务端版本"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			got := VersionSkew(tt.agent, tt.server, tt.min)
+			if got != tt.want {
+				t.Errorf("VersionSkew(%q, %q, %q) = %q, want %q", tt.agent, tt.server, tt.min, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+// Package version 服务端的构建信息，构建时通过 -ldflags 注入：
+//
+//	go build -ldflags "-X tunnel-flow/internal/version.Version=1.2.0 \
+//	  -X tunnel-flow/internal/version.GitCommit=$(git rev-parse --short HEAD) \
+//	  -X tunnel-flow/internal/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import "runtime"
+
+var (
+	Version   = "1.0.0"
+	GitCommit = "unknown"
+	BuildDate = "unknown"
+)
+
+// Info 构建信息
+type Info struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Get 返回当前程序的构建信息
+func Get() Info {
+	return Info{
+		Version:   Version,
+		GitCommit: GitCommit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"time"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// maxTargetViolations 每个客户端保留的最近违规记录数
+const maxTargetViolations = 50
+
+// TargetViolation 代理上报的一次白名单违规
+type TargetViolation struct {
+	protocol.TargetViolationPayload
+	ReceivedAt int64 `json:"received_at"` // 服务端收到上报的时间（毫秒）
+}
+
+// handleTargetViolation 处理代理拒绝白名单之外目标的上报，只在内存中保留最近的记录
+func (m *Manager) handleTargetViolation(client *ClientConn, msg *protocol.Message) {
+	var payload protocol.TargetViolationPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		wsLog.Errorf("Failed to parse target violation from client %s: %v", client.clientID, err)
+		return
+	}
+	wsLog.Warnf("SECURITY: client %s refused target outside its allowlist: %s %s (path %s)",
+		client.clientID, payload.Method, payload.URL, payload.URLSuffix)
+
+	m.violationsMu.Lock()
+	defer m.violationsMu.Unlock()
+	violations := append(m.violations[client.clientID], TargetViolation{TargetViolationPayload: payload, ReceivedAt: time.Now().UnixMilli()})
+	if len(violations) > maxTargetViolations {
+		violations = violations[len(violations)-maxTargetViolations:]
+	}
+	m.violations[client.clientID] = violations
+}
+
+// TargetViolations 返回客户端最近上报的白名单违规，最新的在最后
+func (m *Manager) TargetViolations(clientID string) []TargetViolation {
+	m.violationsMu.RLock()
+	defer m.violationsMu.RUnlock()
+	return append([]TargetViolation{}, m.violations[clientID]...)
+}
+
+// PushAllowedTargets 把修改后的目标白名单下发给在线的客户端，客户端不在线时在下次注册时下发
+func (m *Manager) PushAllowedTargets(clientID string, allowedTargets []string) error {
+	if m.getClient(clientID) == nil {
+		return nil
+	}
+	if allowedTargets == nil {
+		allowedTargets = []string{}
+	}
+	msg, err := protocol.NewMessage(protocol.MessageTypeControl, protocol.OpTargetAllowlist, clientID, nil,
+		&protocol.TargetAllowlistPayload{AllowedTargets: allowedTargets})
+	if err != nil {
+		return err
+	}
+	return m.SendToClient(clientID, msg)
+}
@@ -0,0 +1,36 @@
+package websocket
+
+import (
+	"tunnel-flow/internal/protocol"
+)
+
+// defaultCompression 注册确认中下发给代理的默认载荷压缩设置
+func (m *Manager) defaultCompression() *protocol.CompressionSettings {
+	return &protocol.CompressionSettings{
+		Algorithm: m.config.CompressionAlgorithm,
+		MinBytes:  m.config.CompressionMinBytes,
+	}
+}
+
+// setCompression 启用连接的载荷压缩，在注册确认放入发送队列之后调用，保证代理先收到压缩设置
+func (c *ClientConn) setCompression(settings *protocol.CompressionSettings) {
+	c.mu.Lock()
+	c.compression = settings
+	c.mu.Unlock()
+}
+
+// getCompression 连接的默认载荷压缩设置，代理不支持压缩时为nil
+func (c *ClientConn) getCompression() *protocol.CompressionSettings {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.compression
+}
+
+// compressMessage 代理支持压缩时按消息指定的设置（如路由的覆盖设置）或连接的默认设置压缩载荷
+func (m *Manager) compressMessage(client *ClientConn, msg *protocol.Message) (*protocol.Message, error) {
+	defaults := client.getCompression()
+	if defaults == nil {
+		return msg, nil
+	}
+	return msg.Compress(msg.Compression(defaults))
+}
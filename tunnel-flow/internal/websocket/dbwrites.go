@@ -0,0 +1,107 @@
+package websocket
+
+import (
+	"encoding/json"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/performance"
+)
+
+// 异步数据库写入：心跳时间、审计日志和待处理消息的状态变化放入消息队列，
+// 由批处理器按 batch_size/batch_timeout_ms 合并后在一个事务中写入，突发的写入不再逐条占用数据库
+// 队列关闭或已满时直接写入，不丢失状态变化；心跳时间优先级最低，队列满时最先丢弃
+
+const (
+	writeLastSeen = "last_seen"
+	writeAudit    = "audit"
+	writeMessage  = "message"
+)
+
+// asyncWrite 放入消息队列的一次写入
+type asyncWrite struct {
+	Kind     string                  `json:"kind"`
+	ClientID string                  `json:"client_id,omitempty"`
+	At       int64                   `json:"at,omitempty"` // 最后活跃时间，毫秒
+	Audit    *database.AuditLog      `json:"audit,omitempty"`
+	Update   *database.MessageUpdate `json:"update,omitempty"`
+}
+
+// recordLastSeen 异步更新客户端最后活跃时间
+func (m *Manager) recordLastSeen(clientID string, at time.Time) {
+	m.enqueueWrite(clientID, performance.PriorityLow, &asyncWrite{Kind: writeLastSeen, ClientID: clientID, At: at.UnixMilli()})
+}
+
+// recordAudit 异步写入一条审计日志
+func (m *Manager) recordAudit(msgID, clientID, direction, summary string) {
+	m.enqueueWrite(msgID, performance.PriorityNormal, &asyncWrite{Kind: writeAudit, Audit: &database.AuditLog{
+		MsgID:          msgID,
+		ClientID:       clientID,
+		Direction:      direction,
+		PayloadSummary: summary,
+		TS:             time.Now().UnixMilli(),
+	}})
+}
+
+// updateMessage 异步更新待处理消息的状态，同一消息的变化按发生顺序写入
+func (m *Manager) updateMessage(update *database.MessageUpdate) {
+	m.enqueueWrite(update.MsgID, performance.PriorityNormal, &asyncWrite{Kind: writeMessage, Update: update})
+}
+
+// failMessage 异步把消息标记为失败或取消并记录原因
+func (m *Manager) failMessage(msgID, state, reason string) {
+	m.updateMessage(&database.MessageUpdate{MsgID: msgID, State: state, LastError: reason})
+}
+
+// enqueueWrite 把写入放入消息队列，放不进去时直接写入（心跳时间除外）
+func (m *Manager) enqueueWrite(id string, priority performance.Priority, write *asyncWrite) {
+	data, err := json.Marshal(write)
+	if err == nil {
+		err = m.messageQueue.Enqueue(&performance.QueueMessage{ID: id, Data: data, Priority: priority})
+	}
+	if err == nil {
+		return
+	}
+	if write.Kind == writeLastSeen {
+		heartbeatLog.Warnf("Dropping last seen update for client %s: %v", write.ClientID, err)
+		return
+	}
+	wsLog.Warnf("Writing %s update %s directly: %v", write.Kind, id, err)
+	m.applyWrites([]*asyncWrite{write})
+}
+
+// processBatch 解码批处理器取出的一批写入并合并执行
+func (m *Manager) processBatch(messages []*performance.QueueMessage) error {
+	writes := make([]*asyncWrite, 0, len(messages))
+	for _, msg := range messages {
+		var write asyncWrite
+		if err := json.Unmarshal(msg.Data, &write); err != nil {
+			wsLog.Errorf("Dropping undecodable batch message %s: %v", msg.ID, err)
+			continue
+		}
+		writes = append(writes, &write)
+	}
+	return m.applyWrites(writes)
+}
+
+// applyWrites 合并写入并在一个事务中执行，同一客户端的最后活跃时间只保留最新的
+func (m *Manager) applyWrites(writes []*asyncWrite) error {
+	batch := &database.WriteBatch{LastSeen: make(map[string]int64)}
+	for _, write := range writes {
+		switch write.Kind {
+		case writeLastSeen:
+			if write.At > batch.LastSeen[write.ClientID] {
+				batch.LastSeen[write.ClientID] = write.At
+			}
+		case writeAudit:
+			batch.AuditLogs = append(batch.AuditLogs, write.Audit)
+		case writeMessage:
+			batch.MessageUpdates = append(batch.MessageUpdates, write.Update)
+		}
+	}
+	if err := m.db.ApplyWriteBatch(batch); err != nil {
+		wsLog.Errorf("Failed to apply batch of %d writes: %v", len(writes), err)
+		return err
+	}
+	return nil
+}
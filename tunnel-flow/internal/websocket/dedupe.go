@@ -0,0 +1,41 @@
+package websocket
+
+import "time"
+
+// responseDedupWindow 记录已收到响应的消息ID的时间，窗口内再次收到同一消息ID的响应视为重复
+// 代理重试发送响应或重连后补发的响应可能已经送达过一次
+const responseDedupWindow = 5 * time.Minute
+
+// completeResponse 记录收到了该消息的响应，窗口内已经收到过时返回false
+func (m *Manager) completeResponse(msgID string) bool {
+	now := time.Now()
+	m.completedMu.Lock()
+	defer m.completedMu.Unlock()
+	if at, exists := m.completed[msgID]; exists && now.Sub(at) < responseDedupWindow {
+		return false
+	}
+	m.completed[msgID] = now
+	return true
+}
+
+// dropDuplicateResponse 丢弃重复的响应，只计数不再通知等待的请求和更新待处理消息
+func (m *Manager) dropDuplicateResponse(client *ClientConn, msgID string) {
+	wsLog.Warnf("Dropped duplicate response for message %s from client %s", msgID, client.clientID)
+	if m.metrics != nil {
+		if collector, ok := m.metrics.(interface{ IncrementDuplicateResponses() }); ok {
+			collector.IncrementDuplicateResponses()
+		}
+	}
+}
+
+// pruneCompleted 清理超过去重窗口的消息ID
+func (m *Manager) pruneCompleted() {
+	now := time.Now()
+	m.completedMu.Lock()
+	defer m.completedMu.Unlock()
+	for msgID, at := range m.completed {
+		if now.Sub(at) >= responseDedupWindow {
+			delete(m.completed, msgID)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package websocket
+
+import (
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// negotiateEncryption 用代理注册时上报的公钥派生该连接的密钥，返回服务端的公钥和密钥
+func (m *Manager) negotiateEncryption(client *ClientConn, agentKey string) (string, cipher.AEAD, error) {
+	key, publicKey, err := protocol.NewEncryptionKey()
+	if err != nil {
+		return "", nil, err
+	}
+	aead, err := protocol.DeriveSessionCipher(key, agentKey, client.clientID, client.authToken)
+	if err != nil {
+		return "", nil, err
+	}
+	return publicKey, aead, nil
+}
+
+// setSessionCipher 启用连接的载荷加密，在注册确认放入发送队列之后调用，保证代理先收到服务端的公钥
+func (c *ClientConn) setSessionCipher(aead cipher.AEAD) {
+	c.mu.Lock()
+	c.sessionCipher = aead
+	c.mu.Unlock()
+}
+
+// getSessionCipher 连接的载荷密钥，代理未启用加密时为nil
+func (c *ClientConn) getSessionCipher() cipher.AEAD {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sessionCipher
+}
+
+// encodeMessage 序列化发给代理的消息，依次压缩载荷、连接启用加密时加密载荷，启用签名时对加密后的消息签名
+func (m *Manager) encodeMessage(client *ClientConn, msg *protocol.Message) ([]byte, error) {
+	msg, err := m.compressMessage(client, msg)
+	if err != nil {
+		return nil, err
+	}
+	if aead := client.getSessionCipher(); aead != nil && !protocol.EncryptionExempt(msg.Op) {
+		sealed, err := msg.Seal(aead)
+		if err != nil {
+			return nil, err
+		}
+		msg = sealed
+	}
+	if client.signingEnabled() {
+		signed, err := msg.Sign(client.authToken)
+		if err != nil {
+			return nil, err
+		}
+		msg = signed
+	}
+	return json.Marshal(msg)
+}
+
+// ClientEncrypted 客户端当前的连接是否加密消息载荷
+func (m *Manager) ClientEncrypted(clientID string) bool {
+	client := m.getClient(clientID)
+	return client != nil && client.getSessionCipher() != nil
+}
+
+// verifyConnectProof 校验启用加密的代理建立连接时代替认证Token发送的证明，证明有效后记录随机数，跨连接拒绝重放
+func (m *Manager) verifyConnectProof(r *http.Request, authToken, clientID string) error {
+	query := r.URL.Query()
+	signedAt, err := strconv.ParseInt(query.Get("signed_at"), 10, 64)
+	if err != nil {
+		return errors.New("invalid signed_at")
+	}
+	nonce := query.Get("nonce")
+	if !protocol.VerifyConnectProof(authToken, clientID, signedAt, nonce, query.Get("proof")) {
+		return errors.New("proof does not match the auth token")
+	}
+	return m.connectNonces.Check(nonce, signedAt, time.Now())
+}
@@ -0,0 +1,182 @@
+package websocket
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// fragmentTimeout 超过该时间没有收到新分段的消息丢弃
+const fragmentTimeout = 2 * time.Minute
+
+// negotiateMaxMessageBytes 计算与代理之间的最大消息大小，代理不支持分段时为0（不拆分）
+// 代理要求的值比服务端配置小时使用代理的值
+func (m *Manager) negotiateMaxMessageBytes(client *ClientConn, requested int) int {
+	if !client.hasCapability(protocol.CapabilityFragmentation) {
+		return 0
+	}
+	limit := m.config.WebSocketMaxMessageBytes
+	if requested > 0 {
+		requested = max(requested, protocol.MinMaxMessageBytes)
+		if limit == 0 || requested < limit {
+			limit = requested
+		}
+	}
+	return limit
+}
+
+// setMaxMessageBytes 设置连接的最大消息大小，在注册确认放入发送队列之后调用，保证代理先收到该设置
+func (c *ClientConn) setMaxMessageBytes(limit int) {
+	c.mu.Lock()
+	c.maxMessageBytes = limit
+	c.mu.Unlock()
+}
+
+// getMaxMessageBytes 连接的最大消息大小，0表示不拆分
+func (c *ClientConn) getMaxMessageBytes() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxMessageBytes
+}
+
+// fragmentReady 已关闭的通道，有分段未发完时在写入goroutine的 select 中始终可读
+var fragmentReady = func() chan struct{} {
+	ready := make(chan struct{})
+	close(ready)
+	return ready
+}()
+
+// queuedMessage 发送队列中的一条消息，key 为所属请求的 msg_id，没有时为空
+type queuedMessage struct {
+	data []byte
+	key  string
+}
+
+// newQueuedMessage 创建发送队列中的消息，同一请求的消息按 msg_id 保持发送顺序
+func newQueuedMessage(msg *protocol.Message, data []byte) queuedMessage {
+	queued := queuedMessage{data: data}
+	if msg.MsgID != nil {
+		queued.key = *msg.MsgID
+	}
+	return queued
+}
+
+// fragmentedMessage 正在分段发送的消息
+type fragmentedMessage struct {
+	queuedMessage
+	frames [][]byte
+	next   int
+}
+
+// outbox 写入goroutine中分段发送的状态：每轮循环只写一个分段，多条分段消息轮流发送，
+// 其间照常发送心跳和队列中的其他消息，大消息不会长时间独占连接。
+// 同一请求的消息按入队顺序到达：前一条消息的分段发完之前，后续消息（如请求体的下一个分片）排队等待
+type outbox struct {
+	active  []*fragmentedMessage // 正在分段发送的消息，轮流各发一个分段
+	waiting []queuedMessage      // 等待同一请求的前一条消息发完的消息
+}
+
+// ready 返回有分段待发送时可读的通道，没有时返回nil
+func (o *outbox) ready() <-chan struct{} {
+	if len(o.active) == 0 {
+		return nil
+	}
+	return fragmentReady
+}
+
+// busy 同一请求是否有消息正在分段发送或排队等待
+func (o *outbox) busy(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, message := range o.active {
+		if message.key == key {
+			return true
+		}
+	}
+	for _, message := range o.waiting {
+		if message.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// pending 尚未发送完成的消息，同一请求的消息保持顺序，写入失败时由新连接重新发送
+func (o *outbox) pending() []queuedMessage {
+	var pending []queuedMessage
+	for _, message := range o.active {
+		pending = append(pending, message.queuedMessage)
+	}
+	return append(pending, o.waiting...)
+}
+
+// sendQueued 发送从队列取出的消息：同一请求有消息未发完时排队等待，超过连接的最大消息大小时拆分为二进制分段帧，
+// 由 writeNextFragment 逐个发送，否则直接写入
+func (m *Manager) sendQueued(client *ClientConn, box *outbox, message queuedMessage) error {
+	if box.busy(message.key) {
+		box.waiting = append(box.waiting, message)
+		return nil
+	}
+	frames, err := protocol.SplitMessage(message.data, client.getMaxMessageBytes())
+	if err != nil {
+		return err
+	}
+
+	// 记录WebSocket数据发送流向日志
+	wsLog.Infof("[WebSocket Send] Sending %d bytes to client %s", len(message.data), client.clientID)
+
+	if frames != nil {
+		box.active = append(box.active, &fragmentedMessage{queuedMessage: message, frames: frames})
+		return nil
+	}
+	if err := writeFrame(client, websocket.TextMessage, message.data); err != nil {
+		return err
+	}
+	messageSent(client, message.data)
+	return nil
+}
+
+// writeNextFragment 写入轮到的分段消息的下一个分段，消息的分段发完后发送排队等待的消息
+func (m *Manager) writeNextFragment(client *ClientConn, box *outbox) error {
+	message := box.active[0]
+	if err := writeFrame(client, websocket.BinaryMessage, message.frames[message.next]); err != nil {
+		return err
+	}
+	message.next++
+	box.active = box.active[1:]
+	if message.next < len(message.frames) {
+		box.active = append(box.active, message)
+		return nil
+	}
+	wsLog.Debugf("[WebSocket Send] Sent message to client %s in %d fragments", client.clientID, len(message.frames))
+	messageSent(client, message.data)
+
+	waiting := box.waiting
+	box.waiting = nil
+	for i, queued := range waiting {
+		if err := m.sendQueued(client, box, queued); err != nil {
+			box.waiting = append(box.waiting, waiting[i:]...)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame 写入一个WebSocket帧，每个分段单独计算写入超时
+func writeFrame(client *ClientConn, messageType int, data []byte) error {
+	client.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return client.conn.WriteMessage(messageType, data)
+}
+
+// messageSent 记录一条消息发送完成
+func messageSent(client *ClientConn, message []byte) {
+	wsLog.Infof("[WebSocket Send] Successfully sent message to client %s", client.clientID)
+
+	// 更新统计信息
+	client.mu.Lock()
+	client.bytesSent += int64(len(message))
+	client.mu.Unlock()
+}
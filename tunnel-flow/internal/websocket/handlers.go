@@ -1,13 +1,16 @@
 package websocket
 
 import (
+	"crypto/cipher"
 	"encoding/json"
 	"fmt"
-	"log"
 	"time"
 
 	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/logging"
 	"tunnel-flow/internal/protocol"
+	"tunnel-flow/internal/utils"
+	"tunnel-flow/internal/version"
 )
 
 // handleControlMessage 处理控制消息
@@ -19,8 +22,16 @@ func (m *Manager) handleControlMessage(client *ClientConn, msg *protocol.Message
 		m.handlePong(client, msg)
 	case protocol.OpPing:
 		m.handlePing(client, msg)
+	case protocol.OpStatsReport:
+		m.handleStatsReport(client, msg)
+	case protocol.OpMetrics:
+		m.handleMetrics(client, msg)
+	case protocol.OpTargetViolation:
+		m.handleTargetViolation(client, msg)
+	case protocol.OpRouteSync:
+		m.handleRouteSync(client, msg)
 	default:
-		log.Printf("Unknown control operation %s from client %s", msg.Op, client.clientID)
+		wsLog.Warnf("Unknown control operation %s from client %s", msg.Op, client.clientID)
 	}
 }
 
@@ -30,7 +41,7 @@ func (m *Manager) handleBusinessMessage(client *ClientConn, msg *protocol.Messag
 	case protocol.OpResponse:
 		m.handleResponse(client, msg)
 	default:
-		log.Printf("Unknown business operation %s from client %s", msg.Op, client.clientID)
+		wsLog.Warnf("Unknown business operation %s from client %s", msg.Op, client.clientID)
 	}
 }
 
@@ -38,21 +49,24 @@ func (m *Manager) handleBusinessMessage(client *ClientConn, msg *protocol.Messag
 func (m *Manager) handleACKMessage(client *ClientConn, msg *protocol.Message) {
 	var ackPayload protocol.ACKPayload
 	if err := msg.ParsePayload(&ackPayload); err != nil {
-		log.Printf("Failed to parse ACK payload from client %s: %v", client.clientID, err)
+		wsLog.Errorf("Failed to parse ACK payload from client %s: %v", client.clientID, err)
 		return
 	}
 	
-	log.Printf("Received ACK from client %s for message %s: success=%v", 
+	wsLog.Infof("Received ACK from client %s for message %s: success=%v", 
 		client.clientID, ackPayload.MsgID, ackPayload.Success)
 	
 	// 更新待处理消息状态
 	if ackPayload.Success {
-		if err := m.db.UpdatePendingMessageState(ackPayload.MsgID, database.MessageStateProcessing); err != nil {
-			log.Printf("Failed to update pending message state: %v", err)
-		}
+		m.updateMessage(&database.MessageUpdate{
+			MsgID:       ackPayload.MsgID,
+			State:       database.MessageStateProcessing,
+			NextTryTS:   time.Now().Add(m.config.RequestTimeout()).UnixMilli(),
+			FromPending: true,
+		})
 	} else {
 		// ACK失败，可能需要重试或标记为失败
-		log.Printf("Client %s failed to process message %s: %s", 
+		wsLog.Errorf("Client %s failed to process message %s: %s", 
 			client.clientID, ackPayload.MsgID, ackPayload.Message)
 	}
 }
@@ -61,18 +75,24 @@ func (m *Manager) handleACKMessage(client *ClientConn, msg *protocol.Message) {
 func (m *Manager) handleErrorMessage(client *ClientConn, msg *protocol.Message) {
 	var errorPayload protocol.ErrorPayload
 	if err := msg.ParsePayload(&errorPayload); err != nil {
-		log.Printf("Failed to parse error payload from client %s: %v", client.clientID, err)
+		wsLog.Errorf("Failed to parse error payload from client %s: %v", client.clientID, err)
 		return
 	}
 	
-	log.Printf("Received error from client %s: %s - %s", 
+	wsLog.Errorf("Received error from client %s: %s - %s", 
 		client.clientID, errorPayload.Code, errorPayload.Message)
 	
 	// 如果有关联的消息ID，更新其状态
 	if msg.MsgID != nil {
-		if err := m.db.UpdatePendingMessageState(*msg.MsgID, database.MessageStateFailed); err != nil {
-			log.Printf("Failed to update pending message state: %v", err)
+		if !m.completeResponse(*msg.MsgID) {
+			m.dropDuplicateResponse(client, *msg.MsgID)
+			return
 		}
+		m.updateMessage(&database.MessageUpdate{
+			MsgID:     *msg.MsgID,
+			State:     database.MessageStateFailed,
+			LastError: errorPayload.Message,
+		})
 		
 		// 通知等待的请求
 		m.mu.RLock()
@@ -93,7 +113,7 @@ func (m *Manager) handleErrorMessage(client *ClientConn, msg *protocol.Message)
 func (m *Manager) handleRegister(client *ClientConn, msg *protocol.Message) {
 	var registerPayload protocol.RegisterPayload
 	if err := msg.ParsePayload(&registerPayload); err != nil {
-		log.Printf("Failed to parse register payload from client %s: %v", client.clientID, err)
+		wsLog.Errorf("Failed to parse register payload from client %s: %v", client.clientID, err)
 		m.sendRegisterResponse(client, false, "Invalid register payload")
 		return
 	}
@@ -101,88 +121,208 @@ func (m *Manager) handleRegister(client *ClientConn, msg *protocol.Message) {
 	// 验证客户端
 	clientInfo, err := m.db.GetClient(client.clientID)
 	if err != nil {
-		log.Printf("Client %s not found in database: %v", client.clientID, err)
+		wsLog.Warnf("Client %s not found in database: %v", client.clientID, err)
 		m.sendRegisterResponse(client, false, "Client not found")
 		return
 	}
 	
 	// 验证客户端是否启用
 	if clientInfo.Enabled != 1 {
-		log.Printf("Client %s is disabled", client.clientID)
+		wsLog.Warnf("Client %s is disabled", client.clientID)
 		m.sendRegisterResponse(client, false, "Client is disabled")
 		return
 	}
 	
+	// 建立连接时发送证明的代理不在注册消息中带Token，改为要求注册消息带有效签名和公钥，
+	// 签名覆盖代理的公钥，服务端签名的注册确认覆盖服务端的公钥，中间设备无法替换
+	if client.tokenless {
+		if msg.Signature == "" || registerPayload.EncryptionKey == "" {
+			wsLog.Warnf("Client %s connected with a proof but sent an unsigned or unencrypted registration", client.clientID)
+			m.sendRegisterResponse(client, false, "Signed registration with an encryption key is required")
+			return
+		}
+	}
+	
 	// 验证auth token
-	if registerPayload.AuthToken == "" {
-		log.Printf("Client %s provided empty auth token", client.clientID)
+	if !client.tokenless && registerPayload.AuthToken == "" {
+		wsLog.Infof("Client %s provided empty auth token", client.clientID)
 		m.sendRegisterResponse(client, false, "Invalid auth token")
 		return
 	}
 	
 	// 验证authtoken
-	if clientInfo.AuthToken != registerPayload.AuthToken {
-		log.Printf("Client %s provided invalid auth token", client.clientID)
+	if !client.tokenless && clientInfo.AuthToken != registerPayload.AuthToken {
+		wsLog.Warnf("Client %s provided invalid auth token", client.clientID)
 		m.sendRegisterResponse(client, false, "Invalid auth token")
 		return
 	}
 	
+	// 代理版本与服务端不一致时告警，低于最低版本且配置了拒绝时不允许注册
+	skew := utils.VersionSkew(registerPayload.Version, version.Version, m.config.MinAgentVersion)
+	if skew != "" {
+		warning := utils.VersionSkewWarning(skew, registerPayload.Version, version.Version, m.config.MinAgentVersion)
+		if skew == utils.VersionSkewOutdated && m.config.RejectOutdatedAgents {
+			// 仍然记录上报的版本，便于在客户端列表中看到被拒绝的原因
+			m.saveAgentInfo(client.clientID, &registerPayload)
+			m.rejectRegistration(client, warning)
+			return
+		}
+		wsLog.Warnf("WARNING: client %s version skew (%s): %s", client.clientID, skew, warning)
+	}
+	
+	// 代理启用加密时协商该连接的密钥，注册确认之后的消息载荷加密
+	var serverKey string
+	var sessionCipher cipher.AEAD
+	if registerPayload.EncryptionKey != "" {
+		serverKey, sessionCipher, err = m.negotiateEncryption(client, registerPayload.EncryptionKey)
+		if err != nil {
+			wsLog.Warnf("Failed to negotiate encryption with client %s: %v", client.clientID, err)
+			m.sendRegisterResponse(client, false, "Invalid encryption key")
+			return
+		}
+	} else if m.config.RequireAgentEncryption {
+		m.rejectRegistration(client, "Payload encryption is required; enable encryption on the agent")
+		return
+	}
+	
 	// 注册成功
-	log.Printf("Client %s registered successfully with version %s", 
-		client.clientID, registerPayload.Version)
+	wsLog.Infof("Client %s registered successfully with version %s (%s/%s)", 
+		client.clientID, registerPayload.Version, registerPayload.OS, registerPayload.Arch)
 	
 	// 更新客户端状态
 	if err := m.db.UpdateClientStatus(client.clientID, "online"); err != nil {
-		log.Printf("Failed to update client status: %v", err)
+		wsLog.Errorf("Failed to update client status: %v", err)
 	}
 	
 	// 保存客户端本地IP地址信息
 	if len(registerPayload.LocalIPs) > 0 {
 		localIPsJSON, err := json.Marshal(registerPayload.LocalIPs)
 		if err != nil {
-			log.Printf("Failed to marshal local IPs for client %s: %v", client.clientID, err)
+			wsLog.Errorf("Failed to marshal local IPs for client %s: %v", client.clientID, err)
 		} else {
 			if err := m.db.UpdateClientLocalIPs(client.clientID, string(localIPsJSON)); err != nil {
-				log.Printf("Failed to update client local IPs: %v", err)
+				wsLog.Errorf("Failed to update client local IPs: %v", err)
 			} else {
-				log.Printf("Updated local IPs for client %s: %v", client.clientID, registerPayload.LocalIPs)
+				wsLog.Infof("Updated local IPs for client %s: %v", client.clientID, registerPayload.LocalIPs)
 			}
 		}
 	}
 	
-	m.sendRegisterResponse(client, true, "Registration successful")
+	capabilities := m.saveAgentInfo(client.clientID, &registerPayload)
+	client.mu.Lock()
+	client.capabilities = capabilities
+	client.mu.Unlock()
+	
+	// 注册消息带有效签名时，之后收发的消息都签名，不带签名的消息拒绝
+	if msg.Signature != "" && client.hasCapability(protocol.CapabilitySigning) {
+		client.enableSigning()
+		wsLog.Infof("Message signing enabled for client %s", client.clientID)
+	}
+	
+	// 支持压缩的代理按注册确认中的默认设置压缩载荷
+	var compression *protocol.CompressionSettings
+	if client.hasCapability(protocol.CapabilityCompression) {
+		compression = m.defaultCompression()
+	}
+	
+	// 支持分段的代理收发超过最大消息大小的消息时拆分为分段
+	maxMessageBytes := m.negotiateMaxMessageBytes(client, registerPayload.MaxMessageBytes)
+	
+	// 按客户端表中的设置检查该连接的心跳超时，并下发给代理
+	heartbeat := m.clientHeartbeat(clientInfo)
+	client.setHeartbeat(heartbeat)
+	
+	// 带上有效的恢复令牌时恢复断线前的会话，并为该连接生成新的恢复令牌
+	session := m.takeSession(client, registerPayload.ResumeToken)
+	
+	// 注册确认中带上目标白名单
+	m.sendRegisterAck(client, &protocol.RegisterAckPayload{
+		Success:         true,
+		Message:         "Registration successful",
+		AllowedTargets:  clientInfo.AllowedTargets,
+		EncryptionKey:   serverKey,
+		Compression:     compression,
+		MaxMessageBytes: maxMessageBytes,
+		Heartbeat:       heartbeat,
+		ResumeToken:     m.issueResumeToken(client),
+		Resumed:         session != nil,
+	})
+	if sessionCipher != nil {
+		client.setSessionCipher(sessionCipher)
+		wsLog.Infof("Payload encryption enabled for client %s", client.clientID)
+	}
+	if compression != nil {
+		client.setCompression(compression)
+		wsLog.Infof("Payload compression enabled for client %s (%s, min %d bytes)", client.clientID, compression.Algorithm, compression.MinBytes)
+	}
+	if maxMessageBytes > 0 {
+		client.setMaxMessageBytes(maxMessageBytes)
+		wsLog.Infof("Message fragmentation enabled for client %s (max %d bytes)", client.clientID, maxMessageBytes)
+	}
+	
+	if session != nil {
+		m.resumeSession(client, session)
+	}
+	
+	// 继续连接中断前未完成的响应体传输
+	m.resumeTransfers(client.clientID)
+	
+	// 路由信息直接注入到请求消息中，不需要向代理同步；代理注册成功后通过 ROUTE_SYNC 上报其配置中声明的路由
+}
+
+// handleStatsReport 处理代理运行状态上报，只保留最新快照
+func (m *Manager) handleStatsReport(client *ClientConn, msg *protocol.Message) {
+	var statsPayload protocol.StatsReportPayload
+	if err := msg.ParsePayload(&statsPayload); err != nil {
+		wsLog.Errorf("Failed to parse stats report from client %s: %v", client.clientID, err)
+		return
+	}
+	if statsPayload.Targets == nil {
+		statsPayload.Targets = []protocol.TargetHealth{}
+	}
+	
+	statsJSON, err := json.Marshal(statsPayload)
+	if err != nil {
+		wsLog.Errorf("Failed to marshal stats report from client %s: %v", client.clientID, err)
+		return
+	}
 	
-	// 发送路由同步（如果需要）
-	// 在优化版本中，路由信息直接注入到请求消息中，不需要单独同步
+	if err := m.db.SaveClientStats(client.clientID, string(statsJSON), time.Now().UnixMilli()); err != nil {
+		wsLog.Errorf("Failed to save stats report from client %s: %v", client.clientID, err)
+	}
 }
 
 // handleResponse 处理响应消息
 func (m *Manager) handleResponse(client *ClientConn, msg *protocol.Message) {
 	if msg.MsgID == nil {
-		log.Printf("Response message from client %s missing msg_id", client.clientID)
+		wsLog.Infof("Response message from client %s missing msg_id", client.clientID)
 		return
 	}
 	
-	// 打印接收到的原始WebSocket消息
-	log.Printf("[WebSocket Receive] Raw message from client %s: %+v", client.clientID, msg)
-	
 	var responsePayload protocol.ResponsePayload
 	if err := msg.ParsePayload(&responsePayload); err != nil {
-		log.Printf("Failed to parse response payload from client %s: %v", client.clientID, err)
+		wsLog.Errorf("Failed to parse response payload from client %s: %v", client.clientID, err)
 		return
 	}
 	
 	msgID := *msg.MsgID
-	log.Printf("[WebSocket Receive] Parsed response from client %s for message %s: status=%d, latency=%dms, body_length=%d", 
-		client.clientID, msgID, responsePayload.HTTPStatus, responsePayload.LatencyMS, len(fmt.Sprintf("%v", responsePayload.Body)))
-	
-	// 打印响应体内容（前200个字符）
-	if responsePayload.Body != nil {
-		bodyStr := fmt.Sprintf("%v", responsePayload.Body)
-		if len(bodyStr) > 200 {
-			bodyStr = bodyStr[:200] + "..."
+	if !m.completeResponse(msgID) {
+		m.dropDuplicateResponse(client, msgID)
+		return
+	}
+	// 高流量客户端的响应详情按采样记录，响应体预览中的敏感字段脱敏
+	if m.logSampler.Allow(client.clientID) {
+		wsLog.Infof("[WebSocket Receive] Parsed response from client %s for message %s: status=%d, latency=%dms, body_length=%d", 
+			client.clientID, msgID, responsePayload.HTTPStatus, responsePayload.LatencyMS, len(fmt.Sprintf("%v", responsePayload.Body)))
+		
+		// 打印响应体内容（前200个字符）
+		if responsePayload.Body != nil {
+			bodyStr := fmt.Sprintf("%v", responsePayload.Body)
+			if len(bodyStr) > 200 {
+				bodyStr = bodyStr[:200] + "..."
+			}
+			wsLog.Infof("[WebSocket Receive] Response body preview: %s", logging.RedactBody(bodyStr))
 		}
-		log.Printf("[WebSocket Receive] Response body preview: %s", bodyStr)
 	}
 	
 	// 更新数据库中的待处理消息
@@ -192,11 +332,14 @@ func (m *Manager) handleResponse(client *ClientConn, msg *protocol.Message) {
 		Body:       responsePayload.Body,
 		LatencyMS:  responsePayload.LatencyMS,
 		Error:      responsePayload.Error,
+		Trailers:   responsePayload.Trailers,
+
+		HeaderValues: responsePayload.HeaderValues,
 	}
 	
 	responseMetaJSON, err := json.Marshal(responseMeta)
 	if err != nil {
-		log.Printf("Failed to marshal response meta: %v", err)
+		wsLog.Errorf("Failed to marshal response meta: %v", err)
 		return
 	}
 	
@@ -205,29 +348,52 @@ func (m *Manager) handleResponse(client *ClientConn, msg *protocol.Message) {
 		state = database.MessageStateFailed
 	}
 	
-	if err := m.db.UpdatePendingMessageResponse(msgID, state, string(responseMetaJSON)); err != nil {
-		log.Printf("Failed to update pending message response: %v", err)
+	// 带幂等键的请求立即保存响应，调用方收到响应后马上重试时能重放；其余由批处理器合并写入
+	m.mu.RLock()
+	pending := m.pending[msgID]
+	m.mu.RUnlock()
+	switch {
+	case pending != nil && pending.unrecorded:
+		// 不记录的请求不保存响应
+	case pending != nil && pending.idempotent:
+		if err := m.db.UpdatePendingMessageResponse(msgID, state, string(responseMetaJSON)); err != nil {
+			wsLog.Errorf("Failed to update pending message response: %v", err)
+		}
+	default:
+		m.updateMessage(&database.MessageUpdate{MsgID: msgID, State: state, ResponseMetaJSON: string(responseMetaJSON)})
+	}
+	if pending == nil || !pending.unrecorded {
+		m.recordAudit(msgID, client.clientID, database.DirectionInbound,
+			fmt.Sprintf("response %d in %dms", responsePayload.HTTPStatus, responsePayload.LatencyMS))
+	}
+	
+	// 响应体随后分片发送，先登记传输以免分片早于调用方接收到达
+	if responsePayload.TransferID != "" {
+		m.openTransfer(client.clientID, responsePayload.TransferID)
 	}
 	
 	// 通知等待的请求
 	m.mu.RLock()
-	log.Printf("[WebSocket Receive] Looking for pending request with msgID: %s", msgID)
+	wsLog.Infof("[WebSocket Receive] Looking for pending request with msgID: %s", msgID)
 	if pending, exists := m.pending[msgID]; exists {
-		log.Printf("[WebSocket Receive] Found pending request for msgID: %s, attempting to notify waiting goroutine", msgID)
+		wsLog.Infof("[WebSocket Receive] Found pending request for msgID: %s, attempting to notify waiting goroutine", msgID)
 		select {
 		case pending.resultCh <- &responsePayload:
-			log.Printf("[WebSocket Receive] Successfully notified waiting goroutine for msgID: %s", msgID)
+			wsLog.Infof("[WebSocket Receive] Successfully notified waiting goroutine for msgID: %s", msgID)
 		default:
-			log.Printf("[WebSocket Receive] Failed to send response to pending request %s - channel blocked or closed", msgID)
+			wsLog.Errorf("[WebSocket Receive] Failed to send response to pending request %s - channel blocked or closed", msgID)
 		}
 	} else {
-		log.Printf("[WebSocket Receive] No pending request found for msgID: %s", msgID)
+		wsLog.Infof("[WebSocket Receive] No pending request found for msgID: %s", msgID)
+		if responsePayload.TransferID != "" {
+			go m.CloseTransfer(responsePayload.TransferID, false)
+		}
 		// 打印当前所有pending请求的ID
 		pendingIDs := make([]string, 0, len(m.pending))
 		for id := range m.pending {
 			pendingIDs = append(pendingIDs, id)
 		}
-		log.Printf("[WebSocket Receive] Current pending request IDs: %v", pendingIDs)
+		wsLog.Infof("[WebSocket Receive] Current pending request IDs: %v", pendingIDs)
 	}
 	m.mu.RUnlock()
 }
@@ -236,7 +402,7 @@ func (m *Manager) handleResponse(client *ClientConn, msg *protocol.Message) {
 func (m *Manager) handlePong(client *ClientConn, msg *protocol.Message) {
 	var pongPayload protocol.PongPayload
 	if err := msg.ParsePayload(&pongPayload); err != nil {
-		log.Printf("Failed to parse pong payload from client %s: %v", client.clientID, err)
+		heartbeatLog.Errorf("Failed to parse pong payload from client %s: %v", client.clientID, err)
 		return
 	}
 	
@@ -244,32 +410,52 @@ func (m *Manager) handlePong(client *ClientConn, msg *protocol.Message) {
 	now := time.Now().UnixMilli()
 	latency := now - pongPayload.Timestamp
 	
-	log.Printf("Received pong from client %s, latency: %dms", client.clientID, latency)
+	heartbeatLog.Debugf("Received pong from client %s, latency: %dms", client.clientID, latency)
 	
 	// 更新客户端最后活跃时间
 	client.mu.Lock()
 	client.lastSeen = time.Now()
 	client.mu.Unlock()
 	
-	// 发送心跳更新到队列（非阻塞）
-	select {
-	case m.heartbeatQueue <- HeartbeatUpdate{
-		ClientID:       client.clientID,
-		LastActiveTime: time.Now(),
-	}:
-	default:
-		// 队列满时丢弃，避免阻塞
-		log.Printf("Heartbeat queue full, dropping update for client %s", client.clientID)
+	// 最后活跃时间由批处理器合并写入
+	m.recordLastSeen(client.clientID, time.Now())
+}
+
+// saveAgentInfo 保存代理上报的版本、构建信息、平台和能力信息，返回代理支持的能力列表
+func (m *Manager) saveAgentInfo(clientID string, registerPayload *protocol.RegisterPayload) []string {
+	capabilities := registerPayload.Capabilities
+	if capabilities == nil {
+		capabilities = []string{}
+	}
+	capabilitiesJSON, err := json.Marshal(capabilities)
+	if err != nil {
+		wsLog.Errorf("Failed to marshal capabilities for client %s: %v", clientID, err)
+	} else if err := m.db.UpdateClientAgentInfo(clientID, registerPayload.Version,
+		registerPayload.GitCommit, registerPayload.BuildDate, registerPayload.OS, registerPayload.Arch, string(capabilitiesJSON)); err != nil {
+		wsLog.Errorf("Failed to update agent info for client %s: %v", clientID, err)
 	}
+	return capabilities
+}
+
+// rejectRegistration 拒绝代理注册，注册确认发出后断开连接
+func (m *Manager) rejectRegistration(client *ClientConn, reason string) {
+	wsLog.Warnf("Rejecting client %s: %s", client.clientID, reason)
+	m.sendRegisterResponse(client, false, reason)
+	time.AfterFunc(time.Second, func() {
+		client.disconnect(DisconnectRejected)
+	})
 }
 
 // sendRegisterResponse 发送注册响应
 func (m *Manager) sendRegisterResponse(client *ClientConn, success bool, message string) {
-	responsePayload := &protocol.RegisterAckPayload{
+	m.sendRegisterAck(client, &protocol.RegisterAckPayload{
 		Success: success,
 		Message: message,
-	}
-	
+	})
+}
+
+// sendRegisterAck 发送注册确认
+func (m *Manager) sendRegisterAck(client *ClientConn, responsePayload *protocol.RegisterAckPayload) {
 	responseMsg, err := protocol.NewMessage(
 		protocol.MessageTypeControl,
 		protocol.OpRegisterAck,
@@ -278,12 +464,12 @@ func (m *Manager) sendRegisterResponse(client *ClientConn, success bool, message
 		responsePayload,
 	)
 	if err != nil {
-		log.Printf("Failed to create register response: %v", err)
+		wsLog.Errorf("Failed to create register response: %v", err)
 		return
 	}
 	
 	if err := m.SendToClient(client.clientID, responseMsg); err != nil {
-		log.Printf("Failed to send register response to client %s: %v", client.clientID, err)
+		wsLog.Errorf("Failed to send register response to client %s: %v", client.clientID, err)
 	}
 }
 
@@ -303,12 +489,12 @@ func (m *Manager) sendACK(clientID, msgID string, success bool, message string)
 		ackPayload,
 	)
 	if err != nil {
-		log.Printf("Failed to create ACK message: %v", err)
+		wsLog.Errorf("Failed to create ACK message: %v", err)
 		return
 	}
 	
 	if err := m.SendToClient(clientID, ackMsg); err != nil {
-		log.Printf("Failed to send ACK to client %s: %v", clientID, err)
+		wsLog.Errorf("Failed to send ACK to client %s: %v", clientID, err)
 	}
 }
 
@@ -318,13 +504,13 @@ func (m *Manager) handlePing(client *ClientConn, msg *protocol.Message) {
 	
 	// 如果payload为空或解析失败，使用默认值
 	if len(msg.Payload) == 0 {
-		log.Printf("Received ping from client %s (empty payload)", client.clientID)
+		heartbeatLog.Debugf("Received ping from client %s (empty payload)", client.clientID)
 		pingPayload.Timestamp = time.Now().Unix()
 	} else if err := msg.ParsePayload(&pingPayload); err != nil {
-		log.Printf("Failed to parse ping payload from client %s, using default: %v", client.clientID, err)
+		heartbeatLog.Errorf("Failed to parse ping payload from client %s, using default: %v", client.clientID, err)
 		pingPayload.Timestamp = time.Now().Unix()
 	} else {
-		log.Printf("Received ping from client %s", client.clientID)
+		heartbeatLog.Debugf("Received ping from client %s", client.clientID)
 	}
 	
 	// 更新客户端最后活跃时间
@@ -333,16 +519,8 @@ func (m *Manager) handlePing(client *ClientConn, msg *protocol.Message) {
 	client.lastSeen = now
 	client.mu.Unlock()
 	
-	// 发送心跳更新到队列（非阻塞）
-	select {
-	case m.heartbeatQueue <- HeartbeatUpdate{
-		ClientID:       client.clientID,
-		LastActiveTime: now,
-	}:
-	default:
-		// 队列满时丢弃，避免阻塞
-		log.Printf("Heartbeat queue full, dropping update for client %s", client.clientID)
-	}
+	// 最后活跃时间由批处理器合并写入
+	m.recordLastSeen(client.clientID, now)
 	
 	// 发送Pong响应
 	m.sendPong(client.clientID, pingPayload.Timestamp)
@@ -362,12 +540,12 @@ func (m *Manager) sendPong(clientID string, timestamp int64) {
 		pongPayload,
 	)
 	if err != nil {
-		log.Printf("Failed to create pong message: %v", err)
+		heartbeatLog.Errorf("Failed to create pong message: %v", err)
 		return
 	}
 	
 	if err := m.SendToClient(clientID, pongMsg); err != nil {
-		log.Printf("Failed to send pong to client %s: %v", clientID, err)
+		heartbeatLog.Errorf("Failed to send pong to client %s: %v", clientID, err)
 	}
 }
 
@@ -387,12 +565,12 @@ func (m *Manager) sendError(clientID string, msgID *string, code, message, detai
 		errorPayload,
 	)
 	if err != nil {
-		log.Printf("Failed to create error message: %v", err)
+		wsLog.Errorf("Failed to create error message: %v", err)
 		return
 	}
 	
 	if err := m.SendToClient(clientID, errorMsg); err != nil {
-		log.Printf("Failed to send error to client %s: %v", clientID, err)
+		wsLog.Errorf("Failed to send error to client %s: %v", clientID, err)
 	}
 }
 
@@ -450,12 +628,14 @@ func (m *Manager) handleDataMessage(client *ClientConn, msg *protocol.Message) {
 	switch msg.Op {
 	case protocol.OpRequest:
 		// 处理请求消息 - 转发到目标客户端
-		log.Printf("Handling request message from client %s", client.clientID)
+		wsLog.Infof("Handling request message from client %s", client.clientID)
 	case protocol.OpResponse:
 		// 处理响应消息 - 转发到原始客户端
-		log.Printf("Handling response message from client %s", client.clientID)
+		wsLog.Infof("Handling response message from client %s", client.clientID)
 		m.handleResponse(client, msg)
+	case protocol.OpResponseChunk:
+		m.handleResponseChunk(client, msg)
 	default:
-		log.Printf("Unknown data operation: %s", msg.Op)
+		wsLog.Warnf("Unknown data operation: %s", msg.Op)
 	}
 }
\ No newline at end of file
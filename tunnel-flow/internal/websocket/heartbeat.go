@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/protocol"
+)
+
+// healthCheckInterval 检查连接心跳超时的间隔
+const healthCheckInterval = time.Second
+
+// minReadTimeout 读取消息的最短超时，心跳超时更长时按心跳超时等待
+const minReadTimeout = 60 * time.Second
+
+// clientHeartbeat 客户端表中为该客户端设置的心跳间隔和超时，未设置时使用全局的心跳间隔和3倍的超时
+func (m *Manager) clientHeartbeat(info *database.Client) *protocol.HeartbeatSettings {
+	settings := &protocol.HeartbeatSettings{
+		IntervalSeconds: info.HeartbeatInterval,
+		TimeoutSeconds:  info.HeartbeatTimeout,
+	}
+	if settings.IntervalSeconds <= 0 {
+		settings.IntervalSeconds = max(int(m.config.PingInterval()/time.Second), 1)
+	}
+	if settings.TimeoutSeconds <= settings.IntervalSeconds {
+		settings.TimeoutSeconds = settings.IntervalSeconds * 3
+	}
+	return settings
+}
+
+// setHeartbeat 设置连接的心跳间隔和超时，通知写入goroutine按新的间隔发送心跳
+func (c *ClientConn) setHeartbeat(settings *protocol.HeartbeatSettings) {
+	c.mu.Lock()
+	c.heartbeatInterval = time.Duration(settings.IntervalSeconds) * time.Second
+	c.heartbeatTimeout = time.Duration(settings.TimeoutSeconds) * time.Second
+	c.mu.Unlock()
+	select {
+	case c.heartbeatChanged <- struct{}{}:
+	default:
+	}
+}
+
+// getHeartbeat 连接的心跳间隔和超时
+func (c *ClientConn) getHeartbeat() (interval, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.heartbeatInterval, c.heartbeatTimeout
+}
+
+// PushHeartbeat 把修改后的心跳设置下发给在线的客户端并按新的超时检查连接，客户端不在线时在下次注册时下发
+func (m *Manager) PushHeartbeat(clientID string, settings *protocol.HeartbeatSettings) error {
+	client := m.getClient(clientID)
+	if client == nil {
+		return nil
+	}
+	msg, err := protocol.NewMessage(protocol.MessageTypeControl, protocol.OpHeartbeatConfig, clientID, nil, settings)
+	if err != nil {
+		return err
+	}
+	// 先下发再生效，代理缩短心跳间隔之前服务端不按更短的超时断开连接
+	if err := m.SendToClient(clientID, msg); err != nil {
+		return err
+	}
+	client.setHeartbeat(settings)
+	return nil
+}
+
+// readTimeout 读取下一条消息的超时
+func (c *ClientConn) readTimeout() time.Duration {
+	_, timeout := c.getHeartbeat()
+	return max(timeout, minReadTimeout)
+}
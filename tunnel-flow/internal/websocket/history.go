@@ -0,0 +1,75 @@
+package websocket
+
+import (
+	"net"
+	"time"
+
+	"tunnel-flow/internal/database"
+)
+
+// 连接断开的原因
+const (
+	DisconnectClientClosed     = "client_closed"         // 代理主动关闭连接
+	DisconnectReadError        = "read_error"            // 读取消息失败或超时
+	DisconnectWriteError       = "write_error"           // 发送消息失败
+	DisconnectSendQueueFull    = "send_queue_full"       // 发送队列已满，连接无法及时发送消息
+	DisconnectHeartbeatTimeout = "heartbeat_timeout"     // 超过心跳超时没有收到消息
+	DisconnectAdmin            = "admin_disconnect"      // 管理员断开或禁用了客户端
+	DisconnectServerShutdown   = "server_shutdown"       // 服务端停止
+	DisconnectDuplicate        = "duplicate_connection"  // 同一客户端建立了新的连接
+	DisconnectRejected         = "registration_rejected" // 注册被拒绝
+	DisconnectUnknown          = "unknown"
+)
+
+// disconnect 记录断开原因并关闭连接，只记录第一次的原因
+func (c *ClientConn) disconnect(reason string) {
+	c.mu.Lock()
+	if c.disconnectReason == "" {
+		c.disconnectReason = reason
+	}
+	c.mu.Unlock()
+	c.cancel()
+	// 关闭连接，读取goroutine不必等到读取超时才退出
+	c.conn.Close()
+}
+
+// recordConnect 在连接历史中记录客户端建立了连接
+func (m *Manager) recordConnect(client *ClientConn) {
+	remoteIP := client.conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(remoteIP); err == nil {
+		remoteIP = host
+	}
+	history := &database.ConnectionHistory{
+		ClientID:    client.clientID,
+		RemoteIP:    remoteIP,
+		ConnectedAt: client.connectedAt.UnixMilli(),
+	}
+	if err := m.db.CreateConnectionHistory(history); err != nil {
+		wsLog.Errorf("Failed to record connection of client %s: %v", client.clientID, err)
+		return
+	}
+	client.historyID = history.ID
+}
+
+// recordDisconnect 在连接历史和客户端记录中记录连接断开的时间和原因，返回断开原因
+func (m *Manager) recordDisconnect(client *ClientConn) string {
+	client.mu.Lock()
+	reason := client.disconnectReason
+	client.mu.Unlock()
+	if reason == "" {
+		reason = DisconnectUnknown
+		if m.ctx.Err() != nil {
+			reason = DisconnectServerShutdown
+		}
+	}
+	now := time.Now().UnixMilli()
+	if client.historyID != 0 {
+		if err := m.db.CloseConnectionHistory(client.historyID, now, reason); err != nil {
+			wsLog.Errorf("Failed to record disconnection of client %s: %v", client.clientID, err)
+		}
+	}
+	if err := m.db.UpdateClientDisconnect(client.clientID, reason, now); err != nil {
+		wsLog.Errorf("Failed to record disconnect reason of client %s: %v", client.clientID, err)
+	}
+	return reason
+}
@@ -2,10 +2,10 @@ package websocket
 
 import (
 	"context"
+	"crypto/cipher"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"sync"
 	"time"
@@ -15,9 +15,17 @@ import (
 
 	"tunnel-flow/internal/config"
 	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/logging"
 	"tunnel-flow/internal/protocol"
 	"tunnel-flow/internal/retry"
 	"tunnel-flow/internal/performance"
+	"tunnel-flow/internal/utils"
+)
+
+// 组件日志，级别可以在运行时单独调整
+var (
+	wsLog        = logging.NewComponent(logging.ComponentWebsocket)
+	heartbeatLog = logging.NewComponent(logging.ComponentHeartbeat)
 )
 
 // min 返回两个整数中的较小值
@@ -37,11 +45,17 @@ type Message struct {
 	Timestamp time.Time              `json:"timestamp"`
 }
 
+// 响应延迟统计参数
+const (
+	maxLatencySamples = 10          // 每个客户端保留的延迟样本数
+	latencyStaleAfter = time.Minute // 超过该时间没有新样本时视为测量过期
+)
+
 // ClientConn 客户端连接信息
 type ClientConn struct {
 	clientID     string
 	conn         *websocket.Conn
-	sendQueue    chan []byte
+	sendQueue    chan queuedMessage
 	lastSeen     time.Time
 	lastActivity time.Time
 	connectedAt  time.Time
@@ -56,9 +70,31 @@ type ClientConn struct {
 	packetLoss       float64
 	networkQuality   string
 	adaptiveInterval time.Duration
+	latencyUpdatedAt time.Time   // 最近一次记录响应延迟的时间
+	capabilities     []string    // 注册时上报的代理能力
+	sessionCipher    cipher.AEAD // 载荷加密的密钥，代理未启用加密时为nil
+	authToken        string      // 客户端的认证Token，用作消息签名的密钥
+	signing          bool        // 代理支持消息签名，收发的消息都签名
+	tokenless        bool        // 建立连接时发送的是证明而不是认证Token，注册消息必须带签名和公钥
+	nonces           *protocol.NonceCache
+	maxMessageBytes  int
+	compression      *protocol.CompressionSettings // 载荷压缩的默认设置，代理不支持压缩时为nil
 	mu               sync.Mutex
 	ctx              context.Context
 	cancel           context.CancelFunc
+	// 心跳间隔和超时，注册时按客户端表中的设置更新
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	heartbeatChanged  chan struct{}
+	// 连接历史记录的ID和断开原因，见 history.go
+	historyID        int64
+	disconnectReason string
+	// 会话恢复，见 session.go
+	resumeToken string
+	unsent      []queuedMessage  // 写入失败和未发完的消息，恢复会话时重新发送
+	previous    *ClientConn      // 该连接替换的同一客户端的旧连接
+	session     *detachedSession // 断开后保留的会话，detached 关闭后可以读取
+	detached    chan struct{}    // 断开后的处理完成时关闭
 }
 
 // generateMessageID 生成消息ID
@@ -74,7 +110,7 @@ func (c *ClientConn) sendMessage(msg Message) error {
 	}
 	
 	select {
-	case c.sendQueue <- data:
+	case c.sendQueue <- queuedMessage{data: data}:
 		return nil
 	default:
 		return fmt.Errorf("send queue is full")
@@ -89,12 +125,11 @@ type PendingContext struct {
 	cancel     context.CancelFunc
 	createdAt  time.Time
 	retryCount int
-}
-
-// HeartbeatUpdate 心跳更新信息
-type HeartbeatUpdate struct {
-	ClientID       string
-	LastActiveTime time.Time
+	uploading  bool        // 正在发送流式请求体，发送完成前不按超时清理
+	conn       *ClientConn // 等待响应的连接，恢复会话时改为新连接
+	err        error       // 设置后取消ctx，请求以该错误结束
+	idempotent bool        // 请求带幂等键，响应需要立即保存
+	unrecorded bool        // 请求没有保存为消息，响应也不保存
 }
 
 // ConnectionStats 连接统计信息
@@ -112,14 +147,39 @@ type ConnectionStats struct {
 
 // Manager WebSocket连接管理器
 type Manager struct {
-	config          *config.Config
-	db              *database.Repository
-	upgrader        websocket.Upgrader
-	clients         map[string]*ClientConn
-	pending         map[string]*PendingContext
-	routeIndex      map[string][]string
-	heartbeatQueue  chan HeartbeatUpdate
-	stats           *ConnectionStats
+	config     *config.Config
+	db         *database.Repository
+	upgrader   websocket.Upgrader
+	clients    map[string]*ClientConn
+	pending    map[string]*PendingContext
+	routeIndex map[string][]string
+	stats      *ConnectionStats
+
+	// 响应体预览日志按客户端采样，为nil时不采样
+	logSampler *logging.Sampler
+
+	// 接收中的可续传响应体，按传输ID索引
+	transfersMu sync.Mutex
+	transfers   map[string]*Transfer
+
+	// 代理最近一次上报的计数器，按客户端ID索引，断开后保留
+	agentMetricsMu sync.RWMutex
+	agentMetrics   map[string]*AgentMetrics
+
+	// 代理上报的白名单违规
+	violationsMu sync.RWMutex
+	violations   map[string][]TargetViolation
+
+	// 断开后保留的会话，按客户端ID索引
+	sessionsMu sync.Mutex
+	sessions   map[string]*detachedSession
+
+	// 最近收到响应的消息ID和时间，用于丢弃重复的响应，见 dedupe.go
+	completedMu sync.Mutex
+	completed   map[string]time.Time
+
+	// 建立连接时证明中的随机数，跨连接拒绝重放的证明，见 encryption.go
+	connectNonces *protocol.NonceCache
 	
 	// 自适应心跳配置
 	baseHeartbeatInterval time.Duration
@@ -136,6 +196,9 @@ type Manager struct {
 	
 	// 监控组件
 	metrics interface{}
+
+	// OnRouteSync 处理代理上报的声明路由，为nil时拒绝所有声明路由，需在接受代理连接之前设置
+	OnRouteSync RouteSyncFunc
 	
 	mu     sync.RWMutex
 	ctx    context.Context
@@ -154,12 +217,18 @@ func NewManager(cfg *config.Config, db *database.Repository, objectPool *perform
 	retryStrategy := retry.NewRetryStrategy()
 	
 	m := &Manager{
-		config:         cfg,
-		db:             db,
-		clients:        make(map[string]*ClientConn),
-		pending:        make(map[string]*PendingContext),
-		routeIndex:     make(map[string][]string),
-		heartbeatQueue: make(chan HeartbeatUpdate, 1000),
+		config:       cfg,
+		db:           db,
+		clients:      make(map[string]*ClientConn),
+		pending:      make(map[string]*PendingContext),
+		transfers:    make(map[string]*Transfer),
+		agentMetrics: make(map[string]*AgentMetrics),
+		violations:   make(map[string][]TargetViolation),
+		sessions:     make(map[string]*detachedSession),
+		completed:    make(map[string]time.Time),
+		connectNonces: protocol.NewNonceCache(time.Duration(cfg.SignatureWindowSeconds) * time.Second),
+		routeIndex:   make(map[string][]string),
+		logSampler:   logging.NewSampler(cfg.LogSampleInitial, cfg.LogSampleThereafter),
 		stats: &ConnectionStats{
 			StartTime: time.Now(),
 		},
@@ -186,6 +255,9 @@ func NewManager(cfg *config.Config, db *database.Repository, objectPool *perform
 		},
 	}
 
+	// 恢复上次运行遗留的客户端状态和消息，需要在接受连接和启动后台任务之前完成
+	m.recoverAfterRestart()
+
 	// 启动后台任务
 	go m.startBackgroundTasks()
 	
@@ -208,66 +280,73 @@ func NewManager(cfg *config.Config, db *database.Repository, objectPool *perform
 func (m *Manager) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 	clientID := r.URL.Query().Get("client_id")
 	if clientID == "" {
-		http.Error(w, "client_id is required", http.StatusBadRequest)
+		utils.WriteError(w, r, http.StatusBadRequest, utils.ErrCodeValidation, "client_id is required")
 		return
 	}
 	
-	// 验证token
+	// 验证token，启用加密的代理不发送Token，改为发送以Token计算的证明
 	token := r.URL.Query().Get("token")
-	if token == "" {
-		http.Error(w, "token is required", http.StatusUnauthorized)
+	if token == "" && r.URL.Query().Get("proof") == "" {
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "token is required")
 		return
 	}
 	
 	// 验证JWT token
-	if !m.validateToken(token) {
-		http.Error(w, "invalid token", http.StatusUnauthorized)
+	if token != "" && !m.validateToken(token) {
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "invalid token")
 		return
 	}
 	
-	log.Printf("Client %s connecting", clientID)
+	wsLog.Infof("Client %s connecting", clientID)
 	
 	// 验证客户端是否存在
 	client, err := m.db.GetClient(clientID)
 	if err != nil {
-		log.Printf("Client %s not found in database: %v", clientID, err)
-		http.Error(w, "Client not found", http.StatusNotFound)
+		wsLog.Warnf("Client %s not found in database: %v", clientID, err)
+		utils.WriteError(w, r, http.StatusNotFound, utils.ErrCodeNotFound, "Client not found")
 		return
 	}
 	
 	// 验证authtoken
-	if client.AuthToken != token {
-		log.Printf("Client %s provided invalid auth token", clientID)
-		http.Error(w, "Invalid auth token", http.StatusUnauthorized)
+	if token != "" && client.AuthToken != token {
+		wsLog.Warnf("Client %s provided invalid auth token", clientID)
+		utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid auth token")
 		return
 	}
+	if token == "" {
+		if err := m.verifyConnectProof(r, client.AuthToken, clientID); err != nil {
+			wsLog.Warnf("Client %s provided invalid connect proof: %v", clientID, err)
+			utils.WriteError(w, r, http.StatusUnauthorized, utils.ErrCodeUnauthorized, "Invalid auth token")
+			return
+		}
+	}
 	
 	// 更新客户端最新心跳时间
 	now := time.Now().UnixMilli()
 	client.LastSeenTS = sql.NullInt64{Int64: now, Valid: true}
 	err = m.db.UpdateClientLastSeen(clientID, now)
 	if err != nil {
-		log.Printf("Failed to update client %s last seen: %v", clientID, err)
+		wsLog.Errorf("Failed to update client %s last seen: %v", clientID, err)
 	}
 	
 	// 检查客户端是否被禁用
 	if client.Enabled != 1 {
-		log.Printf("Client %s is disabled, rejecting connection", clientID)
-		http.Error(w, "Client is disabled", http.StatusForbidden)
+		wsLog.Warnf("Client %s is disabled, rejecting connection", clientID)
+		utils.WriteError(w, r, http.StatusForbidden, utils.ErrCodeForbidden, "Client is disabled")
 		return
 	}
 	
 	conn, err := m.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("Failed to upgrade connection: %v", err)
+		wsLog.Errorf("Failed to upgrade connection: %v", err)
 		return
 	}
 	
-	m.handleConnection(clientID, conn)
+	m.handleConnection(clientID, client.AuthToken, token == "", conn)
 }
 
-// handleConnection 处理单个连接
-func (m *Manager) handleConnection(clientID string, conn *websocket.Conn) {
+// handleConnection 处理单个连接，tokenless 表示建立连接时发送的是证明而不是认证Token
+func (m *Manager) handleConnection(clientID, authToken string, tokenless bool, conn *websocket.Conn) {
 	// 记录连接指标
 	if m.metrics != nil {
 		if collector, ok := m.metrics.(interface{ IncrementConnections() }); ok {
@@ -279,16 +358,25 @@ func (m *Manager) handleConnection(clientID string, conn *websocket.Conn) {
 	client := &ClientConn{
 		clientID:         clientID,
 		conn:             conn,
-		sendQueue:        make(chan []byte, m.config.SendQueueSize),
+		sendQueue:        make(chan queuedMessage, m.config.SendQueueSize),
 		lastSeen:         time.Now(),
 		connectedAt:      time.Now(),
 		adaptiveInterval: m.config.PingInterval(), // 初始化为配置的心跳间隔
+		authToken:        authToken,
+		tokenless:        tokenless,
+		nonces:           protocol.NewNonceCache(time.Duration(m.config.SignatureWindowSeconds) * time.Second),
 		ctx:              ctx,
 		cancel:           cancel,
+		// 注册前使用全局的心跳间隔，允许3倍的容错时间
+		heartbeatInterval: m.config.PingInterval(),
+		heartbeatTimeout:  m.config.PingInterval() * 3,
+		heartbeatChanged:  make(chan struct{}, 1),
+		detached:          make(chan struct{}),
 	}
 	
 	// 注册客户端
 	m.registerClient(client)
+	m.recordConnect(client)
 	
 	defer func() {
 		// 确保context被取消
@@ -302,9 +390,11 @@ func (m *Manager) handleConnection(clientID string, conn *websocket.Conn) {
 		}
 		
 		// 清理资源
-		m.unregisterClient(clientID)
+		reason := m.recordDisconnect(client)
+		m.detachSession(client, reason)
+		m.unregisterClient(client)
 		conn.Close()
-		log.Printf("Client %s disconnected", clientID)
+		wsLog.Infof("Client %s disconnected (%s)", clientID, reason)
 	}()
 	
 	// 使用WaitGroup确保两个goroutine都正确退出
@@ -322,7 +412,7 @@ func (m *Manager) handleConnection(clientID string, conn *websocket.Conn) {
 		m.clientWriter(client)
 	}()
 	
-	log.Printf("Client %s connected", clientID)
+	wsLog.Infof("Client %s connected", clientID)
 	
 	// 等待两个goroutine都退出
 	wg.Wait()
@@ -333,6 +423,12 @@ func (m *Manager) registerClient(client *ClientConn) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
+	// 同一客户端的旧连接不再使用，断开旧连接
+	if old := m.clients[client.clientID]; old != nil && old != client {
+		wsLog.Warnf("Client %s opened a new connection, closing the previous one", client.clientID)
+		old.disconnect(DisconnectDuplicate)
+		client.previous = old
+	}
 	m.clients[client.clientID] = client
 	
 	// 更新统计信息
@@ -347,14 +443,18 @@ func (m *Manager) registerClient(client *ClientConn) {
 	client.rttSamples = make([]time.Duration, 0, 10)
 	client.mu.Unlock()
 	
-	log.Printf("Client %s registered, total clients: %d", client.clientID, len(m.clients))
+	wsLog.Infof("Client %s registered, total clients: %d", client.clientID, len(m.clients))
 }
 
-// unregisterClient 注销客户端
-func (m *Manager) unregisterClient(clientID string) {
+// unregisterClient 注销客户端，客户端已经建立了新的连接时保留新连接
+func (m *Manager) unregisterClient(client *ClientConn) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	
+	clientID := client.clientID
+	if m.clients[clientID] != client {
+		return
+	}
 	delete(m.clients, clientID)
 	
 	// 使用工作池处理数据库更新，避免创建新的goroutine
@@ -367,12 +467,14 @@ func (m *Manager) unregisterClient(clientID string) {
 	// 提交到工作池
 	if err := m.workerPool.Submit(task); err != nil {
 		// 工作池队列满，记录警告但不阻塞
-		log.Printf("Worker pool queue full, skipping status update for client %s: %v", clientID, err)
+		wsLog.Warnf("Worker pool queue full, skipping status update for client %s: %v", clientID, err)
 	}
 }
 
 // clientReader 客户端读取goroutine
 func (m *Manager) clientReader(client *ClientConn) {
+	// 拼接代理发来的分段消息
+	fragments := protocol.NewReassembler(protocol.ReassemblyLimit, fragmentTimeout)
 	for {
 		// 检查context是否被取消
 		select {
@@ -382,15 +484,19 @@ func (m *Manager) clientReader(client *ClientConn) {
 		}
 		
 		// 设置读取超时
-		client.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		client.conn.SetReadDeadline(time.Now().Add(client.readTimeout()))
 		
 		messageType, messageBytes, err := client.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error for client %s: %v", client.clientID, err)
+				wsLog.Errorf("WebSocket error for client %s: %v", client.clientID, err)
+			}
+			reason := DisconnectReadError
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				reason = DisconnectClientClosed
 			}
 			// 取消context通知另一个goroutine退出
-			client.cancel()
+			client.disconnect(reason)
 			return
 		}
 		
@@ -400,6 +506,19 @@ func (m *Manager) clientReader(client *ClientConn) {
 		client.lastActivity = time.Now()
 		client.mu.Unlock()
 		
+		// 分段收齐后作为一条文本消息处理
+		if messageType == websocket.BinaryMessage {
+			message, err := fragments.Add(messageBytes, time.Now())
+			if err != nil {
+				wsLog.Warnf("Dropped message fragment from client %s: %v", client.clientID, err)
+				continue
+			}
+			if message == nil {
+				continue
+			}
+			messageType, messageBytes = websocket.TextMessage, message
+		}
+		
 		// 处理消息
 		m.handleMessage(client, messageType, messageBytes)
 	}
@@ -407,10 +526,29 @@ func (m *Manager) clientReader(client *ClientConn) {
 
 // clientWriter 客户端写入goroutine
 func (m *Manager) clientWriter(client *ClientConn) {
-	// 使用配置的心跳间隔，而不是硬编码的30秒
-	ticker := time.NewTicker(m.config.PingInterval())
+	// 使用该连接的心跳间隔，而不是硬编码的30秒
+	interval, _ := client.getHeartbeat()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// 超过最大消息大小的消息拆分为分段，与队列中的其他消息交错发送
+	var box outbox
+	// 写入失败或连接断开时保存未发完的消息，恢复会话时由新连接重新发送
+	saveUnsent := func(unsent []queuedMessage) {
+		if len(unsent) == 0 {
+			return
+		}
+		client.mu.Lock()
+		client.unsent = unsent
+		client.mu.Unlock()
+	}
+	writeFailed := func(err error, unsent []queuedMessage) {
+		wsLog.Errorf("Failed to write message to client %s: %v", client.clientID, err)
+		saveUnsent(unsent)
+		// 取消context通知另一个goroutine退出
+		client.disconnect(DisconnectWriteError)
+	}
+
 	for {
 		select {
 		case message, ok := <-client.sendQueue:
@@ -420,38 +558,36 @@ func (m *Manager) clientWriter(client *ClientConn) {
 				return
 			}
 			
-			// 设置写入超时
-			client.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			
-			// 记录WebSocket数据发送流向日志
-			log.Printf("[WebSocket Send] Sending %d bytes to client %s", len(message), client.clientID)
-			
-			if err := client.conn.WriteMessage(websocket.TextMessage, message); err != nil {
-				log.Printf("Failed to write message to client %s: %v", client.clientID, err)
-				// 取消context通知另一个goroutine退出
-				client.cancel()
+			if err := m.sendQueued(client, &box, message); err != nil {
+				writeFailed(err, append(box.pending(), message))
+				return
+			}
+
+		case <-box.ready():
+			// 每轮只发送一个分段，其间可以发送心跳和其他消息
+			if err := m.writeNextFragment(client, &box); err != nil {
+				writeFailed(err, box.pending())
 				return
 			}
-			
-			log.Printf("[WebSocket Send] Successfully sent message to client %s", client.clientID)
-			
-			// 更新统计信息
-			client.mu.Lock()
-			client.bytesSent += int64(len(message))
-			client.mu.Unlock()
 
 		case <-ticker.C:
 			// 发送自定义协议的ping消息保持连接
-			log.Printf("[WebSocket Send] Sending protocol ping to client %s", client.clientID)
+			heartbeatLog.Debugf("[WebSocket Send] Sending protocol ping to client %s", client.clientID)
 			if err := m.sendProtocolPing(client.clientID); err != nil {
-				log.Printf("Failed to send protocol ping to client %s: %v", client.clientID, err)
+				heartbeatLog.Errorf("Failed to send protocol ping to client %s: %v", client.clientID, err)
+				saveUnsent(box.pending())
 				// 取消context通知另一个goroutine退出
-				client.cancel()
+				client.disconnect(DisconnectSendQueueFull)
 				return
 			}
-			log.Printf("[WebSocket Send] Successfully sent protocol ping to client %s", client.clientID)
+			heartbeatLog.Debugf("[WebSocket Send] Successfully sent protocol ping to client %s", client.clientID)
+
+		case <-client.heartbeatChanged:
+			interval, _ := client.getHeartbeat()
+			ticker.Reset(interval)
 
 		case <-client.ctx.Done():
+			saveUnsent(box.pending())
 			return
 		}
 	}
@@ -479,7 +615,7 @@ func (m *Manager) handleMessage(client *ClientConn, messageType int, data []byte
 	}
 	
 	if err := m.workerPool.Submit(task); err != nil {
-		log.Printf("Failed to submit message task: %v", err)
+		wsLog.Errorf("Failed to submit message task: %v", err)
 		
 		// 记录错误指标
 		if m.metrics != nil {
@@ -503,7 +639,7 @@ func (t *DatabaseUpdateTask) GetID() string {
 }
 
 func (t *DatabaseUpdateTask) GetPriority() int {
-	return 1 // 低优先级
+	return int(performance.PriorityLow)
 }
 
 func (t *DatabaseUpdateTask) Execute() performance.TaskResult {
@@ -539,9 +675,9 @@ func (t *MessageTask) GetID() string {
 	return t.id
 }
 
-// GetPriority 获取任务优先级
+// GetPriority 获取任务优先级，消息处理先于数据库状态更新
 func (t *MessageTask) GetPriority() int {
-	return 1 // 默认优先级
+	return int(performance.PriorityHigh)
 }
 
 // Execute 执行任务
@@ -565,7 +701,7 @@ func (t *MessageTask) Execute() performance.TaskResult {
 	// 首先检查消息类型，只对文本消息进行JSON解析
 	switch t.messageType {
 	case websocket.PingMessage:
-		log.Printf("Received ping from client %s", t.client.clientID)
+		heartbeatLog.Debugf("Received ping from client %s", t.client.clientID)
 		// 更新客户端最后活跃时间
 		now := time.Now()
 		t.client.mu.Lock()
@@ -575,7 +711,7 @@ func (t *MessageTask) Execute() performance.TaskResult {
 		return result
 		
 	case websocket.PongMessage:
-		log.Printf("Received pong from client %s", t.client.clientID)
+		heartbeatLog.Debugf("Received pong from client %s", t.client.clientID)
 		// 更新客户端最后活跃时间
 		now := time.Now()
 		t.client.mu.Lock()
@@ -585,12 +721,12 @@ func (t *MessageTask) Execute() performance.TaskResult {
 		return result
 		
 	case websocket.CloseMessage:
-		log.Printf("Received close message from client %s", t.client.clientID)
+		wsLog.Infof("Received close message from client %s", t.client.clientID)
 		result.Success = true
 		return result
 		
 	case websocket.BinaryMessage:
-		log.Printf("Received binary message from client %s, length: %d", t.client.clientID, len(t.data))
+		wsLog.Infof("Received binary message from client %s, length: %d", t.client.clientID, len(t.data))
 		// 对于二进制消息，我们暂时不处理，只记录
 		result.Success = true
 		return result
@@ -600,7 +736,7 @@ func (t *MessageTask) Execute() performance.TaskResult {
 		break
 		
 	default:
-		log.Printf("Received unknown message type %d from client %s", t.messageType, t.client.clientID)
+		wsLog.Warnf("Received unknown message type %d from client %s", t.messageType, t.client.clientID)
 		result.Success = true
 		return result
 	}
@@ -608,8 +744,8 @@ func (t *MessageTask) Execute() performance.TaskResult {
 	// 解析JSON消息（只有文本消息会到达这里）
 	var msg protocol.Message
 	if err := json.Unmarshal(t.data, &msg); err != nil {
-		log.Printf("Failed to parse JSON message from client %s: %v", t.client.clientID, err)
-		log.Printf("Raw message data (first 200 chars): %s", string(t.data[:min(len(t.data), 200)]))
+		wsLog.Errorf("Failed to parse JSON message from client %s: %v", t.client.clientID, err)
+		wsLog.Infof("Raw message data (first 200 chars): %s", logging.RedactBody(string(t.data[:min(len(t.data), 200)])))
 		
 		// 记录错误指标
 		if t.manager.metrics != nil {
@@ -620,7 +756,31 @@ func (t *MessageTask) Execute() performance.TaskResult {
 		
 		// 对于JSON解析失败，我们不应该返回错误，而是忽略这个消息
 		// 这通常发生在连接断开时收到的非JSON数据
-		log.Printf("Ignoring non-JSON message from client %s", t.client.clientID)
+		wsLog.Infof("Ignoring non-JSON message from client %s", t.client.clientID)
+		result.Success = true
+		return result
+	}
+
+	// 校验签名并拒绝重放的消息，可能来自被劫持的连接
+	if err := t.manager.verifyMessage(t.client, &msg); err != nil {
+		wsLog.Warnf("Rejected %s message from client %s: %v", msg.Op, t.client.clientID, err)
+		if t.manager.metrics != nil {
+			if collector, ok := t.manager.metrics.(interface{ IncrementErrors() }); ok {
+				collector.IncrementErrors()
+			}
+		}
+		result.Success = true
+		return result
+	}
+
+	// 解密启用加密的连接上的载荷，无法解密的消息丢弃
+	if err := msg.Open(t.client.getSessionCipher()); err != nil {
+		wsLog.Warnf("Dropping %s message from client %s: %v", msg.Op, t.client.clientID, err)
+		result.Success = true
+		return result
+	}
+	if err := msg.Decompress(); err != nil {
+		wsLog.Warnf("Dropping %s message from client %s: %v", msg.Op, t.client.clientID, err)
 		result.Success = true
 		return result
 	}
@@ -636,7 +796,7 @@ func (t *MessageTask) Execute() performance.TaskResult {
 	case protocol.MessageTypeError:
 		t.manager.handleErrorMessage(t.client, &msg)
 	default:
-		log.Printf("Unknown message type: %s", msg.Type)
+		wsLog.Warnf("Unknown message type: %s", msg.Type)
 	}
 
 	result.Success = true
@@ -748,24 +908,13 @@ func (m *Manager) validateToken(tokenString string) bool {
 	return true
 }
 
-// processBatch 批处理消息
-func (m *Manager) processBatch(messages []*performance.QueueMessage) error {
-	for _, msg := range messages {
-		log.Printf("Processing batch message: %s", msg.ID)
-	}
-	return nil
-}
-
 // startBackgroundTasks 启动后台任务
 func (m *Manager) startBackgroundTasks() {
-	// 启动心跳批量处理goroutine
-	go m.processHeartbeatUpdates()
-	
 	// 定期清理超时的待处理请求
 	cleanupTicker := time.NewTicker(30 * time.Second)
 	
-	// 定期健康检查
-	healthCheckTicker := time.NewTicker(m.config.PingInterval())
+	// 定期健康检查，每个连接的心跳超时不同，按秒检查
+	healthCheckTicker := time.NewTicker(healthCheckInterval)
 	
 	// 在goroutine中运行，确保ticker能被正确停止
 	go func() {
@@ -780,6 +929,8 @@ func (m *Manager) startBackgroundTasks() {
 				return
 			case <-cleanupTicker.C:
 				m.cleanupExpiredPending()
+				m.pruneCompleted()
+				m.sweepStalePending()
 			case <-healthCheckTicker.C:
 				m.performHealthCheck()
 			}
@@ -794,7 +945,7 @@ func (m *Manager) cleanupExpiredPending() {
 	
 	now := time.Now()
 	for msgID, pending := range m.pending {
-		if now.Sub(pending.createdAt) > m.config.RequestTimeout() {
+		if !pending.uploading && now.Sub(pending.createdAt) > m.config.RequestTimeout() {
 			pending.cancel()
 			delete(m.pending, msgID)
 		}
@@ -811,57 +962,56 @@ func (m *Manager) performHealthCheck() {
 	m.mu.RUnlock()
 	
 	now := time.Now()
-	pingInterval := m.config.PingInterval()
 	
 	for _, client := range clients {
 		client.mu.Lock()
 		lastSeen := client.lastSeen
+		timeout := client.heartbeatTimeout
 		client.mu.Unlock()
 		
-		// 使用配置的心跳间隔检查超时，允许3倍的容错时间
-		timeout := pingInterval * 3
+		// 使用该连接的心跳超时检查
 		if now.Sub(lastSeen) > timeout {
-			log.Printf("Client %s inactive for %v (threshold: %v), disconnecting", 
+			wsLog.Infof("Client %s inactive for %v (threshold: %v), disconnecting", 
 				client.clientID, now.Sub(lastSeen), timeout)
-			client.cancel()
+			client.disconnect(DisconnectHeartbeatTimeout)
 		}
 	}
 }
 
-// processHeartbeatUpdates 批量处理心跳更新
-func (m *Manager) processHeartbeatUpdates() {
-	ticker := time.NewTicker(2 * time.Second)
-	defer ticker.Stop()
-	
-	updates := make(map[string]time.Time)
-	
-	for {
-		select {
-		case <-m.ctx.Done():
-			return
-		case update := <-m.heartbeatQueue:
-			updates[update.ClientID] = update.LastActiveTime
-		case <-ticker.C:
-			if len(updates) > 0 {
-				m.batchUpdateHeartbeats(updates)
-				updates = make(map[string]time.Time)
-			}
-		}
+// recordLatency 记录一次请求的往返延迟，保留最近的样本计算平均值
+func (m *Manager) recordLatency(clientID string, latency time.Duration) {
+	client := m.getClient(clientID)
+	if client == nil {
+		return
 	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.rttSamples) >= maxLatencySamples {
+		client.rttSamples = client.rttSamples[1:]
+	}
+	client.rttSamples = append(client.rttSamples, latency)
+	var total time.Duration
+	for _, sample := range client.rttSamples {
+		total += sample
+	}
+	client.avgRTT = total / time.Duration(len(client.rttSamples))
+	client.latencyUpdatedAt = time.Now()
 }
 
-// batchUpdateHeartbeats 批量更新心跳时间
-func (m *Manager) batchUpdateHeartbeats(updates map[string]time.Time) {
-	if len(updates) == 0 {
-		return
+// ClientLatency 返回客户端最近的平均响应延迟，没有测量数据或数据已过期时第二个返回值为false
+func (m *Manager) ClientLatency(clientID string) (time.Duration, bool) {
+	client := m.getClient(clientID)
+	if client == nil {
+		return 0, false
 	}
-	
-	log.Printf("批量更新心跳时间，客户端数量: %d", len(updates))
-	for clientID, lastActiveTime := range updates {
-		if err := m.db.UpdateClientLastActiveTime(clientID, lastActiveTime); err != nil {
-			log.Printf("Failed to update client %s last active time: %v", clientID, err)
-		}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.latencyUpdatedAt.IsZero() || time.Since(client.latencyUpdatedAt) > latencyStaleAfter {
+		return 0, false
 	}
+	return client.avgRTT, true
 }
 
 // periodicCleanup 定期清理
@@ -875,7 +1025,7 @@ func (m *Manager) periodicCleanup() {
 			return
 		case <-ticker.C:
 			// 执行清理任务
-			log.Printf("Performing periodic cleanup")
+			wsLog.Infof("Performing periodic cleanup")
 		}
 	}
 }
@@ -911,14 +1061,14 @@ func (m *Manager) SendToClient(clientID string, msg *protocol.Message) error {
 	}
 	
 	// 序列化消息
-	data, err := json.Marshal(msg)
+	data, err := m.encodeMessage(client, msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %v", err)
 	}
 	
 	// 发送到客户端队列
 	select {
-	case client.sendQueue <- data:
+	case client.sendQueue <- newQueuedMessage(msg, data):
 		// 更新统计信息
 		client.mu.Lock()
 		client.messageCount++
@@ -947,13 +1097,35 @@ func (m *Manager) DisconnectClient(clientID string) error {
 		return fmt.Errorf("client %s not found", clientID)
 	}
 	
-	// 取消客户端上下文，这将触发连接关闭
-	client.cancel()
+	// 取消客户端上下文并关闭连接
+	client.disconnect(DisconnectAdmin)
 	
-	log.Printf("Disconnected client %s", clientID)
+	wsLog.Infof("Disconnected client %s", clientID)
 	return nil
 }
 
+// DrainClients 在 period 内逐个断开所有代理连接，代理分批重新连接到接替的新进程，避免同时断开后集中重连
+func (m *Manager) DrainClients(period time.Duration) {
+	m.mu.RLock()
+	clients := make([]*ClientConn, 0, len(m.clients))
+	for _, client := range m.clients {
+		clients = append(clients, client)
+	}
+	m.mu.RUnlock()
+	if len(clients) == 0 {
+		return
+	}
+
+	wsLog.Infof("Draining %d client connections over %v", len(clients), period)
+	interval := period / time.Duration(len(clients))
+	for i, client := range clients {
+		if i > 0 {
+			time.Sleep(interval)
+		}
+		client.disconnect(DisconnectServerShutdown)
+	}
+}
+
 // Close 关闭管理器
 func (m *Manager) Close() {
 	m.cancel()
@@ -961,13 +1133,13 @@ func (m *Manager) Close() {
 	// 停止批处理器
 	if m.batchProcessor != nil {
 		m.batchProcessor.Stop()
-		log.Println("Batch processor stopped")
+		wsLog.Infof("Batch processor stopped")
 	}
 	
 	// 关闭消息队列
 	if m.messageQueue != nil {
 		m.messageQueue.Close()
-		log.Println("Message queue closed")
+		wsLog.Infof("Message queue closed")
 	}
 	
 	m.mu.Lock()
@@ -975,7 +1147,7 @@ func (m *Manager) Close() {
 	
 	// 关闭所有客户端连接
 	for _, client := range m.clients {
-		client.cancel()
+		client.disconnect(DisconnectServerShutdown)
 	}
 	
 	// 取消所有待处理的请求
@@ -983,5 +1155,5 @@ func (m *Manager) Close() {
 		pending.cancel()
 	}
 	
-	log.Println("WebSocket manager resources cleaned up")
+	wsLog.Infof("WebSocket manager resources cleaned up")
 }
\ No newline at end of file
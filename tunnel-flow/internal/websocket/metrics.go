@@ -0,0 +1,68 @@
+package websocket
+
+import (
+	"time"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// AgentMetrics 代理最近一次上报的计数器
+type AgentMetrics struct {
+	protocol.MetricsPayload
+	ReceivedAt int64 `json:"received_at"` // 服务端收到上报的时间（毫秒）
+}
+
+// ClientMetrics 服务端对单个客户端连接的统计
+type ClientMetrics struct {
+	ConnectedAt    int64   `json:"connected_at"`  // 本次连接建立的时间（毫秒）
+	LastSeen       int64   `json:"last_seen"`     // 最近收到消息的时间（毫秒）
+	MessagesSent   int64   `json:"messages_sent"` // 发送给代理的消息数
+	BytesSent      int64   `json:"bytes_sent"`    // 发送给代理的字节数
+	AvgRTTMS       int64   `json:"avg_rtt_ms"`    // 服务端测得的平均往返延迟
+	PacketLoss     float64 `json:"packet_loss"`   // 心跳丢包率
+	NetworkQuality string  `json:"network_quality"`
+}
+
+// handleMetrics 处理代理计数器上报，只在内存中保留最新一次
+func (m *Manager) handleMetrics(client *ClientConn, msg *protocol.Message) {
+	var payload protocol.MetricsPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		wsLog.Errorf("Failed to parse metrics from client %s: %v", client.clientID, err)
+		return
+	}
+
+	m.agentMetricsMu.Lock()
+	m.agentMetrics[client.clientID] = &AgentMetrics{MetricsPayload: payload, ReceivedAt: time.Now().UnixMilli()}
+	m.agentMetricsMu.Unlock()
+}
+
+// AgentMetrics 返回代理最近一次上报的计数器，没有上报过时第二个返回值为false
+func (m *Manager) AgentMetrics(clientID string) (AgentMetrics, bool) {
+	m.agentMetricsMu.RLock()
+	defer m.agentMetricsMu.RUnlock()
+	metrics, exists := m.agentMetrics[clientID]
+	if !exists {
+		return AgentMetrics{}, false
+	}
+	return *metrics, true
+}
+
+// ClientMetrics 返回服务端对客户端当前连接的统计，客户端不在线时第二个返回值为false
+func (m *Manager) ClientMetrics(clientID string) (ClientMetrics, bool) {
+	client := m.getClient(clientID)
+	if client == nil {
+		return ClientMetrics{}, false
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return ClientMetrics{
+		ConnectedAt:    client.connectedAt.UnixMilli(),
+		LastSeen:       client.lastSeen.UnixMilli(),
+		MessagesSent:   client.messageCount,
+		BytesSent:      client.bytesSent,
+		AvgRTTMS:       client.avgRTT.Milliseconds(),
+		PacketLoss:     client.packetLoss,
+		NetworkQuality: client.networkQuality,
+	}, true
+}
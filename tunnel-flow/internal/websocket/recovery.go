@@ -0,0 +1,112 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"tunnel-flow/internal/database"
+)
+
+// 启动恢复：服务端异常退出后，数据库中的客户端仍是在线状态，已发给代理的请求停留在 pending 或 processing，
+// 创建管理器时（还没有接受连接）把客户端设置为离线，上次运行遗留的消息按投递策略重新排队或标记为失败，
+// 开启 reuse_port 时新旧进程同时运行，不做恢复
+
+// recoveryBatchSize 每次读取的遗留消息数
+const recoveryBatchSize = 500
+
+// recoverySummary 启动恢复的结果
+type recoverySummary struct {
+	clientsOffline int64
+	requeued       int
+	failed         int
+	deadLettered   int
+}
+
+// recoverAfterRestart 恢复上次运行遗留的状态并输出汇总，出错时只记录日志，不影响启动
+func (m *Manager) recoverAfterRestart() {
+	if m.config.ReusePort {
+		// 不中断重启时旧进程可能仍在服务这些客户端和消息，遗留的消息到期后由清理任务处理
+		wsLog.Infof("Startup recovery skipped: reuse_port is enabled and a previous process may still be draining")
+		return
+	}
+	start := time.Now()
+	var summary recoverySummary
+
+	offline, err := m.db.CleanupStaleClients()
+	if err != nil {
+		wsLog.Errorf("Startup recovery: failed to mark clients offline: %v", err)
+	}
+	summary.clientsOffline = offline
+
+	if err := m.recoverPendingMessages(start.UnixMilli(), &summary); err != nil {
+		wsLog.Errorf("Startup recovery: failed to recover pending messages: %v", err)
+	}
+
+	wsLog.Infof("Startup recovery finished in %v: %d clients marked offline, %d messages requeued, %d marked failed, %d moved to dead letter queue",
+		time.Since(start).Round(time.Millisecond), summary.clientsOffline, summary.requeued, summary.failed, summary.deadLettered)
+}
+
+// recoverPendingMessages 处理启动前最后更新的 pending 和 processing 消息：
+// at_least_once 的消息重新排队，等代理重新连接后由清理任务重新投递，其余标记为失败，重新投递次数用完的移入死信队列
+func (m *Manager) recoverPendingMessages(bootTS int64, summary *recoverySummary) error {
+	// 给代理留出重新连接的时间
+	nextTry := time.Now().Add(retryBackoff).UnixMilli()
+	for {
+		messages, err := m.db.ListUnfinishedPendingMessages(bootTS, recoveryBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		progressed := false
+		for _, msg := range messages {
+			updated, err := m.recoverPendingMessage(msg, nextTry, summary)
+			if err != nil {
+				return fmt.Errorf("message %s: %w", msg.MsgID, err)
+			}
+			progressed = progressed || updated
+		}
+		// 本批消息都没有更新时停止，避免反复读取同一批
+		if !progressed {
+			return nil
+		}
+	}
+}
+
+// recoverPendingMessage 按投递策略处理一条遗留消息，返回是否更新了消息状态
+func (m *Manager) recoverPendingMessage(msg *database.PendingMessage, nextTry int64, summary *recoverySummary) (bool, error) {
+	meta, err := msg.GetRequestMeta()
+	if err != nil {
+		return m.failRecoveredMessage(msg, fmt.Sprintf("server restarted, invalid request meta: %v", err), summary)
+	}
+	switch {
+	case meta.DeliveryPolicy != database.DeliveryPolicyAtLeastOnce:
+		return m.failRecoveredMessage(msg, "server restarted before a response was received", summary)
+	case meta.BodyStreamed:
+		return m.failRecoveredMessage(msg, "server restarted before a response was received, streamed request body cannot be redelivered", summary)
+	case msg.RetryCount >= maxDeliveryRetries:
+		reason := fmt.Sprintf("server restarted after %d redeliveries", msg.RetryCount)
+		moved, err := m.db.DeadLetterPendingMessage(msg.MsgID, reason)
+		if moved {
+			summary.deadLettered++
+		}
+		return moved, err
+	default:
+		requeued, err := m.db.RequeuePendingMessage(msg.MsgID, nextTry, "server restarted before a response was received")
+		if requeued {
+			summary.requeued++
+		}
+		return requeued, err
+	}
+}
+
+// failRecoveredMessage 把遗留消息标记为失败
+func (m *Manager) failRecoveredMessage(msg *database.PendingMessage, reason string, summary *recoverySummary) (bool, error) {
+	updated, err := m.db.ExpirePendingMessage(msg.MsgID, reason)
+	if updated {
+		summary.failed++
+	}
+	return updated, err
+}
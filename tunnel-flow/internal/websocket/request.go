@@ -2,8 +2,9 @@ package websocket
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"time"
 
 	"github.com/google/uuid"
@@ -12,10 +13,44 @@ import (
 	"tunnel-flow/internal/protocol"
 )
 
+// ErrIdempotencyKeyInUse 请求的幂等键已被另一条待处理消息占用
+var ErrIdempotencyKeyInUse = errors.New("idempotency key is already in use")
+
+// ErrRequestTimeout 超时仍未收到客户端的响应
+var ErrRequestTimeout = errors.New("request timeout")
+
+// idempotencyKeyContext 上下文中幂等键的键类型
+type idempotencyKeyContext struct{}
+
+// WithIdempotencyKey 为请求附加幂等键，创建待处理消息时占用该键，键已被占用时请求不会发送
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContext{}, key)
+}
+
+// unrecordedContext 上下文中不记录请求的标记的键类型
+type unrecordedContext struct{}
+
+// WithoutRecord 标记请求不保存为待处理消息、不写审计日志，用于隧道压测等合成请求
+// 这些请求不出现在 /messages 中，超时后也不会重新投递
+func WithoutRecord(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unrecordedContext{}, true)
+}
+
 // SendRequestAndWait 发送请求并等待响应
 func (m *Manager) SendRequestAndWait(clientID string, requestPayload *protocol.RequestPayload, timeout time.Duration) (*protocol.ResponsePayload, error) {
+	return m.SendRequestAndWaitContext(context.Background(), clientID, requestPayload, timeout)
+}
+
+// SendRequestAndWaitContext 发送请求并等待响应，parent 取消时停止等待并通知客户端取消该请求
+func (m *Manager) SendRequestAndWaitContext(parent context.Context, clientID string, requestPayload *protocol.RequestPayload, timeout time.Duration) (*protocol.ResponsePayload, error) {
+	return m.sendRequestAndWait(parent, clientID, requestPayload, nil, timeout)
+}
+
+// sendRequestAndWait 发送请求，body 不为空时随后分片发送请求体，然后等待响应
+func (m *Manager) sendRequestAndWait(parent context.Context, clientID string, requestPayload *protocol.RequestPayload, body io.Reader, timeout time.Duration) (*protocol.ResponsePayload, error) {
 	// 检查客户端是否连接
-	if !m.IsClientConnected(clientID) {
+	conn := m.getClient(clientID)
+	if conn == nil {
 		return nil, fmt.Errorf("client %s is not connected", clientID)
 	}
 	
@@ -31,16 +66,22 @@ func (m *Manager) SendRequestAndWait(clientID string, requestPayload *protocol.R
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request message: %w", err)
 	}
+	// 路由覆盖了载荷压缩设置时，请求和请求体分片按路由的设置压缩
+	requestMsg.UseCompression(requestPayload.Compression)
 	
 	// 创建等待上下文
 	resultCh := make(chan *protocol.ResponsePayload, 1)
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	ctx, cancel := context.WithCancel(parent)
 	pending := &PendingContext{
-		msgID:     msgID,
-		resultCh:  resultCh,
-		ctx:       ctx,
-		cancel:    cancel,
-		createdAt: time.Now(),
+		msgID:      msgID,
+		resultCh:   resultCh,
+		ctx:        ctx,
+		cancel:     cancel,
+		createdAt:  time.Now(),
+		uploading:  body != nil,
+		conn:       conn,
+		idempotent: parent.Value(idempotencyKeyContext{}) != nil,
+		unrecorded: parent.Value(unrecordedContext{}) != nil,
 	}
 	
 	// 注册等待的请求
@@ -49,7 +90,7 @@ func (m *Manager) SendRequestAndWait(clientID string, requestPayload *protocol.R
 	pendingCount := len(m.pending)
 	m.mu.Unlock()
 	
-	log.Printf("[SendRequestAndWait] Registered pending request %s, total pending: %d", msgID, pendingCount)
+	wsLog.Infof("[SendRequestAndWait] Registered pending request %s, total pending: %d", msgID, pendingCount)
 	
 	// 确保在函数结束时清理
 	defer func() {
@@ -59,7 +100,7 @@ func (m *Manager) SendRequestAndWait(clientID string, requestPayload *protocol.R
 		m.mu.Unlock()
 		cancel()
 		close(resultCh)
-		log.Printf("[SendRequestAndWait] Cleaned up pending request %s, remaining pending: %d", msgID, remainingCount)
+		wsLog.Infof("[SendRequestAndWait] Cleaned up pending request %s, remaining pending: %d", msgID, remainingCount)
 	}()
 	
 	// 保存到数据库
@@ -71,6 +112,8 @@ func (m *Manager) SendRequestAndWait(clientID string, requestPayload *protocol.R
 		TimeoutMS:      requestPayload.TimeoutMS,
 		TargetsJSON:    requestPayload.TargetsJSON,
 		DeliveryPolicy: requestPayload.DeliveryPolicy,
+		RouteMode:      requestPayload.RouteMode,
+		BodyStreamed:   requestPayload.BodyStreamed,
 	}
 	
 	// 创建待处理消息
@@ -81,85 +124,128 @@ func (m *Manager) SendRequestAndWait(clientID string, requestPayload *protocol.R
 		State:       database.MessageStatePending,
 		CreatedAt:   time.Now().UnixMilli(),
 		LastUpdate:  time.Now().UnixMilli(),
+		NextTryTS:   time.Now().Add(timeout).UnixMilli(), // 请求体发送完成后重新计算
 	}
+	pendingMsg.IdempotencyKey, _ = parent.Value(idempotencyKeyContext{}).(string)
 	
 	// 设置请求元数据
 	if err := pendingMsg.SetRequestMeta(requestMeta); err != nil {
-		log.Printf("Failed to marshal request meta: %v", err)
+		wsLog.Errorf("Failed to marshal request meta: %v", err)
 	}
 	
-	if err := m.db.CreatePendingMessage(pendingMsg); err != nil {
+	if pending.unrecorded {
+		// 不保存
+	} else if err := m.db.CreatePendingMessage(pendingMsg); err != nil {
+		if pendingMsg.IdempotencyKey != "" && database.IsUniqueConstraintError(err) {
+			return nil, ErrIdempotencyKeyInUse
+		}
 
-		log.Printf("Failed to save pending message to database: %v", err)
+		wsLog.Errorf("Failed to save pending message to database: %v", err)
 		// 继续执行，不因为数据库错误而失败
 	}
 	
 	// 发送消息
-	log.Printf("[SendRequestAndWait] Sending request %s to client %s: %s %s", msgID, clientID, requestPayload.HTTPMethod, requestPayload.URLSuffix)
+	wsLog.Infof("[SendRequestAndWait] Sending request %s to client %s: %s %s", msgID, clientID, requestPayload.HTTPMethod, requestPayload.URLSuffix)
+	sentAt := time.Now()
 	if err := m.SendToClient(clientID, requestMsg); err != nil {
-		log.Printf("[SendRequestAndWait] Failed to send request %s to client %s: %v", msgID, clientID, err)
+		wsLog.Errorf("[SendRequestAndWait] Failed to send request %s to client %s: %v", msgID, clientID, err)
+		m.failRequest(pending, database.MessageStateFailed, err.Error())
 		return nil, fmt.Errorf("failed to send request to client: %w", err)
 	}
 	
-	log.Printf("[SendRequestAndWait] Successfully sent request %s to client %s, waiting for response...", msgID, clientID)
+	wsLog.Infof("[SendRequestAndWait] Successfully sent request %s to client %s, waiting for response...", msgID, clientID)
+	
+	if body != nil {
+		if err := m.streamRequestBody(ctx, conn, msgID, body, requestPayload.Compression); err != nil {
+			wsLog.Errorf("[SendRequestAndWait] Failed to stream request body of %s to client %s: %v", msgID, clientID, err)
+			state := database.MessageStateFailed
+			if parent.Err() != nil {
+				m.sendCancel(clientID, msgID)
+				state = database.MessageStateCancelled
+			}
+			m.failRequest(pending, state, err.Error())
+			return nil, fmt.Errorf("failed to stream request body: %w", err)
+		}
+		// 等待响应的时间从请求体发送完成时开始计算
+		sentAt = time.Now()
+		m.mu.Lock()
+		pending.createdAt = sentAt
+		pending.uploading = false
+		m.mu.Unlock()
+	}
+	if !pending.unrecorded {
+		m.updateMessage(&database.MessageUpdate{
+			MsgID:       msgID,
+			State:       database.MessageStateProcessing,
+			NextTryTS:   sentAt.Add(timeout).UnixMilli(),
+			FromPending: true,
+		})
+		m.recordAudit(msgID, clientID, database.DirectionOutbound, requestPayload.HTTPMethod+" "+requestPayload.URLSuffix)
+	}
+	timer := time.AfterFunc(timeout, cancel)
+	defer timer.Stop()
 	
 	// 等待响应或超时
-	log.Printf("[SendRequestAndWait] Waiting for response to request %s (timeout: %v)", msgID, timeout)
+	wsLog.Infof("[SendRequestAndWait] Waiting for response to request %s (timeout: %v)", msgID, timeout)
 	
 	select {
 	case response := <-resultCh:
 		if response == nil {
-			log.Printf("[SendRequestAndWait] Received nil response for request %s", msgID)
+			wsLog.Infof("[SendRequestAndWait] Received nil response for request %s", msgID)
 			return nil, fmt.Errorf("received nil response")
 		}
-		log.Printf("[SendRequestAndWait] Successfully received response for request %s - Status: %d", msgID, response.HTTPStatus)
+		wsLog.Infof("[SendRequestAndWait] Successfully received response for request %s - Status: %d", msgID, response.HTTPStatus)
+		m.recordLatency(clientID, time.Since(sentAt))
 		return response, nil
 
 	case <-pending.ctx.Done():
-		log.Printf("[SendRequestAndWait] Request %s timed out after %v", msgID, timeout)
-		// 超时，更新数据库状态
-		if err := m.db.UpdatePendingMessageState(msgID, database.MessageStateFailed); err != nil {
-			log.Printf("[SendRequestAndWait] Failed to update message state to timeout for %s: %v", msgID, err)
+		if parent.Err() != nil {
+			wsLog.Warnf("[SendRequestAndWait] Request %s cancelled: %v", msgID, parent.Err())
+			m.sendCancel(clientID, msgID)
+			m.failRequest(pending, database.MessageStateCancelled, parent.Err().Error())
+			return nil, parent.Err()
 		}
-		return nil, fmt.Errorf("request timeout after %v", timeout)
+		m.mu.RLock()
+		failure := pending.err
+		m.mu.RUnlock()
+		if failure != nil {
+			wsLog.Warnf("[SendRequestAndWait] Request %s failed: %v", msgID, failure)
+			m.failRequest(pending, database.MessageStateFailed, failure.Error())
+			return nil, failure
+		}
+		wsLog.Warnf("[SendRequestAndWait] Request %s timed out after %v", msgID, timeout)
+		// 超时，更新数据库状态
+		timeoutErr := fmt.Errorf("%w after %v", ErrRequestTimeout, timeout)
+		m.failRequest(pending, database.MessageStateFailed, timeoutErr.Error())
+		return nil, timeoutErr
 	}
 }
 
 
 
-// SendRouteSync 发送路由同步消息（如果需要）
-func (m *Manager) SendRouteSync(clientID string) error {
-	// 获取客户端的路由
-	routes, err := m.db.ListServerRoutes()
-	if err != nil {
-		return fmt.Errorf("failed to get routes for client %s: %w", clientID, err)
-	}
-	
-	// 过滤属于该客户端的路由
-	clientRoutes := make([]*database.ServerRoute, 0)
-	for _, route := range routes {
-		if route.ClientID == clientID {
-			clientRoutes = append(clientRoutes, route)
-		}
+// failRequest 把请求的消息标记为失败或取消，不记录的请求没有消息
+func (m *Manager) failRequest(pending *PendingContext, state, reason string) {
+	if !pending.unrecorded {
+		m.failMessage(pending.msgID, state, reason)
 	}
-	
-	// 构建路由同步载荷
-	routeSyncPayload := map[string]interface{}{
-		"routes": clientRoutes,
-	}
-	
-	routeSyncMsg, err := protocol.NewMessage(
+}
+
+// sendCancel 通知客户端放弃仍在处理的请求，不支持取消的旧版代理会忽略该消息
+func (m *Manager) sendCancel(clientID, msgID string) {
+	cancelMsg, err := protocol.NewMessage(
 		protocol.MessageTypeControl,
-		protocol.OpRouteSync,
+		protocol.OpCancel,
 		clientID,
+		&msgID,
 		nil,
-		routeSyncPayload,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create route sync message: %w", err)
+		wsLog.Errorf("Failed to create cancel message: %v", err)
+		return
+	}
+	if err := m.SendToClient(clientID, cancelMsg); err != nil {
+		wsLog.Errorf("Failed to send cancel for request %s to client %s: %v", msgID, clientID, err)
 	}
-	
-	return m.SendToClient(clientID, routeSyncMsg)
 }
 
 // GetConnectedClientCount 获取已连接客户端数量
@@ -188,7 +274,7 @@ func (m *Manager) CleanupExpiredPending(maxAge time.Duration) {
 	expired := make([]string, 0)
 	
 	for msgID, pending := range m.pending {
-		if now.Sub(pending.createdAt) > maxAge {
+		if !pending.uploading && now.Sub(pending.createdAt) > maxAge {
 			expired = append(expired, msgID)
 		}
 	}
@@ -208,7 +294,7 @@ func (m *Manager) CleanupExpiredPending(maxAge time.Duration) {
 	}
 	
 	if len(expired) > 0 {
-		log.Printf("Cleaned up %d expired pending requests", len(expired))
+		wsLog.Infof("Cleaned up %d expired pending requests", len(expired))
 	}
 }
 
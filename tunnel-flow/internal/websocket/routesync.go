@@ -0,0 +1,55 @@
+package websocket
+
+import (
+	"tunnel-flow/internal/protocol"
+)
+
+// RouteSyncFunc 处理代理上报的声明路由，返回每条路由的处理结果和删除的路由路径
+type RouteSyncFunc func(clientID string, routes []protocol.DeclaredRoute) ([]protocol.DeclaredRouteResult, []string)
+
+// handleRouteSync 处理代理注册后上报的声明路由，处理结果通过 ROUTE_SYNC_ACK 返回给代理
+func (m *Manager) handleRouteSync(client *ClientConn, msg *protocol.Message) {
+	var payload protocol.RouteSyncPayload
+	if err := msg.ParsePayload(&payload); err != nil {
+		wsLog.Errorf("Failed to parse route sync from client %s: %v", client.clientID, err)
+		return
+	}
+
+	ack := &protocol.RouteSyncAckPayload{}
+	if m.OnRouteSync != nil {
+		ack.Results, ack.Removed = m.OnRouteSync(client.clientID, payload.Routes)
+	} else {
+		for _, route := range payload.Routes {
+			ack.Results = append(ack.Results, protocol.DeclaredRouteResult{
+				URLSuffix: route.URLSuffix,
+				Status:    protocol.RouteSyncRejected,
+				Reason:    "agent-declared routes are not supported by this server",
+			})
+		}
+	}
+	if ack.Results == nil {
+		ack.Results = []protocol.DeclaredRouteResult{}
+	}
+
+	ackMsg, err := protocol.NewMessage(protocol.MessageTypeControl, protocol.OpRouteSyncAck, client.clientID, msg.MsgID, ack)
+	if err != nil {
+		wsLog.Errorf("Failed to create route sync ack for client %s: %v", client.clientID, err)
+		return
+	}
+	if err := m.SendToClient(client.clientID, ackMsg); err != nil {
+		wsLog.Errorf("Failed to send route sync ack to client %s: %v", client.clientID, err)
+	}
+}
+
+// PushRouteDecision 将管理员对声明路由的审批结果推送给代理，代理不在线时忽略，代理下次注册同步时会再次收到结果
+func (m *Manager) PushRouteDecision(clientID string, result protocol.DeclaredRouteResult) error {
+	if m.getClient(clientID) == nil {
+		return nil
+	}
+	msg, err := protocol.NewMessage(protocol.MessageTypeControl, protocol.OpRouteSyncAck, clientID, nil,
+		&protocol.RouteSyncAckPayload{Results: []protocol.DeclaredRouteResult{result}})
+	if err != nil {
+		return err
+	}
+	return m.SendToClient(clientID, msg)
+}
@@ -0,0 +1,241 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// 会话恢复：支持会话恢复的代理注册成功后，注册确认中带上该连接的恢复令牌。
+// 连接意外断开后会话保留 resume_grace_seconds 秒，代理在此期间带上令牌重新注册时，
+// 等待旧连接响应的请求改为等待新连接，旧连接发送队列中尚未发出的消息改由新连接发送，发送中的请求体继续发送。
+// 保留时间内没有恢复、代理以新的进程重连或不能恢复的断开（如代理主动关闭）时，等待该连接的请求立即失败
+
+// ErrClientDisconnected 代理断开了连接且没有恢复会话，请求不会再收到响应
+var ErrClientDisconnected = errors.New("client disconnected")
+
+// resumeWait 新连接替换仍在线的旧连接时，等待旧连接退出并保留会话的最长时间
+const resumeWait = 5 * time.Second
+
+// detachedSession 连接断开后保留的会话
+type detachedSession struct {
+	token  string
+	client *ClientConn // 断开的连接，发送队列中保留着尚未发出的消息
+	timer  *time.Timer
+	done   chan struct{} // 会话恢复或结束后关闭
+	next   *ClientConn   // 恢复会话的新连接，会话结束时为nil
+}
+
+// issueResumeToken 为支持会话恢复的代理生成该连接的恢复令牌，服务端不保留会话时返回空
+func (m *Manager) issueResumeToken(client *ClientConn) string {
+	if m.config.ResumeGrace() <= 0 || !client.hasCapability(protocol.CapabilitySessionResume) {
+		return ""
+	}
+	token := uuid.New().String()
+	client.mu.Lock()
+	client.resumeToken = token
+	client.mu.Unlock()
+	return token
+}
+
+// resumable 可以恢复会话的断开原因，代理主动关闭、被管理员断开、注册被拒绝或服务端停止时不保留会话
+func resumable(reason string) bool {
+	switch reason {
+	case DisconnectClientClosed, DisconnectAdmin, DisconnectRejected, DisconnectServerShutdown:
+		return false
+	}
+	return true
+}
+
+// detachSession 连接断开时保留会话等待代理恢复，不能恢复时让等待该连接的请求立即失败
+// 在注销连接之前调用，代理重连注册时总能找到保留的会话
+func (m *Manager) detachSession(client *ClientConn, reason string) {
+	defer close(client.detached)
+
+	client.mu.Lock()
+	token := client.resumeToken
+	client.mu.Unlock()
+	if token == "" {
+		// 不支持会话恢复的代理重连后仍可能补发暂存的响应，请求等到超时
+		return
+	}
+	if !resumable(reason) || m.ctx.Err() != nil {
+		m.failPending(client)
+		return
+	}
+
+	session := &detachedSession{token: token, client: client, done: make(chan struct{})}
+	m.sessionsMu.Lock()
+	if previous := m.sessions[client.clientID]; previous != nil {
+		m.endSessionLocked(previous)
+	}
+	m.sessions[client.clientID] = session
+	session.timer = time.AfterFunc(m.config.ResumeGrace(), func() {
+		m.sessionsMu.Lock()
+		defer m.sessionsMu.Unlock()
+		if m.sessions[client.clientID] == session {
+			wsLog.Infof("Session of client %s was not resumed within %v", client.clientID, m.config.ResumeGrace())
+			m.endSessionLocked(session)
+		}
+	})
+	m.sessionsMu.Unlock()
+	client.session = session
+	wsLog.Infof("Keeping session of client %s for %v", client.clientID, m.config.ResumeGrace())
+}
+
+// endSessionLocked 结束没有恢复的会话，等待断开连接的请求立即失败，调用方需持有 sessionsMu
+func (m *Manager) endSessionLocked(session *detachedSession) {
+	delete(m.sessions, session.client.clientID)
+	session.timer.Stop()
+	m.failPending(session.client)
+	close(session.done)
+}
+
+// takeSession 代理重新注册时取出保留的会话，令牌不匹配（如代理以新的进程重连）时结束该会话并返回nil
+func (m *Manager) takeSession(client *ClientConn, token string) *detachedSession {
+	// 新连接替换了仍在线的旧连接时，旧连接退出后才会保留会话
+	if previous := client.previous; previous != nil {
+		select {
+		case <-previous.detached:
+		case <-time.After(resumeWait):
+		}
+	}
+
+	m.sessionsMu.Lock()
+	defer m.sessionsMu.Unlock()
+	session := m.sessions[client.clientID]
+	if session == nil {
+		return nil
+	}
+	if token == "" || token != session.token {
+		wsLog.Infof("Client %s registered without resuming its previous session", client.clientID)
+		m.endSessionLocked(session)
+		return nil
+	}
+	delete(m.sessions, client.clientID)
+	session.timer.Stop()
+	return session
+}
+
+// resumeSession 把保留的会话转到新连接：等待中的请求改为等待新连接，重新发送旧连接尚未发出的消息
+// 在注册确认和该连接的加密、压缩设置生效之后调用
+func (m *Manager) resumeSession(client *ClientConn, session *detachedSession) {
+	previous := session.client
+
+	m.mu.Lock()
+	reattached := 0
+	for _, pending := range m.pending {
+		if pending.conn == previous {
+			pending.conn = client
+			reattached++
+		}
+	}
+	m.mu.Unlock()
+
+	replayed := m.replayUnsent(previous, client)
+	wsLog.Infof("Client %s resumed its session: %d pending requests reattached, %d queued messages resent",
+		client.clientID, reattached, replayed)
+
+	session.next = client
+	close(session.done)
+}
+
+// replayUnsent 把旧连接写入失败、未发完的消息和发送队列中剩余的消息按顺序改由新连接发送，返回发送的消息数
+// 只重新发送请求、请求体分片和取消，连接相关的控制消息在注册确认中重新下发
+func (m *Manager) replayUnsent(previous, client *ClientConn) int {
+	previous.mu.Lock()
+	queued := previous.unsent
+	previous.unsent = nil
+	previous.mu.Unlock()
+	for drained := false; !drained; {
+		select {
+		case message := <-previous.sendQueue:
+			queued = append(queued, message)
+		default:
+			drained = true
+		}
+	}
+
+	replayed := 0
+	for _, message := range queued {
+		msg, err := decodeSent(previous, message.data)
+		if err != nil {
+			wsLog.Warnf("Dropping unsent message of client %s: %v", client.clientID, err)
+			continue
+		}
+		switch msg.Op {
+		case protocol.OpRequest, protocol.OpRequestChunk, protocol.OpCancel:
+		default:
+			continue
+		}
+		encoded, err := m.encodeMessage(client, msg)
+		if err != nil {
+			wsLog.Warnf("Dropping unsent %s message of client %s: %v", msg.Op, client.clientID, err)
+			continue
+		}
+		select {
+		case client.sendQueue <- newQueuedMessage(msg, encoded):
+			replayed++
+		case <-client.ctx.Done():
+			return replayed
+		}
+	}
+	return replayed
+}
+
+// decodeSent 还原为旧连接编码的消息，去掉该连接的加密、压缩和签名，以便按新连接的设置重新编码
+func decodeSent(client *ClientConn, data []byte) (*protocol.Message, error) {
+	var msg protocol.Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, err
+	}
+	if err := msg.Open(client.getSessionCipher()); err != nil {
+		return nil, err
+	}
+	if err := msg.Decompress(); err != nil {
+		return nil, err
+	}
+	msg.Nonce, msg.SignedAt, msg.Signature = "", 0, ""
+	return &msg, nil
+}
+
+// awaitResume 连接断开后等待会话恢复，返回恢复会话的新连接；会话没有保留、没有恢复或 ctx 取消时返回nil
+func (m *Manager) awaitResume(ctx context.Context, client *ClientConn) *ClientConn {
+	select {
+	case <-client.detached:
+	case <-ctx.Done():
+		return nil
+	}
+	session := client.session
+	if session == nil {
+		return nil
+	}
+	select {
+	case <-session.done:
+		return session.next
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// failPending 让等待该连接响应的请求以 ErrClientDisconnected 立即结束
+func (m *Manager) failPending(client *ClientConn) {
+	m.mu.Lock()
+	failed := 0
+	for _, pending := range m.pending {
+		if pending.conn == client && pending.err == nil {
+			pending.err = ErrClientDisconnected
+			pending.cancel()
+			failed++
+		}
+	}
+	m.mu.Unlock()
+	if failed > 0 {
+		wsLog.Warnf("Failed %d pending requests of disconnected client %s", failed, client.clientID)
+	}
+}
@@ -0,0 +1,42 @@
+package websocket
+
+import (
+	"time"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// enableSigning 代理注册时上报支持签名且注册消息带有效签名后，之后收发的消息都签名
+func (c *ClientConn) enableSigning() {
+	c.mu.Lock()
+	c.signing = true
+	c.mu.Unlock()
+}
+
+// signingEnabled 连接是否要求消息签名
+func (c *ClientConn) signingEnabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.signing
+}
+
+// ClientSigned 客户端当前的连接是否签名收发的消息
+func (m *Manager) ClientSigned(clientID string) bool {
+	client := m.getClient(clientID)
+	return client != nil && client.signingEnabled()
+}
+
+// verifyMessage 校验代理发来的消息的签名、签名时间和随机数
+// 启用签名之前不带签名的消息照常处理，兼容不支持签名的旧代理
+func (m *Manager) verifyMessage(client *ClientConn, msg *protocol.Message) error {
+	if msg.Signature == "" {
+		if client.signingEnabled() {
+			return protocol.ErrMissingSignature
+		}
+		return nil
+	}
+	if err := msg.VerifySignature(client.authToken); err != nil {
+		return err
+	}
+	return client.nonces.Check(msg.Nonce, msg.SignedAt, time.Now())
+}
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// requestChunkSize 流式请求体每个分片的大小
+const requestChunkSize = 64 * 1024
+
+// SendStreamingRequestAndWait 发送请求并把请求体分片流式发送给客户端，然后等待响应
+// 请求体不保存到待处理消息中；timeout 从请求体发送完成后开始计算
+func (m *Manager) SendStreamingRequestAndWait(parent context.Context, clientID string, requestPayload *protocol.RequestPayload, body io.Reader, timeout time.Duration) (*protocol.ResponsePayload, error) {
+	requestPayload.Body = ""
+	requestPayload.BodyStreamed = true
+	return m.sendRequestAndWait(parent, clientID, requestPayload, body, timeout)
+}
+
+// streamRequestBody 把请求体按分片发送给客户端，发送队列满时等待而不是丢弃
+// compression 为路由覆盖的载荷压缩设置，为nil时使用连接的默认设置
+func (m *Manager) streamRequestBody(ctx context.Context, client *ClientConn, msgID string, body io.Reader, compression *protocol.CompressionSettings) error {
+	buf := make([]byte, requestChunkSize)
+	for seq := 0; ; seq++ {
+		n, readErr := io.ReadFull(body, buf)
+		chunk := &protocol.RequestChunkPayload{Seq: seq}
+		switch readErr {
+		case nil:
+			chunk.Data = buf[:n]
+		case io.EOF, io.ErrUnexpectedEOF:
+			chunk.Data = buf[:n]
+			chunk.EOF = true
+		default:
+			// 通知客户端放弃该请求，不发送不完整的请求体
+			chunk.Error = readErr.Error()
+		}
+
+		chunkMsg, err := protocol.NewMessage(protocol.MessageTypeMessage, protocol.OpRequestChunk, client.clientID, &msgID, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to create request chunk: %w", err)
+		}
+		chunkMsg.UseCompression(compression)
+		if client, err = m.sendToClientWait(ctx, client, chunkMsg); err != nil {
+			return err
+		}
+		if chunk.Error != "" {
+			return fmt.Errorf("failed to read request body: %w", readErr)
+		}
+		if chunk.EOF {
+			return nil
+		}
+	}
+}
+
+// sendToClientWait 通过该连接发送消息，发送队列满时等待直到有空位、ctx 取消或连接断开
+// 连接断开后代理恢复了会话时改由新连接发送，返回之后应使用的连接
+func (m *Manager) sendToClientWait(ctx context.Context, client *ClientConn, msg *protocol.Message) (*ClientConn, error) {
+	for {
+		// 已断开的连接不再放入消息，恢复会话时已经取走的发送队列不会再被读取
+		select {
+		case <-client.ctx.Done():
+			next := m.awaitResume(ctx, client)
+			if next == nil {
+				return nil, fmt.Errorf("client %s disconnected", client.clientID)
+			}
+			client = next
+			continue
+		default:
+		}
+
+		data, err := m.encodeMessage(client, msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message: %v", err)
+		}
+
+		select {
+		case client.sendQueue <- newQueuedMessage(msg, data):
+			client.mu.Lock()
+			client.messageCount++
+			client.mu.Unlock()
+			return client, nil
+		case <-client.ctx.Done():
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
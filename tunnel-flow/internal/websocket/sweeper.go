@@ -0,0 +1,178 @@
+package websocket
+
+import (
+	"fmt"
+	"time"
+
+	"tunnel-flow/internal/database"
+	"tunnel-flow/internal/protocol"
+)
+
+// 待处理消息清理：等待响应的请求在服务端重启、数据库更新失败等情况下会一直停留在 pending 或 processing，
+// 定期检查超过 next_try_ts 的消息，投递策略为 at_least_once 的重新投递，其余标记为失败，
+// 重新投递次数用完的移入死信队列，修复后端后可以通过 /dlq 接口重新执行
+
+const (
+	// sweepBatchSize 每次检查的消息数上限，其余留到下次检查
+	sweepBatchSize = 100
+	// maxDeliveryRetries at_least_once 消息最多重新投递的次数
+	maxDeliveryRetries = 3
+	// retryBackoff 第一次重新投递前额外等待的时间，之后每次加倍
+	retryBackoff = 30 * time.Second
+	// sweepGrace 超过期限后再等待的时间，留给批处理器写入请求的最终状态
+	sweepGrace = 10 * time.Second
+)
+
+// sweepStalePending 处理超过期限仍没有结果的消息，仍有请求在等待响应的消息不处理
+func (m *Manager) sweepStalePending() {
+	messages, err := m.db.ListStalePendingMessages(time.Now().Add(-sweepGrace).UnixMilli(), sweepBatchSize)
+	if err != nil {
+		wsLog.Errorf("Failed to list stale pending messages: %v", err)
+		return
+	}
+
+	retried, expired := 0, 0
+	for _, msg := range messages {
+		m.mu.RLock()
+		_, waiting := m.pending[msg.MsgID]
+		m.mu.RUnlock()
+		if waiting {
+			continue
+		}
+
+		meta, err := msg.GetRequestMeta()
+		if err != nil {
+			m.expirePending(msg, fmt.Sprintf("invalid request meta: %v", err))
+			expired++
+			continue
+		}
+		switch {
+		case meta.DeliveryPolicy != database.DeliveryPolicyAtLeastOnce:
+			m.expirePending(msg, "no response before deadline")
+			expired++
+		case meta.BodyStreamed:
+			m.expirePending(msg, "no response before deadline, streamed request body cannot be redelivered")
+			expired++
+		case msg.RetryCount >= maxDeliveryRetries:
+			m.deadLetterPending(msg)
+			expired++
+		default:
+			if m.redeliverPending(msg, meta) {
+				retried++
+			}
+		}
+	}
+	if retried > 0 || expired > 0 {
+		wsLog.Infof("Swept stale pending messages: %d redelivered, %d marked failed", retried, expired)
+	}
+}
+
+// expirePending 把消息标记为失败并计数，消息已经有结果时不处理
+func (m *Manager) expirePending(msg *database.PendingMessage, reason string) {
+	updated, err := m.db.ExpirePendingMessage(msg.MsgID, reason)
+	if err != nil {
+		wsLog.Errorf("Failed to mark stale message %s as failed: %v", msg.MsgID, err)
+		return
+	}
+	if !updated {
+		return
+	}
+	wsLog.Warnf("Stale message %s for client %s marked failed: %s", msg.MsgID, msg.ClientID, reason)
+	if m.metrics != nil {
+		if collector, ok := m.metrics.(interface{ IncrementExpiredMessages() }); ok {
+			collector.IncrementExpiredMessages()
+		}
+	}
+}
+
+// deadLetterPending 把重新投递次数用完的消息标记为失败并移入死信队列
+func (m *Manager) deadLetterPending(msg *database.PendingMessage) {
+	reason := fmt.Sprintf("no response after %d redeliveries", msg.RetryCount)
+	if msg.LastError != "" {
+		reason += ": " + msg.LastError
+	}
+	moved, err := m.db.DeadLetterPendingMessage(msg.MsgID, reason)
+	if err != nil {
+		wsLog.Errorf("Failed to move message %s to dead letter queue: %v", msg.MsgID, err)
+		return
+	}
+	if !moved {
+		return
+	}
+	wsLog.Warnf("Message %s for client %s moved to dead letter queue: %s", msg.MsgID, msg.ClientID, reason)
+	if m.metrics != nil {
+		if collector, ok := m.metrics.(interface{ IncrementDeadLetters() }); ok {
+			collector.IncrementDeadLetters()
+		}
+	}
+}
+
+// redeliverPending 用保存的请求元数据重新发送请求，响应只更新待处理消息
+// 客户端不在线或发送失败时同样计为一次投递，超过重试次数后标记为失败
+func (m *Manager) redeliverPending(msg *database.PendingMessage, meta *database.RequestMeta) bool {
+	timeout := m.config.RequestTimeout()
+	if meta.TimeoutMS > 0 {
+		timeout = time.Duration(meta.TimeoutMS) * time.Millisecond
+	}
+	nextTry := time.Now().Add(timeout + retryBackoff<<msg.RetryCount)
+
+	sendErr := m.sendStoredRequest(msg, meta)
+	state, lastError := database.MessageStateProcessing, ""
+	if sendErr != nil {
+		state, lastError = database.MessageStatePending, sendErr.Error()
+	}
+	// 响应可能在发送后立即到达，消息已有结果时不再更新
+	updated, err := m.db.RetryPendingMessage(msg.MsgID, state, nextTry.UnixMilli(), lastError)
+	if err != nil {
+		wsLog.Errorf("Failed to reschedule stale message %s: %v", msg.MsgID, err)
+		return false
+	}
+	if !updated {
+		return false
+	}
+	if m.metrics != nil {
+		if collector, ok := m.metrics.(interface{ IncrementRetries() }); ok {
+			collector.IncrementRetries()
+		}
+	}
+	if sendErr != nil {
+		wsLog.Warnf("Failed to redeliver stale message %s (attempt %d): %v", msg.MsgID, msg.RetryCount+1, sendErr)
+	} else {
+		wsLog.Infof("Redelivered stale message %s to client %s (attempt %d)", msg.MsgID, msg.ClientID, msg.RetryCount+1)
+	}
+	return true
+}
+
+// sendStoredRequest 按保存的请求元数据以原消息ID重新发送请求
+func (m *Manager) sendStoredRequest(msg *database.PendingMessage, meta *database.RequestMeta) error {
+	if m.getClient(msg.ClientID) == nil {
+		return fmt.Errorf("client %s is not connected", msg.ClientID)
+	}
+	msgID := msg.MsgID
+	requestMsg, err := protocol.NewMessage(
+		protocol.MessageTypeMessage,
+		protocol.OpRequest,
+		msg.ClientID,
+		&msgID,
+		StoredRequestPayload(msg.URLSuffix, meta),
+	)
+	if err != nil {
+		return err
+	}
+	return m.SendToClient(msg.ClientID, requestMsg)
+}
+
+// StoredRequestPayload 按保存的请求元数据还原请求载荷，用于重新投递和重新执行死信
+func StoredRequestPayload(urlSuffix string, meta *database.RequestMeta) *protocol.RequestPayload {
+	return &protocol.RequestPayload{
+		URLSuffix:      urlSuffix,
+		HTTPMethod:     meta.HTTPMethod,
+		Headers:        meta.Headers,
+		Params:         meta.Params,
+		Body:           meta.Body,
+		TimeoutMS:      meta.TimeoutMS,
+		TargetsJSON:    meta.TargetsJSON,
+		DeliveryPolicy: meta.DeliveryPolicy,
+		RouteMode:      meta.RouteMode,
+	}
+}
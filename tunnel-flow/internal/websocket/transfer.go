@@ -0,0 +1,240 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"tunnel-flow/internal/protocol"
+)
+
+// 可续传的响应体传输
+// 代理把大响应体按偏移量分片发送，服务端逐片确认已接收的偏移量；代理连接中断后重新注册时，
+// 服务端通知代理从已接收的偏移量继续发送，不需要从头重传。
+// 消息由工作池并发处理，分片可能乱序到达，服务端按偏移量重组后按顺序交付
+
+// transferResumeTimeout 代理断开后等待其重连续传的最长时间
+const transferResumeTimeout = 60 * time.Second
+
+// transferMaxBuffered 每个传输最多缓存的乱序分片数，超出的分片丢弃，续传时由代理重发
+const transferMaxBuffered = 64
+
+// ErrTransferStalled 代理断开后没有在等待时间内重连续传
+var ErrTransferStalled = errors.New("response transfer stalled")
+
+// Transfer 接收中的可续传响应体
+type Transfer struct {
+	id       string
+	clientID string
+	manager  *Manager
+	expire   *time.Timer // 没有调用方接收时自动放弃
+
+	mu       sync.Mutex
+	offset   int64                                   // 已交付给调用方的字节数
+	chunks   map[int64]protocol.ResponseChunkPayload // 尚未交付的分片，按偏移量索引
+	notify   chan struct{}
+	accepted bool
+	trailers map[string]string // 最后一个分片携带的trailer
+}
+
+// openTransfer 登记代理开始发送的可续传响应体，调用方需在 transferResumeTimeout 内通过 AcceptTransfer 接收
+func (m *Manager) openTransfer(clientID, transferID string) {
+	t := &Transfer{
+		id:       transferID,
+		clientID: clientID,
+		manager:  m,
+		chunks:   make(map[int64]protocol.ResponseChunkPayload),
+		notify:   make(chan struct{}, 1),
+	}
+	t.expire = time.AfterFunc(transferResumeTimeout, func() {
+		t.mu.Lock()
+		accepted := t.accepted
+		t.mu.Unlock()
+		if !accepted {
+			wsLog.Infof("Response transfer %s from client %s was never accepted, aborting", transferID, clientID)
+			m.CloseTransfer(transferID, false)
+		}
+	})
+
+	m.transfersMu.Lock()
+	m.transfers[transferID] = t
+	m.transfersMu.Unlock()
+}
+
+// AcceptTransfer 开始接收可续传响应体并通知代理发送，传输不存在时返回nil
+func (m *Manager) AcceptTransfer(transferID string) *Transfer {
+	m.transfersMu.Lock()
+	t := m.transfers[transferID]
+	m.transfersMu.Unlock()
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	t.accepted = true
+	t.mu.Unlock()
+	t.expire.Stop()
+	m.sendTransferAck(t.clientID, &protocol.TransferAckPayload{TransferID: transferID})
+	return t
+}
+
+// CloseTransfer 结束传输，completed 为false时通知代理放弃发送
+func (m *Manager) CloseTransfer(transferID string, completed bool) {
+	m.transfersMu.Lock()
+	t := m.transfers[transferID]
+	delete(m.transfers, transferID)
+	m.transfersMu.Unlock()
+	if t == nil {
+		return
+	}
+
+	t.expire.Stop()
+	if !completed {
+		m.sendTransferAck(t.clientID, &protocol.TransferAckPayload{TransferID: transferID, Abort: true})
+	}
+}
+
+// handleResponseChunk 接收响应体分片
+func (m *Manager) handleResponseChunk(client *ClientConn, msg *protocol.Message) {
+	var chunk protocol.ResponseChunkPayload
+	if err := msg.ParsePayload(&chunk); err != nil {
+		wsLog.Errorf("Failed to parse response chunk from client %s: %v", client.clientID, err)
+		return
+	}
+
+	m.transfersMu.Lock()
+	t := m.transfers[chunk.TransferID]
+	m.transfersMu.Unlock()
+	if t == nil || t.clientID != client.clientID {
+		// 传输已经结束，通知代理停止发送
+		m.sendTransferAck(client.clientID, &protocol.TransferAckPayload{TransferID: chunk.TransferID, Abort: true})
+		return
+	}
+
+	t.mu.Lock()
+	switch {
+	case chunk.Offset < t.offset:
+		// 续传时重复发送的分片
+	case len(t.chunks) >= transferMaxBuffered:
+		wsLog.Warnf("Too many buffered chunks for response transfer %s, dropping chunk at offset %d", t.id, chunk.Offset)
+	default:
+		t.chunks[chunk.Offset] = chunk
+	}
+	t.mu.Unlock()
+
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
+
+// resumeTransfers 代理重新注册后，通知其从已接收的偏移量继续发送未完成的响应体
+func (m *Manager) resumeTransfers(clientID string) {
+	m.transfersMu.Lock()
+	transfers := make([]*Transfer, 0)
+	for _, t := range m.transfers {
+		if t.clientID == clientID {
+			transfers = append(transfers, t)
+		}
+	}
+	m.transfersMu.Unlock()
+
+	for _, t := range transfers {
+		t.mu.Lock()
+		offset := t.offset
+		t.mu.Unlock()
+		wsLog.Infof("Resuming response transfer %s from client %s at offset %d", t.id, clientID, offset)
+		m.sendTransferAck(clientID, &protocol.TransferAckPayload{TransferID: t.id, Offset: offset, Resume: true})
+	}
+}
+
+// sendTransferAck 发送传输控制消息，失败只记录日志，代理重连后会收到续传通知
+func (m *Manager) sendTransferAck(clientID string, ack *protocol.TransferAckPayload) {
+	msg, err := protocol.NewMessage(protocol.MessageTypeMessage, protocol.OpTransferAck, clientID, nil, ack)
+	if err != nil {
+		wsLog.Errorf("Failed to create transfer ack: %v", err)
+		return
+	}
+	if err := m.SendToClient(clientID, msg); err != nil {
+		wsLog.Errorf("Failed to send transfer ack for %s to client %s: %v", ack.TransferID, clientID, err)
+	}
+}
+
+// Next 按顺序返回下一段响应体数据，eof 表示响应体已结束
+// 代理保持连接时一直等待，流式响应可以长时间没有数据；代理断开超过 transferResumeTimeout 返回 ErrTransferStalled
+func (t *Transfer) Next(ctx context.Context) (data []byte, eof bool, err error) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var disconnectedAt time.Time
+
+	for {
+		t.mu.Lock()
+		chunk, ok := t.chunks[t.offset]
+		if ok {
+			delete(t.chunks, t.offset)
+			t.offset += int64(len(chunk.Data))
+		}
+		t.mu.Unlock()
+
+		if ok {
+			if chunk.Error != "" {
+				return nil, false, fmt.Errorf("client failed to read response body: %s", chunk.Error)
+			}
+			if chunk.EOF {
+				t.mu.Lock()
+				t.trailers = chunk.Trailers
+				t.mu.Unlock()
+			}
+			return chunk.Data, chunk.EOF, nil
+		}
+
+		select {
+		case <-t.notify:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		case now := <-ticker.C:
+			if t.manager.IsClientConnected(t.clientID) {
+				disconnectedAt = time.Time{}
+			} else if disconnectedAt.IsZero() {
+				disconnectedAt = now
+			} else if now.Sub(disconnectedAt) > transferResumeTimeout {
+				return nil, false, ErrTransferStalled
+			}
+		}
+	}
+}
+
+// Trailers 后端响应的trailer，Next 返回 eof 之后有效
+func (t *Transfer) Trailers() map[string]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.trailers
+}
+
+// Ack 确认已接收的数据，代理据此释放缓存并继续发送
+func (t *Transfer) Ack() {
+	t.mu.Lock()
+	offset := t.offset
+	t.mu.Unlock()
+	t.manager.sendTransferAck(t.clientID, &protocol.TransferAckPayload{TransferID: t.id, Offset: offset})
+}
+
+// ClientHasCapability 检查已连接的客户端注册时是否上报了指定能力
+func (m *Manager) ClientHasCapability(clientID, capability string) bool {
+	client := m.getClient(clientID)
+	return client != nil && client.hasCapability(capability)
+}
+
+// hasCapability 代理注册时是否上报了指定能力
+func (c *ClientConn) hasCapability(capability string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range c.capabilities {
+		if name == capability {
+			return true
+		}
+	}
+	return false
+}
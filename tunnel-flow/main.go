@@ -15,6 +15,8 @@ import (
 	"tunnel-flow/internal/monitoring"
 	"tunnel-flow/internal/performance"
 	"tunnel-flow/internal/server"
+	"tunnel-flow/internal/systemd"
+	"tunnel-flow/internal/version"
 )
 
 func main() {
@@ -31,8 +33,10 @@ func main() {
 	if err := logging.InitDefaultLogger(logConfig); err != nil {
 		log.Fatalf("Failed to initialize logger: %v", err)
 	}
+	// 日志同时发布到实时日志流，供 /api/v1/logs/stream 查看
+	logging.CaptureStandardLog()
 
-	logging.Info("Starting tunnel-flow server...")
+	logging.Infof("Starting tunnel-flow server %s (commit %s, built %s)...", version.Version, version.GitCommit, version.BuildDate)
 
 	// 加载配置
 	cfg, err := config.Load()
@@ -41,8 +45,35 @@ func main() {
 	}
 	logging.Info("Configuration loaded successfully")
 
+	// 日志中的敏感请求头和字段脱敏
+	logging.SetRedaction(cfg.LogRedactHeaders, cfg.LogRedactBodyFields)
+
+	// 日志输出到 syslog 和 Loki
+	if cfg.LogSyslogEnabled {
+		if err := logging.AddSyslogSink(logging.SyslogConfig{
+			Network:    cfg.LogSyslogNetwork,
+			Address:    cfg.LogSyslogAddress,
+			Tag:        cfg.LogSyslogTag,
+			BufferSize: cfg.LogSinkBufferSize,
+		}); err != nil {
+			log.Fatalf("Failed to start syslog output: %v", err)
+		}
+	}
+	if cfg.LogLokiURL != "" {
+		if err := logging.AddLokiSink(logging.LokiConfig{
+			URL:           cfg.LogLokiURL,
+			Labels:        cfg.LogLokiLabels,
+			TenantID:      cfg.LogLokiTenantID,
+			BatchSize:     cfg.LogLokiBatchSize,
+			FlushInterval: cfg.LokiFlushInterval(),
+			BufferSize:    cfg.LogSinkBufferSize,
+		}); err != nil {
+			log.Fatalf("Failed to start Loki output: %v", err)
+		}
+	}
+
 	// 初始化数据库
-	db, err := database.New(cfg.DatabasePath)
+	db, err := database.NewWithKey(cfg.DatabasePath, cfg.DatabaseEncryptionKey)
 	if err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
@@ -50,11 +81,23 @@ func main() {
 
 	// 创建Repository
 	repo := database.NewRepository(db)
-	logging.Info("Database initialized successfully")
+	if db.Encrypted() {
+		logging.Info("Database initialized successfully with encrypted secrets")
+	} else {
+		logging.Info("Database initialized successfully")
+	}
 
 	// 创建性能组件
 	objectPool := performance.NewObjectPool()
-	workerPool := performance.NewWorkerPool(cfg.WorkerPoolSize, cfg.WorkerQueueSize)
+	workerPool := performance.NewWorkerPoolWithConfig(&performance.WorkerPoolConfig{
+		MinWorkers:       cfg.WorkerPoolSize,
+		MaxWorkers:       cfg.WorkerPoolMax,
+		QueueSize:        cfg.WorkerQueueSize,
+		LatencyThreshold: cfg.WorkerLatencyThreshold(),
+	})
+	workerPool.OnScale = func(from, to int, reason string) {
+		logging.Infof("Worker pool scaled from %d to %d workers: %s", from, to, reason)
+	}
 	connectionPool := performance.NewConnectionPool(&performance.ConnectionPoolConfig{
 		MaxIdleConns:          cfg.MaxIdleConns,
 		MaxIdleConnsPerHost:   cfg.MaxIdleConns / 2,
@@ -75,11 +118,22 @@ func main() {
 
 	// 创建监控组件
 	metricsCollector := monitoring.NewMetricsCollector()
+	metricsCollector.TrackWorkerPool(workerPool)
 	healthChecker := monitoring.NewHealthChecker(5*time.Second, 30*time.Second)
 
 	// 注册健康检查
 	healthChecker.RegisterCheck(monitoring.NewDatabaseHealthCheck("database", func() error {
 		return db.Health()
+	}).WithMetrics(func() monitoring.DatabaseMetrics {
+		metrics := db.Metrics()
+		return monitoring.DatabaseMetrics{
+			Degraded:  metrics.Degraded,
+			ErrorRate: metrics.ErrorRate,
+			Latency:   time.Duration(metrics.LatencyMS * float64(time.Millisecond)),
+			Errors:    metrics.Errors,
+			LastError: metrics.LastError,
+			Reopens:   metrics.Reopens,
+		}
 	}))
 
 	// 启动定期监控
@@ -146,14 +200,50 @@ func main() {
 		}
 	}()
 
+	// 三个端口都绑定成功且数据库检查通过后通知 systemd 服务已就绪
+	go notifyReady(ctx, multiServer, db)
+
 	// 等待中断信号
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	// 启用了 systemd 看门狗时，主循环按超时的一半喂看门狗，进程卡死时由 systemd 重启
+	var watchdog <-chan time.Time
+	if interval := systemd.WatchdogInterval(); interval > 0 {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		watchdog = ticker.C
+		logging.Infof("systemd watchdog enabled, notifying every %v", interval/2)
+	}
+
 	logging.Info("Server started successfully. Press Ctrl+C to stop.")
-	<-sigChan
+	for running := true; running; {
+		select {
+		case <-sigChan:
+			running = false
+		case <-watchdog:
+			if err := multiServer.CheckLiveness(); err != nil {
+				logging.Warnf("Liveness check failed: %v", err)
+			}
+			if _, err := systemd.Notify(systemd.Watchdog); err != nil {
+				logging.Warnf("Failed to notify systemd watchdog: %v", err)
+			}
+		}
+	}
 
 	logging.Info("Shutting down server...")
+	systemd.Notify(systemd.Stopping)
+
+	// 先让 /ready 返回503，等负载均衡摘除本实例后再停止接受连接，期间再次收到信号时立即停止
+	multiServer.SetDraining()
+	if delay := cfg.ShutdownDelay(); delay > 0 {
+		logging.Infof("Waiting %v before closing listeners", delay)
+		select {
+		case <-time.After(delay):
+		case <-sigChan:
+			logging.Info("Received second signal, closing listeners now")
+		}
+	}
 
 	// 优雅关闭
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -191,4 +281,38 @@ func main() {
 	connectionPool.Close()
 
 	logging.Info("Tunnel-flow server stopped")
+
+	// 发送剩余的日志
+	logging.CloseSinks(5 * time.Second)
+}
+
+// notifyReady 等待所有端口绑定成功，数据库检查通过后通知 systemd，检查失败时每秒重试
+func notifyReady(ctx context.Context, multiServer *server.MultiServer, db *database.DB) {
+	select {
+	case <-multiServer.Ready():
+	case <-ctx.Done():
+		return
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		err := db.Health()
+		if err == nil {
+			break
+		}
+		logging.Warnf("Database health check failed, delaying readiness: %v", err)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	sent, err := systemd.Notify(systemd.Ready)
+	if err != nil {
+		logging.Warnf("Failed to notify systemd readiness: %v", err)
+	} else if sent {
+		logging.Info("Notified systemd that the server is ready")
+	}
 }